@@ -0,0 +1,605 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Scripted outcomes
+const (
+	OutcomeApprove       = "approve"
+	OutcomeDecline       = "decline"
+	OutcomeTimeout       = "timeout"
+	OutcomePartialRefund = "partial_refund"
+	OutcomeError         = "error"
+)
+
+// Payment statuses
+const (
+	StatusAuthorized        = "authorized"
+	StatusDeclined          = "declined"
+	StatusCaptured          = "captured"
+	StatusRefunded          = "refunded"
+	StatusVoided            = "voided"
+	StatusPartiallyRefunded = "partially_refunded"
+)
+
+// Payment represents a payment as returned by the payment service API.
+// Amounts are integer minor units of the currency, such as cents.
+type Payment struct {
+	PaymentID      string    `json:"payment_id"`
+	OrderID        string    `json:"order_id"`
+	Amount         int64     `json:"amount"`
+	Currency       string    `json:"currency"`
+	Status         string    `json:"status"`
+	RefundedAmount int64     `json:"refunded_amount"`
+	DeclineReason  string    `json:"decline_reason,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// AuthorizeRequest represents the request payload for authorizing a payment
+type AuthorizeRequest struct {
+	OrderID  string `json:"order_id" binding:"required"`
+	Amount   int64  `json:"amount" binding:"required"`
+	Currency string `json:"currency"`
+}
+
+// RefundRequest represents the request payload for refunding a payment
+type RefundRequest struct {
+	Amount   int64  `json:"amount" binding:"required"`
+	Currency string `json:"currency"`
+}
+
+// Scenario scripts the outcome for matching payment calls. An empty OrderID
+// matches every order; Remaining limits how many calls it applies to (0 = unlimited).
+type Scenario struct {
+	ID          string  `json:"id"`
+	OrderID     string  `json:"order_id"`
+	Operation   string  `json:"operation"`
+	Outcome     string  `json:"outcome" binding:"required"`
+	DelayMs     int     `json:"delay_ms"`
+	RefundRatio float64 `json:"refund_ratio"`
+	Remaining   int     `json:"remaining"`
+}
+
+// Event represents a payment event, mirroring what the payment service publishes
+type Event struct {
+	EventID   string    `json:"event_id"`
+	Type      string    `json:"type"`
+	PaymentID string    `json:"payment_id"`
+	OrderID   string    `json:"order_id"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var store = struct {
+	sync.Mutex
+	payments map[string]*Payment
+	// authorizations maps Idempotency-Keys to the payment they authorized
+	authorizations map[string]authorization
+	scenarios      []*Scenario
+	events         []Event
+}{payments: map[string]*Payment{}, authorizations: map[string]authorization{}}
+
+// authorization is the outcome of an authorize call, replayed for retries
+// with the same Idempotency-Key
+type authorization struct {
+	paymentID string
+	status    int
+}
+
+var (
+	defaultOutcome = OutcomeApprove
+	timeoutDelay   = 30 * time.Second
+	eventsURL      string
+	eventsClient   = &http.Client{Timeout: 5 * time.Second}
+)
+
+func main() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
+
+	if outcome := os.Getenv("FAKE_PAYMENT_DEFAULT_OUTCOME"); outcome != "" {
+		defaultOutcome = outcome
+	}
+	if delay := os.Getenv("FAKE_PAYMENT_TIMEOUT_DELAY"); delay != "" {
+		if d, err := time.ParseDuration(delay); err == nil {
+			timeoutDelay = d
+		}
+	}
+	eventsURL = os.Getenv("PAYMENT_EVENTS_URL")
+
+	if os.Getenv("GIN_MODE") == "release" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "healthy",
+			"service":   "fake-payment",
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
+	})
+
+	// Payment API, wire-compatible with the real payment service
+	api := r.Group("/api/payments")
+	{
+		api.POST("/authorize", authorizePayment)
+		api.GET("", listPayments)
+		api.POST("/:id/capture", capturePayment)
+		api.POST("/:id/void", voidPayment)
+		api.POST("/:id/refund", refundPayment)
+		api.GET("/:id", getPayment)
+	}
+
+	// Scripting API for tests
+	fake := r.Group("/_fake")
+	{
+		fake.POST("/scenarios", addScenario)
+		fake.GET("/scenarios", listScenarios)
+		fake.GET("/events", listEvents)
+		fake.POST("/reset", reset)
+	}
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3004"
+	}
+
+	log.Info().Str("port", port).Str("default_outcome", defaultOutcome).Msg("Fake payment service starting")
+	if err := r.Run(":" + port); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start server")
+	}
+}
+
+// resolveOutcome picks the outcome for a call: the X-Fake-Outcome header wins,
+// then the first matching scenario, then the configured default
+func resolveOutcome(c *gin.Context, operation, orderID string) Scenario {
+	if outcome := c.GetHeader("X-Fake-Outcome"); outcome != "" {
+		return Scenario{Outcome: outcome}
+	}
+
+	store.Lock()
+	defer store.Unlock()
+
+	for i, s := range store.scenarios {
+		if s.OrderID != "" && s.OrderID != orderID {
+			continue
+		}
+		if s.Operation != "" && s.Operation != operation {
+			continue
+		}
+		matched := *s
+		if s.Remaining > 0 {
+			s.Remaining--
+			if s.Remaining == 0 {
+				store.scenarios = append(store.scenarios[:i], store.scenarios[i+1:]...)
+			}
+		}
+		return matched
+	}
+
+	return Scenario{Outcome: defaultOutcome}
+}
+
+// applyDelay sleeps for the scripted delay, or for timeoutDelay on a timeout
+// outcome. It returns false if the client went away first.
+func applyDelay(c *gin.Context, s Scenario) bool {
+	delay := time.Duration(s.DelayMs) * time.Millisecond
+	if s.Outcome == OutcomeTimeout {
+		delay = timeoutDelay
+	}
+	if delay == 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(delay):
+		return true
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+func authorizePayment(c *gin.Context) {
+	var req AuthorizeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := c.GetHeader("Idempotency-Key")
+	if key != "" {
+		store.Lock()
+		previous, ok := store.authorizations[key]
+		var snapshot Payment
+		if ok {
+			snapshot = *store.payments[previous.paymentID]
+		}
+		store.Unlock()
+		if ok {
+			c.JSON(previous.status, snapshot)
+			return
+		}
+	}
+
+	scenario := resolveOutcome(c, "authorize", req.OrderID)
+	if !applyDelay(c, scenario) {
+		return
+	}
+
+	switch scenario.Outcome {
+	case OutcomeTimeout:
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider timed out"})
+		return
+	case OutcomeError:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider error"})
+		return
+	}
+
+	currency := req.Currency
+	if currency == "" {
+		currency = "USD"
+	}
+
+	now := time.Now().UTC()
+	payment := &Payment{
+		PaymentID: uuid.New().String(),
+		OrderID:   req.OrderID,
+		Amount:    req.Amount,
+		Currency:  currency,
+		Status:    StatusAuthorized,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	eventType := "payments.confirmed"
+	status := http.StatusCreated
+	if scenario.Outcome == OutcomeDecline {
+		payment.Status = StatusDeclined
+		payment.DeclineReason = "card_declined"
+		eventType = "payments.declined"
+		status = http.StatusPaymentRequired
+	}
+
+	store.Lock()
+	store.payments[payment.PaymentID] = payment
+	if key != "" {
+		store.authorizations[key] = authorization{paymentID: payment.PaymentID, status: status}
+	}
+	store.Unlock()
+
+	emitEvent(eventType, payment)
+
+	log.Info().
+		Str("payment_id", payment.PaymentID).
+		Str("order_id", payment.OrderID).
+		Str("status", payment.Status).
+		Msg("Payment authorization processed")
+
+	c.JSON(status, payment)
+}
+
+func capturePayment(c *gin.Context) {
+	payment, ok := findPayment(c)
+	if !ok {
+		return
+	}
+
+	scenario := resolveOutcome(c, "capture", payment.OrderID)
+	if !applyDelay(c, scenario) {
+		return
+	}
+	if scenario.Outcome == OutcomeTimeout {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider timed out"})
+		return
+	}
+	if scenario.Outcome == OutcomeError {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider error"})
+		return
+	}
+
+	store.Lock()
+	if payment.Status != StatusAuthorized {
+		store.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment is not in authorized state"})
+		return
+	}
+	payment.Status = StatusCaptured
+	payment.UpdatedAt = time.Now().UTC()
+	snapshot := *payment
+	store.Unlock()
+
+	emitEvent("payments.captured", &snapshot)
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// voidPayment cancels an authorization that was never captured. Voiding a
+// voided payment succeeds again, so callers can retry.
+func voidPayment(c *gin.Context) {
+	payment, ok := findPayment(c)
+	if !ok {
+		return
+	}
+
+	scenario := resolveOutcome(c, "void", payment.OrderID)
+	if !applyDelay(c, scenario) {
+		return
+	}
+	if scenario.Outcome == OutcomeTimeout {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider timed out"})
+		return
+	}
+	if scenario.Outcome == OutcomeError {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider error"})
+		return
+	}
+
+	store.Lock()
+	switch payment.Status {
+	case StatusVoided:
+		snapshot := *payment
+		store.Unlock()
+		c.JSON(http.StatusOK, snapshot)
+		return
+	case StatusAuthorized:
+	default:
+		store.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment is not in authorized state"})
+		return
+	}
+	payment.Status = StatusVoided
+	payment.UpdatedAt = time.Now().UTC()
+	snapshot := *payment
+	store.Unlock()
+
+	emitEvent("payments.voided", &snapshot)
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func refundPayment(c *gin.Context) {
+	payment, ok := findPayment(c)
+	if !ok {
+		return
+	}
+
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Currency != "" && req.Currency != payment.Currency {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Refund currency does not match the payment"})
+		return
+	}
+
+	scenario := resolveOutcome(c, "refund", payment.OrderID)
+	if !applyDelay(c, scenario) {
+		return
+	}
+
+	amount := req.Amount
+	switch scenario.Outcome {
+	case OutcomeTimeout:
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Payment provider timed out"})
+		return
+	case OutcomeError:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment provider error"})
+		return
+	case OutcomeDecline:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Refund declined"})
+		return
+	case OutcomePartialRefund:
+		ratio := scenario.RefundRatio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.5
+		}
+		amount = int64(math.Round(float64(req.Amount) * ratio))
+	}
+
+	store.Lock()
+	if payment.Status != StatusCaptured && payment.Status != StatusPartiallyRefunded {
+		store.Unlock()
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment has not been captured"})
+		return
+	}
+	if remaining := payment.Amount - payment.RefundedAmount; amount > remaining {
+		amount = remaining
+	}
+	payment.RefundedAmount += amount
+	payment.Status = StatusPartiallyRefunded
+	if payment.RefundedAmount >= payment.Amount {
+		payment.Status = StatusRefunded
+	}
+	payment.UpdatedAt = time.Now().UTC()
+	snapshot := *payment
+	store.Unlock()
+
+	emitEvent("payments.refunded", &snapshot)
+	c.JSON(http.StatusOK, gin.H{
+		"payment":          snapshot,
+		"refunded_amount":  amount,
+		"requested_amount": req.Amount,
+	})
+}
+
+func getPayment(c *gin.Context) {
+	payment, ok := findPayment(c)
+	if !ok {
+		return
+	}
+
+	store.Lock()
+	snapshot := *payment
+	store.Unlock()
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// listPayments returns the payments of the order given by ?order_id=,
+// oldest first
+func listPayments(c *gin.Context) {
+	orderID := c.Query("order_id")
+	if orderID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order_id is required"})
+		return
+	}
+
+	store.Lock()
+	payments := []Payment{}
+	for _, p := range store.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, *p)
+		}
+	}
+	store.Unlock()
+
+	sort.Slice(payments, func(i, j int) bool { return payments[i].CreatedAt.Before(payments[j].CreatedAt) })
+	c.JSON(http.StatusOK, payments)
+}
+
+func findPayment(c *gin.Context) (*Payment, bool) {
+	store.Lock()
+	payment, ok := store.payments[c.Param("id")]
+	store.Unlock()
+
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+	}
+	return payment, ok
+}
+
+func addScenario(c *gin.Context) {
+	var s Scenario
+	if err := c.ShouldBindJSON(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch s.Outcome {
+	case OutcomeApprove, OutcomeDecline, OutcomeTimeout, OutcomePartialRefund, OutcomeError:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid outcome"})
+		return
+	}
+
+	s.ID = uuid.New().String()
+	store.Lock()
+	store.scenarios = append(store.scenarios, &s)
+	store.Unlock()
+
+	c.JSON(http.StatusCreated, s)
+}
+
+func listScenarios(c *gin.Context) {
+	store.Lock()
+	scenarios := make([]Scenario, 0, len(store.scenarios))
+	for _, s := range store.scenarios {
+		scenarios = append(scenarios, *s)
+	}
+	store.Unlock()
+
+	c.JSON(http.StatusOK, scenarios)
+}
+
+func listEvents(c *gin.Context) {
+	orderID := c.Query("order_id")
+
+	store.Lock()
+	events := make([]Event, 0, len(store.events))
+	for _, e := range store.events {
+		if orderID == "" || e.OrderID == orderID {
+			events = append(events, e)
+		}
+	}
+	store.Unlock()
+
+	c.JSON(http.StatusOK, events)
+}
+
+func reset(c *gin.Context) {
+	store.Lock()
+	store.payments = map[string]*Payment{}
+	store.authorizations = map[string]authorization{}
+	store.scenarios = nil
+	store.events = nil
+	store.Unlock()
+
+	c.Status(http.StatusNoContent)
+}
+
+// emitEvent records the event for inspection and forwards it to
+// PAYMENT_EVENTS_URL when configured
+func emitEvent(eventType string, p *Payment) {
+	event := Event{
+		EventID:   uuid.New().String(),
+		Type:      eventType,
+		PaymentID: p.PaymentID,
+		OrderID:   p.OrderID,
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		Status:    p.Status,
+		Timestamp: time.Now().UTC(),
+	}
+
+	store.Lock()
+	store.events = append(store.events, event)
+	store.Unlock()
+
+	if eventsURL == "" {
+		return
+	}
+
+	go func() {
+		if err := deliverEvent(event); err != nil {
+			log.Error().Err(err).Str("event_type", event.Type).Msg("Failed to deliver payment event")
+		}
+	}()
+}
+
+func deliverEvent(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, eventsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := eventsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("events endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
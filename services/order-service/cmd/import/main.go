@@ -0,0 +1,268 @@
+// Command import loads historical orders from CSV or NDJSON exports of the
+// legacy order system. Legacy statuses and user IDs are mapped, every order
+// is validated, valid orders are written in batches and rejected rows are
+// listed in an error report. A checkpoint file makes interrupted imports
+// resumable, and re-importing the same export updates rather than
+// duplicates orders.
+//
+//	import -file orders.csv [-format csv|ndjson] [-users users.csv]
+//	       [-status-map ON_HOLD=pending] [-batch 500] [-dry-run]
+//
+// CSV exports have one row per line item with the columns order_id,
+// user_id, status, created_at, updated_at, currency, product_id, name, sku,
+// price and quantity; rows of the same order must be adjacent. NDJSON
+// exports have one order per line with the same fields and an items array.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"order-service/internal/app"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	file := flag.String("file", "", "CSV or NDJSON export to import (required)")
+	format := flag.String("format", "", "csv or ndjson (default: from the file extension)")
+	usersFile := flag.String("users", "", "CSV of legacy_user_id,user_id; user IDs are kept as is without it")
+	statusMap := flag.String("status-map", "", "extra legacy status mappings, e.g. ON_HOLD=pending,RETURNED=cancelled")
+	batchSize := flag.Int("batch", 500, "orders written per batch")
+	checkpointFile := flag.String("checkpoint", "", "checkpoint file (default: <file>.checkpoint)")
+	reportFile := flag.String("errors", "", "error report (default: <file>.errors.csv)")
+	dryRun := flag.Bool("dry-run", false, "validate and report without writing orders or the checkpoint")
+	flag.Parse()
+
+	godotenv.Load()
+	app.SetupLogger()
+
+	if *file == "" || *batchSize < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *checkpointFile == "" {
+		*checkpointFile = *file + ".checkpoint"
+	}
+	if *reportFile == "" {
+		*reportFile = *file + ".errors.csv"
+	}
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	app.SetLogLevel(cfg.LogLevel)
+	app.SetLogRedaction(cfg.Redaction)
+	if cfg.OrderStorage == "eventsourced" {
+		log.Fatal().Msg("Importing is only supported with ORDER_STORAGE=document")
+	}
+
+	m := mapper{statuses: map[string]string{}}
+	for legacy, status := range defaultStatuses {
+		m.statuses[legacy] = status
+	}
+	if err := parseStatusMap(*statusMap, m.statuses); err != nil {
+		log.Fatal().Err(err).Msg("Invalid -status-map")
+	}
+	if *usersFile != "" {
+		if m.users, err = loadUserMap(*usersFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to load user map")
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.UseOutboundTLS(cfg.TLS); err != nil {
+		log.Fatal().Err(err).Msg("Invalid outbound TLS configuration")
+	}
+	if _, err := app.LoadSecrets(ctx, &cfg, clock.System{}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load secrets")
+	}
+
+	client, err := app.ConnectMongo(ctx, cfg.MongoURI, cfg.Mongo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to MongoDB")
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(cfg.Database)
+	if _, err := app.UseFieldEncryption(ctx, cfg, db, clock.System{}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up field encryption")
+	}
+	imp := importer{
+		mapper: m,
+		writer: orderWriter{orders: db.Collection("orders"), events: db.Collection("events"), dryRun: *dryRun},
+		batch:  *batchSize,
+		dryRun: *dryRun,
+	}
+	if err := imp.run(ctx, *file, *format, *checkpointFile, *reportFile); err != nil {
+		log.Error().Err(err).Msg("Import failed; rerun the same command to resume")
+		client.Disconnect(context.Background())
+		os.Exit(1)
+	}
+}
+
+// importer drives one import run
+type importer struct {
+	mapper mapper
+	writer orderWriter
+	batch  int
+	dryRun bool
+}
+
+func (imp importer) run(ctx context.Context, path, format, checkpointPath, reportPath string) error {
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	cp, err := loadCheckpoint(checkpointPath, source)
+	if err != nil {
+		return err
+	}
+	if imp.dryRun {
+		cp = checkpoint{Source: source}
+	}
+	resume := cp.Line > 0
+	if resume {
+		log.Info().Int("line", cp.Line).Int("imported", cp.Imported).Int("rejected", cp.Rejected).Msg("Resuming import from checkpoint")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := newReader(f, path, format)
+	if err != nil {
+		return err
+	}
+
+	report, err := openErrorReport(reportPath, resume)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+
+	var (
+		orders     []contracts.Order
+		rejections []rejection
+		lastLine   = cp.Line
+	)
+	flush := func() error {
+		writeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if err := imp.writer.write(writeCtx, orders); err != nil {
+			return fmt.Errorf("write batch ending at line %d: %w", lastLine, err)
+		}
+		if err := report.write(rejections); err != nil {
+			return fmt.Errorf("write error report: %w", err)
+		}
+
+		cp.Line = lastLine
+		cp.Imported += len(orders)
+		cp.Rejected += countOrders(rejections)
+		cp.UpdatedAt = time.Now().UTC()
+		if !imp.dryRun {
+			if err := cp.save(checkpointPath); err != nil {
+				return fmt.Errorf("save checkpoint: %w", err)
+			}
+		}
+		log.Info().Int("line", cp.Line).Int("imported", cp.Imported).Int("rejected", cp.Rejected).Msg("Batch written")
+
+		orders, rejections = orders[:0], rejections[:0]
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		lo, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if rerr, ok := err.(*rowError); ok {
+			if rerr.Line > cp.Line {
+				rejections = append(rejections, rejection{Line: rerr.Line, Field: "row", Message: rerr.Err.Error()})
+				lastLine = rerr.Line
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if lo.Line <= cp.Line {
+			continue
+		}
+		lastLine = lo.Line
+
+		order, problems := imp.mapper.toOrder(lo)
+		for _, p := range problems {
+			rejections = append(rejections, rejection{Line: lo.Line, LegacyID: lo.ID, Field: p.Field, Message: p.Message})
+		}
+		if len(problems) == 0 {
+			orders = append(orders, order)
+		}
+
+		if len(orders)+len(rejections) >= imp.batch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	log.Info().
+		Int("imported", cp.Imported).
+		Int("rejected", cp.Rejected).
+		Bool("dry_run", imp.dryRun).
+		Str("error_report", reportPath).
+		Msg("Import finished")
+	return nil
+}
+
+// countOrders counts distinct rejected orders; one order can have several
+// problems
+func countOrders(rejections []rejection) int {
+	seen := map[int]bool{}
+	for _, r := range rejections {
+		seen[r.Line] = true
+	}
+	return len(seen)
+}
+
+func newReader(r io.Reader, path, format string) (orderReader, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ndjson", ".jsonl":
+			format = "ndjson"
+		default:
+			format = "csv"
+		}
+	}
+	switch format {
+	case "csv":
+		return newCSVReader(r)
+	case "ndjson":
+		return newNDJSONReader(r), nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q, expected csv or ndjson", format)
+	}
+}
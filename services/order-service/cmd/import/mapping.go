@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+
+	"github.com/google/uuid"
+)
+
+// importNamespace derives order IDs from legacy IDs, so importing the same
+// export twice updates orders instead of duplicating them
+var importNamespace = uuid.MustParse("6f1c1f52-6a55-4e55-9a4f-3f0f3c1b9d27")
+
+// defaultStatuses maps legacy statuses (case-insensitive) to order statuses
+var defaultStatuses = map[string]string{
+	"NEW":        "pending",
+	"OPEN":       "pending",
+	"PENDING":    "pending",
+	"PAID":       "confirmed",
+	"CONFIRMED":  "confirmed",
+	"PROCESSING": "confirmed",
+	"SHIPPED":    "shipped",
+	"IN_TRANSIT": "shipped",
+	"DELIVERED":  "delivered",
+	"COMPLETE":   "delivered",
+	"COMPLETED":  "delivered",
+	"CANCELLED":  "cancelled",
+	"CANCELED":   "cancelled",
+	"VOID":       "cancelled",
+	"REFUNDED":   "cancelled",
+}
+
+// legacyTimeLayouts are tried in order; times without a zone are UTC
+var legacyTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// mapper turns legacy orders into contracts.Order
+type mapper struct {
+	statuses map[string]string
+	// users maps legacy user IDs to user-service IDs; nil keeps them as is
+	users map[string]string
+}
+
+// parseStatusMap reads overrides such as "ON_HOLD=pending,RETURNED=cancelled"
+func parseStatusMap(spec string, statuses map[string]string) error {
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		legacy, status, ok := strings.Cut(pair, "=")
+		if !ok || !contracts.IsStatus(strings.TrimSpace(status)) {
+			return fmt.Errorf("invalid status mapping %q, expected LEGACY=pending|confirmed|shipped|delivered|cancelled", pair)
+		}
+		statuses[strings.ToUpper(strings.TrimSpace(legacy))] = strings.TrimSpace(status)
+	}
+	return nil
+}
+
+// loadUserMap reads a CSV of legacy_user_id,user_id with a header row
+func loadUserMap(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read user map: %w", err)
+	}
+	users := make(map[string]string, len(records))
+	for i, record := range records {
+		if i == 0 || len(record) < 2 {
+			continue
+		}
+		users[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+	return users, nil
+}
+
+// toOrder maps and validates a legacy order. Every problem is returned as a
+// field error so the report lists them all at once.
+func (m mapper) toOrder(lo legacyOrder) (contracts.Order, []contracts.FieldError) {
+	var problems []contracts.FieldError
+	fail := func(field, format string, args ...interface{}) {
+		problems = append(problems, contracts.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if lo.Broken != "" {
+		fail("order", "%s", lo.Broken)
+	}
+	if lo.ID == "" {
+		fail("order_id", "is required")
+	}
+
+	userID := lo.UserID
+	switch mapped, ok := m.users[lo.UserID]; {
+	case lo.UserID == "":
+		fail("user_id", "is required")
+	case m.users != nil && !ok:
+		fail("user_id", "legacy user %q has no mapping", lo.UserID)
+	case m.users != nil:
+		userID = mapped
+	}
+
+	status, ok := m.statuses[strings.ToUpper(lo.Status)]
+	if !ok {
+		fail("status", "unknown legacy status %q", lo.Status)
+	}
+
+	createdAt, err := parseLegacyTime(lo.CreatedAt)
+	if err != nil {
+		fail("created_at", "%v", err)
+	}
+	updatedAt := createdAt
+	if lo.UpdatedAt != "" {
+		if updatedAt, err = parseLegacyTime(lo.UpdatedAt); err != nil {
+			fail("updated_at", "%v", err)
+		}
+	}
+
+	currency := lo.Currency
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+
+	items := make([]contracts.OrderItem, 0, len(lo.Items))
+	for i, li := range lo.Items {
+		price, err := money.Parse(string(li.Price), currency)
+		if err != nil {
+			fail(fmt.Sprintf("items[%d].price", i), "%v", err)
+		}
+		items = append(items, contracts.OrderItem{
+			ProductID: li.ProductID,
+			Name:      li.Name,
+			SKU:       li.SKU,
+			Price:     price,
+			Quantity:  li.Quantity,
+		})
+	}
+
+	// Historical orders are not held to today's size limits
+	for _, err := range []error{contracts.ValidateItems(items, contracts.Limits{}), contracts.ValidateItemDetails(items)} {
+		if verr, ok := err.(*contracts.ValidationError); ok {
+			problems = append(problems, verr.Fields...)
+		}
+	}
+	if len(problems) > 0 {
+		return contracts.Order{}, problems
+	}
+
+	lines := make([]money.Money, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, item.Price.Mul(int64(item.Quantity)))
+	}
+	total, err := money.Sum(items[0].Price.Currency, lines...)
+	if err != nil {
+		return contracts.Order{}, []contracts.FieldError{{Field: "items", Message: err.Error()}}
+	}
+
+	order := contracts.Order{
+		OrderID:     uuid.NewSHA1(importNamespace, []byte(lo.ID)).String(),
+		LegacyID:    lo.ID,
+		UserID:      userID,
+		Items:       items,
+		TotalAmount: total,
+		Status:      status,
+		CreatedAt:   createdAt,
+		UpdatedAt:   updatedAt,
+	}
+	// Legacy orders carried no tax or shipping
+	order.BackfillTotals()
+	return order, nil
+}
+
+func parseLegacyTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("is required")
+	}
+	for _, layout := range legacyTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Truncate(time.Millisecond), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized time %q", value)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// legacyOrder is one order as exported by the legacy system. Line is the
+// last input line it was read from, which is what the checkpoint records.
+type legacyOrder struct {
+	Line      int          `json:"-"`
+	ID        string       `json:"order_id"`
+	UserID    string       `json:"user_id"`
+	Status    string       `json:"status"`
+	CreatedAt string       `json:"created_at"`
+	UpdatedAt string       `json:"updated_at"`
+	Currency  string       `json:"currency"`
+	Items     []legacyItem `json:"items"`
+
+	// Broken explains why the order cannot be trusted even if it validates
+	Broken string `json:"-"`
+}
+
+type legacyItem struct {
+	ProductID string       `json:"product_id"`
+	Name      string       `json:"name"`
+	SKU       string       `json:"sku"`
+	Price     legacyAmount `json:"price"`
+	Quantity  int          `json:"quantity"`
+}
+
+// legacyAmount accepts a price exported either as a JSON number or string,
+// keeping its exact decimal text
+type legacyAmount string
+
+func (a *legacyAmount) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*a = legacyAmount(s)
+		return nil
+	}
+	*a = legacyAmount(data)
+	return nil
+}
+
+// rowError is an input line that could not be parsed at all; it is
+// reported and skipped
+type rowError struct {
+	Line int
+	Err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// orderReader yields legacy orders in input order, io.EOF at the end and a
+// *rowError for lines that cannot be parsed
+type orderReader interface {
+	Next() (legacyOrder, error)
+}
+
+// ndjsonReader reads one order object per line
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (r *ndjsonReader) Next() (legacyOrder, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		var order legacyOrder
+		if err := json.Unmarshal([]byte(text), &order); err != nil {
+			return legacyOrder{}, &rowError{Line: r.line, Err: err}
+		}
+		order.Line = r.line
+		return order, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return legacyOrder{}, err
+	}
+	return legacyOrder{}, io.EOF
+}
+
+// csvColumns are the columns of a CSV export, one row per line item. Rows
+// of the same order must be adjacent.
+var csvColumns = []string{
+	"order_id", "user_id", "status", "created_at", "updated_at", "currency",
+	"product_id", "name", "sku", "price", "quantity",
+}
+
+// csvReader groups adjacent line-item rows into orders
+type csvReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	pending *legacyOrder
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := columns[name]; !ok && !optionalCSVColumns[name] {
+			return nil, fmt.Errorf("CSV header is missing column %q", name)
+		}
+	}
+	return &csvReader{reader: reader, columns: columns}, nil
+}
+
+var optionalCSVColumns = map[string]bool{"updated_at": true, "currency": true, "sku": true}
+
+func (r *csvReader) field(record []string, name string) string {
+	i, ok := r.columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (r *csvReader) Next() (legacyOrder, error) {
+	for {
+		record, err := r.reader.Read()
+		if err == io.EOF {
+			if r.pending == nil {
+				return legacyOrder{}, io.EOF
+			}
+			order := *r.pending
+			r.pending = nil
+			return order, nil
+		}
+		var perr *csv.ParseError
+		if errors.As(err, &perr) {
+			// The unreadable row may belong to the order being collected;
+			// reject that order rather than import it with an item missing
+			if r.pending != nil {
+				r.pending.Broken = fmt.Sprintf("an adjacent row (line %d) could not be parsed", perr.Line)
+			}
+			return legacyOrder{}, &rowError{Line: perr.Line, Err: perr.Err}
+		}
+		if err != nil {
+			return legacyOrder{}, err
+		}
+		line, _ := r.reader.FieldPos(0)
+
+		item := legacyItem{
+			ProductID: r.field(record, "product_id"),
+			Name:      r.field(record, "name"),
+			SKU:       r.field(record, "sku"),
+			Price:     legacyAmount(r.field(record, "price")),
+		}
+		// An unparseable quantity stays 0 and is rejected by validation
+		item.Quantity, _ = strconv.Atoi(r.field(record, "quantity"))
+
+		id := r.field(record, "order_id")
+		if r.pending != nil && r.pending.ID == id {
+			r.pending.Items = append(r.pending.Items, item)
+			r.pending.Line = line
+			continue
+		}
+
+		next := &legacyOrder{
+			Line:      line,
+			ID:        id,
+			UserID:    r.field(record, "user_id"),
+			Status:    r.field(record, "status"),
+			CreatedAt: r.field(record, "created_at"),
+			UpdatedAt: r.field(record, "updated_at"),
+			Currency:  r.field(record, "currency"),
+			Items:     []legacyItem{item},
+		}
+		previous := r.pending
+		r.pending = next
+		if previous != nil {
+			return *previous, nil
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// checkpoint records how far an import got. Everything up to and including
+// Line has been written or reported.
+type checkpoint struct {
+	Source    string    `json:"source"`
+	Line      int       `json:"line"`
+	Imported  int       `json:"imported"`
+	Rejected  int       `json:"rejected"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func loadCheckpoint(path, source string) (checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return checkpoint{Source: source}, nil
+	}
+	if err != nil {
+		return checkpoint{}, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}, err
+	}
+	if cp.Source != source {
+		return checkpoint{}, errors.New("checkpoint " + path + " belongs to " + cp.Source + "; pass -checkpoint or delete it")
+	}
+	return cp, nil
+}
+
+// save writes the checkpoint atomically so a crash never leaves it torn
+func (cp checkpoint) save(path string) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// rejection is a row left out of the import and why
+type rejection struct {
+	Line     int
+	LegacyID string
+	Field    string
+	Message  string
+}
+
+// errorReport is the CSV of rejected rows. Resumed imports append to it.
+type errorReport struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+func openErrorReport(path string, resume bool) (*errorReport, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	report := &errorReport{file: f, writer: csv.NewWriter(f)}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		report.writer.Write([]string{"line", "legacy_order_id", "field", "error"})
+	}
+	return report, nil
+}
+
+func (r *errorReport) write(rejections []rejection) error {
+	for _, rej := range rejections {
+		r.writer.Write([]string{strconv.Itoa(rej.Line), rej.LegacyID, rej.Field, rej.Message})
+	}
+	r.writer.Flush()
+	if err := r.writer.Error(); err != nil {
+		return err
+	}
+	return r.file.Sync()
+}
+
+func (r *errorReport) Close() error {
+	r.writer.Flush()
+	return r.file.Close()
+}
+
+// orderWriter upserts imported orders by order_id and records an
+// OrderCreated event for each so the read models pick them up
+type orderWriter struct {
+	orders *mongo.Collection
+	events *mongo.Collection
+	dryRun bool
+}
+
+func (w orderWriter) write(ctx context.Context, orders []contracts.Order) error {
+	if w.dryRun || len(orders) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(orders))
+	stored := make([]interface{}, 0, len(orders))
+	for _, order := range orders {
+		models = append(models, mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"order_id": order.OrderID}).
+			SetReplacement(order).
+			SetUpsert(true))
+
+		event := events.NewEvent(contracts.EventOrderCreated, order, order.CreatedAt)
+		// Deterministic so a re-import does not record the event twice
+		event.EventID = uuid.NewSHA1(importNamespace, []byte(order.OrderID+"/imported")).String()
+		stored = append(stored, event)
+	}
+
+	if _, err := w.orders.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		return err
+	}
+	_, err := w.events.InsertMany(ctx, stored, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicateKeys(err) {
+		return err
+	}
+	return nil
+}
+
+// onlyDuplicateKeys reports whether every write error is a duplicate key,
+// i.e. the events were stored by an earlier run
+func onlyDuplicateKeys(err error) bool {
+	var bulk mongo.BulkWriteException
+	if !errors.As(err, &bulk) || bulk.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range bulk.WriteErrors {
+		if we.Code != 11000 {
+			return false
+		}
+	}
+	return true
+}
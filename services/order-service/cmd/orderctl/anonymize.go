@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"order-service/internal/app"
+	"order-service/pkg/archive"
+)
+
+// anonymize replaces personal data on orders older than ORDER_RETENTION,
+// live and archived, with irreversible tokens. It is meant to run on a
+// schedule, e.g. as a Kubernetes CronJob; each run's summary is stored in
+// anonymization_runs.
+func anonymize(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "count orders due for anonymization without changing them")
+	fs.Parse(args)
+
+	if a.Config.OrderRetention == 0 {
+		return errors.New("ORDER_RETENTION is not set")
+	}
+	for _, orders := range []string{"orders", archive.Collection} {
+		if _, err := a.NewAnonymizer(orders).Run(ctx, *dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+
+	"order-service/internal/app"
+	"order-service/pkg/backup"
+
+	"github.com/rs/zerolog/log"
+)
+
+// newBackups returns the backups kept in OBJECT_STORE_BUCKET under prefix,
+// logging their progress
+func newBackups(a *app.App, prefix string) (*backup.Backups, error) {
+	if a.ObjectStore == nil {
+		return nil, errors.New("OBJECT_STORE_BUCKET is not set")
+	}
+	b := backup.New(a.ObjectStore)
+	b.Prefix = prefix
+	b.Progress = func(p backup.Progress) {
+		event := log.Info().Str("backup", p.Backup).Str("collection", p.Collection).Int64("documents", p.Done).Int64("total", p.Total)
+		if p.Total > 0 {
+			event = event.Float64("percent", float64(p.Done*1000/p.Total)/10)
+		}
+		event.Msg("Backup progress")
+	}
+	return b, nil
+}
+
+// createBackup snapshots the orders collections to the object store
+func createBackup(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	collections := fs.String("collections", "orders", "comma-separated collections to back up")
+	prefix := fs.String("prefix", backup.DefaultPrefix, "key prefix of backups in the bucket")
+	fs.Parse(args)
+
+	b, err := newBackups(a, *prefix)
+	if err != nil {
+		return err
+	}
+	manifest, err := b.Create(ctx, a.DB, strings.Split(*collections, ",")...)
+	if err != nil {
+		return err
+	}
+	for _, c := range manifest.Collections {
+		log.Info().Str("backup", manifest.ID).Str("collection", c.Name).Int64("documents", c.Documents).Int("parts", len(c.Parts)).Msg("Collection backed up")
+	}
+	log.Info().Str("backup", manifest.ID).Dur("took", manifest.CompletedAt.Sub(manifest.CreatedAt)).Msg("Backup complete")
+	return nil
+}
+
+// restoreBackup restores a backup into -database on the same cluster,
+// which may be a scratch database for a drill
+func restoreBackup(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	id := fs.String("id", "", "ID of the backup to restore")
+	database := fs.String("database", "", "database to restore into")
+	drop := fs.Bool("drop", false, "drop collections holding documents before restoring them")
+	prefix := fs.String("prefix", backup.DefaultPrefix, "key prefix of backups in the bucket")
+	fs.Parse(args)
+
+	if *id == "" || *database == "" {
+		return errors.New("-id and -database are required")
+	}
+	b, err := newBackups(a, *prefix)
+	if err != nil {
+		return err
+	}
+	manifest, err := b.Restore(ctx, *id, a.Mongo.Database(*database), *drop)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("backup", manifest.ID).Str("database", *database).Time("created_at", manifest.CreatedAt).Msg("Backup restored")
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"regexp"
+	"strings"
+
+	"order-service/internal/app"
+	"order-service/pkg/archive"
+	"order-service/pkg/contracts"
+	"order-service/pkg/fieldcrypt"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// encryptPII encrypts the personal data stored before FIELD_ENCRYPTION was
+// set, in the orders, live and archived, and in the stored events and
+// snapshots copying them. Everything written since is encrypted as it is
+// stored. Orders already exported to cold storage are left as exported.
+func encryptPII(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("encrypt-pii", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "count documents holding plaintext personal data without writing")
+	fs.Parse(args)
+
+	if a.Keyring == nil {
+		return errors.New("FIELD_ENCRYPTION is not set")
+	}
+	// Each collection with the prefix its copy of the order sits under
+	copies := []struct{ collection, prefix string }{
+		{"orders", ""},
+		{archive.Collection, ""},
+		{"events", "order."},
+		{"order_events", "data."},
+		{"order_snapshots", "state."},
+	}
+	for _, c := range copies {
+		encrypted, err := encryptCollection(ctx, a.DB.Collection(c.collection), c.prefix, *dryRun)
+		if err != nil {
+			return err
+		}
+		log.Info().Str("collection", c.collection).Int("documents", encrypted).Bool("dry_run", *dryRun).Msg("Personal data encrypted")
+	}
+	return nil
+}
+
+// encryptCollection seals the plaintext values of contracts.EncryptedFields
+// under prefix in every document of collection, and returns how many
+// documents held any
+func encryptCollection(ctx context.Context, collection *mongo.Collection, prefix string, dryRun bool) (int, error) {
+	plaintext := bson.A{}
+	projection := bson.M{}
+	for _, field := range contracts.EncryptedFields {
+		path := prefix + field
+		plaintext = append(plaintext, bson.M{path: bson.M{
+			"$type": "string",
+			"$ne":   "",
+			"$not":  bson.M{"$regex": "^" + regexp.QuoteMeta(fieldcrypt.Prefix)},
+		}})
+		projection[path] = 1
+	}
+	filter := bson.M{"$or": plaintext}
+	if dryRun {
+		n, err := collection.CountDocuments(ctx, filter)
+		return int(n), err
+	}
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	encrypted := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return encrypted, err
+		}
+		set := bson.M{}
+		for _, field := range contracts.EncryptedFields {
+			path := prefix + field
+			value, ok := lookup(doc, path).(string)
+			if !ok || value == "" || fieldcrypt.IsEncrypted(value) {
+				continue
+			}
+			if set[path], err = contracts.SealPII(value); err != nil {
+				return encrypted, err
+			}
+		}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, bson.M{"$set": set}); err != nil {
+			return encrypted, err
+		}
+		encrypted++
+	}
+	return encrypted, cursor.Err()
+}
+
+// lookup returns the value at a dotted path in doc, or nil
+func lookup(doc bson.M, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"order-service/internal/app"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateMoney rewrites orders and stored events whose amounts are still
+// float64 into the money representation (minor units + currency). Legacy
+// amounts are read as money.DefaultCurrency. Read models are not touched;
+// rebuild them with ./projector -reset afterwards.
+func migrateMoney(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("migrate-money", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "count documents that need migrating without writing")
+	fs.Parse(args)
+
+	orders := a.DB.Collection("orders")
+	migrated, err := migrateCollection(ctx, orders, bson.M{"total_amount.currency": bson.M{"$exists": false}}, *dryRun,
+		func(cursor *mongo.Cursor) (interface{}, interface{}, error) {
+			var order contracts.Order
+			err := cursor.Decode(&order)
+			return order.ID, order, err
+		})
+	if err != nil {
+		return err
+	}
+	log.Info().Str("collection", "orders").Int("documents", migrated).Bool("dry_run", *dryRun).Msg("Money migration finished")
+
+	stored := a.DB.Collection("events")
+	migrated, err = migrateCollection(ctx, stored, bson.M{"order.total_amount.currency": bson.M{"$exists": false}}, *dryRun,
+		func(cursor *mongo.Cursor) (interface{}, interface{}, error) {
+			var record events.Record
+			err := cursor.Decode(&record)
+			return record.ID, record, err
+		})
+	if err != nil {
+		return err
+	}
+	log.Info().Str("collection", "events").Int("documents", migrated).Bool("dry_run", *dryRun).Msg("Money migration finished")
+	return nil
+}
+
+// migrateSchema upgrades every order document to the current schema
+// version and saves it. Orders are upgraded on read anyway; this persists
+// the upgrades so older migrations can eventually be retired.
+func migrateSchema(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("migrate-schema", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "count documents that need migrating without writing")
+	fs.Parse(args)
+
+	migrated, err := contracts.OrderSchema.Migrate(ctx, a.DB.Collection("orders"), *dryRun)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("collection", "orders").Int("version", contracts.OrderSchema.Version()).Int("documents", migrated).
+		Bool("dry_run", *dryRun).Msg("Schema migration finished")
+	return nil
+}
+
+// migrateIndexes drops the indexes of the orders collections, shared and
+// of each tenant store, that are declared otherwise now, such as the text
+// index over the encrypted name and street lines of shipping addresses,
+// and creates them as declared. Searches miss the text index until it is
+// built again.
+func migrateIndexes(ctx context.Context, a *app.App, args []string) error {
+	fs := flag.NewFlagSet("migrate-indexes", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "list the indexes to drop without dropping them")
+	fs.Parse(args)
+
+	collections := []*mongo.Collection{a.DB.Collection("orders")}
+	for _, store := range a.Config.Tenancy.Stores {
+		collections = append(collections, a.Mongo.Database(store.Database).Collection(store.Collection))
+	}
+	for _, orders := range collections {
+		names, err := repository.ConflictingOrderIndexes(ctx, orders, a.Config.Mongo.ShardKey)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !*dryRun {
+				if _, err := orders.Indexes().DropOne(ctx, name); err != nil {
+					return err
+				}
+			}
+			log.Info().Str("database", orders.Database().Name()).Str("collection", orders.Name()).Str("index", name).
+				Bool("dry_run", *dryRun).Msg("Index dropped")
+		}
+		if len(names) == 0 || *dryRun {
+			continue
+		}
+		report, err := repository.EnsureOrderIndexes(ctx, orders, true, a.Config.Mongo.ShardKey)
+		if err != nil {
+			return err
+		}
+		report.Log()
+		if err := report.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCollection re-saves every document matching filter after decoding
+// it through the current types, which convert legacy amounts on read
+func migrateCollection(ctx context.Context, collection *mongo.Collection, filter bson.M, dryRun bool,
+	decode func(*mongo.Cursor) (interface{}, interface{}, error)) (int, error) {
+	if dryRun {
+		n, err := collection.CountDocuments(ctx, filter)
+		return int(n), err
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		id, doc, err := decode(cursor)
+		if err != nil {
+			return migrated, err
+		}
+		if _, err := collection.ReplaceOne(ctx, bson.M{"_id": id}, doc); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
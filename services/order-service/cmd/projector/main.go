@@ -0,0 +1,80 @@
+// Command projector consumes stored order events and maintains the CQRS read
+// models (order views with product details and per-user summaries).
+package main
+
+import (
+	"context"
+	"flag"
+	"os/signal"
+	"syscall"
+
+	"order-service/internal/app"
+	"order-service/pkg/clock"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	reset := flag.Bool("reset", false, "discard the checkpoint and rebuild all read models")
+	flag.Parse()
+
+	godotenv.Load()
+	app.SetupLogger()
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+	app.SetLogLevel(cfg.LogLevel)
+	app.SetLogRedaction(cfg.Redaction)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.UseOutboundTLS(cfg.TLS); err != nil {
+		log.Fatal().Err(err).Msg("Invalid outbound TLS configuration")
+	}
+	if _, err := app.LoadSecrets(ctx, &cfg, clock.System{}); err != nil {
+		log.Fatal().Err(err).Msg("Failed to load secrets")
+	}
+
+	// The worker only needs the event store and read models, not the
+	// order repository or HTTP handlers
+	writeClient, err := app.ConnectMongo(ctx, cfg.MongoURI, cfg.Mongo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to MongoDB")
+	}
+	defer writeClient.Disconnect(context.Background())
+
+	readClient := writeClient
+	if cfg.ReadModelURI != cfg.MongoURI {
+		readClient, err = app.ConnectMongo(ctx, cfg.ReadModelURI, cfg.Mongo)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect to read model MongoDB")
+		}
+		defer readClient.Disconnect(context.Background())
+	}
+
+	clk := clock.System{}
+	if _, err := app.UseFieldEncryption(ctx, cfg, writeClient.Database(cfg.Database), clk); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up field encryption")
+	}
+	store := app.NewEventStore(ctx, writeClient.Database(cfg.Database), clk)
+	projector := app.NewProjector(cfg, store, readClient.Database(cfg.ReadModelDatabase), clk)
+
+	if err := projector.EnsureIndexes(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Failed to create read model indexes")
+	}
+
+	if *reset {
+		if err := projector.Reset(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Failed to reset projection checkpoint")
+		}
+		log.Info().Msg("Projection checkpoint reset, rebuilding read models")
+	}
+
+	log.Info().Dur("poll_interval", cfg.ProjectionPollInterval).Msg("Order projector starting")
+	projector.Run(ctx, cfg.ProjectionPollInterval)
+	log.Info().Msg("Order projector stopped")
+}
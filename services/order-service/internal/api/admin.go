@@ -0,0 +1,402 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/audit"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/loglevel"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/repository"
+	"order-service/pkg/search"
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// OrderSearch lists orders from a search index rather than the orders
+// collection; it is implemented by *search.Index
+type OrderSearch interface {
+	Find(ctx context.Context, filter repository.OrderFilter, text string, q repository.PageQuery) (repository.Page, error)
+}
+
+// listOrders pages through every order matching the filters, for operators.
+// With a search index they are listed from it, and q finds the orders
+// containing its words.
+//
+//	GET /api/admin/orders?status=pending,confirmed&user_id=...&product_id=...&from=...&to=...&total_currency=USD&min_total=10&max_total=250&q=...&limit=50&sort=-total_amount
+func (h *Handler) listOrders(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	filter, ok := orderFilter(c, "from", "to")
+	if !ok {
+		return
+	}
+	q, _, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(c.Query("q"))
+	if text != "" && h.opts.Search == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q requires the search index, which is not configured"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	var page repository.Page
+	var err error
+	if h.opts.Search != nil {
+		// The index holds every tenant's orders
+		filter.TenantID = tenant.FromContext(ctx)
+		page, err = h.opts.Search.Find(ctx, filter, text, q)
+	} else {
+		page, err = h.orders.List(ctx, filter, q)
+	}
+	if err != nil {
+		if err == search.ErrTooDeep {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to list orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orderPage{
+		Orders: present.orders(ctx, page.Orders),
+		Paging: newPaging(q, page),
+	})
+}
+
+// maxSearchLength bounds the text of a search
+const maxSearchLength = 200
+
+// searchHit is an order found by a text search as rendered to clients
+type searchHit struct {
+	Order orderResponse `json:"order"`
+	Score float64       `json:"score"`
+	// Highlights holds, by field, the values containing words searched
+	// for, HTML-escaped, with those words wrapped in <em>
+	Highlights map[string][]string `json:"highlights"`
+}
+
+// searchPage is one page of search hits
+type searchPage struct {
+	Hits   []searchHit `json:"hits"`
+	Paging paging      `json:"paging"`
+}
+
+// searchOrderText finds the orders whose order ID, item names or shipping
+// address contain words of q, most relevant first, for operators. The
+// listing filters narrow the search.
+//
+//	GET /api/admin/orders/search?q=blue+mug+berlin&status=shipped&from=...&limit=20&offset=40
+func (h *Handler) searchOrderText(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(c.Query("q"))
+	if text == "" || len(text) > maxSearchLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q must be between 1 and " + strconv.Itoa(maxSearchLength) + " characters"})
+		return
+	}
+	filter, ok := orderFilter(c, "from", "to")
+	if !ok {
+		return
+	}
+	if _, sorted := c.GetQuery("sort"); sorted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search results are sorted by relevance"})
+		return
+	}
+	q, _, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	page, err := h.orders.Search(ctx, text, filter, q)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to search orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search orders"})
+		return
+	}
+
+	hits := make([]searchHit, len(page.Hits))
+	orders := make([]contracts.Order, len(page.Hits))
+	for i, hit := range page.Hits {
+		hits[i] = searchHit{Order: present.order(ctx, hit.Order), Score: hit.Score, Highlights: hit.Highlights}
+		orders[i] = hit.Order
+	}
+	paging := newPaging(q, repository.Page{Orders: orders, Total: page.Total})
+	paging.Sort = "relevance"
+	c.JSON(http.StatusOK, searchPage{Hits: hits, Paging: paging})
+}
+
+// orderFilter reads the listing filters from the query string. Invalid
+// values are answered with 400 and ok=false.
+func orderFilter(c *gin.Context, after, before string) (repository.OrderFilter, bool) {
+	f, err := parseOrderFilter(c.Query, after, before)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return f, false
+	}
+	return f, true
+}
+
+// parseOrderFilter reads the listing filters through get: status
+// (comma-separated), user_id, product_id, the created_at bounds named after
+// and before (RFC3339), and min_total and max_total, which are amounts in
+// total_currency. Missing values are empty strings.
+func parseOrderFilter(get func(string) string, after, before string) (f repository.OrderFilter, err error) {
+	if v := get("status"); v != "" {
+		for _, status := range strings.Split(v, ",") {
+			if !contracts.IsStatus(status) {
+				return f, errors.New("Invalid status " + status)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	f.UserID = get("user_id")
+	f.ProductID = get("product_id")
+
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{after, &f.From}, {before, &f.To}} {
+		if v := get(bound.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, errors.New(bound.name + " must be an RFC3339 time")
+			}
+			*bound.t = t
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return f, errors.New(after + " must be before " + before)
+	}
+
+	if v := get("total_currency"); v != "" {
+		currency, err := money.NormalizeCurrency(v)
+		if err != nil {
+			return f, errors.New("total_currency must be an ISO 4217 currency code")
+		}
+		f.Currency = currency
+	}
+	for _, bound := range []struct {
+		name   string
+		amount **int64
+	}{{"min_total", &f.MinTotal}, {"max_total", &f.MaxTotal}} {
+		v := get(bound.name)
+		if v == "" {
+			continue
+		}
+		if f.Currency == "" {
+			return f, errors.New(bound.name + " requires total_currency")
+		}
+		m, err := money.Parse(v, f.Currency)
+		if err != nil {
+			return f, errors.New(bound.name + " must be a decimal amount")
+		}
+		*bound.amount = &m.Amount
+	}
+	if f.MinTotal != nil && f.MaxTotal != nil && *f.MinTotal > *f.MaxTotal {
+		return f, errors.New("min_total must not exceed max_total")
+	}
+	return f, nil
+}
+
+// ReplayEventsRequest represents the request payload for replaying events
+type ReplayEventsRequest struct {
+	OrderID string    `json:"order_id"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Types   []string  `json:"types"`
+}
+
+// replayResult counts the events a replay republished
+type replayResult struct {
+	Replayed int `json:"replayed"`
+}
+
+func (h *Handler) replayEvents(c *gin.Context) {
+	var req ReplayEventsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.OrderID == "" && req.From.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Either order_id or from is required"})
+		return
+	}
+	if !req.To.IsZero() && req.To.Before(req.From) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	filter := events.Filter{OrderID: req.OrderID, From: req.From, To: req.To, Types: req.Types}
+	replayed, err := h.orders.ReplayEvents(ctx, filter)
+	// Events republished before a failure reached consumers all the same
+	after := audit.Fields{"replayed": replayed}
+	if err != nil {
+		after["error"] = err.Error()
+	}
+	h.opts.Audit.Record(ctx, audit.Record{
+		Action:   audit.ActionEventReplay,
+		Resource: audit.Resource{Type: audit.ResourceEvents, ID: req.OrderID},
+		Before:   audit.Fields{"filter": filter},
+		After:    after,
+	})
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			log.Ctx(c.Request.Context()).Warn().Err(err).Int("replayed", replayed).Msg("Event replay interrupted")
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Int("replayed", replayed).Msg("Event replay failed")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Event replay failed",
+			"replayed": replayed,
+		})
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", req.OrderID).
+		Time("from", req.From).
+		Time("to", req.To).
+		Int("replayed", replayed).
+		Str("requested_by", c.GetString(middleware.ContextUserID)).
+		Msg("Events replayed")
+
+	c.JSON(http.StatusOK, replayResult{Replayed: replayed})
+}
+
+// readOnlyPath is exempt from read-only mode so it can be switched off again
+const readOnlyPath = "/api/admin/read-only"
+
+// SetReadOnlyRequest represents the request payload for toggling read-only mode
+type SetReadOnlyRequest struct {
+	Enabled *bool  `json:"enabled" binding:"required"`
+	Reason  string `json:"reason"`
+}
+
+func (h *Handler) getReadOnly(c *gin.Context) {
+	c.JSON(http.StatusOK, h.opts.ReadOnly.Status())
+}
+
+func (h *Handler) setReadOnly(c *gin.Context) {
+	var req SetReadOnlyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := h.opts.ReadOnly.Status()
+	h.opts.ReadOnly.Set(*req.Enabled, req.Reason, h.opts.Clock.Now())
+	h.opts.Audit.Record(c.Request.Context(), audit.Record{
+		Action:   audit.ActionReadOnly,
+		Resource: audit.Resource{Type: audit.ResourceService, ID: "read_only"},
+		Before:   audit.Fields{"enabled": before.Enabled, "reason": before.Reason},
+		After:    audit.Fields{"enabled": *req.Enabled, "reason": req.Reason},
+	})
+	log.Ctx(c.Request.Context()).Warn().
+		Bool("read_only", *req.Enabled).
+		Str("reason", req.Reason).
+		Str("requested_by", c.GetString(middleware.ContextUserID)).
+		Msg("Read-only mode changed")
+
+	c.JSON(http.StatusOK, h.opts.ReadOnly.Status())
+}
+
+// logLevelPath is exempt from read-only mode, which incidents often come
+// with
+const logLevelPath = "/api/admin/log-level"
+
+// SetLogLevelRequest represents the request payload for overriding the log
+// level
+type SetLogLevelRequest struct {
+	Level string `json:"level" binding:"required"`
+	// Duration, such as "15m", ends the override by itself; empty lasts
+	// until reset
+	Duration string `json:"duration"`
+}
+
+// maxLogLevelOverride bounds overrides that end by themselves
+const maxLogLevelOverride = 24 * time.Hour
+
+func (h *Handler) getLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, loglevel.Current())
+}
+
+func (h *Handler) setLogLevel(c *gin.Context) {
+	var req SetLogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	level, err := zerolog.ParseLevel(strings.ToLower(req.Level))
+	if err != nil || req.Level == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "level must be trace, debug, info, warn, error, fatal, panic or disabled"})
+		return
+	}
+	var d time.Duration
+	if req.Duration != "" {
+		if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 || d > maxLogLevelOverride {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive duration of at most 24h"})
+			return
+		}
+	}
+
+	before := loglevel.Current()
+	status := loglevel.Override(level, d)
+	h.recordLogLevel(c, before, status)
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *Handler) resetLogLevel(c *gin.Context) {
+	before := loglevel.Current()
+	status := loglevel.Reset()
+	h.recordLogLevel(c, before, status)
+	c.JSON(http.StatusOK, status)
+}
+
+// recordLogLevel audits and logs a log level change, at a level that is
+// always written
+func (h *Handler) recordLogLevel(c *gin.Context, before, after loglevel.Status) {
+	h.opts.Audit.Record(c.Request.Context(), audit.Record{
+		Action:   audit.ActionLogLevel,
+		Resource: audit.Resource{Type: audit.ResourceService, ID: "log_level"},
+		Before:   audit.Fields{"level": before.Level, "override": before.Override},
+		After:    audit.Fields{"level": after.Level, "override": after.Override, "until": after.Until},
+	})
+	entry := log.Ctx(c.Request.Context()).Log().
+		Str("log_level", after.Level).
+		Bool("override", after.Override).
+		Str("requested_by", c.GetString(middleware.ContextUserID))
+	if after.Until != nil {
+		entry = entry.Time("until", *after.Until)
+	}
+	entry.Msg("Log level changed")
+}
@@ -0,0 +1,221 @@
+//go:build dev
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/httpclient"
+	"order-service/pkg/money"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// SeedRequest represents the request payload for seeding the database
+type SeedRequest struct {
+	Users         int    `json:"users"`
+	OrdersPerUser int    `json:"orders_per_user"`
+	Seed          int64  `json:"seed"`
+	Reset         bool   `json:"reset"`
+	Password      string `json:"password"`
+}
+
+// SeededUser represents a user created (or reused) by the seeder
+type SeededUser struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Orders   int    `json:"orders"`
+}
+
+const (
+	maxSeedUsers         = 500
+	maxSeedOrdersPerUser = 200
+)
+
+var userServiceClient = httpclient.New(httpclient.DefaultConfig("user-service"))
+
+var seedStatuses = []string{"pending", "confirmed", "shipped", "delivered", "cancelled"}
+
+var seedProducts = []contracts.OrderItem{
+	{ProductID: "seed-product-1", Name: "Wireless Mouse", Price: money.New(2499, "USD")},
+	{ProductID: "seed-product-2", Name: "Mechanical Keyboard", Price: money.New(8950, "USD")},
+	{ProductID: "seed-product-3", Name: "USB-C Hub", Price: money.New(3900, "USD")},
+	{ProductID: "seed-product-4", Name: "27in Monitor", Price: money.New(24999, "USD")},
+	{ProductID: "seed-product-5", Name: "Laptop Stand", Price: money.New(3125, "USD")},
+}
+
+// registerDevRoutes exposes development-only endpoints. They are compiled in
+// only with -tags dev and never ship in release images.
+func (h *Handler) registerDevRoutes(r *gin.Engine) {
+	log.Warn().Msg("Development build - POST /dev/seed is enabled")
+	r.POST("/dev/seed", h.seedData)
+}
+
+func (h *Handler) seedData(c *gin.Context) {
+	req := SeedRequest{Users: 5, OrdersPerUser: 3, Seed: 1, Password: "Password123!"}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if req.Users < 1 || req.Users > maxSeedUsers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("users must be between 1 and %d", maxSeedUsers)})
+		return
+	}
+	if req.OrdersPerUser < 0 || req.OrdersPerUser > maxSeedOrdersPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("orders_per_user must be between 0 and %d", maxSeedOrdersPerUser)})
+		return
+	}
+	if len(req.Password) < 8 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "password must be at least 8 characters"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+	defer cancel()
+
+	if req.Reset {
+		if _, err := h.collection.DeleteMany(ctx, bson.M{}); err != nil {
+			log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to reset orders")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset orders"})
+			return
+		}
+	}
+
+	rng := rand.New(rand.NewSource(req.Seed))
+	users := make([]SeededUser, 0, req.Users)
+	totalOrders := 0
+
+	for i := 1; i <= req.Users; i++ {
+		user, err := h.ensureSeedUser(ctx, i, req.Password)
+		if err != nil {
+			log.Ctx(c.Request.Context()).Error().Err(err).Int("user", i).Msg("Failed to seed user")
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to seed users via user-service"})
+			return
+		}
+
+		orders := make([]interface{}, 0, req.OrdersPerUser)
+		for j := 0; j < req.OrdersPerUser; j++ {
+			orders = append(orders, seedOrder(rng, user.UserID, h.opts.Clock.Now()))
+		}
+		if len(orders) > 0 {
+			if _, err := h.collection.InsertMany(ctx, orders); err != nil {
+				log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", user.UserID).Msg("Failed to seed orders")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seed orders"})
+				return
+			}
+		}
+
+		user.Orders = len(orders)
+		totalOrders += len(orders)
+		users = append(users, user)
+	}
+
+	log.Ctx(c.Request.Context()).Info().Int("users", len(users)).Int("orders", totalOrders).Bool("reset", req.Reset).Msg("Database seeded")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"users":  users,
+		"orders": totalOrders,
+		"reset":  req.Reset,
+	})
+}
+
+func seedOrder(rng *rand.Rand, userID string, now time.Time) contracts.Order {
+	count := rng.Intn(3) + 1
+	items := make([]contracts.OrderItem, 0, count)
+	total := money.Zero("USD")
+	for k := 0; k < count; k++ {
+		item := seedProducts[rng.Intn(len(seedProducts))]
+		item.Quantity = rng.Intn(4) + 1
+		total.Amount += item.Price.Mul(int64(item.Quantity)).Amount
+		items = append(items, item)
+	}
+
+	createdAt := now.Add(-time.Duration(rng.Intn(90*24)) * time.Hour)
+	order := contracts.Order{
+		OrderID:     uuid.New().String(),
+		UserID:      userID,
+		Items:       items,
+		TotalAmount: total,
+		Status:      seedStatuses[rng.Intn(len(seedStatuses))],
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+	}
+	order.BackfillTotals()
+	return order
+}
+
+// ensureSeedUser registers seeduser<n> with user-service, falling back to a
+// login when the user already exists from an earlier seed run
+func (h *Handler) ensureSeedUser(ctx context.Context, n int, password string) (SeededUser, error) {
+	baseURL := h.opts.UserServiceURL
+
+	user := SeededUser{Email: fmt.Sprintf("seeduser%d@example.com", n), Password: password}
+
+	var registered struct {
+		UserID string `json:"userId"`
+	}
+	status, err := postJSON(ctx, baseURL+"/api/users/register", gin.H{
+		"username":  fmt.Sprintf("seeduser%d", n),
+		"email":     user.Email,
+		"password":  password,
+		"firstName": "Seed",
+		"lastName":  fmt.Sprintf("User%d", n),
+	}, &registered)
+	if err != nil {
+		return user, err
+	}
+	if status == http.StatusCreated {
+		user.UserID = registered.UserID
+		return user, nil
+	}
+	if status != http.StatusConflict {
+		return user, fmt.Errorf("register returned status %d", status)
+	}
+
+	var login struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	status, err = postJSON(ctx, baseURL+"/api/users/login", gin.H{
+		"email":    user.Email,
+		"password": password,
+	}, &login)
+	if err != nil {
+		return user, err
+	}
+	if status != http.StatusOK {
+		return user, fmt.Errorf("login returned status %d", status)
+	}
+
+	user.UserID = login.User.ID
+	return user, nil
+}
+
+func postJSON(ctx context.Context, url string, body interface{}, out interface{}) (int, error) {
+	resp, err := userServiceClient.PostJSON(ctx, url, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, err
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,9 @@
+//go:build !dev
+
+package api
+
+import "github.com/gin-gonic/gin"
+
+// registerDevRoutes is a no-op in regular builds; build with -tags dev to
+// enable the data seeding endpoint
+func (h *Handler) registerDevRoutes(r *gin.Engine) {}
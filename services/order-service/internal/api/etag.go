@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orderETag returns a strong entity tag for the stored state of the order.
+// Any change to the order, which always moves updated_at, changes the tag.
+func orderETag(order contracts.Order) string {
+	data, _ := json.Marshal(order)
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header value
+// lists etag or is "*". Weak tags only match when weak comparison is asked
+// for, as for If-None-Match.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[len("W/"):]
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified answers 304 when the If-None-Match header matches the order
+func notModified(c *gin.Context, order contracts.Order) bool {
+	etag := orderETag(order)
+	c.Header("ETag", etag)
+	header := c.GetHeader("If-None-Match")
+	if header == "" || !etagMatches(header, etag, true) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+// ifMatch checks the If-Match header against the current order, answering
+// 412 when it does not match. The returned base is the updated_at the write
+// must still see for the precondition to hold, zero when the request is
+// unconditional.
+func ifMatch(c *gin.Context, order contracts.Order) (time.Time, bool) {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return time.Time{}, true
+	}
+	etag := orderETag(order)
+	if !etagMatches(header, etag, false) {
+		preconditionFailed(c, etag)
+		return time.Time{}, false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return time.Time{}, true
+	}
+	return order.UpdatedAt, true
+}
+
+// conditionalConflict answers 412 when a write guarded by If-Match lost to a
+// concurrent change, which the client could not have seen
+func conditionalConflict(c *gin.Context, err error, base time.Time) bool {
+	if base.IsZero() || !errors.Is(err, repository.ErrConflict) {
+		return false
+	}
+	preconditionFailed(c, "")
+	return true
+}
+
+func preconditionFailed(c *gin.Context, etag string) {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Order has changed since it was read"})
+}
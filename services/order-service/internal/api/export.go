@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// exportFlushEvery is how many orders are written between flushes, so the
+// client starts receiving rows before the cursor is exhausted
+const exportFlushEvery = 100
+
+// exportColumns is the CSV header; there is one row per line item
+var exportColumns = []string{
+	"order_id", "created_at", "updated_at", "status", "currency", "order_total",
+	"subtotal", "tax_amount", "shipping_amount", "discount_amount",
+	"product_id", "sku", "name", "quantity", "unit_price", "line_total", "cancelled_at",
+}
+
+// exportUserOrders streams every order of a user, oldest first, as CSV (one
+// row per line item) or NDJSON (one order per line). Rows are written as
+// they are read from the database, so the full history is never held in
+// memory. Nothing is sent until the first order has been read; a failure
+// after that can only truncate the response.
+//
+//	GET /api/orders/user/:userId/export?format=csv|ndjson
+func (h *Handler) exportUserOrders(c *gin.Context) {
+	userID := c.Param("userId")
+
+	// Users can only export their own orders
+	if c.GetString(middleware.ContextUserID) != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	format := c.DefaultQuery("format", "csv")
+	enc, ok := newExportEncoder(ctx, c.Writer, format, present)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	started := false
+	start := func() {
+		started = true
+		c.Header("Content-Type", enc.contentType)
+		c.Header("Content-Disposition", `attachment; filename="orders-`+userID+`.`+format+`"`)
+		c.Status(http.StatusOK)
+	}
+
+	exported := 0
+	err := h.orders.EachByUser(ctx, userID, func(order contracts.Order) error {
+		if !started {
+			start()
+		}
+		if err := enc.encode(order); err != nil {
+			return err
+		}
+		exported++
+		if exported%exportFlushEvery == 0 {
+			if err := enc.flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		start()
+	}
+	if err == nil {
+		err = enc.flush()
+	}
+
+	switch {
+	case err == nil:
+		log.Ctx(c.Request.Context()).Info().Str("user_id", userID).Str("format", format).Int("orders", exported).Msg("Orders exported")
+	case started:
+		// The status line is already out, so the client can only be left
+		// with a truncated body
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Int("exported", exported).Msg("Order export interrupted")
+		c.Abort()
+	case middleware.RequestFailed(c, err):
+	default:
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Msg("Failed to export orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export orders"})
+	}
+}
+
+// exportContentTypes are the export formats and their content types
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportEncoder writes orders in one of the export formats
+type exportEncoder struct {
+	contentType string
+	encode      func(contracts.Order) error
+	// flush writes out what is buffered, and the CSV header when no order
+	// was encoded yet
+	flush func() error
+}
+
+// newExportEncoder returns the encoder of format, csv or ndjson, writing
+// orders to w as present renders them, or false for any other format
+func newExportEncoder(ctx context.Context, w io.Writer, format string, present presentation) (exportEncoder, bool) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := exportColumns
+		writeHeader := func() {
+			if header != nil {
+				cw.Write(header)
+				header = nil
+			}
+		}
+		return exportEncoder{
+			contentType: exportContentTypes[format],
+			encode: func(order contracts.Order) error {
+				writeHeader()
+				for _, row := range exportRows(present.order(ctx, order).Order) {
+					cw.Write(row)
+				}
+				return cw.Error()
+			},
+			flush: func() error {
+				writeHeader()
+				cw.Flush()
+				return cw.Error()
+			},
+		}, true
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		return exportEncoder{
+			contentType: exportContentTypes[format],
+			encode: func(order contracts.Order) error {
+				return enc.Encode(present.order(ctx, order))
+			},
+			flush: func() error { return nil },
+		}, true
+	}
+	return exportEncoder{}, false
+}
+
+// exportRows renders an order as CSV rows, one per line item
+func exportRows(order contracts.Order) [][]string {
+	cancelledAt := ""
+	if order.CancelledAt != nil {
+		cancelledAt = order.CancelledAt.In(order.CreatedAt.Location()).Format(time.RFC3339)
+	}
+
+	rows := make([][]string, 0, len(order.Items))
+	for _, item := range order.Items {
+		rows = append(rows, []string{
+			order.OrderID,
+			order.CreatedAt.Format(time.RFC3339),
+			order.UpdatedAt.Format(time.RFC3339),
+			order.Status,
+			order.TotalAmount.Currency,
+			order.TotalAmount.Decimal(),
+			order.Subtotal.Decimal(),
+			order.TaxAmount.Decimal(),
+			order.ShippingAmount.Decimal(),
+			order.DiscountAmount.Decimal(),
+			item.ProductID,
+			item.SKU,
+			item.Name,
+			strconv.Itoa(item.Quantity),
+			item.Price.Decimal(),
+			item.Price.Mul(int64(item.Quantity)).Decimal(),
+			cancelledAt,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/jobs"
+	"order-service/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// JobExportOrders is the job type of background order exports
+const JobExportOrders = "orders.export"
+
+// ExportJobs queues background exports and reads their status back; it is
+// implemented by *jobs.Queue
+type ExportJobs interface {
+	Enqueue(ctx context.Context, jobType string, payload interface{}) (jobs.Job, error)
+	Job(ctx context.Context, id primitive.ObjectID) (jobs.Job, error)
+}
+
+// ExportStore keeps the files of background exports; it is implemented by
+// *objectstore.S3
+type ExportStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// exportJob is the payload of JobExportOrders: what to export and how to
+// present it, as the request asked
+type exportJob struct {
+	UserID   string `bson:"user_id"`
+	Format   string `bson:"format"`
+	Timezone string `bson:"timezone"`
+	Currency string `bson:"currency,omitempty"`
+}
+
+// ExportJobResponse is the status of a background export
+type ExportJobResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Format string `json:"format"`
+	// Attempts counts the attempts started; failed ones are retried
+	Attempts   int        `json:"attempts"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Error is why the last attempt failed
+	Error string `json:"error,omitempty"`
+	// DownloadURL serves the file once the export is done
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// exportKey is where the file of export job id is kept
+func exportKey(id primitive.ObjectID, format string) string {
+	return "exports/orders/" + id.Hex() + "." + format
+}
+
+// startExport queues an export of every order of a user, in the format and
+// presentation of exportUserOrders, for histories too long to download in
+// one request. The export is polled through its status URL.
+//
+//	POST /api/orders/user/:userId/exports?format=csv|ndjson
+func (h *Handler) startExport(c *gin.Context) {
+	userID := c.Param("userId")
+	if c.GetString(middleware.ContextUserID) != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if exportContentTypes[format] == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ndjson"})
+		return
+	}
+
+	payload := exportJob{UserID: userID, Format: format, Timezone: present.loc.String(), Currency: present.currency}
+	job, err := h.opts.ExportJobs.Enqueue(c.Request.Context(), JobExportOrders, payload)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Msg("Failed to queue order export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue export"})
+		return
+	}
+	response := exportJobResponse(job, payload)
+	c.Header("Location", exportPath(userID, job.ID))
+	c.JSON(http.StatusAccepted, response)
+}
+
+// getExport returns the status of one of the user's exports
+//
+//	GET /api/orders/user/:userId/exports/:exportId
+func (h *Handler) getExport(c *gin.Context) {
+	job, payload, ok := h.userExport(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, exportJobResponse(job, payload))
+}
+
+// downloadExport serves the file of one of the user's finished exports
+//
+//	GET /api/orders/user/:userId/exports/:exportId/download
+func (h *Handler) downloadExport(c *gin.Context) {
+	job, payload, ok := h.userExport(c)
+	if !ok {
+		return
+	}
+	if job.Status != jobs.StatusDone {
+		c.JSON(http.StatusConflict, gin.H{"error": "Export is " + job.Status, "export": exportJobResponse(job, payload)})
+		return
+	}
+	body, err := h.opts.ExportStore.Get(c.Request.Context(), exportKey(job.ID, payload.Format))
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("export_id", job.ID.Hex()).Msg("Failed to read order export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read export"})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="orders-`+payload.UserID+`.`+payload.Format+`"`)
+	c.Data(http.StatusOK, exportContentTypes[payload.Format], body)
+}
+
+// userExport reads the export job of the request, answering 404 for jobs
+// that are not exports of the caller's orders
+func (h *Handler) userExport(c *gin.Context) (jobs.Job, exportJob, bool) {
+	userID := c.Param("userId")
+	if c.GetString(middleware.ContextUserID) != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return jobs.Job{}, exportJob{}, false
+	}
+	id, err := primitive.ObjectIDFromHex(c.Param("exportId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return jobs.Job{}, exportJob{}, false
+	}
+
+	job, err := h.opts.ExportJobs.Job(c.Request.Context(), id)
+	var payload exportJob
+	if err == nil && job.Type == JobExportOrders {
+		err = job.Decode(&payload)
+	}
+	switch {
+	case errors.Is(err, jobs.ErrNotFound) || (err == nil && (job.Type != JobExportOrders || payload.UserID != userID)):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+	case err == nil:
+		return job, payload, true
+	case middleware.RequestFailed(c, err):
+	default:
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("export_id", id.Hex()).Msg("Failed to get order export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get export"})
+	}
+	return jobs.Job{}, exportJob{}, false
+}
+
+func exportPath(userID string, id primitive.ObjectID) string {
+	return "/api/orders/user/" + userID + "/exports/" + id.Hex()
+}
+
+func exportJobResponse(job jobs.Job, payload exportJob) ExportJobResponse {
+	response := ExportJobResponse{
+		ID:         job.ID.Hex(),
+		Status:     job.Status,
+		Format:     payload.Format,
+		Attempts:   job.Attempts,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+		Error:      job.LastError,
+	}
+	if job.Status == jobs.StatusDone {
+		response.DownloadURL = exportPath(payload.UserID, job.ID) + "/download"
+	}
+	return response
+}
+
+// RunExportJob is the Handler of JobExportOrders jobs: it writes the file
+// to ExportStore, where the download endpoint reads it from. The file is
+// built in memory before it is stored.
+func (h *Handler) RunExportJob(ctx context.Context, job jobs.Job) error {
+	var payload exportJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	present := presentation{loc: time.UTC}
+	if payload.Timezone != "" {
+		loc, err := time.LoadLocation(payload.Timezone)
+		if err != nil {
+			return jobs.Permanent(err)
+		}
+		present.loc = loc
+	}
+	if payload.Currency != "" && h.opts.Currency != nil {
+		present.currency, present.convert = payload.Currency, h.opts.Currency
+	}
+
+	var buf bytes.Buffer
+	enc, ok := newExportEncoder(ctx, &buf, payload.Format, present)
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("unknown export format %q", payload.Format))
+	}
+	exported := 0
+	err := h.orders.EachByUser(ctx, payload.UserID, func(order contracts.Order) error {
+		exported++
+		return enc.encode(order)
+	})
+	if err == nil {
+		err = enc.flush()
+	}
+	if err != nil {
+		return err
+	}
+	if err := h.opts.ExportStore.Put(ctx, exportKey(job.ID, payload.Format), buf.Bytes(), enc.contentType); err != nil {
+		return err
+	}
+	log.Info().Str("export_id", job.ID.Hex()).Str("user_id", payload.UserID).Str("format", payload.Format).Int("orders", exported).Msg("Orders exported")
+	return nil
+}
@@ -0,0 +1,584 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// graphqlSchema exposes orders read-only. The filter fields take the same
+// values as the query parameters of GET /api/orders/search.
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	# order returns one order, or null when it does not exist or belongs to
+	# another user
+	order(id: ID!): Order
+	# orders pages through the caller's orders; staff see every order and
+	# may filter by userId
+	orders(filter: OrderFilter, limit: Int, offset: Int, sort: String): OrderPage!
+}
+
+input OrderFilter {
+	status: [String!]
+	userId: String
+	productId: String
+	createdAfter: Time
+	createdBefore: Time
+	totalCurrency: String
+	minTotal: String
+	maxTotal: String
+}
+
+type PageInfo {
+	limit: Int!
+	offset: Int!
+	hasNextPage: Boolean!
+}
+
+type OrderPage {
+	totalCount: Int!
+	nodes: [Order!]!
+	pageInfo: PageInfo!
+}
+
+type Order {
+	id: ID!
+	orderId: String!
+	userId: String!
+	status: String!
+	items(limit: Int, offset: Int): OrderItemPage!
+	subtotal: Money!
+	taxAmount: Money!
+	shippingAmount: Money!
+	discountAmount: Money!
+	totalAmount: Money!
+	createdAt: Time!
+	updatedAt: Time!
+	cancelledAt: Time
+	cancellationReason: String
+	statusHistory: [StatusHistoryEntry!]!
+	shippingAddress: Address
+	isGift: Boolean!
+	giftMessage: String
+	priority: String!
+	notes: [Note!]!
+	shipments: [Shipment!]!
+}
+
+type OrderItemPage {
+	totalCount: Int!
+	nodes: [OrderItem!]!
+	pageInfo: PageInfo!
+}
+
+type OrderItem {
+	productId: String!
+	name: String!
+	sku: String
+	quantity: Int!
+	unitPrice: Money!
+	lineTotal: Money!
+}
+
+type Money {
+	amount: String!
+	currency: String!
+}
+
+type StatusHistoryEntry {
+	from: String!
+	to: String!
+	actorId: String
+	at: Time!
+	reason: String
+}
+
+type Address {
+	name: String!
+	line1: String!
+	line2: String
+	city: String!
+	region: String
+	postalCode: String
+	country: String!
+}
+
+type Note {
+	text: String!
+	authorId: String!
+	at: Time!
+}
+
+type Shipment {
+	id: ID!
+	status: String!
+	items: [ShipmentItem!]!
+	carrier: String
+	trackingNumber: String
+	createdAt: Time!
+	shippedAt: Time
+	deliveredAt: Time
+}
+
+type ShipmentItem {
+	productId: String!
+	quantity: Int!
+}
+`
+
+const (
+	// graphqlMaxDepth bounds query nesting; the schema itself is four
+	// levels deep
+	graphqlMaxDepth = 8
+	// graphqlMaxParallelism bounds how many resolvers one query runs at once
+	graphqlMaxParallelism = 10
+)
+
+// graphqlRequest is the standard GraphQL-over-HTTP request body
+type graphqlRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlCaller is who the query runs for, taken from the auth middleware
+type graphqlCaller struct {
+	userID string
+	admin  bool
+	loc    *time.Location
+}
+
+type graphqlCallerKey struct{}
+
+func callerFrom(ctx context.Context) graphqlCaller {
+	caller, _ := ctx.Value(graphqlCallerKey{}).(graphqlCaller)
+	return caller
+}
+
+// newGraphQLSchema parses the schema with resolvers backed by orders
+func newGraphQLSchema(orders OrderService) *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &queryResolver{orders: orders},
+		graphql.MaxDepth(graphqlMaxDepth),
+		graphql.MaxParallelism(graphqlMaxParallelism),
+	)
+}
+
+// serveGraphQL runs a query for the authenticated user. Like any GraphQL
+// endpoint it answers 200 with an errors list when resolvers fail.
+//
+//	POST /graphql
+func (h *Handler) serveGraphQL(c *gin.Context) {
+	var req graphqlRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request must be JSON with a query"})
+		return
+	}
+	loc, ok := responseLocation(c)
+	if !ok {
+		return
+	}
+	caller := graphqlCaller{
+		userID: c.GetString(middleware.ContextUserID),
+		admin:  middleware.HasScope(c, middleware.ScopeOrdersFulfill),
+		loc:    loc,
+	}
+	if caller.userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphqlCallerKey{}, caller)
+	c.JSON(http.StatusOK, h.graphql.Exec(ctx, req.Query, req.OperationName, req.Variables))
+}
+
+// queryResolver resolves the Query type
+type queryResolver struct {
+	orders OrderService
+}
+
+func (r *queryResolver) Order(ctx context.Context, args struct{ ID graphql.ID }) (*orderResolver, error) {
+	objectID, err := primitive.ObjectIDFromHex(string(args.ID))
+	if err != nil {
+		return nil, errors.New("Invalid order ID")
+	}
+
+	caller := callerFrom(ctx)
+	order, err := r.orders.Get(ctx, objectID)
+	if err == repository.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", string(args.ID)).Msg("Failed to get order for GraphQL")
+		return nil, errors.New("Failed to get order")
+	}
+	// Customers may only see their own orders
+	if order.UserID != caller.userID && !caller.admin {
+		return nil, nil
+	}
+	return &orderResolver{order: order, loc: caller.loc}, nil
+}
+
+// graphqlOrderFilter mirrors the search query parameters
+type graphqlOrderFilter struct {
+	Status        *[]string
+	UserID        *string
+	ProductID     *string
+	CreatedAfter  *graphql.Time
+	CreatedBefore *graphql.Time
+	TotalCurrency *string
+	MinTotal      *string
+	MaxTotal      *string
+}
+
+// get returns the filter as the search query parameters
+func (f *graphqlOrderFilter) get(name string) string {
+	if f == nil {
+		return ""
+	}
+	str := func(v *string) string {
+		if v == nil {
+			return ""
+		}
+		return *v
+	}
+	when := func(v *graphql.Time) string {
+		if v == nil {
+			return ""
+		}
+		return v.Format(time.RFC3339Nano)
+	}
+	switch name {
+	case "status":
+		if f.Status == nil {
+			return ""
+		}
+		return strings.Join(*f.Status, ",")
+	case "user_id":
+		return str(f.UserID)
+	case "product_id":
+		return str(f.ProductID)
+	case "createdAfter":
+		return when(f.CreatedAfter)
+	case "createdBefore":
+		return when(f.CreatedBefore)
+	case "total_currency":
+		return str(f.TotalCurrency)
+	case "min_total":
+		return str(f.MinTotal)
+	case "max_total":
+		return str(f.MaxTotal)
+	}
+	return ""
+}
+
+func (r *queryResolver) Orders(ctx context.Context, args struct {
+	Filter *graphqlOrderFilter
+	Limit  *int32
+	Offset *int32
+	Sort   *string
+}) (*orderPageResolver, error) {
+	filter, err := parseOrderFilter(args.Filter.get, "createdAfter", "createdBefore")
+	if err != nil {
+		return nil, err
+	}
+	caller := callerFrom(ctx)
+	if !caller.admin {
+		filter.UserID = caller.userID
+	}
+
+	q, err := pageArgs(args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if args.Sort != nil {
+		if q.SortBy, q.Desc, err = parseSort(*args.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	page, err := r.orders.List(ctx, filter, q)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("user_id", filter.UserID).Msg("Failed to search orders for GraphQL")
+		return nil, errors.New("Failed to search orders")
+	}
+	nodes := make([]*orderResolver, 0, len(page.Orders))
+	for _, order := range page.Orders {
+		nodes = append(nodes, &orderResolver{order: order, loc: caller.loc})
+	}
+	return &orderPageResolver{
+		nodes: nodes,
+		total: page.Total,
+		info:  pageInfo{limit: q.Limit, offset: q.Offset, hasNext: int64(q.Offset+len(nodes)) < page.Total && len(nodes) > 0},
+	}, nil
+}
+
+// pageArgs reads limit and offset arguments with the same defaults and
+// bounds as the REST listings; newest first unless sorted otherwise
+func pageArgs(limit, offset *int32) (repository.PageQuery, error) {
+	q := repository.PageQuery{Limit: defaultPageLimit, SortBy: repository.SortCreatedAt, Desc: true}
+	if limit != nil {
+		if *limit < 1 || *limit > maxPageLimit {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		q.Limit = int(*limit)
+	}
+	if offset != nil {
+		if *offset < 0 {
+			return q, errors.New("offset must be a non-negative integer")
+		}
+		q.Offset = int(*offset)
+	}
+	return q, nil
+}
+
+// pageInfo resolves the PageInfo type
+type pageInfo struct {
+	limit, offset int
+	hasNext       bool
+}
+
+func (p pageInfo) Limit() int32      { return int32(p.limit) }
+func (p pageInfo) Offset() int32     { return int32(p.offset) }
+func (p pageInfo) HasNextPage() bool { return p.hasNext }
+
+type orderPageResolver struct {
+	nodes []*orderResolver
+	total int64
+	info  pageInfo
+}
+
+func (p *orderPageResolver) TotalCount() int32       { return int32(p.total) }
+func (p *orderPageResolver) Nodes() []*orderResolver { return p.nodes }
+func (p *orderPageResolver) PageInfo() pageInfo      { return p.info }
+
+// orderResolver resolves the Order type, rendering times in the caller's
+// zone
+type orderResolver struct {
+	order contracts.Order
+	loc   *time.Location
+}
+
+func (o *orderResolver) ID() graphql.ID                { return graphql.ID(o.order.ID.Hex()) }
+func (o *orderResolver) OrderID() string               { return o.order.OrderID }
+func (o *orderResolver) UserID() string                { return o.order.UserID }
+func (o *orderResolver) Status() string                { return o.order.Status }
+func (o *orderResolver) Subtotal() moneyResolver       { return moneyResolver{o.order.Subtotal} }
+func (o *orderResolver) TaxAmount() moneyResolver      { return moneyResolver{o.order.TaxAmount} }
+func (o *orderResolver) ShippingAmount() moneyResolver { return moneyResolver{o.order.ShippingAmount} }
+func (o *orderResolver) DiscountAmount() moneyResolver { return moneyResolver{o.order.DiscountAmount} }
+func (o *orderResolver) TotalAmount() moneyResolver    { return moneyResolver{o.order.TotalAmount} }
+func (o *orderResolver) CreatedAt() graphql.Time       { return o.time(o.order.CreatedAt) }
+func (o *orderResolver) UpdatedAt() graphql.Time       { return o.time(o.order.UpdatedAt) }
+func (o *orderResolver) CancellationReason() *string   { return optional(o.order.CancellationReason) }
+func (o *orderResolver) IsGift() bool                  { return o.order.IsGift }
+func (o *orderResolver) GiftMessage() *string          { return optional(o.order.GiftMessage) }
+func (o *orderResolver) Priority() string              { return o.order.EffectivePriority() }
+
+func (o *orderResolver) CancelledAt() *graphql.Time {
+	if o.order.CancelledAt == nil {
+		return nil
+	}
+	t := o.time(*o.order.CancelledAt)
+	return &t
+}
+
+func (o *orderResolver) time(t time.Time) graphql.Time {
+	return graphql.Time{Time: t.In(o.loc)}
+}
+
+// Items pages through the order's line items, which are already loaded
+func (o *orderResolver) Items(args struct {
+	Limit  *int32
+	Offset *int32
+}) (*orderItemPageResolver, error) {
+	q, err := pageArgs(args.Limit, args.Offset)
+	if err != nil {
+		return nil, err
+	}
+	items := o.order.Items
+	start := q.Offset
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + q.Limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return &orderItemPageResolver{
+		nodes: items[start:end],
+		total: len(items),
+		info:  pageInfo{limit: q.Limit, offset: q.Offset, hasNext: end < len(items)},
+	}, nil
+}
+
+func (o *orderResolver) StatusHistory() []statusHistoryResolver {
+	history := make([]statusHistoryResolver, 0, len(o.order.StatusHistory))
+	for _, entry := range o.order.StatusHistory {
+		entry.At = entry.At.In(o.loc)
+		history = append(history, statusHistoryResolver{entry})
+	}
+	return history
+}
+
+func (o *orderResolver) ShippingAddress() *addressResolver {
+	if o.order.ShippingAddress == nil {
+		return nil
+	}
+	return &addressResolver{*o.order.ShippingAddress}
+}
+
+func (o *orderResolver) Notes() []noteResolver {
+	notes := make([]noteResolver, 0, len(o.order.Notes))
+	for _, note := range o.order.Notes {
+		note.At = note.At.In(o.loc)
+		notes = append(notes, noteResolver{note})
+	}
+	return notes
+}
+
+func (o *orderResolver) Shipments() []shipmentResolver {
+	shipments := make([]shipmentResolver, 0, len(o.order.Shipments))
+	for _, shipment := range o.order.Shipments {
+		shipments = append(shipments, shipmentResolver{shipment, o.loc})
+	}
+	return shipments
+}
+
+type orderItemPageResolver struct {
+	nodes []contracts.OrderItem
+	total int
+	info  pageInfo
+}
+
+func (p *orderItemPageResolver) TotalCount() int32  { return int32(p.total) }
+func (p *orderItemPageResolver) PageInfo() pageInfo { return p.info }
+
+func (p *orderItemPageResolver) Nodes() []orderItemResolver {
+	nodes := make([]orderItemResolver, 0, len(p.nodes))
+	for _, item := range p.nodes {
+		nodes = append(nodes, orderItemResolver{item})
+	}
+	return nodes
+}
+
+// orderItemResolver resolves the OrderItem type
+type orderItemResolver struct {
+	item contracts.OrderItem
+}
+
+func (i orderItemResolver) ProductID() string        { return i.item.ProductID }
+func (i orderItemResolver) Name() string             { return i.item.Name }
+func (i orderItemResolver) Sku() *string             { return optional(i.item.SKU) }
+func (i orderItemResolver) Quantity() int32          { return int32(i.item.Quantity) }
+func (i orderItemResolver) UnitPrice() moneyResolver { return moneyResolver{i.item.Price} }
+func (i orderItemResolver) LineTotal() moneyResolver {
+	return moneyResolver{i.item.Price.Mul(int64(i.item.Quantity))}
+}
+
+// moneyResolver resolves the Money type; amounts are exact decimal strings
+// as in the REST API
+type moneyResolver struct {
+	m money.Money
+}
+
+func (m moneyResolver) Amount() string   { return m.m.Decimal() }
+func (m moneyResolver) Currency() string { return m.m.Currency }
+
+// statusHistoryResolver resolves the StatusHistoryEntry type
+type statusHistoryResolver struct {
+	entry contracts.StatusHistoryEntry
+}
+
+func (e statusHistoryResolver) From() string     { return e.entry.From }
+func (e statusHistoryResolver) To() string       { return e.entry.To }
+func (e statusHistoryResolver) ActorID() *string { return optional(e.entry.ActorID) }
+func (e statusHistoryResolver) At() graphql.Time { return graphql.Time{Time: e.entry.At} }
+func (e statusHistoryResolver) Reason() *string  { return optional(e.entry.Reason) }
+
+// addressResolver resolves the Address type
+type addressResolver struct {
+	a contracts.Address
+}
+
+func (a *addressResolver) Name() string        { return a.a.Name }
+func (a *addressResolver) Line1() string       { return a.a.Line1 }
+func (a *addressResolver) Line2() *string      { return optional(a.a.Line2) }
+func (a *addressResolver) City() string        { return a.a.City }
+func (a *addressResolver) Region() *string     { return optional(a.a.Region) }
+func (a *addressResolver) PostalCode() *string { return optional(a.a.PostalCode) }
+func (a *addressResolver) Country() string     { return a.a.Country }
+
+// noteResolver resolves the Note type
+type noteResolver struct {
+	note contracts.Note
+}
+
+func (n noteResolver) Text() string     { return n.note.Text }
+func (n noteResolver) AuthorID() string { return n.note.AuthorID }
+func (n noteResolver) At() graphql.Time { return graphql.Time{Time: n.note.At} }
+
+// shipmentResolver resolves the Shipment type
+type shipmentResolver struct {
+	shipment contracts.Shipment
+	loc      *time.Location
+}
+
+func (s shipmentResolver) ID() graphql.ID          { return graphql.ID(s.shipment.ID) }
+func (s shipmentResolver) Status() string          { return s.shipment.Status }
+func (s shipmentResolver) Carrier() *string        { return optional(s.shipment.Carrier) }
+func (s shipmentResolver) TrackingNumber() *string { return optional(s.shipment.TrackingNumber) }
+func (s shipmentResolver) CreatedAt() graphql.Time {
+	return graphql.Time{Time: s.shipment.CreatedAt.In(s.loc)}
+}
+func (s shipmentResolver) ShippedAt() *graphql.Time   { return s.optionalTime(s.shipment.ShippedAt) }
+func (s shipmentResolver) DeliveredAt() *graphql.Time { return s.optionalTime(s.shipment.DeliveredAt) }
+
+func (s shipmentResolver) Items() []shipmentItemResolver {
+	items := make([]shipmentItemResolver, 0, len(s.shipment.Items))
+	for _, item := range s.shipment.Items {
+		items = append(items, shipmentItemResolver{item})
+	}
+	return items
+}
+
+func (s shipmentResolver) optionalTime(t *time.Time) *graphql.Time {
+	if t == nil {
+		return nil
+	}
+	return &graphql.Time{Time: t.In(s.loc)}
+}
+
+// shipmentItemResolver resolves the ShipmentItem type
+type shipmentItemResolver struct {
+	item contracts.ShipmentItem
+}
+
+func (i shipmentItemResolver) ProductID() string { return i.item.ProductID }
+func (i shipmentItemResolver) Quantity() int32   { return int32(i.item.Quantity) }
+
+// optional maps an empty string to null
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
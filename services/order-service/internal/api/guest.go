@@ -0,0 +1,146 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Guest checkout lets storefronts place orders for customers without an
+// account. A guest order belongs to a pseudonymous user derived from the
+// email address, so idempotency keys stay per customer without the address
+// appearing in user IDs and logs. Its status is checked with a lookup token
+// signed for the order ID, sent as HeaderOrderToken or the token query
+// parameter, so order confirmation emails can link to it.
+const (
+	// HeaderOrderToken carries a guest order's lookup token
+	HeaderOrderToken = "X-Order-Token"
+	// guestUserPrefix marks the user IDs of guest orders
+	guestUserPrefix = "guest_"
+	// MinGuestSecretLength is the shortest GuestSecret guest checkout runs
+	// with
+	MinGuestSecretLength = 32
+)
+
+// guestOrderResponse is a guest order with its lookup token
+type guestOrderResponse struct {
+	Order orderResponse `json:"order"`
+	Token string        `json:"token"`
+}
+
+// createGuestOrder places an order without a JWT. The body is a create
+// request plus the customer's email address.
+//
+//	POST /api/guest/orders
+//	{"email": "...", "items": [...]}
+func (h *Handler) createGuestOrder(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	var req contracts.GuestOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	req.CreateOrderRequest.GuestEmail = email
+	order, ok := h.placeOrder(c, h.guestUserID(email), req.CreateOrderRequest)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, guestOrderResponse{
+		Order: present.order(c.Request.Context(), order),
+		Token: h.guestToken(order.ID),
+	})
+}
+
+// getGuestOrder returns a guest order to the holder of its lookup token.
+// Wrong tokens, and orders of registered users, are reported as not found.
+//
+//	GET /api/guest/orders/:id?token=...
+func (h *Handler) getGuestOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	token := c.GetHeader(HeaderOrderToken)
+	if token == "" {
+		token = c.Query("token")
+	}
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Order token required"})
+		return
+	}
+	if !hmac.Equal([]byte(token), []byte(h.guestToken(objectID))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.GuestEmail == "" {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to get guest order")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
+		return
+	}
+	if notModified(c, order) {
+		return
+	}
+
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+// guestUserID is the pseudonymous user of the guest orders placed with email
+func (h *Handler) guestUserID(email string) string {
+	return guestUserPrefix + h.guestMAC("user:" + email)[:32]
+}
+
+// guestToken is the lookup token of the guest order id
+func (h *Handler) guestToken(id primitive.ObjectID) string {
+	return h.guestMAC("order:" + id.Hex())
+}
+
+// guestCheckout reports whether guest checkout is enabled: with no secret,
+// or a short one, lookup tokens could be forged
+func (h *Handler) guestCheckout() bool {
+	return len(h.opts.GuestSecret) >= MinGuestSecretLength
+}
+
+func (h *Handler) guestMAC(message string) string {
+	mac := hmac.New(sha256.New, h.opts.GuestSecret)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
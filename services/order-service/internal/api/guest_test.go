@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	fixtures "order-service/pkg/testing"
+)
+
+func TestGuestRoutesNeedSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		want   int
+	}{
+		{name: "no secret", secret: nil, want: http.StatusNotFound},
+		{name: "empty secret", secret: []byte{}, want: http.StatusNotFound},
+		{name: "short secret", secret: []byte("short"), want: http.StatusNotFound},
+		{name: "secret", secret: []byte(strings.Repeat("s", MinGuestSecretLength)), want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t, Options{GuestSecret: tt.secret})
+
+			w := fixtures.NewRequest(http.MethodPost, "/api/guest/orders").
+				WithJSON(map[string]interface{}{
+					"email": "guest@example.com",
+					"items": fixtures.NewOrder().CreateRequest().Items,
+				}).
+				Do(t, api.router)
+			if w.Code != tt.want {
+				t.Fatalf("create: status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+
+			if tt.want != http.StatusNotFound {
+				return
+			}
+			order := fixtures.NewOrder().Build()
+			w = fixtures.NewRequest(http.MethodGet, "/api/guest/orders/"+order.ID.Hex()).
+				WithHeader(HeaderOrderToken, "token").
+				Do(t, api.router)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("lookup: status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestGuestOrderLookup(t *testing.T) {
+	secret := []byte(strings.Repeat("s", MinGuestSecretLength))
+	registered := fixtures.NewOrder().Build()
+	api := newTestAPI(t, Options{GuestSecret: secret}, registered)
+
+	// guest places a guest order and returns its ID and lookup token
+	guest := func() (string, string) {
+		w := fixtures.NewRequest(http.MethodPost, "/api/guest/orders").
+			WithJSON(map[string]interface{}{
+				"email": "guest@example.com",
+				"items": fixtures.NewOrder().CreateRequest().Items,
+			}).
+			Do(t, api.router)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: status = %d: %s", w.Code, w.Body)
+		}
+		var created struct {
+			Order struct {
+				ID string `json:"id"`
+			} `json:"order"`
+			Token string `json:"token"`
+		}
+		fixtures.DecodeJSON(t, w, &created)
+		return created.Order.ID, created.Token
+	}
+	id, token := guest()
+	otherID, otherToken := guest()
+
+	// A registered user's order is never a guest's, even with a valid token
+	registeredToken := NewHandler(Options{GuestSecret: secret}, nil, nil, nil).guestToken(registered.ID)
+
+	tests := []struct {
+		name  string
+		id    string
+		token string
+		want  int
+	}{
+		{name: "own token", id: id, token: token, want: http.StatusOK},
+		{name: "no token", id: id, want: http.StatusUnauthorized},
+		{name: "wrong token", id: id, token: "not-the-token", want: http.StatusNotFound},
+		{name: "token of another order", id: id, token: otherToken, want: http.StatusNotFound},
+		{name: "other order with its token", id: otherID, token: otherToken, want: http.StatusOK},
+		{name: "registered user's order", id: registered.ID.Hex(), token: registeredToken, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := fixtures.NewRequest(http.MethodGet, "/api/guest/orders/"+tt.id)
+			if tt.token != "" {
+				req = req.WithHeader(HeaderOrderToken, tt.token)
+			}
+			if w := req.Do(t, api.router); w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
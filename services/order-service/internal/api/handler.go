@@ -0,0 +1,464 @@
+// Package api exposes the order service over HTTP. Handlers hold no globals:
+// everything they use is injected through NewHandler.
+package api
+
+import (
+	"context"
+	"crypto"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/audit"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/errreport"
+	"order-service/pkg/events"
+	"order-service/pkg/featureflags"
+	"order-service/pkg/health"
+	"order-service/pkg/jwks"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrderService is the business logic the handlers depend on; it is
+// implemented by *service.OrderService
+type OrderService interface {
+	Create(ctx context.Context, userID string, req contracts.CreateOrderRequest) (contracts.Order, error)
+	CreateIdempotent(ctx context.Context, userID, key string, req contracts.CreateOrderRequest) (contracts.Order, bool, error)
+	CreateBulk(ctx context.Context, userID string, reqs []contracts.CreateOrderRequest) ([]service.BulkResult, error)
+	Get(ctx context.Context, id primitive.ObjectID) (contracts.Order, error)
+	ListByUser(ctx context.Context, userID string) ([]contracts.Order, error)
+	EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error
+	ListUserPage(ctx context.Context, userID string, q repository.PageQuery) (repository.Page, error)
+	List(ctx context.Context, filter repository.OrderFilter, q repository.PageQuery) (repository.Page, error)
+	Search(ctx context.Context, text string, filter repository.OrderFilter, q repository.PageQuery) (repository.SearchPage, error)
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error)
+	Cancel(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error)
+	RequestReturn(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error)
+	ApproveReturn(ctx context.Context, id primitive.ObjectID, refund *money.Money, change contracts.StatusChange) (contracts.Order, error)
+	RejectReturn(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error)
+	RefundReturn(ctx context.Context, id primitive.ObjectID, actor string) (contracts.Order, error)
+	EditItems(ctx context.Context, id primitive.ObjectID, edit contracts.UpdateOrderItemsRequest, base time.Time) (contracts.Order, error)
+	AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error)
+	CreateShipment(ctx context.Context, id primitive.ObjectID, req contracts.CreateShipmentRequest, actor string, base time.Time) (contracts.Order, error)
+	UpdateShipment(ctx context.Context, id primitive.ObjectID, shipmentID string, req contracts.UpdateShipmentRequest, actor string, base time.Time) (contracts.Order, error)
+	SetPriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error)
+	Delete(ctx context.Context, id primitive.ObjectID, base time.Time) (contracts.Order, error)
+	ReplayEvents(ctx context.Context, filter events.Filter) (int, error)
+}
+
+// Options configures the HTTP surface
+type Options struct {
+	JWTSecret []byte
+	// JWTSecrets, when set, replaces JWTSecret with secrets rotated at
+	// runtime; tokens signed with any of them are accepted
+	JWTSecrets func() [][]byte
+	// JWKS verifies asymmetrically signed tokens with the keys the auth
+	// service publishes; nil only accepts HMAC-signed tokens
+	JWKS *jwks.Set
+	// JWTPublicKeys verify asymmetrically signed tokens alongside JWKS
+	JWTPublicKeys []crypto.PublicKey
+	// JWTAlgorithms are the algorithms tokens may be signed with; empty
+	// accepts any the keys suit
+	JWTAlgorithms      []string
+	CORSAllowedOrigins []string
+	RateLimitRPS       float64
+	RateLimitBurst     int
+	// PactVerification stubs authentication and exposes provider states
+	PactVerification bool
+	// GuestSecret signs the lookup tokens of guest orders. Guest checkout is
+	// disabled unless it has at least MinGuestSecretLength bytes.
+	GuestSecret []byte
+	// UserServiceURL is used by the development seeding endpoint
+	UserServiceURL string
+	// Clock defaults to the system clock
+	Clock clock.Clock
+	// Currency converts totals to a client's preferred currency; nil
+	// disables conversion
+	Currency CurrencyConverter
+	// ReportingCurrency is what multi-currency aggregates are normalized to
+	// when the client does not ask for a currency
+	ReportingCurrency string
+	// Webhooks manages webhook subscriptions; nil disables the endpoints
+	Webhooks Webhooks
+	// Live streams order status updates; nil disables the SSE and
+	// WebSocket endpoints
+	Live LiveFeed
+	// Tracking limits the WebSocket channel; zero fields take
+	// DefaultTrackingLimits
+	Tracking TrackingLimits
+	// Promotions manages promotion codes; nil disables the admin endpoints
+	Promotions Promotions
+	// ReadOnly makes mutating endpoints return 503 while enabled; admins
+	// toggle it at runtime through PUT /api/admin/read-only
+	ReadOnly *middleware.ReadOnlyMode
+	// PoolExhausted, while true, makes every endpoint but health checks,
+	// metrics and the runtime switches return 503; nil never does
+	PoolExhausted func() bool
+	// Deadlines bound how long each endpoint may run until SetDeadlines
+	// replaces them; zero fields take DefaultDeadlines
+	Deadlines Deadlines
+	// V1Sunset is announced in the Sunset header of version 1 responses;
+	// zero leaves the header out
+	V1Sunset time.Time
+	// Readiness decides GET /readyz; nil is ready with no checks
+	Readiness *health.Readiness
+	// Audit records the admin operations; nil records nothing
+	Audit *audit.Log
+	// DebugLogToken, sent in X-Debug-Log, logs a request at debug level;
+	// empty ignores the header
+	DebugLogToken string
+	// Errors receives panics and 5xx responses; nil reports nothing
+	Errors errreport.ErrorReporter
+	// RequireTenant rejects callers whose token has no tenant_id claim,
+	// except on admin routes, where they act across tenants
+	RequireTenant bool
+	// Search serves the admin order listing; nil lists from the orders
+	// collection
+	Search OrderSearch
+	// UserData erases and exports the data of users; nil disables the
+	// endpoints
+	UserData UserData
+	// ExportJobs and ExportStore run order exports in the background; nil
+	// either disables the background export endpoints
+	ExportJobs  ExportJobs
+	ExportStore ExportStore
+	// TenantOrders are the orders collections of the tenants kept apart,
+	// by tenant, which their admins' stats are computed from
+	TenantOrders map[string]*mongo.Collection
+}
+
+// Deadlines are the per-endpoint request deadlines. Every endpoint belongs
+// to a class (Read, Write or Bulk) and Routes overrides single endpoints,
+// keyed by method and route as registered, e.g.
+// "POST /api/admin/events/replay".
+type Deadlines struct {
+	Read   time.Duration
+	Write  time.Duration
+	Bulk   time.Duration
+	Routes map[string]time.Duration
+}
+
+// DefaultDeadlines are used for any class left unset
+var DefaultDeadlines = Deadlines{
+	Read:  2 * time.Second,
+	Write: 5 * time.Second,
+	Bulk:  10 * time.Second,
+	Routes: map[string]time.Duration{
+		"POST /api/admin/events/replay":       5 * time.Minute,
+		"GET /api/orders/user/:userId/export": 5 * time.Minute,
+	},
+}
+
+// Handler serves the order HTTP API
+type Handler struct {
+	opts       Options
+	orders     OrderService
+	collection *mongo.Collection
+	readModels *mongo.Database
+	tracking   *trackingConnections
+	graphql    *graphql.Schema
+	// deadlines are the Deadlines in force, replaced by SetDeadlines
+	deadlines atomic.Pointer[Deadlines]
+	// pact is the provider state of the interaction being verified
+	pact *pactState
+}
+
+// NewHandler returns a handler backed by the order service. collection is
+// the raw orders collection used for health checks and test fixtures;
+// readModels is the database maintained by the projector.
+func NewHandler(opts Options, orders OrderService, collection *mongo.Collection, readModels *mongo.Database) *Handler {
+	if opts.Clock == nil {
+		opts.Clock = clock.System{}
+	}
+	if opts.ReadOnly == nil {
+		opts.ReadOnly = &middleware.ReadOnlyMode{}
+	}
+	if opts.Readiness == nil {
+		opts.Readiness = &health.Readiness{}
+	}
+	if opts.Tracking.MaxConnections == 0 {
+		opts.Tracking.MaxConnections = DefaultTrackingLimits.MaxConnections
+	}
+	if opts.Tracking.MaxConnectionsPerUser == 0 {
+		opts.Tracking.MaxConnectionsPerUser = DefaultTrackingLimits.MaxConnectionsPerUser
+	}
+	if opts.Tracking.MaxSubscriptions == 0 {
+		opts.Tracking.MaxSubscriptions = DefaultTrackingLimits.MaxSubscriptions
+	}
+	h := &Handler{
+		opts:       opts,
+		orders:     orders,
+		collection: collection,
+		readModels: readModels,
+		tracking:   &trackingConnections{},
+		graphql:    newGraphQLSchema(orders),
+		pact:       &pactState{},
+	}
+	h.SetDeadlines(opts.Deadlines)
+	return h
+}
+
+// SetDeadlines replaces the deadlines of requests from now on, as when the
+// configuration is reloaded. Zero fields take DefaultDeadlines.
+func (h *Handler) SetDeadlines(d Deadlines) {
+	if d.Read == 0 {
+		d.Read = DefaultDeadlines.Read
+	}
+	if d.Write == 0 {
+		d.Write = DefaultDeadlines.Write
+	}
+	if d.Bulk == 0 {
+		d.Bulk = DefaultDeadlines.Bulk
+	}
+	if d.Routes == nil {
+		d.Routes = DefaultDeadlines.Routes
+	}
+	h.deadlines.Store(&d)
+}
+
+// Deadlines returns the deadlines in force
+func (h *Handler) Deadlines() Deadlines {
+	return *h.deadlines.Load()
+}
+
+// Router builds the Gin engine with middleware and every route registered
+func (h *Handler) Router() *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(middleware.RequestID())
+	r.Use(middleware.DebugLogging(h.opts.DebugLogToken))
+	r.Use(middleware.ReportErrors(h.opts.Errors))
+	r.Use(middleware.Logging())
+	r.Use(middleware.Metrics())
+	r.Use(middleware.CORS(h.opts.CORSAllowedOrigins...))
+	r.Use(middleware.ReadOnly(h.opts.ReadOnly, append(versionedPaths(readOnlyPath), versionedPaths(logLevelPath)...)...))
+	r.Use(middleware.Overloaded(h.opts.PoolExhausted, append([]string{"/health", "/healthz", "/readyz", "/metrics"},
+		append(versionedPaths(readOnlyPath), versionedPaths(logLevelPath)...)...)...))
+	r.Use(replicaReads)
+
+	// Health checks: liveness for restarts, readiness for routing
+	r.GET("/health", h.deadline(readDeadline), h.healthCheck)
+	r.GET("/healthz", h.liveness)
+	r.GET("/readyz", h.deadline(readDeadline), h.readiness)
+
+	// Metrics endpoint
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// API description, generated from the handlers' structs
+	spec := h.openAPIDocument()
+	r.GET("/openapi.json", serveOpenAPI(spec))
+	r.GET("/docs", serveSwaggerUI)
+
+	// Development-only endpoints (build with -tags dev)
+	h.registerDevRoutes(r)
+
+	if h.opts.PactVerification {
+		h.registerPactRoutes(r)
+	}
+
+	// The REST API is served under every version prefix
+	for _, v := range apiVersions {
+		h.registerAPI(r.Group(v.prefix, h.versioned(v)))
+	}
+
+	// WebSocket order tracking; the token may also come as access_token
+	if h.opts.Live != nil {
+		ws := r.Group("/ws")
+		ws.Use(tokenFromQuery, h.auth(), h.tenant())
+		ws.GET("/orders", middleware.RequireScope(middleware.ScopeOrdersRead), h.trackOrders)
+	}
+
+	// GraphQL queries over the caller's orders
+	gql := r.Group("/graphql")
+	gql.Use(h.auth(), h.tenant())
+	gql.Use(middleware.RateLimit(h.opts.RateLimitRPS, h.opts.RateLimitBurst))
+	gql.POST("", middleware.RequireScope(middleware.ScopeOrdersRead), h.deadline(bulkDeadline), h.serveGraphQL)
+
+	warnUndocumented(r.Routes(), spec)
+	return r
+}
+
+// registerAPI registers the orders, webhook and admin routes under g
+func (h *Handler) registerAPI(g *gin.RouterGroup) {
+
+	// Order routes
+	api := g.Group("/orders")
+	api.Use(h.auth(), h.tenant())
+	api.Use(auditActor, flagSubject, middleware.RateLimit(h.opts.RateLimitRPS, h.opts.RateLimitBurst))
+	{
+		read := middleware.RequireScope(middleware.ScopeOrdersRead)
+		write := middleware.RequireScope(middleware.ScopeOrdersWrite)
+		fulfill := middleware.RequireScope(middleware.ScopeOrdersFulfill)
+		manage := middleware.RequireScope(middleware.ScopeOrdersAdmin)
+
+		api.POST("", write, h.deadline(writeDeadline), h.createOrder)
+		api.POST("/bulk", write, h.deadline(bulkDeadline), h.createOrders)
+		api.GET("/search", read, h.deadline(bulkDeadline), h.searchOrders)
+		api.GET("/:id", read, h.deadline(readDeadline), h.getOrder)
+		api.PATCH("/:id", write, h.deadline(writeDeadline), h.updateOrderItems)
+		api.DELETE("/:id", manage, h.deadline(writeDeadline), h.deleteOrder)
+		api.GET("/user/:userId", read, h.deadline(bulkDeadline), h.getUserOrders)
+		api.GET("/user/:userId/summary", read, h.deadline(readDeadline), h.getUserSummary)
+		api.GET("/user/:userId/export", read, h.deadline(bulkDeadline), h.exportUserOrders)
+		if h.opts.ExportJobs != nil && h.opts.ExportStore != nil {
+			api.POST("/user/:userId/exports", read, h.deadline(writeDeadline), h.startExport)
+			api.GET("/user/:userId/exports/:exportId", read, h.deadline(readDeadline), h.getExport)
+			api.GET("/user/:userId/exports/:exportId/download", read, h.deadline(bulkDeadline), h.downloadExport)
+		}
+		api.PUT("/:id/status", fulfill, h.deadline(writeDeadline), h.updateOrderStatus)
+		api.POST("/:id/cancel", write, h.deadline(writeDeadline), h.cancelOrder)
+		api.POST("/:id/notes", write, h.deadline(writeDeadline), h.addOrderNote)
+		api.POST("/:id/return", write, h.deadline(writeDeadline), h.requestReturn)
+		api.POST("/:id/return/approve", manage, h.deadline(bulkDeadline), h.approveReturn)
+		api.POST("/:id/return/reject", manage, h.deadline(writeDeadline), h.rejectReturn)
+		api.POST("/:id/return/refund", manage, h.deadline(bulkDeadline), h.refundReturn)
+		api.POST("/:id/shipments", fulfill, h.deadline(writeDeadline), h.createShipment)
+		api.PATCH("/:id/shipments/:shipmentId", fulfill, h.deadline(writeDeadline), h.updateShipment)
+		api.GET("/:id/history", read, h.deadline(readDeadline), h.getOrderHistory)
+		api.GET("/:id/packing-slip", read, h.deadline(readDeadline), h.getPackingSlip)
+		api.PUT("/:id/priority", manage, h.deadline(writeDeadline), h.setOrderPriority)
+		// Streams stay open, so they have no deadline
+		if h.opts.Live != nil {
+			api.GET("/:id/events", read, h.streamOrderEvents)
+		}
+	}
+
+	// Guest checkout, authenticated by signed lookup tokens instead of JWTs
+	if h.guestCheckout() {
+		guest := g.Group("/guest/orders")
+		guest.Use(middleware.RateLimit(h.opts.RateLimitRPS, h.opts.RateLimitBurst))
+		{
+			guest.POST("", h.deadline(writeDeadline), h.createGuestOrder)
+			guest.GET("/:id", h.deadline(readDeadline), h.getGuestOrder)
+		}
+	}
+
+	// Webhook subscriptions, scoped to the authenticated user. Only admins
+	// and integrations granted the webhooks scope may subscribe, as every
+	// subscription makes the service call out.
+	if h.opts.Webhooks != nil {
+		hooks := g.Group("/webhooks")
+		hooks.Use(h.auth(), h.tenant(), auditActor, middleware.RequireScope(middleware.ScopeWebhooks), middleware.RateLimit(h.opts.RateLimitRPS, h.opts.RateLimitBurst))
+		{
+			hooks.POST("", h.deadline(writeDeadline), h.createWebhook)
+			hooks.GET("", h.deadline(readDeadline), h.listWebhooks)
+			hooks.DELETE("/:id", h.deadline(writeDeadline), h.deleteWebhook)
+			hooks.GET("/:id/deliveries", h.deadline(readDeadline), h.getWebhookDeliveries)
+			hooks.POST("/:id/ping", h.deadline(bulkDeadline), h.pingWebhook)
+		}
+	}
+
+	// Admin routes; fulfillment staff may browse orders to work the queue.
+	// Operators whose token names no tenant act across tenants.
+	admin := g.Group("/admin")
+	admin.Use(h.auth(), middleware.Tenant(false), auditActor)
+	admin.GET("/orders", middleware.RequireScope(middleware.ScopeOrdersFulfill), h.deadline(bulkDeadline), h.listOrders)
+	admin.GET("/orders/search", middleware.RequireScope(middleware.ScopeOrdersFulfill), h.deadline(bulkDeadline), h.searchOrderText)
+	admin.Use(middleware.RequireScope(middleware.ScopeOrdersAdmin))
+	{
+		admin.POST("/events/replay", h.deadline(bulkDeadline), h.replayEvents)
+		admin.GET("/health", h.deadline(readDeadline), h.getHealthDetails)
+		admin.GET("/read-only", h.deadline(readDeadline), h.getReadOnly)
+		admin.PUT("/read-only", h.deadline(writeDeadline), h.setReadOnly)
+		admin.GET("/log-level", h.deadline(readDeadline), h.getLogLevel)
+		admin.PUT("/log-level", h.deadline(writeDeadline), h.setLogLevel)
+		admin.DELETE("/log-level", h.deadline(writeDeadline), h.resetLogLevel)
+		admin.GET("/stats/revenue", h.deadline(bulkDeadline), h.getRevenueStats)
+		admin.GET("/stats/top-customers", h.deadline(bulkDeadline), h.getTopCustomers)
+		if h.opts.Promotions != nil {
+			admin.POST("/promotions", h.deadline(writeDeadline), h.createPromotion)
+			admin.GET("/promotions", h.deadline(readDeadline), h.listPromotions)
+		}
+		if h.opts.UserData != nil {
+			admin.DELETE("/users/:userId/data", h.deadline(bulkDeadline), h.eraseUserData)
+			admin.GET("/users/:userId/data-export", h.deadline(bulkDeadline), h.exportUserData)
+		}
+	}
+}
+
+// auth authenticates bearer tokens signed with one of the accepted
+// algorithms: HMAC ones with the current JWT secrets, asymmetric ones with
+// the public keys and the JWKS
+func (h *Handler) auth() gin.HandlerFunc {
+	if h.opts.PactVerification {
+		return h.pactAuth()
+	}
+	secrets := h.opts.JWTSecrets
+	if secrets == nil {
+		secret := h.opts.JWTSecret
+		secrets = func() [][]byte { return [][]byte{secret} }
+	}
+	var public []middleware.KeySource
+	if len(h.opts.JWTPublicKeys) > 0 {
+		public = append(public, jwks.Static(h.opts.JWTPublicKeys))
+	}
+	if h.opts.JWKS != nil {
+		public = append(public, h.opts.JWKS.Keys)
+	}
+	var asymmetric middleware.KeySource
+	if len(public) > 0 {
+		asymmetric = middleware.AnyOf(public...)
+	}
+	keys := middleware.BySigningMethod(middleware.HMACKeys(secrets), asymmetric)
+	return middleware.Authenticate(keys, h.opts.JWTAlgorithms...)
+}
+
+// tenant scopes the request to the caller's tenant, rejecting callers
+// without one when Options.RequireTenant is set
+func (h *Handler) tenant() gin.HandlerFunc {
+	return middleware.Tenant(h.opts.RequireTenant)
+}
+
+// auditActor makes the authenticated caller the actor of the audit records
+// written for the request
+func auditActor(c *gin.Context) {
+	actor := audit.Actor{ID: c.GetString(middleware.ContextUserID), Roles: c.GetStringSlice(middleware.ContextRoles)}
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), actor))
+	c.Next()
+}
+
+// replicaReads lets the orders read by GET and HEAD requests, which change
+// nothing, be served by a replica
+func replicaReads(c *gin.Context) {
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		c.Request = c.Request.WithContext(repository.WithReplicaReads(c.Request.Context()))
+	}
+	c.Next()
+}
+
+// flagSubject decides feature flags for the authenticated caller
+func flagSubject(c *gin.Context) {
+	subject := featureflags.Subject{UserID: c.GetString(middleware.ContextUserID), TenantID: c.GetString(middleware.ContextTenantID)}
+	c.Request = c.Request.WithContext(featureflags.WithSubject(c.Request.Context(), subject))
+	c.Next()
+}
+
+// deadlineClass picks an endpoint's class out of Deadlines
+type deadlineClass func(Deadlines) time.Duration
+
+func readDeadline(d Deadlines) time.Duration  { return d.Read }
+func writeDeadline(d Deadlines) time.Duration { return d.Write }
+func bulkDeadline(d Deadlines) time.Duration  { return d.Bulk }
+
+// deadline bounds the request context with the endpoint's override from
+// Deadlines.Routes, or its class otherwise, as currently set
+func (h *Handler) deadline(class deadlineClass) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		d := *h.deadlines.Load()
+		timeout := class(d)
+		if override, ok := d.Routes[c.Request.Method+" "+unversionedPath(c.FullPath())]; ok {
+			timeout = override
+		}
+		middleware.Deadline(timeout)(c)
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/breaker"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/repository"
+	fixtures "order-service/pkg/testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testAPI is the HTTP API over the real order service, backed by in-memory
+// mocks isolating tenants and guarded by a circuit breaker like in
+// production
+type testAPI struct {
+	router    http.Handler
+	repo      *fixtures.MockOrderRepository
+	clock     *clock.Fake
+	publisher *fixtures.MockPublisher
+}
+
+const (
+	testBreakerThreshold = 2
+	testBreakerCooldown  = 30 * time.Second
+)
+
+func newTestAPI(t *testing.T, opts Options, orders ...contracts.Order) *testAPI {
+	t.Helper()
+	api := &testAPI{
+		repo:      fixtures.NewMockOrderRepository(orders...),
+		clock:     clock.NewFake(fixtures.FixedTime.Add(time.Hour)),
+		publisher: &fixtures.MockPublisher{},
+	}
+	b := breaker.New("test-"+t.Name(), testBreakerThreshold, testBreakerCooldown, api.clock)
+	repo := repository.NewBreakerRepository(repository.NewTenantRepository(api.repo, nil), b, 0)
+	svc := service.NewOrderService(repo, &fixtures.MockEventLog{}, api.publisher, api.clock)
+
+	opts.JWTSecret = fixtures.TestSecret
+	opts.Clock = api.clock
+	api.router = NewHandler(opts, svc, nil, nil).Router()
+	return api
+}
+
+// newMongoAPI returns the test API reading orders straight from the
+// collection orders, as stats do, and read models from readModels
+func newMongoAPI(t *testing.T, opts Options, orders *mongo.Collection, readModels *mongo.Database) *testAPI {
+	t.Helper()
+	api := newTestAPI(t, opts)
+	svc := service.NewOrderService(repository.NewTenantRepository(api.repo, nil), &fixtures.MockEventLog{}, api.publisher, api.clock)
+	opts.JWTSecret = fixtures.TestSecret
+	opts.Clock = api.clock
+	api.router = NewHandler(opts, svc, orders, readModels).Router()
+	return api
+}
+
+func customer() *fixtures.TokenBuilder {
+	return fixtures.NewToken().ForUser("user-1")
+}
+
+func fulfillment() *fixtures.TokenBuilder {
+	return fixtures.NewToken().ForUser("staff-1").WithClaim("role", middleware.RoleFulfillment)
+}
+
+// errorMessage returns the error a response reports
+func errorMessage(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	fixtures.DecodeJSON(t, w, &body)
+	return body.Error
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	pending := fixtures.NewOrder().Build()
+	shipped := fixtures.NewOrder().WithStatus(contracts.StatusShipped).Build()
+	cancelled := fixtures.NewOrder().WithStatus(contracts.StatusCancelled).Build()
+
+	tests := []struct {
+		name      string
+		order     contracts.Order
+		id        string
+		status    string
+		token     *fixtures.TokenBuilder
+		want      int
+		wantError string
+		stored    string
+	}{
+		{name: "confirms a pending order", order: pending, status: contracts.StatusConfirmed, token: fulfillment(), want: http.StatusOK, stored: contracts.StatusConfirmed},
+		{name: "delivers a shipped order", order: shipped, status: contracts.StatusDelivered, token: fulfillment(), want: http.StatusOK, stored: contracts.StatusDelivered},
+		{name: "cannot move a shipped order back", order: shipped, status: contracts.StatusPending, token: fulfillment(), want: http.StatusConflict, stored: contracts.StatusShipped},
+		{name: "cannot reopen a cancelled order", order: cancelled, status: contracts.StatusConfirmed, token: fulfillment(), want: http.StatusConflict, stored: contracts.StatusCancelled},
+		{name: "unknown status", order: pending, status: "teleported", token: fulfillment(), want: http.StatusBadRequest, wantError: "Invalid status", stored: contracts.StatusPending},
+		{name: "return statuses", order: pending, status: contracts.StatusReturned, token: fulfillment(), want: http.StatusBadRequest, stored: contracts.StatusPending},
+		{name: "unknown order", order: pending, id: primitive.NewObjectID().Hex(), status: contracts.StatusConfirmed, token: fulfillment(), want: http.StatusNotFound, wantError: "Order not found", stored: contracts.StatusPending},
+		{name: "malformed order ID", order: pending, id: "not-an-id", status: contracts.StatusConfirmed, token: fulfillment(), want: http.StatusBadRequest, wantError: "Invalid order ID", stored: contracts.StatusPending},
+		{name: "customers may not fulfill", order: pending, status: contracts.StatusConfirmed, token: customer(), want: http.StatusForbidden, stored: contracts.StatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t, Options{}, tt.order)
+			id := tt.id
+			if id == "" {
+				id = tt.order.ID.Hex()
+			}
+
+			w := fixtures.NewRequest(http.MethodPut, "/api/orders/"+id+"/status").
+				WithToken(t, tt.token).
+				WithJSON(contracts.UpdateOrderStatusRequest{Status: tt.status}).
+				Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantError != "" {
+				if got := errorMessage(t, w); got != tt.wantError {
+					t.Errorf("error = %q, want %q", got, tt.wantError)
+				}
+			}
+			stored, err := api.repo.FindByID(context.Background(), tt.order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status != tt.stored {
+				t.Errorf("stored status = %q, want %q", stored.Status, tt.stored)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatusRecordsChange(t *testing.T) {
+	order := fixtures.NewOrder().Build()
+	api := newTestAPI(t, Options{}, order)
+
+	w := fixtures.NewRequest(http.MethodPut, "/api/orders/"+order.ID.Hex()+"/status").
+		WithToken(t, fulfillment()).
+		WithJSON(contracts.UpdateOrderStatusRequest{Status: contracts.StatusShipped, Reason: "picked up"}).
+		Do(t, api.router)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	stored, _ := api.repo.FindByID(context.Background(), order.ID)
+	if !stored.UpdatedAt.Equal(api.clock.Now()) {
+		t.Errorf("updated at %v, want the clock's %v", stored.UpdatedAt, api.clock.Now())
+	}
+	if n := len(stored.StatusHistory); n == 0 || stored.StatusHistory[n-1].ActorID != "staff-1" {
+		t.Errorf("history = %+v, want the change by staff-1 last", stored.StatusHistory)
+	}
+	if events := api.publisher.Events(); len(events) != 1 || events[0].Event.Type != contracts.EventOrderStatusChanged {
+		t.Errorf("published %+v, want one %s event", events, contracts.EventOrderStatusChanged)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	order := fixtures.NewOrder().Build()
+
+	tests := []struct {
+		name      string
+		opts      Options
+		header    string
+		token     *fixtures.TokenBuilder
+		want      int
+		wantError string
+	}{
+		{name: "valid token", token: customer(), want: http.StatusOK},
+		{name: "no token", want: http.StatusUnauthorized, wantError: "Authorization header required"},
+		{name: "not a bearer token", header: "Basic dXNlcjpwYXNz", want: http.StatusUnauthorized, wantError: "Bearer token required"},
+		{name: "expired token", token: customer().Expired(), want: http.StatusUnauthorized, wantError: "Invalid token"},
+		{name: "wrong signature", token: customer().SignedWith([]byte("another-secret")), want: http.StatusUnauthorized, wantError: "Invalid token"},
+		{name: "malformed tenant claim", token: customer().WithClaim("tenant_id", "not a tenant!"), want: http.StatusUnauthorized, wantError: "Invalid token"},
+		{name: "no tenant claim when required", opts: Options{RequireTenant: true}, token: customer(), want: http.StatusForbidden, wantError: "Token has no tenant"},
+		{name: "no scope for the route", token: customer().WithClaim("scope", middleware.ScopeOrdersWrite), want: http.StatusForbidden},
+		{name: "another user's orders", token: fixtures.NewToken().ForUser("user-2"), want: http.StatusForbidden, wantError: "Access denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t, tt.opts, order)
+
+			req := fixtures.NewRequest(http.MethodGet, "/api/orders/user/user-1")
+			if tt.token != nil {
+				req.WithToken(t, tt.token)
+			}
+			if tt.header != "" {
+				req.WithHeader("Authorization", tt.header)
+			}
+			w := req.Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantError != "" {
+				if got := errorMessage(t, w); got != tt.wantError {
+					t.Errorf("error = %q, want %q", got, tt.wantError)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateOrderWithoutUserClaim(t *testing.T) {
+	api := newTestAPI(t, Options{})
+	token := fixtures.NewToken()
+	token.WithClaim("userId", nil)
+
+	w := fixtures.NewRequest(http.MethodPost, "/api/orders").
+		WithToken(t, token).
+		WithJSON(fixtures.NewOrder().CreateRequest()).
+		Do(t, api.router)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+	if got := errorMessage(t, w); got != "User ID not found" {
+		t.Errorf("error = %q", got)
+	}
+	for _, call := range api.repo.Calls() {
+		if call == "Create" {
+			t.Error("order stored without a user")
+		}
+	}
+}
+
+func TestUserClaimRequired(t *testing.T) {
+	routes := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{method: http.MethodPost, path: "/api/orders", body: fixtures.NewOrder().CreateRequest()},
+		{method: http.MethodGet, path: "/api/orders/user/user-1"},
+		{method: http.MethodGet, path: "/api/orders/user/user-1/summary"},
+	}
+	claims := map[string]interface{}{"missing": nil, "not a string": 42}
+
+	for _, route := range routes {
+		for name, claim := range claims {
+			t.Run(route.method+" "+route.path+" "+name, func(t *testing.T) {
+				api := newTestAPI(t, Options{}, fixtures.NewOrder().Build())
+
+				req := fixtures.NewRequest(route.method, route.path).WithToken(t, customer().WithClaim("userId", claim))
+				if route.body != nil {
+					req.WithJSON(route.body)
+				}
+				w := req.Do(t, api.router)
+
+				if w.Code != http.StatusUnauthorized {
+					t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+				}
+				if got := errorMessage(t, w); got != "User ID not found" {
+					t.Errorf("error = %q", got)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateOrderValidation(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []contracts.OrderItem
+		want       int
+		wantFields []string
+	}{
+		{name: "valid", items: []contracts.OrderItem{fixtures.NewItem().WithQuantity(2).Build()}, want: http.StatusCreated},
+		{name: "no items", items: []contracts.OrderItem{}, want: http.StatusBadRequest, wantFields: []string{"items"}},
+		{name: "negative quantity", items: []contracts.OrderItem{fixtures.NewItem().WithQuantity(-1).Build()}, want: http.StatusBadRequest, wantFields: []string{"items[0].quantity"}},
+		{name: "negative price", items: []contracts.OrderItem{fixtures.NewItem().WithPrice("-5.00").Build()}, want: http.StatusBadRequest, wantFields: []string{"items[0].price"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI(t, Options{})
+
+			w := fixtures.NewRequest(http.MethodPost, "/api/orders").
+				WithToken(t, customer()).
+				WithJSON(contracts.CreateOrderRequest{Items: tt.items}).
+				Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantFields == nil {
+				return
+			}
+			var body struct {
+				Error  string                 `json:"error"`
+				Fields []contracts.FieldError `json:"fields"`
+			}
+			fixtures.DecodeJSON(t, w, &body)
+			if !strings.HasPrefix(body.Error, "invalid order: ") {
+				t.Errorf("error = %q", body.Error)
+			}
+			var fields []string
+			for _, f := range body.Fields {
+				fields = append(fields, f.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+// networkError is what the driver reports when MongoDB cannot be reached
+var networkError = mongo.CommandError{Message: "connection reset", Labels: []string{"NetworkError"}}
+
+func TestMongoFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		want      int
+		wantError string
+	}{
+		{name: "not found", err: repository.ErrNotFound, want: http.StatusNotFound, wantError: "Order not found"},
+		{name: "query error", err: mongo.CommandError{Code: 2, Message: "bad query"}, want: http.StatusInternalServerError, wantError: "Failed to get order"},
+		{name: "network error", err: networkError, want: http.StatusInternalServerError, wantError: "Failed to get order"},
+		{name: "timeout", err: context.DeadlineExceeded, want: http.StatusInternalServerError, wantError: "Failed to get order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := fixtures.NewOrder().Build()
+			api := newTestAPI(t, Options{}, order)
+			api.repo.FindByIDFunc = func(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+				return contracts.Order{}, tt.err
+			}
+
+			w := fixtures.NewRequest(http.MethodGet, "/api/orders/"+order.ID.Hex()).
+				WithToken(t, customer()).
+				Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if got := errorMessage(t, w); got != tt.wantError {
+				t.Errorf("error = %q, want %q", got, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestMongoOutageOpensBreaker(t *testing.T) {
+	order := fixtures.NewOrder().Build()
+	api := newTestAPI(t, Options{}, order)
+	down := true
+	api.repo.FindByIDFunc = func(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+		if down {
+			return contracts.Order{}, networkError
+		}
+		return order, nil
+	}
+	get := func() (int, string) {
+		w := fixtures.NewRequest(http.MethodGet, "/api/orders/"+order.ID.Hex()).
+			WithToken(t, customer()).
+			Do(t, api.router)
+		return w.Code, w.Header().Get("Retry-After")
+	}
+
+	for i := 0; i < testBreakerThreshold; i++ {
+		if code, _ := get(); code != http.StatusInternalServerError {
+			t.Fatalf("failure %d: status = %d, want %d", i+1, code, http.StatusInternalServerError)
+		}
+	}
+
+	calls := len(api.repo.Calls())
+	code, retryAfter := get()
+	if code != http.StatusServiceUnavailable || retryAfter == "" {
+		t.Fatalf("open breaker: status = %d, Retry-After = %q, want %d with Retry-After", code, retryAfter, http.StatusServiceUnavailable)
+	}
+	if len(api.repo.Calls()) != calls {
+		t.Error("open breaker still queried the repository")
+	}
+
+	down = false
+	api.clock.Advance(testBreakerCooldown)
+	if code, _ := get(); code != http.StatusOK {
+		t.Fatalf("after cooldown: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestMongoFailureOnWrite(t *testing.T) {
+	order := fixtures.NewOrder().Build()
+	api := newTestAPI(t, Options{}, order)
+	api.repo.UpdateStatusFunc = func(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+		return contracts.Order{}, "", errors.New("write concern error")
+	}
+
+	w := fixtures.NewRequest(http.MethodPut, "/api/orders/"+order.ID.Hex()+"/status").
+		WithToken(t, fulfillment()).
+		WithJSON(contracts.UpdateOrderStatusRequest{Status: contracts.StatusConfirmed}).
+		Do(t, api.router)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusInternalServerError, w.Body)
+	}
+	if got := errorMessage(t, w); got != "Failed to update order" {
+		t.Errorf("error = %q", got)
+	}
+	if events := api.publisher.Events(); len(events) != 0 {
+		t.Errorf("published %d events for a failed update", len(events))
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"order-service/pkg/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// healthCheck predates /healthz and /readyz and is kept for existing
+// monitors: it pings the database and ignores the other dependencies
+func (h *Handler) healthCheck(c *gin.Context) {
+	// Check database connection
+	ctx := c.Request.Context()
+
+	err := h.collection.Database().Client().Ping(ctx, nil)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Database ping failed")
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":  "unhealthy",
+			"service": "order-service",
+			"error":   "database connection failed",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "healthy",
+		"service":   "order-service",
+		"timestamp": h.opts.Clock.Now().Format(time.RFC3339),
+		"database":  "connected",
+		"read_only": h.opts.ReadOnly.Status().Enabled,
+	})
+}
+
+// liveness reports that the process is serving requests. It checks no
+// dependency, so an outage of one never gets the instance restarted.
+//
+//	GET /healthz
+func (h *Handler) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, healthResponse{Status: "ok", Service: "order-service"})
+}
+
+// readiness runs the readiness checks, answering 503 unless the instance
+// should receive traffic
+//
+//	GET /readyz
+func (h *Handler) readiness(c *gin.Context) {
+	report := h.opts.Readiness.Check(c.Request.Context())
+	status := http.StatusOK
+	if report.Status != health.StatusReady {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
+
+// getHealthDetails runs every check, optional ones included, and reports
+// each dependency's status, latency and last error. It always answers 200;
+// the status field tells whether /readyz passes.
+//
+//	GET /api/admin/health
+func (h *Handler) getHealthDetails(c *gin.Context) {
+	c.JSON(http.StatusOK, h.opts.Readiness.Details(c.Request.Context()))
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// liveHeartbeat is how often an idle stream sends a comment, so proxies
+// and load balancers do not close it
+const liveHeartbeat = 15 * time.Second
+
+// LiveFeed streams stored order events; it is implemented by *live.Feed
+type LiveFeed interface {
+	Watch(orderID string) (<-chan contracts.Event, func())
+}
+
+// liveStatus is the data of a status event
+type liveStatus struct {
+	OrderID        string    `json:"order_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// streamOrderEvents holds a Server-Sent Events stream of an order's status.
+// A status event with the current state is sent first, then one per status
+// change; a deleted event ends the stream. Reconnecting clients start over
+// from the current state, so Last-Event-ID is not needed.
+//
+//	GET /api/orders/:id/events
+func (h *Handler) streamOrderEvents(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only follow their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+		err = repository.ErrNotFound
+	}
+	var updates <-chan contracts.Event
+	if err == nil {
+		// Events are keyed by the order's public ID. Read the order again
+		// once watching, so no change falls in between.
+		var stop func()
+		updates, stop = h.opts.Live.Watch(order.OrderID)
+		defer stop()
+		order, err = h.orders.Get(ctx, objectID)
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to get order for live updates")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop nginx from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	current := order.UpdatedAt
+	if !writeSSE(c, "", "status", liveStatus{OrderID: order.OrderID, Status: order.Status, UpdatedAt: order.UpdatedAt}) {
+		return
+	}
+
+	heartbeat := time.NewTicker(liveHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case event, ok := <-updates:
+			if !ok {
+				// Fell behind; the client reconnects and gets the current state
+				return
+			}
+			// The first status already covers anything older
+			if !event.Order.UpdatedAt.After(current) {
+				continue
+			}
+			current = event.Order.UpdatedAt
+
+			switch event.Type {
+			case contracts.EventOrderStatusChanged:
+				status := liveStatus{
+					OrderID:        order.OrderID,
+					Status:         event.Order.Status,
+					PreviousStatus: event.PreviousStatus,
+					UpdatedAt:      event.Order.UpdatedAt,
+				}
+				if !writeSSE(c, event.EventID, "status", status) {
+					return
+				}
+			case contracts.EventOrderDeleted:
+				writeSSE(c, event.EventID, "deleted", gin.H{"order_id": order.OrderID})
+				return
+			}
+		}
+	}
+}
+
+// writeSSE sends one event and flushes it, reporting whether the client is
+// still there
+func writeSSE(c *gin.Context, id, name string, data interface{}) bool {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("event", name).Msg("Failed to encode live update")
+		return false
+	}
+	if id != "" {
+		if _, err := fmt.Fprintf(c.Writer, "id: %s\n", id); err != nil {
+			return false
+		}
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", name, payload); err != nil {
+		return false
+	}
+	c.Writer.Flush()
+	return true
+}
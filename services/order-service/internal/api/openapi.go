@@ -0,0 +1,760 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/health"
+	"order-service/pkg/inventory"
+	"order-service/pkg/loglevel"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/openapi"
+	"order-service/pkg/privacy"
+	"order-service/pkg/projection"
+	"order-service/pkg/promotion"
+	"order-service/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// undocumentedPrefixes are routes left out of the OpenAPI document on
+// purpose: test and development helpers
+var undocumentedPrefixes = []string{"/_pact/", "/dev/"}
+
+// errorResponse is the body of every 4xx and 5xx answer. Only error is
+// always set; the rest depend on the failure.
+type errorResponse struct {
+	Error string `json:"error"`
+	// Fields lists each invalid field of a rejected order
+	Fields []contracts.FieldError `json:"fields,omitempty"`
+	// Availability lists the items short of stock
+	Availability []inventory.Shortage `json:"availability,omitempty"`
+	// Status is the order's current status when a change is refused
+	Status string `json:"status,omitempty"`
+	// OrderID is the order whose payment was declined
+	OrderID string `json:"order_id,omitempty"`
+}
+
+// healthResponse is the body of GET /health and GET /healthz
+type healthResponse struct {
+	Status    string `json:"status"`
+	Service   string `json:"service"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Database  string `json:"database,omitempty"`
+	ReadOnly  bool   `json:"read_only,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// swaggerUI renders /openapi.json; the assets come from the swagger-ui-dist
+// package on unpkg
+const swaggerUI = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Order Service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// serveOpenAPI serves doc, encoded once
+//
+//	GET /openapi.json
+func serveOpenAPI(doc *openapi.Document) gin.HandlerFunc {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		// Documents are built from static descriptions, so this is a bug
+		panic("openapi: " + err.Error())
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// serveSwaggerUI serves an interactive viewer of the OpenAPI document
+//
+//	GET /docs
+func serveSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUI))
+}
+
+// warnUndocumented logs every registered route the OpenAPI document does
+// not describe, so a new endpoint without a description shows up at startup
+func warnUndocumented(routes gin.RoutesInfo, doc *openapi.Document) {
+	documented := map[string]bool{}
+	for _, op := range doc.Operations() {
+		documented[op] = true
+	}
+	for _, route := range routes {
+		if documented[route.Method+" "+openapi.Path(unversionedPath(route.Path))] {
+			continue
+		}
+		skip := false
+		for _, prefix := range undocumentedPrefixes {
+			skip = skip || strings.HasPrefix(route.Path, prefix)
+		}
+		if !skip {
+			log.Warn().Str("method", route.Method).Str("path", route.Path).Msg("Route missing from the OpenAPI document")
+		}
+	}
+}
+
+// openAPIDocument describes the routes Router registers. Request and
+// response schemas are reflected from the structs the handlers bind and
+// render.
+func (h *Handler) openAPIDocument() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title: "Order Service API",
+		Description: "Create, track and manage orders. Authenticate with a JWT issued by the user service.\n\n" +
+			"Every /api path is also served under /api/v1, with the same wire format, and /api/v2. " +
+			"Version 1 responses carry Deprecation and a successor-version Link. " +
+			"Where version 2 differs, the operation says so.",
+		Version: "1.0.0",
+	})
+	doc.Components.SecuritySchemes["bearerAuth"] = openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+		Description: "Routes require scopes, granted by the token's roles (customer: orders:read and orders:write; " +
+			"fulfillment: orders:read and orders:fulfill; admin: every scope) or listed in its scope claim. Tokens without either are a customer's.",
+	}
+	doc.Security = []openapi.SecurityRequirement{{"bearerAuth": {}}}
+	doc.Tags = []openapi.Tag{
+		{Name: "orders", Description: "Orders of the authenticated user"},
+		{Name: "guest", Description: "Checkout without an account; enabled by GUEST_CHECKOUT_SECRET"},
+		{Name: "webhooks", Description: "Webhook subscriptions of the authenticated user; require the webhooks:manage scope"},
+		{Name: "admin", Description: "Operator endpoints; require the orders:admin scope, or orders:fulfill to browse orders"},
+		{Name: "system", Description: "Health, metrics and this document"},
+	}
+
+	s := openapi.NewReflector(doc)
+	s.Define(money.Money{}, &openapi.Schema{
+		Type:        "object",
+		Description: "An exact amount. Orders and user summaries under /api and /api/v1 render it as a bare number without its currency instead",
+		Properties: map[string]*openapi.Schema{
+			"amount":   {Type: "string", Description: "Exact decimal amount", Pattern: `^-?\d+(\.\d+)?$`, Example: "12.99"},
+			"currency": {Type: "string", Description: "ISO 4217 code", Example: "USD"},
+		},
+		Required: []string{"amount", "currency"},
+	})
+	s.Define(primitive.ObjectID{}, &openapi.Schema{Type: "string", Pattern: "^[0-9a-f]{24}$"})
+
+	public := &[]openapi.SecurityRequirement{}
+	ok := func(description string, schema *openapi.Schema) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(schema)}
+	}
+	errorSchema := s.Schema(errorResponse{})
+	fail := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(errorSchema)}
+	}
+	body := func(v interface{}) *openapi.RequestBody {
+		return &openapi.RequestBody{Required: true, Content: openapi.JSON(s.Schema(v))}
+	}
+	responses := func(rs map[string]openapi.Response) map[string]openapi.Response {
+		rs["401"] = fail("Missing or invalid JWT")
+		rs["429"] = fail("Rate limited")
+		rs["500"] = fail("Internal error")
+		return rs
+	}
+	mutating := func(rs map[string]openapi.Response) map[string]openapi.Response {
+		rs["503"] = fail("Read-only mode is on (with Retry-After), or a dependency is unavailable")
+		return responses(rs)
+	}
+	etag := map[string]openapi.Header{"ETag": {Description: "Version of the order, for If-None-Match and If-Match", Schema: &openapi.Schema{Type: "string"}}}
+
+	str := &openapi.Schema{Type: "string"}
+	query := func(name, description string, schema *openapi.Schema) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+	}
+	header := func(name, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "header", Description: description, Schema: str}
+	}
+	orderID := openapi.Parameter{Name: "id", In: "path", Required: true, Description: "Order ID", Schema: s.Schema(primitive.ObjectID{})}
+	webhookID := openapi.Parameter{Name: "id", In: "path", Required: true, Description: "Subscription ID", Schema: s.Schema(primitive.ObjectID{})}
+	userID := openapi.Parameter{Name: "userId", In: "path", Required: true, Description: "Must be the caller's own user ID", Schema: str}
+	ifMatch := header("If-Match", "Refuse the change with 412 unless the order still has this ETag")
+	timeParams := []openapi.Parameter{
+		query("tz", "IANA zone to render times in, default UTC", str),
+		header(HeaderTimezone, "Same as tz; the query parameter takes precedence"),
+	}
+	presentParams := append([]openapi.Parameter{
+		query("currency", "Add display_total converted to this ISO 4217 currency", str),
+		header(HeaderCurrency, "Same as currency; the query parameter takes precedence"),
+	}, timeParams...)
+	limit := &openapi.Schema{Type: "integer", Minimum: float(1), Maximum: float(maxPageLimit)}
+	pageParams := []openapi.Parameter{
+		query("limit", "Page size, default 20", limit),
+		query("offset", "Orders to skip", &openapi.Schema{Type: "integer", Minimum: float(0)}),
+		query("sort", "Sort order, default -created_at; -priority lists the most urgent first, oldest first within a priority",
+			&openapi.Schema{Type: "string", Enum: []string{"created_at", "-created_at", "total_amount", "-total_amount", "priority", "-priority"}}),
+	}
+	filterParams := func(after, before string) []openapi.Parameter {
+		return []openapi.Parameter{
+			query("status", "Comma-separated statuses", str),
+			query("user_id", "Orders of one user (fulfillment and admins only)", str),
+			query("product_id", "Orders containing the product", str),
+			query(after, "Created at or after (RFC3339)", &openapi.Schema{Type: "string", Format: "date-time"}),
+			query(before, "Created before (RFC3339)", &openapi.Schema{Type: "string", Format: "date-time"}),
+			query("total_currency", "Currency of min_total and max_total; also restricts orders to it", str),
+			query("min_total", "Smallest total, as a decimal", str),
+			query("max_total", "Largest total, as a decimal", str),
+		}
+	}
+	params := func(groups ...[]openapi.Parameter) []openapi.Parameter {
+		var all []openapi.Parameter
+		for _, g := range groups {
+			all = append(all, g...)
+		}
+		return all
+	}
+	orderSchema := s.Schema(orderResponse{})
+	pageSchema := s.Schema(orderPage{})
+
+	doc.Add("GET", "/health", openapi.Operation{
+		Tags: []string{"system"}, Summary: "Health check", Security: public,
+		Description: "Pings the database only. Kept for existing monitors; probes should use /healthz and /readyz.",
+		Responses: map[string]openapi.Response{
+			"200": ok("Healthy", s.Schema(healthResponse{})),
+			"503": ok("The database is unreachable", s.Schema(healthResponse{})),
+		},
+	})
+	doc.Add("GET", "/healthz", openapi.Operation{
+		Tags: []string{"system"}, Summary: "Liveness", Security: public,
+		Description: "Answers while the process serves requests; no dependency is checked.",
+		Responses:   map[string]openapi.Response{"200": ok("Alive", s.Schema(healthResponse{}))},
+	})
+	doc.Add("GET", "/readyz", openapi.Operation{
+		Tags: []string{"system"}, Summary: "Readiness", Security: public,
+		Description: "Checks MongoDB, the message bus and the exchange rate cache. Unready while the instance drains before shutting down. " +
+			"Failures are not explained; admins get the detail from /api/admin/health.",
+		Responses: map[string]openapi.Response{
+			"200": ok("Ready for traffic", s.Schema(health.Report{})),
+			"503": ok("A check failed, or the instance is draining", s.Schema(health.Report{})),
+		},
+	})
+	doc.Add("GET", "/metrics", openapi.Operation{
+		Tags: []string{"system"}, Summary: "Prometheus metrics", Security: public,
+		Responses: map[string]openapi.Response{"200": {Description: "Metrics in the Prometheus text format", Content: map[string]openapi.MediaType{"text/plain": {Schema: str}}}},
+	})
+	doc.Add("GET", "/openapi.json", openapi.Operation{
+		Tags: []string{"system"}, Summary: "This document", Security: public,
+		Responses: map[string]openapi.Response{"200": ok("OpenAPI 3 document", &openapi.Schema{Type: "object"})},
+	})
+	doc.Add("GET", "/docs", openapi.Operation{
+		Tags: []string{"system"}, Summary: "Swagger UI for this document", Security: public,
+		Responses: map[string]openapi.Response{"200": {Description: "HTML page", Content: map[string]openapi.MediaType{"text/html": {Schema: str}}}},
+	})
+
+	doc.Add("POST", "/api/orders", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Create an order",
+		Description: "Items are priced from the catalog, stock is reserved and payment authorized. Retries with the same Idempotency-Key return the first order.",
+		Parameters:  params([]openapi.Parameter{header(idempotencyKeyHeader, "Makes retries safe")}, presentParams),
+		RequestBody: body(contracts.CreateOrderRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"201": ok("Created, or replayed for a repeated Idempotency-Key", orderSchema),
+			"400": fail("Invalid order, with fields"),
+			"402": fail("Payment was declined"),
+			"409": fail("Insufficient stock (with availability), or the Idempotency-Key is in use"),
+			"422": fail("The Idempotency-Key was used for a different order"),
+		}),
+	})
+	// Guest endpoints take no JWT
+	guestCreated := mutating(map[string]openapi.Response{
+		"201": ok("Created, or replayed for a repeated Idempotency-Key", s.Schema(guestOrderResponse{})),
+		"400": fail("Invalid order or email, with fields"),
+		"402": fail("Payment was declined"),
+		"409": fail("Insufficient stock (with availability), or the Idempotency-Key is in use"),
+		"422": fail("The Idempotency-Key was used for a different order"),
+	})
+	delete(guestCreated, "401")
+	doc.Add("POST", "/api/guest/orders", openapi.Operation{
+		Tags: []string{"guest"}, Summary: "Create an order without an account", Security: public,
+		Description: "Like POST /api/orders, with the customer's email instead of a JWT. " +
+			"The response carries the token that GET /api/guest/orders/{id} requires.",
+		Parameters:  params([]openapi.Parameter{header(idempotencyKeyHeader, "Makes retries safe")}, presentParams),
+		RequestBody: body(contracts.GuestOrderRequest{}),
+		Responses:   guestCreated,
+	})
+	guestOrder := responses(map[string]openapi.Response{
+		"200": {Description: "The order", Headers: etag, Content: openapi.JSON(orderSchema)},
+		"304": {Description: "Unchanged since If-None-Match"},
+		"400": fail("Invalid order ID"),
+		"404": fail("Order not found, or the token is not the order's"),
+	})
+	guestOrder["401"] = fail("No order token")
+	doc.Add("GET", "/api/guest/orders/:id", openapi.Operation{
+		Tags: []string{"guest"}, Summary: "Get a guest order with its token", Security: public,
+		Parameters: params([]openapi.Parameter{
+			orderID,
+			header(HeaderOrderToken, "The token returned when the order was created"),
+			query("token", "Same as "+HeaderOrderToken+", for links", str),
+			header("If-None-Match", "Answer 304 while the order still has this ETag"),
+		}, presentParams),
+		Responses: guestOrder,
+	})
+	doc.Add("POST", "/api/orders/bulk", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Create several orders",
+		Description: "Each order is validated and priced on its own; the valid ones are created.",
+		Parameters:  presentParams,
+		RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(s.ArrayOf(contracts.CreateOrderRequest{}))},
+		Responses: mutating(map[string]openapi.Response{
+			"201": ok("Every order was created", s.ArrayOf(bulkOrderResult{})),
+			"207": ok("Some orders failed; see each status", s.ArrayOf(bulkOrderResult{})),
+			"400": fail("Malformed request or too many orders"),
+		}),
+	})
+	doc.Add("GET", "/api/orders/search", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Search orders",
+		Description: "Searches the caller's orders; fulfillment and admins search every order.",
+		Parameters:  params(filterParams("created_after", "created_before"), pageParams, presentParams),
+		Responses:   responses(map[string]openapi.Response{"200": ok("One page of orders", pageSchema), "400": fail("Invalid filter")}),
+	})
+	doc.Add("GET", "/api/orders/:id", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Get an order",
+		Parameters: params([]openapi.Parameter{orderID, header("If-None-Match", "Answer 304 while the order still has this ETag")}, presentParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": {Description: "The order", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"304": {Description: "Unchanged since If-None-Match"},
+			"400": fail("Invalid order ID"),
+			"404": fail("Order not found"),
+		}),
+	})
+	doc.Add("PATCH", "/api/orders/:id", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Edit the items of a pending order",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.UpdateOrderItemsRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The updated order", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("Invalid edit, with fields"),
+			"404": fail("Order not found"),
+			"409": fail("The order is past pending (with status), or stock is short (with availability)"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("DELETE", "/api/orders/:id", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Soft-delete an order (admin)",
+		Parameters: []openapi.Parameter{orderID, ifMatch},
+		Responses: mutating(map[string]openapi.Response{
+			"204": {Description: "Deleted"},
+			"403": fail("Not an admin"),
+			"404": fail("Order not found"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("GET", "/api/orders/user/:userId", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "List a user's orders",
+		Description: "Version 1 returns every order as an array, or a page when limit, offset or sort is given. Version 2 always returns a page.",
+		Parameters:  params([]openapi.Parameter{userID}, pageParams, presentParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": ok("Every order without paging parameters, one page with them", &openapi.Schema{OneOf: []*openapi.Schema{{Type: "array", Items: orderSchema}, pageSchema}}),
+			"403": fail("Not the caller's user ID"),
+		}),
+	})
+	doc.Add("GET", "/api/orders/user/:userId/summary", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Order summary of a user",
+		Parameters: params([]openapi.Parameter{userID}, presentParams),
+		Responses:  responses(map[string]openapi.Response{"200": ok("The summary", s.Schema(projection.UserSummary{})), "403": fail("Not the caller's user ID")}),
+	})
+	doc.Add("GET", "/api/orders/user/:userId/export", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Download every order of a user",
+		Parameters: params([]openapi.Parameter{userID, query("format", "csv (one row per item, default) or ndjson (one order per line)", &openapi.Schema{Type: "string", Enum: []string{"csv", "ndjson"}})}, timeParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": {Description: "The orders, oldest first", Content: map[string]openapi.MediaType{"text/csv": {Schema: str}, "application/x-ndjson": {Schema: str}}},
+			"400": fail("Unknown format"),
+			"403": fail("Not the caller's user ID"),
+		}),
+	})
+	if h.opts.ExportJobs != nil && h.opts.ExportStore != nil {
+		exportID := openapi.Parameter{Name: "exportId", In: "path", Required: true, Description: "Export ID", Schema: str}
+		doc.Add("POST", "/api/orders/user/:userId/exports", openapi.Operation{
+			Tags: []string{"orders"}, Summary: "Export every order of a user in the background",
+			Description: "Queues the export the download endpoint streams, for histories too long to download in one request. " +
+				"Poll the Location returned until the export is done, then fetch its download_url.",
+			Parameters: params([]openapi.Parameter{userID, query("format", "csv (one row per item, default) or ndjson (one order per line)", &openapi.Schema{Type: "string", Enum: []string{"csv", "ndjson"}})}, presentParams),
+			Responses: responses(map[string]openapi.Response{
+				"202": {Description: "Queued", Headers: map[string]openapi.Header{"Location": {Description: "The export's status", Schema: str}}, Content: openapi.JSON(s.Schema(ExportJobResponse{}))},
+				"400": fail("Unknown format"),
+				"403": fail("Not the caller's user ID"),
+			}),
+		})
+		doc.Add("GET", "/api/orders/user/:userId/exports/:exportId", openapi.Operation{
+			Tags: []string{"orders"}, Summary: "Status of a background export",
+			Parameters: []openapi.Parameter{userID, exportID},
+			Responses: responses(map[string]openapi.Response{
+				"200": ok("The export; pending and running exports are polled again", s.Schema(ExportJobResponse{})),
+				"403": fail("Not the caller's user ID"),
+				"404": fail("Export not found"),
+			}),
+		})
+		doc.Add("GET", "/api/orders/user/:userId/exports/:exportId/download", openapi.Operation{
+			Tags: []string{"orders"}, Summary: "Download a finished background export",
+			Parameters: []openapi.Parameter{userID, exportID},
+			Responses: responses(map[string]openapi.Response{
+				"200": {Description: "The orders, oldest first", Content: map[string]openapi.MediaType{"text/csv": {Schema: str}, "application/x-ndjson": {Schema: str}}},
+				"403": fail("Not the caller's user ID"),
+				"404": fail("Export not found"),
+				"409": fail("The export is not done"),
+			}),
+		})
+	}
+	doc.Add("PUT", "/api/orders/:id/status", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Change the status of an order (fulfillment)",
+		Description: "Requires the orders:fulfill scope. Orders move pending, confirmed, shipped, delivered, and may be cancelled while pending or confirmed. Return statuses are refused; they are set through the return endpoints.",
+		Parameters:  []openapi.Parameter{orderID, ifMatch},
+		RequestBody: body(contracts.UpdateOrderStatusRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "Updated", Headers: etag, Content: openapi.JSON(s.Schema(statusUpdate{}))},
+			"400": fail("Invalid status"),
+			"403": fail("Lacks the orders:fulfill scope"),
+			"404": fail("Order not found"),
+			"409": fail("The change is not allowed from the current status, which is returned"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/cancel", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Cancel an order",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: &openapi.RequestBody{Content: openapi.JSON(s.Schema(contracts.CancelOrderRequest{}))},
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The cancelled order", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"404": fail("Order not found"),
+			"409": fail("The order was already shipped or delivered"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/notes", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Add a note to your order",
+		Description: fmt.Sprintf("Appends a timestamped note to the order's notes. Only the customer who placed the order can add notes, at most %d.", contracts.MaxNotes),
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.AddNoteRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order with the note", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("The note is blank or too long"),
+			"403": fail("Admins cannot add notes to other customers' orders"),
+			"404": fail("Order not found"),
+			"409": fail("The order already has the maximum number of notes"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/return", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Request the return of a delivered order",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.ReturnRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order, return_requested", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"404": fail("Order not found"),
+			"409": fail("The order is not delivered, or was already returned"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	refundResponses := func(rs map[string]openapi.Response) map[string]openapi.Response {
+		rs["404"] = fail("Order not found")
+		rs["403"] = fail("Not an admin")
+		rs["422"] = fail("The payment could not be refunded; the order stays returned")
+		return mutating(rs)
+	}
+	doc.Add("POST", "/api/orders/:id/return/approve", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Approve a return and refund it (admin)",
+		Description: "The order becomes returned, then refunded once the payment service has refunded the payment. " +
+			"If the refund fails the order stays returned and the refund can be retried.",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: &openapi.RequestBody{Content: openapi.JSON(s.Schema(contracts.ApproveReturnRequest{}))},
+		Responses: refundResponses(map[string]openapi.Response{
+			"200": {Description: "The refunded order", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("Refund amount not in the order currency, or above its total"),
+			"409": fail("No return awaits review"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/return/reject", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Reject a return (admin)",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.RejectReturnRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order, return_rejected", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"403": fail("Not an admin"),
+			"404": fail("Order not found"),
+			"409": fail("No return awaits review"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/return/refund", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Retry the refund of an approved return (admin)",
+		Parameters: params([]openapi.Parameter{orderID}, presentParams),
+		Responses: refundResponses(map[string]openapi.Response{
+			"200": {Description: "The refunded order", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"409": fail("The order is not returned awaiting its refund"),
+		}),
+	})
+	doc.Add("POST", "/api/orders/:id/shipments", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Ship part of an order (fulfillment)",
+		Description: "Adds a pending shipment holding some of the order's items. Shipments may not hold more of a product than was ordered.",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.CreateShipmentRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order with the shipment", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("Items not on the order, or more than were ordered"),
+			"403": fail("Lacks the orders:fulfill scope"),
+			"404": fail("Order not found"),
+			"409": fail("The order is not confirmed or shipped"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("PATCH", "/api/orders/:id/shipments/:shipmentId", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Update a shipment (fulfillment)",
+		Description: "Moves the shipment forward (pending, shipped, delivered) and sets its carrier and tracking number. " +
+			"The order becomes shipped once every item is in a shipped shipment, and delivered once all of them are delivered.",
+		Parameters: params([]openapi.Parameter{
+			orderID,
+			{Name: "shipmentId", In: "path", Required: true, Description: "Shipment ID", Schema: str},
+			ifMatch,
+		}, presentParams),
+		RequestBody: body(contracts.UpdateShipmentRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order with the updated shipment", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("Unknown status, or a shipment moved back"),
+			"403": fail("Lacks the orders:fulfill scope"),
+			"404": fail("Order or shipment not found"),
+			"409": fail("The order is not confirmed or shipped"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("PUT", "/api/orders/:id/priority", openapi.Operation{
+		Tags: []string{"orders", "admin"}, Summary: "Change the priority of an order (admin)",
+		Description: "Sets standard, expedited or rush. Fulfillment works the admin listing sorted by -priority.",
+		Parameters:  params([]openapi.Parameter{orderID, ifMatch}, presentParams),
+		RequestBody: body(contracts.UpdatePriorityRequest{}),
+		Responses: mutating(map[string]openapi.Response{
+			"200": {Description: "The order with its new priority", Headers: etag, Content: openapi.JSON(orderSchema)},
+			"400": fail("Unknown priority"),
+			"403": fail("Not an admin"),
+			"404": fail("Order not found"),
+			"409": fail("The order is not pending or confirmed"),
+			"412": fail("The order changed since If-Match"),
+		}),
+	})
+	doc.Add("GET", "/api/orders/:id/history", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Status history of an order",
+		Parameters: params([]openapi.Parameter{orderID}, timeParams),
+		Responses:  responses(map[string]openapi.Response{"200": ok("Every status change, oldest first", s.Schema(orderHistory{})), "404": fail("Order not found")}),
+	})
+	doc.Add("GET", "/api/orders/:id/packing-slip", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Packing slip of an order or one of its shipments",
+		Description: "Lists the items to pack with the shipping address. Slips of gift orders carry the gift message and no prices.",
+		Parameters:  params([]openapi.Parameter{orderID, query("shipment", "Only the items of this shipment", str)}, timeParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": ok("The packing slip", s.Schema(packingSlip{})),
+			"404": fail("Order or shipment not found"),
+		}),
+	})
+	if h.opts.Live != nil {
+		doc.Add("GET", "/api/orders/:id/events", openapi.Operation{
+			Tags: []string{"orders"}, Summary: "Stream the status of an order",
+			Description: "Server-Sent Events: a status event with the current state, one per change, and deleted before the stream ends.",
+			Parameters:  []openapi.Parameter{orderID},
+			Responses: responses(map[string]openapi.Response{
+				"200": {Description: "Event stream; each data line is a JSON status", Content: map[string]openapi.MediaType{"text/event-stream": {Schema: s.Schema(liveStatus{})}}},
+				"404": fail("Order not found"),
+			}),
+		})
+		doc.Add("GET", "/ws/orders", openapi.Operation{
+			Tags: []string{"orders"}, Summary: "Track orders over a WebSocket",
+			Description: `Send {"type":"subscribe","order_ids":[...]} or unsubscribe; the server sends subscribed, status, deleted and error messages.`,
+			Parameters:  []openapi.Parameter{query("access_token", "The JWT, for browsers that cannot set headers", str)},
+			Responses: responses(map[string]openapi.Response{
+				"101": {Description: "Switched to the WebSocket protocol"},
+				"429": fail("Too many tracking connections"),
+			}),
+		})
+	}
+	doc.Add("POST", "/graphql", openapi.Operation{
+		Tags: []string{"orders"}, Summary: "Query orders with GraphQL",
+		RequestBody: body(graphqlRequest{}),
+		Responses: responses(map[string]openapi.Response{
+			"200": ok("Data and errors of the query", s.Schema(graphql.Response{})),
+			"400": fail("The body is not a GraphQL request"),
+		}),
+	})
+
+	if h.opts.Webhooks != nil {
+		doc.Add("POST", "/api/webhooks", openapi.Operation{
+			Tags: []string{"webhooks"}, Summary: "Subscribe a URL to order events",
+			RequestBody: body(CreateWebhookRequest{}),
+			Responses: mutating(map[string]openapi.Response{
+				"201": ok("The subscription, with its signing secret", s.Schema(webhook.Subscription{})),
+				"400": fail("Invalid URL or event type"),
+			}),
+		})
+		doc.Add("GET", "/api/webhooks", openapi.Operation{
+			Tags: []string{"webhooks"}, Summary: "List subscriptions",
+			Responses: responses(map[string]openapi.Response{"200": ok("Subscriptions", s.ArrayOf(webhook.Subscription{}))}),
+		})
+		doc.Add("DELETE", "/api/webhooks/:id", openapi.Operation{
+			Tags: []string{"webhooks"}, Summary: "Delete a subscription",
+			Parameters: []openapi.Parameter{webhookID},
+			Responses:  mutating(map[string]openapi.Response{"204": {Description: "Deleted"}, "404": fail("Webhook not found")}),
+		})
+		doc.Add("GET", "/api/webhooks/:id/deliveries", openapi.Operation{
+			Tags: []string{"webhooks"}, Summary: "Recent deliveries of a subscription",
+			Parameters: []openapi.Parameter{webhookID, query("limit", "Deliveries to return, default 50", limit)},
+			Responses:  responses(map[string]openapi.Response{"200": ok("Deliveries, newest first", s.ArrayOf(webhook.Delivery{})), "404": fail("Webhook not found")}),
+		})
+		doc.Add("POST", "/api/webhooks/:id/ping", openapi.Operation{
+			Tags: []string{"webhooks"}, Summary: "Send a test event",
+			Parameters: []openapi.Parameter{webhookID},
+			Responses:  mutating(map[string]openapi.Response{"200": ok("The delivery", s.Schema(webhook.Delivery{})), "404": fail("Webhook not found")}),
+		})
+	}
+
+	admin := func(rs map[string]openapi.Response) map[string]openapi.Response {
+		rs["403"] = fail("Not an admin")
+		return responses(rs)
+	}
+	statsParams := []openapi.Parameter{
+		query("from", "Start of the range (RFC3339), default 30 days before to", &openapi.Schema{Type: "string", Format: "date-time"}),
+		query("to", "End of the range (RFC3339), default now", &openapi.Schema{Type: "string", Format: "date-time"}),
+		query("status", "Comma-separated statuses to include; cancelled orders are excluded otherwise", str),
+		query("format", "csv for a CSV download; Accept: text/csv works too", str),
+	}
+	doc.Add("GET", "/api/admin/orders", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Browse every order",
+		Description: "Served from the search index when one is configured, which may trail changes by a few seconds and pages " +
+			"through the first 10000 matches only.",
+		Parameters: params(filterParams("from", "to"), []openapi.Parameter{
+			query("q", "Words every order listed contains in its order ID, item names or SKUs, shipping address, guest email or notes; requires the search index", str),
+		}, pageParams, presentParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": ok("One page of orders", pageSchema),
+			"400": fail("Invalid filter, q without a search index or a page past the first 10000 matches"),
+			"403": fail("Lacks the orders:fulfill scope"),
+		}),
+	})
+	doc.Add("GET", "/api/admin/orders/search", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Search orders by text",
+		Description: "Finds the orders whose order ID, item names or shipping address contain any of the words of q, whole and " +
+			"case-insensitively, most relevant first: order IDs weigh most, then item names. Each hit carries the matching " +
+			"values, HTML-escaped, with the words found wrapped in <em>.",
+		Parameters: params([]openapi.Parameter{
+			query("q", "Words to search for", str),
+		}, filterParams("from", "to"), pageParams[:2], presentParams),
+		Responses: responses(map[string]openapi.Response{
+			"200": ok("One page of hits", s.Schema(searchPage{})),
+			"400": fail("Missing q, invalid filter or sort given"),
+			"403": fail("Lacks the orders:fulfill scope"),
+		}),
+	})
+	doc.Add("POST", "/api/admin/events/replay", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Republish stored order events",
+		RequestBody: body(ReplayEventsRequest{}),
+		Responses:   admin(map[string]openapi.Response{"200": ok("Events republished", s.Schema(replayResult{})), "400": fail("Neither order_id nor from given")}),
+	})
+	doc.Add("GET", "/api/admin/health", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Dependencies of this instance",
+		Description: "Runs every readiness check, and product-service's, reporting each one's latency and last error.",
+		Responses:   admin(map[string]openapi.Response{"200": ok("Every dependency", s.Schema(health.Details{}))}),
+	})
+	doc.Add("GET", "/api/admin/read-only", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Read-only mode of this instance",
+		Responses: admin(map[string]openapi.Response{"200": ok("Status", s.Schema(middleware.ReadOnlyStatus{}))}),
+	})
+	doc.Add("PUT", "/api/admin/read-only", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Switch read-only mode of this instance",
+		RequestBody: body(SetReadOnlyRequest{}),
+		Responses:   admin(map[string]openapi.Response{"200": ok("Status", s.Schema(middleware.ReadOnlyStatus{})), "400": fail("enabled is required")}),
+	})
+	doc.Add("GET", "/api/admin/log-level", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Log level of this instance",
+		Responses: admin(map[string]openapi.Response{"200": ok("Level in force", s.Schema(loglevel.Status{}))}),
+	})
+	doc.Add("PUT", "/api/admin/log-level", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Override the log level of this instance",
+		Description: "Sets the level until reset, or for duration. Resetting, or SIGHUP, puts LOG_LEVEL back in force.",
+		RequestBody: body(SetLogLevelRequest{}),
+		Responses:   admin(map[string]openapi.Response{"200": ok("Level in force", s.Schema(loglevel.Status{})), "400": fail("Invalid level or duration")}),
+	})
+	doc.Add("DELETE", "/api/admin/log-level", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Reset the log level of this instance to LOG_LEVEL",
+		Responses: admin(map[string]openapi.Response{"200": ok("Level in force", s.Schema(loglevel.Status{}))}),
+	})
+	doc.Add("GET", "/api/admin/stats/revenue", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Revenue per period and currency",
+		Parameters: params(statsParams, []openapi.Parameter{
+			query("interval", "Period length, default day", &openapi.Schema{Type: "string", Enum: []string{"day", "week", "month"}}),
+			query("group_by", "status, tenant or status,tenant to break rows down by them; tenant only for admins acting across tenants", str),
+			query("currency", "Add display_revenue converted to this currency", str),
+		}, timeParams),
+		Responses: admin(map[string]openapi.Response{
+			"200": {Description: "Revenue rows", Content: map[string]openapi.MediaType{"application/json": {Schema: s.Schema(revenueReport{})}, "text/csv": {Schema: str}}},
+			"400": fail("Invalid range or parameter"),
+		}),
+	})
+	doc.Add("GET", "/api/admin/stats/top-customers", openapi.Operation{
+		Tags: []string{"admin"}, Summary: "Customers who spent most",
+		Parameters: params(statsParams, []openapi.Parameter{
+			query("limit", "Customers to return, default 10", limit),
+			query("currency", "Currency to rank in, default the reporting currency", str),
+		}, timeParams),
+		Responses: admin(map[string]openapi.Response{
+			"200": {Description: "Ranked customers", Content: map[string]openapi.MediaType{"application/json": {Schema: s.Schema(topCustomersReport{})}, "text/csv": {Schema: str}}},
+			"400": fail("Invalid range or parameter"),
+			"503": fail("Exchange rates are unavailable"),
+		}),
+	})
+	if h.opts.Promotions != nil {
+		doc.Add("POST", "/api/admin/promotions", openapi.Operation{
+			Tags: []string{"admin"}, Summary: "Create a promotion code",
+			RequestBody: body(CreatePromotionRequest{}),
+			Responses: admin(map[string]openapi.Response{
+				"201": ok("The promotion", s.Schema(promotion.Promotion{})),
+				"400": fail("Invalid promotion, with fields"),
+				"409": fail("The code exists"),
+			}),
+		})
+		doc.Add("GET", "/api/admin/promotions", openapi.Operation{
+			Tags: []string{"admin"}, Summary: "List promotions",
+			Responses: admin(map[string]openapi.Response{"200": ok("Every promotion, newest first", s.ArrayOf(promotion.Promotion{}))}),
+		})
+	}
+	if h.opts.UserData != nil {
+		subject := openapi.Parameter{Name: "userId", In: "path", Required: true, Description: "User whose data is concerned", Schema: str}
+		doc.Add("DELETE", "/api/admin/users/:userId/data", openapi.Operation{
+			Tags: []string{"admin"}, Summary: "Erase the personal data of a user",
+			Description: "Anonymizes every order of the user, live, archived or in cold storage, and its stored events and " +
+				"read models, as orderctl anonymize does for aged orders: amounts, items and statuses are kept for aggregates. " +
+				"The erasure is audited.",
+			Parameters: []openapi.Parameter{subject},
+			Responses: admin(map[string]openapi.Response{
+				"200": ok("What was anonymized", s.Schema(privacy.Erasure{})),
+				"503": fail("ANONYMIZATION_KEY is not set"),
+			}),
+		})
+		doc.Add("GET", "/api/admin/users/:userId/data-export", openapi.Operation{
+			Tags: []string{"admin"}, Summary: "Export the order data of a user",
+			Description: "Every order of the user, live, archived or in cold storage, deleted ones included, as one JSON " +
+				"attachment. The export is audited.",
+			Parameters: []openapi.Parameter{subject},
+			Responses:  admin(map[string]openapi.Response{"200": ok("The user's orders", s.Schema(privacy.Export{}))}),
+		})
+	}
+
+	return doc
+}
+
+// float returns a pointer to n, for schema bounds
+func float(n float64) *float64 {
+	return &n
+}
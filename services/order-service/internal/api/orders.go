@@ -0,0 +1,770 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/contracts"
+	"order-service/pkg/idempotency"
+	"order-service/pkg/inventory"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// idempotencyKeyHeader lets clients retry order creation safely
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks a response repeating an earlier one
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+func (h *Handler) createOrder(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	var req contracts.CreateOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString(middleware.ContextUserID)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	order, ok := h.placeOrder(c, userID, req)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, present.order(c.Request.Context(), order))
+}
+
+// placeOrder creates an order for userID, honoring the request's
+// idempotency key, and answers every failure itself; ok is false once it
+// has. Replays are marked with idempotentReplayedHeader.
+func (h *Handler) placeOrder(c *gin.Context, userID string, req contracts.CreateOrderRequest) (contracts.Order, bool) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if len(key) > idempotency.MaxKeyLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, idempotency.MaxKeyLength)})
+		return contracts.Order{}, false
+	}
+
+	ctx := c.Request.Context()
+
+	order, replayed, err := h.orders.CreateIdempotent(ctx, userID, key, req)
+	if errors.Is(err, idempotency.ErrKeyReused) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": idempotencyKeyHeader + " was already used for a different order"})
+		return order, false
+	}
+	if errors.Is(err, idempotency.ErrInProgress) {
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusConflict, gin.H{"error": "An order with this " + idempotencyKeyHeader + " is still being created"})
+		return order, false
+	}
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The order created with this " + idempotencyKeyHeader + " no longer exists"})
+		return order, false
+	}
+	var verr *contracts.ValidationError
+	if errors.As(err, &verr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return order, false
+	}
+	if errors.Is(err, money.ErrCurrencyMismatch) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "All items must be priced in the same currency"})
+		return order, false
+	}
+	var shortage *inventory.InsufficientStockError
+	if errors.As(err, &shortage) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "availability": shortage.Items})
+		return order, false
+	}
+	if errors.Is(err, payment.ErrDeclined) {
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": "Payment was declined", "order_id": order.OrderID})
+		return order, false
+	}
+	if err != nil && middleware.RequestFailed(c, err) {
+		return order, false
+	}
+	if errors.Is(err, service.ErrCatalogUnavailable) {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to look up order products")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Product catalog is unavailable, please retry"})
+		return order, false
+	}
+	if errors.Is(err, service.ErrExchangeRatesUnavailable) {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to convert order prices")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates are unavailable, please retry"})
+		return order, false
+	}
+	if errors.Is(err, service.ErrInventoryUnavailable) {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to reserve order stock")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Inventory is unavailable, please retry"})
+		return order, false
+	}
+	if errors.Is(err, service.ErrPaymentUnavailable) {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to authorize order payment")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service is unavailable, please retry"})
+		return order, false
+	}
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to create order")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
+		return order, false
+	}
+
+	if replayed {
+		c.Header(idempotentReplayedHeader, "true")
+		return order, true
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", order.OrderID).
+		Str("user_id", order.UserID).
+		Stringer("total_amount", order.TotalAmount).
+		Msg("Order created successfully")
+	return order, true
+}
+
+// bulkOrderResult is the outcome of one order of a bulk request
+type bulkOrderResult struct {
+	Index  int                    `json:"index"`
+	Status int                    `json:"status"`
+	Order  *orderResponse         `json:"order,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+	Fields []contracts.FieldError `json:"fields,omitempty"`
+	// Availability lists the items short of stock on a 409
+	Availability []inventory.Shortage `json:"availability,omitempty"`
+}
+
+// createOrders places several orders at once, reporting each one's outcome.
+// The response is 201 when every order was created and 207 otherwise.
+//
+//	POST /api/orders/bulk
+//	[{"items": [...]}, {"items": [...]}]
+func (h *Handler) createOrders(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	var reqs []contracts.CreateOrderRequest
+	if err := c.ShouldBindJSON(&reqs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString(middleware.ContextUserID)
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	results, err := h.orders.CreateBulk(ctx, userID, reqs)
+	var verr *contracts.ValidationError
+	if errors.As(err, &verr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return
+	}
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Msg("Failed to create orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create orders"})
+		return
+	}
+
+	response := make([]bulkOrderResult, len(results))
+	var shortage *inventory.InsufficientStockError
+	created := 0
+	for i, result := range results {
+		response[i] = bulkOrderResult{Index: i, Status: http.StatusCreated}
+		switch err := result.Err; {
+		case err == nil:
+			order := present.order(ctx, result.Order)
+			response[i].Order = &order
+			created++
+			continue
+		case errors.As(err, &verr):
+			response[i].Status, response[i].Error, response[i].Fields = http.StatusBadRequest, verr.Error(), verr.Fields
+		case errors.Is(err, money.ErrCurrencyMismatch):
+			response[i].Status, response[i].Error = http.StatusBadRequest, "All items must be priced in the same currency"
+		case errors.Is(err, service.ErrCatalogUnavailable):
+			response[i].Status, response[i].Error = http.StatusServiceUnavailable, "Product catalog is unavailable, please retry"
+		case errors.As(err, &shortage):
+			response[i].Status, response[i].Error, response[i].Availability = http.StatusConflict, "Insufficient stock", shortage.Items
+		case errors.Is(err, service.ErrExchangeRatesUnavailable):
+			response[i].Status, response[i].Error = http.StatusServiceUnavailable, "Exchange rates are unavailable, please retry"
+		case errors.Is(err, service.ErrInventoryUnavailable):
+			response[i].Status, response[i].Error = http.StatusServiceUnavailable, "Inventory is unavailable, please retry"
+		case errors.Is(err, payment.ErrDeclined):
+			response[i].Status, response[i].Error = http.StatusPaymentRequired, "Payment was declined"
+		case errors.Is(err, service.ErrPaymentUnavailable):
+			response[i].Status, response[i].Error = http.StatusServiceUnavailable, "Payment service is unavailable, please retry"
+		default:
+			log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Int("index", i).Msg("Failed to create order")
+			response[i].Status, response[i].Error = http.StatusInternalServerError, "Failed to create order"
+		}
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("user_id", userID).
+		Int("created", created).
+		Int("failed", len(results)-created).
+		Msg("Bulk orders created")
+
+	status := http.StatusCreated
+	if created < len(results) {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{"results": response, "created": created, "failed": len(results) - created})
+}
+
+func (h *Handler) getOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only see their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to get order")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
+		return
+	}
+	if notModified(c, order) {
+		return
+	}
+
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+// orderHistory is the status history of an order
+type orderHistory struct {
+	OrderID string                         `json:"order_id"`
+	Status  string                         `json:"status"`
+	History []contracts.StatusHistoryEntry `json:"history"`
+}
+
+// getOrderHistory lists the status changes of an order, oldest first: who
+// moved it from which status to which, when and why.
+//
+//	GET /api/orders/:id/history
+func (h *Handler) getOrderHistory(c *gin.Context) {
+	orderID := c.Param("id")
+
+	loc, ok := responseLocation(c)
+	if !ok {
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only see the history of their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to get order history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orderHistory{
+		OrderID: order.OrderID,
+		Status:  order.Status,
+		History: historyIn(order.StatusHistory, loc),
+	})
+}
+
+// searchOrders pages through the caller's orders matching the filters.
+// Admins search every order and may narrow to one user with user_id.
+//
+//	GET /api/orders/search?status=shipped,delivered&created_after=...&created_before=...&total_currency=USD&min_total=10&max_total=250&product_id=...
+func (h *Handler) searchOrders(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	filter, ok := orderFilter(c, "created_after", "created_before")
+	if !ok {
+		return
+	}
+	if !middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+		filter.UserID = c.GetString(middleware.ContextUserID)
+		if filter.UserID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+			return
+		}
+	}
+	q, _, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	page, err := h.orders.List(ctx, filter, q)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", filter.UserID).Msg("Failed to search orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orderPage{
+		Orders: present.orders(ctx, page.Orders),
+		Paging: newPaging(q, page),
+	})
+}
+
+func (h *Handler) getUserOrders(c *gin.Context) {
+	userID := c.Param("userId")
+
+	// Verify user can only access their own orders
+	tokenUserID := c.GetString(middleware.ContextUserID)
+	if tokenUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	if tokenUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	q, paged, ok := pageQuery(c)
+	if !ok {
+		return
+	}
+	if paged || apiVersion(c) >= apiV2 {
+		page, err := h.orders.ListUserPage(ctx, userID, q)
+		if err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Msg("Failed to get user orders")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get orders"})
+			return
+		}
+		c.JSON(http.StatusOK, orderPage{
+			Orders: present.orders(ctx, page.Orders),
+			Paging: newPaging(q, page),
+		})
+		return
+	}
+
+	// Without paging parameters every order is returned as a bare array, as
+	// before pagination existed
+	orders, err := h.orders.ListByUser(ctx, userID)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("user_id", userID).Msg("Failed to get user orders")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, present.orders(ctx, orders))
+}
+
+// statusUpdate acknowledges a status change
+type statusUpdate struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (h *Handler) updateOrderStatus(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.UpdateOrderStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// A conditional update reads the order first to check If-Match
+	var base time.Time
+	if c.GetHeader("If-Match") != "" {
+		current, err := h.orders.Get(ctx, objectID)
+		if err != nil {
+			statusChangeError(c, err, orderID, "Failed to update order")
+			return
+		}
+		var ok bool
+		if base, ok = ifMatch(c, current); !ok {
+			return
+		}
+	}
+
+	order, err := h.orders.UpdateStatus(ctx, objectID, contracts.StatusChange{
+		To:     req.Status,
+		Reason: req.Reason,
+		Actor:  c.GetString(middleware.ContextUserID),
+		Base:   base,
+	})
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			statusChangeError(c, err, orderID, "Failed to update order")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("new_status", req.Status).
+		Msg("Order status updated successfully")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, statusUpdate{
+		Message: "Order status updated successfully",
+		Status:  req.Status,
+	})
+}
+
+func (h *Handler) cancelOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	// The body is optional; without one the order is cancelled without a reason
+	var req contracts.CancelOrderRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only cancel their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersAdmin) {
+		err = repository.ErrNotFound
+	}
+	var base time.Time
+	if err == nil {
+		if base, ok = ifMatch(c, order); !ok {
+			return
+		}
+		order, err = h.orders.Cancel(ctx, objectID, contracts.StatusChange{
+			Reason: req.Reason,
+			Actor:  c.GetString(middleware.ContextUserID),
+			Base:   base,
+		})
+	}
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			statusChangeError(c, err, orderID, "Failed to cancel order")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("reason", req.Reason).
+		Msg("Order cancelled")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) updateOrderItems(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.UpdateOrderItemsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only edit their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersAdmin) {
+		err = repository.ErrNotFound
+	}
+	var base time.Time
+	if err == nil {
+		if base, ok = ifMatch(c, order); !ok {
+			return
+		}
+		order, err = h.orders.EditItems(ctx, objectID, req, base)
+	}
+
+	var verr *contracts.ValidationError
+	var shortage *inventory.InsufficientStockError
+	switch {
+	case err == nil:
+	case conditionalConflict(c, err, base):
+		return
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return
+	case errors.Is(err, money.ErrCurrencyMismatch):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "All items must be priced in the same currency"})
+		return
+	case errors.Is(err, contracts.ErrNotEditable):
+		c.JSON(http.StatusConflict, gin.H{"error": "Order items can only be changed while the order is pending", "status": order.Status})
+		return
+	case errors.Is(err, service.ErrCatalogUnavailable) && !middleware.RequestEnded(c):
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to look up order products")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Product catalog is unavailable, please retry"})
+		return
+	case errors.As(err, &shortage):
+		c.JSON(http.StatusConflict, gin.H{"error": "Insufficient stock", "availability": shortage.Items})
+		return
+	case errors.Is(err, service.ErrExchangeRatesUnavailable) && !middleware.RequestEnded(c):
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to convert order prices")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates are unavailable, please retry"})
+		return
+	case errors.Is(err, service.ErrInventoryUnavailable) && !middleware.RequestEnded(c):
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to reserve order stock")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Inventory is unavailable, please retry"})
+		return
+	default:
+		statusChangeError(c, err, orderID, "Failed to update order items")
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Int("items", len(order.Items)).
+		Stringer("total_amount", order.TotalAmount).
+		Msg("Order items updated")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) addOrderNote(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.AddNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.GetString(middleware.ContextUserID)
+
+	// Notes are the customer's own; staff see them but cannot add any
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != userID {
+		if middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the customer who placed the order can add notes"})
+			return
+		}
+		err = repository.ErrNotFound
+	}
+	var base time.Time
+	if err == nil {
+		if base, ok = ifMatch(c, order); !ok {
+			return
+		}
+		order, err = h.orders.AddNote(ctx, objectID, contracts.NoteChange{
+			Note: contracts.Note{Text: req.Text, AuthorID: userID},
+			Base: base,
+		})
+	}
+
+	var verr *contracts.ValidationError
+	switch {
+	case err == nil:
+	case conditionalConflict(c, err, base):
+		return
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return
+	case errors.Is(err, contracts.ErrTooManyNotes):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Orders can have at most %d notes", contracts.MaxNotes)})
+		return
+	default:
+		statusChangeError(c, err, orderID, "Failed to add order note")
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Int("notes", len(order.Notes)).
+		Msg("Order note added")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) deleteOrder(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// A conditional delete reads the order first to check If-Match
+	var base time.Time
+	if c.GetHeader("If-Match") != "" {
+		current, err := h.orders.Get(ctx, objectID)
+		if err != nil {
+			statusChangeError(c, err, orderID, "Failed to delete order")
+			return
+		}
+		var ok bool
+		if base, ok = ifMatch(c, current); !ok {
+			return
+		}
+	}
+
+	if _, err := h.orders.Delete(ctx, objectID, base); err != nil {
+		if conditionalConflict(c, err, base) {
+			return
+		}
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if err == repository.ErrConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "Order was modified concurrently, please retry"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to delete order")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete order"})
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("deleted_by", c.GetString(middleware.ContextUserID)).
+		Msg("Order deleted")
+
+	c.Status(http.StatusNoContent)
+}
+
+// statusChangeError answers a failed status change. Moves the order state
+// machine forbids are a 409 naming the current status.
+func statusChangeError(c *gin.Context, err error, orderID, message string) {
+	var terr *contracts.TransitionError
+	switch {
+	case errors.As(err, &terr):
+		c.JSON(http.StatusConflict, gin.H{"error": terr.Error(), "status": terr.From})
+	case err == service.ErrInvalidStatus:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+	case err == service.ErrReturnStatus:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Return statuses are set through the return endpoints"})
+	case err == repository.ErrNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	case err == repository.ErrConflict:
+		c.JSON(http.StatusConflict, gin.H{"error": "Order was modified concurrently, please retry"})
+	case middleware.RequestFailed(c, err):
+	default:
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
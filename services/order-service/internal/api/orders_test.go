@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"order-service/pkg/contracts"
+	fixtures "order-service/pkg/testing"
+)
+
+// orderRequests are the requests reading or changing one order on behalf
+// of its owner
+func orderRequests(order contracts.Order) map[string]*fixtures.RequestBuilder {
+	path := "/api/orders/" + order.ID.Hex()
+	return map[string]*fixtures.RequestBuilder{
+		"get":    fixtures.NewRequest(http.MethodGet, path),
+		"cancel": fixtures.NewRequest(http.MethodPost, path+"/cancel"),
+		"edit": fixtures.NewRequest(http.MethodPatch, path).WithJSON(contracts.UpdateOrderItemsRequest{
+			Update: []contracts.ItemQuantity{{ProductID: "product-1", Quantity: 2}},
+		}),
+	}
+}
+
+func TestOrderOwnership(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *fixtures.TokenBuilder
+		// want is the status of each request, by name
+		want map[string]int
+	}{
+		{name: "owner", token: customer(), want: map[string]int{"get": http.StatusOK, "cancel": http.StatusOK, "edit": http.StatusOK}},
+		{name: "other customer", token: fixtures.NewToken().ForUser("user-2"), want: map[string]int{"get": http.StatusNotFound, "cancel": http.StatusNotFound, "edit": http.StatusNotFound}},
+		{name: "fulfillment", token: fulfillment(), want: map[string]int{"get": http.StatusOK}},
+		{name: "admin", token: admin(), want: map[string]int{"get": http.StatusOK, "cancel": http.StatusOK, "edit": http.StatusOK}},
+	}
+	for _, tt := range tests {
+		for name, want := range tt.want {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				order := fixtures.NewOrder().WithUser("user-1").Build()
+				api := newTestAPI(t, Options{}, order)
+
+				w := orderRequests(order)[name].WithToken(t, tt.token).Do(t, api.router)
+				if w.Code != want {
+					t.Fatalf("status = %d, want %d: %s", w.Code, want, w.Body)
+				}
+				if want == http.StatusNotFound {
+					assertUnchanged(t, api, order)
+				}
+			})
+		}
+	}
+}
+
+func TestOrderTenantIsolation(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *fixtures.TokenBuilder
+		want  int
+	}{
+		{name: "owner in the tenant", token: customer().WithClaim("tenant_id", "acme"), want: http.StatusOK},
+		{name: "owner in another tenant", token: customer().WithClaim("tenant_id", "globex"), want: http.StatusNotFound},
+		{name: "admin of the tenant", token: admin().WithClaim("tenant_id", "acme"), want: http.StatusOK},
+		{name: "admin of another tenant", token: admin().WithClaim("tenant_id", "globex"), want: http.StatusNotFound},
+		{name: "operator", token: admin(), want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, name := range []string{"get", "cancel", "edit"} {
+			t.Run(tt.name+" "+name, func(t *testing.T) {
+				order := fixtures.NewOrder().WithUser("user-1").Build()
+				order.TenantID = "acme"
+				api := newTestAPI(t, Options{}, order)
+
+				w := orderRequests(order)[name].WithToken(t, tt.token).Do(t, api.router)
+				if w.Code != tt.want {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+				}
+				if tt.want == http.StatusNotFound {
+					assertUnchanged(t, api, order)
+				}
+			})
+		}
+	}
+}
+
+// assertUnchanged fails unless order is stored with its status and items
+// as given
+func assertUnchanged(t *testing.T, api *testAPI, order contracts.Order) {
+	t.Helper()
+	stored, err := api.repo.FindByID(context.Background(), order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != order.Status || stored.Items[0].Quantity != order.Items[0].Quantity {
+		t.Errorf("order changed by a caller who may not see it: %+v", stored)
+	}
+}
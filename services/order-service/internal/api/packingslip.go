@@ -0,0 +1,141 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// packingSlip is what is packed with an order, or with one of its
+// shipments. Gift orders carry the gift message and no prices, so the
+// recipient does not see what was paid.
+type packingSlip struct {
+	OrderID     string             `json:"order_id"`
+	ShipmentID  string             `json:"shipment_id,omitempty"`
+	PlacedAt    time.Time          `json:"placed_at"`
+	ShipTo      *contracts.Address `json:"ship_to,omitempty"`
+	Gift        bool               `json:"gift"`
+	GiftMessage string             `json:"gift_message,omitempty"`
+	Items       []packingSlipItem  `json:"items"`
+	// Total is the order's grand total, on slips for the whole order
+	Total *money.Money `json:"total,omitempty"`
+}
+
+// packingSlipItem is one line of a packing slip
+type packingSlipItem struct {
+	ProductID string       `json:"product_id"`
+	Name      string       `json:"name"`
+	SKU       string       `json:"sku,omitempty"`
+	Quantity  int          `json:"quantity"`
+	Price     *money.Money `json:"price,omitempty"`
+	LineTotal *money.Money `json:"line_total,omitempty"`
+}
+
+// getPackingSlip renders the packing slip of an order, or with shipment of
+// the shipment with that ID. Prices are left out of gift orders' slips.
+//
+//	GET /api/orders/:id/packing-slip?shipment=...
+func (h *Handler) getPackingSlip(c *gin.Context) {
+	orderID := c.Param("id")
+
+	loc, ok := responseLocation(c)
+	if !ok {
+		return
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only see the slips of their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersFulfill) {
+		err = repository.ErrNotFound
+	}
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+			return
+		}
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to get packing slip")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get packing slip"})
+		return
+	}
+
+	slip, ok := newPackingSlip(order, c.Query("shipment"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+		return
+	}
+	slip.PlacedAt = slip.PlacedAt.In(loc)
+	c.JSON(http.StatusOK, slip)
+}
+
+// newPackingSlip builds the slip of the whole order, or of its shipment
+// shipmentID when set; ok is false if the order has no such shipment
+func newPackingSlip(order contracts.Order, shipmentID string) (slip packingSlip, ok bool) {
+	slip = packingSlip{
+		OrderID:     order.OrderID,
+		PlacedAt:    order.CreatedAt,
+		ShipTo:      order.ShippingAddress,
+		Gift:        order.IsGift,
+		GiftMessage: order.GiftMessage,
+		Items:       []packingSlipItem{},
+	}
+
+	quantities := map[string]int{}
+	if shipmentID == "" {
+		for _, item := range order.Items {
+			quantities[item.ProductID] += item.Quantity
+		}
+		if !order.IsGift {
+			total := order.TotalAmount
+			slip.Total = &total
+		}
+	} else {
+		for _, shipment := range order.Shipments {
+			if shipment.ID != shipmentID {
+				continue
+			}
+			ok = true
+			for _, item := range shipment.Items {
+				quantities[item.ProductID] += item.Quantity
+			}
+		}
+		if !ok {
+			return slip, false
+		}
+		slip.ShipmentID = shipmentID
+	}
+
+	for _, item := range order.Items {
+		quantity := quantities[item.ProductID]
+		if quantity == 0 {
+			continue
+		}
+		// A product listed twice on the order is packed on its first line
+		delete(quantities, item.ProductID)
+		line := packingSlipItem{ProductID: item.ProductID, Name: item.Name, SKU: item.SKU, Quantity: quantity}
+		if !order.IsGift {
+			price, lineTotal := item.Price, item.Price.Mul(int64(quantity))
+			line.Price, line.LineTotal = &price, &lineTotal
+		}
+		slip.Items = append(slip.Items, line)
+	}
+	return slip, true
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProviderStateRequest represents the payload sent by the Pact verifier
+// before and after each interaction
+type ProviderStateRequest struct {
+	Action string                 `json:"action"`
+	State  string                 `json:"state" binding:"required"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// pactState tracks the principal and fixtures created for the interaction
+// currently being verified, so teardown can remove exactly what setup added
+type pactState struct {
+	mu        sync.Mutex
+	principal pactPrincipal
+	fixtures  []primitive.ObjectID
+}
+
+// pactPrincipal is the caller the stubbed authentication reports, as the
+// provider state set it up
+type pactPrincipal struct {
+	userID   string
+	roles    []string
+	scopes   []string
+	tenantID string
+}
+
+const pactDefaultUserID = "pact-user"
+
+// current returns the principal of the interaction, a customer by default
+func (s *pactState) current() pactPrincipal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.principal
+	if p.userID == "" {
+		p.userID = pactDefaultUserID
+	}
+	if len(p.roles) == 0 && len(p.scopes) == 0 {
+		p.roles = []string{middleware.RoleCustomer}
+	}
+	return p
+}
+
+// registerPactRoutes exposes the provider-state endpoint used by the Pact
+// verifier. It must only be enabled for contract verification runs.
+func (h *Handler) registerPactRoutes(r *gin.Engine) {
+	log.Warn().Msg("Pact verification mode enabled - authentication is stubbed")
+	r.POST("/_pact/provider-states", h.providerStates)
+}
+
+// pactAuth stands in for middleware.Auth during verification. Consumer
+// contracts carry placeholder tokens, so any bearer token is accepted and the
+// principal, with its roles, scopes and tenant, is taken from the active
+// provider state.
+func (h *Handler) pactAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.Abort()
+			return
+		}
+
+		p := h.pact.current()
+		c.Set(middleware.ContextUserID, p.userID)
+		c.Set(middleware.ContextEmail, p.userID+"@pact.test")
+		c.Set(middleware.ContextRoles, p.roles)
+		c.Set(middleware.ContextScopes, p.scopes)
+		if p.tenantID != "" {
+			c.Set(middleware.ContextTenantID, p.tenantID)
+		}
+		c.Next()
+	}
+}
+
+func (h *Handler) providerStates(c *gin.Context) {
+	var req ProviderStateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if req.Action == "teardown" {
+		if err := h.pactTeardown(ctx); err != nil {
+			log.Ctx(c.Request.Context()).Error().Err(err).Str("state", req.State).Msg("Provider state teardown failed")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to tear down provider state"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	// Not every verifier sends teardown, so clear the previous interaction first
+	if err := h.pactTeardown(ctx); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("state", req.State).Msg("Provider state cleanup failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up provider state"})
+		return
+	}
+
+	principal := pactPrincipal{
+		userID:   stringParam(req.Params, "userId", pactDefaultUserID),
+		roles:    listParam(req.Params, "roles"),
+		scopes:   listParam(req.Params, "scopes"),
+		tenantID: stringParam(req.Params, "tenantId", ""),
+	}
+	if principal.tenantID != "" && !tenant.Valid(principal.tenantID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tenantId must be letters, digits, dashes and underscores"})
+		return
+	}
+	h.pact.mu.Lock()
+	h.pact.principal = principal
+	h.pact.mu.Unlock()
+	userID := principal.userID
+
+	var (
+		result gin.H
+		err    error
+	)
+	switch req.State {
+	case "no orders exist":
+		result = gin.H{"userId": userID}
+	case "an order exists":
+		var order contracts.Order
+		order, err = h.pactInsertOrder(ctx, req.Params, principal)
+		result = gin.H{"userId": userID, "id": order.ID.Hex(), "orderId": order.OrderID}
+	case "user has orders":
+		count := intParam(req.Params, "count", 2)
+		for i := 0; i < count && err == nil; i++ {
+			_, err = h.pactInsertOrder(ctx, nil, principal)
+		}
+		result = gin.H{"userId": userID, "count": count}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown provider state: " + req.State})
+		return
+	}
+
+	if err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("state", req.State).Msg("Provider state setup failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set up provider state"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// pactInsertOrder stores an order of the principal, in its tenant's orders
+func (h *Handler) pactInsertOrder(ctx context.Context, params map[string]interface{}, p pactPrincipal) (contracts.Order, error) {
+	id := primitive.NewObjectID()
+	if hex := stringParam(params, "id", ""); hex != "" {
+		parsed, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return contracts.Order{}, err
+		}
+		id = parsed
+	}
+
+	now := h.opts.Clock.Now()
+	items := []contracts.OrderItem{{ProductID: "pact-product", Name: "Pact Product", Price: money.New(1000, "USD"), Quantity: 2}}
+	order := contracts.Order{
+		ID:          id,
+		OrderID:     stringParam(params, "orderId", uuid.New().String()),
+		UserID:      p.userID,
+		TenantID:    p.tenantID,
+		Items:       items,
+		TotalAmount: money.New(2000, "USD"),
+		Status:      stringParam(params, "status", "pending"),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	order.BackfillTotals()
+
+	// Replace any leftover document with the same ID from an aborted run
+	orders := h.tenantOrders(p.tenantID)
+	if _, err := orders.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		return contracts.Order{}, err
+	}
+	if _, err := orders.InsertOne(ctx, order); err != nil {
+		return contracts.Order{}, err
+	}
+
+	h.pact.mu.Lock()
+	h.pact.fixtures = append(h.pact.fixtures, id)
+	h.pact.mu.Unlock()
+
+	return order, nil
+}
+
+func (h *Handler) pactTeardown(ctx context.Context) error {
+	p := h.pact.current()
+	h.pact.mu.Lock()
+	fixtures := append([]primitive.ObjectID{}, h.pact.fixtures...)
+	h.pact.fixtures = nil
+	h.pact.principal = pactPrincipal{}
+	h.pact.mu.Unlock()
+
+	// Orders created by the interaction itself (e.g. POST /api/orders) belong
+	// to the stubbed principal, so remove those alongside the seeded fixtures
+	_, err := h.tenantOrders(p.tenantID).DeleteMany(ctx, bson.M{"$or": []bson.M{
+		{"_id": bson.M{"$in": fixtures}},
+		{"user_id": p.userID},
+	}})
+	return err
+}
+
+func stringParam(params map[string]interface{}, key, fallback string) string {
+	if v, ok := params[key].(string); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// listParam reads a list of strings, given as an array or a space-separated
+// string
+func listParam(params map[string]interface{}, key string) []string {
+	switch v := params[key].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func intParam(params map[string]interface{}, key string, fallback int) int {
+	switch v := params[key].(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return fallback
+}
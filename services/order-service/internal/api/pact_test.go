@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/clock"
+	"order-service/pkg/repository"
+	fixtures "order-service/pkg/testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// providerState sets up state with params for the next interaction
+func providerState(t *testing.T, router http.Handler, state string, params map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return fixtures.NewRequest(http.MethodPost, "/_pact/provider-states").
+		WithJSON(ProviderStateRequest{State: state, Params: params}).
+		Do(t, router)
+}
+
+// placeholder is the bearer token consumer contracts carry
+func placeholder(req *fixtures.RequestBuilder) *fixtures.RequestBuilder {
+	return req.WithHeader("Authorization", "Bearer placeholder")
+}
+
+func TestPactRoutesOffByDefault(t *testing.T) {
+	api := newTestAPI(t, Options{})
+
+	if w := providerState(t, api.router, "no orders exist", nil); w.Code != http.StatusNotFound {
+		t.Errorf("provider states: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w := placeholder(fixtures.NewRequest(http.MethodGet, "/api/orders/user/"+pactDefaultUserID)).Do(t, api.router)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("placeholder token: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPactProviderStates(t *testing.T) {
+	shared := fixtures.MongoDatabase(t).Collection("orders")
+	// globex has a database of its own
+	globex := fixtures.MongoDatabase(t).Collection("orders")
+	repo := repository.NewTenantRepository(repository.NewMongoRepository(shared), map[string]repository.OrderRepository{
+		"globex": repository.NewMongoRepository(globex),
+	})
+	clk := clock.NewFake(fixtures.FixedTime.Add(time.Hour))
+	svc := service.NewOrderService(repo, &fixtures.MockEventLog{}, &fixtures.MockPublisher{}, clk)
+	opts := Options{PactVerification: true, Clock: clk, TenantOrders: map[string]*mongo.Collection{"globex": globex}}
+	router := NewHandler(opts, svc, shared, nil).Router()
+
+	t.Run("invalid states", func(t *testing.T) {
+		if w := providerState(t, router, "no orders exist", map[string]interface{}{"tenantId": "not a tenant"}); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid tenant: status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := providerState(t, router, "the moon is full", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown state: status = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("authorization required", func(t *testing.T) {
+		providerState(t, router, "no orders exist", nil)
+		w := fixtures.NewRequest(http.MethodGet, "/api/orders/user/"+pactDefaultUserID).Do(t, router)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("order in the principal's tenant", func(t *testing.T) {
+		w := providerState(t, router, "an order exists", map[string]interface{}{"tenantId": "globex"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("setup: status = %d: %s", w.Code, w.Body)
+		}
+		var state struct {
+			ID string `json:"id"`
+		}
+		fixtures.DecodeJSON(t, w, &state)
+		if n, _ := globex.CountDocuments(context.Background(), bson.M{}); n != 1 {
+			t.Fatalf("%d orders in globex's store, want the fixture", n)
+		}
+
+		get := placeholder(fixtures.NewRequest(http.MethodGet, "/api/orders/"+state.ID))
+		if w := get.Do(t, router); w.Code != http.StatusOK {
+			t.Errorf("globex principal: status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		// The next interaction tears the fixture down
+		providerState(t, router, "no orders exist", map[string]interface{}{"tenantId": "acme"})
+		if n, _ := globex.CountDocuments(context.Background(), bson.M{}); n != 0 {
+			t.Errorf("%d orders left in globex's store after teardown", n)
+		}
+	})
+
+	t.Run("order of another tenant", func(t *testing.T) {
+		order := fixtures.NewOrder().WithUser(pactDefaultUserID).Build()
+		order.TenantID = "globex"
+		if _, err := globex.InsertOne(context.Background(), order); err != nil {
+			t.Fatal(err)
+		}
+		providerState(t, router, "no orders exist", map[string]interface{}{"tenantId": "acme"})
+
+		w := placeholder(fixtures.NewRequest(http.MethodGet, "/api/orders/"+order.ID.Hex())).Do(t, router)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("acme principal: status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
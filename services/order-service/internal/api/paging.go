@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// orderPage is one page of orders as rendered to clients
+type orderPage struct {
+	Orders []orderResponse `json:"orders"`
+	Paging paging          `json:"paging"`
+}
+
+// paging describes where a page sits in the full result
+type paging struct {
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Sort   string `json:"sort"`
+	Total  int64  `json:"total"`
+	// NextOffset is the offset of the following page, absent on the last
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+func newPaging(q repository.PageQuery, page repository.Page) paging {
+	p := paging{Limit: q.Limit, Offset: q.Offset, Sort: q.SortBy, Total: page.Total}
+	if q.Desc {
+		p.Sort = "-" + q.SortBy
+	}
+	if next := q.Offset + len(page.Orders); int64(next) < page.Total && len(page.Orders) > 0 {
+		p.NextOffset = &next
+	}
+	return p
+}
+
+// pageQuery reads limit, offset and sort (created_at, total_amount or
+// priority, prefixed with - for descending; newest first by default). paged is false
+// when the request carries none of them. Invalid values are answered with
+// 400 and ok=false.
+func pageQuery(c *gin.Context) (q repository.PageQuery, paged, ok bool) {
+	q = repository.PageQuery{Limit: defaultPageLimit, SortBy: repository.SortCreatedAt, Desc: true}
+
+	limit, hasLimit := c.GetQuery("limit")
+	offset, hasOffset := c.GetQuery("offset")
+	sort, hasSort := c.GetQuery("sort")
+	if !hasLimit && !hasOffset && !hasSort {
+		return q, false, true
+	}
+
+	if hasLimit {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxPageLimit)})
+			return q, true, false
+		}
+		q.Limit = n
+	}
+	if hasOffset {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return q, true, false
+		}
+		q.Offset = n
+	}
+	if hasSort {
+		var err error
+		if q.SortBy, q.Desc, err = parseSort(sort); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return q, true, false
+		}
+	}
+	return q, true, true
+}
+
+// parseSort reads a sort order: created_at, total_amount or priority,
+// prefixed with - for descending
+func parseSort(sort string) (by string, desc bool, err error) {
+	desc = strings.HasPrefix(sort, "-")
+	by = strings.TrimPrefix(sort, "-")
+	switch by {
+	case repository.SortCreatedAt, repository.SortTotalAmount, repository.SortPriority:
+		return by, desc, nil
+	}
+	return "", false, errors.New("sort must be created_at, total_amount or priority, optionally prefixed with -")
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/currency"
+	"order-service/pkg/money"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// HeaderTimezone lets clients request timestamps in an IANA zone; the tz
+	// query parameter takes precedence
+	HeaderTimezone = "X-Timezone"
+	// HeaderCurrency lets clients request totals converted to a preferred
+	// currency; the currency query parameter takes precedence
+	HeaderCurrency = "X-Currency"
+)
+
+// CurrencyConverter converts amounts for presentation and reporting; it is
+// implemented by *currency.Converter
+type CurrencyConverter interface {
+	Convert(ctx context.Context, amount money.Money, to string) (money.Money, error)
+	Sum(ctx context.Context, amounts []money.Money, to string) (money.Money, error)
+}
+
+// orderResponse is an order as rendered to clients. DisplayTotal is the
+// total converted to the requested currency; TotalAmount stays the amount
+// actually charged.
+type orderResponse struct {
+	contracts.Order
+	DisplayTotal *money.Money `json:"display_total,omitempty"`
+
+	legacy bool
+}
+
+// MarshalJSON renders the order's amounts as bare numbers for API version
+// 1. DisplayTotal, which version 1 never had without its currency, stays a
+// money object.
+func (r orderResponse) MarshalJSON() ([]byte, error) {
+	type plain orderResponse
+	if !r.legacy {
+		return json.Marshal(plain(r))
+	}
+	displayTotal := r.DisplayTotal
+	r.DisplayTotal = nil
+	data, err := json.Marshal(plain(r))
+	if err != nil {
+		return nil, err
+	}
+	if data, err = money.LegacyJSON(data); err != nil || displayTotal == nil {
+		return data, err
+	}
+	total, err := json.Marshal(displayTotal)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data[:len(data)-1], `,"display_total":`...)
+	return append(append(data, total...), '}'), nil
+}
+
+// presentation holds the per-request rendering preferences
+type presentation struct {
+	loc      *time.Location
+	currency string
+	convert  CurrencyConverter
+	// legacy renders amounts as bare decimal numbers without their
+	// currency, the wire format of API version 1
+	legacy bool
+}
+
+// responseLocation returns the zone timestamps are rendered in. Everything
+// is stored in UTC; conversion only happens here, at the presentation layer.
+// An unknown zone is answered with 400 and ok=false.
+func responseLocation(c *gin.Context) (loc *time.Location, ok bool) {
+	name := c.Query("tz")
+	if name == "" {
+		name = c.GetHeader(HeaderTimezone)
+	}
+	if name == "" {
+		return time.UTC, true
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone: " + name})
+		return nil, false
+	}
+	return loc, true
+}
+
+// presentation reads the timezone and preferred currency of the request. An
+// invalid or unsupported preference is answered with 400 and ok=false.
+func (h *Handler) presentation(c *gin.Context) (p presentation, ok bool) {
+	p.legacy = apiVersion(c) == apiV1
+	if p.loc, ok = responseLocation(c); !ok {
+		return p, false
+	}
+
+	code := c.Query("currency")
+	if code == "" {
+		code = c.GetHeader(HeaderCurrency)
+	}
+	if code == "" {
+		return p, true
+	}
+
+	var err error
+	if p.currency, err = money.NormalizeCurrency(code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid currency: " + code})
+		return p, false
+	}
+	if h.opts.Currency == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Currency conversion is not available"})
+		return p, false
+	}
+
+	// Probe the rate table up front so an unsupported currency is rejected
+	// before the request has any side effects
+	if _, err := h.opts.Currency.Convert(c.Request.Context(), money.Zero(money.DefaultCurrency), p.currency); errors.Is(err, currency.ErrUnsupportedCurrency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency: " + p.currency})
+		return p, false
+	}
+	p.convert = h.opts.Currency
+	return p, true
+}
+
+// order converts the order's timestamps to the requested zone and its total
+// to the requested currency. A rate source outage only drops the display
+// total; it never fails the request. Orders stored before totals had a
+// breakdown are shown with their total as the subtotal.
+func (p presentation) order(ctx context.Context, order contracts.Order) orderResponse {
+	order.BackfillTotals()
+	order.Priority = order.EffectivePriority()
+	order.CreatedAt = order.CreatedAt.In(p.loc)
+	order.UpdatedAt = order.UpdatedAt.In(p.loc)
+	if order.StatusHistory != nil {
+		order.StatusHistory = historyIn(order.StatusHistory, p.loc)
+	}
+
+	resp := orderResponse{Order: order, legacy: p.legacy}
+	if p.convert == nil {
+		return resp
+	}
+	total, err := p.convert.Convert(ctx, order.TotalAmount, p.currency)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("order_id", order.OrderID).Str("currency", p.currency).Msg("Failed to convert order total")
+		return resp
+	}
+	resp.DisplayTotal = &total
+	return resp
+}
+
+// historyIn returns a copy of history with its times in loc
+func historyIn(history []contracts.StatusHistoryEntry, loc *time.Location) []contracts.StatusHistoryEntry {
+	converted := make([]contracts.StatusHistoryEntry, len(history))
+	for i, entry := range history {
+		entry.At = entry.At.In(loc)
+		converted[i] = entry
+	}
+	return converted
+}
+
+// orders presents every order. nil stays nil so an empty result renders as
+// it always has.
+func (p presentation) orders(ctx context.Context, orders []contracts.Order) []orderResponse {
+	if orders == nil {
+		return nil
+	}
+	presented := make([]orderResponse, len(orders))
+	for i, order := range orders {
+		presented[i] = p.order(ctx, order)
+	}
+	return presented
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setOrderPriority changes the priority of a pending or confirmed order
+//
+//	PUT /api/orders/:id/priority
+func (h *Handler) setOrderPriority(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.UpdatePriorityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.Get(ctx, objectID)
+	var base time.Time
+	if err == nil {
+		if base, ok = ifMatch(c, order); !ok {
+			return
+		}
+		order, err = h.orders.SetPriority(ctx, objectID, contracts.PriorityChange{
+			Priority: req.Priority,
+			Actor:    c.GetString(middleware.ContextUserID),
+			Base:     base,
+		})
+	}
+
+	var verr *contracts.ValidationError
+	switch {
+	case err == nil:
+	case conditionalConflict(c, err, base):
+		return
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return
+	case err == contracts.ErrPriorityLocked:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": order.Status})
+		return
+	default:
+		statusChangeError(c, err, orderID, "Failed to change order priority")
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("priority", order.Priority).
+		Msg("Order priority changed")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"order-service/pkg/audit"
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/promotion"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Promotions manages promotion codes; it is implemented by *promotion.Store
+type Promotions interface {
+	Create(ctx context.Context, p *promotion.Promotion) error
+	List(ctx context.Context) ([]promotion.Promotion, error)
+}
+
+// CreatePromotionRequest represents the request payload for creating a
+// promotion code
+type CreatePromotionRequest struct {
+	Code        string       `json:"code" binding:"required"`
+	Type        string       `json:"type" binding:"required"`
+	BasisPoints int64        `json:"basis_points"`
+	AmountOff   *money.Money `json:"amount_off"`
+	MinSubtotal *money.Money `json:"min_subtotal"`
+	StartsAt    *time.Time   `json:"starts_at"`
+	ExpiresAt   *time.Time   `json:"expires_at"`
+	MaxUses     int          `json:"max_uses"`
+}
+
+func (h *Handler) createPromotion(c *gin.Context) {
+	var req CreatePromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	p := promotion.Promotion{
+		Code:        req.Code,
+		Type:        req.Type,
+		BasisPoints: req.BasisPoints,
+		AmountOff:   req.AmountOff,
+		MinSubtotal: req.MinSubtotal,
+		StartsAt:    req.StartsAt,
+		ExpiresAt:   req.ExpiresAt,
+		MaxUses:     req.MaxUses,
+		CreatedAt:   h.opts.Clock.Now(),
+	}
+	var verr *contracts.ValidationError
+	if err := p.Validate(); errors.As(err, &verr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	err := h.opts.Promotions.Create(ctx, &p)
+	if errors.Is(err, promotion.ErrExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Promotion code already exists"})
+		return
+	}
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to create promotion")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create promotion"})
+		return
+	}
+
+	h.opts.Audit.Record(ctx, audit.Record{
+		Action:   audit.ActionPromotionCreate,
+		Resource: audit.Resource{Type: audit.ResourcePromotion, ID: p.Code},
+		After:    audit.Fields{"promotion": p},
+	})
+	log.Ctx(c.Request.Context()).Info().Str("code", p.Code).Str("type", p.Type).Msg("Promotion created")
+	c.JSON(http.StatusCreated, p)
+}
+
+func (h *Handler) listPromotions(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	promotions, err := h.opts.Promotions.List(ctx)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to list promotions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list promotions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promotions)
+}
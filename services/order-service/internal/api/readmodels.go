@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/projection"
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (h *Handler) getUserSummary(c *gin.Context) {
+	userID := c.Param("userId")
+
+	tokenUserID := c.GetString(middleware.ContextUserID)
+	if tokenUserID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	if tokenUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	tenantID := tenant.FromContext(ctx)
+
+	var summary projection.UserSummary
+	err := h.readModels.Collection(projection.UserSummariesCollection).FindOne(ctx, bson.M{"_id": projection.UserSummaryID(tenantID, userID)}).Decode(&summary)
+	if err == mongo.ErrNoDocuments {
+		summary = projection.UserSummary{UserID: userID, TenantID: tenantID, TotalSpent: []money.Money{}, StatusCounts: map[string]int{}}
+	} else if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(ctx).Error().Err(err).Str("user_id", userID).Msg("Failed to get user summary")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order summary"})
+		return
+	}
+
+	summary.FirstOrderAt = summary.FirstOrderAt.In(present.loc)
+	summary.LastOrderAt = summary.LastOrderAt.In(present.loc)
+	summary.LastUpdatedAt = summary.LastUpdatedAt.In(present.loc)
+	h.normalizeSummary(ctx, present, &summary)
+	if present.legacy {
+		c.JSON(http.StatusOK, newLegacySummary(summary))
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// legacySummary is a user summary as API version 1 renders it: TotalSpent
+// is the bare amount spent in money.DefaultCurrency, the only currency
+// orders had before they carried one, and TotalSpentByCurrency lists every
+// currency
+type legacySummary struct {
+	projection.UserSummary
+	TotalSpent           json.Number   `json:"total_spent"`
+	TotalSpentByCurrency []money.Money `json:"total_spent_by_currency"`
+}
+
+func newLegacySummary(summary projection.UserSummary) legacySummary {
+	spent := money.Zero(money.DefaultCurrency)
+	for _, amount := range summary.TotalSpent {
+		if amount.Currency == spent.Currency {
+			spent = amount
+		}
+	}
+	return legacySummary{UserSummary: summary, TotalSpent: json.Number(spent.Decimal()), TotalSpentByCurrency: summary.TotalSpent}
+}
+
+// normalizeSummary adds the user's spending across all currencies as one
+// total in the requested currency, or the reporting currency by default
+func (h *Handler) normalizeSummary(ctx context.Context, present presentation, summary *projection.UserSummary) {
+	if h.opts.Currency == nil {
+		return
+	}
+	target := present.currency
+	if target == "" {
+		target = h.opts.ReportingCurrency
+	}
+	if target == "" {
+		return
+	}
+
+	total, err := h.opts.Currency.Sum(ctx, summary.TotalSpent, target)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Str("user_id", summary.UserID).Str("currency", target).Msg("Failed to normalize user summary")
+		return
+	}
+	summary.NormalizedTotal = &total
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"order-service/pkg/money"
+	"order-service/pkg/projection"
+	fixtures "order-service/pkg/testing"
+)
+
+func TestUserSummaryOfAnotherUser(t *testing.T) {
+	api := newTestAPI(t, Options{})
+
+	w := fixtures.NewRequest(http.MethodGet, "/api/orders/user/user-2/summary").WithToken(t, customer()).Do(t, api.router)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestUserSummaryTenant(t *testing.T) {
+	readModels := fixtures.MongoDatabase(t)
+	summaries := readModels.Collection(projection.UserSummariesCollection)
+	for tenantID, count := range map[string]int{"acme": 2, "globex": 5, "": 7} {
+		summary := projection.UserSummary{UserID: "user-1", TenantID: tenantID, OrderCount: count, TotalSpent: []money.Money{}, StatusCounts: map[string]int{}}
+		doc := struct {
+			ID                     interface{} `bson:"_id"`
+			projection.UserSummary `bson:",inline"`
+		}{projection.UserSummaryID(tenantID, "user-1"), summary}
+		if _, err := summaries.InsertOne(context.Background(), doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := newMongoAPI(t, Options{}, nil, readModels)
+
+	tests := []struct {
+		name  string
+		token *fixtures.TokenBuilder
+		want  int
+	}{
+		{name: "acme", token: customer().WithClaim("tenant_id", "acme"), want: 2},
+		{name: "globex", token: customer().WithClaim("tenant_id", "globex"), want: 5},
+		{name: "no tenant", token: customer(), want: 7},
+		{name: "tenant without orders", token: customer().WithClaim("tenant_id", "initech"), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := fixtures.NewRequest(http.MethodGet, "/api/v2/orders/user/user-1/summary").WithToken(t, tt.token).Do(t, api.router)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			var got projection.UserSummary
+			fixtures.DecodeJSON(t, w, &got)
+			if got.OrderCount != tt.want {
+				t.Errorf("order count = %d, want %d", got.OrderCount, tt.want)
+			}
+		})
+	}
+}
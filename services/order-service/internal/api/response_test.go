@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+	"order-service/pkg/projection"
+	fixtures "order-service/pkg/testing"
+)
+
+// goldenOrder has fixed identifiers, so responses about it are stable
+func goldenOrder() *fixtures.OrderBuilder {
+	return fixtures.NewOrder().
+		WithID("65a1b2c3d4e5f60718293a4b").
+		WithOrderID("3f1c9a52-6d2e-4b7a-9c85-0e4f1a2b3c4d").
+		WithItems(
+			fixtures.NewItem().WithProduct("product-1", "Espresso Beans").WithPrice("12.99").WithQuantity(2).Build(),
+			fixtures.NewItem().WithProduct("product-2", "Filter Papers").WithPrice("3.50").Build(),
+		)
+}
+
+// TestResponseGolden pins the JSON clients receive, per API version; run
+// with UPDATE_GOLDEN=1 after an intended change
+func TestResponseGolden(t *testing.T) {
+	shipped := goldenOrder().WithStatus(contracts.StatusShipped).Build()
+	shipped.StatusHistory = []contracts.StatusHistoryEntry{
+		{From: contracts.StatusPending, To: contracts.StatusShipped, ActorID: "staff-1", At: fixtures.FixedTime, Reason: "picked up"},
+	}
+
+	tests := []struct {
+		name   string
+		golden string
+		order  contracts.Order
+		req    *fixtures.RequestBuilder
+		token  *fixtures.TokenBuilder
+		want   int
+	}{
+		{
+			name:   "order v1",
+			golden: "order_v1",
+			order:  goldenOrder().Build(),
+			req:    fixtures.NewRequest(http.MethodGet, "/api/v1/orders/65a1b2c3d4e5f60718293a4b"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "order unversioned",
+			golden: "order_v1",
+			order:  goldenOrder().Build(),
+			req:    fixtures.NewRequest(http.MethodGet, "/api/orders/65a1b2c3d4e5f60718293a4b"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "order v2",
+			golden: "order_v2",
+			order:  goldenOrder().Build(),
+			req:    fixtures.NewRequest(http.MethodGet, "/api/v2/orders/65a1b2c3d4e5f60718293a4b"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "user orders v2",
+			golden: "user_orders_v2",
+			order:  goldenOrder().Build(),
+			req:    fixtures.NewRequest(http.MethodGet, "/api/v2/orders/user/user-1").WithQuery("limit", "10"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "order history",
+			golden: "order_history",
+			order:  shipped,
+			req:    fixtures.NewRequest(http.MethodGet, "/api/orders/65a1b2c3d4e5f60718293a4b/history"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "invalid transition",
+			golden: "invalid_transition",
+			order:  shipped,
+			req: fixtures.NewRequest(http.MethodPut, "/api/orders/65a1b2c3d4e5f60718293a4b/status").
+				WithJSON(contracts.UpdateOrderStatusRequest{Status: contracts.StatusPending}),
+			token: fulfillment(),
+			want:  http.StatusConflict,
+		},
+		{
+			name:   "validation error",
+			golden: "validation_error",
+			req: fixtures.NewRequest(http.MethodPost, "/api/orders").
+				WithJSON(contracts.CreateOrderRequest{Items: []contracts.OrderItem{
+					fixtures.NewItem().WithProduct("", "").WithQuantity(0).Build(),
+					fixtures.NewItem().WithQuantity(101).Build(),
+				}}),
+			want: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var orders []contracts.Order
+			if !tt.order.ID.IsZero() {
+				orders = append(orders, tt.order)
+			}
+			api := newTestAPI(t, Options{}, orders...)
+			token := tt.token
+			if token == nil {
+				token = customer()
+			}
+
+			w := tt.req.WithToken(t, token).Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			fixtures.AssertGoldenJSON(t, tt.golden, w.Body.Bytes())
+		})
+	}
+}
+
+func TestLegacySummary(t *testing.T) {
+	summary := projection.UserSummary{
+		UserID:       "user-1",
+		OrderCount:   3,
+		TotalSpent:   []money.Money{money.New(1250, "EUR"), money.New(2948, "USD")},
+		StatusCounts: map[string]int{"pending": 3},
+	}
+
+	data, err := json.Marshal(newLegacySummary(summary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]json.RawMessage
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got["total_spent"]) != "29.48" {
+		t.Errorf("total_spent = %s, want 29.48", got["total_spent"])
+	}
+	if want := `[{"amount":"12.50","currency":"EUR"},{"amount":"29.48","currency":"USD"}]`; string(got["total_spent_by_currency"]) != want {
+		t.Errorf("total_spent_by_currency = %s, want %s", got["total_spent_by_currency"], want)
+	}
+	if string(got["user_id"]) != `"user-1"` || string(got["order_count"]) != "3" {
+		t.Errorf("summary fields lost: %s", data)
+	}
+}
+
+func TestOrderBuilderTotals(t *testing.T) {
+	order := goldenOrder().AddItem(fixtures.NewItem().WithPrice("0.01").WithQuantity(3).Build()).Build()
+
+	if got, want := order.TotalAmount.Decimal(), "29.51"; got != want {
+		t.Errorf("total = %s, want %s", got, want)
+	}
+	if err := contracts.ValidateItems(goldenOrder().CreateRequest().Items, contracts.DefaultLimits); err != nil {
+		t.Errorf("builder request is invalid: %v", err)
+	}
+}
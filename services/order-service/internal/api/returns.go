@@ -0,0 +1,228 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *Handler) requestReturn(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.ReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Customers may only return their own orders
+	order, err := h.orders.Get(ctx, objectID)
+	if err == nil && order.UserID != c.GetString(middleware.ContextUserID) && !middleware.HasScope(c, middleware.ScopeOrdersAdmin) {
+		err = repository.ErrNotFound
+	}
+	var base time.Time
+	if err == nil {
+		if base, ok = ifMatch(c, order); !ok {
+			return
+		}
+		order, err = h.orders.RequestReturn(ctx, objectID, contracts.StatusChange{
+			Reason: req.Reason,
+			Actor:  c.GetString(middleware.ContextUserID),
+			Base:   base,
+		})
+	}
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			statusChangeError(c, err, orderID, "Failed to request return")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("reason", req.Reason).
+		Msg("Order return requested")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) approveReturn(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	// The body is optional; without one the whole total is refunded
+	var req contracts.ApproveReturnRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	base, ok := h.reviewBase(c, objectID, orderID)
+	if !ok {
+		return
+	}
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.ApproveReturn(ctx, objectID, req.RefundAmount, contracts.StatusChange{
+		Reason: req.Resolution,
+		Actor:  c.GetString(middleware.ContextUserID),
+		Base:   base,
+	})
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			refundError(c, err, order, orderID, "Failed to approve return")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Stringer("refund_amount", order.Return.Refund.Amount).
+		Msg("Order return approved and refunded")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) rejectReturn(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.RejectReturnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	base, ok := h.reviewBase(c, objectID, orderID)
+	if !ok {
+		return
+	}
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.RejectReturn(ctx, objectID, contracts.StatusChange{
+		Reason: req.Resolution,
+		Actor:  c.GetString(middleware.ContextUserID),
+		Base:   base,
+	})
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			statusChangeError(c, err, orderID, "Failed to reject return")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("resolution", req.Resolution).
+		Msg("Order return rejected")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) refundReturn(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.RefundReturn(ctx, objectID, c.GetString(middleware.ContextUserID))
+	if err != nil {
+		refundError(c, err, order, orderID, "Failed to refund return")
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Stringer("refund_amount", order.Return.Refund.Amount).
+		Msg("Order return refunded")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+// reviewBase reads the order to check If-Match when the request has one,
+// like a conditional status update
+func (h *Handler) reviewBase(c *gin.Context, id primitive.ObjectID, orderID string) (time.Time, bool) {
+	if c.GetHeader("If-Match") == "" {
+		return time.Time{}, true
+	}
+	current, err := h.orders.Get(c.Request.Context(), id)
+	if err != nil {
+		statusChangeError(c, err, orderID, "Failed to review return")
+		return time.Time{}, false
+	}
+	return ifMatch(c, current)
+}
+
+// refundError answers a failed approval or refund. A refund that fails
+// after the approval leaves the order returned, which the response says,
+// so the refund can be retried.
+func refundError(c *gin.Context, err error, order contracts.Order, orderID, message string) {
+	var verr *contracts.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+	case errors.Is(err, payment.ErrRefundDeclined):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "status": order.Status})
+	case errors.Is(err, service.ErrPaymentUnavailable) && !middleware.RequestEnded(c):
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("order_id", orderID).Msg("Failed to refund order")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Payment service is unavailable, please retry the refund", "status": order.Status})
+	default:
+		statusChangeError(c, err, orderID, message)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (h *Handler) createShipment(c *gin.Context) {
+	orderID := c.Param("id")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.CreateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	base, ok := h.shipmentBase(c, objectID, orderID)
+	if !ok {
+		return
+	}
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.CreateShipment(ctx, objectID, req, c.GetString(middleware.ContextUserID), base)
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			shipmentError(c, err, order, orderID, "Failed to create shipment")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("shipment_id", order.Shipments[len(order.Shipments)-1].ID).
+		Str("status", order.Status).
+		Msg("Order shipment created")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+func (h *Handler) updateShipment(c *gin.Context) {
+	orderID := c.Param("id")
+	shipmentID := c.Param("shipmentId")
+
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	var req contracts.UpdateShipmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	base, ok := h.shipmentBase(c, objectID, orderID)
+	if !ok {
+		return
+	}
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	order, err := h.orders.UpdateShipment(ctx, objectID, shipmentID, req, c.GetString(middleware.ContextUserID), base)
+	if err != nil {
+		if !conditionalConflict(c, err, base) {
+			shipmentError(c, err, order, orderID, "Failed to update shipment")
+		}
+		return
+	}
+
+	log.Ctx(c.Request.Context()).Info().
+		Str("order_id", orderID).
+		Str("shipment_id", shipmentID).
+		Str("shipment_status", req.Status).
+		Str("status", order.Status).
+		Msg("Order shipment updated")
+
+	c.Header("ETag", orderETag(order))
+	c.JSON(http.StatusOK, present.order(ctx, order))
+}
+
+// shipmentBase reads the order to check If-Match when the request has one,
+// like reviewBase
+func (h *Handler) shipmentBase(c *gin.Context, id primitive.ObjectID, orderID string) (time.Time, bool) {
+	if c.GetHeader("If-Match") == "" {
+		return time.Time{}, true
+	}
+	current, err := h.orders.Get(c.Request.Context(), id)
+	if err != nil {
+		statusChangeError(c, err, orderID, "Failed to read order")
+		return time.Time{}, false
+	}
+	return ifMatch(c, current)
+}
+
+// shipmentError answers a failed shipment change. Orders that cannot ship
+// are a conflict reporting their status, like rejected status changes.
+func shipmentError(c *gin.Context, err error, order contracts.Order, orderID, message string) {
+	var verr *contracts.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": verr.Error(), "fields": verr.Fields})
+	case err == contracts.ErrNotShippable:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": order.Status})
+	case err == contracts.ErrShipmentNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": "Shipment not found"})
+	default:
+		statusChangeError(c, err, orderID, message)
+	}
+}
@@ -0,0 +1,594 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/money"
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// defaultStatsWindow is the range reported when no from is given
+	defaultStatsWindow = 30 * 24 * time.Hour
+	// maxStatsWindow bounds how much history one stats request scans
+	maxStatsWindow = 2 * 366 * 24 * time.Hour
+)
+
+// statsIntervals maps the interval parameter to a $dateTrunc unit
+var statsIntervals = map[string]string{"day": "day", "week": "week", "month": "month"}
+
+// RevenueRow is the revenue of one period in one currency, and one status
+// or tenant when broken down by them
+type RevenueRow struct {
+	Period   time.Time   `json:"period"`
+	Status   string      `json:"status,omitempty"`
+	TenantID string      `json:"tenant_id,omitempty"`
+	Currency string      `json:"currency"`
+	Orders   int         `json:"orders"`
+	Revenue  money.Money `json:"revenue"`
+	// DisplayRevenue is Revenue converted to the requested currency
+	DisplayRevenue *money.Money `json:"display_revenue,omitempty"`
+}
+
+// TopCustomer is one customer's spending over the requested range. Users
+// are customers of each tenant apart.
+type TopCustomer struct {
+	UserID   string `json:"user_id"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Orders   int    `json:"orders"`
+	// Total is everything the customer spent, in the ranking currency
+	Total money.Money `json:"total"`
+	// Spent lists the amounts per original currency
+	Spent []money.Money `json:"spent"`
+}
+
+// revenueReport is the response of GET /api/admin/stats/revenue
+type revenueReport struct {
+	Interval string       `json:"interval"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Timezone string       `json:"timezone"`
+	Rows     []RevenueRow `json:"rows"`
+}
+
+// topCustomersReport is the response of GET /api/admin/stats/top-customers
+type topCustomersReport struct {
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Currency  string        `json:"currency"`
+	Customers []TopCustomer `json:"customers"`
+}
+
+// statsRange is the time range and status filter shared by stats endpoints,
+// and the tenant of the caller they are limited to
+type statsRange struct {
+	from, to time.Time
+	statuses []string
+	tenant   string
+}
+
+// match returns the $match stage selecting the range's orders
+func (r statsRange) match() bson.D {
+	return bson.D{{Key: "$match", Value: r.filter()}}
+}
+
+// filter selects the range's orders. Deleted orders are always left out,
+// cancelled ones unless statuses are asked for explicitly.
+func (r statsRange) filter() bson.M {
+	filter := bson.M{
+		"created_at":            bson.M{"$gte": r.from, "$lt": r.to},
+		"total_amount.currency": bson.M{"$exists": true},
+		"deleted_at":            bson.M{"$exists": false},
+	}
+	if r.tenant != "" {
+		filter["tenant_id"] = r.tenant
+	}
+	if len(r.statuses) > 0 {
+		filter["status"] = bson.M{"$in": r.statuses}
+	} else {
+		filter["status"] = bson.M{"$ne": contracts.StatusCancelled}
+	}
+	return filter
+}
+
+// parseStatsRange reads from, to and status. An invalid range is answered
+// with 400 and ok=false.
+func (h *Handler) parseStatsRange(c *gin.Context) (r statsRange, ok bool) {
+	r.tenant = tenant.FromContext(c.Request.Context())
+	r.to = h.opts.Clock.Now()
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC3339 time"})
+			return r, false
+		}
+		r.to = t
+	}
+	r.from = r.to.Add(-defaultStatsWindow)
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC3339 time"})
+			return r, false
+		}
+		r.from = t
+	}
+	if !r.from.Before(r.to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return r, false
+	}
+	if r.to.Sub(r.from) > maxStatsWindow {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The range may span at most two years"})
+		return r, false
+	}
+	if v := c.Query("status"); v != "" {
+		r.statuses = strings.Split(v, ",")
+	}
+	return r, true
+}
+
+// getRevenueStats reports order count and revenue per period and currency,
+// optionally broken down by status or tenant. Only admins acting across
+// tenants may break revenue down by tenant.
+//
+//	GET /api/admin/stats/revenue?interval=week&group_by=status,tenant&from=...&to=...
+func (h *Handler) getRevenueStats(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	r, ok := h.parseStatsRange(c)
+	if !ok {
+		return
+	}
+
+	interval := c.DefaultQuery("interval", "day")
+	unit, ok := statsIntervals[interval]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval must be day, week or month"})
+		return
+	}
+	byStatus, byTenant := false, false
+	if v := c.Query("group_by"); v != "" {
+		for _, field := range strings.Split(v, ",") {
+			switch field {
+			case "status":
+				byStatus = true
+			case "tenant":
+				byTenant = true
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be status, tenant or both"})
+				return
+			}
+		}
+	}
+	if byTenant && r.tenant != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins acting across tenants may group by tenant"})
+		return
+	}
+
+	// Periods start at midnight in the requested timezone
+	trunc := bson.M{"date": "$created_at", "unit": unit, "timezone": present.loc.String()}
+	if unit == "week" {
+		trunc["startOfWeek"] = "monday"
+	}
+	key := bson.M{"period": bson.M{"$dateTrunc": trunc}, "currency": "$total_amount.currency"}
+	if byStatus {
+		key["status"] = "$status"
+	}
+	if byTenant {
+		key["tenant"] = "$tenant_id"
+	}
+	pipeline := mongo.Pipeline{
+		r.match(),
+		{{Key: "$group", Value: bson.M{
+			"_id":     key,
+			"orders":  bson.M{"$sum": 1},
+			"revenue": bson.M{"$sum": "$total_amount.amount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id.period", Value: 1}, {Key: "_id.tenant", Value: 1}, {Key: "_id.currency", Value: 1}, {Key: "_id.status", Value: 1}}}},
+	}
+
+	ctx := c.Request.Context()
+	type revenueKey struct {
+		Period   time.Time `bson:"period"`
+		Currency string    `bson:"currency"`
+		Status   string    `bson:"status"`
+		Tenant   string    `bson:"tenant"`
+	}
+	// Each store is aggregated apart and the groups of the same key added
+	// up: a tenant store may live in a database of its own
+	rows := []RevenueRow{}
+	at := map[revenueKey]int{}
+	for _, store := range h.statsStores(r.tenant) {
+		cursor, err := store.orders.Aggregate(ctx, pipeline)
+		if err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(ctx).Error().Err(err).Str("tenant", store.tenant).Msg("Failed to aggregate revenue")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute revenue"})
+			return
+		}
+		var groups []struct {
+			ID      revenueKey `bson:"_id"`
+			Orders  int        `bson:"orders"`
+			Revenue int64      `bson:"revenue"`
+		}
+		if err := cursor.All(ctx, &groups); err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(ctx).Error().Err(err).Str("tenant", store.tenant).Msg("Failed to read revenue aggregation")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute revenue"})
+			return
+		}
+		for _, g := range groups {
+			key := g.ID
+			key.Period = key.Period.UTC()
+			if byTenant && key.Tenant == "" {
+				key.Tenant = store.tenant
+			}
+			i, ok := at[key]
+			if !ok {
+				i = len(rows)
+				at[key] = i
+				rows = append(rows, RevenueRow{
+					Period:   key.Period,
+					Status:   key.Status,
+					TenantID: key.Tenant,
+					Currency: key.Currency,
+					Revenue:  money.Zero(key.Currency),
+				})
+			}
+			rows[i].Orders += g.Orders
+			rows[i].Revenue.Amount += g.Revenue
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch {
+		case !a.Period.Equal(b.Period):
+			return a.Period.Before(b.Period)
+		case a.TenantID != b.TenantID:
+			return a.TenantID < b.TenantID
+		case a.Currency != b.Currency:
+			return a.Currency < b.Currency
+		}
+		return a.Status < b.Status
+	})
+
+	for i := range rows {
+		rows[i].Period = rows[i].Period.In(present.loc)
+		if present.convert != nil {
+			if converted, err := present.convert.Convert(ctx, rows[i].Revenue, present.currency); err == nil {
+				rows[i].DisplayRevenue = &converted
+			}
+		}
+	}
+
+	if wantsCSV(c) {
+		header := []string{"period"}
+		if byTenant {
+			header = append(header, "tenant_id")
+		}
+		if byStatus {
+			header = append(header, "status")
+		}
+		header = append(header, "currency", "orders", "revenue")
+		records := make([][]string, len(rows))
+		for i, row := range rows {
+			record := []string{row.Period.Format(time.RFC3339)}
+			if byTenant {
+				record = append(record, row.TenantID)
+			}
+			if byStatus {
+				record = append(record, row.Status)
+			}
+			records[i] = append(record, row.Currency, strconv.Itoa(row.Orders), row.Revenue.Decimal())
+		}
+		writeCSV(c, "revenue.csv", header, records)
+		return
+	}
+
+	c.JSON(http.StatusOK, revenueReport{
+		Interval: interval,
+		From:     r.from.In(present.loc),
+		To:       r.to.In(present.loc),
+		Timezone: present.loc.String(),
+		Rows:     rows,
+	})
+}
+
+// getTopCustomers ranks customers by what they spent over the range. Amounts
+// in other currencies are converted to the requested currency, or the
+// reporting currency by default, before ranking.
+//
+//	GET /api/admin/stats/top-customers?limit=10&from=...&to=...
+func (h *Handler) getTopCustomers(c *gin.Context) {
+	present, ok := h.presentation(c)
+	if !ok {
+		return
+	}
+	r, ok := h.parseStatsRange(c)
+	if !ok {
+		return
+	}
+
+	limit := 10
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+	target := present.currency
+	if target == "" {
+		target = h.opts.ReportingCurrency
+	}
+	if target == "" {
+		target = money.DefaultCurrency
+	}
+
+	ctx := c.Request.Context()
+	stores := h.statsStores(r.tenant)
+	var currencies []interface{}
+	for _, store := range stores {
+		found, err := store.orders.Distinct(ctx, "total_amount.currency", r.filter())
+		if err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(ctx).Error().Err(err).Str("tenant", store.tenant).Msg("Failed to list the currencies of customer spending")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute top customers"})
+			return
+		}
+		currencies = append(currencies, found...)
+	}
+	rates, err := h.rankingRates(ctx, currencies, target)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("currency", target).Msg("Failed to rate customer spending")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates are unavailable, please retry"})
+		return
+	}
+	ranked := []TopCustomer{}
+	if len(rates) == 0 {
+		// No orders in the range
+		h.writeTopCustomers(c, r, present, target, ranked)
+		return
+	}
+
+	// Customers are ranked in the database by their spending weighed by the
+	// rate of each currency, and only the top ones are read back. Each
+	// customer belongs to one tenant, so the top ones of each store hold
+	// the top ones overall.
+	branches := make(bson.A, 0, len(rates))
+	for currency, rate := range rates {
+		branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$_id.currency", currency}}, "then": rate})
+	}
+	pipeline := mongo.Pipeline{
+		r.match(),
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"tenant_id": "$tenant_id", "user_id": "$user_id", "currency": "$total_amount.currency"},
+			"orders": bson.M{"$sum": 1},
+			"spent":  bson.M{"$sum": "$total_amount.amount"},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":    bson.M{"tenant_id": "$_id.tenant_id", "user_id": "$_id.user_id"},
+			"orders": bson.M{"$sum": "$orders"},
+			"spent":  bson.M{"$push": bson.M{"currency": "$_id.currency", "amount": "$spent"}},
+			"weight": bson.M{"$sum": bson.M{"$multiply": bson.A{"$spent", bson.M{"$switch": bson.M{"branches": branches, "default": 0}}}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "weight", Value: -1}, {Key: "_id.user_id", Value: 1}, {Key: "_id.tenant_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}
+
+	type customerGroup struct {
+		ID struct {
+			TenantID string `bson:"tenant_id"`
+			UserID   string `bson:"user_id"`
+		} `bson:"_id"`
+		Orders int `bson:"orders"`
+		Spent  []struct {
+			Currency string `bson:"currency"`
+			Amount   int64  `bson:"amount"`
+		} `bson:"spent"`
+		Weight float64 `bson:"weight"`
+	}
+	var groups []customerGroup
+	for _, store := range stores {
+		cursor, err := store.orders.Aggregate(ctx, pipeline)
+		if err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(ctx).Error().Err(err).Str("tenant", store.tenant).Msg("Failed to aggregate customer spending")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute top customers"})
+			return
+		}
+		var top []customerGroup
+		if err := cursor.All(ctx, &top); err != nil {
+			if middleware.RequestFailed(c, err) {
+				return
+			}
+			log.Ctx(ctx).Error().Err(err).Str("tenant", store.tenant).Msg("Failed to read customer spending")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute top customers"})
+			return
+		}
+		for i := range top {
+			if top[i].ID.TenantID == "" {
+				top[i].ID.TenantID = store.tenant
+			}
+		}
+		groups = append(groups, top...)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Weight > groups[j].Weight })
+	if len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	for _, g := range groups {
+		customer := TopCustomer{UserID: g.ID.UserID, TenantID: g.ID.TenantID, Orders: g.Orders, Spent: make([]money.Money, 0, len(g.Spent))}
+		for _, spent := range g.Spent {
+			customer.Spent = append(customer.Spent, money.New(spent.Amount, spent.Currency))
+		}
+		sort.Slice(customer.Spent, func(i, j int) bool { return customer.Spent[i].Currency < customer.Spent[j].Currency })
+		total, err := h.spentIn(c, customer.Spent, target)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("currency", target).Msg("Failed to convert customer spending")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Exchange rates are unavailable, please retry"})
+			return
+		}
+		customer.Total = total
+		ranked = append(ranked, customer)
+	}
+	// Totals are rounded per conversion, which may reorder near ties
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Total.Amount != ranked[j].Total.Amount {
+			return ranked[i].Total.Amount > ranked[j].Total.Amount
+		}
+		if ranked[i].UserID != ranked[j].UserID {
+			return ranked[i].UserID < ranked[j].UserID
+		}
+		return ranked[i].TenantID < ranked[j].TenantID
+	})
+	h.writeTopCustomers(c, r, present, target, ranked)
+}
+
+// writeTopCustomers responds with the ranked customers, as CSV when asked
+func (h *Handler) writeTopCustomers(c *gin.Context, r statsRange, present presentation, target string, ranked []TopCustomer) {
+	if wantsCSV(c) {
+		records := make([][]string, len(ranked))
+		for i, customer := range ranked {
+			records[i] = []string{strconv.Itoa(i + 1), customer.UserID, customer.TenantID, strconv.Itoa(customer.Orders), customer.Total.Decimal(), customer.Total.Currency}
+		}
+		writeCSV(c, "top-customers.csv", []string{"rank", "user_id", "tenant_id", "orders", "total", "currency"}, records)
+		return
+	}
+
+	c.JSON(http.StatusOK, topCustomersReport{
+		From:      r.from.In(present.loc),
+		To:        r.to.In(present.loc),
+		Currency:  target,
+		Customers: ranked,
+	})
+}
+
+// rateProbe is the amount, in minor units, converted to read an exchange
+// rate precisely enough to rank customers by
+const rateProbe = 1000000000
+
+// rankingRates returns what one minor unit of each of currencies is worth
+// in minor units of target. Without a converter only target counts, as in
+// spentIn.
+func (h *Handler) rankingRates(ctx context.Context, currencies []interface{}, target string) (map[string]float64, error) {
+	rates := make(map[string]float64, len(currencies))
+	for _, value := range currencies {
+		currency, ok := value.(string)
+		if !ok {
+			continue
+		}
+		switch {
+		case currency == target:
+			rates[currency] = 1
+		case h.opts.Currency == nil:
+			rates[currency] = 0
+		default:
+			converted, err := h.opts.Currency.Convert(ctx, money.New(rateProbe, currency), target)
+			if err != nil {
+				return nil, err
+			}
+			rates[currency] = float64(converted.Amount) / rateProbe
+		}
+	}
+	return rates, nil
+}
+
+// tenantOrders returns the orders collection holding the orders of a
+// tenant: its own when kept apart, the shared one otherwise
+func (h *Handler) tenantOrders(tenantID string) *mongo.Collection {
+	if collection, ok := h.opts.TenantOrders[tenantID]; ok && tenantID != "" {
+		return collection
+	}
+	return h.collection
+}
+
+// statsStore is an orders collection stats are computed from, and the
+// tenant whose orders are read from it; empty for every tenant of the
+// shared collection
+type statsStore struct {
+	tenant string
+	orders *mongo.Collection
+}
+
+// statsStores returns the orders collections holding the orders of a
+// tenant: its own when kept apart, the shared one otherwise. Admins acting
+// across tenants, with no tenant, get the shared one and every tenant's.
+func (h *Handler) statsStores(tenantID string) []statsStore {
+	if tenantID != "" {
+		return []statsStore{{tenant: tenantID, orders: h.tenantOrders(tenantID)}}
+	}
+	stores := []statsStore{{orders: h.collection}}
+	ids := make([]string, 0, len(h.opts.TenantOrders))
+	for id := range h.opts.TenantOrders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		stores = append(stores, statsStore{tenant: id, orders: h.opts.TenantOrders[id]})
+	}
+	return stores
+}
+
+// spentIn totals amounts in currency. Without a converter only amounts
+// already in currency count.
+func (h *Handler) spentIn(c *gin.Context, amounts []money.Money, currency string) (money.Money, error) {
+	if h.opts.Currency != nil {
+		return h.opts.Currency.Sum(c.Request.Context(), amounts, currency)
+	}
+	total := money.Zero(currency)
+	for _, amount := range amounts {
+		if amount.Currency == currency {
+			total.Amount += amount.Amount
+		}
+	}
+	return total, nil
+}
+
+// wantsCSV reports whether the client asked for CSV with ?format=csv or an
+// Accept: text/csv header
+func wantsCSV(c *gin.Context) bool {
+	if format := c.Query("format"); format != "" {
+		return format == "csv"
+	}
+	return strings.Contains(c.GetHeader("Accept"), "text/csv")
+}
+
+// writeCSV responds with records as a CSV attachment
+func writeCSV(c *gin.Context, filename string, header []string, records [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write(header)
+	w.WriteAll(records)
+	if err := w.Error(); err != nil {
+		log.Ctx(c.Request.Context()).Error().Err(err).Str("file", filename).Msg("Failed to write CSV response")
+	}
+}
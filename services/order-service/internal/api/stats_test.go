@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	fixtures "order-service/pkg/testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// insertOrder stores an order of userID in tenantID into orders
+func insertOrder(t *testing.T, orders *mongo.Collection, tenantID, userID string) {
+	t.Helper()
+	order := fixtures.NewOrder().WithUser(userID).Build()
+	order.TenantID = tenantID
+	if _, err := orders.InsertOne(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatsAcrossTenantStores(t *testing.T) {
+	shared := fixtures.MongoDatabase(t).Collection("orders")
+	// globex has a database of its own
+	globex := fixtures.MongoDatabase(t).Collection("orders")
+	insertOrder(t, shared, "acme", "user-1")
+	insertOrder(t, shared, "acme", "user-2")
+	insertOrder(t, shared, "", "user-3")
+	insertOrder(t, globex, "globex", "user-1")
+	api := newMongoAPI(t, Options{TenantOrders: map[string]*mongo.Collection{"globex": globex}}, shared, nil)
+
+	t.Run("revenue by tenant", func(t *testing.T) {
+		w := fixtures.NewRequest(http.MethodGet, "/api/admin/stats/revenue").
+			WithQuery("group_by", "tenant").WithToken(t, admin()).Do(t, api.router)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var report revenueReport
+		fixtures.DecodeJSON(t, w, &report)
+		got := map[string]int{}
+		for _, row := range report.Rows {
+			got[row.TenantID] += row.Orders
+		}
+		want := map[string]int{"acme": 2, "": 1, "globex": 1}
+		if len(got) != len(want) || got["acme"] != 2 || got[""] != 1 || got["globex"] != 1 {
+			t.Errorf("orders by tenant = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("top customers", func(t *testing.T) {
+		w := fixtures.NewRequest(http.MethodGet, "/api/admin/stats/top-customers").WithToken(t, admin()).Do(t, api.router)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var report topCustomersReport
+		fixtures.DecodeJSON(t, w, &report)
+		got := map[string]bool{}
+		for _, customer := range report.Customers {
+			got[customer.TenantID+"/"+customer.UserID] = true
+		}
+		for _, want := range []string{"acme/user-1", "acme/user-2", "/user-3", "globex/user-1"} {
+			if !got[want] {
+				t.Errorf("customers = %v, missing %s", got, want)
+			}
+		}
+	})
+
+	t.Run("tenant admin", func(t *testing.T) {
+		w := fixtures.NewRequest(http.MethodGet, "/api/admin/stats/top-customers").
+			WithToken(t, admin().WithClaim("tenant_id", "globex")).Do(t, api.router)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", w.Code, w.Body)
+		}
+		var report topCustomersReport
+		fixtures.DecodeJSON(t, w, &report)
+		if len(report.Customers) != 1 || report.Customers[0].TenantID != "globex" {
+			t.Errorf("globex admin sees %+v, want globex's customer only", report.Customers)
+		}
+	})
+}
+
+func TestStatsGroupByTenantNeedsOperator(t *testing.T) {
+	api := newTestAPI(t, Options{})
+
+	w := fixtures.NewRequest(http.MethodGet, "/api/admin/stats/revenue").
+		WithQuery("group_by", "tenant").
+		WithToken(t, admin().WithClaim("tenant_id", "acme")).
+		Do(t, api.router)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+}
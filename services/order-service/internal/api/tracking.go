@@ -0,0 +1,350 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/middleware"
+	"order-service/pkg/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// trackingPingInterval is how often the server pings; a client that
+	// has not answered within trackingPongWait is disconnected
+	trackingPingInterval = 30 * time.Second
+	trackingPongWait     = 70 * time.Second
+	trackingWriteWait    = 10 * time.Second
+	// trackingMaxMessage bounds client messages, which only list order IDs
+	trackingMaxMessage = 16 << 10
+)
+
+// TrackingLimits bound the WebSocket order-tracking channel on each
+// instance
+type TrackingLimits struct {
+	// MaxConnections caps open connections
+	MaxConnections int
+	// MaxConnectionsPerUser caps the open connections of one user
+	MaxConnectionsPerUser int
+	// MaxSubscriptions caps how many orders one connection follows
+	MaxSubscriptions int
+}
+
+// DefaultTrackingLimits are used for any limit left unset
+var DefaultTrackingLimits = TrackingLimits{MaxConnections: 1000, MaxConnectionsPerUser: 5, MaxSubscriptions: 50}
+
+// Tracking message types. Clients send subscribe and unsubscribe; the
+// server answers with the rest.
+const (
+	trackSubscribe   = "subscribe"
+	trackUnsubscribe = "unsubscribe"
+	trackSubscribed  = "subscribed"
+	trackStatus      = "status"
+	trackDeleted     = "deleted"
+	trackError       = "error"
+)
+
+// trackingRequest is a client message, e.g.
+// {"type":"subscribe","order_ids":["<id>", ...]}. Orders are identified by
+// the same ID as in /api/orders/{id}.
+type trackingRequest struct {
+	Type     string   `json:"type"`
+	OrderIDs []string `json:"order_ids"`
+}
+
+// trackingMessage is a server message. subscribed carries the order's
+// current status, status each change after it; an error with an id ends
+// that subscription only.
+type trackingMessage struct {
+	Type           string     `json:"type"`
+	ID             string     `json:"id,omitempty"`
+	OrderID        string     `json:"order_id,omitempty"`
+	Status         string     `json:"status,omitempty"`
+	PreviousStatus string     `json:"previous_status,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// trackingConnections counts open tracking connections per user
+type trackingConnections struct {
+	mu     sync.Mutex
+	total  int
+	byUser map[string]int
+}
+
+// acquire reserves a connection for userID unless a limit is reached
+func (t *trackingConnections) acquire(userID string, limits TrackingLimits) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.total >= limits.MaxConnections || t.byUser[userID] >= limits.MaxConnectionsPerUser {
+		return false
+	}
+	if t.byUser == nil {
+		t.byUser = map[string]int{}
+	}
+	t.total++
+	t.byUser[userID]++
+	return true
+}
+
+func (t *trackingConnections) release(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total--
+	if t.byUser[userID]--; t.byUser[userID] == 0 {
+		delete(t.byUser, userID)
+	}
+}
+
+// tokenFromQuery lets browsers, which cannot set headers on a WebSocket
+// handshake, pass the JWT as access_token
+func tokenFromQuery(c *gin.Context) {
+	if token := c.Query("access_token"); token != "" && c.GetHeader("Authorization") == "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+	c.Next()
+}
+
+// trackOrders upgrades to a WebSocket on which the client follows the
+// status of its orders; staff may follow any order
+//
+//	GET /ws/orders
+func (h *Handler) trackOrders(c *gin.Context) {
+	userID := c.GetString(middleware.ContextUserID)
+	if !h.tracking.acquire(userID, h.opts.Tracking) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many tracking connections"})
+		return
+	}
+	defer h.tracking.release(userID)
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || middleware.OriginAllowed(h.opts.CORSAllowedOrigins, origin)
+		},
+	}
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has already answered
+		return
+	}
+	defer conn.Close()
+
+	t := &tracker{
+		h:       h,
+		conn:    conn,
+		userID:  userID,
+		admin:   middleware.HasScope(c, middleware.ScopeOrdersFulfill),
+		out:     make(chan trackingMessage, 64),
+		done:    make(chan struct{}),
+		watches: map[string]*subscription{},
+	}
+
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		t.read(c.Request.Context())
+	}()
+	t.write(readDone)
+
+	// Unblock the reader and wait for it before touching its subscriptions
+	close(t.done)
+	conn.Close()
+	<-readDone
+	for _, sub := range t.watches {
+		sub.stop()
+	}
+}
+
+// tracker serves one tracking connection. Only write uses the connection's
+// writer, and only read touches watches until it has returned.
+type tracker struct {
+	h      *Handler
+	conn   *websocket.Conn
+	userID string
+	admin  bool
+
+	out     chan trackingMessage
+	done    chan struct{}
+	watches map[string]*subscription
+}
+
+// subscription is one order followed by a connection
+type subscription struct {
+	once    sync.Once
+	stopped chan struct{}
+	unwatch func()
+}
+
+// stop ends the subscription; it may be called more than once
+func (s *subscription) stop() {
+	s.once.Do(func() {
+		close(s.stopped)
+		s.unwatch()
+	})
+}
+
+// write sends queued messages and pings until the connection fails or
+// readDone is closed
+func (t *tracker) write(readDone <-chan struct{}) {
+	ping := time.NewTicker(trackingPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-readDone:
+			t.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(trackingWriteWait))
+			return
+		case msg := <-t.out:
+			t.conn.SetWriteDeadline(time.Now().Add(trackingWriteWait))
+			if err := t.conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := t.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(trackingWriteWait)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// send queues msg unless the connection is closing
+func (t *tracker) send(msg trackingMessage) bool {
+	select {
+	case t.out <- msg:
+		return true
+	case <-t.done:
+		return false
+	}
+}
+
+// read handles client messages until the connection fails or closes
+func (t *tracker) read(ctx context.Context) {
+	t.conn.SetReadLimit(trackingMaxMessage)
+	t.conn.SetReadDeadline(time.Now().Add(trackingPongWait))
+	t.conn.SetPongHandler(func(string) error {
+		return t.conn.SetReadDeadline(time.Now().Add(trackingPongWait))
+	})
+
+	for {
+		_, data, err := t.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req trackingRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.send(trackingMessage{Type: trackError, Error: "Invalid message"})
+			continue
+		}
+
+		switch req.Type {
+		case trackSubscribe:
+			for _, id := range req.OrderIDs {
+				t.subscribe(ctx, id)
+			}
+		case trackUnsubscribe:
+			for _, id := range req.OrderIDs {
+				if sub, ok := t.watches[id]; ok {
+					sub.stop()
+					delete(t.watches, id)
+				}
+			}
+		default:
+			t.send(trackingMessage{Type: trackError, Error: "type must be subscribe or unsubscribe"})
+		}
+	}
+}
+
+// subscribe starts following order id after checking the caller may see it
+func (t *tracker) subscribe(ctx context.Context, id string) {
+	fail := func(message string) {
+		t.send(trackingMessage{Type: trackError, ID: id, Error: message})
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		fail("Invalid order ID")
+		return
+	}
+	if sub, ok := t.watches[id]; ok {
+		// Subscribing again starts over from the current state
+		sub.stop()
+		delete(t.watches, id)
+	}
+	if len(t.watches) >= t.h.opts.Tracking.MaxSubscriptions {
+		fail("Too many subscriptions")
+		return
+	}
+
+	getCtx, cancel := context.WithTimeout(ctx, t.h.deadlines.Load().Read)
+	defer cancel()
+	order, err := t.h.orders.Get(getCtx, objectID)
+	if err == nil && order.UserID != t.userID && !t.admin {
+		err = repository.ErrNotFound
+	}
+	var updates <-chan contracts.Event
+	sub := &subscription{stopped: make(chan struct{})}
+	if err == nil {
+		// Read the order again once watching, so no change falls in between
+		updates, sub.unwatch = t.h.opts.Live.Watch(order.OrderID)
+		if order, err = t.h.orders.Get(getCtx, objectID); err != nil {
+			sub.stop()
+		}
+	}
+	if err == repository.ErrNotFound {
+		fail("Order not found")
+		return
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", id).Msg("Failed to get order for tracking")
+		fail("Failed to get order")
+		return
+	}
+
+	t.watches[id] = sub
+	updatedAt := order.UpdatedAt
+	t.send(trackingMessage{Type: trackSubscribed, ID: id, OrderID: order.OrderID, Status: order.Status, UpdatedAt: &updatedAt})
+	go t.forward(id, order, sub, updates)
+}
+
+// forward relays the updates of one subscription until it is stopped, the
+// order is deleted or the feed drops it for falling behind
+func (t *tracker) forward(id string, order contracts.Order, sub *subscription, updates <-chan contracts.Event) {
+	current := order.UpdatedAt
+	for event := range updates {
+		// The subscribed message already covers anything older
+		if !event.Order.UpdatedAt.After(current) {
+			continue
+		}
+		current = event.Order.UpdatedAt
+
+		msg := trackingMessage{ID: id, OrderID: order.OrderID}
+		switch event.Type {
+		case contracts.EventOrderStatusChanged:
+			updatedAt := event.Order.UpdatedAt
+			msg.Type, msg.Status, msg.PreviousStatus, msg.UpdatedAt = trackStatus, event.Order.Status, event.PreviousStatus, &updatedAt
+		case contracts.EventOrderDeleted:
+			t.send(trackingMessage{Type: trackDeleted, ID: id, OrderID: order.OrderID})
+			return
+		default:
+			continue
+		}
+		if !t.send(msg) {
+			return
+		}
+	}
+
+	select {
+	case <-sub.stopped:
+	default:
+		t.send(trackingMessage{Type: trackError, ID: id, Error: "Fell behind, subscribe again"})
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"order-service/pkg/audit"
+	"order-service/pkg/middleware"
+	"order-service/pkg/privacy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// UserData erases and exports the order data held on users, in the tenant
+// of the context or every tenant without one; it is implemented by
+// *privacy.Service
+type UserData interface {
+	Erase(ctx context.Context, userID string) (privacy.Erasure, error)
+	Export(ctx context.Context, userID string) (privacy.Export, error)
+}
+
+func (h *Handler) eraseUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	ctx := c.Request.Context()
+
+	erasure, err := h.opts.UserData.Erase(ctx, userID)
+	if errors.Is(err, privacy.ErrErasureDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Erasure is not configured"})
+		return
+	}
+	// Orders anonymized before a failure stay anonymized, so the attempt is
+	// recorded either way
+	after := audit.Fields{"orders": erasure.Orders, "thawed": erasure.Thawed, "copies": erasure.Copies, "runs": erasure.Runs}
+	if erasure.TenantID != "" {
+		after["tenant"] = erasure.TenantID
+	}
+	if err != nil {
+		after["error"] = err.Error()
+	}
+	h.opts.Audit.Record(ctx, audit.Record{
+		Action:   audit.ActionUserErase,
+		Resource: audit.Resource{Type: audit.ResourceUser, ID: userID},
+		After:    after,
+	})
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(ctx).Error().Err(err).Int("orders", erasure.Orders).Msg("User data erasure failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Erasure failed; erasing again carries on", "orders": erasure.Orders})
+		return
+	}
+
+	log.Ctx(ctx).Info().
+		Int("orders", erasure.Orders).
+		Int("thawed", erasure.Thawed).
+		Str("tenant", erasure.TenantID).
+		Str("requested_by", c.GetString(middleware.ContextUserID)).
+		Msg("User data erased")
+	c.JSON(http.StatusOK, erasure)
+}
+
+func (h *Handler) exportUserData(c *gin.Context) {
+	userID := c.Param("userId")
+	ctx := c.Request.Context()
+
+	export, err := h.opts.UserData.Export(ctx, userID)
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to export user data")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export user data"})
+		return
+	}
+
+	after := audit.Fields{"orders": len(export.Orders)}
+	if export.TenantID != "" {
+		after["tenant"] = export.TenantID
+	}
+	h.opts.Audit.Record(ctx, audit.Record{
+		Action:   audit.ActionUserExport,
+		Resource: audit.Resource{Type: audit.ResourceUser, ID: userID},
+		After:    after,
+	})
+	c.Header("Content-Disposition", `attachment; filename="order-data.json"`)
+	c.JSON(http.StatusOK, export)
+}
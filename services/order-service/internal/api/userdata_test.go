@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"order-service/pkg/privacy"
+	"order-service/pkg/tenant"
+	fixtures "order-service/pkg/testing"
+)
+
+// fakeUserData records the tenant of every request
+type fakeUserData struct {
+	tenants []string
+}
+
+func (f *fakeUserData) Erase(ctx context.Context, userID string) (privacy.Erasure, error) {
+	f.tenants = append(f.tenants, tenant.FromContext(ctx))
+	return privacy.Erasure{UserID: userID, TenantID: tenant.FromContext(ctx), Copies: map[string]int64{}, Runs: []string{}}, nil
+}
+
+func (f *fakeUserData) Export(ctx context.Context, userID string) (privacy.Export, error) {
+	f.tenants = append(f.tenants, tenant.FromContext(ctx))
+	return privacy.Export{UserID: userID, TenantID: tenant.FromContext(ctx)}, nil
+}
+
+func TestUserDataTenant(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *fixtures.TokenBuilder
+		want  string
+	}{
+		{name: "tenant admin", token: admin().WithClaim("tenant_id", "acme"), want: "acme"},
+		{name: "operator", token: admin()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &fakeUserData{}
+			api := newTestAPI(t, Options{UserData: data, RequireTenant: true})
+
+			for _, req := range []*fixtures.RequestBuilder{
+				fixtures.NewRequest(http.MethodDelete, "/api/admin/users/user-1/data"),
+				fixtures.NewRequest(http.MethodGet, "/api/admin/users/user-1/data-export"),
+			} {
+				if w := req.WithToken(t, tt.token).Do(t, api.router); w.Code != http.StatusOK {
+					t.Fatalf("status = %d: %s", w.Code, w.Body)
+				}
+			}
+			if len(data.tenants) != 2 || data.tenants[0] != tt.want || data.tenants[1] != tt.want {
+				t.Errorf("tenants = %q, want %q for both", data.tenants, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserDataNeedsAdmin(t *testing.T) {
+	data := &fakeUserData{}
+	api := newTestAPI(t, Options{UserData: data})
+
+	for _, token := range []*fixtures.TokenBuilder{customer(), fulfillment()} {
+		w := fixtures.NewRequest(http.MethodDelete, "/api/admin/users/user-1/data").WithToken(t, token).Do(t, api.router)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+		}
+	}
+	if len(data.tenants) != 0 {
+		t.Errorf("erased without the admin scope")
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// API versions. Version 1 is the wire format clients have always had and is
+// served under /api/v1 and the unversioned /api; version 2 is served under
+// /api/v2. Handlers whose rendering differs between versions check
+// apiVersion; everything else is shared.
+//
+// Version 2 differs from version 1 in:
+//   - GET /orders/user/{userId} always answers a page, with or without
+//     paging parameters
+//   - amounts on orders and user summaries are money objects; version 1
+//     renders them as bare numbers, as before they carried a currency (see
+//     presentation.legacy)
+const (
+	apiV1 = 1
+	apiV2 = 2
+)
+
+// contextAPIVersion holds the version of the route that matched
+const contextAPIVersion = "apiVersion"
+
+// apiVersionPrefix is one prefix the REST API is served under
+type apiVersionPrefix struct {
+	prefix  string
+	version int
+}
+
+var apiVersions = []apiVersionPrefix{
+	{prefix: "/api", version: apiV1},
+	{prefix: "/api/v1", version: apiV1},
+	{prefix: "/api/v2", version: apiV2},
+}
+
+// versioned records v for the handlers. Version 1 responses are marked
+// deprecated and link to their version 2 equivalent; Sunset is added once
+// a removal date is set.
+func (h *Handler) versioned(v apiVersionPrefix) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(contextAPIVersion, v.version)
+		if v.version == apiV1 {
+			c.Header("Deprecation", "true")
+			c.Header("Link", `</api/v2`+strings.TrimPrefix(c.Request.URL.Path, v.prefix)+`>; rel="successor-version"`)
+			if !h.opts.V1Sunset.IsZero() {
+				c.Header("Sunset", h.opts.V1Sunset.UTC().Format(http.TimeFormat))
+			}
+		}
+		c.Next()
+	}
+}
+
+// apiVersion returns the version of the request's route, 1 outside the
+// versioned API
+func apiVersion(c *gin.Context) int {
+	if v := c.GetInt(contextAPIVersion); v != 0 {
+		return v
+	}
+	return apiV1
+}
+
+// unversionedPath strips the version from a route, so /api/v2/orders/:id
+// shares the deadlines and documentation of /api/orders/:id
+func unversionedPath(path string) string {
+	for _, v := range apiVersions {
+		if v.prefix != "/api" && strings.HasPrefix(path, v.prefix+"/") {
+			return "/api" + strings.TrimPrefix(path, v.prefix)
+		}
+	}
+	return path
+}
+
+// versionedPaths returns the unversioned API route path under every prefix
+func versionedPaths(path string) []string {
+	paths := make([]string, 0, len(apiVersions))
+	for _, v := range apiVersions {
+		paths = append(paths, v.prefix+strings.TrimPrefix(path, "/api"))
+	}
+	return paths
+}
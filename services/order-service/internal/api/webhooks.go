@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"order-service/pkg/middleware"
+	"order-service/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhooks manages webhook subscriptions; it is implemented by
+// *webhook.Dispatcher
+type Webhooks interface {
+	Subscribe(ctx context.Context, owner, url string, eventTypes []string, allOrders bool) (webhook.Subscription, error)
+	Subscriptions(ctx context.Context, owner string) ([]webhook.Subscription, error)
+	Unsubscribe(ctx context.Context, owner string, id primitive.ObjectID) error
+	Deliveries(ctx context.Context, owner string, id primitive.ObjectID, limit int) ([]webhook.Delivery, error)
+	Ping(ctx context.Context, owner string, id primitive.ObjectID) (webhook.Delivery, error)
+}
+
+// CreateWebhookRequest represents the request payload for subscribing a URL
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" binding:"required"`
+	EventTypes []string `json:"event_types"`
+}
+
+func (h *Handler) createWebhook(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	// Admins subscribe on behalf of the shop, so they receive every order
+	allOrders := middleware.HasScope(c, middleware.ScopeOrdersAdmin)
+	sub, err := h.opts.Webhooks.Subscribe(ctx, c.GetString(middleware.ContextUserID), req.URL, req.EventTypes, allOrders)
+	if err == webhook.ErrInvalidURL || err == webhook.ErrForbiddenAddress || errors.Is(err, webhook.ErrUnknownEventType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to create webhook subscription")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, sub)
+}
+
+func (h *Handler) listWebhooks(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	subs, err := h.opts.Webhooks.Subscriptions(ctx, c.GetString(middleware.ContextUserID))
+	if err != nil {
+		if middleware.RequestFailed(c, err) {
+			return
+		}
+		log.Ctx(c.Request.Context()).Error().Err(err).Msg("Failed to list webhook subscriptions")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subs)
+}
+
+func (h *Handler) deleteWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	if err := h.opts.Webhooks.Unsubscribe(ctx, c.GetString(middleware.ContextUserID), id); err != nil {
+		h.webhookError(c, err, "Failed to delete webhook")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) getWebhookDeliveries(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	ctx := c.Request.Context()
+
+	deliveries, err := h.opts.Webhooks.Deliveries(ctx, c.GetString(middleware.ContextUserID), id, limit)
+	if err != nil {
+		h.webhookError(c, err, "Failed to get webhook deliveries")
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+func (h *Handler) pingWebhook(c *gin.Context) {
+	id, ok := webhookID(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+
+	delivery, err := h.opts.Webhooks.Ping(ctx, c.GetString(middleware.ContextUserID), id)
+	if err != nil {
+		h.webhookError(c, err, "Failed to ping webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+func webhookID(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return id, false
+	}
+	return id, true
+}
+
+func (h *Handler) webhookError(c *gin.Context, err error, message string) {
+	if err == webhook.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	if middleware.RequestFailed(c, err) {
+		return
+	}
+	log.Ctx(c.Request.Context()).Error().Err(err).Str("webhook_id", c.Param("id")).Msg(message)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"order-service/pkg/middleware"
+	"order-service/pkg/tenant"
+	fixtures "order-service/pkg/testing"
+	"order-service/pkg/webhook"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeWebhooks keeps subscriptions in memory, by owner and tenant
+type fakeWebhooks struct {
+	mu   sync.Mutex
+	subs []webhook.Subscription
+}
+
+func (f *fakeWebhooks) Subscribe(ctx context.Context, owner, url string, eventTypes []string, allOrders bool) (webhook.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	sub := webhook.Subscription{ID: primitive.NewObjectID(), Owner: owner, TenantID: tenant.FromContext(ctx), URL: url, EventTypes: eventTypes, AllOrders: allOrders, Secret: "secret"}
+	f.subs = append(f.subs, sub)
+	return sub, nil
+}
+
+func (f *fakeWebhooks) Subscriptions(ctx context.Context, owner string) ([]webhook.Subscription, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	subs := []webhook.Subscription{}
+	for _, sub := range f.subs {
+		if sub.Owner == owner && sub.TenantID == tenant.FromContext(ctx) {
+			sub.Secret = ""
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func (f *fakeWebhooks) subscription(ctx context.Context, owner string, id primitive.ObjectID) (int, error) {
+	for i, sub := range f.subs {
+		if sub.ID == id && sub.Owner == owner && sub.TenantID == tenant.FromContext(ctx) {
+			return i, nil
+		}
+	}
+	return -1, webhook.ErrNotFound
+}
+
+func (f *fakeWebhooks) Unsubscribe(ctx context.Context, owner string, id primitive.ObjectID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i, err := f.subscription(ctx, owner, id)
+	if err != nil {
+		return err
+	}
+	f.subs = append(f.subs[:i], f.subs[i+1:]...)
+	return nil
+}
+
+func (f *fakeWebhooks) Deliveries(ctx context.Context, owner string, id primitive.ObjectID, limit int) ([]webhook.Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.subscription(ctx, owner, id); err != nil {
+		return nil, err
+	}
+	return []webhook.Delivery{}, nil
+}
+
+func (f *fakeWebhooks) Ping(ctx context.Context, owner string, id primitive.ObjectID) (webhook.Delivery, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.subscription(ctx, owner, id); err != nil {
+		return webhook.Delivery{}, err
+	}
+	return webhook.Delivery{SubscriptionID: id, EventType: webhook.EventPing, Status: webhook.StatusSucceeded}, nil
+}
+
+func admin() *fixtures.TokenBuilder {
+	return fixtures.NewToken().ForUser("admin-1").WithClaim("role", middleware.RoleAdmin)
+}
+
+func TestWebhooksScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		token *fixtures.TokenBuilder
+		want  int
+	}{
+		{name: "customer", token: customer(), want: http.StatusForbidden},
+		{name: "fulfillment", token: fulfillment(), want: http.StatusForbidden},
+		{name: "customer with read scope", token: customer().WithClaim("scope", middleware.ScopeOrdersRead), want: http.StatusForbidden},
+		{name: "integration with webhooks scope", token: customer().WithClaim("scope", middleware.ScopeWebhooks), want: http.StatusCreated},
+		{name: "admin", token: admin(), want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hooks := &fakeWebhooks{}
+			api := newTestAPI(t, Options{Webhooks: hooks})
+
+			w := fixtures.NewRequest(http.MethodPost, "/api/webhooks").
+				WithToken(t, tt.token).
+				WithJSON(CreateWebhookRequest{URL: "https://erp.example.com/hooks"}).
+				Do(t, api.router)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if subs, _ := hooks.Subscriptions(context.Background(), "user-1"); tt.want == http.StatusForbidden && len(subs) != 0 {
+				t.Errorf("subscription created without the scope: %+v", subs)
+			}
+		})
+	}
+}
+
+func TestWebhooksTenant(t *testing.T) {
+	hooks := &fakeWebhooks{}
+	api := newTestAPI(t, Options{Webhooks: hooks})
+	acme := admin().WithClaim("tenant_id", "acme")
+	globex := admin().WithClaim("tenant_id", "globex")
+
+	w := fixtures.NewRequest(http.MethodPost, "/api/webhooks").
+		WithToken(t, acme).
+		WithJSON(CreateWebhookRequest{URL: "https://erp.example.com/hooks"}).
+		Do(t, api.router)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if len(hooks.subs) != 1 || hooks.subs[0].TenantID != "acme" || !hooks.subs[0].AllOrders {
+		t.Fatalf("subscriptions = %+v, want one for all orders of tenant acme", hooks.subs)
+	}
+	id := hooks.subs[0].ID.Hex()
+
+	w = fixtures.NewRequest(http.MethodDelete, "/api/webhooks/"+id).WithToken(t, globex).Do(t, api.router)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("delete from another tenant: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	w = fixtures.NewRequest(http.MethodDelete, "/api/webhooks/"+id).WithToken(t, acme).Do(t, api.router)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete from the tenant: status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,949 @@
+// Package app wires the order service together. Components are built in a
+// fixed order — config, logger, Mongo, stores, repository, services,
+// handlers — and each step is an exported constructor, so entrypoints (the
+// API server, the projector worker, the orderctl CLI) can build only the
+// parts they need.
+package app
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"order-service/internal/api"
+	"order-service/internal/grpcapi"
+	"order-service/internal/service"
+	"order-service/pkg/address"
+	"order-service/pkg/archive"
+	"order-service/pkg/audit"
+	"order-service/pkg/breaker"
+	"order-service/pkg/cdc"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/currency"
+	"order-service/pkg/dbmonitor"
+	"order-service/pkg/errreport"
+	"order-service/pkg/events"
+	"order-service/pkg/featureflags"
+	"order-service/pkg/fieldcrypt"
+	"order-service/pkg/health"
+	"order-service/pkg/idempotency"
+	"order-service/pkg/inventory"
+	"order-service/pkg/jobs"
+	"order-service/pkg/jwks"
+	"order-service/pkg/leader"
+	"order-service/pkg/live"
+	"order-service/pkg/loglevel"
+	"order-service/pkg/middleware"
+	"order-service/pkg/notify"
+	"order-service/pkg/objectstore"
+	"order-service/pkg/payment"
+	"order-service/pkg/privacy"
+	"order-service/pkg/projection"
+	"order-service/pkg/promotion"
+	"order-service/pkg/redact"
+	"order-service/pkg/repository"
+	"order-service/pkg/retention"
+	"order-service/pkg/saga"
+	"order-service/pkg/search"
+	"order-service/pkg/webhook"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// App holds the fully wired components of the order service
+type App struct {
+	Config Config
+
+	Mongo      *mongo.Client
+	ReadMongo  *mongo.Client
+	DB         *mongo.Database
+	ReadModels *mongo.Database
+	// Peers are the databases of other regions when running multi-region
+	Peers map[string]*mongo.Client
+
+	Clock     clock.Clock
+	Events    *events.Store
+	Publisher events.Publisher
+	Bus       events.MessageBus
+	Orders    repository.OrderRepository
+	Regional  *repository.RegionalRepository
+	Service   *service.OrderService
+	Currency  *currency.Converter
+	Catalog   *projection.HTTPCatalog
+	Webhooks  *webhook.Dispatcher
+	Audit     *audit.Log
+	// Errors reports panics and server errors; errreport.Nop without a
+	// tracker
+	Errors errreport.ErrorReporter
+	// Flags decide the features rolled out gradually; nil leaves them at
+	// their defaults
+	Flags      *featureflags.Flags
+	Live       *live.Feed
+	Promotions *promotion.Store
+	Archiver   *archive.Archiver
+	ReadOnly   *middleware.ReadOnlyMode
+	Readiness  *health.Readiness
+	Secrets    *Secrets
+	// JWKS verifies tokens signed by the auth service's rotating keys;
+	// nil without JWKS_URL
+	JWKS *jwks.Set
+	// JWTPublicKeys verify asymmetrically signed tokens alongside JWKS
+	JWTPublicKeys []crypto.PublicKey
+	// ChangeStreams derive the order events from the orders collections
+	// with ORDER_EVENT_SOURCE=changestream
+	ChangeStreams []*cdc.Watcher
+	// Search serves the admin listings; nil without ELASTICSEARCH_URL
+	Search *search.Index
+	// ObjectStore is the bucket of OBJECT_STORE_BUCKET, or nil
+	ObjectStore *objectstore.S3
+	// ColdStorage exports old orders to ObjectStore and reads them back;
+	// nil without ORDER_COLD_STORAGE_MONTHS
+	ColdStorage *archive.ColdStorage
+	// Keyring encrypts the personal data on orders; nil without
+	// FIELD_ENCRYPTION
+	Keyring *fieldcrypt.Keyring
+	// Leader runs the background jobs sweeping every order on one replica
+	// at a time; nil with LEADER_ELECTION=false
+	Leader *leader.Elector
+	// Jobs queues background work for the worker pools of every replica;
+	// nil with JOB_WORKERS=0
+	Jobs *jobs.Queue
+}
+
+// SetupLogger configures the global zerolog logger and the access log,
+// redacting their entries with the default rules until SetLogRedaction
+func SetupLogger() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	logRedaction = redact.NewWriter(os.Stdout, redact.Default())
+	middleware.AccessLog = logRedaction
+	loglevel.Setup(zerolog.ConsoleWriter{Out: logRedaction, TimeFormat: time.RFC3339})
+}
+
+// SetLogLevel sets the configured minimum level logged, such as
+// Config.LogLevel, which LoadConfig has validated. An override made
+// through the admin API stays in force until it ends.
+func SetLogLevel(level string) {
+	if l, err := zerolog.ParseLevel(level); err == nil && level != "" {
+		loglevel.SetConfigured(l)
+	}
+}
+
+// New fetches the secrets cfg references, connects to MongoDB and builds
+// every component from cfg
+func New(ctx context.Context, cfg Config) (*App, error) {
+	a := &App{Config: cfg}
+
+	if err := UseOutboundTLS(cfg.TLS); err != nil {
+		return nil, fmt.Errorf("outbound TLS: %w", err)
+	}
+	var err error
+	if a.Secrets, err = LoadSecrets(ctx, &a.Config, clock.System{}); err != nil {
+		return nil, fmt.Errorf("load secrets: %w", err)
+	}
+	cfg = a.Config
+	if a.Mongo, err = ConnectMongo(ctx, cfg.MongoURI, cfg.Mongo); err != nil {
+		return nil, fmt.Errorf("connect to MongoDB: %w", err)
+	}
+	a.ReadMongo = a.Mongo
+	if cfg.ReadModelURI != cfg.MongoURI {
+		if a.ReadMongo, err = ConnectMongo(ctx, cfg.ReadModelURI, cfg.Mongo); err != nil {
+			a.Close(ctx)
+			return nil, fmt.Errorf("connect to read model MongoDB: %w", err)
+		}
+	}
+	a.DB = a.Mongo.Database(cfg.Database)
+	a.ReadModels = a.ReadMongo.Database(cfg.ReadModelDatabase)
+
+	a.Clock = clock.System{}
+	if a.Keyring, err = UseFieldEncryption(ctx, cfg, a.DB, a.Clock); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	a.ReadOnly = &middleware.ReadOnlyMode{}
+	if cfg.ReadOnly {
+		a.ReadOnly.Set(true, "READ_ONLY is set", a.Clock.Now())
+	}
+	a.Events = NewEventStore(ctx, a.DB, a.Clock)
+	a.Live = live.NewFeed(a.Events)
+	a.Live.Clock = a.Clock
+	a.Jobs = NewJobQueue(ctx, cfg, a.DB, a.Clock)
+	a.Webhooks = NewWebhookDispatcher(ctx, cfg, a.DB, a.Jobs, a.Clock)
+	a.Publisher = events.MultiPublisher{NewPublisher(cfg, a.Jobs, a.Clock), a.Webhooks}
+	if a.Bus, err = NewMessageBus(cfg.Bus); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	if a.Bus != nil {
+		a.Publisher = events.MultiPublisher{a.Publisher, a.Bus}
+	}
+
+	if cfg.Region.Region != "" {
+		if a.Peers, err = ConnectPeers(ctx, cfg); err != nil {
+			a.Close(ctx)
+			return nil, err
+		}
+		a.Regional = NewRegionalRepository(ctx, cfg, a.DB, a.Peers, a.Clock)
+		a.Orders = a.Regional
+	} else if a.Orders, err = NewOrderRepository(ctx, cfg, a.DB, a.Clock); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	a.ObjectStore = NewObjectStore(cfg)
+	if cfg.ColdStorage.Months > 0 {
+		a.ColdStorage = NewColdStorage(ctx, cfg, a.DB, a.ObjectStore, a.Clock)
+		a.Orders = archive.NewColdRepository(a.Orders, a.ColdStorage)
+	}
+	if a.Orders, err = NewTenantRepository(ctx, cfg, a.Mongo, a.Orders, a.Clock); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	if cfg.Mongo.BreakerThreshold > 0 {
+		b := breaker.New("mongodb", cfg.Mongo.BreakerThreshold, cfg.Mongo.BreakerCooldown, a.Clock)
+		a.Orders = repository.NewBreakerRepository(a.Orders, b, cfg.Mongo.BreakerSlowCall)
+	}
+	ensureOrderIndexes(ctx, cfg, a.DB.Collection("orders"))
+	a.Service = service.NewOrderService(a.Orders, a.Events, a.Publisher, a.Clock)
+	a.Service.Limits = cfg.OrderLimits
+	a.Service.DetachedTimeout = cfg.DetachedTimeout
+	if cfg.EventSource == "changestream" {
+		a.Service.ChangeStreamEvents = true
+		a.ChangeStreams = NewChangeStreamWatchers(cfg, a.Mongo, a.DB, a.Events, a.Publisher, a.Clock)
+	}
+	// Regional storage reads through the clients of other regions, which
+	// cannot join a transaction of this one
+	if cfg.Region.Region == "" {
+		a.Service.Transactions = repository.NewTransactions(a.Mongo)
+	}
+	if a.Audit, err = NewAuditLog(ctx, cfg, a.DB, a.Clock); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	if a.Errors, err = NewErrorReporter(cfg); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	if a.Flags, err = NewFeatureFlags(ctx, cfg); err != nil {
+		a.Close(ctx)
+		return nil, fmt.Errorf("feature flags: %w", err)
+	}
+	a.Service.Flags = a.Flags
+	a.Service.Audit = a.Audit
+	a.Catalog = NewCatalog(cfg, cfg.CatalogCacheTTL, a.Clock)
+	a.Service.Catalog = a.Catalog
+	a.Service.LegacyClientPrices = cfg.LegacyClientPrices
+	if cfg.LegacyClientPrices {
+		log.Warn().Msg("LEGACY_CLIENT_PRICES is enabled: client-supplied item prices are trusted")
+	}
+	a.Service.Pricing = NewPricingCalculator(cfg.Pricing)
+	if cfg.InventoryReservations {
+		a.Service.Inventory = inventory.NewClient(cfg.ProductServiceURL, cfg.InternalAPIToken)
+	}
+	if cfg.PaymentServiceURL != "" {
+		a.Service.Payments = payment.NewClient(cfg.PaymentServiceURL)
+	}
+	if a.Service.Inventory != nil || a.Service.Payments != nil {
+		a.Service.Sagas = NewSagaStore(ctx, a.DB, a.Clock)
+	}
+	a.Service.Idempotency = NewIdempotencyStore(ctx, cfg, a.DB, a.Clock)
+	if cfg.AddressValidationURL != "" {
+		a.Service.Addresses = address.NewClient(cfg.AddressValidationURL, cfg.AddressValidationAPIKey)
+	}
+
+	if a.Currency, err = NewCurrencyConverter(cfg.Currency, a.Clock); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	a.Service.Rates = a.Currency
+	a.Service.SettlementCurrency = cfg.Currency.SettlementCurrency
+	a.Promotions = NewPromotionStore(ctx, a.DB)
+	a.Service.Promotions = a.Promotions
+	if cfg.OrderArchiveAfter > 0 {
+		a.Archiver = NewArchiver(ctx, cfg, a.DB, a.Clock)
+	}
+	a.Leader = NewElector(cfg, a.DB, a.Clock)
+	if cfg.JWKSURL != "" {
+		a.JWKS = NewJWKS(ctx, cfg.JWKSURL, a.Clock)
+	}
+	if a.JWTPublicKeys, err = LoadPublicKeys(cfg.JWTPublicKeyFiles); err != nil {
+		a.Close(ctx)
+		return nil, err
+	}
+	a.Search = NewSearchIndex(cfg)
+	a.Readiness = a.newReadiness()
+
+	return a, nil
+}
+
+// newReadiness checks the dependencies every request may need: MongoDB,
+// the message bus and the exchange rates. product-service is only needed
+// to place orders, so its outage is reported without making the instance
+// unready.
+func (a *App) newReadiness() *health.Readiness {
+	r := &health.Readiness{Clock: a.Clock}
+	r.Add("mongodb", func(ctx context.Context) error { return a.Mongo.Ping(ctx, nil) })
+	if a.ReadMongo != a.Mongo {
+		r.Add("read_models", func(ctx context.Context) error { return a.ReadMongo.Ping(ctx, nil) })
+	}
+	if a.Bus != nil {
+		r.Add("message_bus", a.Bus.Ping)
+	}
+	r.Add("exchange_rates", a.Currency.Warm)
+	if a.Config.Mongo.RequireIndexes {
+		r.Add("indexes", a.checkOrderIndexes)
+	}
+	if a.JWKS != nil {
+		// Without keys no request can be authenticated
+		r.Add("jwks", a.JWKS.Ready)
+	}
+	r.AddOptional("product_service", a.Catalog.Ping)
+	if a.Search != nil {
+		// Only admin listings need it
+		r.AddOptional("search", a.Search.Ping)
+	}
+	if a.ObjectStore != nil {
+		// Only exports and reads of exported orders need it
+		r.AddOptional("object_store", a.ObjectStore.Ping)
+	}
+	return r
+}
+
+// Close flushes pending message bus and audit writes and disconnects from
+// MongoDB
+func (a *App) Close(ctx context.Context) {
+	if a.Bus != nil {
+		if err := a.Bus.Close(); err != nil {
+			log.Error().Err(err).Msg("Failed to close message bus")
+		}
+	}
+	if err := a.Audit.Close(); err != nil {
+		log.Error().Err(err).Msg("Failed to close audit log")
+	}
+	if a.Errors != nil {
+		if err := a.Errors.Close(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to send pending error reports")
+		}
+	}
+	for _, peer := range a.Peers {
+		peer.Disconnect(ctx)
+	}
+	if a.ReadMongo != nil && a.ReadMongo != a.Mongo {
+		a.ReadMongo.Disconnect(ctx)
+	}
+	if a.Mongo != nil {
+		a.Mongo.Disconnect(ctx)
+	}
+}
+
+// ensureOrderIndexes creates the missing indexes of the orders collection,
+// unless MONGODB_SKIP_INDEX_CREATION is set, and logs the state of each.
+// Failures are logged: queries still work, only slower, and readiness
+// reports critical indexes missing when MONGODB_REQUIRE_INDEXES is set.
+func ensureOrderIndexes(ctx context.Context, cfg Config, orders *mongo.Collection) {
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	report, err := repository.EnsureOrderIndexes(indexCtx, orders, !cfg.Mongo.SkipIndexCreation, cfg.Mongo.ShardKey)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to verify order indexes")
+		return
+	}
+	report.Log()
+}
+
+// checkOrderIndexes fails while a critical index of the orders collection
+// is missing
+func (a *App) checkOrderIndexes(ctx context.Context) error {
+	report, err := repository.EnsureOrderIndexes(ctx, a.DB.Collection("orders"), false, a.Config.Mongo.ShardKey)
+	if err != nil {
+		return err
+	}
+	return report.Err()
+}
+
+// NewEventStore returns the order event store in db. Index creation failures
+// are logged rather than fatal so the service can start against a degraded
+// cluster.
+func NewEventStore(ctx context.Context, db *mongo.Database, clk clock.Clock) *events.Store {
+	store := events.NewStore(db.Collection("events"))
+	store.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create event store indexes")
+	}
+	return store
+}
+
+// NewPublisher returns the publisher order events are sent to. With an
+// INTERNAL_API_TOKEN, events also notify users as their preferences allow,
+// through queue when not nil.
+func NewPublisher(cfg Config, queue *jobs.Queue, clk clock.Clock) events.Publisher {
+	if cfg.InternalAPIToken == "" {
+		return events.LogPublisher{}
+	}
+
+	prefs := notify.NewUserServicePreferences(cfg.UserServiceURL, cfg.InternalAPIToken)
+	notifier := notify.NewNotifier(prefs, map[string]notify.Sender{
+		notify.ChannelEmail: notify.LogSender{},
+		notify.ChannelSMS:   notify.LogSender{},
+		notify.ChannelPush:  notify.LogSender{},
+	})
+	notifier.Clock = clk
+	if queue != nil {
+		notifier.Jobs = queue
+		queue.Handle(notify.JobNotify, notifier.RunNotifyJob)
+		queue.Handle(notify.JobSend, notifier.RunSendJob)
+	}
+	return events.MultiPublisher{events.LogPublisher{}, notifier}
+}
+
+// NewIdempotencyStore returns the store of order Idempotency-Keys in db.
+// Index creation failures are logged.
+func NewIdempotencyStore(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) *idempotency.Store {
+	store := idempotency.NewStore(db.Collection("idempotency_keys"), cfg.IdempotencyKeyTTL)
+	store.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create idempotency key indexes")
+	}
+	return store
+}
+
+// NewSagaStore returns the store of order placement sagas in db. Index
+// creation failures are logged.
+func NewSagaStore(ctx context.Context, db *mongo.Database, clk clock.Clock) *saga.Store {
+	store := saga.NewStore(db.Collection("order_sagas"))
+	store.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create order saga indexes")
+	}
+	return store
+}
+
+// NewPromotionStore returns the promotion code store in db. Index creation
+// failures are logged.
+func NewPromotionStore(ctx context.Context, db *mongo.Database) *promotion.Store {
+	store := promotion.NewStore(db.Collection("promotions"))
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create promotion indexes")
+	}
+	return store
+}
+
+// NewWebhookDispatcher returns the webhook dispatcher storing subscriptions
+// and delivery history in db. Index creation failures are logged.
+func NewWebhookDispatcher(ctx context.Context, cfg Config, db *mongo.Database, queue *jobs.Queue, clk clock.Clock) *webhook.Dispatcher {
+	store := webhook.NewStore(db.Collection("webhook_subscriptions"), db.Collection("webhook_deliveries"))
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := store.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create webhook indexes")
+	}
+
+	dispatcher := webhook.NewDispatcher(store, cfg.WebhookTimeout)
+	dispatcher.Policy.MaxAge = cfg.WebhookMaxAge
+	dispatcher.Egress.Allow = cfg.WebhookAllowedNetworks
+	dispatcher.Clock = clk
+	if queue != nil {
+		dispatcher.Jobs = queue
+		queue.Handle(webhook.JobDispatch, dispatcher.RunDispatchJob)
+	}
+	return dispatcher
+}
+
+// NewChangeStreamWatchers returns the watchers deriving order events from
+// the shared orders collection and from every tenant's own
+func NewChangeStreamWatchers(cfg Config, client *mongo.Client, db *mongo.Database, store events.Log, publisher events.Publisher, clk clock.Clock) []*cdc.Watcher {
+	checkpoints := db.Collection(cdc.CheckpointsCollection)
+	collections := []*mongo.Collection{db.Collection("orders")}
+	for _, s := range cfg.Tenancy.Stores {
+		collections = append(collections, client.Database(s.Database).Collection(s.Collection))
+	}
+
+	var watchers []*cdc.Watcher
+	seen := map[string]bool{}
+	for _, orders := range collections {
+		name := orders.Database().Name() + "." + orders.Name()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		watcher := cdc.NewWatcher(orders, checkpoints, store, publisher)
+		watcher.Clock = clk
+		watchers = append(watchers, watcher)
+	}
+	return watchers
+}
+
+// NewArchiver returns the job moving orders older than cfg.OrderArchiveAfter
+// to the archive collection. Index creation failures are logged.
+func NewArchiver(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) *archive.Archiver {
+	archiver := archive.NewArchiver(db.Collection("orders"), db.Collection(archive.Collection), cfg.OrderArchiveAfter)
+	archiver.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := archiver.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create order archive indexes")
+	}
+	return archiver
+}
+
+// NewOrderRepository returns the repository selected by cfg.OrderStorage
+func NewOrderRepository(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) (repository.OrderRepository, error) {
+	return newOrderRepository(ctx, cfg, db, "orders", clk)
+}
+
+// newOrderRepository returns the repository selected by cfg.OrderStorage
+// keeping current orders in the named collection. Event streams and
+// snapshots of orders kept elsewhere than orders go to collections named
+// after it.
+func newOrderRepository(ctx context.Context, cfg Config, db *mongo.Database, collection string, clk clock.Clock) (repository.OrderRepository, error) {
+	orders := db.Collection(collection)
+	streams, snapshots := streamCollections(collection)
+
+	// A shard key is remembered per repository, for its own collection
+	var shard *repository.ShardKey
+	if len(cfg.Mongo.ShardKey) > 0 {
+		var err error
+		if shard, err = repository.NewShardKey(cfg.Mongo.ShardKey...); err != nil {
+			return nil, err
+		}
+	}
+
+	var replicas *mongo.Collection
+	replicaOpts, err := cfg.Mongo.replicaReads()
+	if err == nil && replicaOpts != nil {
+		replicas, err = orders.Clone(replicaOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("configure replica reads: %w", err)
+	}
+
+	switch cfg.OrderStorage {
+	case "", "document":
+		repo := repository.NewMongoRepository(orders)
+		repo.Shard = shard
+		repo.Replicas = replicas
+		return repo, nil
+	case "eventsourced":
+		repo := repository.NewEventSourcedRepository(db.Collection(streams), orders)
+		repo.Clock = clk
+		repo.Shard = shard
+		repo.Replicas = replicas
+		repo.Transactions = repository.NewTransactions(db.Client())
+
+		indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		if err := repo.EnsureIndexes(indexCtx); err != nil {
+			return nil, fmt.Errorf("create order event stream indexes: %w", err)
+		}
+
+		if cfg.SnapshotInterval > 0 {
+			repo.EnableSnapshots(db.Collection(snapshots), cfg.SnapshotInterval)
+		}
+		return repo, nil
+	default:
+		return nil, fmt.Errorf("unknown ORDER_STORAGE %q, expected document or eventsourced", cfg.OrderStorage)
+	}
+}
+
+// streamCollections returns the names of the collections holding the event
+// streams and snapshots of the orders in collection
+func streamCollections(collection string) (streams, snapshots string) {
+	if collection != "orders" {
+		return collection + "_events", collection + "_snapshots"
+	}
+	return "order_events", "order_snapshots"
+}
+
+// NewCatalog returns the product-service client, caching products for ttl
+func NewCatalog(cfg Config, ttl time.Duration, clk clock.Clock) *projection.HTTPCatalog {
+	catalog := projection.NewHTTPCatalog(cfg.ProductServiceURL, ttl)
+	catalog.Clock = clk
+	return catalog
+}
+
+// NewProjector returns the read model projector fed from store
+func NewProjector(cfg Config, store *events.Store, readModels *mongo.Database, clk clock.Clock) *projection.Projector {
+	catalog := NewCatalog(cfg, 5*time.Minute, clk)
+	projector := projection.NewProjector(store, catalog, readModels)
+	projector.Clock = clk
+	if index := NewSearchIndex(cfg); index != nil {
+		projector.Search = index
+	}
+	return projector
+}
+
+// NewAnonymizer returns the job anonymizing orders in the given collection,
+// live or archived, that are older than cfg.OrderRetention, along with their
+// stored events, event streams, snapshots and read model views
+func (a *App) NewAnonymizer(orders string) *retention.Anonymizer {
+	return a.newAnonymizer(a.DB, orders, "order_events", "order_snapshots")
+}
+
+// newAnonymizer returns the anonymizer of the orders collection in db, whose
+// event streams and snapshots are kept in the streams and snapshots
+// collections of db
+func (a *App) newAnonymizer(db *mongo.Database, orders, streams, snapshots string) *retention.Anonymizer {
+	anonymizer := retention.NewAnonymizer(
+		db.Collection(orders),
+		a.DB.Collection("anonymization_runs"),
+		a.Config.AnonymizationKey,
+		a.Config.OrderRetention,
+		retention.Copy{
+			Collection:   a.DB.Collection("events"),
+			OrderIDField: "order_id",
+			Fields:       piiPaths("order.", map[string]string{"user_id": "user_id"}),
+			Actors:       []string{"order.status_history[].actor_id", "order.notes[].author_id"},
+		},
+		retention.Copy{
+			Collection:   db.Collection(streams),
+			OrderIDField: "aggregate_id",
+			Match:        bson.M{"type": repository.DomainOrderCreated},
+			Fields:       piiPaths("data.", nil),
+		},
+		retention.Copy{
+			Collection:   db.Collection(streams),
+			OrderIDField: "aggregate_id",
+			Match:        bson.M{"type": repository.DomainStatusChanged},
+			Actors:       []string{"data.actor_id"},
+		},
+		retention.Copy{
+			Collection:   db.Collection(streams),
+			OrderIDField: "aggregate_id",
+			Match:        bson.M{"type": repository.DomainNoteAdded},
+			Actors:       []string{"data.author_id"},
+		},
+		retention.Copy{
+			Collection:   db.Collection(snapshots),
+			OrderIDField: "_id",
+			Fields:       piiPaths("state.", nil),
+			Actors:       []string{"state.status_history[].actor_id", "state.notes[].author_id"},
+		},
+		retention.Copy{
+			Collection:   a.ReadModels.Collection(projection.OrderViewsCollection),
+			OrderIDField: "order_id",
+			Fields:       map[string]string{"user_id": "user_id"},
+		},
+	)
+	anonymizer.Clock = a.Clock
+	if a.Search != nil {
+		// The index holds the order as it was projected
+		collection := db.Collection(orders)
+		anonymizer.Reindex = func(ctx context.Context, id primitive.ObjectID) error {
+			var order contracts.Order
+			if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&order); err != nil {
+				return err
+			}
+			return a.Search.Put(ctx, order)
+		}
+	}
+	return anonymizer
+}
+
+// NewPrivacy returns the service erasing and exporting the data of users
+// across the live and archived orders, and cold storage when configured,
+// and in the store of every tenant kept apart. Erasure needs
+// cfg.AnonymizationKey.
+func (a *App) NewPrivacy() *privacy.Service {
+	collections := []*mongo.Collection{a.DB.Collection("orders"), a.DB.Collection(archive.Collection)}
+	var anonymizers []*retention.Anonymizer
+	if len(a.Config.AnonymizationKey) > 0 {
+		for _, orders := range collections {
+			anonymizers = append(anonymizers, a.NewAnonymizer(orders.Name()))
+		}
+	}
+	service := privacy.NewService(collections, anonymizers...)
+	service.Cold = a.ColdStorage
+	service.ThawInto = a.DB.Collection(archive.Collection)
+	service.Clock = a.Clock
+
+	// Tenant stores are neither archived nor exported to cold storage
+	service.Tenants = make(map[string]*privacy.Service, len(a.Config.Tenancy.Stores))
+	for id, store := range a.Config.Tenancy.Stores {
+		db := a.Mongo.Database(store.Database)
+		var anonymizers []*retention.Anonymizer
+		if len(a.Config.AnonymizationKey) > 0 {
+			streams, snapshots := streamCollections(store.Collection)
+			anonymizers = append(anonymizers, a.newAnonymizer(db, store.Collection, streams, snapshots))
+		}
+		tenantService := privacy.NewService([]*mongo.Collection{db.Collection(store.Collection)}, anonymizers...)
+		tenantService.Clock = a.Clock
+		service.Tenants[id] = tenantService
+	}
+	return service
+}
+
+// piiPaths adds to fields the paths of the order's personal data in a copy
+// embedding the order under prefix
+func piiPaths(prefix string, fields map[string]string) map[string]string {
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	for _, field := range retention.DefaultPIIFields {
+		fields[prefix+field] = field
+	}
+	return fields
+}
+
+// Handler returns the HTTP handler for the API server
+func (a *App) Handler() *api.Handler {
+	opts := api.Options{
+		JWTSecret:          a.Config.JWTSecret,
+		JWTSecrets:         a.Secrets.JWTSecrets(),
+		JWKS:               a.JWKS,
+		JWTPublicKeys:      a.JWTPublicKeys,
+		JWTAlgorithms:      a.Config.JWTAlgorithms,
+		GuestSecret:        a.Config.GuestSecret,
+		CORSAllowedOrigins: a.Config.CORSAllowedOrigins,
+		RateLimitRPS:       a.Config.RateLimitRPS,
+		RateLimitBurst:     a.Config.RateLimitBurst,
+		PactVerification:   a.Config.PactVerification,
+		UserServiceURL:     a.Config.UserServiceURL,
+		Clock:              a.Clock,
+		Currency:           a.Currency,
+		ReportingCurrency:  a.Config.Currency.ReportingCurrency,
+		Webhooks:           a.Webhooks,
+		Live:               a.Live,
+		Promotions:         a.Promotions,
+		ReadOnly:           a.ReadOnly,
+		PoolExhausted:      a.poolExhausted,
+		Deadlines:          a.Config.Deadlines,
+		Tracking:           a.Config.Tracking,
+		V1Sunset:           a.Config.APIV1Sunset,
+		Readiness:          a.Readiness,
+		Audit:              a.Audit,
+		DebugLogToken:      a.Config.DebugLogToken,
+		Errors:             a.Errors,
+		RequireTenant:      a.Config.Tenancy.Required,
+	}
+	if a.Search != nil {
+		opts.Search = a.Search
+	}
+	opts.UserData = a.NewPrivacy()
+	if a.Jobs != nil && a.ObjectStore != nil {
+		opts.ExportJobs = a.Jobs
+		opts.ExportStore = a.ObjectStore
+	}
+	opts.TenantOrders = make(map[string]*mongo.Collection, len(a.Config.Tenancy.Stores))
+	for id, store := range a.Config.Tenancy.Stores {
+		opts.TenantOrders[id] = a.Mongo.Database(store.Database).Collection(store.Collection)
+	}
+	return api.NewHandler(opts, a.Service, a.DB.Collection("orders"), a.ReadModels)
+}
+
+// poolExhausted reports whether enough operations queue for a database
+// connection that requests should be refused
+func (a *App) poolExhausted() bool {
+	return dbmonitor.Exhausted(a.Config.Mongo.PoolMaxWaiting)
+}
+
+// runSingleton runs job on the elected replica, or on this one without
+// leader election. Jobs sweeping every order run this way, so replicas do
+// not duplicate each other's work.
+func (a *App) runSingleton(job func(ctx context.Context)) {
+	if a.Leader != nil {
+		a.Leader.Add(job)
+		return
+	}
+	go job(context.Background())
+}
+
+// RunServer serves the HTTP API until it fails, or until SIGINT or SIGTERM
+// shuts it down gracefully
+func (a *App) RunServer() error {
+	if a.Config.GinMode == "release" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	h := a.Handler()
+	r := h.Router()
+
+	// Run queued jobs, order exports among them, on every replica
+	if a.Jobs != nil {
+		a.Jobs.Handle(api.JobExportOrders, h.RunExportJob)
+		go a.Jobs.Run(context.Background(), a.Config.Jobs.Workers, a.Config.Jobs.PollInterval)
+	}
+
+	// Retry failed webhook deliveries for as long as the server runs
+	a.runSingleton(func(ctx context.Context) { a.Webhooks.Run(ctx, a.Config.WebhookRetryInterval) })
+	// Follow changes to the feature flag rules
+	go a.Flags.Run(context.Background(), a.Config.FeatureFlags.RefreshInterval)
+	// Push order updates to clients following them
+	go a.Live.Run(context.Background(), a.Config.LivePollInterval)
+	if a.Archiver != nil && !a.Config.Cron.Scheduled(TaskOrderArchive) {
+		a.runSingleton(func(ctx context.Context) { a.Archiver.Run(ctx, a.Config.OrderArchiveInterval) })
+	}
+	if a.ColdStorage != nil && !a.Config.Cron.Scheduled(TaskColdStorage) {
+		a.runSingleton(func(ctx context.Context) { a.ColdStorage.Run(ctx, a.Config.ColdStorage.Interval) })
+	}
+	// Pick up the data keys other replicas rotated to, and rotate when due
+	if a.Keyring != nil {
+		go a.Keyring.Run(context.Background(), keyRefreshInterval)
+	}
+	// Cancel checkouts abandoned while pending
+	if a.Config.OrderPendingTTL > 0 && !a.Config.Cron.Scheduled(TaskOrderExpiry) {
+		a.runSingleton(func(ctx context.Context) {
+			a.Service.RunExpiry(ctx, a.Config.OrderPendingTTL, a.Config.OrderExpiryInterval)
+		})
+	}
+	if a.Config.PaymentEvents.URL != "" {
+		go payment.NewConsumer(a.Config.PaymentEvents, a.Service).Run(context.Background())
+	}
+	// Finish or undo placements interrupted by a crash of any replica
+	if a.Service.Sagas != nil {
+		go a.Service.RunSagaRecovery(context.Background(), a.Config.SagaRecoveryInterval)
+	}
+	if a.Regional != nil {
+		a.runSingleton(func(ctx context.Context) { a.Regional.RunReconciler(ctx, a.Config.Region.ReconcileInterval) })
+	}
+	// Run the maintenance tasks given a cron schedule instead
+	if scheduler := a.NewScheduler(); scheduler != nil {
+		a.runSingleton(scheduler.Run)
+	}
+	// Apply configuration changes that need no restart, and pick up
+	// rotated secrets
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	// Publish order changes, from one replica per collection, giving the
+	// collection up on shutdown
+	for _, watcher := range a.ChangeStreams {
+		go watcher.Run(watchCtx, 5*time.Second)
+	}
+	// Run the jobs registered with runSingleton while leading, stopping
+	// them and giving the lease up on shutdown
+	if a.Leader != nil {
+		go a.Leader.Run(watchCtx)
+	}
+	go a.WatchConfig(watchCtx, h)
+	a.Secrets.Run(watchCtx, a.Config.Secrets.RefreshInterval)
+	if a.JWKS != nil {
+		go a.JWKS.Run(watchCtx, a.Config.JWKSRefreshInterval)
+	}
+
+	tlsConfig, err := a.Config.TLS.ServerTLS()
+	if err != nil {
+		return fmt.Errorf("server TLS: %w", err)
+	}
+
+	// The gRPC API for internal callers listens alongside the HTTP API
+	var grpcServer *grpc.Server
+	if a.Config.GRPCPort != "" {
+		lis, err := net.Listen("tcp", ":"+a.Config.GRPCPort)
+		if err != nil {
+			return err
+		}
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		opts = append(opts, grpcapi.WithDeadlines(func() (time.Duration, time.Duration) {
+			d := h.Deadlines()
+			return d.Read, d.Write
+		}))
+		grpcServer = grpcapi.NewGRPCServer(a.Config.InternalAPIToken, a.Service, a.Errors, opts...)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Error().Err(err).Msg("gRPC server stopped")
+			}
+		}()
+		log.Info().Str("port", a.Config.GRPCPort).Msg("gRPC server starting")
+	}
+
+	// Profiling and runtime diagnostics listen apart from the APIs
+	if a.Config.DebugPort != "" {
+		debugSrv := newDebugServer(a.Config.DebugHost, a.Config.DebugPort)
+		defer debugSrv.Close()
+		go func() {
+			if err := debugSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error().Err(err).Msg("Debug server stopped")
+			}
+		}()
+		log.Info().Str("addr", debugSrv.Addr).Msg("Debug server starting")
+	}
+
+	srv := &http.Server{Addr: ":" + a.Config.Port, Handler: r, TLSConfig: tlsConfig}
+	failed := make(chan error, 1)
+	go func() {
+		if tlsConfig != nil {
+			// The certificate comes from TLSConfig, reloaded as it rotates
+			failed <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		failed <- srv.ListenAndServe()
+	}()
+	log.Info().Str("port", a.Config.Port).Str("region", a.Config.Region.Region).Bool("tls", tlsConfig != nil).
+		Bool("client_certs", tlsConfig != nil && tlsConfig.ClientCAs != nil).Msg("Order service starting")
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	select {
+	case err := <-failed:
+		return err
+	case sig := <-stop:
+		log.Info().Str("signal", sig.String()).Dur("drain_delay", a.Config.ShutdownDrainDelay).Msg("Draining before shutdown")
+	}
+	return a.shutdown(srv, grpcServer)
+}
+
+// shutdown reports unready and keeps serving for the drain delay, so load
+// balancers stop routing here while requests still succeed, then stops the
+// servers, giving in-flight requests up to the shutdown timeout
+func (a *App) shutdown(srv *http.Server, grpcServer *grpc.Server) error {
+	a.Readiness.Drain()
+	time.Sleep(a.Config.ShutdownDrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), a.Config.ShutdownTimeout)
+	defer cancel()
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				grpcServer.Stop()
+			}
+		}()
+	}
+
+	err := srv.Shutdown(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// Long-lived streams such as SSE are cut off
+		log.Warn().Dur("timeout", a.Config.ShutdownTimeout).Msg("Requests still running at shutdown timeout, closing them")
+		return srv.Close()
+	}
+	if err == nil {
+		log.Info().Msg("Order service stopped")
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package app
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"order-service/pkg/audit"
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Audit sinks
+const (
+	AuditSinkNone       = "none"
+	AuditSinkFile       = "file"
+	AuditSinkKafka      = "kafka"
+	AuditSinkCollection = "mongodb"
+)
+
+// AuditOptions select where audit records of status updates, cancellations
+// and admin operations are written
+type AuditOptions struct {
+	// Sink is AuditSinkFile, AuditSinkKafka, AuditSinkCollection or
+	// AuditSinkNone
+	Sink string
+	// File is appended to by the file sink
+	File string
+	// KafkaTopic is written to by the kafka sink, on the KAFKA_BROKERS
+	KafkaTopic string
+	// Collection is inserted into by the mongodb sink
+	Collection string
+}
+
+// loadAuditOptions reads the AUDIT_* settings
+func (l *configLoader) loadAuditOptions() AuditOptions {
+	return AuditOptions{
+		Sink:       l.envOr("AUDIT_SINK", AuditSinkNone),
+		File:       l.env("AUDIT_FILE"),
+		KafkaTopic: l.envOr("AUDIT_KAFKA_TOPIC", "order-audit"),
+		Collection: l.envOr("AUDIT_COLLECTION", "audit_log"),
+	}
+}
+
+// validateAudit checks the chosen sink has what it writes to
+func (l *configLoader) validateAudit(opts AuditOptions, bus BusOptions) {
+	switch opts.Sink {
+	case AuditSinkNone, AuditSinkCollection:
+	case AuditSinkFile:
+		if opts.File == "" {
+			l.fail("AUDIT_FILE", "", "a file path when AUDIT_SINK=file")
+		} else if info, err := os.Stat(filepath.Dir(opts.File)); err != nil || !info.IsDir() {
+			l.fail("AUDIT_FILE", opts.File, "a path in an existing directory")
+		}
+	case AuditSinkKafka:
+		if len(bus.Kafka.Brokers) == 0 {
+			l.fail("KAFKA_BROKERS", "", "a comma-separated list of host:port addresses when AUDIT_SINK=kafka")
+		}
+		if opts.KafkaTopic == "" {
+			l.fail("AUDIT_KAFKA_TOPIC", "", "a topic name")
+		}
+	default:
+		l.fail("AUDIT_SINK", opts.Sink, "file, kafka, mongodb or none")
+	}
+}
+
+// NewAuditLog returns the audit log cfg selects, or nil without one. Index
+// creation failures on the collection are logged.
+func NewAuditLog(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) (*audit.Log, error) {
+	var sink audit.Sink
+	switch cfg.Audit.Sink {
+	case AuditSinkFile:
+		file, err := audit.NewFileSink(cfg.Audit.File)
+		if err != nil {
+			return nil, err
+		}
+		sink = file
+	case AuditSinkKafka:
+		sink = audit.NewKafkaSink(cfg.Bus.Kafka.Brokers, cfg.Audit.KafkaTopic)
+	case AuditSinkCollection:
+		collection := db.Collection(cfg.Audit.Collection)
+		indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		_, err := collection.Indexes().CreateOne(indexCtx, mongo.IndexModel{
+			Keys: bson.D{{Key: "resource.type", Value: 1}, {Key: "resource.id", Value: 1}, {Key: "at", Value: 1}},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create audit log indexes")
+		}
+		sink = audit.NewCollectionSink(collection)
+	default:
+		return nil, nil
+	}
+	log.Info().Str("sink", cfg.Audit.Sink).Msg("Audit log enabled")
+	return audit.New(sink, clk), nil
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/jwks"
+
+	"github.com/rs/zerolog/log"
+)
+
+// jwtAlgorithms are the signing algorithms JWT_ALGORITHMS may accept, and
+// whether they are asymmetric
+var jwtAlgorithms = map[string]bool{
+	"HS256": false, "HS384": false, "HS512": false,
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+}
+
+// acceptsHMAC reports whether tokens signed with the JWT secret are
+// accepted
+func acceptsHMAC(algorithms []string) bool {
+	for _, alg := range algorithms {
+		if asymmetric, ok := jwtAlgorithms[alg]; ok && !asymmetric {
+			return true
+		}
+	}
+	return false
+}
+
+// validateJWT checks the accepted algorithms have keys to verify them, and
+// that the public keys configured are used
+func (l *configLoader) validateJWT(cfg Config) {
+	asymmetric := false
+	for _, alg := range cfg.JWTAlgorithms {
+		isAsymmetric, ok := jwtAlgorithms[alg]
+		if !ok {
+			l.fail("JWT_ALGORITHMS", alg, "a comma-separated list of HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384 or ES512")
+		}
+		asymmetric = asymmetric || isAsymmetric
+	}
+	if len(cfg.JWTAlgorithms) == 0 {
+		l.fail("JWT_ALGORITHMS", "", "at least one signing algorithm")
+	}
+
+	for _, file := range cfg.JWTPublicKeyFiles {
+		if _, err := loadPublicKeys(file); err != nil {
+			l.fail("JWT_PUBLIC_KEY_FILES", file, "PEM files of RSA (2048 bits or more) or ECDSA public keys: "+err.Error())
+		}
+	}
+	hasKeys := cfg.JWKSURL != "" || len(cfg.JWTPublicKeyFiles) > 0
+	switch {
+	case asymmetric && !hasKeys:
+		l.fail("JWT_ALGORITHMS", strings.Join(cfg.JWTAlgorithms, ","), "HMAC algorithms only without JWKS_URL or JWT_PUBLIC_KEY_FILES")
+	case hasKeys && !asymmetric:
+		l.fail("JWT_ALGORITHMS", strings.Join(cfg.JWTAlgorithms, ","), "an asymmetric algorithm such as RS256 with JWKS_URL or JWT_PUBLIC_KEY_FILES")
+	}
+}
+
+// LoadPublicKeys reads the public keys of the PEM files
+func LoadPublicKeys(files []string) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for _, file := range files {
+		found, err := loadPublicKeys(file)
+		if err != nil {
+			return nil, fmt.Errorf("load public keys from %s: %w", file, err)
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
+
+func loadPublicKeys(file string) ([]crypto.PublicKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return jwks.ParsePEM(data)
+}
+
+// NewJWKS returns the key set published at url, fetched once up front; on
+// failure the instance stays unready until Run fetches it
+func NewJWKS(ctx context.Context, url string, clk clock.Clock) *jwks.Set {
+	set := jwks.New(url, clk)
+	fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := set.Refresh(fetchCtx); err != nil {
+		log.Error().Err(err).Str("url", url).Msg("Failed to fetch JWKS")
+	}
+	return set
+}
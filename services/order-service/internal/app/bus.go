@@ -0,0 +1,116 @@
+package app
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/events"
+)
+
+// BusOptions select the message bus order events are published to for
+// other services
+type BusOptions struct {
+	// Type is events.BusKafka, events.BusNATS or events.BusNone. It
+	// defaults to Kafka when brokers are configured and none otherwise.
+	Type  string
+	Kafka events.KafkaConfig
+	NATS  events.NATSConfig
+}
+
+// loadBusOptions reads MESSAGE_BUS and the KAFKA_* and NATS_* settings
+func (l *configLoader) loadBusOptions() BusOptions {
+	opts := BusOptions{
+		Type: l.env("MESSAGE_BUS"),
+		Kafka: events.KafkaConfig{
+			TopicPrefix:  l.env("KAFKA_TOPIC_PREFIX"),
+			MaxAttempts:  l.intVar("KAFKA_MAX_ATTEMPTS", 5),
+			BackoffMin:   l.durationVar("KAFKA_RETRY_BACKOFF_MIN", 100*time.Millisecond),
+			BackoffMax:   l.durationVar("KAFKA_RETRY_BACKOFF_MAX", time.Second),
+			WriteTimeout: l.durationVar("KAFKA_WRITE_TIMEOUT", 2*time.Second),
+		},
+		NATS: events.NATSConfig{
+			URL:             l.env("NATS_URL"),
+			Stream:          l.envOr("NATS_STREAM", "ORDER_EVENTS"),
+			SubjectPrefix:   l.env("NATS_SUBJECT_PREFIX"),
+			DuplicateWindow: l.durationVar("NATS_DUPLICATE_WINDOW", 2*time.Minute),
+		},
+	}
+	for _, broker := range strings.Split(l.env("KAFKA_BROKERS"), ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			opts.Kafka.Brokers = append(opts.Kafka.Brokers, broker)
+		}
+	}
+	if opts.Type == "" {
+		opts.Type = events.BusNone
+		if len(opts.Kafka.Brokers) > 0 {
+			opts.Type = events.BusKafka
+		}
+	}
+	return opts
+}
+
+// validateBus checks the settings of the selected bus
+func (l *configLoader) validateBus(opts BusOptions) {
+	switch opts.Type {
+	case events.BusNone:
+	case events.BusKafka:
+		l.validateKafka(opts.Kafka)
+	case events.BusNATS:
+		l.validateNATS(opts.NATS)
+	default:
+		l.fail("MESSAGE_BUS", opts.Type, "kafka, nats or none")
+	}
+}
+
+func (l *configLoader) validateKafka(cfg events.KafkaConfig) {
+	if len(cfg.Brokers) == 0 {
+		l.fail("KAFKA_BROKERS", "", "a comma-separated list of host:port addresses when MESSAGE_BUS=kafka")
+	}
+	for _, broker := range cfg.Brokers {
+		if i := strings.LastIndex(broker, ":"); i <= 0 || strings.Contains(broker, "/") {
+			l.fail("KAFKA_BROKERS", broker, "a comma-separated list of host:port addresses")
+		} else if port, err := strconv.Atoi(broker[i+1:]); err != nil || port < 1 || port > 65535 {
+			l.fail("KAFKA_BROKERS", broker, "a comma-separated list of host:port addresses")
+		}
+	}
+	if cfg.MaxAttempts < 1 || cfg.MaxAttempts > 20 {
+		l.fail("KAFKA_MAX_ATTEMPTS", strconv.Itoa(cfg.MaxAttempts), "between 1 and 20")
+	}
+	if cfg.BackoffMin <= 0 || cfg.BackoffMax < cfg.BackoffMin || cfg.BackoffMax > time.Minute {
+		l.fail("KAFKA_RETRY_BACKOFF_MAX", cfg.BackoffMax.String(), "a duration up to 1m, at least KAFKA_RETRY_BACKOFF_MIN (which must be positive)")
+	}
+	if cfg.WriteTimeout < 100*time.Millisecond || cfg.WriteTimeout > time.Minute {
+		l.fail("KAFKA_WRITE_TIMEOUT", cfg.WriteTimeout.String(), "a duration between 100ms and 1m")
+	}
+}
+
+func (l *configLoader) validateNATS(cfg events.NATSConfig) {
+	for _, server := range strings.Split(cfg.URL, ",") {
+		u, err := url.Parse(strings.TrimSpace(server))
+		if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			l.fail("NATS_URL", redactURI(server), "a comma-separated list of nats:// or tls:// URLs when MESSAGE_BUS=nats")
+		}
+	}
+	if cfg.Stream == "" || strings.ContainsAny(cfg.Stream, ". *>") {
+		l.fail("NATS_STREAM", cfg.Stream, "a stream name without spaces, dots or wildcards")
+	}
+	if p := cfg.SubjectPrefix; p != "" && (!strings.HasSuffix(p, ".") || strings.ContainsAny(p, " *>")) {
+		l.fail("NATS_SUBJECT_PREFIX", p, "subject tokens ending with a dot, such as prod.")
+	}
+	if cfg.DuplicateWindow < time.Second || cfg.DuplicateWindow > 24*time.Hour {
+		l.fail("NATS_DUPLICATE_WINDOW", cfg.DuplicateWindow.String(), "a duration between 1s and 24h")
+	}
+}
+
+// NewMessageBus connects to the selected bus; it returns nil for none
+func NewMessageBus(opts BusOptions) (events.MessageBus, error) {
+	switch opts.Type {
+	case events.BusKafka:
+		return events.NewKafkaPublisher(opts.Kafka), nil
+	case events.BusNATS:
+		return events.NewNATSPublisher(opts.NATS)
+	}
+	return nil, nil
+}
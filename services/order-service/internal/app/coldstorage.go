@@ -0,0 +1,71 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/archive"
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ColdStorageOptions configure the export of old orders out of MongoDB
+// into the object store
+type ColdStorageOptions struct {
+	// Months is how old finished and deleted orders are when exported; 0
+	// keeps them in MongoDB
+	Months   int
+	Interval time.Duration
+	// Prefix is prepended to the key of every exported batch
+	Prefix string
+}
+
+// loadColdStorageOptions reads ORDER_COLD_STORAGE_MONTHS,
+// ORDER_COLD_STORAGE_INTERVAL and ORDER_COLD_STORAGE_PREFIX
+func (l *configLoader) loadColdStorageOptions() ColdStorageOptions {
+	return ColdStorageOptions{
+		Months:   l.intVar("ORDER_COLD_STORAGE_MONTHS", 0),
+		Interval: l.durationVar("ORDER_COLD_STORAGE_INTERVAL", 6*time.Hour),
+		Prefix:   l.env("ORDER_COLD_STORAGE_PREFIX"),
+	}
+}
+
+// validateColdStorage checks the export has a bucket to go to
+func (l *configLoader) validateColdStorage(opts ColdStorageOptions, store ObjectStoreOptions) {
+	if opts.Months < 0 {
+		l.fail("ORDER_COLD_STORAGE_MONTHS", strconv.Itoa(opts.Months), "0 (never export) or a number of months")
+	}
+	if opts.Months == 0 {
+		return
+	}
+	if store.Bucket == "" {
+		l.fail("OBJECT_STORE_BUCKET", "", "a bucket with ORDER_COLD_STORAGE_MONTHS set")
+	}
+	if opts.Interval < time.Minute || opts.Interval > 24*time.Hour {
+		l.fail("ORDER_COLD_STORAGE_INTERVAL", opts.Interval.String(), "a duration between 1m and 24h")
+	}
+	if strings.HasPrefix(opts.Prefix, "/") {
+		l.fail("ORDER_COLD_STORAGE_PREFIX", opts.Prefix, "a key prefix without a leading /")
+	}
+}
+
+// NewColdStorage returns the cold storage of orders exported from the
+// orders and archive collections into store. Index creation failures are
+// logged.
+func NewColdStorage(ctx context.Context, cfg Config, db *mongo.Database, store archive.ObjectStore, clk clock.Clock) *archive.ColdStorage {
+	cold := archive.NewColdStorage(db.Collection(archive.ColdCollection), store, cfg.ColdStorage.Months,
+		db.Collection("orders"), db.Collection(archive.Collection))
+	cold.Prefix = cfg.ColdStorage.Prefix
+	cold.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := cold.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create cold storage indexes")
+	}
+	return cold
+}
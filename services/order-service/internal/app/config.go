@@ -0,0 +1,751 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/internal/api"
+	"order-service/internal/service"
+	"order-service/pkg/contracts"
+	"order-service/pkg/payment"
+
+	"github.com/rs/zerolog"
+)
+
+// fallbackJWTSecret is only acceptable outside release mode
+const fallbackJWTSecret = "fallback-secret"
+
+// Config holds every setting the order service binaries read from the
+// environment and the optional CONFIG_FILE. Only LoadConfig reads them;
+// components receive the values they need through their constructors.
+type Config struct {
+	Port    string
+	GinMode string
+	// LogLevel is the minimum level logged, e.g. "debug" or "warn"
+	LogLevel string
+	// ConfigFile is the YAML file settings are read from under the
+	// environment; empty reads the environment only
+	ConfigFile string
+	// ConfigReloadInterval is how often ConfigFile is checked for changes
+	// to reload; 0 only reloads on SIGHUP
+	ConfigReloadInterval time.Duration
+	// GRPCPort serves the gRPC API for internal callers; empty disables it.
+	// It needs InternalAPIToken, which callers authenticate with.
+	GRPCPort string
+	// DebugPort serves pprof profiles, expvar and goroutine dumps on
+	// DebugHost, loopback by default so only port-forwards reach it; empty
+	// disables it
+	DebugPort string
+	DebugHost string
+	// ShutdownDrainDelay is how long the server keeps serving, reporting
+	// unready, after SIGTERM so load balancers stop routing to it first;
+	// in-flight requests then get up to ShutdownTimeout to finish
+	ShutdownDrainDelay time.Duration
+	ShutdownTimeout    time.Duration
+
+	MongoURI          string
+	Mongo             MongoOptions
+	Database          string
+	ReadModelURI      string
+	ReadModelDatabase string
+
+	// OrderStorage selects the repository: "document" or "eventsourced"
+	OrderStorage string
+	// SnapshotInterval is the event count between snapshots; 0 disables them
+	SnapshotInterval int
+	// EventSource is where order events come from: "service", recorded by
+	// the service with each change it writes, or "changestream", derived
+	// from the change streams of the orders collections
+	EventSource string
+	// OrderLimits bounds line items per order and quantity per item
+	OrderLimits contracts.Limits
+	Currency    CurrencyOptions
+	Pricing     PricingOptions
+	Region      RegionOptions
+	// Bus is where order events are published for other services
+	Bus BusOptions
+	// Audit is where status updates, cancellations and admin operations
+	// are recorded
+	Audit AuditOptions
+	// ErrorTracking is where panics and server errors are reported
+	ErrorTracking ErrorTrackingOptions
+	// FeatureFlags decide the features rolled out gradually
+	FeatureFlags FeatureFlagOptions
+	// Tenancy isolates the orders of each tenant
+	Tenancy TenancyOptions
+	// Search is the Elasticsearch index admin listings are served from
+	Search SearchOptions
+	// ObjectStore is the bucket cold storage exports orders to
+	ObjectStore ObjectStoreOptions
+	// ColdStorage moves orders out of MongoDB once they are old enough
+	ColdStorage ColdStorageOptions
+	// Encryption encrypts the personal data on orders as it is stored
+	Encryption EncryptionOptions
+	// Redaction masks personal data and credentials in logs
+	Redaction RedactionOptions
+	// Leader elects the replica running the background jobs
+	Leader LeaderOptions
+	// Jobs is the queue of background work run by a pool of workers
+	Jobs JobOptions
+	// Cron runs maintenance tasks on cron schedules
+	Cron CronOptions
+
+	JWTSecret          []byte
+	CORSAllowedOrigins []string
+	RateLimitRPS       float64
+	RateLimitBurst     int
+	PactVerification   bool
+	// GuestSecret signs guest order lookup tokens; guest checkout is
+	// disabled without it
+	GuestSecret []byte
+	// Secrets fetches the JWT secret and the MongoDB URI from a secret
+	// manager, see LoadSecrets
+	Secrets SecretOptions
+	// JWKSURL is where the auth service publishes the public keys of
+	// asymmetrically signed tokens, fetched again every
+	// JWKSRefreshInterval; empty only accepts HMAC-signed tokens
+	JWKSURL             string
+	JWKSRefreshInterval time.Duration
+	// JWTPublicKeyFiles are PEM files of public keys verifying
+	// asymmetrically signed tokens, alongside the JWKS
+	JWTPublicKeyFiles []string
+	// JWTAlgorithms are the algorithms tokens may be signed with; tokens
+	// signed with any other are rejected
+	JWTAlgorithms []string
+	// TLS serves the APIs over TLS, optionally requiring client
+	// certificates, and configures TLS for calls to other services
+	TLS TLSOptions
+	// Deadlines bound each endpoint's request context
+	Deadlines api.Deadlines
+	// DetachedTimeout bounds the work finishing a request once detached
+	// from its context, such as publishing its events
+	DetachedTimeout time.Duration
+	// Tracking limits the WebSocket order-tracking channel per instance
+	Tracking api.TrackingLimits
+	// ReadOnly starts the service refusing writes; admins can change it at
+	// runtime
+	ReadOnly bool
+	// APIV1Sunset is when version 1 of the REST API goes away, announced to
+	// its clients; zero until a date is set
+	APIV1Sunset time.Time
+
+	UserServiceURL    string
+	ProductServiceURL string
+	// InternalAPIToken authenticates calls to other services' internal
+	// endpoints; notifications are disabled without it
+	InternalAPIToken string
+	// DebugLogToken, sent in X-Debug-Log, logs a request at debug level;
+	// empty ignores the header
+	DebugLogToken string
+	// CatalogCacheTTL is how long product prices are reused when pricing
+	// new orders
+	CatalogCacheTTL time.Duration
+	// LegacyClientPrices keeps client-supplied unit prices during the
+	// migration to catalog pricing
+	LegacyClientPrices bool
+	// InventoryReservations reserves stock in product-service for every
+	// order placed; it needs InternalAPIToken
+	InventoryReservations bool
+	// PaymentServiceURL is where order payments are authorized before
+	// orders are confirmed; empty leaves new orders pending
+	PaymentServiceURL string
+	// AddressValidationURL is the address-validation provider verifying
+	// the shipping address of new orders; empty only checks their format
+	AddressValidationURL    string
+	AddressValidationAPIKey string
+	// SagaRecoveryInterval is how often placements interrupted by a crash
+	// are looked for; placement runs as a saga whenever it reserves stock
+	// or authorizes payments
+	SagaRecoveryInterval time.Duration
+	// PaymentEvents is the RabbitMQ queue of payment confirmations that
+	// confirm pending orders; disabled without a URL
+	PaymentEvents          payment.ConsumerConfig
+	ProjectionPollInterval time.Duration
+	// LivePollInterval is how often the event store is read for order
+	// updates streamed to clients
+	LivePollInterval time.Duration
+	// IdempotencyKeyTTL is how long an Idempotency-Key keeps returning the
+	// order it created
+	IdempotencyKeyTTL time.Duration
+
+	// WebhookTimeout bounds one delivery attempt; failed deliveries are
+	// retried every WebhookRetryInterval until they are WebhookMaxAge old
+	WebhookTimeout       time.Duration
+	WebhookRetryInterval time.Duration
+	WebhookMaxAge        time.Duration
+	// WebhookAllowedNetworks may receive webhooks although they are not
+	// public, such as subscribers inside the cluster
+	WebhookAllowedNetworks []*net.IPNet
+
+	// OrderArchiveAfter is how long finished and deleted orders stay in the
+	// live collection before being moved to the archive, checked every
+	// OrderArchiveInterval; 0 disables archiving
+	OrderArchiveAfter    time.Duration
+	OrderArchiveInterval time.Duration
+	// OrderPendingTTL is how long an order may stay pending before it is
+	// cancelled as abandoned, checked every OrderExpiryInterval; 0 disables
+	// expiry
+	OrderPendingTTL     time.Duration
+	OrderExpiryInterval time.Duration
+
+	// OrderRetention is how long orders keep personal data before
+	// orderctl anonymize replaces it with tokens keyed by AnonymizationKey;
+	// 0 disables anonymization. Erasing a user's data also needs the key.
+	OrderRetention   time.Duration
+	AnonymizationKey []byte
+}
+
+// Violation describes one invalid configuration variable
+type Violation struct {
+	Var      string
+	Value    string
+	Expected string
+}
+
+// ConfigError lists every configuration violation found at startup
+type ConfigError struct {
+	Violations []Violation
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d configuration error(s):", len(e.Violations))
+	for _, v := range e.Violations {
+		fmt.Fprintf(&b, "\n  - %s=%q: expected %s", v.Var, v.Value, v.Expected)
+	}
+	return b.String()
+}
+
+// configLoader reads variables and accumulates violations instead of
+// stopping at the first one
+type configLoader struct {
+	violations []Violation
+	// file holds the settings of the config file, which the environment
+	// overrides
+	file map[string]string
+	// read records every variable looked up, to report file settings
+	// nothing reads
+	read map[string]bool
+}
+
+// env returns the variable from the environment, or else from the config
+// file
+func (l *configLoader) env(key string) string {
+	if l.read == nil {
+		l.read = map[string]bool{}
+	}
+	l.read[key] = true
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return l.file[key]
+}
+
+// envOr returns the variable, or fallback when it is unset
+func (l *configLoader) envOr(key, fallback string) string {
+	if v := l.env(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func (l *configLoader) fail(key, value, expected string) {
+	l.violations = append(l.violations, Violation{Var: key, Value: value, Expected: expected})
+}
+
+func (l *configLoader) intVar(key string, fallback int) int {
+	v := l.env(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.fail(key, v, "an integer")
+		return fallback
+	}
+	return n
+}
+
+func (l *configLoader) optionalBoolVar(key string) *bool {
+	v := l.env(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(key, v, "true or false")
+		return nil
+	}
+	return &b
+}
+
+func (l *configLoader) floatVar(key string, fallback float64) float64 {
+	v := l.env(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		l.fail(key, v, "a number")
+		return fallback
+	}
+	return f
+}
+
+func (l *configLoader) boolVar(key string) bool {
+	v := l.env(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.fail(key, v, "true or false")
+	}
+	return b
+}
+
+// networksVar reads a comma-separated list of CIDR networks
+func (l *configLoader) networksVar(key string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(l.env(key), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			l.fail(key, cidr, "a comma-separated list of CIDR networks such as 10.0.0.0/8")
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func (l *configLoader) durationVar(key string, fallback time.Duration) time.Duration {
+	v := l.env(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.fail(key, v, "a Go duration such as 500ms or 2s")
+		return fallback
+	}
+	return d
+}
+
+func (l *configLoader) timeVar(key string) time.Time {
+	v := l.env(key)
+	if v == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		l.fail(key, v, "an RFC 3339 time such as 2027-01-01T00:00:00Z")
+		return time.Time{}
+	}
+	return t
+}
+
+// LoadConfig reads the configuration from the environment, layered over
+// the YAML file named by CONFIG_FILE and then over defaults, and validates
+// it. The returned *ConfigError lists every problem at once so they can
+// all be fixed in one deploy.
+func LoadConfig() (Config, error) {
+	l := &configLoader{}
+	path := os.Getenv("CONFIG_FILE")
+	if path != "" {
+		l.loadFile(path)
+	}
+
+	cfg := Config{
+		Port:              l.envOr("PORT", "3003"),
+		GRPCPort:          l.env("GRPC_PORT"),
+		DebugPort:         l.env("DEBUG_PORT"),
+		DebugHost:         l.envOr("DEBUG_HOST", "127.0.0.1"),
+		GinMode:           l.env("GIN_MODE"),
+		MongoURI:          l.env("MONGODB_URI"),
+		Mongo:             l.loadMongoOptions(),
+		Database:          "orders",
+		ReadModelDatabase: l.envOr("READ_MODEL_DATABASE", "orders_read"),
+		OrderStorage:      l.envOr("ORDER_STORAGE", "document"),
+		SnapshotInterval:  l.intVar("ORDER_SNAPSHOT_INTERVAL", 100),
+		EventSource:       l.envOr("ORDER_EVENT_SOURCE", "service"),
+		OrderLimits: contracts.Limits{
+			MaxItems:      l.intVar("ORDER_MAX_ITEMS", contracts.DefaultLimits.MaxItems),
+			MaxQuantity:   l.intVar("ORDER_MAX_ITEM_QUANTITY", contracts.DefaultLimits.MaxQuantity),
+			MaxBulkOrders: l.intVar("ORDER_BULK_MAX_ORDERS", contracts.DefaultLimits.MaxBulkOrders),
+		},
+		Currency:         l.loadCurrencyOptions(),
+		Pricing:          l.loadPricingOptions(),
+		Region:           l.loadRegionOptions(),
+		Bus:              l.loadBusOptions(),
+		Audit:            l.loadAuditOptions(),
+		ErrorTracking:    l.loadErrorTrackingOptions(),
+		FeatureFlags:     l.loadFeatureFlagOptions(),
+		Tenancy:          l.loadTenancyOptions(),
+		Search:           l.loadSearchOptions(),
+		ObjectStore:      l.loadObjectStoreOptions(),
+		ColdStorage:      l.loadColdStorageOptions(),
+		Encryption:       l.loadEncryptionOptions(),
+		Redaction:        l.loadRedactionOptions(),
+		Leader:           l.loadLeaderOptions(),
+		Jobs:             l.loadJobOptions(),
+		Cron:             l.loadCronOptions(),
+		JWTSecret:        []byte(l.envOr("JWT_SECRET", fallbackJWTSecret)),
+		GuestSecret:      optionalBytes(l.env("GUEST_CHECKOUT_SECRET")),
+		RateLimitRPS:     l.floatVar("RATE_LIMIT_RPS", 0),
+		RateLimitBurst:   l.intVar("RATE_LIMIT_BURST", 0),
+		PactVerification: l.boolVar("PACT_VERIFICATION"),
+		ReadOnly:         l.boolVar("READ_ONLY"),
+		Deadlines:        l.loadDeadlines(),
+		DetachedTimeout:  l.durationVar("REQUEST_TIMEOUT_DETACHED", service.DefaultDetachedTimeout),
+		APIV1Sunset:      l.timeVar("API_V1_SUNSET"),
+		Tracking: api.TrackingLimits{
+			MaxConnections:        l.intVar("TRACKING_MAX_CONNECTIONS", api.DefaultTrackingLimits.MaxConnections),
+			MaxConnectionsPerUser: l.intVar("TRACKING_MAX_CONNECTIONS_PER_USER", api.DefaultTrackingLimits.MaxConnectionsPerUser),
+			MaxSubscriptions:      l.intVar("TRACKING_MAX_SUBSCRIPTIONS", api.DefaultTrackingLimits.MaxSubscriptions),
+		},
+		UserServiceURL:          l.envOr("USER_SERVICE_URL", "http://localhost:3001"),
+		ProductServiceURL:       l.envOr("PRODUCT_SERVICE_URL", "http://localhost:3002"),
+		InternalAPIToken:        l.env("INTERNAL_API_TOKEN"),
+		DebugLogToken:           l.env("DEBUG_LOG_TOKEN"),
+		CatalogCacheTTL:         l.durationVar("CATALOG_CACHE_TTL", time.Minute),
+		LegacyClientPrices:      l.boolVar("LEGACY_CLIENT_PRICES"),
+		InventoryReservations:   l.boolVar("INVENTORY_RESERVATIONS"),
+		PaymentServiceURL:       l.env("PAYMENT_SERVICE_URL"),
+		AddressValidationURL:    l.env("ADDRESS_VALIDATION_URL"),
+		AddressValidationAPIKey: l.env("ADDRESS_VALIDATION_API_KEY"),
+		SagaRecoveryInterval:    l.durationVar("SAGA_RECOVERY_INTERVAL", 30*time.Second),
+		PaymentEvents: payment.ConsumerConfig{
+			URL:        l.env("RABBITMQ_URL"),
+			Queue:      l.envOr("PAYMENTS_CONFIRMED_QUEUE", payment.EventConfirmed),
+			Prefetch:   l.intVar("PAYMENTS_CONFIRMED_PREFETCH", 10),
+			RetryDelay: l.durationVar("PAYMENTS_CONFIRMED_RETRY_DELAY", 5*time.Second),
+		},
+		ProjectionPollInterval: l.durationVar("PROJECTION_POLL_INTERVAL", time.Second),
+		LivePollInterval:       l.durationVar("LIVE_POLL_INTERVAL", 500*time.Millisecond),
+		IdempotencyKeyTTL:      l.durationVar("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		WebhookTimeout:         l.durationVar("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookRetryInterval:   l.durationVar("WEBHOOK_RETRY_INTERVAL", 15*time.Second),
+		WebhookMaxAge:          l.durationVar("WEBHOOK_MAX_AGE", 24*time.Hour),
+		WebhookAllowedNetworks: l.networksVar("WEBHOOK_ALLOWED_NETWORKS"),
+		OrderArchiveAfter:      l.durationVar("ORDER_ARCHIVE_AFTER", 0),
+		OrderArchiveInterval:   l.durationVar("ORDER_ARCHIVE_INTERVAL", time.Hour),
+		OrderPendingTTL:        l.durationVar("ORDER_PENDING_TTL", 0),
+		OrderExpiryInterval:    l.durationVar("ORDER_EXPIRY_INTERVAL", time.Minute),
+		OrderRetention:         l.durationVar("ORDER_RETENTION", 0),
+		AnonymizationKey:       []byte(l.env("ANONYMIZATION_KEY")),
+		ShutdownDrainDelay:     l.durationVar("SHUTDOWN_DRAIN_DELAY", 5*time.Second),
+		ShutdownTimeout:        l.durationVar("SHUTDOWN_TIMEOUT", 15*time.Second),
+		LogLevel:               strings.ToLower(l.envOr("LOG_LEVEL", "info")),
+		ConfigFile:             path,
+		ConfigReloadInterval:   l.durationVar("CONFIG_RELOAD_INTERVAL", 30*time.Second),
+		Secrets:                l.loadSecretOptions(),
+		JWKSURL:                l.env("JWKS_URL"),
+		JWKSRefreshInterval:    l.durationVar("JWKS_REFRESH_INTERVAL", 15*time.Minute),
+		TLS:                    l.loadTLSOptions(),
+	}
+	uriSet := cfg.MongoURI != ""
+	switch {
+	case !uriSet && cfg.Mongo.Host != "":
+		cfg.MongoURI = cfg.Mongo.uri()
+	case !uriSet:
+		cfg.MongoURI = "mongodb://localhost:27017"
+	}
+	cfg.ReadModelURI = l.envOr("READ_MODEL_MONGODB_URI", cfg.MongoURI)
+
+	if origins := l.env("CORS_ALLOWED_ORIGINS"); origins != "" {
+		cfg.CORSAllowedOrigins = strings.Split(origins, ",")
+	}
+	if files := l.env("JWT_PUBLIC_KEY_FILES"); files != "" {
+		cfg.JWTPublicKeyFiles = strings.Split(files, ",")
+	}
+	// Asymmetric algorithms are accepted once there are keys for them
+	algorithms := "HS256"
+	if cfg.JWKSURL != "" || len(cfg.JWTPublicKeyFiles) > 0 {
+		algorithms = "HS256,RS256,ES256"
+	}
+	for _, alg := range strings.Split(l.envOr("JWT_ALGORITHMS", algorithms), ",") {
+		if alg = strings.ToUpper(strings.TrimSpace(alg)); alg != "" {
+			cfg.JWTAlgorithms = append(cfg.JWTAlgorithms, alg)
+		}
+	}
+
+	l.validate(cfg)
+	l.validateMongo(uriSet, cfg.Mongo, cfg.Region.Region)
+	l.validateCurrency(cfg.Currency)
+	l.validateBus(cfg.Bus)
+	l.validateAudit(cfg.Audit, cfg.Bus)
+	l.validateErrorTracking(cfg.ErrorTracking)
+	l.validateFeatureFlags(cfg.FeatureFlags)
+	l.validateTenancy(cfg.Tenancy, cfg.Region.Region)
+	l.validateSearch(cfg.Search)
+	l.validateObjectStore(cfg.ObjectStore)
+	l.validateColdStorage(cfg.ColdStorage, cfg.ObjectStore)
+	l.validateEncryption(cfg.Encryption)
+	l.validateRedaction(cfg.Redaction)
+	l.validateLeader(cfg.Leader)
+	l.validateJobs(cfg.Jobs)
+	l.validateCron(cfg.Cron)
+	l.validateRegion(cfg.Region, cfg.OrderStorage)
+	l.validateDeadlines(cfg.Deadlines, cfg.DetachedTimeout)
+	l.validateJWT(cfg)
+	l.validateSecrets(cfg.Secrets, string(cfg.JWTSecret) != fallbackJWTSecret, uriSet || cfg.Mongo.Host != "")
+	l.validateTLS(cfg.TLS)
+	l.validateFile()
+	if cfg.Tracking.MaxConnections < 1 || cfg.Tracking.MaxConnections > 100000 {
+		l.fail("TRACKING_MAX_CONNECTIONS", strconv.Itoa(cfg.Tracking.MaxConnections), "an integer between 1 and 100000")
+	}
+	if cfg.Tracking.MaxConnectionsPerUser < 1 || cfg.Tracking.MaxConnectionsPerUser > cfg.Tracking.MaxConnections {
+		l.fail("TRACKING_MAX_CONNECTIONS_PER_USER", strconv.Itoa(cfg.Tracking.MaxConnectionsPerUser), "an integer between 1 and TRACKING_MAX_CONNECTIONS")
+	}
+	if cfg.Tracking.MaxSubscriptions < 1 || cfg.Tracking.MaxSubscriptions > 1000 {
+		l.fail("TRACKING_MAX_SUBSCRIPTIONS", strconv.Itoa(cfg.Tracking.MaxSubscriptions), "an integer between 1 and 1000")
+	}
+	if len(l.violations) > 0 {
+		return cfg, &ConfigError{Violations: l.violations}
+	}
+	return cfg, nil
+}
+
+// validate checks ranges, formats and combinations of settings
+func (l *configLoader) validate(cfg Config) {
+	release := cfg.GinMode == "release"
+
+	switch cfg.GinMode {
+	case "", "debug", "release", "test":
+	default:
+		l.fail("GIN_MODE", cfg.GinMode, "one of debug, release or test")
+	}
+
+	if port, err := strconv.Atoi(cfg.Port); err != nil || port < 1 || port > 65535 {
+		l.fail("PORT", cfg.Port, "a TCP port between 1 and 65535")
+	}
+	if cfg.GRPCPort != "" {
+		if port, err := strconv.Atoi(cfg.GRPCPort); err != nil || port < 1 || port > 65535 || cfg.GRPCPort == cfg.Port {
+			l.fail("GRPC_PORT", cfg.GRPCPort, "a TCP port between 1 and 65535 other than PORT")
+		}
+	}
+	if cfg.DebugPort != "" {
+		if port, err := strconv.Atoi(cfg.DebugPort); err != nil || port < 1 || port > 65535 || cfg.DebugPort == cfg.Port || cfg.DebugPort == cfg.GRPCPort {
+			l.fail("DEBUG_PORT", cfg.DebugPort, "a TCP port between 1 and 65535 other than PORT and GRPC_PORT")
+		}
+	}
+	if cfg.ShutdownDrainDelay < 0 || cfg.ShutdownDrainDelay > time.Minute {
+		l.fail("SHUTDOWN_DRAIN_DELAY", cfg.ShutdownDrainDelay.String(), "a duration between 0 and 1m")
+	}
+	if cfg.ShutdownTimeout < time.Second || cfg.ShutdownTimeout > 5*time.Minute {
+		l.fail("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout.String(), "a duration between 1s and 5m")
+	}
+	if _, err := zerolog.ParseLevel(cfg.LogLevel); err != nil || cfg.LogLevel == "" {
+		l.fail("LOG_LEVEL", cfg.LogLevel, "trace, debug, info, warn, error, fatal, panic or disabled")
+	}
+	if cfg.JWKSURL != "" {
+		l.httpURL("JWKS_URL", cfg.JWKSURL)
+		if release && !strings.HasPrefix(cfg.JWKSURL, "https://") {
+			l.fail("JWKS_URL", cfg.JWKSURL, "an https:// URL when GIN_MODE=release")
+		}
+	}
+	if cfg.JWKSRefreshInterval < time.Minute || cfg.JWKSRefreshInterval > 24*time.Hour {
+		l.fail("JWKS_REFRESH_INTERVAL", cfg.JWKSRefreshInterval.String(), "a duration between 1m and 24h")
+	}
+	if cfg.ConfigReloadInterval != 0 && cfg.ConfigReloadInterval < time.Second {
+		l.fail("CONFIG_RELOAD_INTERVAL", cfg.ConfigReloadInterval.String(), "0 or a duration of at least 1s")
+	}
+
+	l.mongoURI("MONGODB_URI", cfg.MongoURI)
+	l.mongoURI("READ_MODEL_MONGODB_URI", cfg.ReadModelURI)
+	if cfg.ReadModelDatabase == cfg.Database && cfg.ReadModelURI == cfg.MongoURI {
+		l.fail("READ_MODEL_DATABASE", cfg.ReadModelDatabase, "a database other than "+cfg.Database+" on the same cluster")
+	}
+
+	switch cfg.OrderStorage {
+	case "document", "eventsourced":
+	default:
+		l.fail("ORDER_STORAGE", cfg.OrderStorage, "document or eventsourced")
+	}
+	if cfg.SnapshotInterval < 0 {
+		l.fail("ORDER_SNAPSHOT_INTERVAL", strconv.Itoa(cfg.SnapshotInterval), "0 (disabled) or a positive event count")
+	}
+	// Event-sourced projections are replaced whole, which tells no change
+	// apart, and regional copies change with every replicated write
+	switch cfg.EventSource {
+	case "service":
+	case "changestream":
+		if cfg.OrderStorage != "document" {
+			l.fail("ORDER_EVENT_SOURCE", cfg.EventSource, "service when ORDER_STORAGE is "+cfg.OrderStorage)
+		}
+		if cfg.Region.Region != "" {
+			l.fail("ORDER_EVENT_SOURCE", cfg.EventSource, "service when REGION is set")
+		}
+	default:
+		l.fail("ORDER_EVENT_SOURCE", cfg.EventSource, "service or changestream")
+	}
+
+	if cfg.OrderLimits.MaxItems < 1 {
+		l.fail("ORDER_MAX_ITEMS", strconv.Itoa(cfg.OrderLimits.MaxItems), "a positive number of line items")
+	}
+	if cfg.OrderLimits.MaxQuantity < 1 {
+		l.fail("ORDER_MAX_ITEM_QUANTITY", strconv.Itoa(cfg.OrderLimits.MaxQuantity), "a positive quantity")
+	}
+	if cfg.OrderLimits.MaxBulkOrders < 1 || cfg.OrderLimits.MaxBulkOrders > 1000 {
+		l.fail("ORDER_BULK_MAX_ORDERS", strconv.Itoa(cfg.OrderLimits.MaxBulkOrders), "between 1 and 1000 orders")
+	}
+
+	secret := string(cfg.JWTSecret)
+	switch {
+	case !acceptsHMAC(cfg.JWTAlgorithms):
+		// Tokens are only verified with public keys
+	case cfg.Secrets.JWTSecretRef != "":
+		// Checked by LoadSecrets once fetched
+	case release && secret == fallbackJWTSecret:
+		l.fail("JWT_SECRET", "", "a secret to be set when GIN_MODE=release")
+	case release && len(secret) < 32:
+		l.fail("JWT_SECRET", "<redacted>", "at least 32 bytes when GIN_MODE=release")
+	}
+	if cfg.GuestSecret != nil && len(cfg.GuestSecret) < api.MinGuestSecretLength {
+		l.fail("GUEST_CHECKOUT_SECRET", "<redacted>", fmt.Sprintf("at least %d bytes", api.MinGuestSecretLength))
+	}
+
+	for _, origin := range cfg.CORSAllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "" || origin == "*" {
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			l.fail("CORS_ALLOWED_ORIGINS", origin, "a comma-separated list of origins such as https://shop.example.com, or *")
+		}
+	}
+
+	if cfg.RateLimitRPS < 0 {
+		l.fail("RATE_LIMIT_RPS", strconv.FormatFloat(cfg.RateLimitRPS, 'f', -1, 64), "0 (disabled) or a positive rate")
+	}
+	if cfg.RateLimitBurst < 0 {
+		l.fail("RATE_LIMIT_BURST", strconv.Itoa(cfg.RateLimitBurst), "0 (a burst of 1) or a positive request count")
+	}
+
+	if cfg.PactVerification && release {
+		l.fail("PACT_VERIFICATION", "true", "false when GIN_MODE=release; provider states stub authentication")
+	}
+
+	l.httpURL("USER_SERVICE_URL", cfg.UserServiceURL)
+	l.httpURL("PRODUCT_SERVICE_URL", cfg.ProductServiceURL)
+	if cfg.InternalAPIToken != "" && len(cfg.InternalAPIToken) < 16 {
+		l.fail("INTERNAL_API_TOKEN", "<redacted>", "at least 16 bytes")
+	}
+	if cfg.DebugLogToken != "" && len(cfg.DebugLogToken) < 16 {
+		l.fail("DEBUG_LOG_TOKEN", "<redacted>", "at least 16 bytes")
+	}
+	if cfg.GRPCPort != "" && cfg.InternalAPIToken == "" {
+		l.fail("GRPC_PORT", cfg.GRPCPort, "unset unless INTERNAL_API_TOKEN is set; gRPC callers authenticate with it")
+	}
+	if cfg.InventoryReservations && cfg.InternalAPIToken == "" {
+		l.fail("INVENTORY_RESERVATIONS", "true", "false unless INTERNAL_API_TOKEN is set; product-service authenticates reservations with it")
+	}
+	if cfg.PaymentServiceURL != "" {
+		l.httpURL("PAYMENT_SERVICE_URL", cfg.PaymentServiceURL)
+	}
+	if cfg.AddressValidationURL != "" {
+		l.httpURL("ADDRESS_VALIDATION_URL", cfg.AddressValidationURL)
+	}
+	if u := cfg.PaymentEvents.URL; u != "" {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "amqp" && parsed.Scheme != "amqps") || parsed.Host == "" {
+			l.fail("RABBITMQ_URL", redactURI(u), "an amqp:// or amqps:// URL")
+		}
+	}
+	if cfg.PaymentEvents.Prefetch < 1 || cfg.PaymentEvents.Prefetch > 1000 {
+		l.fail("PAYMENTS_CONFIRMED_PREFETCH", strconv.Itoa(cfg.PaymentEvents.Prefetch), "between 1 and 1000")
+	}
+	if cfg.PaymentEvents.RetryDelay < 100*time.Millisecond || cfg.PaymentEvents.RetryDelay > 5*time.Minute {
+		l.fail("PAYMENTS_CONFIRMED_RETRY_DELAY", cfg.PaymentEvents.RetryDelay.String(), "a duration between 100ms and 5m")
+	}
+	if cfg.SagaRecoveryInterval < time.Second || cfg.SagaRecoveryInterval > time.Hour {
+		l.fail("SAGA_RECOVERY_INTERVAL", cfg.SagaRecoveryInterval.String(), "a duration between 1s and 1h")
+	}
+	if cfg.CatalogCacheTTL < 0 || cfg.CatalogCacheTTL > time.Hour {
+		l.fail("CATALOG_CACHE_TTL", cfg.CatalogCacheTTL.String(), "0 (no caching) or a duration up to 1h")
+	}
+
+	if cfg.ProjectionPollInterval < 100*time.Millisecond || cfg.ProjectionPollInterval > time.Hour {
+		l.fail("PROJECTION_POLL_INTERVAL", cfg.ProjectionPollInterval.String(), "a duration between 100ms and 1h")
+	}
+	if cfg.LivePollInterval < 100*time.Millisecond || cfg.LivePollInterval > time.Minute {
+		l.fail("LIVE_POLL_INTERVAL", cfg.LivePollInterval.String(), "a duration between 100ms and 1m")
+	}
+
+	if cfg.IdempotencyKeyTTL < time.Hour || cfg.IdempotencyKeyTTL > 7*24*time.Hour {
+		l.fail("IDEMPOTENCY_KEY_TTL", cfg.IdempotencyKeyTTL.String(), "a duration between 1h and 168h")
+	}
+	if cfg.WebhookTimeout < time.Second || cfg.WebhookTimeout > time.Minute {
+		l.fail("WEBHOOK_TIMEOUT", cfg.WebhookTimeout.String(), "a duration between 1s and 1m")
+	}
+	if cfg.WebhookRetryInterval < time.Second || cfg.WebhookRetryInterval > time.Hour {
+		l.fail("WEBHOOK_RETRY_INTERVAL", cfg.WebhookRetryInterval.String(), "a duration between 1s and 1h")
+	}
+	if cfg.WebhookMaxAge < time.Minute || cfg.WebhookMaxAge > 72*time.Hour {
+		l.fail("WEBHOOK_MAX_AGE", cfg.WebhookMaxAge.String(), "a duration between 1m and 72h")
+	}
+
+	if cfg.OrderArchiveAfter != 0 && cfg.OrderArchiveAfter < 24*time.Hour {
+		l.fail("ORDER_ARCHIVE_AFTER", cfg.OrderArchiveAfter.String(), "0 (never archive) or a duration of at least 24h")
+	}
+	if cfg.OrderArchiveInterval < time.Minute || cfg.OrderArchiveInterval > 24*time.Hour {
+		l.fail("ORDER_ARCHIVE_INTERVAL", cfg.OrderArchiveInterval.String(), "a duration between 1m and 24h")
+	}
+	// Placements finish within seconds; a shorter TTL could cancel them
+	if cfg.OrderPendingTTL != 0 && cfg.OrderPendingTTL < 5*time.Minute {
+		l.fail("ORDER_PENDING_TTL", cfg.OrderPendingTTL.String(), "0 (never expire) or a duration of at least 5m")
+	}
+	if cfg.OrderExpiryInterval < 10*time.Second || cfg.OrderExpiryInterval > time.Hour {
+		l.fail("ORDER_EXPIRY_INTERVAL", cfg.OrderExpiryInterval.String(), "a duration between 10s and 1h")
+	}
+
+	if cfg.OrderRetention != 0 && cfg.OrderRetention < 24*time.Hour {
+		l.fail("ORDER_RETENTION", cfg.OrderRetention.String(), "0 (keep personal data) or a duration of at least 24h")
+	}
+	if (cfg.OrderRetention > 0 || len(cfg.AnonymizationKey) > 0) && len(cfg.AnonymizationKey) < 32 {
+		l.fail("ANONYMIZATION_KEY", "<redacted>", "at least 32 bytes, and set when ORDER_RETENTION is")
+	}
+	if cfg.OrderRetention > 0 && cfg.IdempotencyKeyTTL > cfg.OrderRetention {
+		// Keys record the user who sent them and are not anonymized
+		l.fail("IDEMPOTENCY_KEY_TTL", cfg.IdempotencyKeyTTL.String(), "at most ORDER_RETENTION "+cfg.OrderRetention.String())
+	}
+}
+
+func (l *configLoader) mongoURI(key, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "mongodb" && u.Scheme != "mongodb+srv") || u.Host == "" {
+		l.fail(key, redactURI(value), "a mongodb:// or mongodb+srv:// connection string")
+	}
+}
+
+func (l *configLoader) httpURL(key, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		l.fail(key, value, "an http:// or https:// base URL")
+	}
+}
+
+// redactURI strips credentials so violations can be logged safely
+func redactURI(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "<unparseable>"
+	}
+	return u.Redacted()
+}
+
+// optionalBytes returns the bytes of value, or nil when it is empty, so an
+// unset secret reads as absent rather than as an empty key
+func optionalBytes(value string) []byte {
+	if value == "" {
+		return nil
+	}
+	return []byte(value)
+}
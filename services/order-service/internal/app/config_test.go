@@ -0,0 +1,38 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuestSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		enabled bool
+		wantErr bool
+	}{
+		{name: "unset", secret: ""},
+		{name: "too short", secret: "short-secret", wantErr: true},
+		{name: "long enough", secret: strings.Repeat("s", 32), enabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GUEST_CHECKOUT_SECRET", tt.secret)
+
+			cfg, err := LoadConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "GUEST_CHECKOUT_SECRET") {
+					t.Errorf("error %q does not name GUEST_CHECKOUT_SECRET", err)
+				}
+				return
+			}
+			if got := cfg.GuestSecret != nil; got != tt.enabled {
+				t.Errorf("guest secret set = %v, want %v", got, tt.enabled)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile reads the settings of a YAML config file, a mapping from
+// variable names to values, e.g.
+//
+//	LOG_LEVEL: debug
+//	REQUEST_TIMEOUT_READ: 3s
+//	CORS_ALLOWED_ORIGINS: [https://shop.example.com, https://admin.example.com]
+//
+// Lists are joined with commas, as the variables are written in the
+// environment. Values the environment sets win over the file.
+func (l *configLoader) loadFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.fail("CONFIG_FILE", path, "a readable YAML file")
+		return
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		l.fail("CONFIG_FILE", path, "a YAML mapping of variable names to values: "+err.Error())
+		return
+	}
+
+	l.file = map[string]string{}
+	for key, value := range settings {
+		s, ok := fileValue(value)
+		if !ok {
+			l.fail(key, fmt.Sprint(value), "a scalar or a list of scalars in CONFIG_FILE")
+			continue
+		}
+		l.file[key] = s
+	}
+}
+
+// fileValue renders a YAML value as the variable would be written
+func fileValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case time.Time:
+		// YAML resolves unquoted timestamps
+		return v.Format(time.RFC3339), true
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			s, ok := fileValue(item)
+			if !ok {
+				return "", false
+			}
+			if _, list := item.([]interface{}); list {
+				return "", false
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), true
+	default:
+		return "", false
+	}
+}
+
+// validateFile reports file settings no variable was read for, most often
+// misspelled names that would otherwise be silently ignored
+func (l *configLoader) validateFile() {
+	var unknown []string
+	for key := range l.file {
+		if !l.read[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		// The value is left out as it may be a secret
+		l.fail(key, "...", "a setting of the order service in CONFIG_FILE")
+	}
+}
@@ -0,0 +1,184 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"order-service/pkg/cron"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// The maintenance tasks CRON_SCHEDULES may schedule
+const (
+	// TaskOrderExpiry cancels orders pending past ORDER_PENDING_TTL
+	TaskOrderExpiry = "order-expiry"
+	// TaskOrderArchive moves finished orders to the archive
+	TaskOrderArchive = "order-archive"
+	// TaskColdStorage exports old orders to object storage
+	TaskColdStorage = "cold-storage"
+	// TaskOrderMetrics rolls the orders up into the orders_by_status gauge
+	TaskOrderMetrics = "order-metrics"
+)
+
+var cronTasks = []string{TaskOrderExpiry, TaskOrderArchive, TaskColdStorage, TaskOrderMetrics}
+
+var ordersByStatus = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "orders_by_status",
+		Help: "Number of orders not deleted in each status, as of the last order-metrics run",
+	},
+	[]string{"status"},
+)
+
+func init() {
+	prometheus.MustRegister(ordersByStatus)
+}
+
+// CronOptions schedule maintenance tasks at set times rather than at an
+// interval from startup
+type CronOptions struct {
+	// Schedules maps tasks to their cron expression; a scheduled task no
+	// longer runs at its interval
+	Schedules map[string]string
+	// Timezone is the IANA time zone schedules are read in
+	Timezone string
+}
+
+// Scheduled reports whether task runs on a cron schedule
+func (o CronOptions) Scheduled(task string) bool {
+	return o.Schedules[task] != ""
+}
+
+// loadCronOptions reads CRON_SCHEDULES, a semicolon-separated list of
+// task=expression, and CRON_TIMEZONE
+func (l *configLoader) loadCronOptions() CronOptions {
+	opts := CronOptions{Timezone: l.envOr("CRON_TIMEZONE", "UTC")}
+	if spec := l.env("CRON_SCHEDULES"); spec != "" {
+		opts.Schedules = map[string]string{}
+		for _, entry := range strings.Split(spec, ";") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			name, expression, ok := strings.Cut(entry, "=")
+			name, expression = strings.TrimSpace(name), strings.TrimSpace(expression)
+			if !ok || name == "" || expression == "" {
+				l.fail("CRON_SCHEDULES", entry, "a semicolon-separated list such as order-expiry=*/5 * * * *;order-metrics=@every 1m")
+				continue
+			}
+			opts.Schedules[name] = expression
+		}
+	}
+	return opts
+}
+
+// validateCron checks every schedule names a known task and parses
+func (l *configLoader) validateCron(opts CronOptions) {
+	if _, err := time.LoadLocation(opts.Timezone); err != nil {
+		l.fail("CRON_TIMEZONE", opts.Timezone, "an IANA time zone such as Europe/Berlin")
+	}
+	names := make([]string, 0, len(opts.Schedules))
+	for name := range opts.Schedules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		known := false
+		for _, task := range cronTasks {
+			known = known || name == task
+		}
+		if !known {
+			l.fail("CRON_SCHEDULES", name, "tasks among "+strings.Join(cronTasks, ", "))
+			continue
+		}
+		if _, err := cron.Parse(opts.Schedules[name]); err != nil {
+			l.fail("CRON_SCHEDULES", name+"="+opts.Schedules[name], "a cron expression such as */5 * * * *, @hourly or @every 10m")
+		}
+	}
+}
+
+// NewScheduler returns the scheduler of the tasks of CRON_SCHEDULES, or nil
+// without any. Tasks whose feature is disabled are left out.
+func (a *App) NewScheduler() *cron.Scheduler {
+	if len(a.Config.Cron.Schedules) == 0 {
+		return nil
+	}
+	scheduler := cron.NewScheduler()
+	scheduler.Clock = a.Clock
+	// Validated with the config
+	scheduler.Location, _ = time.LoadLocation(a.Config.Cron.Timezone)
+
+	tasks := map[string]cron.Task{TaskOrderMetrics: a.rollupOrderMetrics}
+	if a.Config.OrderPendingTTL > 0 {
+		tasks[TaskOrderExpiry] = func(ctx context.Context) error {
+			expired, err := a.Service.ExpireOrders(ctx, a.Config.OrderPendingTTL)
+			if expired > 0 {
+				log.Info().Int("expired", expired).Msg("Expired pending orders")
+			}
+			return err
+		}
+	}
+	if a.Archiver != nil {
+		tasks[TaskOrderArchive] = func(ctx context.Context) error {
+			moved, err := a.Archiver.ArchiveOnce(ctx)
+			if moved > 0 {
+				log.Info().Int("moved", moved).Msg("Archived orders")
+			}
+			return err
+		}
+	}
+	if a.ColdStorage != nil {
+		tasks[TaskColdStorage] = func(ctx context.Context) error {
+			exported, err := a.ColdStorage.ExportOnce(ctx)
+			if exported > 0 {
+				log.Info().Int("exported", exported).Msg("Exported orders to cold storage")
+			}
+			return err
+		}
+	}
+
+	for _, name := range cronTasks {
+		spec := a.Config.Cron.Schedules[name]
+		if spec == "" {
+			continue
+		}
+		task, ok := tasks[name]
+		if !ok {
+			log.Warn().Str("task", name).Msg("Scheduled task is disabled by its own settings; not scheduling it")
+			continue
+		}
+		if err := scheduler.Add(name, spec, task); err != nil {
+			log.Error().Err(err).Str("task", name).Msg("Failed to schedule task")
+		}
+	}
+	return scheduler
+}
+
+// rollupOrderMetrics counts the orders of the local database by status
+func (a *App) rollupOrderMetrics(ctx context.Context) error {
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"deleted_at": bson.M{"$exists": false}}},
+		bson.M{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+	}
+	cursor, err := a.DB.Collection("orders").Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var counts []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return err
+	}
+	// Statuses no order is in any more are dropped
+	ordersByStatus.Reset()
+	for _, c := range counts {
+		ordersByStatus.WithLabelValues(c.Status).Set(float64(c.Count))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/currency"
+	"order-service/pkg/money"
+)
+
+// CurrencyOptions configure conversion of order totals to a client's
+// preferred currency and of multi-currency reports to ReportingCurrency
+type CurrencyOptions struct {
+	// RateSource is "fixed" (a static table), "ecb" (the ECB daily feed) or
+	// "http" (a JSON rate API at RatesURL)
+	RateSource string
+	// FixedBase and FixedRates form the static table, e.g. USD with
+	// {"EUR": "0.92"}
+	FixedBase  string
+	FixedRates map[string]string
+	ECBURL     string
+	RatesURL   string
+	// RatesTTL is how long fetched rates are reused
+	RatesTTL          time.Duration
+	ReportingCurrency string
+	// SettlementCurrency is the currency every order's total is normalized
+	// to when it is priced; empty disables normalization
+	SettlementCurrency string
+}
+
+// loadCurrencyOptions reads the CURRENCY_* conversion settings
+func (l *configLoader) loadCurrencyOptions() CurrencyOptions {
+	opts := CurrencyOptions{
+		RateSource:         l.envOr("CURRENCY_RATE_SOURCE", "fixed"),
+		FixedBase:          strings.ToUpper(l.envOr("CURRENCY_FIXED_BASE", money.DefaultCurrency)),
+		ECBURL:             l.envOr("CURRENCY_ECB_URL", currency.ECBDailyURL),
+		RatesURL:           l.env("CURRENCY_RATES_URL"),
+		RatesTTL:           l.durationVar("CURRENCY_RATES_TTL", time.Hour),
+		ReportingCurrency:  strings.ToUpper(l.envOr("REPORTING_CURRENCY", money.DefaultCurrency)),
+		SettlementCurrency: strings.ToUpper(l.env("SETTLEMENT_CURRENCY")),
+	}
+	if spec := l.env("CURRENCY_FIXED_RATES"); spec != "" {
+		rates, err := currency.ParseFixedRates(spec)
+		if err != nil {
+			l.fail("CURRENCY_FIXED_RATES", spec, "a comma-separated list such as EUR=0.92,GBP=0.79")
+		}
+		opts.FixedRates = rates
+	}
+	return opts
+}
+
+// validateCurrency checks the conversion settings
+func (l *configLoader) validateCurrency(opts CurrencyOptions) {
+	switch opts.RateSource {
+	case "fixed", "ecb", "http":
+	default:
+		l.fail("CURRENCY_RATE_SOURCE", opts.RateSource, "fixed, ecb or http")
+	}
+	if _, err := money.NormalizeCurrency(opts.FixedBase); err != nil {
+		l.fail("CURRENCY_FIXED_BASE", opts.FixedBase, "a three-letter ISO 4217 code")
+	}
+	if _, err := money.NormalizeCurrency(opts.ReportingCurrency); err != nil {
+		l.fail("REPORTING_CURRENCY", opts.ReportingCurrency, "a three-letter ISO 4217 code")
+	}
+	if opts.SettlementCurrency != "" {
+		if _, err := money.NormalizeCurrency(opts.SettlementCurrency); err != nil {
+			l.fail("SETTLEMENT_CURRENCY", opts.SettlementCurrency, "a three-letter ISO 4217 code")
+		}
+	}
+	if _, err := currency.NewFixedSource(opts.FixedBase, opts.FixedRates); err != nil {
+		l.fail("CURRENCY_FIXED_RATES", fmt.Sprint(opts.FixedRates), "positive decimal rates")
+	}
+	switch opts.RateSource {
+	case "ecb":
+		l.httpURL("CURRENCY_ECB_URL", opts.ECBURL)
+	case "http":
+		l.httpURL("CURRENCY_RATES_URL", opts.RatesURL)
+	}
+	if opts.RatesTTL < time.Minute {
+		l.fail("CURRENCY_RATES_TTL", opts.RatesTTL.String(), "at least 1m")
+	}
+}
+
+// NewCurrencyConverter returns the converter for the configured rate source
+func NewCurrencyConverter(opts CurrencyOptions, clk clock.Clock) (*currency.Converter, error) {
+	var source currency.Source
+	switch opts.RateSource {
+	case "", "fixed":
+		fixed, err := currency.NewFixedSource(opts.FixedBase, opts.FixedRates)
+		if err != nil {
+			return nil, err
+		}
+		source = fixed
+	case "ecb":
+		source = currency.NewECBSource(opts.ECBURL)
+	case "http":
+		source = currency.NewHTTPSource(opts.RatesURL)
+	default:
+		return nil, fmt.Errorf("unknown CURRENCY_RATE_SOURCE %q, expected fixed, ecb or http", opts.RateSource)
+	}
+	return currency.NewConverter(source, opts.RatesTTL, clk), nil
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"strings"
+	"time"
+
+	"order-service/internal/api"
+)
+
+// loadDeadlines reads the REQUEST_TIMEOUT_* per-class deadlines and the
+// REQUEST_TIMEOUTS per-endpoint overrides, e.g.
+// "POST /api/admin/events/replay=10m,GET /api/orders/:id=1s"
+func (l *configLoader) loadDeadlines() api.Deadlines {
+	d := api.Deadlines{
+		Read:   l.durationVar("REQUEST_TIMEOUT_READ", api.DefaultDeadlines.Read),
+		Write:  l.durationVar("REQUEST_TIMEOUT_WRITE", api.DefaultDeadlines.Write),
+		Bulk:   l.durationVar("REQUEST_TIMEOUT_BULK", api.DefaultDeadlines.Bulk),
+		Routes: map[string]time.Duration{},
+	}
+	for route, timeout := range api.DefaultDeadlines.Routes {
+		d.Routes[route] = timeout
+	}
+
+	spec := l.env("REQUEST_TIMEOUTS")
+	if spec == "" {
+		return d
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		route, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		method, path, hasPath := strings.Cut(route, " ")
+		timeout, err := time.ParseDuration(value)
+		if !ok || !hasPath || method == "" || !strings.HasPrefix(path, "/") || err != nil {
+			l.fail("REQUEST_TIMEOUTS", entry, `a comma-separated list such as "GET /api/orders/:id=1s"`)
+			continue
+		}
+		d.Routes[strings.ToUpper(method)+" "+path] = timeout
+	}
+	return d
+}
+
+// validateDeadlines keeps every deadline between 100ms and 10m
+func (l *configLoader) validateDeadlines(d api.Deadlines, detached time.Duration) {
+	check := func(key string, timeout time.Duration) {
+		if timeout < 100*time.Millisecond || timeout > 10*time.Minute {
+			l.fail(key, timeout.String(), "a duration between 100ms and 10m")
+		}
+	}
+	check("REQUEST_TIMEOUT_READ", d.Read)
+	check("REQUEST_TIMEOUT_WRITE", d.Write)
+	check("REQUEST_TIMEOUT_BULK", d.Bulk)
+	check("REQUEST_TIMEOUT_DETACHED", detached)
+	for route, timeout := range d.Routes {
+		check("REQUEST_TIMEOUTS["+route+"]", timeout)
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strconv"
+	"time"
+)
+
+// NewDebugHandler serves the runtime's diagnostics, never to be exposed
+// publicly:
+//
+//   - /debug/pprof/ profiles, e.g. /debug/pprof/profile?seconds=30 for CPU
+//     and /debug/pprof/heap for memory, read with go tool pprof
+//   - /debug/vars the expvar variables, including memstats
+//   - /debug/goroutines a dump of every goroutine's stack
+func NewDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Goroutines", strconv.Itoa(runtime.NumGoroutine()))
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	return mux
+}
+
+// newDebugServer returns the server of NewDebugHandler on host and port.
+// It has no write timeout, as CPU profiles and traces stream for as long
+// as asked.
+func newDebugServer(host, port string) *http.Server {
+	return &http.Server{
+		Addr:              net.JoinHostPort(host, port),
+		Handler:           NewDebugHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"order-service/pkg/awssig"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/fieldcrypt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// keyRefreshInterval is how often replicas pick up the data keys others
+// rotated to
+const keyRefreshInterval = 5 * time.Minute
+
+// EncryptionOptions configure the field-level encryption of the personal
+// data on orders
+type EncryptionOptions struct {
+	// Provider holds the key-encryption key: "local" or "aws-kms"; empty
+	// stores personal data in plaintext
+	Provider string
+	// LocalKey is the base64 32-byte key-encryption key of "local"
+	LocalKey string
+
+	KMSKeyID    string
+	KMSRegion   string
+	KMSEndpoint string
+	Credentials awssig.Credentials
+
+	// Rotation is how old the newest data key gets before a new one is
+	// created; 0 never rotates
+	Rotation time.Duration
+}
+
+// loadEncryptionOptions reads the FIELD_ENCRYPTION settings. The KMS
+// region and credentials are AWS_REGION and the AWS_ACCESS_KEY_ID family.
+func (l *configLoader) loadEncryptionOptions() EncryptionOptions {
+	return EncryptionOptions{
+		Provider:    l.env("FIELD_ENCRYPTION"),
+		LocalKey:    l.env("FIELD_ENCRYPTION_KEY"),
+		KMSKeyID:    l.env("FIELD_ENCRYPTION_KMS_KEY_ID"),
+		KMSRegion:   l.env("AWS_REGION"),
+		KMSEndpoint: l.env("FIELD_ENCRYPTION_KMS_ENDPOINT"),
+		Credentials: awssig.Credentials{
+			AccessKeyID:     l.env("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: l.env("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    l.env("AWS_SESSION_TOKEN"),
+		},
+		Rotation: l.durationVar("FIELD_ENCRYPTION_KEY_ROTATION", 0),
+	}
+}
+
+// validateEncryption checks the provider has what it needs
+func (l *configLoader) validateEncryption(opts EncryptionOptions) {
+	switch opts.Provider {
+	case "":
+		return
+	case "local":
+		if key, err := base64.StdEncoding.DecodeString(opts.LocalKey); err != nil || len(key) != 32 {
+			l.fail("FIELD_ENCRYPTION_KEY", "<redacted>", "32 base64-encoded bytes with FIELD_ENCRYPTION=local")
+		}
+	case "aws-kms":
+		if opts.KMSKeyID == "" {
+			l.fail("FIELD_ENCRYPTION_KMS_KEY_ID", "", "a KMS key ID, ARN or alias with FIELD_ENCRYPTION=aws-kms")
+		}
+		if opts.KMSRegion == "" {
+			l.fail("AWS_REGION", "", "a region with FIELD_ENCRYPTION=aws-kms")
+		}
+		if opts.Credentials.AccessKeyID == "" || opts.Credentials.SecretAccessKey == "" {
+			l.fail("AWS_ACCESS_KEY_ID", "", "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY with FIELD_ENCRYPTION=aws-kms")
+		}
+		if opts.KMSEndpoint != "" {
+			l.httpURL("FIELD_ENCRYPTION_KMS_ENDPOINT", opts.KMSEndpoint)
+		}
+	default:
+		l.fail("FIELD_ENCRYPTION", opts.Provider, "local or aws-kms")
+	}
+	if opts.Rotation != 0 && opts.Rotation < 24*time.Hour {
+		l.fail("FIELD_ENCRYPTION_KEY_ROTATION", opts.Rotation.String(), "0 (never rotate) or a duration of at least 24h")
+	}
+}
+
+// UseFieldEncryption loads the data keys in db and makes every process
+// component store the personal data on orders encrypted with them. It
+// returns nil without a provider, leaving personal data in plaintext.
+func UseFieldEncryption(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) (*fieldcrypt.Keyring, error) {
+	opts := cfg.Encryption
+	var wrapper fieldcrypt.KeyWrapper
+	switch opts.Provider {
+	case "local":
+		key, err := base64.StdEncoding.DecodeString(opts.LocalKey)
+		if err != nil {
+			return nil, err
+		}
+		if wrapper, err = fieldcrypt.NewLocalKEK(key); err != nil {
+			return nil, err
+		}
+	case "aws-kms":
+		wrapper = fieldcrypt.NewAWSKMS(opts.KMSRegion, opts.KMSKeyID, opts.Credentials, opts.KMSEndpoint)
+	default:
+		return nil, nil
+	}
+
+	keyring := fieldcrypt.NewKeyring(db.Collection(fieldcrypt.KeysCollection), wrapper)
+	keyring.Rotation = opts.Rotation
+	keyring.Clock = clk
+	refreshCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := keyring.Refresh(refreshCtx); err != nil {
+		return nil, fmt.Errorf("load field encryption keys: %w", err)
+	}
+	contracts.SetFieldCipher(keyring)
+	return keyring, nil
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"order-service/pkg/errreport"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrorTrackingOptions select the Sentry-compatible tracker panics and
+// server errors are reported to
+type ErrorTrackingOptions struct {
+	// DSN is the tracker's project DSN; empty reports nothing
+	DSN string
+	// Environment, such as "production", separates the events of each
+	// deployment in the tracker
+	Environment string
+	// Release is the version events are reported for
+	Release string
+}
+
+// loadErrorTrackingOptions reads the SENTRY_* settings
+func (l *configLoader) loadErrorTrackingOptions() ErrorTrackingOptions {
+	return ErrorTrackingOptions{
+		DSN:         l.env("SENTRY_DSN"),
+		Environment: l.env("SENTRY_ENVIRONMENT"),
+		Release:     l.env("SENTRY_RELEASE"),
+	}
+}
+
+// validateErrorTracking checks the DSN names a project
+func (l *configLoader) validateErrorTracking(opts ErrorTrackingOptions) {
+	if opts.DSN == "" {
+		return
+	}
+	if _, _, err := errreport.ParseDSN(opts.DSN); err != nil {
+		l.fail("SENTRY_DSN", "<redacted>", "a DSN such as https://<key>@<host>/<project>")
+	}
+}
+
+// NewErrorReporter returns the reporter cfg selects, errreport.Nop without
+// a DSN
+func NewErrorReporter(cfg Config) (errreport.ErrorReporter, error) {
+	if cfg.ErrorTracking.DSN == "" {
+		return errreport.Nop{}, nil
+	}
+	reporter, err := errreport.NewSentry(cfg.ErrorTracking.DSN, errreport.SentryOptions{
+		Environment: cfg.ErrorTracking.Environment,
+		Release:     cfg.ErrorTracking.Release,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("environment", cfg.ErrorTracking.Environment).Msg("Error tracking enabled")
+	return reporter, nil
+}
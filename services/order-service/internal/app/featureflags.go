@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"time"
+
+	"order-service/pkg/featureflags"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Feature flag sources
+const (
+	FeatureFlagsNone        = "none"
+	FeatureFlagsEnv         = "env"
+	FeatureFlagsFile        = "file"
+	FeatureFlagsRedis       = "redis"
+	FeatureFlagsOpenFeature = "openfeature"
+)
+
+// FeatureFlagOptions select what decides feature flags
+type FeatureFlagOptions struct {
+	// Source is FeatureFlagsEnv, FeatureFlagsFile, FeatureFlagsRedis,
+	// FeatureFlagsOpenFeature or FeatureFlagsNone, which leaves every flag
+	// at its default
+	Source string
+	// Flags are the env source's rules, e.g. "server_pricing=25%"
+	Flags string
+	// File holds the file source's rules as YAML or JSON
+	File string
+	// RedisURL and RedisKey locate the redis source's rules
+	RedisURL string
+	RedisKey string
+	// OpenFeatureURL is the flag service answering the OpenFeature Remote
+	// Evaluation Protocol
+	OpenFeatureURL string
+	// RefreshInterval is how often the file and redis rules are reloaded
+	RefreshInterval time.Duration
+}
+
+// loadFeatureFlagOptions reads the FEATURE_FLAGS* settings
+func (l *configLoader) loadFeatureFlagOptions() FeatureFlagOptions {
+	return FeatureFlagOptions{
+		Source:          l.envOr("FEATURE_FLAGS_SOURCE", FeatureFlagsNone),
+		Flags:           l.env("FEATURE_FLAGS"),
+		File:            l.env("FEATURE_FLAGS_FILE"),
+		RedisURL:        l.env("FEATURE_FLAGS_REDIS_URL"),
+		RedisKey:        l.envOr("FEATURE_FLAGS_REDIS_KEY", "order-service:feature-flags"),
+		OpenFeatureURL:  l.env("FEATURE_FLAGS_OFREP_URL"),
+		RefreshInterval: l.durationVar("FEATURE_FLAGS_REFRESH_INTERVAL", 30*time.Second),
+	}
+}
+
+// validateFeatureFlags checks the chosen source has what it reads from
+func (l *configLoader) validateFeatureFlags(opts FeatureFlagOptions) {
+	switch opts.Source {
+	case FeatureFlagsNone:
+	case FeatureFlagsEnv:
+		if _, err := featureflags.ParseEnvRules(opts.Flags); err != nil {
+			l.fail("FEATURE_FLAGS", opts.Flags, "comma-separated name=on|off|N%")
+		}
+	case FeatureFlagsFile:
+		if info, err := os.Stat(opts.File); err != nil || info.IsDir() {
+			l.fail("FEATURE_FLAGS_FILE", opts.File, "an existing file when FEATURE_FLAGS_SOURCE=file")
+		}
+	case FeatureFlagsRedis:
+		if _, err := featureflags.NewRedisSource(opts.RedisURL, opts.RedisKey); err != nil {
+			l.fail("FEATURE_FLAGS_REDIS_URL", "<redacted>", "a redis:// or rediss:// URL when FEATURE_FLAGS_SOURCE=redis")
+		}
+		if opts.RedisKey == "" {
+			l.fail("FEATURE_FLAGS_REDIS_KEY", "", "a key name")
+		}
+	case FeatureFlagsOpenFeature:
+		if u, err := url.Parse(opts.OpenFeatureURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			l.fail("FEATURE_FLAGS_OFREP_URL", opts.OpenFeatureURL, "an http(s) URL when FEATURE_FLAGS_SOURCE=openfeature")
+		}
+	default:
+		l.fail("FEATURE_FLAGS_SOURCE", opts.Source, "env, file, redis, openfeature or none")
+	}
+	if opts.RefreshInterval <= 0 {
+		l.fail("FEATURE_FLAGS_REFRESH_INTERVAL", opts.RefreshInterval.String(), "a positive duration")
+	}
+}
+
+// NewFeatureFlags returns the flags cfg selects, or nil, which leaves every
+// flag at its default. Rules that cannot be loaded fail startup.
+func NewFeatureFlags(ctx context.Context, cfg Config) (*featureflags.Flags, error) {
+	opts := cfg.FeatureFlags
+	var source featureflags.Source
+	switch opts.Source {
+	case FeatureFlagsEnv:
+		rules, err := featureflags.ParseEnvRules(opts.Flags)
+		if err != nil {
+			return nil, err
+		}
+		source = featureflags.StaticSource(rules)
+	case FeatureFlagsFile:
+		source = featureflags.FileSource(opts.File)
+	case FeatureFlagsRedis:
+		redis, err := featureflags.NewRedisSource(opts.RedisURL, opts.RedisKey)
+		if err != nil {
+			return nil, err
+		}
+		source = redis
+	case FeatureFlagsOpenFeature:
+		log.Info().Str("source", opts.Source).Msg("Feature flags enabled")
+		return featureflags.New(featureflags.NewOFREPProvider(opts.OpenFeatureURL)), nil
+	default:
+		return nil, nil
+	}
+	rules, err := featureflags.NewRuleProvider(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("source", opts.Source).Msg("Feature flags enabled")
+	return featureflags.New(rules), nil
+}
@@ -0,0 +1,74 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/jobs"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// JobOptions configure the persistent queue of background jobs: webhook
+// dispatch, notification fan-out and order exports
+type JobOptions struct {
+	// Workers run jobs concurrently on each replica; 0 disables the queue,
+	// running that work in the background of the process publishing it
+	Workers      int
+	PollInterval time.Duration
+	MaxAttempts  int
+	// Timeout bounds one attempt of a job
+	Timeout time.Duration
+}
+
+// loadJobOptions reads JOB_WORKERS, JOB_POLL_INTERVAL, JOB_MAX_ATTEMPTS and
+// JOB_TIMEOUT
+func (l *configLoader) loadJobOptions() JobOptions {
+	return JobOptions{
+		Workers:      l.intVar("JOB_WORKERS", 4),
+		PollInterval: l.durationVar("JOB_POLL_INTERVAL", time.Second),
+		MaxAttempts:  l.intVar("JOB_MAX_ATTEMPTS", 5),
+		Timeout:      l.durationVar("JOB_TIMEOUT", 5*time.Minute),
+	}
+}
+
+// validateJobs bounds the pool and the polling
+func (l *configLoader) validateJobs(opts JobOptions) {
+	if opts.Workers < 0 || opts.Workers > 64 {
+		l.fail("JOB_WORKERS", strconv.Itoa(opts.Workers), "0 (no queue) to 64 workers")
+	}
+	if opts.Workers == 0 {
+		return
+	}
+	if opts.PollInterval < 100*time.Millisecond || opts.PollInterval > time.Minute {
+		l.fail("JOB_POLL_INTERVAL", opts.PollInterval.String(), "a duration between 100ms and 1m")
+	}
+	if opts.MaxAttempts < 1 {
+		l.fail("JOB_MAX_ATTEMPTS", strconv.Itoa(opts.MaxAttempts), "at least 1")
+	}
+	if opts.Timeout < time.Second || opts.Timeout > time.Hour {
+		l.fail("JOB_TIMEOUT", opts.Timeout.String(), "a duration between 1s and 1h")
+	}
+}
+
+// NewJobQueue returns the job queue in db, or nil without workers. Index
+// creation failures are logged.
+func NewJobQueue(ctx context.Context, cfg Config, db *mongo.Database, clk clock.Clock) *jobs.Queue {
+	if cfg.Jobs.Workers == 0 {
+		return nil
+	}
+	queue := jobs.NewQueue(db.Collection(jobs.Collection))
+	queue.MaxAttempts = cfg.Jobs.MaxAttempts
+	queue.Timeout = cfg.Jobs.Timeout
+	queue.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := queue.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create job queue indexes")
+	}
+	return queue
+}
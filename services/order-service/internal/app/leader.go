@@ -0,0 +1,57 @@
+package app
+
+import (
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/leader"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// leaderElection names the election of the replica running the background
+// jobs
+const leaderElection = "order-service-jobs"
+
+// LeaderOptions configure the election of the one replica running the
+// background jobs that sweep every order
+type LeaderOptions struct {
+	// Disabled runs the jobs on every replica
+	Disabled bool
+	// Lease is how long a leader that stopped renewing holds the lease
+	// before another replica takes over; it stops the jobs after two
+	// thirds of it
+	Lease time.Duration
+}
+
+// loadLeaderOptions reads LEADER_ELECTION and LEADER_LEASE
+func (l *configLoader) loadLeaderOptions() LeaderOptions {
+	opts := LeaderOptions{Lease: l.durationVar("LEADER_LEASE", 15*time.Second)}
+	if enabled := l.optionalBoolVar("LEADER_ELECTION"); enabled != nil {
+		opts.Disabled = !*enabled
+	}
+	return opts
+}
+
+// validateLeader bounds the lease: renewals every third of it must not
+// load the database, nor failover take long
+func (l *configLoader) validateLeader(opts LeaderOptions) {
+	if opts.Disabled {
+		return
+	}
+	if opts.Lease < 3*time.Second || opts.Lease > 5*time.Minute {
+		l.fail("LEADER_LEASE", opts.Lease.String(), "a duration between 3s and 5m")
+	}
+}
+
+// NewElector returns the elector of the replica running the background
+// jobs, or nil when every replica runs them
+func NewElector(cfg Config, db *mongo.Database, clk clock.Clock) *leader.Elector {
+	if cfg.Leader.Disabled {
+		return nil
+	}
+	e := leader.NewElector(db.Collection(leader.Collection), leaderElection)
+	e.Lease = cfg.Leader.Lease
+	e.Clock = clk
+	return e
+}
@@ -0,0 +1,404 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/dbmonitor"
+	"order-service/pkg/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// MongoOptions are connection parameters applied on top of the connection
+// string, so Atlas and other hardened clusters can be configured without
+// hand-crafting a long URI
+type MongoOptions struct {
+	// Host, when set instead of MONGODB_URI, builds the URI; SRV selects the
+	// mongodb+srv:// scheme (e.g. cluster0.abcde.mongodb.net on Atlas)
+	Host string
+	SRV  bool
+
+	Username      string
+	Password      string
+	AuthSource    string
+	AuthMechanism string
+
+	ReplicaSet string
+	AppName    string
+
+	TLS            bool
+	TLSCAFile      string
+	TLSCertKeyFile string
+	TLSInsecure    bool
+
+	// RetryWrites and RetryReads override the driver default (true) when set
+	RetryWrites *bool
+	RetryReads  *bool
+	// Compressors lists wire compressors in preference order: zstd, snappy, zlib
+	Compressors []string
+
+	// BreakerThreshold consecutive failed order queries stop sending
+	// queries for BreakerCooldown; queries slower than BreakerSlowCall
+	// count as failed. A threshold of 0 disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	BreakerSlowCall  time.Duration
+
+	// SlowQueryThreshold logs every command taking longer, with its filter
+	// redacted; 0 logs none. Every command's latency is measured anyway.
+	SlowQueryThreshold time.Duration
+
+	// MaxPoolSize and MinPoolSize bound the connections kept to each
+	// server, and idle connections are closed after MaxConnIdleTime. Zero
+	// leaves each to the connection string or the driver default.
+	MaxPoolSize     int
+	MinPoolSize     int
+	MaxConnIdleTime time.Duration
+	// ConnectTimeout bounds opening a connection and
+	// ServerSelectionTimeout finding a server for an operation; zero
+	// leaves each to the connection string or the driver default (30s).
+	ConnectTimeout         time.Duration
+	ServerSelectionTimeout time.Duration
+	// PoolMaxWaiting operations waiting for a connection while every one
+	// is in use make the API refuse requests until the pool drains,
+	// rather than queue them until their deadline; 0 never refuses.
+	PoolMaxWaiting int
+
+	// SkipIndexCreation only verifies the orders indexes at startup, for
+	// clusters whose indexes are managed by hand; RequireIndexes makes the
+	// instance unready while a critical one is missing
+	SkipIndexCreation bool
+	RequireIndexes    bool
+
+	// ReadPreference and ReadConcern apply to the orders read by requests
+	// that change nothing, such as order lists, so they can be served by
+	// replicas; writes and everything else stay on the primary. Replicas
+	// lagging more than MaxStaleness are not read from. Empty leaves each
+	// to the connection string or the server default.
+	ReadPreference string
+	ReadConcern    string
+	MaxStaleness   time.Duration
+
+	// ShardKey is the shard key of a sharded orders collection, such as
+	// user_id,created_at; queries are then targeted at the shard holding
+	// each order. Empty when the collection is not sharded.
+	ShardKey []string
+}
+
+var (
+	mongoAuthMechanisms = map[string]bool{
+		"SCRAM-SHA-1":   true,
+		"SCRAM-SHA-256": true,
+		"MONGODB-X509":  true,
+		"MONGODB-AWS":   true,
+		"GSSAPI":        true,
+		"PLAIN":         true,
+	}
+	mongoCompressors = map[string]bool{"zstd": true, "snappy": true, "zlib": true}
+	// Snapshot reads need a transaction, so they are not offered
+	mongoReadConcerns = map[string]bool{"local": true, "available": true, "majority": true, "linearizable": true}
+)
+
+// loadMongoOptions reads the MONGODB_* connection parameters
+func (l *configLoader) loadMongoOptions() MongoOptions {
+	opts := MongoOptions{
+		Host:           l.env("MONGODB_HOST"),
+		SRV:            l.boolVar("MONGODB_SRV"),
+		Username:       l.env("MONGODB_USERNAME"),
+		Password:       l.env("MONGODB_PASSWORD"),
+		AuthSource:     l.env("MONGODB_AUTH_SOURCE"),
+		AuthMechanism:  l.env("MONGODB_AUTH_MECHANISM"),
+		ReplicaSet:     l.env("MONGODB_REPLICA_SET"),
+		AppName:        l.envOr("MONGODB_APP_NAME", "order-service"),
+		TLS:            l.boolVar("MONGODB_TLS"),
+		TLSCAFile:      l.env("MONGODB_TLS_CA_FILE"),
+		TLSCertKeyFile: l.env("MONGODB_TLS_CERT_KEY_FILE"),
+		TLSInsecure:    l.boolVar("MONGODB_TLS_INSECURE"),
+		RetryWrites:    l.optionalBoolVar("MONGODB_RETRY_WRITES"),
+		RetryReads:     l.optionalBoolVar("MONGODB_RETRY_READS"),
+
+		BreakerThreshold: l.intVar("MONGODB_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  l.durationVar("MONGODB_BREAKER_COOLDOWN", 10*time.Second),
+		BreakerSlowCall:  l.durationVar("MONGODB_BREAKER_SLOW_CALL", 0),
+
+		SlowQueryThreshold: l.durationVar("MONGODB_SLOW_QUERY_THRESHOLD", 100*time.Millisecond),
+
+		MaxPoolSize:            l.intVar("MONGODB_MAX_POOL_SIZE", 0),
+		MinPoolSize:            l.intVar("MONGODB_MIN_POOL_SIZE", 0),
+		MaxConnIdleTime:        l.durationVar("MONGODB_MAX_CONN_IDLE_TIME", 0),
+		ConnectTimeout:         l.durationVar("MONGODB_CONNECT_TIMEOUT", 0),
+		ServerSelectionTimeout: l.durationVar("MONGODB_SERVER_SELECTION_TIMEOUT", 0),
+		PoolMaxWaiting:         l.intVar("MONGODB_POOL_MAX_WAITING", 50),
+
+		SkipIndexCreation: l.boolVar("MONGODB_SKIP_INDEX_CREATION"),
+		RequireIndexes:    l.boolVar("MONGODB_REQUIRE_INDEXES"),
+
+		ReadPreference: l.env("MONGODB_READ_PREFERENCE"),
+		ReadConcern:    l.env("MONGODB_READ_CONCERN"),
+		MaxStaleness:   l.durationVar("MONGODB_MAX_STALENESS", 0),
+	}
+	if compressors := l.env("MONGODB_COMPRESSORS"); compressors != "" {
+		for _, c := range strings.Split(compressors, ",") {
+			opts.Compressors = append(opts.Compressors, strings.TrimSpace(c))
+		}
+	}
+	if key := l.env("MONGODB_SHARD_KEY"); key != "" {
+		for _, field := range strings.Split(key, ",") {
+			opts.ShardKey = append(opts.ShardKey, strings.TrimSpace(field))
+		}
+	}
+	return opts
+}
+
+// validateMongo checks the connection parameters for consistency. Regional
+// storage queries each region's collection by ID alone, so it cannot be
+// combined with a shard key.
+func (l *configLoader) validateMongo(uriSet bool, opts MongoOptions, region string) {
+	if uriSet && opts.Host != "" {
+		l.fail("MONGODB_HOST", opts.Host, "to be unset when MONGODB_URI is set; use one or the other")
+	}
+	if opts.SRV && opts.Host == "" {
+		l.fail("MONGODB_SRV", "true", "MONGODB_HOST to be set; put mongodb+srv:// in MONGODB_URI otherwise")
+	}
+	if opts.SRV && strings.ContainsAny(opts.Host, ":,") {
+		l.fail("MONGODB_HOST", opts.Host, "a single hostname without a port when MONGODB_SRV=true")
+	}
+
+	if opts.AuthMechanism != "" && !mongoAuthMechanisms[opts.AuthMechanism] {
+		l.fail("MONGODB_AUTH_MECHANISM", opts.AuthMechanism, "one of SCRAM-SHA-256, SCRAM-SHA-1, MONGODB-X509, MONGODB-AWS, GSSAPI or PLAIN")
+	}
+	if opts.AuthMechanism == "MONGODB-X509" && opts.TLSCertKeyFile == "" {
+		l.fail("MONGODB_TLS_CERT_KEY_FILE", "", "a client certificate when MONGODB_AUTH_MECHANISM=MONGODB-X509")
+	}
+	if opts.Password != "" && opts.Username == "" {
+		l.fail("MONGODB_USERNAME", "", "to be set when MONGODB_PASSWORD is set")
+	}
+
+	for key, file := range map[string]string{
+		"MONGODB_TLS_CA_FILE":       opts.TLSCAFile,
+		"MONGODB_TLS_CERT_KEY_FILE": opts.TLSCertKeyFile,
+	} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			l.fail(key, file, "a readable PEM file")
+		}
+	}
+
+	if opts.BreakerThreshold < 0 {
+		l.fail("MONGODB_BREAKER_THRESHOLD", strconv.Itoa(opts.BreakerThreshold), "a non-negative integer; 0 disables the breaker")
+	}
+	if opts.BreakerCooldown < time.Second {
+		l.fail("MONGODB_BREAKER_COOLDOWN", opts.BreakerCooldown.String(), "a duration of at least 1s")
+	}
+	if opts.BreakerSlowCall < 0 {
+		l.fail("MONGODB_BREAKER_SLOW_CALL", opts.BreakerSlowCall.String(), "a non-negative duration; 0 counts no call as slow")
+	}
+	if opts.SlowQueryThreshold < 0 {
+		l.fail("MONGODB_SLOW_QUERY_THRESHOLD", opts.SlowQueryThreshold.String(), "a non-negative duration; 0 logs no command")
+	}
+
+	if opts.MaxPoolSize < 0 {
+		l.fail("MONGODB_MAX_POOL_SIZE", strconv.Itoa(opts.MaxPoolSize), "a non-negative integer; 0 keeps the default of 100")
+	}
+	if opts.MinPoolSize < 0 {
+		l.fail("MONGODB_MIN_POOL_SIZE", strconv.Itoa(opts.MinPoolSize), "a non-negative integer")
+	}
+	if opts.MaxPoolSize > 0 && opts.MinPoolSize > opts.MaxPoolSize {
+		l.fail("MONGODB_MIN_POOL_SIZE", strconv.Itoa(opts.MinPoolSize), "at most MONGODB_MAX_POOL_SIZE")
+	}
+	for key, d := range map[string]time.Duration{
+		"MONGODB_MAX_CONN_IDLE_TIME":       opts.MaxConnIdleTime,
+		"MONGODB_CONNECT_TIMEOUT":          opts.ConnectTimeout,
+		"MONGODB_SERVER_SELECTION_TIMEOUT": opts.ServerSelectionTimeout,
+	} {
+		if d < 0 {
+			l.fail(key, d.String(), "a non-negative duration; 0 keeps the default")
+		}
+	}
+	if opts.PoolMaxWaiting < 0 {
+		l.fail("MONGODB_POOL_MAX_WAITING", strconv.Itoa(opts.PoolMaxWaiting), "a non-negative integer; 0 never refuses requests")
+	}
+
+	for _, c := range opts.Compressors {
+		if !mongoCompressors[c] {
+			l.fail("MONGODB_COMPRESSORS", c, "a comma-separated list of zstd, snappy and zlib")
+		}
+	}
+
+	if opts.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(opts.ReadPreference)
+		if err != nil {
+			l.fail("MONGODB_READ_PREFERENCE", opts.ReadPreference, "one of primary, primaryPreferred, secondary, secondaryPreferred or nearest")
+		}
+		if opts.ReadConcern == "linearizable" && err == nil && mode != readpref.PrimaryMode {
+			l.fail("MONGODB_READ_CONCERN", opts.ReadConcern, "a level other than linearizable unless MONGODB_READ_PREFERENCE=primary")
+		}
+	}
+	if opts.ReadConcern != "" && !mongoReadConcerns[opts.ReadConcern] {
+		l.fail("MONGODB_READ_CONCERN", opts.ReadConcern, "one of local, available, majority or linearizable")
+	}
+	// The server refuses a max staleness under 90s
+	if opts.MaxStaleness != 0 && opts.MaxStaleness < 90*time.Second {
+		l.fail("MONGODB_MAX_STALENESS", opts.MaxStaleness.String(), "a duration of at least 90s; 0 sets no bound")
+	}
+	if opts.MaxStaleness != 0 && (opts.ReadPreference == "" || strings.EqualFold(opts.ReadPreference, "primary")) {
+		l.fail("MONGODB_MAX_STALENESS", opts.MaxStaleness.String(), "to be unset unless MONGODB_READ_PREFERENCE reads from secondaries")
+	}
+
+	if len(opts.ShardKey) > 0 {
+		if _, err := repository.NewShardKey(opts.ShardKey...); err != nil {
+			l.fail("MONGODB_SHARD_KEY", strings.Join(opts.ShardKey, ","), "comma-separated order fields that never change: "+err.Error())
+		}
+		if region != "" {
+			l.fail("MONGODB_SHARD_KEY", strings.Join(opts.ShardKey, ","), "to be unset when REGION is set")
+		}
+	}
+}
+
+// replicaReads returns the collection options of the reads that may be
+// served by replicas, or nil when neither ReadPreference nor ReadConcern is
+// set
+func (o MongoOptions) replicaReads() (*options.CollectionOptions, error) {
+	if o.ReadPreference == "" && o.ReadConcern == "" {
+		return nil, nil
+	}
+	opts := options.Collection()
+	if o.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(o.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		var prefOpts []readpref.Option
+		if o.MaxStaleness > 0 {
+			prefOpts = append(prefOpts, readpref.WithMaxStaleness(o.MaxStaleness))
+		}
+		pref, err := readpref.New(mode, prefOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(pref)
+	}
+	if o.ReadConcern != "" {
+		opts.SetReadConcern(readconcern.New(readconcern.Level(o.ReadConcern)))
+	}
+	return opts, nil
+}
+
+// uri builds a connection string from Host when MONGODB_URI is not set.
+// Credentials are applied separately through ClientOptions.
+func (o MongoOptions) uri() string {
+	scheme := "mongodb"
+	if o.SRV {
+		scheme = "mongodb+srv"
+	}
+	return (&url.URL{Scheme: scheme, Host: o.Host, Path: "/"}).String()
+}
+
+// ClientOptions returns driver options for uri with the parameters applied.
+// Explicit parameters override the same settings in the connection string.
+func (o MongoOptions) ClientOptions(uri string) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(uri)
+
+	if o.Username != "" || o.AuthMechanism != "" {
+		cred := options.Credential{
+			AuthMechanism: o.AuthMechanism,
+			AuthSource:    o.AuthSource,
+			Username:      o.Username,
+			Password:      o.Password,
+			PasswordSet:   o.Password != "",
+		}
+		opts.SetAuth(cred)
+	}
+	if o.ReplicaSet != "" {
+		opts.SetReplicaSet(o.ReplicaSet)
+	}
+	if o.AppName != "" {
+		opts.SetAppName(o.AppName)
+	}
+	if o.RetryWrites != nil {
+		opts.SetRetryWrites(*o.RetryWrites)
+	}
+	if o.RetryReads != nil {
+		opts.SetRetryReads(*o.RetryReads)
+	}
+	if len(o.Compressors) > 0 {
+		opts.SetCompressors(o.Compressors)
+	}
+	if o.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(o.MaxPoolSize))
+	}
+	if o.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(o.MinPoolSize))
+	}
+	if o.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(o.MaxConnIdleTime)
+	}
+	if o.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(o.ConnectTimeout)
+	}
+	if o.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(o.ServerSelectionTimeout)
+	}
+	opts.SetMonitor(dbmonitor.New(o.SlowQueryThreshold))
+	opts.SetPoolMonitor(dbmonitor.NewPoolMonitor())
+
+	if o.TLS || o.TLSCAFile != "" || o.TLSCertKeyFile != "" || o.TLSInsecure {
+		tlsConfig, err := o.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, opts.Validate()
+}
+
+func (o MongoOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: o.TLSInsecure}
+
+	if o.TLSCAFile != "" {
+		pem, err := os.ReadFile(o.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read MONGODB_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("MONGODB_TLS_CA_FILE %s contains no PEM certificates", o.TLSCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.TLSCertKeyFile != "" {
+		// Same layout as the driver's tlsCertificateKeyFile: certificate and
+		// private key concatenated in one PEM file
+		cert, err := tls.LoadX509KeyPair(o.TLSCertKeyFile, o.TLSCertKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load MONGODB_TLS_CERT_KEY_FILE: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// ConnectMongo opens a client for uri with the configured connection parameters
+func ConnectMongo(ctx context.Context, uri string, opts MongoOptions) (*mongo.Client, error) {
+	clientOpts, err := opts.ClientOptions(uri)
+	if err != nil {
+		return nil, err
+	}
+	return mongo.Connect(ctx, clientOpts)
+}
@@ -0,0 +1,58 @@
+package app
+
+import (
+	"order-service/pkg/awssig"
+	"order-service/pkg/objectstore"
+)
+
+// ObjectStoreOptions configure the S3-compatible bucket cold storage keeps
+// orders in
+type ObjectStoreOptions struct {
+	Bucket string
+	Region string
+	// Endpoint overrides AWS's, e.g. https://storage.googleapis.com for
+	// Cloud Storage with HMAC keys or a MinIO server
+	Endpoint    string
+	Credentials awssig.Credentials
+}
+
+// loadObjectStoreOptions reads the OBJECT_STORE settings. The region and
+// credentials default to AWS_REGION and the AWS_ACCESS_KEY_ID family.
+func (l *configLoader) loadObjectStoreOptions() ObjectStoreOptions {
+	return ObjectStoreOptions{
+		Bucket:   l.env("OBJECT_STORE_BUCKET"),
+		Region:   l.envOr("OBJECT_STORE_REGION", l.env("AWS_REGION")),
+		Endpoint: l.env("OBJECT_STORE_ENDPOINT"),
+		Credentials: awssig.Credentials{
+			AccessKeyID:     l.envOr("OBJECT_STORE_ACCESS_KEY_ID", l.env("AWS_ACCESS_KEY_ID")),
+			SecretAccessKey: l.envOr("OBJECT_STORE_SECRET_ACCESS_KEY", l.env("AWS_SECRET_ACCESS_KEY")),
+			SessionToken:    l.envOr("OBJECT_STORE_SESSION_TOKEN", l.env("AWS_SESSION_TOKEN")),
+		},
+	}
+}
+
+// validateObjectStore checks the bucket has what signing requests to it
+// needs, once a bucket is set
+func (l *configLoader) validateObjectStore(opts ObjectStoreOptions) {
+	if opts.Bucket == "" {
+		return
+	}
+	if opts.Region == "" {
+		l.fail("OBJECT_STORE_REGION", "", "a region, or auto for Cloud Storage, with OBJECT_STORE_BUCKET")
+	}
+	if opts.Credentials.AccessKeyID == "" || opts.Credentials.SecretAccessKey == "" {
+		l.fail("OBJECT_STORE_ACCESS_KEY_ID", "", "an access key and secret, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, with OBJECT_STORE_BUCKET")
+	}
+	if opts.Endpoint != "" {
+		l.httpURL("OBJECT_STORE_ENDPOINT", opts.Endpoint)
+	}
+}
+
+// NewObjectStore returns the bucket of cfg.ObjectStore, or nil without one
+func NewObjectStore(cfg Config) *objectstore.S3 {
+	opts := cfg.ObjectStore
+	if opts.Bucket == "" {
+		return nil
+	}
+	return objectstore.NewS3(opts.Bucket, opts.Region, opts.Credentials, opts.Endpoint)
+}
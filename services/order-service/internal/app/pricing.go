@@ -0,0 +1,56 @@
+package app
+
+import (
+	"order-service/pkg/money"
+	"order-service/pkg/pricing"
+)
+
+// PricingOptions configure the tax and shipping charged on new orders
+type PricingOptions struct {
+	// TaxRate is in basis points of the subtotal
+	TaxRate int64
+	// ShippingFees and FreeShippingFrom are per currency, e.g.
+	// {"USD": 4.99 USD}
+	ShippingFees     map[string]money.Money
+	FreeShippingFrom map[string]money.Money
+}
+
+// loadPricingOptions reads TAX_RATE, SHIPPING_FEES and FREE_SHIPPING_FROM
+func (l *configLoader) loadPricingOptions() PricingOptions {
+	var opts PricingOptions
+	if rate := l.env("TAX_RATE"); rate != "" {
+		bps, err := pricing.ParseRate(rate)
+		if err != nil || bps > 10000 {
+			l.fail("TAX_RATE", rate, "a percentage between 0 and 100 with at most two decimals")
+		}
+		opts.TaxRate = bps
+	}
+	if spec := l.env("SHIPPING_FEES"); spec != "" {
+		fees, err := pricing.ParseAmounts(spec)
+		if err != nil {
+			l.fail("SHIPPING_FEES", spec, "a comma-separated list such as USD=4.99,EUR=4.50")
+		}
+		opts.ShippingFees = fees
+	}
+	if spec := l.env("FREE_SHIPPING_FROM"); spec != "" {
+		thresholds, err := pricing.ParseAmounts(spec)
+		if err != nil {
+			l.fail("FREE_SHIPPING_FROM", spec, "a comma-separated list such as USD=50,EUR=45")
+		}
+		opts.FreeShippingFrom = thresholds
+	}
+	return opts
+}
+
+// NewPricingCalculator returns the calculator for the configured charges;
+// with none configured orders cost exactly their items
+func NewPricingCalculator(opts PricingOptions) pricing.Calculator {
+	if opts.TaxRate == 0 && len(opts.ShippingFees) == 0 {
+		return pricing.None{}
+	}
+	return pricing.Standard{
+		TaxRate:          opts.TaxRate,
+		ShippingFees:     opts.ShippingFees,
+		FreeShippingFrom: opts.FreeShippingFrom,
+	}
+}
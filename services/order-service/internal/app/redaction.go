@@ -0,0 +1,72 @@
+package app
+
+import (
+	"regexp"
+	"strings"
+
+	"order-service/pkg/redact"
+)
+
+// logRedaction redacts everything the service logs, access log included;
+// SetupLogger starts it with the default rules
+var logRedaction *redact.Writer
+
+// RedactionOptions configure the masking of personal data and credentials
+// in logs
+type RedactionOptions struct {
+	// Disabled logs entries as they are written
+	Disabled bool
+	// Fields are JSON fields masked whole on top of redact.DefaultFields
+	Fields []string
+	// Pattern is a regular expression masked on top of
+	// redact.DefaultRules, e.g. a loyalty card number format
+	Pattern string
+}
+
+// loadRedactionOptions reads LOG_REDACT, LOG_REDACT_FIELDS and
+// LOG_REDACT_PATTERN
+func (l *configLoader) loadRedactionOptions() RedactionOptions {
+	opts := RedactionOptions{Pattern: l.env("LOG_REDACT_PATTERN")}
+	if enabled := l.optionalBoolVar("LOG_REDACT"); enabled != nil {
+		opts.Disabled = !*enabled
+	}
+	if fields := l.env("LOG_REDACT_FIELDS"); fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			opts.Fields = append(opts.Fields, strings.TrimSpace(field))
+		}
+	}
+	return opts
+}
+
+// validateRedaction checks the field names and that the pattern compiles
+func (l *configLoader) validateRedaction(opts RedactionOptions) {
+	for _, field := range opts.Fields {
+		if field == "" {
+			l.fail("LOG_REDACT_FIELDS", strings.Join(opts.Fields, ","), "comma-separated JSON field names")
+			break
+		}
+	}
+	if opts.Pattern != "" {
+		if _, err := regexp.Compile(opts.Pattern); err != nil {
+			l.fail("LOG_REDACT_PATTERN", opts.Pattern, "a Go regular expression; use | to match several")
+		}
+	}
+}
+
+// SetLogRedaction applies opts, which LoadConfig has validated, to the
+// logger SetupLogger configured
+func SetLogRedaction(opts RedactionOptions) {
+	if logRedaction == nil {
+		return
+	}
+	if opts.Disabled {
+		logRedaction.Set(nil)
+		return
+	}
+	fields := append(append([]string{}, redact.DefaultFields...), opts.Fields...)
+	rules := redact.DefaultRules
+	if opts.Pattern != "" {
+		rules = append(append([]redact.Rule{}, rules...), redact.Rule{Pattern: regexp.MustCompile(opts.Pattern), Replacement: redact.Mask})
+	}
+	logRedaction.Set(redact.New(fields, rules))
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RegionOptions configure active-active operation across regions. Without a
+// Region the service runs single-region.
+type RegionOptions struct {
+	// Region names the region this instance writes to, e.g. eu-west-1
+	Region string
+	// Peers maps every other region to the MongoDB URI holding its orders
+	Peers map[string]string
+	// PeerTimeout bounds each read from a peer region
+	PeerTimeout time.Duration
+	// ReconcileInterval is how often orders changed in peer regions are
+	// merged into the local database
+	ReconcileInterval time.Duration
+}
+
+// loadRegionOptions reads the REGION* settings
+func (l *configLoader) loadRegionOptions() RegionOptions {
+	opts := RegionOptions{
+		Region:            l.env("REGION"),
+		PeerTimeout:       l.durationVar("REGION_PEER_TIMEOUT", 2*time.Second),
+		ReconcileInterval: l.durationVar("REGION_RECONCILE_INTERVAL", time.Minute),
+	}
+	if spec := l.env("REGION_PEERS"); spec != "" {
+		opts.Peers = map[string]string{}
+		for _, entry := range strings.Split(spec, ",") {
+			name, uri, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok || name == "" {
+				l.fail("REGION_PEERS", redactURI(entry), "a comma-separated list such as us-east-1=mongodb://host:27017")
+				continue
+			}
+			opts.Peers[name] = uri
+		}
+	}
+	return opts
+}
+
+// validateRegion checks the multi-region settings. Regional storage keeps
+// one document per order, so it cannot be combined with event sourcing.
+func (l *configLoader) validateRegion(opts RegionOptions, storage string) {
+	if opts.Region == "" {
+		if len(opts.Peers) > 0 {
+			l.fail("REGION", "", "the local region name when REGION_PEERS is set")
+		}
+		return
+	}
+
+	if storage == "eventsourced" {
+		l.fail("ORDER_STORAGE", storage, "document when REGION is set")
+	}
+	for name, uri := range opts.Peers {
+		if name == opts.Region {
+			l.fail("REGION_PEERS", name, "regions other than REGION "+opts.Region)
+		}
+		l.mongoURI("REGION_PEERS", uri)
+	}
+	if opts.PeerTimeout < 100*time.Millisecond || opts.PeerTimeout > 30*time.Second {
+		l.fail("REGION_PEER_TIMEOUT", opts.PeerTimeout.String(), "a duration between 100ms and 30s")
+	}
+	if opts.ReconcileInterval < 10*time.Second || opts.ReconcileInterval > time.Hour {
+		l.fail("REGION_RECONCILE_INTERVAL", opts.ReconcileInterval.String(), "a duration between 10s and 1h")
+	}
+}
+
+// ConnectPeers connects to the database of every peer region
+func ConnectPeers(ctx context.Context, cfg Config) (map[string]*mongo.Client, error) {
+	peers := make(map[string]*mongo.Client, len(cfg.Region.Peers))
+	for name, uri := range cfg.Region.Peers {
+		client, err := ConnectMongo(ctx, uri, cfg.Mongo)
+		if err != nil {
+			for _, connected := range peers {
+				connected.Disconnect(ctx)
+			}
+			return nil, fmt.Errorf("connect to region %s: %w", name, err)
+		}
+		peers[name] = client
+	}
+	return peers, nil
+}
+
+// NewRegionalRepository returns the active-active repository writing to db
+// and reading from the same database in every peer region
+func NewRegionalRepository(ctx context.Context, cfg Config, db *mongo.Database, peers map[string]*mongo.Client, clk clock.Clock) *repository.RegionalRepository {
+	collections := make(map[string]*mongo.Collection, len(peers))
+	for name, client := range peers {
+		collections[name] = client.Database(cfg.Database).Collection("orders")
+	}
+
+	repo := repository.NewRegionalRepository(cfg.Region.Region, db.Collection("orders"), collections)
+	repo.PeerTimeout = cfg.Region.PeerTimeout
+	repo.Clock = clk
+
+	indexCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := repo.EnsureIndexes(indexCtx); err != nil {
+		log.Error().Err(err).Msg("Failed to create regional order indexes")
+	}
+	return repo
+}
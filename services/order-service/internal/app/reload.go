@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"order-service/internal/api"
+	"order-service/pkg/loglevel"
+
+	"github.com/rs/zerolog/log"
+)
+
+// reloadable are the Config fields a reload applies while running; changes
+// to any other field are logged and wait for a restart
+var reloadable = map[string]bool{"LogLevel": true, "Deadlines": true, "Redaction": true}
+
+// WatchConfig reloads the configuration on SIGHUP, and whenever
+// Config.ConfigFile changes when polling is enabled, until ctx is done. A
+// reload applies the log level, log redaction and h's request deadlines; a
+// configuration that fails validation is rejected and the current one
+// kept. SIGHUP also resets a log level override made through the admin API.
+func (a *App) WatchConfig(ctx context.Context, h *api.Handler) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	w := &configWatcher{current: a.Config, handler: h}
+	var poll <-chan time.Time
+	if a.Config.ConfigFile != "" && a.Config.ConfigReloadInterval > 0 {
+		w.modified, _ = w.stat()
+		ticker := time.NewTicker(a.Config.ConfigReloadInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			// SIGHUP also ends a log level override, should the admin API
+			// be out of reach
+			if loglevel.Current().Override {
+				status := loglevel.Reset()
+				log.Log().Str("log_level", status.Level).Msg("Log level override reset")
+			}
+			w.reload("SIGHUP")
+		case <-poll:
+			modified, ok := w.stat()
+			if ok && !modified.Equal(w.modified) {
+				w.modified = modified
+				w.reload("file changed")
+			}
+		}
+	}
+}
+
+// configWatcher holds the configuration in force
+type configWatcher struct {
+	current  Config
+	handler  *api.Handler
+	modified time.Time
+}
+
+// stat returns when the config file was last modified; a file being
+// replaced may briefly be missing
+func (w *configWatcher) stat() (time.Time, bool) {
+	info, err := os.Stat(w.current.ConfigFile)
+	if err != nil {
+		log.Warn().Err(err).Str("file", w.current.ConfigFile).Msg("Failed to check config file")
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
+// reload loads the configuration again and applies what changed
+func (w *configWatcher) reload(trigger string) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Error().Err(err).Str("trigger", trigger).Msg("Configuration reload rejected, keeping the current configuration")
+		return
+	}
+
+	if cfg.LogLevel != w.current.LogLevel {
+		SetLogLevel(cfg.LogLevel)
+		w.current.LogLevel = cfg.LogLevel
+		log.Log().Str("log_level", cfg.LogLevel).Msg("Log level changed")
+	}
+	if !reflect.DeepEqual(cfg.Redaction, w.current.Redaction) {
+		SetLogRedaction(cfg.Redaction)
+		w.current.Redaction = cfg.Redaction
+		log.Info().Bool("enabled", !cfg.Redaction.Disabled).Msg("Log redaction changed")
+	}
+	if !reflect.DeepEqual(cfg.Deadlines, w.current.Deadlines) {
+		w.handler.SetDeadlines(cfg.Deadlines)
+		w.current.Deadlines = cfg.Deadlines
+		log.Info().
+			Dur("read", cfg.Deadlines.Read).
+			Dur("write", cfg.Deadlines.Write).
+			Dur("bulk", cfg.Deadlines.Bulk).
+			Msg("Request deadlines changed")
+	}
+	if pending := restartRequired(w.current, cfg); len(pending) > 0 {
+		log.Warn().Strs("settings", pending).Msg("Changed settings take effect on restart")
+	}
+	log.Info().Str("trigger", trigger).Msg("Configuration reloaded")
+}
+
+// restartRequired lists the fields that differ between the configurations
+// and cannot be reloaded
+func restartRequired(current, next Config) []string {
+	var fields []string
+	cv, nv := reflect.ValueOf(current), reflect.ValueOf(next)
+	for i := 0; i < cv.NumField(); i++ {
+		name := cv.Type().Field(i).Name
+		if reloadable[name] {
+			continue
+		}
+		if !reflect.DeepEqual(cv.Field(i).Interface(), nv.Field(i).Interface()) {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/url"
+	"strings"
+
+	"order-service/pkg/search"
+)
+
+// SearchOptions configure the Elasticsearch index the projector keeps of
+// every order and admin listings are served from
+type SearchOptions struct {
+	// URL is the cluster, which may carry basic auth credentials; empty
+	// serves listings from MongoDB
+	URL    string
+	Index  string
+	APIKey string
+}
+
+// loadSearchOptions reads ELASTICSEARCH_URL, ELASTICSEARCH_INDEX and
+// ELASTICSEARCH_API_KEY
+func (l *configLoader) loadSearchOptions() SearchOptions {
+	return SearchOptions{
+		URL:    l.env("ELASTICSEARCH_URL"),
+		Index:  l.envOr("ELASTICSEARCH_INDEX", search.DefaultIndex),
+		APIKey: l.env("ELASTICSEARCH_API_KEY"),
+	}
+}
+
+// validateSearch checks the cluster URL and the index name against the
+// rules Elasticsearch puts on names
+func (l *configLoader) validateSearch(opts SearchOptions) {
+	if opts.URL == "" {
+		return
+	}
+	// The URL may carry credentials, which must not be reported
+	if u, err := url.Parse(opts.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		l.fail("ELASTICSEARCH_URL", redactURI(opts.URL), "an http:// or https:// base URL")
+	}
+	if opts.Index != strings.ToLower(opts.Index) || strings.ContainsAny(opts.Index, `\/*?"<>| ,#:`) ||
+		strings.HasPrefix(opts.Index, "_") || strings.HasPrefix(opts.Index, "-") || strings.HasPrefix(opts.Index, "+") {
+		l.fail("ELASTICSEARCH_INDEX", opts.Index, "a lowercase index name without spaces or any of \\/*?\"<>|,#:")
+	}
+}
+
+// NewSearchIndex returns the index of cfg.Search, or nil without a URL
+func NewSearchIndex(cfg Config) *search.Index {
+	if cfg.Search.URL == "" {
+		return nil
+	}
+	return search.NewIndex(cfg.Search.URL, cfg.Search.Index, cfg.Search.APIKey)
+}
@@ -0,0 +1,216 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/secrets"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SecretOptions select the secret manager the JWT secret and the MongoDB
+// URI are fetched from at runtime instead of JWT_SECRET and MONGODB_URI
+type SecretOptions struct {
+	// Provider is "vault", "aws" or "gcp"; empty reads every secret from
+	// the configuration
+	Provider string
+
+	VaultAddr  string
+	VaultToken string
+	VaultMount string
+
+	AWSRegion      string
+	AWSCredentials secrets.AWSCredentials
+	AWSEndpoint    string
+
+	GCPProject string
+
+	// JWTSecretRef and MongoURIRef reference the secrets in the provider,
+	// e.g. "order-service/jwt#secret" in Vault
+	JWTSecretRef string
+	MongoURIRef  string
+	// RefreshInterval is how often the secrets are fetched again to pick
+	// up rotations
+	RefreshInterval time.Duration
+	// JWTRotationGrace is how long tokens signed with the previous JWT
+	// secret keep verifying after a rotation
+	JWTRotationGrace time.Duration
+}
+
+// loadSecretOptions reads the SECRET_PROVIDER settings
+func (l *configLoader) loadSecretOptions() SecretOptions {
+	return SecretOptions{
+		Provider:   l.env("SECRET_PROVIDER"),
+		VaultAddr:  l.env("VAULT_ADDR"),
+		VaultToken: l.env("VAULT_TOKEN"),
+		VaultMount: l.envOr("VAULT_KV_MOUNT", "secret"),
+		AWSRegion:  l.env("AWS_REGION"),
+		AWSCredentials: secrets.AWSCredentials{
+			AccessKeyID:     l.env("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: l.env("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    l.env("AWS_SESSION_TOKEN"),
+		},
+		AWSEndpoint:      l.env("AWS_SECRETS_MANAGER_ENDPOINT"),
+		GCPProject:       l.env("GCP_PROJECT"),
+		JWTSecretRef:     l.env("JWT_SECRET_REF"),
+		MongoURIRef:      l.env("MONGODB_URI_REF"),
+		RefreshInterval:  l.durationVar("SECRET_REFRESH_INTERVAL", 5*time.Minute),
+		JWTRotationGrace: l.durationVar("JWT_SECRET_ROTATION_GRACE", 24*time.Hour),
+	}
+}
+
+// validateSecrets checks the provider has what it needs, and that secrets
+// fetched from it are not also configured directly
+func (l *configLoader) validateSecrets(opts SecretOptions, jwtSecretSet, mongoURISet bool) {
+	switch opts.Provider {
+	case "":
+		if opts.JWTSecretRef != "" {
+			l.fail("JWT_SECRET_REF", opts.JWTSecretRef, "SECRET_PROVIDER to be set")
+		}
+		if opts.MongoURIRef != "" {
+			l.fail("MONGODB_URI_REF", opts.MongoURIRef, "SECRET_PROVIDER to be set")
+		}
+		return
+	case "vault":
+		if opts.VaultAddr == "" {
+			l.fail("VAULT_ADDR", "", "the Vault server's address with SECRET_PROVIDER=vault")
+		} else {
+			l.httpURL("VAULT_ADDR", opts.VaultAddr)
+		}
+		if opts.VaultToken == "" {
+			l.fail("VAULT_TOKEN", "", "a token with SECRET_PROVIDER=vault")
+		}
+	case "aws":
+		if opts.AWSRegion == "" {
+			l.fail("AWS_REGION", "", "a region with SECRET_PROVIDER=aws")
+		}
+		if opts.AWSCredentials.AccessKeyID == "" || opts.AWSCredentials.SecretAccessKey == "" {
+			l.fail("AWS_ACCESS_KEY_ID", "", "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY with SECRET_PROVIDER=aws")
+		}
+		if opts.AWSEndpoint != "" {
+			l.httpURL("AWS_SECRETS_MANAGER_ENDPOINT", opts.AWSEndpoint)
+		}
+	case "gcp":
+		if opts.GCPProject == "" {
+			l.fail("GCP_PROJECT", "", "a project ID with SECRET_PROVIDER=gcp")
+		}
+	default:
+		l.fail("SECRET_PROVIDER", opts.Provider, "vault, aws or gcp")
+	}
+
+	if opts.JWTSecretRef == "" && opts.MongoURIRef == "" {
+		l.fail("SECRET_PROVIDER", opts.Provider, "JWT_SECRET_REF or MONGODB_URI_REF to reference a secret")
+	}
+	if opts.JWTSecretRef != "" && jwtSecretSet {
+		l.fail("JWT_SECRET", "<redacted>", "to be unset when JWT_SECRET_REF is set")
+	}
+	if opts.MongoURIRef != "" && mongoURISet {
+		l.fail("MONGODB_URI", "<redacted>", "MONGODB_URI and MONGODB_HOST to be unset when MONGODB_URI_REF is set")
+	}
+	if opts.RefreshInterval < 10*time.Second {
+		l.fail("SECRET_REFRESH_INTERVAL", opts.RefreshInterval.String(), "a duration of at least 10s")
+	}
+	if opts.JWTRotationGrace < 0 {
+		l.fail("JWT_SECRET_ROTATION_GRACE", opts.JWTRotationGrace.String(), "a non-negative duration")
+	}
+}
+
+// NewSecretProvider returns the secret manager opts select, or nil when
+// secrets are configured directly
+func NewSecretProvider(opts SecretOptions) secrets.Provider {
+	switch opts.Provider {
+	case "vault":
+		return secrets.NewVault(opts.VaultAddr, opts.VaultToken, opts.VaultMount)
+	case "aws":
+		return secrets.NewAWSSecretsManager(opts.AWSRegion, opts.AWSCredentials, opts.AWSEndpoint)
+	case "gcp":
+		return secrets.NewGCPSecretManager(opts.GCPProject)
+	default:
+		return nil
+	}
+}
+
+// Secrets are the secrets fetched from the secret manager; either may be
+// nil when configured directly
+type Secrets struct {
+	JWT      *secrets.Rotating
+	MongoURI *secrets.Rotating
+}
+
+// LoadSecrets fetches the secrets cfg references from its secret manager
+// into cfg, so that entrypoints connect with them like with configured
+// ones. The fetched values are validated as LoadConfig validates
+// configured ones.
+func LoadSecrets(ctx context.Context, cfg *Config, clk clock.Clock) (*Secrets, error) {
+	s := &Secrets{}
+	provider := NewSecretProvider(cfg.Secrets)
+	if provider == nil {
+		return s, nil
+	}
+	l := &configLoader{}
+
+	var err error
+	if ref := cfg.Secrets.JWTSecretRef; ref != "" {
+		if s.JWT, err = secrets.NewRotating(ctx, provider, ref, clk); err != nil {
+			return nil, err
+		}
+		s.JWT.Grace = cfg.Secrets.JWTRotationGrace
+		cfg.JWTSecret = []byte(s.JWT.Current())
+		if len(cfg.JWTSecret) < 32 && cfg.GinMode == "release" {
+			l.fail("JWT_SECRET_REF", ref, "a secret of at least 32 bytes when GIN_MODE=release")
+		}
+	}
+	if ref := cfg.Secrets.MongoURIRef; ref != "" {
+		if s.MongoURI, err = secrets.NewRotating(ctx, provider, ref, clk); err != nil {
+			return nil, err
+		}
+		// The read models follow the URI unless they have their own
+		if cfg.ReadModelURI == cfg.MongoURI {
+			cfg.ReadModelURI = s.MongoURI.Current()
+		}
+		cfg.MongoURI = s.MongoURI.Current()
+		l.mongoURI("MONGODB_URI_REF", cfg.MongoURI)
+		// Open connections keep working after a rotation, but the new URI
+		// is only used once the service restarts
+		s.MongoURI.OnChange = func(string) {
+			log.Warn().Str("secret", ref).Msg("MongoDB URI rotated; restart to connect with it, keeping the previous credentials valid until then")
+		}
+	}
+
+	if len(l.violations) > 0 {
+		return nil, &ConfigError{Violations: l.violations}
+	}
+	log.Info().Str("provider", cfg.Secrets.Provider).Msg("Secrets fetched from secret manager")
+	return s, nil
+}
+
+// Run fetches the secrets again every interval to pick up rotations,
+// until ctx is done
+func (s *Secrets) Run(ctx context.Context, interval time.Duration) {
+	if s == nil {
+		return
+	}
+	for _, secret := range []*secrets.Rotating{s.JWT, s.MongoURI} {
+		if secret != nil {
+			go secret.Run(ctx, interval)
+		}
+	}
+}
+
+// JWTSecrets returns the JWT secrets tokens may be signed with, or nil
+// when the secret is configured directly
+func (s *Secrets) JWTSecrets() func() [][]byte {
+	if s == nil || s.JWT == nil {
+		return nil
+	}
+	return func() [][]byte {
+		values := s.JWT.Values()
+		keys := make([][]byte, len(values))
+		for i, value := range values {
+			keys[i] = []byte(value)
+		}
+		return keys
+	}
+}
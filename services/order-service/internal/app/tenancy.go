@@ -0,0 +1,86 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/repository"
+	"order-service/pkg/tenant"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenancyOptions isolate the orders of SaaS customers. Callers whose token
+// carries a tenant_id claim only ever see their tenant's orders.
+type TenancyOptions struct {
+	// Required rejects API callers whose token has no tenant
+	Required bool
+	// Stores are the tenants kept out of the shared orders collection, in
+	// a database or collection of their own
+	Stores map[string]TenantStore
+}
+
+// TenantStore is where a tenant's orders are kept
+type TenantStore struct {
+	Database   string
+	Collection string
+}
+
+// loadTenancyOptions reads MULTI_TENANT and TENANT_STORES, a
+// comma-separated list of tenant=database or tenant=database/collection
+func (l *configLoader) loadTenancyOptions() TenancyOptions {
+	opts := TenancyOptions{Required: l.boolVar("MULTI_TENANT")}
+	if spec := l.env("TENANT_STORES"); spec != "" {
+		opts.Stores = map[string]TenantStore{}
+		for _, entry := range strings.Split(spec, ",") {
+			id, store, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			database, collection, _ := strings.Cut(store, "/")
+			if !ok || id == "" || database == "" {
+				l.fail("TENANT_STORES", entry, "a comma-separated list such as acme=acme_orders or globex=orders/globex_orders")
+				continue
+			}
+			if collection == "" {
+				collection = "orders"
+			}
+			opts.Stores[id] = TenantStore{Database: database, Collection: collection}
+		}
+	}
+	return opts
+}
+
+// validateTenancy checks the tenant stores. Regional storage replicates a
+// single collection, so it cannot be combined with them.
+func (l *configLoader) validateTenancy(opts TenancyOptions, region string) {
+	if len(opts.Stores) > 0 && region != "" {
+		l.fail("TENANT_STORES", "", "to be unset when REGION is set")
+	}
+	for id, store := range opts.Stores {
+		if !tenant.Valid(id) {
+			l.fail("TENANT_STORES", id, "tenant IDs of letters, digits, dashes and underscores")
+		}
+		if strings.ContainsAny(store.Database, `/\. "$`) || strings.ContainsAny(store.Collection, "$") {
+			l.fail("TENANT_STORES", store.Database+"/"+store.Collection, "valid MongoDB database and collection names")
+		}
+	}
+}
+
+// NewTenantRepository returns the orders of every tenant: shared for most,
+// and a repository of the storage cfg.OrderStorage selects for each tenant
+// store, whose indexes are created like those of the shared collection
+func NewTenantRepository(ctx context.Context, cfg Config, client *mongo.Client, shared repository.OrderRepository, clk clock.Clock) (*repository.TenantRepository, error) {
+	dedicated := make(map[string]repository.OrderRepository, len(cfg.Tenancy.Stores))
+	for id, store := range cfg.Tenancy.Stores {
+		db := client.Database(store.Database)
+		repo, err := newOrderRepository(ctx, cfg, db, store.Collection, clk)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", id, err)
+		}
+		ensureOrderIndexes(ctx, cfg, db.Collection(store.Collection))
+		dedicated[id] = repo
+		log.Info().Str("tenant", id).Str("database", store.Database).Str("collection", store.Collection).Msg("Tenant orders kept apart")
+	}
+	return repository.NewTenantRepository(shared, dedicated), nil
+}
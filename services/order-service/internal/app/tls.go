@@ -0,0 +1,100 @@
+package app
+
+import (
+	"crypto/tls"
+	"os"
+
+	"order-service/pkg/httpclient"
+	"order-service/pkg/mtls"
+)
+
+// TLSOptions configure TLS for the service's own listeners and for its
+// calls to other services, mutually authenticated with client
+// certificates when both sides are configured
+type TLSOptions struct {
+	// CertFile and KeyFile serve the HTTP and gRPC APIs over TLS; empty
+	// serves plain text
+	CertFile string
+	KeyFile  string
+	// ClientCAFile makes clients present a certificate signed by one of
+	// its CAs; with ClientAuth "optional" clients presenting none are let
+	// through, as health probes usually cannot present one
+	ClientCAFile string
+	ClientAuth   string
+
+	// OutboundCAFile is trusted besides the system's CAs when calling
+	// other services, which are presented OutboundCertFile and
+	// OutboundKeyFile when they ask for a client certificate
+	OutboundCAFile   string
+	OutboundCertFile string
+	OutboundKeyFile  string
+}
+
+// loadTLSOptions reads the TLS_ and OUTBOUND_TLS_ settings
+func (l *configLoader) loadTLSOptions() TLSOptions {
+	return TLSOptions{
+		CertFile:         l.env("TLS_CERT_FILE"),
+		KeyFile:          l.env("TLS_KEY_FILE"),
+		ClientCAFile:     l.env("TLS_CLIENT_CA_FILE"),
+		ClientAuth:       l.envOr("TLS_CLIENT_AUTH", "require"),
+		OutboundCAFile:   l.env("OUTBOUND_TLS_CA_FILE"),
+		OutboundCertFile: l.env("OUTBOUND_TLS_CERT_FILE"),
+		OutboundKeyFile:  l.env("OUTBOUND_TLS_KEY_FILE"),
+	}
+}
+
+// validateTLS checks certificates come with their keys and every file
+// can be read
+func (l *configLoader) validateTLS(opts TLSOptions) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		l.fail("TLS_CERT_FILE", opts.CertFile, "TLS_CERT_FILE and TLS_KEY_FILE to be set together")
+	}
+	if opts.ClientCAFile != "" && opts.CertFile == "" {
+		l.fail("TLS_CLIENT_CA_FILE", opts.ClientCAFile, "TLS_CERT_FILE and TLS_KEY_FILE to serve TLS")
+	}
+	if opts.ClientAuth != "require" && opts.ClientAuth != "optional" {
+		l.fail("TLS_CLIENT_AUTH", opts.ClientAuth, "require or optional")
+	}
+	if (opts.OutboundCertFile == "") != (opts.OutboundKeyFile == "") {
+		l.fail("OUTBOUND_TLS_CERT_FILE", opts.OutboundCertFile, "OUTBOUND_TLS_CERT_FILE and OUTBOUND_TLS_KEY_FILE to be set together")
+	}
+
+	for key, file := range map[string]string{
+		"TLS_CERT_FILE":          opts.CertFile,
+		"TLS_KEY_FILE":           opts.KeyFile,
+		"TLS_CLIENT_CA_FILE":     opts.ClientCAFile,
+		"OUTBOUND_TLS_CA_FILE":   opts.OutboundCAFile,
+		"OUTBOUND_TLS_CERT_FILE": opts.OutboundCertFile,
+		"OUTBOUND_TLS_KEY_FILE":  opts.OutboundKeyFile,
+	} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			l.fail(key, file, "a readable PEM file")
+		}
+	}
+}
+
+// ServerTLS returns the TLS configuration of the HTTP and gRPC listeners,
+// or nil to serve plain text
+func (o TLSOptions) ServerTLS() (*tls.Config, error) {
+	if o.CertFile == "" {
+		return nil, nil
+	}
+	return mtls.ServerConfig(o.CertFile, o.KeyFile, o.ClientCAFile, o.ClientAuth == "require")
+}
+
+// UseOutboundTLS makes the outbound HTTP clients trust OutboundCAFile and
+// present the client certificate; without either they keep the defaults
+func UseOutboundTLS(o TLSOptions) error {
+	if o.OutboundCAFile == "" && o.OutboundCertFile == "" {
+		return nil
+	}
+	cfg, err := mtls.ClientConfig(o.OutboundCAFile, o.OutboundCertFile, o.OutboundKeyFile)
+	if err != nil {
+		return err
+	}
+	httpclient.UseTLS(cfg)
+	return nil
+}
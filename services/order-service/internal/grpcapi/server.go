@@ -0,0 +1,340 @@
+// Package grpcapi serves the orders.v2 OrderService gRPC API for internal
+// callers, on top of the same business logic as the HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/internal/service"
+	"order-service/pkg/breaker"
+	"order-service/pkg/contracts"
+	"order-service/pkg/contracts/ordersv2"
+	"order-service/pkg/errreport"
+	"order-service/pkg/featureflags"
+	"order-service/pkg/idempotency"
+	"order-service/pkg/inventory"
+	"order-service/pkg/money"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+	"order-service/pkg/requestid"
+	"order-service/pkg/tenant"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// MetadataInternalToken carries the internal API token on every call
+const MetadataInternalToken = "x-internal-token"
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// OrderService is the business logic the server depends on; it is
+// implemented by *service.OrderService
+type OrderService interface {
+	CreateIdempotent(ctx context.Context, userID, key string, req contracts.CreateOrderRequest) (contracts.Order, bool, error)
+	Get(ctx context.Context, id primitive.ObjectID) (contracts.Order, error)
+	ListUserPage(ctx context.Context, userID string, q repository.PageQuery) (repository.Page, error)
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error)
+}
+
+// Server implements ordersv2.OrderServiceServer
+type Server struct {
+	ordersv2.UnimplementedOrderServiceServer
+	orders OrderService
+}
+
+// NewServer returns a server backed by the order service
+func NewServer(orders OrderService) *Server {
+	return &Server{orders: orders}
+}
+
+// readMethods are the calls bound by the read deadline; the others
+// change orders and are bound by the write deadline
+var readMethods = map[string]bool{
+	ordersv2.OrderService_GetOrder_FullMethodName:       true,
+	ordersv2.OrderService_ListUserOrders_FullMethodName: true,
+}
+
+// WithDeadlines bounds every call by the read or write deadline deadlines
+// returns at the time of the call, like the HTTP API's endpoints; callers
+// may still set an earlier one
+func WithDeadlines(deadlines func() (read, write time.Duration)) grpc.ServerOption {
+	return grpc.ChainUnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		read, write := deadlines()
+		timeout := write
+		if readMethods[info.FullMethod] {
+			timeout = read
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	})
+}
+
+// NewGRPCServer returns a gRPC server exposing orders to callers presenting
+// token; opts add server options such as TLS credentials or WithDeadlines
+func NewGRPCServer(token string, orders OrderService, reporter errreport.ErrorReporter, opts ...grpc.ServerOption) *grpc.Server {
+	if reporter == nil {
+		reporter = errreport.Nop{}
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(withRequestID, recoverPanics(reporter), logCalls, authenticate(token), withTenant))
+	srv := grpc.NewServer(opts...)
+	ordersv2.RegisterOrderServiceServer(srv, NewServer(orders))
+	return srv
+}
+
+// withRequestID accepts the caller's x-request-id metadata or generates an
+// ID, carries it in the context like the HTTP API and returns it in the
+// response header
+func withRequestID(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if values := md.Get(requestid.Header); len(values) == 1 {
+		id = values[0]
+	}
+	id = requestid.Accept(id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	return handler(requestid.NewContext(ctx, id), req)
+}
+
+// authenticate rejects calls without the internal API token
+func authenticate(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(MetadataInternalToken)
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(token)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid internal token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// withTenant serves the call for the tenant named in the caller's
+// x-tenant-id metadata, if any; the caller is trusted to name the tenant
+// it acts for, having presented the internal token
+func withTenant(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(tenant.Header)
+	if len(values) == 0 {
+		return handler(ctx, req)
+	}
+	if len(values) != 1 || !tenant.Valid(values[0]) {
+		return nil, status.Error(codes.InvalidArgument, "invalid tenant")
+	}
+	return handler(tenant.WithTenant(ctx, values[0]), req)
+}
+
+// logCalls logs every call with its outcome, like the HTTP access log
+func logCalls(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	log.Ctx(ctx).Info().
+		Str("method", info.FullMethod).
+		Str("code", status.Code(err).String()).
+		Dur("duration", time.Since(start)).
+		Msg("gRPC call")
+	return resp, err
+}
+
+// recoverPanics turns a panicking handler into an Internal error instead of
+// crashing the process. Panics, and the Internal, Unknown and DataLoss
+// errors handlers return, are reported to reporter with the last error
+// the call logged, like the HTTP API's 5xx responses.
+func recoverPanics(reporter errreport.ErrorReporter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		ctx, capture := errreport.WithCapture(ctx)
+		event := errreport.Event{Route: info.FullMethod, RequestID: requestid.FromContext(ctx)}
+		defer func() {
+			if p := recover(); p != nil {
+				event.Stack = errreport.Callers(1)
+				log.Ctx(ctx).Error().Interface("panic", p).Str("method", info.FullMethod).Msg("gRPC handler panicked")
+				event.Message = fmt.Sprintf("panic: %v", p)
+				event.Panic = true
+				event.Tags = map[string]string{"grpc_code": codes.Internal.String()}
+				reporter.Report(ctx, event)
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+		switch code := status.Code(err); code {
+		case codes.Internal, codes.Unknown, codes.DataLoss:
+			event.Message = err.Error()
+			if message, stack, ok := capture.Last(); ok {
+				event.Message = message
+				event.Stack = stack
+			}
+			event.Err = err
+			event.Tags = map[string]string{"grpc_code": code.String()}
+			reporter.Report(ctx, event)
+		}
+		return resp, err
+	}
+}
+
+// CreateOrder places an order on behalf of the request's user
+func (s *Server) CreateOrder(ctx context.Context, req *ordersv2.CreateOrderRequest) (*ordersv2.CreateOrderResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	if len(req.GetIdempotencyKey()) > idempotency.MaxKeyLength {
+		return nil, status.Errorf(codes.InvalidArgument, "idempotency_key must be at most %d characters", idempotency.MaxKeyLength)
+	}
+
+	items := make([]contracts.OrderItem, 0, len(req.GetItems()))
+	for _, item := range req.GetItems() {
+		items = append(items, contracts.OrderItemFromProto(item))
+	}
+	create := contracts.CreateOrderRequest{
+		Items:      items,
+		Currency:   req.GetCurrency(),
+		CouponCode: req.GetCouponCode(),
+	}
+
+	ctx = featureflags.WithSubject(ctx, featureflags.Subject{UserID: req.GetUserId(), TenantID: tenant.FromContext(ctx)})
+	order, replayed, err := s.orders.CreateIdempotent(ctx, req.GetUserId(), req.GetIdempotencyKey(), create)
+	if err != nil {
+		return nil, createError(ctx, err, order)
+	}
+	return &ordersv2.CreateOrderResponse{Order: order.ToProto(), Replayed: replayed}, nil
+}
+
+// GetOrder returns one order
+func (s *Server) GetOrder(ctx context.Context, req *ordersv2.GetOrderRequest) (*ordersv2.GetOrderResponse, error) {
+	id, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid order id")
+	}
+
+	order, err := s.orders.Get(repository.WithReplicaReads(ctx), id)
+	if err != nil {
+		return nil, orderError(ctx, err, "get order")
+	}
+	return &ordersv2.GetOrderResponse{Order: order.ToProto()}, nil
+}
+
+// ListUserOrders returns one page of a user's orders
+func (s *Server) ListUserOrders(ctx context.Context, req *ordersv2.ListUserOrdersRequest) (*ordersv2.ListUserOrdersResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+
+	q := repository.PageQuery{Limit: defaultPageLimit, Offset: int(req.GetOffset()), SortBy: repository.SortCreatedAt, Desc: true}
+	if req.GetLimit() != 0 {
+		q.Limit = int(req.GetLimit())
+	}
+	if q.Limit < 1 || q.Limit > maxPageLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit must be between 1 and %d", maxPageLimit)
+	}
+	if q.Offset < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset must not be negative")
+	}
+	if sort := req.GetSort(); sort != "" {
+		q.Desc = strings.HasPrefix(sort, "-")
+		q.SortBy = strings.TrimPrefix(sort, "-")
+		if q.SortBy != repository.SortCreatedAt && q.SortBy != repository.SortTotalAmount {
+			return nil, status.Error(codes.InvalidArgument, "sort must be created_at or total_amount, optionally prefixed with -")
+		}
+	}
+
+	page, err := s.orders.ListUserPage(repository.WithReplicaReads(ctx), req.GetUserId(), q)
+	if err != nil {
+		return nil, orderError(ctx, err, "list user orders")
+	}
+	resp := &ordersv2.ListUserOrdersResponse{
+		Orders: make([]*ordersv2.Order, 0, len(page.Orders)),
+		Total:  page.Total,
+	}
+	for _, order := range page.Orders {
+		resp.Orders = append(resp.Orders, order.ToProto())
+	}
+	return resp, nil
+}
+
+// UpdateStatus moves an order to the requested status
+func (s *Server) UpdateStatus(ctx context.Context, req *ordersv2.UpdateStatusRequest) (*ordersv2.UpdateStatusResponse, error) {
+	id, err := primitive.ObjectIDFromHex(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid order id")
+	}
+	if len(req.GetReason()) > 500 {
+		return nil, status.Error(codes.InvalidArgument, "reason must be at most 500 characters")
+	}
+
+	order, err := s.orders.UpdateStatus(ctx, id, contracts.StatusChange{
+		To:     req.GetStatus(),
+		Reason: req.GetReason(),
+		Actor:  req.GetActorId(),
+	})
+	if err != nil {
+		return nil, orderError(ctx, err, "update order status")
+	}
+	return &ordersv2.UpdateStatusResponse{Order: order.ToProto()}, nil
+}
+
+// createError maps an order creation failure to a status, like the HTTP
+// API maps it to a response
+func createError(ctx context.Context, err error, order contracts.Order) error {
+	var verr *contracts.ValidationError
+	var shortage *inventory.InsufficientStockError
+	switch {
+	case errors.As(err, &verr):
+		return status.Error(codes.InvalidArgument, verr.Error())
+	case errors.Is(err, money.ErrCurrencyMismatch):
+		return status.Error(codes.InvalidArgument, "all items must be priced in the same currency")
+	case errors.Is(err, idempotency.ErrKeyReused):
+		return status.Error(codes.InvalidArgument, "idempotency_key was already used for a different order")
+	case errors.Is(err, idempotency.ErrInProgress):
+		return status.Error(codes.Aborted, "an order with this idempotency_key is still being created")
+	case errors.Is(err, repository.ErrNotFound):
+		return status.Error(codes.FailedPrecondition, "the order created with this idempotency_key no longer exists")
+	case errors.As(err, &shortage):
+		return status.Error(codes.FailedPrecondition, "insufficient stock")
+	case errors.Is(err, payment.ErrDeclined):
+		return status.Errorf(codes.FailedPrecondition, "payment was declined for order %s", order.OrderID)
+	case errors.Is(err, service.ErrCatalogUnavailable),
+		errors.Is(err, service.ErrExchangeRatesUnavailable),
+		errors.Is(err, service.ErrInventoryUnavailable),
+		errors.Is(err, service.ErrPaymentUnavailable):
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to create order")
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return orderError(ctx, err, "create order")
+}
+
+// orderError maps the failures every call shares to a status
+func orderError(ctx context.Context, err error, action string) error {
+	var terr *contracts.TransitionError
+	switch {
+	case errors.As(err, &terr):
+		return status.Error(codes.FailedPrecondition, terr.Error())
+	case err == service.ErrInvalidStatus:
+		return status.Error(codes.InvalidArgument, "invalid status")
+	case err == service.ErrReturnStatus:
+		return status.Error(codes.InvalidArgument, "return statuses are set through the return endpoints")
+	case err == repository.ErrNotFound:
+		return status.Error(codes.NotFound, "order not found")
+	case err == repository.ErrConflict:
+		return status.Error(codes.Aborted, "order was modified concurrently, please retry")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "call cancelled")
+	case errors.Is(err, breaker.ErrOpen):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	log.Ctx(ctx).Error().Err(err).Msg("Failed to " + action)
+	return status.Error(codes.Internal, "failed to "+action)
+}
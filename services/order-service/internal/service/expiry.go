@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ExpiryReason is the cancellation reason recorded on expired orders
+const ExpiryReason = "Expired: still pending after the pending order TTL"
+
+// expiryBatch is how many stale orders are read at a time
+const expiryBatch = 100
+
+// ExpireOrders cancels every order still pending ttl after it was created,
+// such as an abandoned checkout, and returns how many it cancelled. Each
+// order is cancelled like Cancel, which releases its stock; payment
+// authorizations left by an interrupted placement are voided, and
+// order.expired is published after the status change. Orders that leave
+// pending meanwhile are skipped.
+func (s *OrderService) ExpireOrders(ctx context.Context, ttl time.Duration) (int, error) {
+	filter := repository.OrderFilter{
+		Statuses: []string{contracts.StatusPending},
+		To:       s.clock.Now().Add(-ttl),
+	}
+	q := repository.PageQuery{Limit: expiryBatch, SortBy: repository.SortCreatedAt}
+
+	expired := 0
+	for {
+		page, err := s.repo.FindPage(ctx, filter, q)
+		if err != nil {
+			return expired, err
+		}
+		cancelled := 0
+		for _, order := range page.Orders {
+			ok, err := s.expire(ctx, order)
+			if err != nil {
+				return expired, err
+			}
+			if ok {
+				cancelled++
+			}
+		}
+		expired += cancelled
+		// Orders skipped because they changed are read again next run
+		// rather than looped over now
+		if len(page.Orders) < expiryBatch || cancelled == 0 {
+			return expired, nil
+		}
+	}
+}
+
+// expire cancels one stale order, reporting false when it left pending or
+// changed since it was read
+func (s *OrderService) expire(ctx context.Context, order contracts.Order) (bool, error) {
+	cancelled, err := s.changeStatus(ctx, order.ID, contracts.StatusChange{
+		To:     contracts.StatusCancelled,
+		At:     s.clock.Now(),
+		Reason: ExpiryReason,
+		Base:   order.UpdatedAt,
+	})
+	switch {
+	case errors.Is(err, contracts.ErrInvalidTransition), errors.Is(err, repository.ErrConflict), errors.Is(err, repository.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	s.voidPayments(cancelled.OrderID)
+	s.publish(ctx, contracts.EventOrderExpired, cancelled, contracts.StatusPending)
+	log.Info().Str("order_id", cancelled.OrderID).Time("created_at", cancelled.CreatedAt).Msg("Expired pending order")
+	return true, nil
+}
+
+// voidPayments voids every authorization still held for an order. The
+// context is detached so the hold is released even if the caller is
+// stopping; a failure is only logged, leaving the authorization to lapse.
+func (s *OrderService) voidPayments(orderID string) {
+	if s.Payments == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), compensationTimeout)
+	defer cancel()
+
+	payments, err := s.Payments.ForOrder(ctx, orderID)
+	if err != nil {
+		log.Error().Err(err).Str("order_id", orderID).Msg("Failed to look up payments to void")
+		return
+	}
+	for _, p := range payments {
+		if p.Status != payment.StatusAuthorized {
+			continue
+		}
+		if err := s.Payments.Void(ctx, p.PaymentID); err != nil {
+			log.Error().Err(err).Str("order_id", orderID).Str("payment_id", p.PaymentID).Msg("Failed to void payment")
+		}
+	}
+}
+
+// RunExpiry expires orders pending longer than ttl every interval until
+// ctx is cancelled
+func (s *OrderService) RunExpiry(ctx context.Context, ttl, interval time.Duration) {
+	for {
+		expired, err := s.ExpireOrders(ctx, ttl)
+		if err != nil {
+			log.Error().Err(err).Int("expired", expired).Msg("Pending order expiry failed")
+		} else if expired > 0 {
+			log.Info().Int("expired", expired).Msg("Expired pending orders")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
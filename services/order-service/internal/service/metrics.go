@@ -0,0 +1,64 @@
+package service
+
+import (
+	"strconv"
+
+	"order-service/pkg/contracts"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ordersCreatedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_created_total",
+			Help: "Total number of orders created, by their status once placed",
+		},
+		[]string{"status"},
+	)
+	orderTotalAmount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "order_total_amount",
+			Help: "Totals of created orders in units of their currency; the sum is the revenue ordered",
+			// 1 to about 16k in the major unit of the currency
+			Buckets: prometheus.ExponentialBuckets(1, 2, 15),
+		},
+		[]string{"currency"},
+	)
+	ordersCancelledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_cancelled_total",
+			Help: "Total number of orders cancelled, by the status they were cancelled from and whether they expired",
+		},
+		[]string{"from", "expired"},
+	)
+	eventPublishFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "order_event_publish_failures_total",
+			Help: "Total number of order events that failed to be stored in the event store or published",
+		},
+		[]string{"event_type", "stage"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(ordersCreatedTotal)
+	prometheus.MustRegister(orderTotalAmount)
+	prometheus.MustRegister(ordersCancelledTotal)
+	prometheus.MustRegister(eventPublishFailuresTotal)
+}
+
+// observeEvent updates the business metrics with an event being published
+func observeEvent(event contracts.Event) {
+	switch event.Type {
+	case contracts.EventOrderCreated:
+		ordersCreatedTotal.WithLabelValues(event.Order.Status).Inc()
+		total := event.Order.TotalAmount
+		orderTotalAmount.WithLabelValues(total.Currency).Observe(total.Float64())
+	case contracts.EventOrderStatusChanged:
+		if event.Order.Status == contracts.StatusCancelled && event.PreviousStatus != contracts.StatusCancelled {
+			expired := event.Order.CancellationReason == ExpiryReason
+			ordersCancelledTotal.WithLabelValues(event.PreviousStatus, strconv.FormatBool(expired)).Inc()
+		}
+	}
+}
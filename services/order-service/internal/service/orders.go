@@ -0,0 +1,1094 @@
+// Package service holds the order business logic shared by every transport
+// (HTTP handlers, CLI and workers). It depends only on interfaces and stores
+// handed to its constructor.
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/pkg/audit"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/currency"
+	"order-service/pkg/events"
+	"order-service/pkg/featureflags"
+	"order-service/pkg/inventory"
+	"order-service/pkg/money"
+	"order-service/pkg/pricing"
+	"order-service/pkg/projection"
+	"order-service/pkg/promotion"
+	"order-service/pkg/repository"
+	"order-service/pkg/requestid"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrInvalidStatus is returned for a status outside the order lifecycle
+	ErrInvalidStatus = errors.New("invalid status")
+	// ErrCatalogUnavailable is returned when products cannot be looked up
+	ErrCatalogUnavailable = errors.New("product catalog unavailable")
+	// ErrExchangeRatesUnavailable is returned when an order needs a currency
+	// conversion and no exchange rates can be fetched
+	ErrExchangeRatesUnavailable = errors.New("exchange rates unavailable")
+	// ErrInventoryUnavailable is returned when stock cannot be reserved for
+	// reasons other than a shortage
+	ErrInventoryUnavailable = errors.New("inventory unavailable")
+)
+
+// OrderService implements order use cases on top of a repository, recording
+// and publishing an event for every state change
+type OrderService struct {
+	repo      repository.OrderRepository
+	store     events.Log
+	publisher events.Publisher
+	clock     clock.Clock
+
+	// Limits bounds the size of new orders
+	Limits contracts.Limits
+	// Catalog supplies the authoritative name, SKU and unit price of every
+	// item on a new order. When nil, the client's values are trusted, which
+	// is only acceptable in tests and tooling.
+	Catalog projection.Catalog
+	// LegacyClientPrices keeps the unit price a client sends instead of the
+	// catalog's, for clients still being migrated. Products are still
+	// looked up and every overridden price is logged.
+	LegacyClientPrices bool
+	// Pricing computes tax, shipping and discounts whenever an order's items
+	// are set; when nil, an order costs exactly its items
+	Pricing pricing.Calculator
+	// Rates converts item prices to an order's currency and totals to
+	// SettlementCurrency. When nil, every item must already be priced in
+	// the order's currency and totals are not normalized.
+	Rates ExchangeRateProvider
+	// SettlementCurrency is the currency every order's total is normalized
+	// to; empty disables normalization
+	SettlementCurrency string
+	// Promotions looks up and redeems coupon codes; when nil, orders with a
+	// coupon code are rejected
+	Promotions PromotionStore
+	// Inventory reserves stock for the items of every order placed or
+	// edited and releases it when the order is cancelled or deleted; when
+	// nil, stock is not tracked
+	Inventory Inventory
+	// Payments authorizes the total of every order placed before it is
+	// confirmed; when nil, orders are left pending
+	Payments PaymentGateway
+	// Sagas records the progress of each placement so one interrupted by
+	// a crash is finished or undone by ResumeSagas. When nil, orders are
+	// placed without a saga, which is only safe without Inventory and
+	// Payments.
+	Sagas SagaStore
+	// Idempotency remembers the order created for each idempotency key;
+	// when nil, keys are ignored
+	Idempotency IdempotencyStore
+	// Addresses verifies the shipping address of every new order; when
+	// nil, addresses are only checked for their format
+	Addresses AddressVerifier
+	// DetachedTimeout bounds the work finishing a request that runs
+	// detached from it, so it completes even if the client has gone away:
+	// persisting and publishing events, settling idempotency keys and
+	// giving back stock and promotion uses
+	DetachedTimeout time.Duration
+	// Audit records status updates, cancellations, priority changes and
+	// deletions; nil records nothing
+	Audit *audit.Log
+	// Flags roll out server pricing over LegacyClientPrices and the
+	// placement saga per user; nil leaves both at their defaults
+	Flags *featureflags.Flags
+	// Transactions stores status changes and shipments in one transaction
+	// with the events recording them, so neither is written without the
+	// other. When nil, or without transactions, the events are stored
+	// after the change and failures to store them are only logged.
+	Transactions Transactor
+	// ChangeStreamEvents leaves storing and publishing the events of order
+	// changes to a cdc.Watcher of the orders collection's change stream;
+	// the service still counts them in its metrics
+	ChangeStreamEvents bool
+}
+
+// DefaultDetachedTimeout is the DetachedTimeout of new services
+const DefaultDetachedTimeout = 5 * time.Second
+
+// Transactor runs groups of writes atomically where the database allows,
+// which Atomic reports; it is implemented by *repository.Transactions
+type Transactor interface {
+	Atomic(ctx context.Context) bool
+	Run(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// AddressVerifier checks that an address is deliverable and standardizes
+// it; it is implemented by *address.Client. Addresses it rejects fail with
+// a *contracts.ValidationError on the address fields.
+type AddressVerifier interface {
+	Verify(ctx context.Context, a contracts.Address) (contracts.Address, error)
+}
+
+// IdempotencyStore remembers which order each idempotency key created; it
+// is implemented by *idempotency.Store
+type IdempotencyStore interface {
+	Reserve(ctx context.Context, userID, key, fingerprint string) (primitive.ObjectID, error)
+	Complete(ctx context.Context, userID, key string, orderID primitive.ObjectID) error
+	Release(ctx context.Context, userID, key string) error
+}
+
+// PromotionStore looks up promotion codes and counts their uses; it is
+// implemented by *promotion.Store
+type PromotionStore interface {
+	Find(ctx context.Context, code string) (promotion.Promotion, error)
+	Redeem(ctx context.Context, code string) error
+	Release(ctx context.Context, code string) error
+}
+
+// Inventory holds stock for the items of orders; it is implemented by
+// *inventory.Client. Reserve states an order's full needs, replacing what
+// was held for it before, and fails with *inventory.InsufficientStockError
+// when any item is short.
+type Inventory interface {
+	Reserve(ctx context.Context, orderID string, items []contracts.OrderItem) error
+	Release(ctx context.Context, orderID string) error
+}
+
+// ExchangeRateProvider converts amounts between currencies; it is
+// implemented by *currency.Converter, which caches the rates it fetches
+type ExchangeRateProvider interface {
+	Convert(ctx context.Context, amount money.Money, to string) (money.Money, error)
+}
+
+// NewOrderService returns a service persisting through repo. A nil store
+// skips event persistence, a nil publisher falls back to logging and a nil
+// clock uses the system clock.
+func NewOrderService(repo repository.OrderRepository, store events.Log, publisher events.Publisher, clk clock.Clock) *OrderService {
+	if publisher == nil {
+		publisher = events.LogPublisher{}
+	}
+	if clk == nil {
+		clk = clock.System{}
+	}
+	return &OrderService{
+		repo:            repo,
+		store:           store,
+		publisher:       publisher,
+		clock:           clk,
+		Limits:          contracts.DefaultLimits,
+		DetachedTimeout: DefaultDetachedTimeout,
+	}
+}
+
+// Create places a new pending order for userID. Invalid items and coupon
+// codes are rejected with a *contracts.ValidationError. Item names, SKUs
+// and prices are snapshotted from the catalog so later catalog edits never
+// change the order. With Sagas, unless the PlacementSaga flag is off for
+// the caller, the order is placed through a placement saga and returned
+// confirmed once its payment is authorized.
+func (s *OrderService) Create(ctx context.Context, userID string, req contracts.CreateOrderRequest) (contracts.Order, error) {
+	order, err := s.newOrder(ctx, userID, req)
+	if err != nil {
+		return order, err
+	}
+	if s.placeWithSaga(ctx) {
+		return s.place(ctx, order)
+	}
+
+	if err := s.redeem(ctx, order); err != nil {
+		return order, err
+	}
+	if err := s.reserve(ctx, order.OrderID, order.Items); err != nil {
+		s.release(ctx, order)
+		return order, err
+	}
+	if err := s.repo.Create(ctx, &order); err != nil {
+		s.releaseStock(ctx, order.OrderID)
+		s.release(ctx, order)
+		return order, err
+	}
+
+	s.publish(ctx, contracts.EventOrderCreated, order, "")
+	return order, nil
+}
+
+// BulkResult is the outcome of one order of a bulk request: the order
+// created, or why it was not
+type BulkResult struct {
+	Order contracts.Order
+	Err   error
+}
+
+// CreateBulk places each order of reqs for userID as Create would and
+// returns their outcomes in the same order. Every order is validated and
+// priced first; those that pass are stored together, so one bad order does
+// not fail the rest. With Sagas, each valid order is placed through its own
+// saga instead. A request with no orders or more than Limits.MaxBulkOrders
+// fails as a whole with a *contracts.ValidationError.
+func (s *OrderService) CreateBulk(ctx context.Context, userID string, reqs []contracts.CreateOrderRequest) ([]BulkResult, error) {
+	switch {
+	case len(reqs) == 0:
+		return nil, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "orders", Message: "must contain at least one order"},
+		}}
+	case s.Limits.MaxBulkOrders > 0 && len(reqs) > s.Limits.MaxBulkOrders:
+		return nil, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "orders", Message: fmt.Sprintf("must contain at most %d orders", s.Limits.MaxBulkOrders)},
+		}}
+	}
+
+	results := make([]BulkResult, len(reqs))
+	var pending []*contracts.Order
+	var positions []int
+	for i, req := range reqs {
+		results[i].Order, results[i].Err = s.newOrder(ctx, userID, req)
+		if results[i].Err == nil && s.placeWithSaga(ctx) {
+			results[i].Order, results[i].Err = s.place(ctx, results[i].Order)
+			continue
+		}
+		if results[i].Err == nil {
+			results[i].Err = s.redeem(ctx, results[i].Order)
+		}
+		if results[i].Err == nil {
+			if results[i].Err = s.reserve(ctx, results[i].Order.OrderID, results[i].Order.Items); results[i].Err != nil {
+				s.release(ctx, results[i].Order)
+			}
+		}
+		if results[i].Err == nil {
+			pending = append(pending, &results[i].Order)
+			positions = append(positions, i)
+		}
+	}
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	for n, err := range s.repo.CreateMany(ctx, pending) {
+		if err != nil {
+			s.releaseStock(ctx, pending[n].OrderID)
+			s.release(ctx, *pending[n])
+			results[positions[n]].Err = err
+			continue
+		}
+		s.publish(ctx, contracts.EventOrderCreated, *pending[n], "")
+	}
+	return results, nil
+}
+
+// newOrder builds a pending order from the submitted items, validated and
+// priced from the catalog in the requested currency
+func (s *OrderService) newOrder(ctx context.Context, userID string, req contracts.CreateOrderRequest) (contracts.Order, error) {
+	if err := contracts.ValidateItems(req.Items, s.Limits); err != nil {
+		return contracts.Order{}, err
+	}
+	priority := req.Priority
+	if priority == "" {
+		priority = contracts.PriorityStandard
+	}
+	if !contracts.IsPriority(priority) {
+		return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "priority", Message: "must be standard, expedited or rush"},
+		}}
+	}
+	giftMessage := strings.TrimSpace(req.GiftMessage)
+	if giftMessage != "" && !req.IsGift {
+		return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "gift_message", Message: "is only allowed on gift orders"},
+		}}
+	}
+	var address *contracts.Address
+	if req.ShippingAddress != nil {
+		verified, err := s.shippingAddress(ctx, *req.ShippingAddress)
+		if err != nil {
+			return contracts.Order{}, err
+		}
+		address = &verified
+	}
+	code := req.Currency
+	if code != "" {
+		var err error
+		if code, err = money.NormalizeCurrency(code); err != nil {
+			return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+				{Field: "currency", Message: "must be a three-letter ISO 4217 code"},
+			}}
+		}
+	}
+
+	items, err := s.snapshotItems(ctx, "items", req.Items)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	if items, err = s.itemsIn(ctx, "items", code, items); err != nil {
+		return contracts.Order{}, err
+	}
+
+	now := s.clock.Now()
+	order := contracts.Order{
+		OrderID:         uuid.New().String(),
+		UserID:          userID,
+		Items:           items,
+		Status:          contracts.StatusPending,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		ShippingAddress: address,
+		GuestEmail:      req.GuestEmail,
+		IsGift:          req.IsGift,
+		GiftMessage:     giftMessage,
+		Priority:        priority,
+		PriorityRank:    contracts.PriorityRank(priority),
+	}
+	if text := strings.TrimSpace(req.Notes); text != "" {
+		order.Notes = []contracts.Note{{Text: text, AuthorID: userID, At: now}}
+	}
+	if req.CouponCode != "" {
+		if order.Promotion, err = s.promotion(ctx, req.CouponCode, items); err != nil {
+			return contracts.Order{}, err
+		}
+	}
+	if err := s.price(ctx, &order); err != nil {
+		return contracts.Order{}, err
+	}
+	return order, nil
+}
+
+// shippingAddress normalizes a submitted shipping address, checks its
+// format and has Addresses verify it. Verification is advisory: when the
+// provider cannot be reached the address is kept as entered, since its
+// format was already checked.
+func (s *OrderService) shippingAddress(ctx context.Context, a contracts.Address) (contracts.Address, error) {
+	const field = "shipping_address"
+	a = a.Normalize()
+	if err := contracts.ValidateAddress(field, a); err != nil {
+		return a, err
+	}
+	if s.Addresses == nil {
+		return a, nil
+	}
+
+	verified, err := s.Addresses.Verify(ctx, a)
+	var verr *contracts.ValidationError
+	switch {
+	case errors.As(err, &verr):
+		for i, f := range verr.Fields {
+			verr.Fields[i].Field = field
+			if f.Field != "" {
+				verr.Fields[i].Field += "." + f.Field
+			}
+		}
+		return a, verr
+	case err != nil:
+		log.Ctx(ctx).Warn().Err(err).Msg("Address validation unavailable, keeping the shipping address as entered")
+		return a, nil
+	}
+	verified = verified.Normalize()
+	if err := contracts.ValidateAddress(field, verified); err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("Address validation returned an invalid address, keeping the shipping address as entered")
+		return a, nil
+	}
+	return verified, nil
+}
+
+// price sets the order's subtotal from its items and the discount of its
+// promotion, asks the calculator for the charges on it and sets the grand
+// total
+func (s *OrderService) price(ctx context.Context, order *contracts.Order) error {
+	subtotal, err := orderTotal(order.Items)
+	if err != nil {
+		return err
+	}
+	order.Subtotal = subtotal
+	order.DiscountAmount = money.Zero(subtotal.Currency)
+	if order.Promotion != nil {
+		order.DiscountAmount = order.Promotion.Discount(subtotal)
+	}
+
+	charges := contracts.NoCharges(subtotal.Currency)
+	if s.Pricing != nil {
+		if charges, err = s.Pricing.Charges(ctx, *order); err != nil {
+			return fmt.Errorf("pricing order: %w", err)
+		}
+	}
+	charges.Discount = order.DiscountAmount
+	if err := order.SetTotals(subtotal, charges); err != nil {
+		return err
+	}
+	return s.settle(ctx, order)
+}
+
+// promotion looks up the coupon code for an order of items and checks it
+// applies. Codes that do not are validation errors on coupon_code.
+func (s *OrderService) promotion(ctx context.Context, code string, items []contracts.OrderItem) (*contracts.AppliedPromotion, error) {
+	if s.Promotions == nil {
+		return nil, couponError(promotion.ErrNotFound, promotion.Promotion{}, "")
+	}
+	subtotal, err := orderTotal(items)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := s.Promotions.Find(ctx, code)
+	if err == nil {
+		err = p.Check(subtotal, s.clock.Now())
+	}
+	if err != nil {
+		return nil, couponError(err, p, subtotal.Currency)
+	}
+	applied := p.Applied()
+	return &applied, nil
+}
+
+// redeem counts a use of the order's promotion code, if it has one
+func (s *OrderService) redeem(ctx context.Context, order contracts.Order) error {
+	if order.Promotion == nil {
+		return nil
+	}
+	if err := s.Promotions.Redeem(ctx, order.Promotion.Code); err != nil {
+		return couponError(err, promotion.Promotion{}, "")
+	}
+	return nil
+}
+
+// release gives back the use of the promotion code of an order that could
+// not be stored. The context is detached so the use is returned even if
+// the client has gone away.
+func (s *OrderService) release(ctx context.Context, order contracts.Order) {
+	if order.Promotion == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), s.DetachedTimeout)
+	defer cancel()
+	if err := s.Promotions.Release(ctx, order.Promotion.Code); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("code", order.Promotion.Code).Msg("Failed to release promotion use")
+	}
+}
+
+// reserve holds stock for the items of an order. Shortages are returned
+// as *inventory.InsufficientStockError; any other failure wraps
+// ErrInventoryUnavailable.
+func (s *OrderService) reserve(ctx context.Context, orderID string, items []contracts.OrderItem) error {
+	if s.Inventory == nil {
+		return nil
+	}
+	err := s.Inventory.Reserve(ctx, orderID, items)
+	var shortage *inventory.InsufficientStockError
+	if err == nil || errors.As(err, &shortage) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrInventoryUnavailable, err)
+}
+
+// releaseStock returns the stock held for an order. The context is
+// detached so the stock is returned even if the client has gone away; a
+// failure is only logged, leaving the reservation for an operator.
+func (s *OrderService) releaseStock(ctx context.Context, orderID string) {
+	if s.Inventory == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), s.DetachedTimeout)
+	defer cancel()
+	if err := s.Inventory.Release(ctx, orderID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", orderID).Msg("Failed to release reserved stock")
+	}
+}
+
+// restoreStock reserves the stock of an order's stored items again after
+// an edit that reserved for other items could not be stored
+func (s *OrderService) restoreStock(ctx context.Context, order contracts.Order) {
+	if s.Inventory == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), s.DetachedTimeout)
+	defer cancel()
+	if err := s.Inventory.Reserve(ctx, order.OrderID, order.Items); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Msg("Failed to restore stock reservation")
+	}
+}
+
+// couponError turns a promotion lookup failure into a validation error on
+// coupon_code; other errors are returned unchanged
+func couponError(err error, p promotion.Promotion, currency string) error {
+	var message string
+	switch {
+	case errors.Is(err, promotion.ErrNotFound):
+		message = "does not exist"
+	case errors.Is(err, promotion.ErrNotStarted):
+		message = "is not active yet"
+	case errors.Is(err, promotion.ErrExpired):
+		message = "has expired"
+	case errors.Is(err, promotion.ErrExhausted):
+		message = "has reached its usage limit"
+	case errors.Is(err, promotion.ErrBelowMinimum):
+		message = fmt.Sprintf("requires a subtotal of at least %s", p.MinSubtotal)
+	case errors.Is(err, promotion.ErrCurrency):
+		message = fmt.Sprintf("does not apply to orders in %s", currency)
+	default:
+		return err
+	}
+	return &contracts.ValidationError{Fields: []contracts.FieldError{{Field: "coupon_code", Message: message}}}
+}
+
+// settle normalizes the order's total to the settlement currency at the
+// current rate
+func (s *OrderService) settle(ctx context.Context, order *contracts.Order) error {
+	order.SettlementTotal = nil
+	if s.SettlementCurrency == "" || s.Rates == nil {
+		return nil
+	}
+
+	total, err := s.Rates.Convert(ctx, order.TotalAmount, s.SettlementCurrency)
+	if errors.Is(err, currency.ErrUnsupportedCurrency) {
+		return &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "currency", Message: fmt.Sprintf("%s cannot be settled in %s", order.TotalAmount.Currency, s.SettlementCurrency)},
+		}}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrExchangeRatesUnavailable, err)
+	}
+	order.SettlementTotal = &total
+	return nil
+}
+
+// itemsIn prices every item in the order currency code, or the first
+// item's currency when code is empty. Prices in another currency are
+// converted with Rates, or rejected as validation errors without it.
+func (s *OrderService) itemsIn(ctx context.Context, field, code string, items []contracts.OrderItem) ([]contracts.OrderItem, error) {
+	if code == "" && len(items) > 0 {
+		code = items[0].Price.Currency
+	}
+	if s.Rates == nil {
+		return items, contracts.ValidateItemCurrencies(field, items, code)
+	}
+
+	verr := &contracts.ValidationError{}
+	converted := make([]contracts.OrderItem, len(items))
+	for i, item := range items {
+		if item.Price.Currency != code {
+			price, err := s.Rates.Convert(ctx, item.Price, code)
+			if errors.Is(err, currency.ErrUnsupportedCurrency) {
+				verr.Fields = append(verr.Fields, contracts.FieldError{
+					Field:   fmt.Sprintf("%s[%d].price.currency", field, i),
+					Message: fmt.Sprintf("cannot be converted from %s to %s", item.Price.Currency, code),
+				})
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrExchangeRatesUnavailable, err)
+			}
+			item.Price = price
+		}
+		converted[i] = item
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return converted, nil
+}
+
+// CreateIdempotent is Create for a request carrying the client's
+// idempotency key. Retrying the same request with the same key returns the
+// order the first attempt created, with replayed set, instead of placing a
+// second one. Reusing a key for different items fails with
+// idempotency.ErrKeyReused, and a retry racing the first attempt with
+// idempotency.ErrInProgress. An empty key is a plain Create.
+func (s *OrderService) CreateIdempotent(ctx context.Context, userID, key string, req contracts.CreateOrderRequest) (order contracts.Order, replayed bool, err error) {
+	if key == "" || s.Idempotency == nil {
+		order, err = s.Create(ctx, userID, req)
+		return order, false, err
+	}
+
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return order, false, err
+	}
+	orderID, err := s.Idempotency.Reserve(ctx, userID, key, fingerprint)
+	if err != nil {
+		return order, false, err
+	}
+	if !orderID.IsZero() {
+		order, err = s.repo.FindByID(ctx, orderID)
+		return order, err == nil, err
+	}
+
+	// The key is settled even if the client has gone away: a retry must see
+	// the order this attempt created, or be free to create it itself
+	settleCtx, cancel := context.WithTimeout(requestid.Detach(ctx), s.DetachedTimeout)
+	defer cancel()
+
+	order, err = s.Create(ctx, userID, req)
+	if err != nil {
+		if rerr := s.Idempotency.Release(settleCtx, userID, key); rerr != nil {
+			log.Ctx(ctx).Error().Err(rerr).Str("user_id", userID).Msg("Failed to release idempotency key")
+		}
+		return order, false, err
+	}
+	if err := s.Idempotency.Complete(settleCtx, userID, key, order.ID); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Msg("Failed to record idempotency key")
+	}
+	return order, false, nil
+}
+
+// requestFingerprint identifies the items, currency, coupon code, shipping
+// address, notes, gift options and priority a client sent, before catalog
+// snapshotting, so a reused key can be told apart from a retry. Requests
+// with only items hash their items alone, as they did before orders could
+// carry anything else.
+func requestFingerprint(req contracts.CreateOrderRequest) (string, error) {
+	var data []byte
+	var err error
+	if req.Currency == "" && req.CouponCode == "" && req.ShippingAddress == nil && req.Notes == "" &&
+		!req.IsGift && req.GiftMessage == "" && req.Priority == "" {
+		data, err = json.Marshal(req.Items)
+	} else {
+		data, err = json.Marshal(req)
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// snapshotItems replaces whatever name, SKU and price the client sent with
+// the catalog's current values. Unknown and inactive products are
+// validation errors on the request field holding the items.
+func (s *OrderService) snapshotItems(ctx context.Context, field string, items []contracts.OrderItem) ([]contracts.OrderItem, error) {
+	if s.Catalog == nil {
+		return items, contracts.ValidateItemDetails(items)
+	}
+
+	// Server pricing is rolled out to the users still sending prices
+	legacyPrices := s.LegacyClientPrices && !s.Flags.Enabled(ctx, featureflags.ServerPricing)
+
+	verr := &contracts.ValidationError{}
+	snapshot := make([]contracts.OrderItem, len(items))
+	for i, item := range items {
+		product, err := s.Catalog.Product(ctx, item.ProductID)
+		if errors.Is(err, projection.ErrProductNotFound) {
+			verr.Fields = append(verr.Fields, contracts.FieldError{Field: fmt.Sprintf("%s[%d].product_id", field, i), Message: "does not exist"})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCatalogUnavailable, err)
+		}
+		if !product.Purchasable() {
+			verr.Fields = append(verr.Fields, contracts.FieldError{Field: fmt.Sprintf("%s[%d].product_id", field, i), Message: "is not available for purchase"})
+			continue
+		}
+
+		price, err := product.UnitPrice()
+		if err != nil {
+			return nil, fmt.Errorf("%w: product %s: %v", ErrCatalogUnavailable, item.ProductID, err)
+		}
+		if legacyPrices && item.Price.Amount > 0 && item.Price != price {
+			log.Ctx(ctx).Warn().Str("product_id", item.ProductID).Stringer("client_price", item.Price).Stringer("catalog_price", price).
+				Msg("Using client-supplied price")
+			price = item.Price
+		}
+		snapshot[i] = contracts.OrderItem{
+			ProductID: item.ProductID,
+			Name:      product.Name,
+			SKU:       product.SKU,
+			Price:     price,
+			Quantity:  item.Quantity,
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+	return snapshot, nil
+}
+
+// orderTotal sums the line items; every item must be priced in the same currency
+func orderTotal(items []contracts.OrderItem) (money.Money, error) {
+	currency := money.DefaultCurrency
+	if len(items) > 0 {
+		currency = items[0].Price.Currency
+	}
+
+	lines := make([]money.Money, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, item.Price.Mul(int64(item.Quantity)))
+	}
+	return money.Sum(currency, lines...)
+}
+
+// Get returns a single order
+func (s *OrderService) Get(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	return s.repo.FindByID(ctx, id)
+}
+
+// ListByUser returns every order placed by userID
+func (s *OrderService) ListByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	return s.repo.FindByUser(ctx, userID)
+}
+
+// EachByUser calls fn for every order placed by userID, oldest first,
+// streaming them where the repository can
+func (s *OrderService) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	return s.repo.EachByUser(ctx, userID, fn)
+}
+
+// ListUserPage returns one sorted page of the orders placed by userID
+func (s *OrderService) ListUserPage(ctx context.Context, userID string, q repository.PageQuery) (repository.Page, error) {
+	return s.repo.FindUserPage(ctx, userID, q)
+}
+
+// List returns one sorted page of every order matching filter
+func (s *OrderService) List(ctx context.Context, filter repository.OrderFilter, q repository.PageQuery) (repository.Page, error) {
+	return s.repo.FindPage(ctx, filter, q)
+}
+
+// Search returns one page of the orders matching filter that contain words
+// of text, most relevant first
+func (s *OrderService) Search(ctx context.Context, text string, filter repository.OrderFilter, q repository.PageQuery) (repository.SearchPage, error) {
+	return s.repo.Search(ctx, text, filter, q)
+}
+
+// UpdateStatus moves an order to change.To, recording the change with its
+// actor and reason in the order's status history; the time is set here.
+// Moves the order state machine does not allow fail with a
+// *contracts.TransitionError, and return statuses with ErrReturnStatus. A
+// non-zero change.Base is the updated_at the order must still have, or the
+// update fails with repository.ErrConflict; the same holds for the base of
+// Cancel, EditItems and Delete.
+func (s *OrderService) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error) {
+	if !contracts.IsStatus(change.To) {
+		return contracts.Order{}, ErrInvalidStatus
+	}
+	if contracts.IsReturnStatus(change.To) {
+		return contracts.Order{}, ErrReturnStatus
+	}
+	change.At = s.clock.Now()
+	return s.changeStatus(ctx, id, change)
+}
+
+// Cancel cancels an order that has not shipped yet, recording who did and
+// why. change.To is ignored.
+func (s *OrderService) Cancel(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error) {
+	change.To = contracts.StatusCancelled
+	change.At = s.clock.Now()
+	return s.changeStatus(ctx, id, change)
+}
+
+func (s *OrderService) changeStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error) {
+	var order contracts.Order
+	var previousStatus string
+	publish, err := s.atomically(ctx, func(ctx context.Context) ([]contracts.Event, error) {
+		var err error
+		if order, previousStatus, err = s.repo.UpdateStatus(ctx, id, change); err != nil {
+			return nil, err
+		}
+		return []contracts.Event{s.orderEvent(contracts.EventOrderStatusChanged, order, previousStatus)}, nil
+	})
+	if err != nil {
+		return order, err
+	}
+	if order.Status == contracts.StatusCancelled && previousStatus != contracts.StatusCancelled {
+		s.releaseStock(ctx, order.OrderID)
+	}
+
+	action := audit.ActionStatusUpdate
+	if order.Status == contracts.StatusCancelled {
+		action = audit.ActionCancel
+	}
+	after := audit.Fields{"status": order.Status}
+	if change.Reason != "" {
+		after["reason"] = change.Reason
+	}
+	if change.Return != nil {
+		after["return"] = change.Return
+	}
+	s.Audit.Record(ctx, audit.Record{
+		Actor:    audit.Actor{ID: change.Actor},
+		Action:   action,
+		Resource: audit.Resource{Type: audit.ResourceOrder, ID: order.OrderID},
+		Before:   audit.Fields{"status": previousStatus},
+		After:    after,
+	})
+
+	publish()
+	return order, nil
+}
+
+// EditItems changes the items of a pending order and recomputes its total.
+// Removals apply first, then quantity updates, then additions; products
+// removed or updated must be on the order. Added products are priced from
+// the catalog like on a new order, or increase the quantity of a product
+// already on it, which keeps its original price. Stock is reserved for the
+// edited items before they are stored. Orders that are no longer pending
+// fail with contracts.ErrNotEditable.
+func (s *OrderService) EditItems(ctx context.Context, id primitive.ObjectID, edit contracts.UpdateOrderItemsRequest, base time.Time) (contracts.Order, error) {
+	if len(edit.Add)+len(edit.Update)+len(edit.Remove) == 0 {
+		return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "add", Message: "at least one of add, update or remove is required"},
+		}}
+	}
+
+	current, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+	if current.Status != contracts.StatusPending {
+		return current, contracts.ErrNotEditable
+	}
+	if !base.IsZero() && !current.UpdatedAt.Equal(base) {
+		return current, repository.ErrConflict
+	}
+
+	items, err := s.editItems(ctx, current, edit)
+	if err != nil {
+		return current, err
+	}
+	if err := contracts.ValidateItems(items, s.Limits); err != nil {
+		return current, err
+	}
+	priced := current
+	priced.Items = items
+	if err := s.price(ctx, &priced); err != nil {
+		return current, err
+	}
+
+	if err := s.reserve(ctx, current.OrderID, items); err != nil {
+		return current, err
+	}
+	order, err := s.repo.UpdateItems(ctx, id, contracts.ItemsChange{
+		Items:      items,
+		Subtotal:   priced.Subtotal,
+		Charges:    priced.Charges(),
+		Total:      priced.TotalAmount,
+		Settlement: priced.SettlementTotal,
+		At:         s.clock.Now(),
+		Base:       current.UpdatedAt,
+	})
+	if err != nil {
+		// Hold only what the stored order still needs
+		s.restoreStock(ctx, current)
+		return order, err
+	}
+
+	s.publish(ctx, contracts.EventOrderItemsChanged, order, "")
+	return order, nil
+}
+
+// AddNote appends a note to an order at the current time. Blank notes are
+// validation errors, and orders that already have contracts.MaxNotes fail
+// with contracts.ErrTooManyNotes.
+func (s *OrderService) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	change.Note.Text = strings.TrimSpace(change.Note.Text)
+	if change.Note.Text == "" {
+		return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "text", Message: "must not be blank"},
+		}}
+	}
+	change.Note.At = s.clock.Now()
+
+	order, err := s.repo.AddNote(ctx, id, change)
+	if err != nil {
+		return order, err
+	}
+
+	s.publish(ctx, contracts.EventOrderNoteAdded, order, "")
+	return order, nil
+}
+
+// SetPriority changes the priority of a pending or confirmed order; other
+// orders fail with contracts.ErrPriorityLocked. Unknown priorities are
+// validation errors.
+func (s *OrderService) SetPriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	if !contracts.IsPriority(change.Priority) {
+		return contracts.Order{}, &contracts.ValidationError{Fields: []contracts.FieldError{
+			{Field: "priority", Message: "must be standard, expedited or rush"},
+		}}
+	}
+	change.At = s.clock.Now()
+
+	order, err := s.repo.UpdatePriority(ctx, id, change)
+	if err != nil {
+		return order, err
+	}
+	s.Audit.Record(ctx, audit.Record{
+		Actor:    audit.Actor{ID: change.Actor},
+		Action:   audit.ActionPriorityUpdate,
+		Resource: audit.Resource{Type: audit.ResourceOrder, ID: order.OrderID},
+		After:    audit.Fields{"priority": order.Priority},
+	})
+
+	s.publish(ctx, contracts.EventOrderPriorityChanged, order, "")
+	return order, nil
+}
+
+// Delete soft-deletes an order. It disappears from every read and is moved
+// to the archive by the archiver once it is old enough.
+func (s *OrderService) Delete(ctx context.Context, id primitive.ObjectID, base time.Time) (contracts.Order, error) {
+	order, err := s.repo.Delete(ctx, id, s.clock.Now(), base)
+	if err != nil {
+		return order, err
+	}
+	s.Audit.Record(ctx, audit.Record{
+		Action:   audit.ActionDelete,
+		Resource: audit.Resource{Type: audit.ResourceOrder, ID: order.OrderID},
+		Before:   audit.Fields{"status": order.Status},
+		After:    audit.Fields{"deleted_at": order.DeletedAt},
+	})
+	if order.Status == contracts.StatusPending || order.Status == contracts.StatusConfirmed {
+		s.releaseStock(ctx, order.OrderID)
+	}
+
+	s.publish(ctx, contracts.EventOrderDeleted, order, "")
+	return order, nil
+}
+
+// editItems applies edit to a copy of the order's items; added products are
+// priced in the order's currency
+func (s *OrderService) editItems(ctx context.Context, order contracts.Order, edit contracts.UpdateOrderItemsRequest) ([]contracts.OrderItem, error) {
+	edited := append([]contracts.OrderItem(nil), order.Items...)
+	position := func(productID string) int {
+		for i, item := range edited {
+			if item.ProductID == productID {
+				return i
+			}
+		}
+		return -1
+	}
+
+	verr := &contracts.ValidationError{}
+	invalid := func(field, message string) {
+		verr.Fields = append(verr.Fields, contracts.FieldError{Field: field, Message: message})
+	}
+
+	for i, productID := range edit.Remove {
+		at := position(productID)
+		if at < 0 {
+			invalid(fmt.Sprintf("remove[%d]", i), "is not on the order")
+			continue
+		}
+		edited = append(edited[:at], edited[at+1:]...)
+	}
+	for i, update := range edit.Update {
+		at := position(update.ProductID)
+		switch {
+		case at < 0:
+			invalid(fmt.Sprintf("update[%d].product_id", i), "is not on the order")
+		case update.Quantity < 1:
+			invalid(fmt.Sprintf("update[%d].quantity", i), "must be at least 1; remove the product instead")
+		default:
+			edited[at].Quantity = update.Quantity
+		}
+	}
+	for i, item := range edit.Add {
+		if strings.TrimSpace(item.ProductID) == "" {
+			invalid(fmt.Sprintf("add[%d].product_id", i), "is required")
+		}
+		if item.Quantity < 1 {
+			invalid(fmt.Sprintf("add[%d].quantity", i), "must be at least 1")
+		}
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	added, err := s.snapshotItems(ctx, "add", edit.Add)
+	if err != nil {
+		return nil, err
+	}
+	if added, err = s.itemsIn(ctx, "add", order.TotalAmount.Currency, added); err != nil {
+		return nil, err
+	}
+	for _, item := range added {
+		if at := position(item.ProductID); at >= 0 {
+			edited[at].Quantity += item.Quantity
+			continue
+		}
+		edited = append(edited, item)
+	}
+	return edited, nil
+}
+
+// ReplayEvents republishes stored events matching filter
+func (s *OrderService) ReplayEvents(ctx context.Context, filter events.Filter) (int, error) {
+	if s.store == nil {
+		return 0, errors.New("event store is not configured")
+	}
+	return events.Replay(ctx, s.store, s.publisher, filter)
+}
+
+// publish records an order event in the event store and publishes it.
+// Failures are logged but never fail the operation: the order write has
+// already succeeded and the stored event can be replayed later. The context
+// is detached from the request so a client disconnecting after the write
+// cannot drop the event; it keeps the request ID, which the event carries.
+func (s *OrderService) publish(ctx context.Context, eventType string, order contracts.Order, previousStatus string) {
+	s.emit(ctx, s.orderEvent(eventType, order, previousStatus), false)
+}
+
+// orderEvent returns the event of a change to order
+func (s *OrderService) orderEvent(eventType string, order contracts.Order, previousStatus string) contracts.Event {
+	event := events.NewEvent(eventType, order, s.clock.Now())
+	event.PreviousStatus = previousStatus
+	return event
+}
+
+// emit records event in the event store, unless it was stored with its
+// change already, and publishes it, as publish does
+func (s *OrderService) emit(ctx context.Context, event contracts.Event, stored bool) {
+	observeEvent(event)
+	if s.ChangeStreamEvents {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), s.DetachedTimeout)
+	defer cancel()
+
+	if s.store != nil && !stored {
+		if err := s.store.Append(ctx, event); err != nil {
+			eventPublishFailuresTotal.WithLabelValues(event.Type, "store").Inc()
+			log.Ctx(ctx).Error().Err(err).Str("event_type", event.Type).Str("order_id", event.OrderID).Msg("Failed to persist event")
+		}
+	}
+
+	if err := s.publisher.Publish(ctx, event, events.Headers(ctx, event.Type)); err != nil {
+		eventPublishFailuresTotal.WithLabelValues(event.Type, "publish").Inc()
+		log.Ctx(ctx).Error().Err(err).Str("event_type", event.Type).Str("order_id", event.OrderID).Msg("Failed to publish event")
+	}
+}
+
+// atomically runs write, which changes an order and returns the events
+// recording the change, in one transaction with storing those events, so
+// a failure to store them fails the change. The returned function
+// publishes the events once the caller is done with the change. Without
+// transactions the events are left to it to store and publish, as publish
+// does.
+func (s *OrderService) atomically(ctx context.Context, write func(ctx context.Context) ([]contracts.Event, error)) (publish func(), err error) {
+	var written []contracts.Event
+	stored := s.Transactions != nil && s.store != nil && !s.ChangeStreamEvents && s.Transactions.Atomic(ctx)
+	if stored {
+		err = s.Transactions.Run(ctx, func(ctx context.Context) error {
+			var err error
+			if written, err = write(ctx); err != nil {
+				return err
+			}
+			for _, event := range written {
+				if err := s.store.Append(ctx, event); err != nil {
+					return fmt.Errorf("persist %s event: %w", event.Type, err)
+				}
+			}
+			return nil
+		})
+	} else {
+		written, err = write(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		for _, event := range written {
+			s.emit(ctx, event, stored)
+		}
+	}, nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ConfirmPayment confirms the pending order a payment confirmation refers
+// to. Confirmations for orders already confirmed or further along are
+// acknowledged without change, so redelivered messages are harmless.
+// Unknown and cancelled orders fail with payment.ErrPermanent: retrying
+// cannot help, and a payment for a cancelled order needs a person.
+func (s *OrderService) ConfirmPayment(ctx context.Context, event payment.Event) error {
+	order, err := s.findByOrderID(ctx, event.OrderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("%w: order %s does not exist", payment.ErrPermanent, event.OrderID)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.confirmPaid(ctx, order, event)
+}
+
+// confirmPaid confirms order for the payment unless its status says the
+// confirmation was already applied or must not be
+func (s *OrderService) confirmPaid(ctx context.Context, order contracts.Order, event payment.Event) error {
+	switch order.Status {
+	case contracts.StatusCancelled:
+		return fmt.Errorf("%w: order %s was cancelled", payment.ErrPermanent, event.OrderID)
+	case contracts.StatusPending:
+	default:
+		log.Ctx(ctx).Debug().Str("order_id", order.OrderID).Str("status", order.Status).Msg("Payment already applied to order")
+		return nil
+	}
+
+	_, err := s.changeStatus(ctx, order.ID, contracts.StatusChange{
+		To:     contracts.StatusConfirmed,
+		At:     s.clock.Now(),
+		Reason: "Payment " + event.PaymentID + " confirmed",
+		Actor:  "payment-service",
+	})
+	if errors.Is(err, contracts.ErrInvalidTransition) {
+		// The order left pending concurrently, and never returns to it;
+		// decide again on its new status
+		if order, err = s.repo.FindByID(ctx, order.ID); err != nil {
+			return err
+		}
+		return s.confirmPaid(ctx, order, event)
+	}
+	return err
+}
+
+// findByOrderID returns the order with the given public order_id
+func (s *OrderService) findByOrderID(ctx context.Context, orderID string) (contracts.Order, error) {
+	page, err := s.repo.FindPage(ctx, repository.OrderFilter{OrderID: orderID}, repository.PageQuery{Limit: 1})
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	if len(page.Orders) == 0 {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	return page.Orders[0], nil
+}
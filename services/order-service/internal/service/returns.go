@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrReturnStatus is returned when UpdateStatus is asked for a return
+// status, which only the return workflow may set
+var ErrReturnStatus = errors.New("return statuses are set through the return workflow")
+
+// RequestReturn records the customer's request to return a delivered order.
+// change.Reason is the customer's reason and change.Actor the customer;
+// change.To is ignored.
+func (s *OrderService) RequestReturn(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error) {
+	change.To = contracts.StatusReturnRequested
+	change.At = s.clock.Now()
+	change.Return = &contracts.Return{Reason: change.Reason, RequestedBy: change.Actor, RequestedAt: change.At}
+	return s.changeStatus(ctx, id, change)
+}
+
+// ApproveReturn accepts a requested return and refunds refund, or the
+// order's total when nil, through Payments. change.Reason is the
+// resolution recorded on the return. The order is returned once approved
+// and refunded once the money has moved; a failed refund leaves it
+// returned, with the error, to be retried by RefundReturn.
+func (s *OrderService) ApproveReturn(ctx context.Context, id primitive.ObjectID, refund *money.Money, change contracts.StatusChange) (contracts.Order, error) {
+	current, err := s.reviewable(ctx, id, change.Base)
+	if err != nil {
+		return current, err
+	}
+	amount := current.TotalAmount
+	if refund != nil {
+		amount = *refund
+	}
+	if err := validateRefund(current, amount); err != nil {
+		return current, err
+	}
+
+	change.To = contracts.StatusReturned
+	change.At = s.clock.Now()
+	change.Base = current.UpdatedAt
+	change.Return = s.reviewed(current, change)
+	change.Return.RefundAmount = &amount
+	order, err := s.changeStatus(ctx, id, change)
+	if err != nil {
+		return order, err
+	}
+	return s.refund(ctx, order, change.Actor)
+}
+
+// RejectReturn turns down a requested return; change.Reason is the
+// resolution recorded on it
+func (s *OrderService) RejectReturn(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, error) {
+	current, err := s.reviewable(ctx, id, change.Base)
+	if err != nil {
+		return current, err
+	}
+
+	change.To = contracts.StatusReturnRejected
+	change.At = s.clock.Now()
+	change.Base = current.UpdatedAt
+	change.Return = s.reviewed(current, change)
+	return s.changeStatus(ctx, id, change)
+}
+
+// RefundReturn retries the refund of an approved return whose refund
+// failed
+func (s *OrderService) RefundReturn(ctx context.Context, id primitive.ObjectID, actor string) (contracts.Order, error) {
+	order, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != contracts.StatusReturned || order.Return == nil || order.Return.RefundAmount == nil {
+		return order, &contracts.TransitionError{From: order.Status, To: contracts.StatusRefunded}
+	}
+	return s.refund(ctx, order, actor)
+}
+
+// reviewable reads an order whose return awaits review
+func (s *OrderService) reviewable(ctx context.Context, id primitive.ObjectID, base time.Time) (contracts.Order, error) {
+	order, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != contracts.StatusReturnRequested || order.Return == nil {
+		return order, &contracts.TransitionError{From: order.Status, To: contracts.StatusReturned}
+	}
+	if !base.IsZero() && !order.UpdatedAt.Equal(base) {
+		return order, repository.ErrConflict
+	}
+	return order, nil
+}
+
+// reviewed returns a copy of the order's return marked reviewed by the
+// change's actor
+func (s *OrderService) reviewed(order contracts.Order, change contracts.StatusChange) *contracts.Return {
+	ret := *order.Return
+	at := change.At
+	ret.ReviewedBy = change.Actor
+	ret.ReviewedAt = &at
+	ret.Resolution = change.Reason
+	return &ret
+}
+
+// validateRefund checks a refund is in the order's currency and within its
+// total
+func validateRefund(order contracts.Order, amount money.Money) error {
+	var message string
+	switch {
+	case amount.Currency != order.TotalAmount.Currency:
+		message = fmt.Sprintf("must be in the order currency %s", order.TotalAmount.Currency)
+	case amount.IsNegative():
+		message = "must not be negative"
+	case amount.Amount > order.TotalAmount.Amount:
+		message = fmt.Sprintf("must not exceed the order total %s", order.TotalAmount)
+	default:
+		return nil
+	}
+	return &contracts.ValidationError{Fields: []contracts.FieldError{{Field: "refund_amount", Message: message}}}
+}
+
+// refund returns the approved amount of a returned order's payment and
+// moves the order to refunded. Without Payments, the refund is recorded as
+// made outside the service.
+func (s *OrderService) refund(ctx context.Context, order contracts.Order, actor string) (contracts.Order, error) {
+	ret := *order.Return
+	refund := &contracts.Refund{Amount: *ret.RefundAmount}
+	if s.Payments != nil && !refund.Amount.IsZero() {
+		var err error
+		if refund, err = s.refundPayment(ctx, order.OrderID, refund.Amount); err != nil {
+			return order, err
+		}
+	}
+	refund.RefundedAt = s.clock.Now()
+	ret.Refund = refund
+
+	return s.changeStatus(ctx, order.ID, contracts.StatusChange{
+		To:     contracts.StatusRefunded,
+		At:     refund.RefundedAt,
+		Actor:  actor,
+		Base:   order.UpdatedAt,
+		Return: &ret,
+	})
+}
+
+// refundPayment refunds amount of the order's payment. A payment that was
+// only authorized is voided instead, which releases all of it, so it can
+// only be refunded in full. The refund is keyed by the order, so a retry
+// after a failure to record it does not refund twice.
+func (s *OrderService) refundPayment(ctx context.Context, orderID string, amount money.Money) (*contracts.Refund, error) {
+	payments, err := s.Payments.ForOrder(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+	}
+	var paid *payment.Payment
+	for i := range payments {
+		switch payments[i].Status {
+		case payment.StatusAuthorized, payment.StatusCaptured, payment.StatusPartiallyRefunded:
+			paid = &payments[i]
+		}
+	}
+	if paid == nil {
+		return nil, fmt.Errorf("%w: order %s has no payment to refund", payment.ErrRefundDeclined, orderID)
+	}
+
+	if paid.Status == payment.StatusAuthorized {
+		if paid.Total() != amount {
+			return nil, fmt.Errorf("%w: an uncaptured payment can only be refunded in full", payment.ErrRefundDeclined)
+		}
+		if err := s.Payments.Void(ctx, paid.PaymentID); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+		}
+		return &contracts.Refund{PaymentID: paid.PaymentID, Amount: amount}, nil
+	}
+
+	result, err := s.Payments.Refund(ctx, paid.PaymentID, amount, "refund-"+orderID)
+	if errors.Is(err, payment.ErrRefundDeclined) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+	}
+	return &contracts.Refund{PaymentID: paid.PaymentID, Amount: result.Refunded()}, nil
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/featureflags"
+	"order-service/pkg/money"
+	"order-service/pkg/payment"
+	"order-service/pkg/repository"
+	"order-service/pkg/requestid"
+	"order-service/pkg/saga"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrPaymentUnavailable is returned when a payment cannot be authorized for
+// reasons other than a decline
+var ErrPaymentUnavailable = errors.New("payment service unavailable")
+
+// compensationTimeout bounds undoing one step. Compensation runs detached
+// from the request, so it completes even if the client has gone away.
+const compensationTimeout = 30 * time.Second
+
+// SagaStore persists the progress of order placements; it is implemented
+// by *saga.Store
+type SagaStore interface {
+	Start(ctx context.Context, s *saga.Saga) error
+	Save(ctx context.Context, s *saga.Saga) error
+	Claim(ctx context.Context) (saga.Saga, error)
+}
+
+// PaymentGateway authorizes and refunds order payments; it is implemented
+// by *payment.Client
+type PaymentGateway interface {
+	Authorize(ctx context.Context, orderID string, amount money.Money, key string) (payment.Payment, error)
+	Void(ctx context.Context, paymentID string) error
+	Refund(ctx context.Context, paymentID string, amount money.Money, key string) (payment.Refund, error)
+	ForOrder(ctx context.Context, orderID string) ([]payment.Payment, error)
+}
+
+// place stores an order through a placement saga: the promotion use is
+// redeemed, the order stored, its stock reserved, its payment authorized
+// and the order confirmed, recording each step as it completes. When a
+// step fails, the steps done are undone in reverse and the order is left
+// cancelled. A placement interrupted by a crash is finished or undone by
+// ResumeSagas.
+func (s *OrderService) place(ctx context.Context, order contracts.Order) (contracts.Order, error) {
+	order.ID = primitive.NewObjectID()
+	placement := &saga.Saga{ID: order.OrderID, Order: order, Steps: s.placementSteps(order)}
+	if err := s.Sagas.Start(ctx, placement); err != nil {
+		return order, fmt.Errorf("start placement saga: %w", err)
+	}
+	return s.runSaga(ctx, placement)
+}
+
+// placementSteps returns the steps placing order takes with the services
+// configured
+func (s *OrderService) placementSteps(order contracts.Order) []string {
+	var steps []string
+	if order.Promotion != nil {
+		steps = append(steps, saga.StepRedeemPromotion)
+	}
+	steps = append(steps, saga.StepCreateOrder)
+	if s.Inventory != nil {
+		steps = append(steps, saga.StepReserveStock)
+	}
+	if s.Payments != nil {
+		steps = append(steps, saga.StepAuthorizePayment, saga.StepConfirmOrder)
+	}
+	return steps
+}
+
+// placeWithSaga reports whether new orders are placed through a saga:
+// with Sagas, unless the PlacementSaga flag is off for the caller
+func (s *OrderService) placeWithSaga(ctx context.Context) bool {
+	return s.Sagas != nil && s.Flags.Enabled(ctx, featureflags.PlacementSaga)
+}
+
+// runSaga drives a placement from wherever it stopped. It returns the
+// placed order, or the error that made the placement fail once the steps
+// done have been undone. An error from compensation itself leaves the saga
+// compensating, to be retried by ResumeSagas.
+func (s *OrderService) runSaga(ctx context.Context, placement *saga.Saga) (contracts.Order, error) {
+	order := placement.Order
+	var failure error
+	for placement.Status == saga.StatusRunning {
+		step := placement.Next()
+		if step == "" {
+			placement.Status = saga.StatusCompleted
+			if err := s.Sagas.Save(ctx, placement); err != nil {
+				log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Msg("Failed to record completed placement saga")
+			}
+			return order, nil
+		}
+
+		next, err := s.sagaStep(ctx, placement, step)
+		if err != nil {
+			failure = err
+			placement.Status = saga.StatusCompensating
+			placement.Error = err.Error()
+			// A remote call that failed may still have taken effect. Every
+			// compensation but the promotion's is safe to run when it did
+			// not, so the failed step is undone with the others.
+			if step != saga.StepRedeemPromotion {
+				placement.Done = append(placement.Done, step)
+			}
+		} else {
+			order = next
+			placement.Done = append(placement.Done, step)
+		}
+		if err := s.Sagas.Save(ctx, placement); err != nil {
+			return order, fmt.Errorf("save placement saga: %w", err)
+		}
+	}
+	if failure == nil {
+		failure = errors.New(placement.Error)
+	}
+
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), compensationTimeout)
+	defer cancel()
+	for len(placement.Done) > 0 {
+		step := placement.Done[len(placement.Done)-1]
+		if err := s.compensate(ctx, placement, step); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Str("step", step).Msg("Failed to compensate placement step")
+			return order, failure
+		}
+		placement.Done = placement.Done[:len(placement.Done)-1]
+		if err := s.Sagas.Save(ctx, placement); err != nil {
+			log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Msg("Failed to record placement compensation")
+			return order, failure
+		}
+	}
+	placement.Status = saga.StatusFailed
+	if err := s.Sagas.Save(ctx, placement); err != nil {
+		log.Ctx(ctx).Error().Err(err).Str("order_id", order.OrderID).Msg("Failed to record failed placement saga")
+	}
+	log.Ctx(ctx).Warn().Str("order_id", order.OrderID).Str("error", placement.Error).Msg("Order placement compensated")
+	return order, failure
+}
+
+// sagaStep runs one placement step and returns the order as it stands
+// after it. Steps are safe to run again for an order whose earlier attempt
+// was interrupted.
+func (s *OrderService) sagaStep(ctx context.Context, placement *saga.Saga, step string) (contracts.Order, error) {
+	order := placement.Order
+	switch step {
+	case saga.StepRedeemPromotion:
+		return order, s.redeem(ctx, order)
+
+	case saga.StepCreateOrder:
+		stored, err := s.repo.FindByID(ctx, order.ID)
+		if err == nil {
+			return stored, nil
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			return order, err
+		}
+		if err := s.repo.Create(ctx, &order); err != nil {
+			return order, err
+		}
+		s.publish(ctx, contracts.EventOrderCreated, order, "")
+		return order, nil
+
+	case saga.StepReserveStock:
+		return order, s.reserve(ctx, order.OrderID, order.Items)
+
+	case saga.StepAuthorizePayment:
+		if s.Payments == nil {
+			return order, fmt.Errorf("%w: no payment service configured", ErrPaymentUnavailable)
+		}
+		p, err := s.Payments.Authorize(ctx, order.OrderID, order.TotalAmount, "order-"+order.OrderID)
+		placement.PaymentID = p.PaymentID
+		if err != nil && !errors.Is(err, payment.ErrDeclined) {
+			err = fmt.Errorf("%w: %v", ErrPaymentUnavailable, err)
+		}
+		return order, err
+
+	case saga.StepConfirmOrder:
+		stored, err := s.repo.FindByID(ctx, order.ID)
+		switch {
+		case err != nil:
+			return order, err
+		case stored.Status == contracts.StatusCancelled:
+			return order, errors.New("order was cancelled while it was being placed")
+		case stored.Status != contracts.StatusPending:
+			return stored, nil
+		}
+		confirmed, err := s.changeStatus(ctx, order.ID, contracts.StatusChange{
+			To:     contracts.StatusConfirmed,
+			At:     s.clock.Now(),
+			Reason: "Payment authorized",
+		})
+		if errors.Is(err, contracts.ErrInvalidTransition) {
+			// The order left pending meanwhile, confirmed by the payment
+			// service's event or cancelled; it never returns to pending
+			return s.sagaStep(ctx, placement, step)
+		}
+		return confirmed, err
+	}
+	return order, fmt.Errorf("unknown placement step %q", step)
+}
+
+// compensate undoes a placement step. Undoing a step that did not take
+// effect is a no-op, except for the promotion use, which is only undone
+// once redeemed.
+func (s *OrderService) compensate(ctx context.Context, placement *saga.Saga, step string) error {
+	order := placement.Order
+	switch step {
+	case saga.StepRedeemPromotion:
+		return s.Promotions.Release(ctx, order.Promotion.Code)
+
+	case saga.StepCreateOrder:
+		stored, err := s.repo.FindByID(ctx, order.ID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		if err != nil || stored.Status == contracts.StatusCancelled {
+			return err
+		}
+		_, err = s.changeStatus(ctx, order.ID, contracts.StatusChange{
+			To:     contracts.StatusCancelled,
+			At:     s.clock.Now(),
+			Reason: "Order could not be placed: " + placement.Error,
+		})
+		return err
+
+	case saga.StepReserveStock:
+		if s.Inventory == nil {
+			return errors.New("no inventory service configured")
+		}
+		return s.Inventory.Release(ctx, order.OrderID)
+
+	case saga.StepAuthorizePayment:
+		if s.Payments == nil {
+			return errors.New("no payment service configured")
+		}
+		payments := []payment.Payment{{PaymentID: placement.PaymentID, Status: payment.StatusAuthorized}}
+		if placement.PaymentID == "" {
+			// The authorization's outcome is unknown; void whatever it left
+			var err error
+			if payments, err = s.Payments.ForOrder(ctx, order.OrderID); err != nil {
+				return err
+			}
+		}
+		for _, p := range payments {
+			if p.Status != payment.StatusAuthorized {
+				continue
+			}
+			if err := s.Payments.Void(ctx, p.PaymentID); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case saga.StepConfirmOrder:
+		// Undone with the order itself
+		return nil
+	}
+	return fmt.Errorf("unknown placement step %q", step)
+}
+
+// ResumeSagas finishes or undoes every placement whose replica stopped
+// before it was done, and returns how many it took over
+func (s *OrderService) ResumeSagas(ctx context.Context) (int, error) {
+	resumed := 0
+	for {
+		placement, err := s.Sagas.Claim(ctx)
+		if errors.Is(err, saga.ErrNoneStale) {
+			return resumed, nil
+		}
+		if err != nil {
+			return resumed, err
+		}
+		resumed++
+
+		log.Ctx(ctx).Info().Str("order_id", placement.ID).Str("status", placement.Status).Strs("done", placement.Done).Msg("Resuming order placement")
+		if _, err := s.runSaga(ctx, &placement); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Str("order_id", placement.ID).Msg("Resumed order placement failed")
+		}
+	}
+}
+
+// RunSagaRecovery resumes interrupted placements every interval until ctx
+// is cancelled
+func (s *OrderService) RunSagaRecovery(ctx context.Context, interval time.Duration) {
+	for {
+		resumed, err := s.ResumeSagas(ctx)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Int("resumed", resumed).Msg("Order placement recovery failed")
+		} else if resumed > 0 {
+			log.Ctx(ctx).Info().Int("resumed", resumed).Msg("Resumed order placements")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/repository"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateShipment splits a pending shipment of some of the items off a
+// confirmed or shipped order. Its items must be on the order and, with the
+// other shipments, not exceed the quantities ordered. A non-zero base is
+// the updated_at the order must still have, as for UpdateStatus.
+func (s *OrderService) CreateShipment(ctx context.Context, id primitive.ObjectID, req contracts.CreateShipmentRequest, actor string, base time.Time) (contracts.Order, error) {
+	current, err := s.shippable(ctx, id, base)
+	if err != nil {
+		return current, err
+	}
+
+	shipment := contracts.Shipment{
+		ID:             uuid.New().String(),
+		Items:          req.Items,
+		Status:         contracts.ShipmentPending,
+		Carrier:        strings.TrimSpace(req.Carrier),
+		TrackingNumber: strings.TrimSpace(req.TrackingNumber),
+		CreatedAt:      s.clock.Now(),
+	}
+	shipments := append(append([]contracts.Shipment(nil), current.Shipments...), shipment)
+	if err := contracts.ValidateShipments("items", current.Items, shipments); err != nil {
+		return current, err
+	}
+	return s.updateShipments(ctx, current, shipments, actor)
+}
+
+// UpdateShipment moves a shipment of the order forward and sets its
+// carrier and tracking number, leaving those not given unchanged. Once
+// every item is in a shipped shipment the order becomes shipped, and once
+// all of those are delivered it becomes delivered. Shipments the order
+// does not have fail with contracts.ErrShipmentNotFound.
+func (s *OrderService) UpdateShipment(ctx context.Context, id primitive.ObjectID, shipmentID string, req contracts.UpdateShipmentRequest, actor string, base time.Time) (contracts.Order, error) {
+	current, err := s.shippable(ctx, id, base)
+	if err != nil {
+		return current, err
+	}
+
+	shipments := append([]contracts.Shipment(nil), current.Shipments...)
+	var shipment *contracts.Shipment
+	for i := range shipments {
+		if shipments[i].ID == shipmentID {
+			shipment = &shipments[i]
+		}
+	}
+	if shipment == nil {
+		return current, contracts.ErrShipmentNotFound
+	}
+
+	if req.Status != "" {
+		if err := shipment.Advance(req.Status, s.clock.Now()); err != nil {
+			return current, err
+		}
+	}
+	if carrier := strings.TrimSpace(req.Carrier); carrier != "" {
+		shipment.Carrier = carrier
+	}
+	if tracking := strings.TrimSpace(req.TrackingNumber); tracking != "" {
+		shipment.TrackingNumber = tracking
+	}
+	return s.updateShipments(ctx, current, shipments, actor)
+}
+
+// shippable reads an order whose shipments may change
+func (s *OrderService) shippable(ctx context.Context, id primitive.ObjectID, base time.Time) (contracts.Order, error) {
+	order, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != contracts.StatusConfirmed && order.Status != contracts.StatusShipped {
+		return order, contracts.ErrNotShippable
+	}
+	if !base.IsZero() && !order.UpdatedAt.Equal(base) {
+		return order, repository.ErrConflict
+	}
+	return order, nil
+}
+
+// updateShipments stores the order's new shipments with the status derived
+// from them, and publishes the change followed by any status change
+func (s *OrderService) updateShipments(ctx context.Context, current contracts.Order, shipments []contracts.Shipment, actor string) (contracts.Order, error) {
+	change := contracts.ShipmentsChange{
+		Shipments: shipments,
+		Actor:     actor,
+		At:        s.clock.Now(),
+		Base:      current.UpdatedAt,
+	}
+	if status := contracts.ShipmentsStatus(current.Items, shipments); status != current.Status {
+		change.Status = status
+	}
+
+	var order contracts.Order
+	publish, err := s.atomically(ctx, func(ctx context.Context) ([]contracts.Event, error) {
+		var previousStatus string
+		var err error
+		if order, previousStatus, err = s.repo.UpdateShipments(ctx, current.ID, change); err != nil {
+			return nil, err
+		}
+		written := []contracts.Event{s.orderEvent(contracts.EventOrderShipmentsChanged, order, "")}
+		if order.Status != previousStatus {
+			written = append(written, s.orderEvent(contracts.EventOrderStatusChanged, order, previousStatus))
+		}
+		return written, nil
+	})
+	if err != nil {
+		return order, err
+	}
+	publish()
+	return order, nil
+}
@@ -2,440 +2,34 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"os"
-	"strconv"
-	"strings"
-	"time"
 
-	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/google/uuid"
+	"order-service/internal/app"
+
 	"github.com/joho/godotenv"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
-)
-
-// Prometheus metrics
-var (
-	httpRequestsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "http_requests_total",
-			Help: "Total number of HTTP requests",
-		},
-		[]string{"method", "route", "status"},
-	)
-
-	httpRequestDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name: "http_request_duration_seconds",
-			Help: "Duration of HTTP requests in seconds",
-		},
-		[]string{"method", "route"},
-	)
 )
 
-func init() {
-	prometheus.MustRegister(httpRequestsTotal)
-	prometheus.MustRegister(httpRequestDuration)
-}
-
-type Order struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	OrderID     string             `json:"order_id" bson:"order_id"`
-	UserID      string             `json:"user_id" bson:"user_id"`
-	Items       []OrderItem        `json:"items" bson:"items"`
-	TotalAmount float64            `json:"total_amount" bson:"total_amount"`
-	Status      string             `json:"status" bson:"status"`
-	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
-}
-
-// OrderItem represents an item in an order
-type OrderItem struct {
-	ProductID string  `json:"product_id" bson:"product_id"`
-	Name      string  `json:"name" bson:"name"`
-	Price     float64 `json:"price" bson:"price"`
-	Quantity  int     `json:"quantity" bson:"quantity"`
-}
-
-// CreateOrderRequest represents the request payload for creating an order
-type CreateOrderRequest struct {
-	Items []OrderItem `json:"items" binding:"required"`
-}
-
-// UpdateOrderStatusRequest represents the request payload for updating order status
-type UpdateOrderStatusRequest struct {
-	Status string `json:"status" binding:"required"`
-}
-
-// Database connection
-var collection *mongo.Collection
-
-// JWT secret
-var jwtSecret = []byte("fallback-secret")
-
 func main() {
 	// Load environment variables
 	godotenv.Load()
 
 	// Setup logger
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
-
-	// Connect to MongoDB
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017"
-	}
+	app.SetupLogger()
 
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoURI))
+	cfg, err := app.LoadConfig()
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to MongoDB")
-	}
-	defer client.Disconnect(context.TODO())
-
-	collection = client.Database("orders").Collection("orders")
-
-	// Setup JWT secret
-	if secret := os.Getenv("JWT_SECRET"); secret != "" {
-		jwtSecret = []byte(secret)
-	}
-
-	// Setup Gin
-	if os.Getenv("GIN_MODE") == "release" {
-		gin.SetMode(gin.ReleaseMode)
-	}
-
-	r := gin.New()
-	r.Use(gin.Recovery())
-	r.Use(loggingMiddleware())
-	r.Use(metricsMiddleware())
-	r.Use(corsMiddleware())
-
-	// Health check endpoint
-	r.GET("/health", healthCheck)
-
-	// Metrics endpoint
-	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
-
-	// API routes
-	api := r.Group("/api/orders")
-	api.Use(authMiddleware())
-	{
-		api.POST("", createOrder)
-		api.GET("/:id", getOrder)
-		api.GET("/user/:userId", getUserOrders)
-		api.PUT("/:id/status", updateOrderStatus)
-	}
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3003"
-	}
-
-	log.Info().Str("port", port).Msg("Order service starting")
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal().Err(err).Msg("Failed to start server")
+		log.Fatal().Err(err).Msg("Invalid configuration")
 	}
-}
-
-func loggingMiddleware() gin.HandlerFunc {
-	return gin.LoggerWithConfig(gin.LoggerConfig{
-		Formatter: func(param gin.LogFormatterParams) string {
-			return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\"\n",
-				param.ClientIP,
-				param.TimeStamp.Format(time.RFC1123),
-				param.Method,
-				param.Path,
-				param.Request.Proto,
-				param.StatusCode,
-				param.Latency,
-				param.Request.UserAgent(),
-				param.ErrorMessage,
-			)
-		},
-		Output:    os.Stdout,
-		SkipPaths: []string{"/health", "/metrics"},
-	})
-}
-
-func metricsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
-
-		c.Next()
-
-		duration := time.Since(start)
-		route := c.FullPath()
-		if route == "" {
-			route = "unknown"
-		}
-
-		httpRequestsTotal.WithLabelValues(
-			c.Request.Method,
-			route,
-			strconv.Itoa(c.Writer.Status()),
-		).Inc()
-
-		httpRequestDuration.WithLabelValues(
-			c.Request.Method,
-			route,
-		).Observe(duration.Seconds())
-	}
-}
-
-func corsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	app.SetLogLevel(cfg.LogLevel)
+	app.SetLogRedaction(cfg.Redaction)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-
-		c.Next()
-	}
-}
-
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
-			return
-		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
-			c.Abort()
-			return
-		}
-
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
-			return
-		}
-
-		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("userID", claims["userId"])
-			c.Set("email", claims["email"])
-		}
-
-		c.Next()
-	}
-}
-
-func healthCheck(c *gin.Context) {
-	// Check database connection
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	err := collection.Database().Client().Ping(ctx, nil)
+	a, err := app.New(context.Background(), cfg)
 	if err != nil {
-		log.Error().Err(err).Msg("Database ping failed")
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":  "unhealthy",
-			"service": "order-service",
-			"error":   "database connection failed",
-		})
-		return
+		log.Fatal().Err(err).Msg("Failed to initialize order service")
 	}
+	defer a.Close(context.Background())
 
-	c.JSON(http.StatusOK, gin.H{
-		"status":    "healthy",
-		"service":   "order-service",
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"database":  "connected",
-	})
-}
-
-func createOrder(c *gin.Context) {
-	var req CreateOrderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
-		return
-	}
-
-	// Calculate total amount
-	var totalAmount float64
-	for _, item := range req.Items {
-		totalAmount += item.Price * float64(item.Quantity)
-	}
-
-	order := Order{
-		OrderID:     uuid.New().String(),
-		UserID:      userID.(string),
-		Items:       req.Items,
-		TotalAmount: totalAmount,
-		Status:      "pending",
-		CreatedAt:   time.Now().UTC(),
-		UpdatedAt:   time.Now().UTC(),
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	result, err := collection.InsertOne(ctx, order)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create order")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create order"})
-		return
-	}
-
-	order.ID = result.InsertedID.(primitive.ObjectID)
-
-	log.Info().
-		Str("order_id", order.OrderID).
-		Str("user_id", order.UserID).
-		Float64("total_amount", order.TotalAmount).
-		Msg("Order created successfully")
-
-	c.JSON(http.StatusCreated, order)
-}
-
-func getOrder(c *gin.Context) {
-	orderID := c.Param("id")
-
-	objectID, err := primitive.ObjectIDFromHex(orderID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var order Order
-	err = collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&order)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-			return
-		}
-		log.Error().Err(err).Str("order_id", orderID).Msg("Failed to get order")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get order"})
-		return
-	}
-
-	c.JSON(http.StatusOK, order)
-}
-
-func getUserOrders(c *gin.Context) {
-	userID := c.Param("userId")
-
-	// Verify user can only access their own orders
-	tokenUserID, exists := c.Get("userID")
-	if !exists || tokenUserID.(string) != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
-	if err != nil {
-		log.Error().Err(err).Str("user_id", userID).Msg("Failed to get user orders")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get orders"})
-		return
-	}
-	defer cursor.Close(ctx)
-
-	var orders []Order
-	if err = cursor.All(ctx, &orders); err != nil {
-		log.Error().Err(err).Str("user_id", userID).Msg("Failed to decode orders")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get orders"})
-		return
-	}
-
-	c.JSON(http.StatusOK, orders)
-}
-
-func updateOrderStatus(c *gin.Context) {
-	orderID := c.Param("id")
-
-	objectID, err := primitive.ObjectIDFromHex(orderID)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
-		return
-	}
-
-	var req UpdateOrderStatusRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Validate status
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"confirmed": true,
-		"shipped":   true,
-		"delivered": true,
-		"cancelled": true,
-	}
-
-	if !validStatuses[req.Status] {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
-		return
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	update := bson.M{
-		"$set": bson.M{
-			"status":     req.Status,
-			"updated_at": time.Now().UTC(),
-		},
-	}
-
-	result, err := collection.UpdateOne(ctx, bson.M{"_id": objectID}, update)
-	if err != nil {
-		log.Error().Err(err).Str("order_id", orderID).Msg("Failed to update order status")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
-		return
-	}
-
-	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
-		return
+	if err := a.RunServer(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start server")
 	}
-
-	log.Info().
-		Str("order_id", orderID).
-		Str("new_status", req.Status).
-		Msg("Order status updated successfully")
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Order status updated successfully",
-		"status":  req.Status,
-	})
 }
@@ -0,0 +1,66 @@
+// Package address verifies shipping addresses with an external
+// address-validation provider. The provider is called with the address as
+// JSON and answers 200 with the address in its standardized form, or 422
+// with the fields it rejects:
+//
+//	{"errors": [{"field": "postal_code", "message": "does not match the city"}]}
+package address
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/httpclient"
+)
+
+// Client calls an address-validation provider
+type Client struct {
+	url    string
+	client *httpclient.Client
+}
+
+// NewClient returns a client posting addresses to url, authenticating with
+// apiKey as a bearer token when it is set
+func NewClient(url, apiKey string) *Client {
+	cfg := httpclient.DefaultConfig("address-validation")
+	if apiKey != "" {
+		cfg.Auth = httpclient.BearerToken(apiKey)
+	}
+	return &Client{url: url, client: httpclient.New(cfg)}
+}
+
+// Verify returns the provider's standardized form of a. An address the
+// provider rejects fails with a *contracts.ValidationError naming the
+// address fields at fault.
+func (c *Client) Verify(ctx context.Context, a contracts.Address) (contracts.Address, error) {
+	resp, err := c.client.PostJSON(ctx, c.url, a)
+	if err != nil {
+		return a, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var verified contracts.Address
+		if err := json.NewDecoder(resp.Body).Decode(&verified); err != nil {
+			return a, fmt.Errorf("decode verified address: %w", err)
+		}
+		return verified, nil
+	case http.StatusUnprocessableEntity:
+		var rejected struct {
+			Errors []contracts.FieldError `json:"errors"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&rejected); err != nil {
+			return a, fmt.Errorf("decode address errors: %w", err)
+		}
+		if len(rejected.Errors) == 0 {
+			rejected.Errors = []contracts.FieldError{{Message: "is not a deliverable address"}}
+		}
+		return a, &contracts.ValidationError{Fields: rejected.Errors}
+	default:
+		return a, fmt.Errorf("address validation returned status %d", resp.StatusCode)
+	}
+}
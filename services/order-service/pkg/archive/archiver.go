@@ -0,0 +1,160 @@
+// Package archive moves aged orders out of the live orders collection into
+// an archive collection, keeping the live working set small, and older ones
+// still out of MongoDB into cold storage. Archived documents keep their
+// original shape plus the time they were archived.
+package archive
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection is the default name of the archive collection
+const Collection = "orders_archive"
+
+// duplicateKeyCode is MongoDB's error code for a unique index violation
+const duplicateKeyCode = 11000
+
+// Archiver moves orders created more than After ago into the archive once
+// they are finished: delivered, cancelled, refunded, return rejected or
+// soft-deleted. Orders still in progress stay live however old they are.
+type Archiver struct {
+	orders  *mongo.Collection
+	archive *mongo.Collection
+
+	// After is the retention window orders stay live for
+	After time.Duration
+	// BatchSize is how many orders are moved per round trip
+	BatchSize int
+	Clock     clock.Clock
+}
+
+// NewArchiver returns an archiver moving orders older than after from
+// orders to archive
+func NewArchiver(orders, archive *mongo.Collection, after time.Duration) *Archiver {
+	return &Archiver{orders: orders, archive: archive, After: after, BatchSize: 500, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the indexes used to look up archived orders
+func (a *Archiver) EnsureIndexes(ctx context.Context) error {
+	_, err := a.archive.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+	})
+	return err
+}
+
+// ArchiveOnce moves every order currently due and returns how many were
+// moved. Each batch is copied before it is deleted, so an interrupted run
+// loses nothing and concurrent archivers only repeat work.
+func (a *Archiver) ArchiveOnce(ctx context.Context) (int, error) {
+	now := a.Clock.Now()
+	filter := finishedBefore(now.Add(-a.After))
+
+	moved := 0
+	for {
+		n, err := a.moveBatch(ctx, filter, now)
+		moved += n
+		if err != nil || n < a.BatchSize {
+			return moved, err
+		}
+	}
+}
+
+// moveBatch copies up to BatchSize due orders into the archive and deletes
+// them from the live collection
+func (a *Archiver) moveBatch(ctx context.Context, filter bson.M, now time.Time) (int, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(a.BatchSize))
+	cursor, err := a.orders.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.D
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	ids := make(bson.A, 0, len(docs))
+	archived := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		for _, field := range doc {
+			if field.Key == "_id" {
+				ids = append(ids, field.Value)
+			}
+		}
+		archived = append(archived, append(doc, bson.E{Key: "archived_at", Value: now}))
+	}
+
+	// Orders already archived by an earlier, interrupted run are skipped
+	_, err = a.archive.InsertMany(ctx, archived, options.InsertMany().SetOrdered(false))
+	if err != nil && !onlyDuplicates(err) {
+		return 0, err
+	}
+
+	deleteFilter := bson.M{"_id": bson.M{"$in": ids}}
+	for k, v := range filter {
+		deleteFilter[k] = v
+	}
+	result, err := a.orders.DeleteMany(ctx, deleteFilter)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// Run archives due orders every interval until ctx is done
+func (a *Archiver) Run(ctx context.Context, interval time.Duration) {
+	for {
+		moved, err := a.ArchiveOnce(ctx)
+		if err != nil {
+			log.Error().Err(err).Int("moved", moved).Msg("Order archiving failed")
+		} else if moved > 0 {
+			log.Info().Int("moved", moved).Msg("Archived orders")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// finishedBefore selects the finished and deleted orders created before
+// cutoff
+func finishedBefore(cutoff time.Time) bson.M {
+	return bson.M{
+		"created_at": bson.M{"$lt": cutoff},
+		"$or": bson.A{
+			bson.M{"status": bson.M{"$in": bson.A{contracts.StatusDelivered, contracts.StatusCancelled, contracts.StatusReturnRejected, contracts.StatusRefunded}}},
+			bson.M{"deleted_at": bson.M{"$exists": true}},
+		},
+	}
+}
+
+// onlyDuplicates reports whether err consists solely of duplicate key errors
+func onlyDuplicates(err error) bool {
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return false
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != duplicateKeyCode {
+			return false
+		}
+	}
+	return true
+}
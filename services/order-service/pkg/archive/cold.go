@@ -0,0 +1,403 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/repository"
+	"order-service/pkg/tenant"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ColdCollection is the default name of the collection recording where in
+// cold storage each exported order is
+const ColdCollection = "orders_cold"
+
+// maxLine bounds one exported order, well above MongoDB's 16MB documents
+// once written as extended JSON
+const maxLine = 64 << 20
+
+// ObjectStore holds the exported batches; it is implemented by
+// *objectstore.S3
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// coldOrder records the object an exported order is in, along with the
+// fields orders are looked up by
+type coldOrder struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	OrderID    string             `bson:"order_id"`
+	UserID     string             `bson:"user_id"`
+	TenantID   string             `bson:"tenant_id,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	Object     string             `bson:"object"`
+	ExportedAt time.Time          `bson:"exported_at"`
+}
+
+// ColdStorage exports finished and deleted orders created more than Months
+// ago out of MongoDB into an object store, as gzipped NDJSON batches of
+// canonical extended JSON, and reads them back one at a time. Every
+// exported order keeps a small record in the index collection naming its
+// batch, so reads never scan the store.
+type ColdStorage struct {
+	sources []*mongo.Collection
+	index   *mongo.Collection
+	store   ObjectStore
+
+	// Months is how old orders are when exported
+	Months int
+	// Prefix is prepended to the key of every batch
+	Prefix string
+	// BatchSize is how many orders go in one object
+	BatchSize int
+	Clock     clock.Clock
+}
+
+// NewColdStorage returns the cold storage of orders older than months,
+// exported from each of sources, such as the live orders and the archive,
+// into store and recorded in index
+func NewColdStorage(index *mongo.Collection, store ObjectStore, months int, sources ...*mongo.Collection) *ColdStorage {
+	return &ColdStorage{sources: sources, index: index, store: store, Months: months, BatchSize: 500, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the indexes used to look up exported orders
+func (c *ColdStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := c.index.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "order_id", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// ExportOnce exports every order currently due and returns how many were
+// exported. Each batch is stored and recorded before it is deleted, so an
+// interrupted run loses nothing: orders exported again land in a new batch,
+// which their record then names.
+func (c *ColdStorage) ExportOnce(ctx context.Context) (int, error) {
+	now := c.Clock.Now()
+	filter := finishedBefore(now.AddDate(0, -c.Months, 0))
+
+	exported := 0
+	for _, source := range c.sources {
+		for {
+			n, err := c.exportBatch(ctx, source, filter, now)
+			exported += n
+			if err != nil {
+				return exported, fmt.Errorf("export from %s: %w", source.Name(), err)
+			}
+			if n < c.BatchSize {
+				break
+			}
+		}
+	}
+	return exported, nil
+}
+
+// exportBatch writes up to BatchSize due orders of source to one object,
+// records them, and deletes them from source
+func (c *ColdStorage) exportBatch(ctx context.Context, source *mongo.Collection, filter bson.M, now time.Time) (int, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}).SetLimit(int64(c.BatchSize))
+	cursor, err := source.Find(ctx, filter, opts)
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.Raw
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+	if len(docs) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	records := make([]coldOrder, 0, len(docs))
+	for _, doc := range docs {
+		line, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return 0, err
+		}
+		zw.Write(append(line, '\n'))
+
+		var record coldOrder
+		if err := bson.Unmarshal(doc, &record); err != nil {
+			return 0, err
+		}
+		records = append(records, record)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+
+	// Batches are filed under the month of their oldest order
+	key := fmt.Sprintf("%sorders/%s/%s.ndjson.gz", c.Prefix, records[0].CreatedAt.UTC().Format("2006/01"), primitive.NewObjectIDFromTimestamp(now).Hex())
+	if err := c.store.Put(ctx, key, buf.Bytes(), "application/x-ndjson"); err != nil {
+		return 0, err
+	}
+
+	ids := make(bson.A, 0, len(records))
+	writes := make([]mongo.WriteModel, 0, len(records))
+	for _, record := range records {
+		record.Object = key
+		record.ExportedAt = now
+		ids = append(ids, record.ID)
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": record.ID}).SetReplacement(record).SetUpsert(true))
+	}
+	if _, err := c.index.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+
+	deleteFilter := bson.M{"_id": bson.M{"$in": ids}}
+	for k, v := range filter {
+		deleteFilter[k] = v
+	}
+	result, err := source.DeleteMany(ctx, deleteFilter)
+	if err != nil {
+		return 0, err
+	}
+	return int(result.DeletedCount), nil
+}
+
+// Find reads the exported order with the given document ID back from its
+// batch, failing with repository.ErrNotFound if it was never exported
+func (c *ColdStorage) Find(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	var record coldOrder
+	err := c.index.FindOne(ctx, bson.M{"_id": id}).Decode(&record)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	if err != nil {
+		return contracts.Order{}, err
+	}
+
+	lines, err := c.batch(ctx, record.Object)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	for _, line := range lines {
+		if line.id != id {
+			continue
+		}
+		var order contracts.Order
+		if err := bson.UnmarshalExtJSON(line.json, true, &order); err != nil {
+			return contracts.Order{}, fmt.Errorf("decode cold storage batch %s: %w", record.Object, err)
+		}
+		return order, nil
+	}
+	return contracts.Order{}, fmt.Errorf("order %s missing from cold storage batch %s", id.Hex(), record.Object)
+}
+
+// FindByUser reads every exported order of userID back, oldest batch first.
+// With a tenant in ctx, only that tenant's orders are read.
+func (c *ColdStorage) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	orders := []contracts.Order{}
+	err := c.eachBatch(ctx, userFilter(ctx, userID), func(key string, lines []batchLine, exported map[primitive.ObjectID]bool) error {
+		for _, line := range lines {
+			if !exported[line.id] {
+				continue
+			}
+			var order contracts.Order
+			if err := bson.UnmarshalExtJSON(line.json, true, &order); err != nil {
+				return fmt.Errorf("decode cold storage batch %s: %w", key, err)
+			}
+			orders = append(orders, order)
+		}
+		return nil
+	})
+	return orders, err
+}
+
+// Thaw moves the exported orders of userID back into target, such as the
+// archive collection, so they can be changed again, as when the user's data
+// is erased. Their batches are rewritten without them, or deleted once
+// empty; while still due, they are exported again by the next run. With a
+// tenant in ctx, only that tenant's orders are moved.
+func (c *ColdStorage) Thaw(ctx context.Context, userID string, target *mongo.Collection) (int, error) {
+	thawed := 0
+	err := c.eachBatch(ctx, userFilter(ctx, userID), func(key string, lines []batchLine, moving map[primitive.ObjectID]bool) error {
+		var docs []interface{}
+		var kept bytes.Buffer
+		zw := gzip.NewWriter(&kept)
+		for _, line := range lines {
+			if !moving[line.id] {
+				zw.Write(append(line.json, '\n'))
+				continue
+			}
+			var doc bson.D
+			if err := bson.UnmarshalExtJSON(line.json, true, &doc); err != nil {
+				return fmt.Errorf("decode cold storage batch %s: %w", key, err)
+			}
+			docs = append(docs, doc)
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+
+		// The orders are copied back before the batch loses them, and
+		// copies left by an interrupted thaw are skipped
+		if len(docs) > 0 {
+			_, err := target.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+			if err != nil && !onlyDuplicates(err) {
+				return err
+			}
+		}
+		var err error
+		if len(docs) == len(lines) {
+			err = c.store.Delete(ctx, key)
+		} else {
+			err = c.store.Put(ctx, key, kept.Bytes(), "application/x-ndjson")
+		}
+		if err != nil {
+			return err
+		}
+
+		ids := make(bson.A, 0, len(moving))
+		for id := range moving {
+			ids = append(ids, id)
+		}
+		if _, err := c.index.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+			return err
+		}
+		thawed += len(docs)
+		return nil
+	})
+	return thawed, err
+}
+
+// batchLine is one order of a batch
+type batchLine struct {
+	id   primitive.ObjectID
+	json []byte
+}
+
+// batch reads the orders of the batch under key
+func (c *ColdStorage) batch(ctx context.Context, key string) ([]batchLine, error) {
+	body, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("read cold storage batch %s: %w", key, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("read cold storage batch %s: %w", key, err)
+	}
+	var lines []batchLine
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, maxLine)
+	for scanner.Scan() {
+		var doc struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return nil, fmt.Errorf("decode cold storage batch %s: %w", key, err)
+		}
+		lines = append(lines, batchLine{id: doc.ID, json: append([]byte{}, scanner.Bytes()...)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cold storage batch %s: %w", key, err)
+	}
+	return lines, nil
+}
+
+// eachBatch calls fn with every batch holding orders whose records match
+// filter, oldest first, along with the IDs of those orders
+// userFilter matches the index records of userID's orders in the tenant of
+// ctx, or in every tenant without one
+func userFilter(ctx context.Context, userID string) bson.M {
+	filter := bson.M{"user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	return filter
+}
+
+func (c *ColdStorage) eachBatch(ctx context.Context, filter bson.M, fn func(key string, lines []batchLine, matched map[primitive.ObjectID]bool) error) error {
+	cursor, err := c.index.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	var records []coldOrder
+	if err := cursor.All(ctx, &records); err != nil {
+		return err
+	}
+
+	var keys []string
+	matched := map[string]map[primitive.ObjectID]bool{}
+	for _, record := range records {
+		if matched[record.Object] == nil {
+			keys = append(keys, record.Object)
+			matched[record.Object] = map[primitive.ObjectID]bool{}
+		}
+		matched[record.Object][record.ID] = true
+	}
+	for _, key := range keys {
+		lines, err := c.batch(ctx, key)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, lines, matched[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run exports due orders every interval until ctx is done
+func (c *ColdStorage) Run(ctx context.Context, interval time.Duration) {
+	for {
+		exported, err := c.ExportOnce(ctx)
+		if err != nil {
+			log.Error().Err(err).Int("exported", exported).Msg("Cold storage export failed")
+		} else if exported > 0 {
+			log.Info().Int("exported", exported).Msg("Exported orders to cold storage")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ColdRepository reads orders missing from another repository back from
+// cold storage, so links to exported orders keep working. Exported orders
+// are finished and can only be read, by ID; listings and changes see only
+// the wrapped repository.
+type ColdRepository struct {
+	repository.OrderRepository
+	cold *ColdStorage
+}
+
+// NewColdRepository wraps next, falling back to cold
+func NewColdRepository(next repository.OrderRepository, cold *ColdStorage) *ColdRepository {
+	return &ColdRepository{OrderRepository: next, cold: cold}
+}
+
+// FindByID returns the order from the wrapped repository, or else from
+// cold storage. Deleted orders stay missing either way.
+func (r *ColdRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	order, err := r.OrderRepository.FindByID(ctx, id)
+	if !errors.Is(err, repository.ErrNotFound) {
+		return order, err
+	}
+	order, err = r.cold.Find(ctx, id)
+	if err == nil && order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	return order, err
+}
@@ -0,0 +1,145 @@
+// Package audit records who did what to which order, and what changed, to
+// a stream kept apart from the application logs: status updates,
+// cancellations and admin operations. Sinks only ever append, so the
+// stream can be handed to auditors as it is.
+package audit
+
+import (
+	"context"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/requestid"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Actions recorded
+const (
+	ActionStatusUpdate    = "order.status_update"
+	ActionCancel          = "order.cancel"
+	ActionPriorityUpdate  = "order.priority_update"
+	ActionDelete          = "order.delete"
+	ActionEventReplay     = "events.replay"
+	ActionReadOnly        = "service.read_only"
+	ActionLogLevel        = "service.log_level"
+	ActionPromotionCreate = "promotion.create"
+	ActionUserErase       = "user.erase"
+	ActionUserExport      = "user.export"
+)
+
+// Resource types
+const (
+	ResourceOrder     = "order"
+	ResourceEvents    = "events"
+	ResourceService   = "service"
+	ResourcePromotion = "promotion"
+	ResourceUser      = "user"
+)
+
+// SystemActor is the actor of changes no caller asked for, such as orders
+// expiring
+const SystemActor = "system"
+
+// Actor is who performed an action: the user ID and roles of the token,
+// or a service name
+type Actor struct {
+	ID    string   `json:"id" bson:"id"`
+	Roles []string `json:"roles,omitempty" bson:"roles,omitempty"`
+}
+
+// Resource is what an action applied to
+type Resource struct {
+	Type string `json:"type" bson:"type"`
+	ID   string `json:"id,omitempty" bson:"id,omitempty"`
+}
+
+// Fields are the values an action changed, before or after it
+type Fields map[string]interface{}
+
+// Record is one entry of the audit stream
+type Record struct {
+	ID        string    `json:"id" bson:"_id"`
+	At        time.Time `json:"at" bson:"at"`
+	RequestID string    `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	Actor     Actor     `json:"actor" bson:"actor"`
+	Action    string    `json:"action" bson:"action"`
+	Resource  Resource  `json:"resource" bson:"resource"`
+	Before    Fields    `json:"before,omitempty" bson:"before,omitempty"`
+	After     Fields    `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// Sink appends records to the audit stream
+type Sink interface {
+	Write(ctx context.Context, rec Record) error
+	Close() error
+}
+
+// writeTimeout bounds writing one record
+const writeTimeout = 5 * time.Second
+
+// Log writes records to a sink. A nil *Log records nothing, so components
+// may hold one unconditionally.
+type Log struct {
+	sink  Sink
+	clock clock.Clock
+}
+
+// New returns a log writing to sink; nil clk is the system clock
+func New(sink Sink, clk clock.Clock) *Log {
+	if clk == nil {
+		clk = clock.System{}
+	}
+	return &Log{sink: sink, clock: clk}
+}
+
+type actorKey struct{}
+
+// WithActor returns ctx carrying the actor of the actions taken for it
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor ctx carries
+func ActorFrom(ctx context.Context) (Actor, bool) {
+	actor, ok := ctx.Value(actorKey{}).(Actor)
+	return actor, ok && actor.ID != ""
+}
+
+// Record completes rec and writes it. The actor is the one ctx carries,
+// else rec.Actor, else SystemActor. The write is detached from ctx, so a
+// change that was made is recorded even if the client has gone away; a
+// failure is logged, as the change cannot be undone.
+func (l *Log) Record(ctx context.Context, rec Record) {
+	if l == nil {
+		return
+	}
+	if actor, ok := ActorFrom(ctx); ok {
+		rec.Actor = actor
+	} else if rec.Actor.ID == "" {
+		rec.Actor.ID = SystemActor
+	}
+	rec.ID = uuid.New().String()
+	rec.At = l.clock.Now().UTC()
+	rec.RequestID = requestid.FromContext(ctx)
+
+	ctx, cancel := context.WithTimeout(requestid.Detach(ctx), writeTimeout)
+	defer cancel()
+	if err := l.sink.Write(ctx, rec); err != nil {
+		log.Ctx(ctx).Error().Err(err).
+			Str("action", rec.Action).
+			Str("resource_type", rec.Resource.Type).
+			Str("resource_id", rec.Resource.ID).
+			Str("actor", rec.Actor.ID).
+			Msg("Failed to write audit record")
+	}
+}
+
+// Close closes the sink
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
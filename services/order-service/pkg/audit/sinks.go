@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// FileSink appends records to a file as JSON lines, synced to disk before
+// Write returns
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens path for appending, creating it readable only by the
+// service's user
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Write appends rec
+func (s *FileSink) Write(ctx context.Context, rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// KafkaSink writes records to a Kafka topic, keyed by resource so the
+// records of one order stay in order on a partition
+type KafkaSink struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaSink returns a sink writing to topic on brokers. Writes are
+// acknowledged by every in-sync replica before Write returns.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 5 * time.Millisecond,
+	}}
+}
+
+// Write sends rec
+func (s *KafkaSink) Write(ctx context.Context, rec Record) error {
+	value, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(rec.Resource.Type + "/" + rec.Resource.ID),
+		Value: value,
+	})
+}
+
+// Close flushes pending writes
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+// CollectionSink inserts records into a MongoDB collection. It never
+// updates or deletes them; granting the service's user only insert and
+// find on the collection makes it append-only for everyone else too.
+type CollectionSink struct {
+	collection *mongo.Collection
+}
+
+// NewCollectionSink returns a sink inserting into collection
+func NewCollectionSink(collection *mongo.Collection) *CollectionSink {
+	return &CollectionSink{collection: collection}
+}
+
+// Write inserts rec
+func (s *CollectionSink) Write(ctx context.Context, rec Record) error {
+	_, err := s.collection.InsertOne(ctx, rec)
+	return err
+}
+
+// Close does nothing; the client is disconnected with the others
+func (s *CollectionSink) Close() error {
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package backup snapshots collections to an object store and restores
+// them into a database, for disaster recovery and for drills restoring into
+// a scratch database. A backup is a set of gzipped NDJSON parts of
+// canonical extended JSON, so every field and BSON type survives, written
+// under its ID along with a manifest. The manifest is written last, so a
+// backup without one is incomplete and cannot be restored. Documents are
+// read as they are while the backup runs; it is not a point-in-time
+// snapshot of writes made meanwhile.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/objectstore"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultPrefix is where backups are written in the store unless
+// configured otherwise
+const DefaultPrefix = "backups/"
+
+// manifestName is the object completing a backup
+const manifestName = "manifest.json"
+
+// maxLine bounds one document, well above MongoDB's 16MB documents once
+// written as extended JSON
+const maxLine = 64 << 20
+
+// ErrNotFound is returned for backups without a manifest
+var ErrNotFound = errors.New("backup not found or incomplete")
+
+// ObjectStore holds the backups; it is implemented by *objectstore.S3, and
+// Get fails with objectstore.ErrNotFound for missing objects
+type ObjectStore interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Manifest describes a complete backup
+type Manifest struct {
+	ID          string       `json:"id"`
+	Database    string       `json:"database"`
+	CreatedAt   time.Time    `json:"created_at"`
+	CompletedAt time.Time    `json:"completed_at"`
+	Collections []Collection `json:"collections"`
+}
+
+// Collection is one backed up collection and the objects holding it
+type Collection struct {
+	Name      string   `json:"name"`
+	Documents int64    `json:"documents"`
+	Parts     []string `json:"parts"`
+}
+
+// Progress is reported as every part of a collection is backed up or
+// restored. Total is estimated while backing up.
+type Progress struct {
+	Backup     string
+	Collection string
+	Done       int64
+	Total      int64
+}
+
+// Backups writes and restores backups in a store
+type Backups struct {
+	store ObjectStore
+
+	// Prefix is prepended to the keys of every backup
+	Prefix string
+	// PartSize is how many documents each part holds
+	PartSize int
+	// Progress, when set, is called after every part
+	Progress func(Progress)
+	Clock    clock.Clock
+}
+
+// New returns the backups in store
+func New(store ObjectStore) *Backups {
+	return &Backups{store: store, Prefix: DefaultPrefix, PartSize: 10000, Clock: clock.System{}}
+}
+
+// Create backs up the named collections of db under a new ID, the time it
+// started
+func (b *Backups) Create(ctx context.Context, db *mongo.Database, collections ...string) (Manifest, error) {
+	now := b.Clock.Now().UTC()
+	manifest := Manifest{ID: now.Format("20060102T150405Z"), Database: db.Name(), CreatedAt: now}
+	for _, name := range collections {
+		collection, err := b.backupCollection(ctx, manifest.ID, db.Collection(name))
+		if err != nil {
+			return Manifest{}, fmt.Errorf("back up %s: %w", name, err)
+		}
+		manifest.Collections = append(manifest.Collections, collection)
+	}
+
+	manifest.CompletedAt = b.Clock.Now().UTC()
+	body, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return Manifest{}, err
+	}
+	if err := b.store.Put(ctx, b.key(manifest.ID, manifestName), body, "application/json"); err != nil {
+		return Manifest{}, fmt.Errorf("write backup manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// backupCollection writes the documents of collection in parts of PartSize
+func (b *Backups) backupCollection(ctx context.Context, id string, collection *mongo.Collection) (Collection, error) {
+	result := Collection{Name: collection.Name(), Parts: []string{}}
+	total, err := collection.EstimatedDocumentCount(ctx)
+	if err != nil {
+		return result, err
+	}
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return result, err
+	}
+	defer cursor.Close(ctx)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	inPart := 0
+	flush := func() error {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		key := b.key(id, fmt.Sprintf("%s-%05d.ndjson.gz", result.Name, len(result.Parts)+1))
+		if err := b.store.Put(ctx, key, buf.Bytes(), "application/x-ndjson"); err != nil {
+			return err
+		}
+		result.Parts = append(result.Parts, key)
+		buf.Reset()
+		zw.Reset(&buf)
+		inPart = 0
+		b.report(Progress{Backup: id, Collection: result.Name, Done: result.Documents, Total: total})
+		return nil
+	}
+
+	for cursor.Next(ctx) {
+		line, err := bson.MarshalExtJSON(cursor.Current, true, false)
+		if err != nil {
+			return result, err
+		}
+		zw.Write(append(line, '\n'))
+		result.Documents++
+		if inPart++; inPart == b.PartSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return result, err
+	}
+	if inPart > 0 || len(result.Parts) == 0 {
+		// An empty collection still gets a part, so it is restored empty
+		if err := flush(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Manifest reads the manifest of backup id
+func (b *Backups) Manifest(ctx context.Context, id string) (Manifest, error) {
+	body, err := b.store.Get(ctx, b.key(id, manifestName))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return Manifest{}, fmt.Errorf("backup %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return Manifest{}, fmt.Errorf("read manifest of backup %s: %w", id, err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("decode manifest of backup %s: %w", id, err)
+	}
+	return manifest, nil
+}
+
+// Restore writes the collections of backup id into db, refusing to restore
+// into a collection holding documents unless drop is set, in which case the
+// collection is dropped first. Indexes are not part of a backup; the
+// service creates them as it starts on the restored database.
+func (b *Backups) Restore(ctx context.Context, id string, db *mongo.Database, drop bool) (Manifest, error) {
+	manifest, err := b.Manifest(ctx, id)
+	if err != nil {
+		return Manifest{}, err
+	}
+	for _, c := range manifest.Collections {
+		target := db.Collection(c.Name)
+		if drop {
+			if err := target.Drop(ctx); err != nil {
+				return manifest, fmt.Errorf("drop %s: %w", c.Name, err)
+			}
+		} else if n, err := target.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1)); err != nil {
+			return manifest, err
+		} else if n > 0 {
+			return manifest, fmt.Errorf("%s.%s holds documents; restore with drop to replace them", db.Name(), c.Name)
+		}
+	}
+
+	for _, c := range manifest.Collections {
+		if err := b.restoreCollection(ctx, id, c, db.Collection(c.Name)); err != nil {
+			return manifest, fmt.Errorf("restore %s: %w", c.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// restoreCollection inserts the documents of every part of c into target
+// and checks none is missing
+func (b *Backups) restoreCollection(ctx context.Context, id string, c Collection, target *mongo.Collection) error {
+	var restored int64
+	for _, key := range c.Parts {
+		docs, err := b.part(ctx, key)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			if _, err := target.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false)); err != nil {
+				return err
+			}
+		}
+		restored += int64(len(docs))
+		b.report(Progress{Backup: id, Collection: c.Name, Done: restored, Total: c.Documents})
+	}
+	if restored != c.Documents {
+		return fmt.Errorf("restored %d documents of the %d backed up", restored, c.Documents)
+	}
+	return nil
+}
+
+// part reads the documents of the part under key, keeping their field order
+func (b *Backups) part(ctx context.Context, key string) ([]interface{}, error) {
+	body, err := b.store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("read backup part %s: %w", key, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("read backup part %s: %w", key, err)
+	}
+	var docs []interface{}
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(nil, maxLine)
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), true, &doc); err != nil {
+			return nil, fmt.Errorf("decode backup part %s: %w", key, err)
+		}
+		docs = append(docs, doc)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read backup part %s: %w", key, err)
+	}
+	return docs, nil
+}
+
+func (b *Backups) key(id, name string) string {
+	return b.Prefix + id + "/" + name
+}
+
+func (b *Backups) report(p Progress) {
+	if b.Progress != nil {
+		b.Progress(p)
+	}
+}
@@ -0,0 +1,209 @@
+// Package breaker provides the circuit breakers guarding calls to the
+// service's dependencies, so one that fails or slows down is not waited on
+// by every request: after enough consecutive failures calls fail fast
+// until a cooldown passes, then a single probe decides whether to close
+// again.
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrOpen is returned without calling the dependency while the breaker is
+// open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is the ErrOpen of a Guard, telling when the breaker lets a
+// probe through again
+type OpenError struct {
+	Breaker    string
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return e.Breaker + ": " + ErrOpen.Error()
+}
+
+// Is matches ErrOpen
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Breaker states, also exported as the circuit_breaker_state gauge value
+const (
+	StateClosed   = 0
+	StateHalfOpen = 1
+	StateOpen     = 2
+)
+
+var (
+	stateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Circuit breaker state per dependency (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"breaker"},
+	)
+	rejectedTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "circuit_breaker_rejected_total",
+			Help: "Total number of calls rejected by an open circuit breaker",
+		},
+		[]string{"breaker"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(stateGauge)
+	prometheus.MustRegister(rejectedTotal)
+}
+
+// Breaker opens after Threshold consecutive failures, rejects calls for
+// Cooldown, then lets a single probe through (half-open) to decide whether
+// to close again. It is safe for concurrent use.
+type Breaker struct {
+	name      string
+	threshold int
+	cooldown  time.Duration
+	clock     clock.Clock
+
+	mu       sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// New returns a closed breaker named for its dependency in metrics; a
+// threshold of 0 or less never opens, and nil clk is the system clock
+func New(name string, threshold int, cooldown time.Duration, clk clock.Clock) *Breaker {
+	if clk == nil {
+		clk = clock.System{}
+	}
+	stateGauge.WithLabelValues(name).Set(StateClosed)
+	return &Breaker{name: name, threshold: threshold, cooldown: cooldown, clock: clk}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be
+// followed by Record with its outcome.
+func (b *Breaker) Allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	allowed := true
+	switch b.state {
+	case StateOpen:
+		if b.clock.Now().Sub(b.openedAt) < b.cooldown {
+			allowed = false
+			break
+		}
+		b.setState(StateHalfOpen)
+		b.probing = true
+	case StateHalfOpen:
+		if b.probing {
+			allowed = false
+			break
+		}
+		b.probing = true
+	}
+	if !allowed {
+		rejectedTotal.WithLabelValues(b.name).Inc()
+	}
+	return allowed
+}
+
+// Record updates the breaker with the outcome of a call and returns the
+// new state
+func (b *Breaker) Record(success bool) int {
+	if b.threshold <= 0 {
+		return StateClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.failures = 0
+		b.setState(StateClosed)
+		return b.state
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+		b.setState(StateOpen)
+	}
+	return b.state
+}
+
+// State returns the current state
+func (b *Breaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RetryAfter is how long until an open breaker lets a probe through
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != StateOpen {
+		return 0
+	}
+	if wait := b.cooldown - b.clock.Now().Sub(b.openedAt); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// setState changes the state; the caller holds mu
+func (b *Breaker) setState(state int) {
+	b.state = state
+	stateGauge.WithLabelValues(b.name).Set(float64(state))
+}
+
+// Guard runs calls through a breaker, counting as failures the errors
+// Failure reports and calls taking longer than Slow
+type Guard struct {
+	Breaker *Breaker
+	// Failure reports whether err means the dependency failed, as opposed
+	// to a call it rightly refused, such as for a missing record
+	Failure func(err error) bool
+	// Slow is how long a call may take before it counts as a failure even
+	// though it succeeded; 0 never does
+	Slow time.Duration
+}
+
+// Call runs fn unless the breaker is open, which fails with an
+// *OpenError
+func (g Guard) Call(fn func() error) error {
+	if !g.Breaker.Allow() {
+		return &OpenError{Breaker: g.Breaker.name, RetryAfter: g.Breaker.RetryAfter()}
+	}
+	// A panicking call counts as failed, so a probe cannot leave the
+	// breaker half-open for good
+	recorded := false
+	defer func() {
+		if !recorded {
+			g.Breaker.Record(false)
+		}
+	}()
+
+	start := g.Breaker.clock.Now()
+	err := fn()
+	slow := g.Slow > 0 && g.Breaker.clock.Now().Sub(start) > g.Slow
+	g.Breaker.Record(!slow && (err == nil || !g.Failure(err)))
+	recorded = true
+	return err
+}
@@ -0,0 +1,293 @@
+// Package cdc derives order events from the change stream of an orders
+// collection, for deployments preferring change data capture to events
+// recorded by the service as it writes: every committed change to an order
+// is stored and published, whichever process made it. The resume token of
+// the last change handled is persisted, so a restarted watcher carries on
+// where it stopped; a change whose publication was interrupted is handled
+// again, under the same event ID. One replica at a time watches each
+// collection, holding a lease on its checkpoint.
+package cdc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CheckpointsCollection holds the resume token and lease of every watcher
+const CheckpointsCollection = "cdc_checkpoints"
+
+// errLeaseLost stops a watcher whose lease another replica has taken
+var errLeaseLost = errors.New("change stream lease lost")
+
+// Server error codes of a resume token the oplog no longer reaches
+var historyLostCodes = []int{280, 286}
+
+type checkpoint struct {
+	Name       string    `bson:"_id"`
+	Token      bson.Raw  `bson:"token,omitempty"`
+	Lease      string    `bson:"lease"`
+	LeaseUntil time.Time `bson:"lease_until"`
+	UpdatedAt  time.Time `bson:"updated_at"`
+}
+
+// change is the part of a change event the watcher reads
+type change struct {
+	Token             bson.Raw         `bson:"_id"`
+	OperationType     string           `bson:"operationType"`
+	FullDocument      *contracts.Order `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.M `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Watcher turns the changes of one orders collection into order events
+type Watcher struct {
+	orders      *mongo.Collection
+	checkpoints *mongo.Collection
+	store       events.Log
+	publisher   events.Publisher
+	name        string
+	lease       string
+
+	// Lease is how long the watching replica may go without renewing its
+	// lease before another one takes over
+	Lease time.Duration
+	Clock clock.Clock
+}
+
+// NewWatcher returns a watcher of orders storing its events in store, when
+// not nil, and publishing them to publisher. Its checkpoint is kept in
+// checkpoints under the collection's full name.
+func NewWatcher(orders, checkpoints *mongo.Collection, store events.Log, publisher events.Publisher) *Watcher {
+	return &Watcher{
+		orders:      orders,
+		checkpoints: checkpoints,
+		store:       store,
+		publisher:   publisher,
+		name:        orders.Database().Name() + "." + orders.Name(),
+		lease:       uuid.New().String(),
+		Lease:       30 * time.Second,
+		Clock:       clock.System{},
+	}
+}
+
+// Run watches the collection until ctx is done while this replica holds
+// the lease, trying to take it every interval otherwise and after failures
+func (w *Watcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		token, leased, err := w.claim(ctx)
+		switch {
+		case err != nil:
+			log.Error().Err(err).Str("collection", w.name).Msg("Failed to claim the change stream lease")
+		case leased:
+			log.Info().Str("collection", w.name).Bool("resumed", token != nil).Msg("Watching order changes")
+			err = w.watch(ctx, token)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Str("collection", w.name).Msg("Order change stream stopped")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			w.release()
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// claim takes the lease if it is free or already held by this replica, and
+// returns the resume token it was left with
+func (w *Watcher) claim(ctx context.Context) (bson.Raw, bool, error) {
+	now := w.Clock.Now()
+	filter := bson.M{"_id": w.name, "$or": bson.A{
+		bson.M{"lease": w.lease},
+		bson.M{"lease_until": bson.M{"$lt": now}},
+	}}
+	update := bson.M{"$set": bson.M{"lease": w.lease, "lease_until": now.Add(w.Lease)}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var cp checkpoint
+	err := w.checkpoints.FindOneAndUpdate(ctx, filter, update, opts).Decode(&cp)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica holds the lease
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return cp.Token, true, nil
+}
+
+// save records token and renews the lease, failing with errLeaseLost if
+// another replica has taken it
+func (w *Watcher) save(ctx context.Context, token bson.Raw) error {
+	now := w.Clock.Now()
+	set := bson.M{"lease_until": now.Add(w.Lease), "updated_at": now}
+	if token != nil {
+		set["token"] = token
+	}
+	result, err := w.checkpoints.UpdateOne(ctx, bson.M{"_id": w.name, "lease": w.lease}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errLeaseLost
+	}
+	return nil
+}
+
+// release gives the lease up so another replica takes over at once
+func (w *Watcher) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := w.checkpoints.UpdateOne(ctx, bson.M{"_id": w.name, "lease": w.lease}, bson.M{"$set": bson.M{"lease_until": time.Time{}}})
+	if err != nil {
+		log.Warn().Err(err).Str("collection", w.name).Msg("Failed to release the change stream lease")
+	}
+}
+
+// watch handles changes after token, or from now without one, until ctx is
+// done, the stream fails or the lease is lost
+func (w *Watcher) watch(ctx context.Context, token bson.Raw) error {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update"}}}}}}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup).SetMaxAwaitTime(time.Second)
+	if token != nil {
+		opts.SetResumeAfter(token)
+	}
+	stream, err := w.orders.Watch(ctx, pipeline, opts)
+	if historyLost(err) {
+		log.Error().Err(err).Str("collection", w.name).Msg("Order changes since the last checkpoint are no longer in the oplog; watching from now, changes in between were not published")
+		stream, err = w.orders.Watch(ctx, pipeline, opts.SetResumeAfter(nil))
+	}
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	renewed := w.Clock.Now()
+	for {
+		if stream.TryNext(ctx) {
+			var c change
+			if err := stream.Decode(&c); err != nil {
+				return fmt.Errorf("decode order change: %w", err)
+			}
+			if err := w.handle(ctx, c); err != nil {
+				return err
+			}
+			if err := w.save(ctx, stream.ResumeToken()); err != nil {
+				return err
+			}
+			renewed = w.Clock.Now()
+			continue
+		}
+		if err := stream.Err(); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		// Idle: renew the lease, moving the token past changes filtered out
+		if w.Clock.Now().Sub(renewed) >= w.Lease/3 {
+			if err := w.save(ctx, stream.ResumeToken()); err != nil {
+				return err
+			}
+			renewed = w.Clock.Now()
+		}
+	}
+}
+
+// handle stores and publishes the events of one change
+func (w *Watcher) handle(ctx context.Context, c change) error {
+	if c.FullDocument == nil {
+		// The order was removed before its change was read; its removal is
+		// not an order event
+		return nil
+	}
+	order := *c.FullDocument
+	for i, eventType := range EventTypes(c.OperationType, c.UpdateDescription.UpdatedFields) {
+		event := events.NewEvent(eventType, order, order.UpdatedAt)
+		// Handling the change again yields the same IDs, so consumers can
+		// drop the duplicates
+		seed := append(append([]byte{}, c.Token...), byte(i))
+		event.EventID = uuid.NewSHA1(uuid.NameSpaceOID, seed).String()
+		if eventType == contracts.EventOrderStatusChanged && len(order.StatusHistory) > 0 {
+			event.PreviousStatus = order.StatusHistory[len(order.StatusHistory)-1].From
+		}
+
+		if w.store != nil {
+			if err := w.store.Append(ctx, event); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("persist %s event of order %s: %w", eventType, order.OrderID, err)
+			}
+		}
+		if err := w.publisher.Publish(ctx, event, events.Headers(ctx, eventType)); err != nil {
+			return fmt.Errorf("publish %s event of order %s: %w", eventType, order.OrderID, err)
+		}
+	}
+	return nil
+}
+
+// EventTypes returns the order events of a change to an order document:
+// an insert creates the order, and an update is told apart by the fields
+// it set. Updates setting none of them, such as schema migrations, are no
+// order event.
+func EventTypes(operationType string, updatedFields bson.M) []string {
+	if operationType == "insert" {
+		return []string{contracts.EventOrderCreated}
+	}
+	updated := func(field string) bool {
+		for name := range updatedFields {
+			if name == field || strings.HasPrefix(name, field+".") {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case updated("deleted_at"):
+		return []string{contracts.EventOrderDeleted}
+	case updated("items"):
+		return []string{contracts.EventOrderItemsChanged}
+	case updated("shipments"):
+		if updated("status") {
+			return []string{contracts.EventOrderShipmentsChanged, contracts.EventOrderStatusChanged}
+		}
+		return []string{contracts.EventOrderShipmentsChanged}
+	case updated("status"):
+		return []string{contracts.EventOrderStatusChanged}
+	case updated("priority"):
+		return []string{contracts.EventOrderPriorityChanged}
+	case updated("notes"):
+		return []string{contracts.EventOrderNoteAdded}
+	}
+	return nil
+}
+
+// historyLost reports whether err is the server's refusal to resume from a
+// token the oplog no longer reaches
+func historyLost(err error) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range historyLostCodes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+// Package clock abstracts the current time so time-dependent logic can be
+// exercised with a controllable clock.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock reports the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the wall clock. Times are returned in UTC and truncated to the
+// millisecond precision MongoDB stores, so a value read back compares equal
+// to the one that was written.
+type System struct{}
+
+// Now returns the current UTC time
+func (System) Now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// Fake is a manually driven clock. It is safe for concurrent use.
+type Fake struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a fake clock stopped at t
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t.UTC()}
+}
+
+// Now returns the fake's current time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Set moves the clock to t
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	f.now = t.UTC()
+	f.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
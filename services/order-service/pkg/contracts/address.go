@@ -0,0 +1,97 @@
+package contracts
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Address is where an order is shipped. Country is an ISO 3166-1 alpha-2
+// code; Region is the state, province or county where the country has them.
+type Address struct {
+	Name       string `json:"name" bson:"name" binding:"max=200"`
+	Line1      string `json:"line1" bson:"line1" binding:"max=200"`
+	Line2      string `json:"line2,omitempty" bson:"line2,omitempty" binding:"max=200"`
+	City       string `json:"city" bson:"city" binding:"max=100"`
+	Region     string `json:"region,omitempty" bson:"region,omitempty" binding:"max=100"`
+	PostalCode string `json:"postal_code,omitempty" bson:"postal_code,omitempty" binding:"max=20"`
+	Country    string `json:"country" bson:"country"`
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// postalCodes are the postal code formats of the countries whose codes are
+// checked; addresses in them need a postal code. Codes elsewhere are taken
+// as given.
+var postalCodes = map[string]*regexp.Regexp{
+	"AT": regexp.MustCompile(`^\d{4}$`),
+	"AU": regexp.MustCompile(`^\d{4}$`),
+	"BE": regexp.MustCompile(`^\d{4}$`),
+	"BR": regexp.MustCompile(`^\d{5}-?\d{3}$`),
+	"CA": regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`),
+	"CH": regexp.MustCompile(`^\d{4}$`),
+	"DE": regexp.MustCompile(`^\d{5}$`),
+	"DK": regexp.MustCompile(`^\d{4}$`),
+	"ES": regexp.MustCompile(`^\d{5}$`),
+	"FR": regexp.MustCompile(`^\d{5}$`),
+	"GB": regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`),
+	"IN": regexp.MustCompile(`^\d{6}$`),
+	"IT": regexp.MustCompile(`^\d{5}$`),
+	"JP": regexp.MustCompile(`^\d{3}-?\d{4}$`),
+	"NL": regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`),
+	"NO": regexp.MustCompile(`^\d{4}$`),
+	"NZ": regexp.MustCompile(`^\d{4}$`),
+	"PL": regexp.MustCompile(`^\d{2}-\d{3}$`),
+	"SE": regexp.MustCompile(`^\d{3} ?\d{2}$`),
+	"US": regexp.MustCompile(`^\d{5}(-\d{4})?$`),
+}
+
+// Normalize trims every field and upper-cases the country and postal code
+func (a Address) Normalize() Address {
+	return Address{
+		Name:       strings.TrimSpace(a.Name),
+		Line1:      strings.TrimSpace(a.Line1),
+		Line2:      strings.TrimSpace(a.Line2),
+		City:       strings.TrimSpace(a.City),
+		Region:     strings.TrimSpace(a.Region),
+		PostalCode: strings.ToUpper(strings.TrimSpace(a.PostalCode)),
+		Country:    strings.ToUpper(strings.TrimSpace(a.Country)),
+	}
+}
+
+// ValidateAddress checks a normalized address: a name, first line, city and
+// country are required, the country must be a two-letter code and, where
+// its format is known, the postal code must match it. field names the
+// request field holding the address. It returns a *ValidationError listing
+// every violation, or nil.
+func ValidateAddress(field string, a Address) error {
+	verr := &ValidationError{}
+
+	if a.Name == "" {
+		verr.add(field+".name", "is required")
+	}
+	if a.Line1 == "" {
+		verr.add(field+".line1", "is required")
+	}
+	if a.City == "" {
+		verr.add(field+".city", "is required")
+	}
+	switch {
+	case a.Country == "":
+		verr.add(field+".country", "is required")
+	case !countryCode.MatchString(a.Country):
+		verr.add(field+".country", "must be a two-letter ISO 3166-1 code")
+	default:
+		if format, ok := postalCodes[a.Country]; ok {
+			if a.PostalCode == "" {
+				verr.add(field+".postal_code", "is required in %s", a.Country)
+			} else if !format.MatchString(a.PostalCode) {
+				verr.add(field+".postal_code", "is not a valid postal code in %s", a.Country)
+			}
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
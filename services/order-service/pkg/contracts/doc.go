@@ -0,0 +1,18 @@
+// Package contracts holds the canonical order types and event payloads shared
+// by every service, in both JSON/BSON (Go structs) and protobuf (ordersv1)
+// form. Services import these instead of declaring their own copies.
+//
+// Contracts are versioned: additive changes are made in place and bump
+// nothing; breaking changes require a new protobuf package (orders.v2) and a
+// new SchemaVersion so consumers can tell payloads apart.
+//
+// v2 replaced float64 amounts with money.Money ({"amount":"12.99",
+// "currency":"USD"} in JSON, minor units in BSON and protobuf). ordersv1 is
+// kept, frozen, for consumers that have not migrated yet.
+package contracts
+
+//go:generate protoc -I proto --go_out=../.. --go_opt=module=order-service --go-grpc_out=../.. --go-grpc_opt=module=order-service orders/v1/orders.proto orders/v2/orders.proto orders/v2/order_service.proto
+
+// SchemaVersion identifies the wire format of the types in this package and
+// is stamped on every published event
+const SchemaVersion = "v2"
@@ -0,0 +1,37 @@
+package contracts
+
+import "time"
+
+// Order event types
+const (
+	EventOrderCreated       = "order.created"
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderItemsChanged  = "order.items_changed"
+	EventOrderNoteAdded     = "order.note_added"
+	EventOrderDeleted       = "order.deleted"
+	// EventOrderShipmentsChanged is published when a shipment is added or
+	// updated, before the status change of shipments moving the order
+	EventOrderShipmentsChanged = "order.shipments_changed"
+	// EventOrderPriorityChanged is published when an admin changes the
+	// priority of an order
+	EventOrderPriorityChanged = "order.priority_changed"
+	// EventOrderCancelled is published to the message bus alongside the
+	// status change of every cancellation, for consumers that only follow
+	// cancellations. It is never stored.
+	EventOrderCancelled = "order.cancelled"
+	// EventOrderExpired follows the status change of an order cancelled
+	// because it stayed pending too long
+	EventOrderExpired = "order.expired"
+)
+
+// Event represents an order lifecycle event published to the bus
+type Event struct {
+	EventID        string    `json:"event_id" bson:"event_id"`
+	Type           string    `json:"type" bson:"type"`
+	SchemaVersion  string    `json:"schema_version" bson:"schema_version"`
+	OrderID        string    `json:"order_id" bson:"order_id"`
+	UserID         string    `json:"user_id" bson:"user_id"`
+	PreviousStatus string    `json:"previous_status,omitempty" bson:"previous_status,omitempty"`
+	Order          Order     `json:"order" bson:"order"`
+	OccurredAt     time.Time `json:"occurred_at" bson:"occurred_at"`
+}
@@ -0,0 +1,49 @@
+package contracts
+
+import (
+	"errors"
+	"time"
+
+	"order-service/pkg/money"
+)
+
+// ErrNotEditable is returned when the items of an order that is no longer
+// pending are changed
+var ErrNotEditable = errors.New("order items can only be changed while the order is pending")
+
+// ItemsChange replaces the line items of a pending order, along with the
+// totals recomputed from them
+type ItemsChange struct {
+	Items    []OrderItem
+	Subtotal money.Money
+	Charges  Charges
+	Total    money.Money
+	// Settlement is the new total normalized to the settlement currency
+	Settlement *money.Money
+	At         time.Time
+	// Base is the UpdatedAt of the order the new items were computed from.
+	// The change is rejected if the order was modified since.
+	Base time.Time
+}
+
+// Apply checks that the order can still be edited and applies the change
+// to it. It does not compare Base; repositories do so atomically.
+func (c ItemsChange) Apply(order *Order) error {
+	if order.Status != StatusPending {
+		return ErrNotEditable
+	}
+	c.Set(order)
+	return nil
+}
+
+// Set applies the change without checking it
+func (c ItemsChange) Set(order *Order) {
+	order.Items = c.Items
+	order.Subtotal = c.Subtotal
+	order.TaxAmount = c.Charges.Tax
+	order.ShippingAmount = c.Charges.Shipping
+	order.DiscountAmount = c.Charges.Discount
+	order.TotalAmount = c.Total
+	order.SettlementTotal = c.Settlement
+	order.UpdatedAt = c.At
+}
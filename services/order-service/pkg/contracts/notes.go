@@ -0,0 +1,51 @@
+package contracts
+
+import (
+	"errors"
+	"time"
+)
+
+// MaxNotes bounds the notes one order keeps, so follow-up comments cannot
+// grow an order document without limit
+const MaxNotes = 50
+
+// ErrTooManyNotes is returned when a note is added to an order that already
+// has MaxNotes
+var ErrTooManyNotes = errors.New("order has the maximum number of notes")
+
+// Note is a comment the customer left on their order
+type Note struct {
+	Text     string    `json:"text" bson:"text"`
+	AuthorID string    `json:"author_id" bson:"author_id"`
+	At       time.Time `json:"at" bson:"at"`
+}
+
+// NoteChange appends a note to an order
+type NoteChange struct {
+	Note Note
+	// Base is the UpdatedAt the order must still have; zero adds the note
+	// whatever changed since
+	Base time.Time
+}
+
+// Apply checks that the order has room for the note and appends it. It does
+// not compare Base; repositories do so atomically.
+func (c NoteChange) Apply(order *Order) error {
+	if len(order.Notes) >= MaxNotes {
+		return ErrTooManyNotes
+	}
+	c.Set(order)
+	return nil
+}
+
+// Set appends the note without checking it
+func (c NoteChange) Set(order *Order) {
+	order.Notes = append(order.Notes, c.Note)
+	order.UpdatedAt = c.Note.At
+}
+
+// AddNoteRequest represents the request payload for adding a note to an
+// order
+type AddNoteRequest struct {
+	Text string `json:"text" binding:"required,max=1000"`
+}
@@ -0,0 +1,150 @@
+package contracts
+
+import (
+	"time"
+
+	"order-service/pkg/money"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order represents a customer order
+type Order struct {
+	ID      primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OrderID string             `json:"order_id" bson:"order_id"`
+	UserID  string             `json:"user_id" bson:"user_id"`
+	// TenantID is the tenant the order belongs to in multi-tenant
+	// deployments; empty otherwise
+	TenantID    string      `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Items       []OrderItem `json:"items" bson:"items"`
+	TotalAmount money.Money `json:"total_amount" bson:"total_amount"`
+	Status      string      `json:"status" bson:"status"`
+	CreatedAt   time.Time   `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at" bson:"updated_at"`
+	// Subtotal is the sum of the line items. TotalAmount is the grand total:
+	// the subtotal plus TaxAmount and ShippingAmount, less DiscountAmount.
+	// Orders placed before the breakdown existed have only a total; see
+	// BackfillTotals.
+	Subtotal       money.Money `json:"subtotal" bson:"subtotal"`
+	TaxAmount      money.Money `json:"tax_amount" bson:"tax_amount"`
+	ShippingAmount money.Money `json:"shipping_amount" bson:"shipping_amount"`
+	DiscountAmount money.Money `json:"discount_amount" bson:"discount_amount"`
+	// SettlementTotal is TotalAmount normalized to the settlement currency
+	// at the rate of the time the order was priced; nil when no settlement
+	// currency is configured
+	SettlementTotal *money.Money `json:"settlement_total,omitempty" bson:"settlement_total,omitempty"`
+	// Promotion is the promotion code applied when the order was placed;
+	// DiscountAmount is what it takes off
+	Promotion *AppliedPromotion `json:"promotion,omitempty" bson:"promotion,omitempty"`
+	// GuestEmail is how to reach a customer who checked out without an
+	// account; empty on orders of registered users
+	GuestEmail string `json:"guest_email,omitempty" bson:"guest_email,omitempty"`
+	// LegacyID is the order's ID in the system it was imported from
+	LegacyID string `json:"legacy_id,omitempty" bson:"legacy_id,omitempty"`
+	// CancelledAt and CancellationReason are recorded when the order is
+	// cancelled
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty" bson:"cancelled_at,omitempty"`
+	CancellationReason string     `json:"cancellation_reason,omitempty" bson:"cancellation_reason,omitempty"`
+	// Return is the customer's return request and how it was resolved,
+	// once one was made
+	Return *Return `json:"return,omitempty" bson:"return,omitempty"`
+	// ShippingAddress is where the order is shipped; orders placed before
+	// addresses were captured have none
+	ShippingAddress *Address `json:"shipping_address,omitempty" bson:"shipping_address,omitempty"`
+	// Shipments split the order's items into parcels shipped on their own;
+	// orders shipped whole have none
+	Shipments []Shipment `json:"shipments,omitempty" bson:"shipments,omitempty"`
+	// Priority is how urgently the order is fulfilled; empty on orders
+	// placed before priorities existed, which are standard. PriorityRank
+	// mirrors it so orders can be sorted by urgency.
+	Priority     string `json:"priority,omitempty" bson:"priority,omitempty"`
+	PriorityRank int    `json:"-" bson:"priority_rank,omitempty"`
+	// IsGift orders are gift wrapped, carry GiftMessage for the recipient
+	// and have packing slips without prices
+	IsGift      bool   `json:"is_gift,omitempty" bson:"is_gift,omitempty"`
+	GiftMessage string `json:"gift_message,omitempty" bson:"gift_message,omitempty"`
+	// Notes are the customer's comments on the order, oldest first
+	Notes []Note `json:"notes,omitempty" bson:"notes,omitempty"`
+	// StatusHistory records every status change, oldest first
+	StatusHistory []StatusHistoryEntry `json:"status_history,omitempty" bson:"status_history,omitempty"`
+	// Region is the home region the order was created in, and Versions
+	// counts writes per region so copies diverging between regions can be
+	// reconciled
+	Region   string           `json:"region,omitempty" bson:"region,omitempty"`
+	Versions map[string]int64 `json:"-" bson:"versions,omitempty"`
+	// DeletedAt is set when the order is soft-deleted; deleted orders are
+	// left out of every read until the archiver moves them away
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	// AnonymizedAt is set once the order's personal data has been replaced
+	// with tokens after the retention window
+	AnonymizedAt *time.Time `json:"anonymized_at,omitempty" bson:"anonymized_at,omitempty"`
+	// SchemaVersion is the version of the stored document's layout, see
+	// OrderSchema; orders are always read and written at the current one
+	SchemaVersion int `json:"-" bson:"schema_version,omitempty"`
+}
+
+// OrderItem represents an item in an order
+type OrderItem struct {
+	ProductID string      `json:"product_id" bson:"product_id"`
+	Name      string      `json:"name" bson:"name"`
+	SKU       string      `json:"sku,omitempty" bson:"sku,omitempty"`
+	Price     money.Money `json:"price" bson:"price"`
+	Quantity  int         `json:"quantity" bson:"quantity"`
+}
+
+// CreateOrderRequest represents the request payload for creating an order
+type CreateOrderRequest struct {
+	Items []OrderItem `json:"items" binding:"required"`
+	// Currency is the ISO 4217 code to price the order in; item prices in
+	// other currencies are converted. Empty uses the first item's currency.
+	Currency string `json:"currency,omitempty"`
+	// CouponCode is an optional promotion code to apply
+	CouponCode string `json:"coupon_code,omitempty"`
+	// ShippingAddress is where to ship the order
+	ShippingAddress *Address `json:"shipping_address,omitempty"`
+	// Notes is an optional comment recorded as the order's first note
+	Notes string `json:"notes,omitempty" binding:"max=1000"`
+	// Priority is standard, expedited or rush; empty is standard
+	Priority string `json:"priority,omitempty"`
+	// IsGift marks the order as a gift; GiftMessage is only accepted on
+	// gifts
+	IsGift      bool   `json:"is_gift,omitempty"`
+	GiftMessage string `json:"gift_message,omitempty" binding:"max=500"`
+	// GuestEmail is set by guest checkout from GuestOrderRequest.Email and
+	// is never read from a request body
+	GuestEmail string `json:"-"`
+}
+
+// GuestOrderRequest represents the request payload for checking out
+// without an account
+type GuestOrderRequest struct {
+	CreateOrderRequest
+	Email string `json:"email" binding:"required,email,max=254"`
+}
+
+// UpdateOrderItemsRequest represents the request payload for editing the
+// items of a pending order. Added products already on the order increase
+// its quantity.
+type UpdateOrderItemsRequest struct {
+	Add    []OrderItem    `json:"add"`
+	Update []ItemQuantity `json:"update"`
+	Remove []string       `json:"remove"`
+}
+
+// ItemQuantity sets the quantity of a product already on an order
+type ItemQuantity struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// UpdateOrderStatusRequest represents the request payload for updating order status
+type UpdateOrderStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	// Reason is recorded in the order's status history
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// CancelOrderRequest represents the optional request payload for cancelling an order
+type CancelOrderRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
@@ -0,0 +1,138 @@
+package contracts
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"order-service/pkg/fieldcrypt"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// EncryptedFields are the order fields stored encrypted once a field
+// cipher is set: the personal data retention anonymizes, but for the user
+// ID, which orders are looked up by
+var EncryptedFields = []string{"guest_email", "shipping_address.name", "shipping_address.line1", "shipping_address.line2"}
+
+// FieldCipher encrypts personal data as orders are stored; it is
+// implemented by *fieldcrypt.Keyring
+type FieldCipher interface {
+	Encrypt(plaintext string) (string, error)
+	Decrypt(value string) (string, error)
+}
+
+// errNoFieldCipher is returned for encrypted values read without a cipher
+var errNoFieldCipher = errors.New("order has encrypted fields but field encryption is not configured")
+
+type cipherHolder struct{ cipher FieldCipher }
+
+var fieldCipher atomic.Value
+
+// SetFieldCipher makes EncryptedFields stored encrypted by c from now on,
+// in orders, the events and snapshots copying them and the archive. It is
+// meant to be called once at startup, before orders are read or written.
+func SetFieldCipher(c FieldCipher) {
+	fieldCipher.Store(cipherHolder{c})
+}
+
+func currentCipher() FieldCipher {
+	holder, _ := fieldCipher.Load().(cipherHolder)
+	return holder.cipher
+}
+
+// SealPII returns value as it is stored: encrypted with a field cipher set,
+// as it is otherwise
+func SealPII(value string) (string, error) {
+	c := currentCipher()
+	if c == nil || value == "" || fieldcrypt.IsEncrypted(value) {
+		return value, nil
+	}
+	return c.Encrypt(value)
+}
+
+// OpenPII returns the plaintext of a stored value, which may predate
+// encryption
+func OpenPII(value string) (string, error) {
+	if !fieldcrypt.IsEncrypted(value) {
+		return value, nil
+	}
+	c := currentCipher()
+	if c == nil {
+		return "", errNoFieldCipher
+	}
+	return c.Decrypt(value)
+}
+
+// seal and open apply SealPII and OpenPII to each of fields in place
+func seal(fields ...*string) error {
+	for _, field := range fields {
+		sealed, err := SealPII(*field)
+		if err != nil {
+			return err
+		}
+		*field = sealed
+	}
+	return nil
+}
+
+func open(fields ...*string) error {
+	for _, field := range fields {
+		opened, err := OpenPII(*field)
+		if err != nil {
+			return err
+		}
+		*field = opened
+	}
+	return nil
+}
+
+// addressDocument is Address without its BSON methods, to encode the fields
+type addressDocument Address
+
+// MarshalBSON stores the address with its name and street lines sealed
+func (a Address) MarshalBSON() ([]byte, error) {
+	if err := seal(&a.Name, &a.Line1, &a.Line2); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(addressDocument(a))
+}
+
+// UnmarshalBSON reads a stored address, opening its sealed lines
+func (a *Address) UnmarshalBSON(data []byte) error {
+	if err := bson.Unmarshal(data, (*addressDocument)(a)); err != nil {
+		return err
+	}
+	return open(&a.Name, &a.Line1, &a.Line2)
+}
+
+// Sealed returns a copy of the order with its personal data as stored, for
+// copies kept outside MongoDB, such as the search index
+func (o Order) Sealed() (Order, error) {
+	if err := seal(&o.GuestEmail); err != nil {
+		return Order{}, err
+	}
+	if o.ShippingAddress != nil {
+		a := *o.ShippingAddress
+		if err := seal(&a.Name, &a.Line1, &a.Line2); err != nil {
+			return Order{}, err
+		}
+		o.ShippingAddress = &a
+	}
+	return o, nil
+}
+
+// Opened returns a copy of a sealed order with its personal data in
+// plaintext
+func (o Order) Opened() (Order, error) {
+	if err := open(&o.GuestEmail); err != nil {
+		return Order{}, err
+	}
+	if o.ShippingAddress != nil {
+		a := *o.ShippingAddress
+		if err := open(&a.Name, &a.Line1, &a.Line2); err != nil {
+			return Order{}, err
+		}
+		o.ShippingAddress = &a
+	}
+	return o, nil
+}
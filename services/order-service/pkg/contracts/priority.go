@@ -0,0 +1,74 @@
+package contracts
+
+import (
+	"errors"
+	"time"
+)
+
+// Order priorities, from the default to the most urgent
+const (
+	PriorityStandard  = "standard"
+	PriorityExpedited = "expedited"
+	PriorityRush      = "rush"
+)
+
+// ErrPriorityLocked is returned when the priority of an order that has
+// already shipped, or will not ship, is changed
+var ErrPriorityLocked = errors.New("priority can only be changed while the order is pending or confirmed")
+
+// priorityRanks orders priorities by urgency
+var priorityRanks = map[string]int{PriorityStandard: 0, PriorityExpedited: 1, PriorityRush: 2}
+
+// IsPriority reports whether priority is an order priority
+func IsPriority(priority string) bool {
+	_, ok := priorityRanks[priority]
+	return ok
+}
+
+// PriorityRank is the urgency of priority, 0 for standard. Orders store it
+// alongside the priority so they can be sorted by urgency.
+func PriorityRank(priority string) int {
+	return priorityRanks[priority]
+}
+
+// EffectivePriority is the order's priority; orders placed before
+// priorities existed are standard
+func (o Order) EffectivePriority() string {
+	if o.Priority == "" {
+		return PriorityStandard
+	}
+	return o.Priority
+}
+
+// PriorityChange sets the priority of a pending or confirmed order
+type PriorityChange struct {
+	Priority string
+	// Actor is the ID of the user making the change
+	Actor string
+	At    time.Time
+	// Base, when set, is the UpdatedAt the order must still have
+	Base time.Time
+}
+
+// Apply checks that the order has not shipped yet and applies the change.
+// It does not compare Base; repositories do so atomically.
+func (c PriorityChange) Apply(order *Order) error {
+	if order.Status != StatusPending && order.Status != StatusConfirmed {
+		return ErrPriorityLocked
+	}
+	c.Set(order)
+	return nil
+}
+
+// Set applies the change without checking it
+func (c PriorityChange) Set(order *Order) {
+	order.Priority = c.Priority
+	order.PriorityRank = PriorityRank(c.Priority)
+	order.UpdatedAt = c.At
+}
+
+// UpdatePriorityRequest represents the request payload for changing the
+// priority of an order
+type UpdatePriorityRequest struct {
+	Priority string `json:"priority" binding:"required"`
+}
@@ -0,0 +1,39 @@
+package contracts
+
+import "order-service/pkg/money"
+
+// Promotion types
+const (
+	// PromotionPercentage takes BasisPoints of the subtotal off
+	PromotionPercentage = "percentage"
+	// PromotionFixed takes AmountOff off, in the order's currency
+	PromotionFixed = "fixed"
+)
+
+// AppliedPromotion records the promotion code an order was placed with and
+// its terms at the time, so later edits to the promotion never change the
+// order
+type AppliedPromotion struct {
+	Code        string       `json:"code" bson:"code"`
+	Type        string       `json:"type" bson:"type"`
+	BasisPoints int64        `json:"basis_points,omitempty" bson:"basis_points,omitempty"`
+	AmountOff   *money.Money `json:"amount_off,omitempty" bson:"amount_off,omitempty"`
+}
+
+// Discount returns what the promotion takes off subtotal, never more than
+// the subtotal itself
+func (p AppliedPromotion) Discount(subtotal money.Money) money.Money {
+	discount := money.Zero(subtotal.Currency)
+	switch p.Type {
+	case PromotionPercentage:
+		discount = subtotal.MulRatio(p.BasisPoints, 10000)
+	case PromotionFixed:
+		if p.AmountOff != nil && p.AmountOff.Currency == subtotal.Currency {
+			discount = *p.AmountOff
+		}
+	}
+	if discount.Amount > subtotal.Amount {
+		discount = subtotal
+	}
+	return discount
+}
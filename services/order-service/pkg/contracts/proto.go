@@ -0,0 +1,176 @@
+package contracts
+
+import (
+	"order-service/pkg/contracts/ordersv2"
+	"order-service/pkg/money"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ToProto converts the order to its protobuf form
+func (o Order) ToProto() *ordersv2.Order {
+	items := make([]*ordersv2.OrderItem, 0, len(o.Items))
+	for _, item := range o.Items {
+		items = append(items, item.ToProto())
+	}
+
+	id := ""
+	if !o.ID.IsZero() {
+		id = o.ID.Hex()
+	}
+	o.BackfillTotals()
+
+	order := &ordersv2.Order{
+		Id:                 id,
+		OrderId:            o.OrderID,
+		UserId:             o.UserID,
+		Items:              items,
+		TotalAmount:        MoneyToProto(o.TotalAmount),
+		Subtotal:           MoneyToProto(o.Subtotal),
+		TaxAmount:          MoneyToProto(o.TaxAmount),
+		ShippingAmount:     MoneyToProto(o.ShippingAmount),
+		DiscountAmount:     MoneyToProto(o.DiscountAmount),
+		Status:             o.Status,
+		CreatedAt:          timestamppb.New(o.CreatedAt),
+		UpdatedAt:          timestamppb.New(o.UpdatedAt),
+		LegacyId:           o.LegacyID,
+		Region:             o.Region,
+		CancellationReason: o.CancellationReason,
+		IsGift:             o.IsGift,
+		GiftMessage:        o.GiftMessage,
+		Priority:           o.EffectivePriority(),
+	}
+	if o.SettlementTotal != nil {
+		order.SettlementTotal = MoneyToProto(*o.SettlementTotal)
+	}
+	if o.Promotion != nil {
+		order.Promotion = &ordersv2.Promotion{
+			Code:        o.Promotion.Code,
+			Type:        o.Promotion.Type,
+			BasisPoints: o.Promotion.BasisPoints,
+		}
+		if o.Promotion.AmountOff != nil {
+			order.Promotion.AmountOff = MoneyToProto(*o.Promotion.AmountOff)
+		}
+	}
+	if o.CancelledAt != nil {
+		order.CancelledAt = timestamppb.New(*o.CancelledAt)
+	}
+	return order
+}
+
+// ToProto converts the item to its protobuf form
+func (i OrderItem) ToProto() *ordersv2.OrderItem {
+	return &ordersv2.OrderItem{
+		ProductId: i.ProductID,
+		Name:      i.Name,
+		Sku:       i.SKU,
+		Price:     MoneyToProto(i.Price),
+		Quantity:  int32(i.Quantity),
+	}
+}
+
+// ToProto converts the event to its protobuf form
+func (e Event) ToProto() *ordersv2.OrderEvent {
+	return &ordersv2.OrderEvent{
+		EventId:        e.EventID,
+		Type:           e.Type,
+		SchemaVersion:  e.SchemaVersion,
+		OrderId:        e.OrderID,
+		UserId:         e.UserID,
+		PreviousStatus: e.PreviousStatus,
+		Order:          e.Order.ToProto(),
+		OccurredAt:     timestamppb.New(e.OccurredAt),
+	}
+}
+
+// OrderFromProto converts a protobuf order. An invalid or empty id yields a zero ObjectID.
+func OrderFromProto(p *ordersv2.Order) Order {
+	id, _ := primitive.ObjectIDFromHex(p.GetId())
+
+	items := make([]OrderItem, 0, len(p.GetItems()))
+	for _, item := range p.GetItems() {
+		items = append(items, OrderItemFromProto(item))
+	}
+
+	order := Order{
+		ID:                 id,
+		OrderID:            p.GetOrderId(),
+		UserID:             p.GetUserId(),
+		Items:              items,
+		TotalAmount:        MoneyFromProto(p.GetTotalAmount()),
+		Status:             p.GetStatus(),
+		CreatedAt:          p.GetCreatedAt().AsTime(),
+		UpdatedAt:          p.GetUpdatedAt().AsTime(),
+		LegacyID:           p.GetLegacyId(),
+		Region:             p.GetRegion(),
+		CancellationReason: p.GetCancellationReason(),
+		IsGift:             p.GetIsGift(),
+		GiftMessage:        p.GetGiftMessage(),
+		Priority:           p.GetPriority(),
+		PriorityRank:       PriorityRank(p.GetPriority()),
+	}
+	if p.Subtotal != nil {
+		order.Subtotal = MoneyFromProto(p.GetSubtotal())
+		order.TaxAmount = MoneyFromProto(p.GetTaxAmount())
+		order.ShippingAmount = MoneyFromProto(p.GetShippingAmount())
+		order.DiscountAmount = MoneyFromProto(p.GetDiscountAmount())
+	}
+	order.BackfillTotals()
+	if p.SettlementTotal != nil {
+		settlement := MoneyFromProto(p.GetSettlementTotal())
+		order.SettlementTotal = &settlement
+	}
+	if promo := p.GetPromotion(); promo != nil {
+		order.Promotion = &AppliedPromotion{
+			Code:        promo.GetCode(),
+			Type:        promo.GetType(),
+			BasisPoints: promo.GetBasisPoints(),
+		}
+		if promo.AmountOff != nil {
+			amountOff := MoneyFromProto(promo.GetAmountOff())
+			order.Promotion.AmountOff = &amountOff
+		}
+	}
+	if p.CancelledAt != nil {
+		at := p.GetCancelledAt().AsTime()
+		order.CancelledAt = &at
+	}
+	return order
+}
+
+// OrderItemFromProto converts a protobuf order item
+func OrderItemFromProto(p *ordersv2.OrderItem) OrderItem {
+	return OrderItem{
+		ProductID: p.GetProductId(),
+		Name:      p.GetName(),
+		SKU:       p.GetSku(),
+		Price:     MoneyFromProto(p.GetPrice()),
+		Quantity:  int(p.GetQuantity()),
+	}
+}
+
+// EventFromProto converts a protobuf event
+func EventFromProto(p *ordersv2.OrderEvent) Event {
+	return Event{
+		EventID:        p.GetEventId(),
+		Type:           p.GetType(),
+		SchemaVersion:  p.GetSchemaVersion(),
+		OrderID:        p.GetOrderId(),
+		UserID:         p.GetUserId(),
+		PreviousStatus: p.GetPreviousStatus(),
+		Order:          OrderFromProto(p.GetOrder()),
+		OccurredAt:     p.GetOccurredAt().AsTime(),
+	}
+}
+
+// MoneyToProto converts an amount to its protobuf form
+func MoneyToProto(m money.Money) *ordersv2.Money {
+	return &ordersv2.Money{AmountMinor: m.Amount, Currency: m.Currency}
+}
+
+// MoneyFromProto converts a protobuf amount
+func MoneyFromProto(p *ordersv2.Money) money.Money {
+	return money.New(p.GetAmountMinor(), p.GetCurrency())
+}
@@ -0,0 +1,53 @@
+package contracts
+
+import (
+	"time"
+
+	"order-service/pkg/money"
+)
+
+// Return is a customer's request to send a delivered order back, and its
+// resolution
+type Return struct {
+	Reason      string    `json:"reason" bson:"reason"`
+	RequestedBy string    `json:"requested_by" bson:"requested_by"`
+	RequestedAt time.Time `json:"requested_at" bson:"requested_at"`
+	// ReviewedBy and ReviewedAt are set when an admin approves or rejects
+	// the return, with the Resolution they gave
+	ReviewedBy string     `json:"reviewed_by,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty" bson:"reviewed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty" bson:"resolution,omitempty"`
+	// RefundAmount is what an approved return grants back to the customer
+	RefundAmount *money.Money `json:"refund_amount,omitempty" bson:"refund_amount,omitempty"`
+	// Refund records the money actually returned
+	Refund *Refund `json:"refund,omitempty" bson:"refund,omitempty"`
+}
+
+// Refund is money returned to the customer for a return
+type Refund struct {
+	// PaymentID is the payment refunded; empty when no payment service is
+	// configured and the refund was made outside the order service
+	PaymentID string `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	// Amount is what was returned, which the payment provider may cap
+	// below the amount approved
+	Amount     money.Money `json:"amount" bson:"amount"`
+	RefundedAt time.Time   `json:"refunded_at" bson:"refunded_at"`
+}
+
+// ReturnRequest represents the request payload for requesting the return
+// of a delivered order
+type ReturnRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ApproveReturnRequest represents the request payload for approving a
+// return. RefundAmount defaults to the order's total.
+type ApproveReturnRequest struct {
+	RefundAmount *money.Money `json:"refund_amount"`
+	Resolution   string       `json:"resolution" binding:"max=500"`
+}
+
+// RejectReturnRequest represents the request payload for rejecting a return
+type RejectReturnRequest struct {
+	Resolution string `json:"resolution" binding:"required,max=500"`
+}
@@ -0,0 +1,97 @@
+package contracts
+
+import (
+	"order-service/pkg/migration"
+	"order-service/pkg/money"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// OrderSchema upgrades stored order documents, which record the version of
+// their layout in schema_version. Every order read is upgraded to the
+// current version, so a change to the stored model ships with a migration
+// here instead of conversions scattered across readers; orderctl
+// migrate-schema saves the upgrades. Append migrations, never edit one
+// that has been released.
+var OrderSchema = migration.NewSchema("order",
+	migration.Migration{Version: 1, Description: "float64 amounts to money", Up: moneyAmounts},
+	migration.Migration{Version: 2, Description: "totals breakdown", Up: totalsBreakdown},
+)
+
+// orderDocument is Order without its BSON methods, to encode the fields
+type orderDocument Order
+
+// MarshalBSON stores the order at the current schema version, with its
+// guest email sealed; its address seals its own lines
+func (o Order) MarshalBSON() ([]byte, error) {
+	if o.SchemaVersion < OrderSchema.Version() {
+		o.SchemaVersion = OrderSchema.Version()
+	}
+	if err := seal(&o.GuestEmail); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(orderDocument(o))
+}
+
+// UnmarshalBSON reads a stored order, upgrading it first if it predates the
+// current schema version
+func (o *Order) UnmarshalBSON(data []byte) error {
+	doc, _, err := OrderSchema.Upgrade(data)
+	if err != nil {
+		return err
+	}
+	if err := bson.Unmarshal(doc, (*orderDocument)(o)); err != nil {
+		return err
+	}
+	return open(&o.GuestEmail)
+}
+
+// moneyAmounts stores the total and item prices of orders placed before
+// amounts were money, held as plain numbers, as money in DefaultCurrency
+func moneyAmounts(doc bson.M) error {
+	doc["total_amount"] = legacyAmount(doc["total_amount"])
+	items, _ := doc["items"].(bson.A)
+	for _, item := range items {
+		if item, ok := item.(bson.M); ok {
+			item["price"] = legacyAmount(item["price"])
+		}
+	}
+	return nil
+}
+
+// legacyAmount converts a number to money, leaving anything else as it is
+func legacyAmount(v interface{}) interface{} {
+	switch n := v.(type) {
+	case float64:
+		return money.FromFloat(n, money.DefaultCurrency)
+	case int32:
+		return money.FromFloat(float64(n), money.DefaultCurrency)
+	case int64:
+		return money.FromFloat(float64(n), money.DefaultCurrency)
+	}
+	return v
+}
+
+// totalsBreakdown gives orders placed before totals were broken down a
+// subtotal equal to their total and no charges, as BackfillTotals does
+func totalsBreakdown(doc bson.M) error {
+	if subtotal, ok := doc["subtotal"].(bson.M); ok && subtotal["currency"] != "" {
+		return nil
+	}
+	raw, err := bson.Marshal(bson.M{"total_amount": doc["total_amount"]})
+	if err != nil {
+		return err
+	}
+	var total struct {
+		Amount money.Money `bson:"total_amount"`
+	}
+	if err := bson.Unmarshal(raw, &total); err != nil {
+		return err
+	}
+	charges := NoCharges(total.Amount.Currency)
+	doc["subtotal"] = total.Amount
+	doc["tax_amount"] = charges.Tax
+	doc["shipping_amount"] = charges.Shipping
+	doc["discount_amount"] = charges.Discount
+	return nil
+}
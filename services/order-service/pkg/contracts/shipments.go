@@ -0,0 +1,216 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Shipment statuses. A shipment moves forward only, from pending to
+// shipped to delivered.
+const (
+	ShipmentPending   = "pending"
+	ShipmentShipped   = "shipped"
+	ShipmentDelivered = "delivered"
+)
+
+// ShipmentsReason is the reason recorded in the status history when
+// shipments move an order
+const ShipmentsReason = "Derived from shipments"
+
+// ErrNotShippable is returned when the shipments of an order that is not
+// confirmed or shipped are changed
+var ErrNotShippable = errors.New("shipments can only be changed on confirmed or shipped orders")
+
+// ErrShipmentNotFound is returned for a shipment the order does not have
+var ErrShipmentNotFound = errors.New("shipment not found")
+
+// shipmentRank orders shipment statuses
+var shipmentRank = map[string]int{ShipmentPending: 0, ShipmentShipped: 1, ShipmentDelivered: 2}
+
+// IsShipmentStatus reports whether status is a shipment status
+func IsShipmentStatus(status string) bool {
+	_, ok := shipmentRank[status]
+	return ok
+}
+
+// Shipment is part of an order's items sent to the customer together
+type Shipment struct {
+	ID             string         `json:"id" bson:"id"`
+	Items          []ShipmentItem `json:"items" bson:"items"`
+	Status         string         `json:"status" bson:"status"`
+	Carrier        string         `json:"carrier,omitempty" bson:"carrier,omitempty"`
+	TrackingNumber string         `json:"tracking_number,omitempty" bson:"tracking_number,omitempty"`
+	CreatedAt      time.Time      `json:"created_at" bson:"created_at"`
+	// ShippedAt and DeliveredAt are set as the shipment reaches each status
+	ShippedAt   *time.Time `json:"shipped_at,omitempty" bson:"shipped_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" bson:"delivered_at,omitempty"`
+}
+
+// ShipmentItem is a quantity of one of the order's products in a shipment
+type ShipmentItem struct {
+	ProductID string `json:"product_id" bson:"product_id"`
+	Quantity  int    `json:"quantity" bson:"quantity"`
+}
+
+// Advance moves the shipment forward to status at the given time. Moving
+// back, or to an unknown status, fails with a *ValidationError on status.
+func (s *Shipment) Advance(status string, at time.Time) error {
+	rank, ok := shipmentRank[status]
+	if !ok {
+		return &ValidationError{Fields: []FieldError{{Field: "status", Message: fmt.Sprintf("must be one of %s, %s or %s", ShipmentPending, ShipmentShipped, ShipmentDelivered)}}}
+	}
+	if rank < shipmentRank[s.Status] {
+		return &ValidationError{Fields: []FieldError{{Field: "status", Message: fmt.Sprintf("cannot move a shipment from %s back to %s", s.Status, status)}}}
+	}
+	if rank >= shipmentRank[ShipmentShipped] && s.ShippedAt == nil {
+		s.ShippedAt = &at
+	}
+	if rank >= shipmentRank[ShipmentDelivered] && s.DeliveredAt == nil {
+		s.DeliveredAt = &at
+	}
+	s.Status = status
+	return nil
+}
+
+// ValidateShipments checks that the shipments only hold products of the
+// order, in positive quantities that do not add up to more than were
+// ordered. field names the request field holding the items of the last
+// shipment, the one being added or changed. It returns a *ValidationError
+// listing every violation, or nil.
+func ValidateShipments(field string, items []OrderItem, shipments []Shipment) error {
+	if len(shipments) == 0 {
+		return nil
+	}
+	verr := &ValidationError{}
+	ordered := map[string]int{}
+	for _, item := range items {
+		ordered[item.ProductID] += item.Quantity
+	}
+
+	shipped := map[string]int{}
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			shipped[item.ProductID] += item.Quantity
+		}
+	}
+
+	last := shipments[len(shipments)-1]
+	if len(last.Items) == 0 {
+		verr.add(field, "must contain at least one item")
+	}
+	for i, item := range last.Items {
+		at := fmt.Sprintf("%s[%d]", field, i)
+		switch {
+		case ordered[item.ProductID] == 0:
+			verr.add(at+".product_id", "is not on the order")
+		case item.Quantity < 1:
+			verr.add(at+".quantity", "must be at least 1")
+		case shipped[item.ProductID] > ordered[item.ProductID]:
+			verr.add(at+".quantity", "ships more than the %d ordered", ordered[item.ProductID])
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ShipmentsStatus derives the order status from its shipments: shipped
+// once every ordered item is in a shipped or delivered shipment, delivered
+// once all of those are delivered. Otherwise it returns the empty string
+// and the order keeps its status.
+func ShipmentsStatus(items []OrderItem, shipments []Shipment) string {
+	remaining := map[string]int{}
+	for _, item := range items {
+		remaining[item.ProductID] += item.Quantity
+	}
+	delivered := true
+	for _, shipment := range shipments {
+		if shipment.Status == ShipmentPending {
+			continue
+		}
+		delivered = delivered && shipment.Status == ShipmentDelivered
+		for _, item := range shipment.Items {
+			remaining[item.ProductID] -= item.Quantity
+		}
+	}
+	for _, quantity := range remaining {
+		if quantity > 0 {
+			return ""
+		}
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	if delivered {
+		return StatusDelivered
+	}
+	return StatusShipped
+}
+
+// ShipmentsChange replaces the shipments of a confirmed or shipped order,
+// moving the order to the status derived from them
+type ShipmentsChange struct {
+	Shipments []Shipment
+	// Status is the status derived from the shipments; empty, or the
+	// order's current status, leaves the status as it is
+	Status string
+	// Actor is the ID of the user making the change
+	Actor string
+	At    time.Time
+	// Base is the UpdatedAt of the order the shipments were computed from.
+	// The change is rejected if the order was modified since.
+	Base time.Time
+}
+
+// Moves reports whether the change moves an order in status from to
+// another status
+func (c ShipmentsChange) Moves(from string) bool {
+	return c.Status != "" && c.Status != from
+}
+
+// Apply checks that the order's shipments can still change and that the
+// derived status is allowed from its current one, and applies the change.
+// It does not compare Base; repositories do so atomically.
+func (c ShipmentsChange) Apply(order *Order) error {
+	if order.Status != StatusConfirmed && order.Status != StatusShipped {
+		return ErrNotShippable
+	}
+	if c.Moves(order.Status) && !CanTransition(order.Status, c.Status) {
+		return &TransitionError{From: order.Status, To: c.Status}
+	}
+	c.Set(order)
+	return nil
+}
+
+// Set applies the change without checking it
+func (c ShipmentsChange) Set(order *Order) {
+	if c.Moves(order.Status) {
+		c.StatusChange().Set(order)
+	}
+	order.Shipments = c.Shipments
+	order.UpdatedAt = c.At
+}
+
+// StatusChange is the status change the shipments make
+func (c ShipmentsChange) StatusChange() StatusChange {
+	return StatusChange{To: c.Status, At: c.At, Actor: c.Actor, Reason: ShipmentsReason}
+}
+
+// CreateShipmentRequest represents the request payload for shipping part of
+// an order
+type CreateShipmentRequest struct {
+	Items          []ShipmentItem `json:"items" binding:"required"`
+	Carrier        string         `json:"carrier" binding:"max=100"`
+	TrackingNumber string         `json:"tracking_number" binding:"max=100"`
+}
+
+// UpdateShipmentRequest represents the request payload for updating a
+// shipment. Empty fields are left unchanged.
+type UpdateShipmentRequest struct {
+	Status         string `json:"status"`
+	Carrier        string `json:"carrier" binding:"max=100"`
+	TrackingNumber string `json:"tracking_number" binding:"max=100"`
+}
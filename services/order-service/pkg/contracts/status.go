@@ -0,0 +1,149 @@
+package contracts
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Order statuses
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusShipped   = "shipped"
+	StatusDelivered = "delivered"
+	StatusCancelled = "cancelled"
+	// Return statuses follow delivery; they are only set through the
+	// return workflow, never directly
+	StatusReturnRequested = "return_requested"
+	StatusReturned        = "returned"
+	StatusReturnRejected  = "return_rejected"
+	StatusRefunded        = "refunded"
+)
+
+// ErrInvalidTransition is returned when an order cannot move from its
+// current status to the requested one
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// transitions is the order state machine: the statuses each status may move
+// to. Orders only move forward, and can only be cancelled before they ship.
+// A delivered order may be returned: the customer requests the return, an
+// admin approves or rejects it and an approved return is refunded.
+var transitions = map[string][]string{
+	StatusPending:         {StatusConfirmed, StatusShipped, StatusDelivered, StatusCancelled},
+	StatusConfirmed:       {StatusShipped, StatusDelivered, StatusCancelled},
+	StatusShipped:         {StatusDelivered},
+	StatusDelivered:       {StatusReturnRequested},
+	StatusCancelled:       {},
+	StatusReturnRequested: {StatusReturned, StatusReturnRejected},
+	StatusReturned:        {StatusRefunded},
+	StatusReturnRejected:  {},
+	StatusRefunded:        {},
+}
+
+// IsStatus reports whether status is part of the order lifecycle
+func IsStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// IsReturnStatus reports whether status belongs to the return workflow
+func IsReturnStatus(status string) bool {
+	switch status {
+	case StatusReturnRequested, StatusReturned, StatusReturnRejected, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransition reports whether an order in status from may move to to
+func CanTransition(from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusesBefore returns the statuses from which an order may move to to
+func StatusesBefore(to string) []string {
+	from := []string{}
+	for status := range transitions {
+		if CanTransition(status, to) {
+			from = append(from, status)
+		}
+	}
+	return from
+}
+
+// TransitionError describes a rejected status change
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("cannot move order from %s to %s", e.From, e.To)
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidTransition) match
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// StatusHistoryEntry is one status change in an order's history: who moved
+// the order from which status to which, when and why
+type StatusHistoryEntry struct {
+	From    string    `json:"from" bson:"from"`
+	To      string    `json:"to" bson:"to"`
+	ActorID string    `json:"actor_id,omitempty" bson:"actor_id,omitempty"`
+	At      time.Time `json:"at" bson:"at"`
+	Reason  string    `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
+// StatusChange moves an order to a new status
+type StatusChange struct {
+	To string
+	At time.Time
+	// Reason is recorded in the history, and as the cancellation reason
+	// when the order is cancelled
+	Reason string
+	// Actor is the ID of the user making the change
+	Actor string
+	// Base, when set, is the UpdatedAt the order must still have for the
+	// change to apply, so a client cannot overwrite changes it has not seen
+	Base time.Time
+	// Return, when set, replaces the order's return along with the status
+	Return *Return
+}
+
+// Apply checks the change against the state machine and applies it to order
+func (s StatusChange) Apply(order *Order) error {
+	if !CanTransition(order.Status, s.To) {
+		return &TransitionError{From: order.Status, To: s.To}
+	}
+	s.Set(order)
+	return nil
+}
+
+// Set applies the change without checking it, for replaying changes that
+// were accepted when they happened
+func (s StatusChange) Set(order *Order) {
+	order.StatusHistory = append(order.StatusHistory, s.Entry(order.Status))
+	order.Status = s.To
+	order.UpdatedAt = s.At
+	if s.To == StatusCancelled {
+		at := s.At
+		order.CancelledAt = &at
+		order.CancellationReason = s.Reason
+	}
+	if s.Return != nil {
+		order.Return = s.Return
+	}
+}
+
+// Entry is the history entry recording the change from status from
+func (s StatusChange) Entry(from string) StatusHistoryEntry {
+	return StatusHistoryEntry{From: from, To: s.To, ActorID: s.Actor, At: s.At, Reason: s.Reason}
+}
@@ -0,0 +1,85 @@
+package contracts
+
+import (
+	"errors"
+
+	"order-service/pkg/money"
+)
+
+// ErrNegativeTotal is returned when an order's charges would take its total
+// below zero
+var ErrNegativeTotal = errors.New("order total cannot be negative")
+
+// Charges are the amounts added to or taken off an order's subtotal
+type Charges struct {
+	Tax      money.Money `json:"tax" bson:"tax"`
+	Shipping money.Money `json:"shipping" bson:"shipping"`
+	Discount money.Money `json:"discount" bson:"discount"`
+}
+
+// NoCharges returns zero charges in currency
+func NoCharges(currency string) Charges {
+	zero := money.Zero(currency)
+	return Charges{Tax: zero, Shipping: zero, Discount: zero}
+}
+
+// In returns the charges with zero amounts that carry no currency put in
+// currency, so calculators can leave charges they do not apply unset
+func (c Charges) In(currency string) Charges {
+	for _, m := range []*money.Money{&c.Tax, &c.Shipping, &c.Discount} {
+		if m.Currency == "" && m.IsZero() {
+			*m = money.Zero(currency)
+		}
+	}
+	return c
+}
+
+// Total returns subtotal plus tax and shipping, less the discount
+func (c Charges) Total(subtotal money.Money) (money.Money, error) {
+	c = c.In(subtotal.Currency)
+	total, err := money.Sum(subtotal.Currency, subtotal, c.Tax, c.Shipping)
+	if err != nil {
+		return total, err
+	}
+	if total, err = total.Sub(c.Discount); err != nil {
+		return total, err
+	}
+	if total.IsNegative() {
+		return total, ErrNegativeTotal
+	}
+	return total, nil
+}
+
+// SetTotals records the subtotal and charges on the order and sets its
+// grand total from them
+func (o *Order) SetTotals(subtotal money.Money, charges Charges) error {
+	total, err := charges.Total(subtotal)
+	if err != nil {
+		return err
+	}
+	charges = charges.In(subtotal.Currency)
+	o.Subtotal = subtotal
+	o.TaxAmount = charges.Tax
+	o.ShippingAmount = charges.Shipping
+	o.DiscountAmount = charges.Discount
+	o.TotalAmount = total
+	return nil
+}
+
+// Charges returns the order's tax, shipping and discount
+func (o Order) Charges() Charges {
+	return Charges{Tax: o.TaxAmount, Shipping: o.ShippingAmount, Discount: o.DiscountAmount}
+}
+
+// BackfillTotals fills in the breakdown of an order placed before orders
+// had one: its whole total was the subtotal
+func (o *Order) BackfillTotals() {
+	if o.Subtotal.Currency != "" {
+		return
+	}
+	o.Subtotal = o.TotalAmount
+	charges := NoCharges(o.TotalAmount.Currency)
+	o.TaxAmount = charges.Tax
+	o.ShippingAmount = charges.Shipping
+	o.DiscountAmount = charges.Discount
+}
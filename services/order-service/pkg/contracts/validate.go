@@ -0,0 +1,135 @@
+package contracts
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits bounds the size of an order and of a bulk request
+type Limits struct {
+	// MaxItems is the maximum number of line items per order
+	MaxItems int
+	// MaxQuantity is the maximum quantity of a single line item
+	MaxQuantity int
+	// MaxBulkOrders is the maximum number of orders per bulk request
+	MaxBulkOrders int
+}
+
+// DefaultLimits are used when no limits are configured
+var DefaultLimits = Limits{MaxItems: 50, MaxQuantity: 100, MaxBulkOrders: 500}
+
+// MaxItemTextLength is the maximum length, in characters, of an item's
+// product ID and name
+const MaxItemTextLength = 200
+
+// FieldError describes one invalid field, addressed like items[2].quantity
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every invariant an order payload violates
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		parts = append(parts, f.Field+": "+f.Message)
+	}
+	return "invalid order: " + strings.Join(parts, "; ")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateItems checks the line items of a new order as submitted: at least
+// one and at most limits.MaxItems items, each with a product ID of at most
+// MaxItemTextLength characters and a quantity between 1 and
+// limits.MaxQuantity. Names and prices are checked separately
+// by ValidateItemDetails because they normally come from the catalog. It
+// returns a *ValidationError listing every violation, or nil.
+func ValidateItems(items []OrderItem, limits Limits) error {
+	verr := &ValidationError{}
+
+	switch {
+	case len(items) == 0:
+		verr.add("items", "must contain at least one item")
+	case limits.MaxItems > 0 && len(items) > limits.MaxItems:
+		verr.add("items", "must contain at most %d items", limits.MaxItems)
+	}
+
+	for i, item := range items {
+		field := fmt.Sprintf("items[%d]", i)
+		if strings.TrimSpace(item.ProductID) == "" {
+			verr.add(field+".product_id", "is required")
+		} else if utf8.RuneCountInString(item.ProductID) > MaxItemTextLength {
+			verr.add(field+".product_id", "must be at most %d characters", MaxItemTextLength)
+		}
+		if item.Quantity < 1 {
+			verr.add(field+".quantity", "must be at least 1")
+		} else if limits.MaxQuantity > 0 && item.Quantity > limits.MaxQuantity {
+			verr.add(field+".quantity", "must be at most %d", limits.MaxQuantity)
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ValidateItemCurrencies checks that every item is priced in currency.
+// field names the request field holding the items. It returns a
+// *ValidationError listing every violation, or nil.
+func ValidateItemCurrencies(field string, items []OrderItem, currency string) error {
+	verr := &ValidationError{}
+
+	for i, item := range items {
+		if item.Price.Currency != currency {
+			verr.add(fmt.Sprintf("%s[%d].price.currency", field, i), "is %s, but the order is in %s", item.Price.Currency, currency)
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ValidateItemDetails checks that every item has a name of at most
+// MaxItemTextLength characters and a non-negative price, and that neither a
+// line total nor the sum of them overflows. It returns a *ValidationError
+// listing every violation, or nil.
+func ValidateItemDetails(items []OrderItem) error {
+	verr := &ValidationError{}
+
+	var total int64
+	for i, item := range items {
+		field := fmt.Sprintf("items[%d]", i)
+		if strings.TrimSpace(item.Name) == "" {
+			verr.add(field+".name", "is required")
+		} else if utf8.RuneCountInString(item.Name) > MaxItemTextLength {
+			verr.add(field+".name", "must be at most %d characters", MaxItemTextLength)
+		}
+		switch {
+		case item.Price.IsNegative():
+			verr.add(field+".price", "must not be negative")
+		case item.Quantity > 0 && item.Price.Amount > math.MaxInt64/int64(item.Quantity):
+			verr.add(field+".price", "is too large for the quantity")
+		case item.Quantity > 0 && total > math.MaxInt64-item.Price.Amount*int64(item.Quantity):
+			verr.add(field+".price", "makes the order total too large")
+		case item.Quantity > 0:
+			total += item.Price.Amount * int64(item.Quantity)
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
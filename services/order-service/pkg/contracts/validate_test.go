@@ -0,0 +1,154 @@
+package contracts
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"order-service/pkg/money"
+)
+
+func item(productID string, quantity int) OrderItem {
+	return OrderItem{ProductID: productID, Name: "Widget", Price: money.New(1299, "USD"), Quantity: quantity}
+}
+
+func items(n int) []OrderItem {
+	list := make([]OrderItem, n)
+	for i := range list {
+		list[i] = item("prod-1", 1)
+	}
+	return list
+}
+
+// fields returns the fields a validation error names, or nil without error
+func fields(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error = %T %v, want *ValidationError", err, err)
+	}
+	var names []string
+	for _, f := range verr.Fields {
+		names = append(names, f.Field)
+	}
+	return names
+}
+
+func TestValidateItems(t *testing.T) {
+	limits := Limits{MaxItems: 3, MaxQuantity: 10}
+	long := strings.Repeat("p", MaxItemTextLength+1)
+
+	tests := []struct {
+		name   string
+		items  []OrderItem
+		limits Limits
+		want   []string
+	}{
+		{name: "valid", items: []OrderItem{item("prod-1", 1), item("prod-2", 10)}, limits: limits},
+		{name: "no items", items: nil, limits: limits, want: []string{"items"}},
+		{name: "at the item limit", items: items(3), limits: limits},
+		{name: "over the item limit", items: items(4), limits: limits, want: []string{"items"}},
+		{name: "no item limit", items: items(100), limits: Limits{}},
+		{name: "zero quantity", items: []OrderItem{item("prod-1", 0)}, limits: limits, want: []string{"items[0].quantity"}},
+		{name: "negative quantity", items: []OrderItem{item("prod-1", -2)}, limits: limits, want: []string{"items[0].quantity"}},
+		{name: "over the quantity limit", items: []OrderItem{item("prod-1", 1), item("prod-2", 11)}, limits: limits, want: []string{"items[1].quantity"}},
+		{name: "no quantity limit", items: []OrderItem{item("prod-1", 1000)}, limits: Limits{}},
+		{name: "empty product", items: []OrderItem{item("", 1)}, limits: limits, want: []string{"items[0].product_id"}},
+		{name: "blank product", items: []OrderItem{item("  ", 1)}, limits: limits, want: []string{"items[0].product_id"}},
+		{name: "product at the length limit", items: []OrderItem{item(long[1:], 1)}, limits: limits},
+		{name: "product too long", items: []OrderItem{item(long, 1)}, limits: limits, want: []string{"items[0].product_id"}},
+		{name: "product length in characters", items: []OrderItem{item(strings.Repeat("é", MaxItemTextLength), 1)}, limits: limits},
+		{
+			name:   "every violation",
+			items:  []OrderItem{item("", 0), item("prod-2", 1), item("prod-3", 11), item("prod-4", 1)},
+			limits: limits,
+			want:   []string{"items", "items[0].product_id", "items[0].quantity", "items[2].quantity"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fields(t, ValidateItems(tt.items, tt.limits))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateItemDetails(t *testing.T) {
+	priced := func(minor int64, quantity int) OrderItem {
+		i := item("prod-1", quantity)
+		i.Price = money.New(minor, "USD")
+		return i
+	}
+	named := func(name string) OrderItem {
+		i := item("prod-1", 1)
+		i.Name = name
+		return i
+	}
+
+	tests := []struct {
+		name  string
+		items []OrderItem
+		want  []string
+	}{
+		{name: "valid", items: []OrderItem{priced(1299, 2), priced(1, 100)}},
+		{name: "free item", items: []OrderItem{priced(0, 1)}},
+		{name: "negative price", items: []OrderItem{priced(-1, 1)}, want: []string{"items[0].price"}},
+		{name: "largest line total", items: []OrderItem{priced(math.MaxInt64, 1)}},
+		{name: "line total overflows", items: []OrderItem{priced(math.MaxInt64/2+1, 2)}, want: []string{"items[0].price"}},
+		{name: "order total overflows", items: []OrderItem{priced(math.MaxInt64-10, 1), priced(11, 1)}, want: []string{"items[1].price"}},
+		{name: "empty name", items: []OrderItem{named("")}, want: []string{"items[0].name"}},
+		{name: "blank name", items: []OrderItem{named(" \t")}, want: []string{"items[0].name"}},
+		{name: "name at the length limit", items: []OrderItem{named(strings.Repeat("n", MaxItemTextLength))}},
+		{name: "name too long", items: []OrderItem{named(strings.Repeat("n", MaxItemTextLength+1))}, want: []string{"items[0].name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fields(t, ValidateItemDetails(tt.items))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid fields = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateItemCurrencies(t *testing.T) {
+	eur := item("prod-2", 1)
+	eur.Price = money.New(500, "EUR")
+
+	if err := ValidateItemCurrencies("items", []OrderItem{item("prod-1", 1)}, "USD"); err != nil {
+		t.Fatalf("same currency: %v", err)
+	}
+	err := ValidateItemCurrencies("items", []OrderItem{item("prod-1", 1), eur}, "USD")
+	if got, want := fields(t, err), []string{"items[1].price.currency"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid fields = %v, want %v", got, want)
+	}
+}
+
+// TestValidationErrorShape pins what clients see: the error message and the
+// fields list the API returns alongside it
+func TestValidationErrorShape(t *testing.T) {
+	err := ValidateItems([]OrderItem{item("", 0), item("prod-2", 101)}, DefaultLimits)
+
+	want := "invalid order: items[0].product_id: is required; items[0].quantity: must be at least 1; items[1].quantity: must be at most 100"
+	if err == nil || err.Error() != want {
+		t.Fatalf("error = %v, want %q", err, want)
+	}
+
+	body, jerr := json.Marshal(err.(*ValidationError).Fields)
+	if jerr != nil {
+		t.Fatal(jerr)
+	}
+	wantJSON := `[{"field":"items[0].product_id","message":"is required"},` +
+		`{"field":"items[0].quantity","message":"must be at least 1"},` +
+		`{"field":"items[1].quantity","message":"must be at most 100"}]`
+	if string(body) != wantJSON {
+		t.Errorf("fields = %s, want %s", body, wantJSON)
+	}
+}
@@ -0,0 +1,150 @@
+// Package cron runs recurring maintenance tasks on cron schedules, such as
+// expiring stale orders or rolling metrics up at fixed times of day rather
+// than at an interval from whenever the process started. A task still
+// running when it is due again is skipped for that run, so a slow run
+// never piles up behind itself; runs missed while the process was down or
+// busy are not caught up, the task runs at its next time.
+package cron
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Task is one run of a scheduled task. Its error is logged and counted.
+type Task func(ctx context.Context) error
+
+type entry struct {
+	name     string
+	spec     string
+	schedule Schedule
+	task     Task
+	running  int32
+}
+
+// Scheduler runs tasks on their schedules
+type Scheduler struct {
+	entries []*entry
+
+	// Location is the time zone schedules are read in
+	Location *time.Location
+	Clock    clock.Clock
+}
+
+// NewScheduler returns a scheduler reading schedules in UTC
+func NewScheduler() *Scheduler {
+	return &Scheduler{Location: time.UTC, Clock: clock.System{}}
+}
+
+// Add schedules task under name, which labels its logs and metrics, to run
+// as spec tells; see Parse. Tasks are added before Run.
+func (s *Scheduler) Add(name, spec string, task Task) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("schedule of %s: %w", name, err)
+	}
+	s.entries = append(s.entries, &entry{name: name, spec: spec, schedule: schedule, task: task})
+	return nil
+}
+
+// Run starts the tasks when they are due until ctx is done, which is also
+// given to the tasks, then waits for the runs in progress
+func (s *Scheduler) Run(ctx context.Context) {
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	now := s.Clock.Now().In(s.Location)
+	next := make([]time.Time, len(s.entries))
+	for i, e := range s.entries {
+		next[i] = s.plan(e, now)
+		log.Info().Str("task", e.name).Str("schedule", e.spec).Time("next_run", next[i]).Msg("Scheduled task")
+	}
+
+	for {
+		var wake time.Time
+		for _, t := range next {
+			if !t.IsZero() && (wake.IsZero() || t.Before(wake)) {
+				wake = t
+			}
+		}
+		if wake.IsZero() {
+			<-ctx.Done()
+			return
+		}
+
+		timer := time.NewTimer(wake.Sub(s.Clock.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		now = s.Clock.Now().In(s.Location)
+		for i, e := range s.entries {
+			if next[i].IsZero() || next[i].After(now) {
+				continue
+			}
+			s.start(ctx, e, &running)
+			next[i] = s.plan(e, now)
+		}
+	}
+}
+
+// plan returns the next run of e after now and reports it
+func (s *Scheduler) plan(e *entry, now time.Time) time.Time {
+	next := e.schedule.Next(now)
+	if next.IsZero() {
+		log.Warn().Str("task", e.name).Str("schedule", e.spec).Msg("Scheduled task never runs again")
+		taskNextRun.DeleteLabelValues(e.name)
+		return next
+	}
+	taskNextRun.WithLabelValues(e.name).Set(float64(next.Unix()))
+	return next
+}
+
+// start runs e in the background unless its previous run is still going
+func (s *Scheduler) start(ctx context.Context, e *entry, running *sync.WaitGroup) {
+	if !atomic.CompareAndSwapInt32(&e.running, 0, 1) {
+		taskRunsTotal.WithLabelValues(e.name, "skipped").Inc()
+		log.Warn().Str("task", e.name).Msg("Skipped scheduled task, its previous run is still going")
+		return
+	}
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer atomic.StoreInt32(&e.running, 0)
+		s.run(ctx, e)
+	}()
+}
+
+// run runs e once, recording its result
+func (s *Scheduler) run(ctx context.Context, e *entry) {
+	started := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		return e.task(ctx)
+	}()
+	elapsed := time.Since(started)
+	taskDuration.WithLabelValues(e.name).Observe(elapsed.Seconds())
+
+	if err != nil {
+		taskRunsTotal.WithLabelValues(e.name, "failure").Inc()
+		log.Error().Err(err).Str("task", e.name).Dur("duration", elapsed).Msg("Scheduled task failed")
+		return
+	}
+	taskRunsTotal.WithLabelValues(e.name, "success").Inc()
+	taskLastSuccess.WithLabelValues(e.name).SetToCurrentTime()
+	log.Debug().Str("task", e.name).Dur("duration", elapsed).Msg("Scheduled task finished")
+}
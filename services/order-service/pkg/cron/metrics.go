@@ -0,0 +1,42 @@
+package cron
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	taskRunsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cron_task_runs_total",
+			Help: "Total number of scheduled task runs, by task and result (success, failure or skipped while the previous run was still going)",
+		},
+		[]string{"task", "result"},
+	)
+	taskDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "cron_task_duration_seconds",
+			Help:    "Time taken by scheduled task runs",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+		[]string{"task"},
+	)
+	taskLastSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cron_task_last_success_timestamp_seconds",
+			Help: "Unix time the last successful run of a scheduled task finished",
+		},
+		[]string{"task"},
+	)
+	taskNextRun = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cron_task_next_run_timestamp_seconds",
+			Help: "Unix time a scheduled task runs next",
+		},
+		[]string{"task"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(taskRunsTotal)
+	prometheus.MustRegister(taskDuration)
+	prometheus.MustRegister(taskLastSuccess)
+	prometheus.MustRegister(taskNextRun)
+}
@@ -0,0 +1,212 @@
+// Package currency converts money between currencies using exchange rates
+// from a pluggable source (a fixed table, the ECB reference feed or a JSON
+// rate API), cached for a configurable time.
+package currency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/money"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrUnsupportedCurrency is returned when the rate source has no rate for a currency
+var ErrUnsupportedCurrency = errors.New("unsupported currency")
+
+// Rates are exchange rates relative to Base: one unit of Base buys
+// Rates[code] units of code
+type Rates struct {
+	Base  string
+	Rates map[string]*big.Rat
+	AsOf  time.Time
+}
+
+// rate returns units of code per unit of Base
+func (r Rates) rate(code string) (*big.Rat, bool) {
+	if code == r.Base {
+		return big.NewRat(1, 1), true
+	}
+	rate, ok := r.Rates[code]
+	return rate, ok
+}
+
+// Source supplies exchange rates
+type Source interface {
+	Rates(ctx context.Context) (Rates, error)
+}
+
+// FixedSource serves a static rate table, e.g. for tests or offline setups
+type FixedSource struct {
+	table Rates
+}
+
+// NewFixedSource returns a source for rates such as {"EUR": "0.92"} relative
+// to base. Rates are decimal strings so they are represented exactly.
+func NewFixedSource(base string, rates map[string]string) (*FixedSource, error) {
+	table := Rates{Base: strings.ToUpper(base), Rates: map[string]*big.Rat{}}
+	for code, value := range rates {
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid rate %q for %s", value, code)
+		}
+		table.Rates[strings.ToUpper(code)] = rate
+	}
+	return &FixedSource{table: table}, nil
+}
+
+// ParseFixedRates parses "EUR=0.92,GBP=0.79" into a rate table
+func ParseFixedRates(spec string) (map[string]string, error) {
+	rates := map[string]string{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate %q, expected CODE=rate", pair)
+		}
+		rates[strings.TrimSpace(code)] = strings.TrimSpace(value)
+	}
+	return rates, nil
+}
+
+// Rates returns the fixed table
+func (s *FixedSource) Rates(context.Context) (Rates, error) {
+	return s.table, nil
+}
+
+// Converter converts money using rates from a source, refreshing them once
+// they are older than the TTL. If a refresh fails the previous rates keep
+// being served.
+type Converter struct {
+	source Source
+	ttl    time.Duration
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	rates     Rates
+	fetchedAt time.Time
+}
+
+// NewConverter returns a converter caching rates from source for ttl
+func NewConverter(source Source, ttl time.Duration, clk clock.Clock) *Converter {
+	if clk == nil {
+		clk = clock.System{}
+	}
+	return &Converter{source: source, ttl: ttl, clock: clk}
+}
+
+// Convert returns amount expressed in currency to, rounded half to even in
+// the target's minor unit
+func (c *Converter) Convert(ctx context.Context, amount money.Money, to string) (money.Money, error) {
+	to, err := money.NormalizeCurrency(to)
+	if err != nil {
+		return money.Money{}, err
+	}
+	if amount.Currency == to {
+		return amount, nil
+	}
+
+	rates, err := c.current(ctx)
+	if err != nil {
+		return money.Money{}, err
+	}
+	fromRate, ok := rates.rate(amount.Currency)
+	if !ok {
+		return money.Money{}, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, amount.Currency)
+	}
+	toRate, ok := rates.rate(to)
+	if !ok {
+		return money.Money{}, fmt.Errorf("%w: %s", ErrUnsupportedCurrency, to)
+	}
+
+	// minor_to = minor_from / 10^exp_from / rate_from * rate_to * 10^exp_to
+	v := new(big.Rat).SetInt64(amount.Amount)
+	v.Quo(v, fromRate)
+	v.Mul(v, toRate)
+	v.Mul(v, pow10(money.Exponent(to)))
+	v.Quo(v, pow10(money.Exponent(amount.Currency)))
+
+	return money.New(roundHalfEven(v), to), nil
+}
+
+// Sum converts every amount to currency to and adds them up, e.g. to report
+// a single total for a user who ordered in several currencies
+func (c *Converter) Sum(ctx context.Context, amounts []money.Money, to string) (money.Money, error) {
+	to, err := money.NormalizeCurrency(to)
+	if err != nil {
+		return money.Money{}, err
+	}
+	total := money.Zero(to)
+	for _, amount := range amounts {
+		converted, err := c.Convert(ctx, amount, to)
+		if err != nil {
+			return money.Money{}, err
+		}
+		if total, err = total.Add(converted); err != nil {
+			return money.Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// Warm fetches rates unless fresh ones are cached, so conversions do not
+// wait on the source. It only fails while no rates were ever fetched.
+func (c *Converter) Warm(ctx context.Context) error {
+	_, err := c.current(ctx)
+	return err
+}
+
+func (c *Converter) current(ctx context.Context) (Rates, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if c.rates.Rates != nil && now.Sub(c.fetchedAt) < c.ttl {
+		return c.rates, nil
+	}
+
+	rates, err := c.source.Rates(ctx)
+	if err != nil {
+		if c.rates.Rates != nil {
+			log.Warn().Err(err).Time("as_of", c.rates.AsOf).Msg("Exchange rate refresh failed, serving cached rates")
+			return c.rates, nil
+		}
+		return Rates{}, err
+	}
+	c.rates = rates
+	c.fetchedAt = now
+	return rates, nil
+}
+
+func pow10(exp int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exp)), nil))
+}
+
+// roundHalfEven rounds v to the nearest integer, ties to even
+func roundHalfEven(v *big.Rat) int64 {
+	num, den := v.Num(), v.Denom()
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+
+	// Compare 2|r| with the denominator to decide the rounding direction
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+	switch cmp := twice.Cmp(den); {
+	case cmp > 0, cmp == 0 && q.Bit(0) == 1:
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
@@ -0,0 +1,74 @@
+package currency
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"order-service/pkg/httpclient"
+)
+
+// ECBDailyURL is the European Central Bank's daily reference rate feed
+const ECBDailyURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+
+// ECBSource reads EUR-based reference rates from the ECB feed
+type ECBSource struct {
+	url    string
+	client *httpclient.Client
+}
+
+// NewECBSource returns a source for the feed at url (ECBDailyURL if empty)
+func NewECBSource(url string) *ECBSource {
+	if url == "" {
+		url = ECBDailyURL
+	}
+	return &ECBSource{url: url, client: httpclient.New(httpclient.DefaultConfig("ecb-rates"))}
+}
+
+type ecbEnvelope struct {
+	Cube struct {
+		Cube struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube"`
+	} `xml:"Cube"`
+}
+
+// Rates fetches the latest published rates
+func (s *ECBSource) Rates(ctx context.Context) (Rates, error) {
+	resp, err := s.client.Get(ctx, s.url)
+	if err != nil {
+		return Rates{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Rates{}, fmt.Errorf("ECB feed returned status %d", resp.StatusCode)
+	}
+
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return Rates{}, fmt.Errorf("decode ECB feed: %w", err)
+	}
+
+	daily := envelope.Cube.Cube
+	rates := Rates{Base: "EUR", Rates: make(map[string]*big.Rat, len(daily.Rates))}
+	rates.AsOf, _ = time.Parse("2006-01-02", daily.Time)
+	for _, r := range daily.Rates {
+		rate, ok := new(big.Rat).SetString(r.Rate)
+		if !ok || rate.Sign() <= 0 {
+			return Rates{}, fmt.Errorf("ECB feed has invalid rate %q for %s", r.Rate, r.Currency)
+		}
+		rates.Rates[r.Currency] = rate
+	}
+	if len(rates.Rates) == 0 {
+		return Rates{}, fmt.Errorf("ECB feed contained no rates")
+	}
+	return rates, nil
+}
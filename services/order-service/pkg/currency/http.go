@@ -0,0 +1,80 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"order-service/pkg/httpclient"
+)
+
+// HTTPSource reads rates from a JSON endpoint in the format shared by most
+// commercial rate APIs (exchangerate.host, Open Exchange Rates, Fixer):
+//
+//	{"base": "USD", "date": "2024-05-01", "rates": {"EUR": 0.92, "GBP": 0.79}}
+//
+// A "timestamp" in Unix seconds is used for AsOf when there is no date.
+// Wrap it in a Converter to cache the rates.
+type HTTPSource struct {
+	url    string
+	client *httpclient.Client
+}
+
+// NewHTTPSource returns a source for the endpoint at url, which carries any
+// API key the provider needs as a query parameter
+func NewHTTPSource(url string) *HTTPSource {
+	return &HTTPSource{url: url, client: httpclient.New(httpclient.DefaultConfig("exchange-rates"))}
+}
+
+type httpRates struct {
+	Base      string                 `json:"base"`
+	Date      string                 `json:"date"`
+	Timestamp int64                  `json:"timestamp"`
+	Rates     map[string]json.Number `json:"rates"`
+}
+
+// Rates fetches the latest rates
+func (s *HTTPSource) Rates(ctx context.Context) (Rates, error) {
+	resp, err := s.client.Get(ctx, s.url)
+	if err != nil {
+		return Rates{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Rates{}, fmt.Errorf("rate endpoint returned status %d", resp.StatusCode)
+	}
+
+	var body httpRates
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return Rates{}, fmt.Errorf("decode rates: %w", err)
+	}
+	if body.Base == "" {
+		return Rates{}, fmt.Errorf("rate endpoint returned no base currency")
+	}
+
+	rates := Rates{Base: strings.ToUpper(body.Base), Rates: make(map[string]*big.Rat, len(body.Rates))}
+	if body.Date != "" {
+		rates.AsOf, _ = time.Parse("2006-01-02", body.Date)
+	} else if body.Timestamp > 0 {
+		rates.AsOf = time.Unix(body.Timestamp, 0).UTC()
+	}
+	// Rates are decoded as json.Number so they stay exact decimals
+	for code, value := range body.Rates {
+		rate, ok := new(big.Rat).SetString(value.String())
+		if !ok || rate.Sign() <= 0 {
+			return Rates{}, fmt.Errorf("rate endpoint has invalid rate %q for %s", value, code)
+		}
+		rates.Rates[strings.ToUpper(code)] = rate
+	}
+	if len(rates.Rates) == 0 {
+		return Rates{}, fmt.Errorf("rate endpoint returned no rates")
+	}
+	return rates, nil
+}
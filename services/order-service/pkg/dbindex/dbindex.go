@@ -0,0 +1,286 @@
+// Package dbindex declares the indexes a collection needs in code and
+// creates or verifies them at startup, reporting each one, so a missing
+// index shows in the logs and readiness rather than as slow collection
+// scans in production.
+package dbindex
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Index is an index a collection needs
+type Index struct {
+	Keys   bson.D
+	Unique bool
+	// Critical indexes enforce uniqueness or back queries too slow to serve
+	// without them
+	Critical bool
+	// Weights rank the fields of a text index, whose keys have the value
+	// "text", against each other; fields left out weigh 1. Language is
+	// its stemming language, "none" for none, and English when empty.
+	Weights  bson.D
+	Language string
+}
+
+// Name is the name MongoDB gives the index by default, such as
+// user_id_1_created_at_-1. Indexes are matched by their keys, so existing
+// indexes named otherwise are recognized.
+func (i Index) Name() string {
+	parts := make([]string, 0, len(i.Keys))
+	for _, k := range i.Keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+// listedName is the default name of an index with the keys MongoDB lists
+// for it, which for a text index are _fts and _ftsx in place of its
+// fields, so it can be found among the existing ones
+func (i Index) listedName() string {
+	var parts []string
+	text := false
+	for _, k := range i.Keys {
+		if k.Value != "text" {
+			parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+		} else if !text {
+			parts = append(parts, "_fts_text", "_ftsx_1")
+			text = true
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// options returns the options the index is created with
+func (i Index) options() *options.IndexOptions {
+	opts := options.Index().SetUnique(i.Unique)
+	if len(i.Weights) > 0 {
+		opts.SetWeights(i.Weights)
+	}
+	if i.Language != "" {
+		opts.SetDefaultLanguage(i.Language)
+	}
+	return opts
+}
+
+// Index states
+const (
+	StatePresent = "present"
+	StateCreated = "created"
+	StateMissing = "missing"
+	// StateConflict is an index on the same keys with other options, such
+	// as a non-unique one where a unique one is needed, or a text index over
+	// other fields. It is never dropped automatically; see Conflicting.
+	StateConflict = "conflict"
+	StateFailed   = "failed"
+)
+
+// Status is the state of one index
+type Status struct {
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Critical   bool   `json:"critical"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ok reports whether the index is in place
+func (s Status) ok() bool {
+	return s.State == StatePresent || s.State == StateCreated
+}
+
+// Report is the state of every declared index
+type Report struct {
+	Indexes []Status `json:"indexes"`
+}
+
+// Err reports the critical indexes not in place, nil if there are none
+func (r Report) Err() error {
+	var missing []string
+	for _, s := range r.Indexes {
+		if s.Critical && !s.ok() {
+			missing = append(missing, fmt.Sprintf("%s.%s (%s)", s.Collection, s.Name, s.State))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("critical indexes missing: %s", strings.Join(missing, ", "))
+}
+
+// Log logs every index not in place, and a summary
+func (r Report) Log() {
+	counts := map[string]int{}
+	for _, s := range r.Indexes {
+		counts[s.State]++
+		if s.ok() {
+			continue
+		}
+		event := log.Warn()
+		if s.Critical {
+			event = log.Error()
+		}
+		if s.Error != "" {
+			event = event.Str("error", s.Error)
+		}
+		event.Str("collection", s.Collection).Str("index", s.Name).Str("state", s.State).
+			Bool("critical", s.Critical).Msg("Index not in place")
+	}
+	log.Info().Int("present", counts[StatePresent]).Int("created", counts[StateCreated]).
+		Int("missing", counts[StateMissing]).Int("conflict", counts[StateConflict]).
+		Int("failed", counts[StateFailed]).Msg("Index report")
+}
+
+// spec is an existing index as MongoDB lists it
+type spec struct {
+	Name   string   `bson:"name"`
+	Keys   bson.Raw `bson:"key"`
+	Unique bool     `bson:"unique"`
+	// Weights are set on text indexes, by field
+	Weights map[string]interface{} `bson:"weights"`
+}
+
+// conflict describes how s differs from index, on the same keys; it is
+// empty when s is index
+func (s spec) conflict(index Index) string {
+	if s.Unique != index.Unique {
+		return fmt.Sprintf("index %s exists with unique=%t; drop it to have it recreated", s.Name, s.Unique)
+	}
+	if s.Weights == nil {
+		return ""
+	}
+	want := map[string]int64{}
+	for _, k := range index.Keys {
+		if k.Value == "text" {
+			want[k.Key] = 1
+		}
+	}
+	for _, w := range index.Weights {
+		want[w.Key] = weight(w.Value)
+	}
+	same := len(s.Weights) == len(want)
+	for field, w := range s.Weights {
+		if want[field] != weight(w) {
+			same = false
+		}
+	}
+	if !same {
+		return fmt.Sprintf("text index %s exists over other fields or weights; drop it to have it recreated", s.Name)
+	}
+	return ""
+}
+
+// weight returns a text index weight, which MongoDB lists as any number
+func weight(v interface{}) int64 {
+	switch w := v.(type) {
+	case int32:
+		return int64(w)
+	case int64:
+		return w
+	case int:
+		return int64(w)
+	case float64:
+		return int64(w)
+	}
+	return 0
+}
+
+// specs returns the existing indexes of coll by the default name of their
+// keys
+func specs(ctx context.Context, coll *mongo.Collection) (map[string]spec, error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list indexes of %s: %w", coll.Name(), err)
+	}
+	var listed []spec
+	if err := cursor.All(ctx, &listed); err != nil {
+		return nil, fmt.Errorf("list indexes of %s: %w", coll.Name(), err)
+	}
+	existing := make(map[string]spec, len(listed))
+	for _, s := range listed {
+		existing[keysName(s.Keys)] = s
+	}
+	return existing, nil
+}
+
+// Conflicting returns the names of the existing indexes of coll in
+// conflict with indexes, such as a text index declared over fields since
+// left out. Dropping them has Ensure create the declared ones instead.
+func Conflicting(ctx context.Context, coll *mongo.Collection, indexes []Index) ([]string, error) {
+	existing, err := specs(ctx, coll)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, index := range indexes {
+		if s, ok := existing[index.listedName()]; ok && s.conflict(index) != "" {
+			names = append(names, s.Name)
+		}
+	}
+	return names, nil
+}
+
+// Ensure verifies the indexes of coll, creating the missing ones when
+// create is set. It fails only if the existing indexes cannot be listed;
+// an index that cannot be created is reported as failed.
+func Ensure(ctx context.Context, coll *mongo.Collection, indexes []Index, create bool) (Report, error) {
+	existing, err := specs(ctx, coll)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	for _, index := range indexes {
+		status := Status{Collection: coll.Name(), Name: index.Name(), Critical: index.Critical}
+		if s, ok := existing[index.listedName()]; ok {
+			status.State = StatePresent
+			if conflict := s.conflict(index); conflict != "" {
+				status.State = StateConflict
+				status.Error = conflict
+			}
+		} else if !create {
+			status.State = StateMissing
+		} else if _, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    index.Keys,
+			Options: index.options(),
+		}); err != nil {
+			status.State = StateFailed
+			status.Error = err.Error()
+		} else {
+			status.State = StateCreated
+		}
+		report.Indexes = append(report.Indexes, status)
+	}
+	return report, nil
+}
+
+// keysName is the default name of an index with the keys of an existing
+// one, so both are compared the same way
+func keysName(keys bson.Raw) string {
+	elems, _ := keys.Elements()
+	parts := make([]string, 0, len(elems))
+	for _, e := range elems {
+		v := e.Value()
+		value := v.String()
+		switch v.Type {
+		case bsontype.Int32:
+			value = strconv.FormatInt(int64(v.Int32()), 10)
+		case bsontype.Int64:
+			value = strconv.FormatInt(v.Int64(), 10)
+		case bsontype.Double:
+			value = strconv.FormatFloat(v.Double(), 'g', -1, 64)
+		case bsontype.String:
+			value = v.StringValue()
+		}
+		parts = append(parts, e.Key()+"_"+value)
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,49 @@
+package dbindex
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestConflict(t *testing.T) {
+	text := Index{
+		Keys:    bson.D{{Key: "order_id", Value: "text"}, {Key: "items.name", Value: "text"}, {Key: "shipping_address.city", Value: "text"}},
+		Weights: bson.D{{Key: "order_id", Value: int32(10)}, {Key: "items.name", Value: int32(5)}},
+	}
+	tests := []struct {
+		name     string
+		existing spec
+		index    Index
+		conflict bool
+	}{
+		{name: "same", existing: spec{}, index: Index{Keys: bson.D{{Key: "user_id", Value: 1}}}},
+		{name: "not unique", existing: spec{}, index: Index{Keys: bson.D{{Key: "order_id", Value: 1}}, Unique: true}, conflict: true},
+		{
+			name:     "same text",
+			existing: spec{Weights: map[string]interface{}{"order_id": int32(10), "items.name": int32(5), "shipping_address.city": int32(1)}},
+			index:    text,
+		},
+		{
+			name: "text over a field left out",
+			existing: spec{Weights: map[string]interface{}{
+				"order_id": int32(10), "items.name": int32(5), "shipping_address.city": int32(1), "shipping_address.name": int32(1),
+			}},
+			index:    text,
+			conflict: true,
+		},
+		{
+			name:     "text weighed otherwise",
+			existing: spec{Weights: map[string]interface{}{"order_id": int32(1), "items.name": int32(5), "shipping_address.city": int32(1)}},
+			index:    text,
+			conflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.existing.conflict(tt.index); (got != "") != tt.conflict {
+				t.Errorf("conflict = %q, want conflict %t", got, tt.conflict)
+			}
+		})
+	}
+}
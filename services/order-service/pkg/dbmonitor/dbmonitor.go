@@ -0,0 +1,176 @@
+// Package dbmonitor instruments MongoDB clients through the driver's
+// command monitoring: every command's latency is observed per operation
+// and collection, and commands slower than a threshold are logged with
+// their filter, its values redacted so no customer data reaches the logs.
+// Pool monitoring exports the connection pools' usage and tells when they
+// are exhausted.
+package dbmonitor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var commandDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "mongodb_command_duration_seconds",
+		Help:    "MongoDB command latency by operation, collection and outcome",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	},
+	[]string{"operation", "collection", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(commandDuration)
+}
+
+// monitor tracks the commands in flight between their started and
+// finished events
+type monitor struct {
+	slow     time.Duration
+	inFlight sync.Map // commandKey -> started
+}
+
+type commandKey struct {
+	connection string
+	request    int64
+}
+
+// started is what the finished event needs of the started one. The filter
+// is copied, as the driver may reuse the command's buffer.
+type started struct {
+	collection string
+	database   string
+	filter     bson.Raw
+}
+
+// New returns a command monitor for a client's options that logs commands
+// slower than slow; 0 logs none
+func New(slow time.Duration) *event.CommandMonitor {
+	m := &monitor{slow: slow}
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			m.finished(ctx, e.CommandFinishedEvent, "success")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			m.finished(ctx, e.CommandFinishedEvent, "failure")
+		},
+	}
+}
+
+func (m *monitor) started(ctx context.Context, e *event.CommandStartedEvent) {
+	s := started{database: e.DatabaseName}
+	if name, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		s.collection = name
+	}
+	if m.slow > 0 {
+		if filter := filterOf(e.CommandName, e.Command); filter != nil {
+			s.filter = append(bson.Raw(nil), filter...)
+		}
+	}
+	m.inFlight.Store(commandKey{e.ConnectionID, e.RequestID}, s)
+}
+
+func (m *monitor) finished(ctx context.Context, e event.CommandFinishedEvent, result string) {
+	value, ok := m.inFlight.LoadAndDelete(commandKey{e.ConnectionID, e.RequestID})
+	if !ok {
+		return
+	}
+	s := value.(started)
+	duration := time.Duration(e.DurationNanos)
+	commandDuration.WithLabelValues(e.CommandName, s.collection, result).Observe(duration.Seconds())
+
+	if m.slow <= 0 || duration < m.slow {
+		return
+	}
+	entry := log.Ctx(ctx).Warn().
+		Str("operation", e.CommandName).
+		Str("database", s.database).
+		Str("collection", s.collection).
+		Str("result", result).
+		Dur("duration", duration)
+	if s.filter != nil {
+		entry = entry.Str("filter", redactFilter(s.filter))
+	}
+	entry.Msg("Slow MongoDB command")
+}
+
+// filterOf returns the part of a command selecting documents: a query's
+// filter, the first statement's of a write, or an aggregation's pipeline
+// wrapped in a document. Inserted documents are never returned.
+func filterOf(name string, cmd bson.Raw) bson.Raw {
+	var value bson.RawValue
+	var err error
+	switch name {
+	case "find":
+		value, err = cmd.LookupErr("filter")
+	case "count", "distinct", "findAndModify":
+		value, err = cmd.LookupErr("query")
+	case "update":
+		value, err = cmd.LookupErr("updates", "0", "q")
+	case "delete":
+		value, err = cmd.LookupErr("deletes", "0", "q")
+	case "aggregate":
+		if value, err = cmd.LookupErr("pipeline"); err == nil {
+			doc, merr := bson.Marshal(bson.D{{Key: "pipeline", Value: value}})
+			if merr != nil {
+				return nil
+			}
+			return doc
+		}
+	default:
+		return nil
+	}
+	if err != nil || value.Type != bsontype.EmbeddedDocument {
+		return nil
+	}
+	return value.Document()
+}
+
+// redactFilter returns doc as extended JSON with every value replaced by "?",
+// keeping the field names and operators that show its shape
+func redactFilter(doc bson.Raw) string {
+	redacted, ok := redact(bson.RawValue{Type: bsontype.EmbeddedDocument, Value: doc}).(bson.D)
+	if !ok {
+		return ""
+	}
+	out, err := bson.MarshalExtJSON(redacted, false, false)
+	if err != nil {
+		return ""
+	}
+	return string(out)
+}
+
+func redact(value bson.RawValue) interface{} {
+	switch value.Type {
+	case bsontype.EmbeddedDocument:
+		elements, err := value.Document().Elements()
+		if err != nil {
+			return "?"
+		}
+		doc := make(bson.D, 0, len(elements))
+		for _, e := range elements {
+			doc = append(doc, bson.E{Key: e.Key(), Value: redact(e.Value())})
+		}
+		return doc
+	case bsontype.Array:
+		values, err := value.Array().Values()
+		if err != nil {
+			return "?"
+		}
+		array := make(bson.A, 0, len(values))
+		for _, v := range values {
+			array = append(array, redact(v))
+		}
+		return array
+	}
+	return "?"
+}
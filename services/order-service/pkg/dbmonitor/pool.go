@@ -0,0 +1,157 @@
+package dbmonitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	poolMaxConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_pool_max_connections",
+			Help: "Maximum size of the MongoDB connection pool of each server",
+		},
+		[]string{"address"},
+	)
+
+	poolOpenConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_pool_open_connections",
+			Help: "Connections open in the MongoDB connection pool of each server",
+		},
+		[]string{"address"},
+	)
+
+	poolInUseConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_pool_in_use_connections",
+			Help: "Connections checked out of the MongoDB connection pool of each server",
+		},
+		[]string{"address"},
+	)
+
+	poolWaiting = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mongodb_pool_waiting_checkouts",
+			Help: "Operations waiting for a connection from the MongoDB connection pool of each server",
+		},
+		[]string{"address"},
+	)
+
+	poolCheckoutWait = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mongodb_pool_checkout_wait_seconds",
+			Help:    "Time operations waited for a connection from the MongoDB connection pool",
+			Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		},
+		[]string{"address"},
+	)
+
+	poolCheckoutFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mongodb_pool_checkout_failures_total",
+			Help: "Connections the MongoDB connection pool failed to hand out, by reason",
+		},
+		[]string{"address", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(poolMaxConnections, poolOpenConnections, poolInUseConnections,
+		poolWaiting, poolCheckoutWait, poolCheckoutFailures)
+}
+
+// pool is what is known of the connection pool of one server. Checkouts
+// are not identified in pool events, so each one ending is matched with
+// the oldest one started: the wait times' sum and count are exact, while
+// single waits are approximated.
+type pool struct {
+	max       uint64
+	open      int
+	inUse     int
+	started   []time.Time
+	exhausted bool
+}
+
+// pools are the connection pools of every client, by server address
+var pools = struct {
+	sync.Mutex
+	byAddress map[string]*pool
+}{byAddress: map[string]*pool{}}
+
+// NewPoolMonitor returns a pool monitor for a client's options that
+// exports its connection pools as metrics and feeds Exhausted
+func NewPoolMonitor() *event.PoolMonitor {
+	return &event.PoolMonitor{Event: poolEvent}
+}
+
+func poolEvent(e *event.PoolEvent) {
+	pools.Lock()
+	defer pools.Unlock()
+	p, ok := pools.byAddress[e.Address]
+	if !ok {
+		p = &pool{}
+		pools.byAddress[e.Address] = p
+	}
+
+	switch e.Type {
+	case event.PoolCreated:
+		if e.PoolOptions != nil {
+			p.max = e.PoolOptions.MaxPoolSize
+			poolMaxConnections.WithLabelValues(e.Address).Set(float64(p.max))
+		}
+	case event.ConnectionCreated:
+		p.open++
+	case event.ConnectionClosed:
+		p.open--
+	case event.GetStarted:
+		p.started = append(p.started, time.Now())
+	case event.GetSucceeded, event.GetFailed:
+		if len(p.started) > 0 {
+			poolCheckoutWait.WithLabelValues(e.Address).Observe(time.Since(p.started[0]).Seconds())
+			p.started = p.started[1:]
+		}
+		if e.Type == event.GetSucceeded {
+			p.inUse++
+		} else {
+			poolCheckoutFailures.WithLabelValues(e.Address, e.Reason).Inc()
+		}
+	case event.ConnectionReturned:
+		p.inUse--
+	}
+	poolOpenConnections.WithLabelValues(e.Address).Set(float64(p.open))
+	poolInUseConnections.WithLabelValues(e.Address).Set(float64(p.inUse))
+	poolWaiting.WithLabelValues(e.Address).Set(float64(len(p.started)))
+}
+
+// Exhausted reports whether the pool of any server has every connection
+// in use and at least maxWaiting operations waiting for one, so requests
+// can be refused at once rather than queue until their deadline. A
+// maxWaiting of 0 never reports exhaustion. The first report and the
+// recovery of each pool are logged.
+func Exhausted(maxWaiting int) bool {
+	if maxWaiting <= 0 {
+		return false
+	}
+	pools.Lock()
+	defer pools.Unlock()
+	exhausted := false
+	for address, p := range pools.byAddress {
+		full := p.max > 0 && uint64(p.inUse) >= p.max && len(p.started) >= maxWaiting
+		if full != p.exhausted {
+			p.exhausted = full
+			if full {
+				log.Warn().Str("address", address).Uint64("max_pool_size", p.max).Int("waiting", len(p.started)).
+					Msg("MongoDB connection pool exhausted, refusing requests")
+			} else {
+				log.Info().Str("address", address).Msg("MongoDB connection pool recovered")
+			}
+		}
+		exhausted = exhausted || full
+	}
+	return exhausted
+}
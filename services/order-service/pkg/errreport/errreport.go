@@ -0,0 +1,102 @@
+// Package errreport sends panics and server errors to an error tracker,
+// with their stack trace, the request that failed and the user who made
+// it, so they can be grouped and triaged instead of searched for in logs.
+// Sentry and backends speaking its protocol are supported through a DSN.
+package errreport
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Event is one error to report
+type Event struct {
+	// Message says what failed, e.g. the message logged with the error
+	Message string
+	// Err is the error, or the value a panic was raised with
+	Err error
+	// Panic marks recovered panics
+	Panic bool
+	// Stack is where it happened, innermost frame first, as returned by
+	// Callers
+	Stack []uintptr
+	// Request is the HTTP request that failed, if any. Only its method,
+	// URL without the query and a few harmless headers are reported.
+	Request *http.Request
+	// Route is the route of the request, or the gRPC method called
+	Route string
+	// Status is the HTTP status answered, or 0
+	Status int
+	// UserID is who made the request, if known
+	UserID string
+	// RequestID joins the event with the request's log entries
+	RequestID string
+	// Tags are searchable labels, such as the gRPC code
+	Tags map[string]string
+}
+
+// ErrorReporter reports events to an error tracker. Report must not block
+// the request; Close flushes pending events until ctx is done.
+type ErrorReporter interface {
+	Report(ctx context.Context, e Event)
+	Close(ctx context.Context) error
+}
+
+// Nop reports nothing, for when no tracker is configured
+type Nop struct{}
+
+// Report does nothing
+func (Nop) Report(context.Context, Event) {}
+
+// Close does nothing
+func (Nop) Close(context.Context) error { return nil }
+
+// maxFrames bounds the stack traces kept
+const maxFrames = 64
+
+// Callers returns the stack of its caller, skipping skip more frames
+func Callers(skip int) []uintptr {
+	pcs := make([]uintptr, maxFrames)
+	return pcs[:runtime.Callers(skip+2, pcs)]
+}
+
+// Capture remembers the last error a request logged through log.Ctx and
+// where it was logged, so a failed response can be reported with the
+// cause its handler logged
+type Capture struct {
+	mu      sync.Mutex
+	message string
+	stack   []uintptr
+}
+
+// WithCapture returns ctx with a logger whose error entries are remembered
+// by the returned Capture
+func WithCapture(ctx context.Context) (context.Context, *Capture) {
+	c := &Capture{}
+	logger := log.Ctx(ctx).Hook(c)
+	return logger.WithContext(ctx), c
+}
+
+// Run records error, fatal and panic entries
+func (c *Capture) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	if level < zerolog.ErrorLevel || level >= zerolog.NoLevel {
+		return
+	}
+	stack := Callers(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.message = msg
+	c.stack = stack
+}
+
+// Last returns the last error entry logged; ok is false if none was
+func (c *Capture) Last() (message string, stack []uintptr, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.message, c.stack, c.stack != nil
+}
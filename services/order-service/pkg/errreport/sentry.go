@@ -0,0 +1,345 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/httpclient"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+var reports = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "error_reports_total",
+		Help: "Events sent to the error tracker by result (sent, failed, dropped)",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(reports)
+}
+
+// DefaultQueueSize is how many events wait to be sent before new ones are
+// dropped, so an outage of the tracker cannot hold requests up
+const DefaultQueueSize = 100
+
+// reportedHeaders are the request headers sent along; the others may
+// carry credentials or personal data
+var reportedHeaders = []string{"Accept", "Content-Type", "Content-Length", "User-Agent", "X-Request-ID"}
+
+// SentryOptions describe the service in the events sent
+type SentryOptions struct {
+	// Environment, such as "production", separates events in the tracker
+	Environment string
+	// Release is the version of the service that failed
+	Release string
+	// ServerName defaults to the host name
+	ServerName string
+	// QueueSize defaults to DefaultQueueSize
+	QueueSize int
+}
+
+// Sentry sends events to a Sentry-compatible tracker's store endpoint in
+// the background
+type Sentry struct {
+	endpoint string
+	auth     string
+	opts     SentryOptions
+	client   *httpclient.Client
+
+	queue     chan *sentryEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ParseDSN returns the store endpoint and public key of a Sentry DSN, such
+// as https://<key>@o0.ingest.sentry.io/<project>
+func ParseDSN(dsn string) (endpoint, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid DSN: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return "", "", fmt.Errorf("invalid DSN: scheme must be http or https")
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing public key")
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || path[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid DSN: missing project ID")
+	}
+	endpoint = fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], path[i+1:])
+	return endpoint, u.User.Username(), nil
+}
+
+// NewSentry returns a reporter sending to the tracker of dsn. It sends
+// until Close.
+func NewSentry(dsn string, opts SentryOptions) (*Sentry, error) {
+	endpoint, key, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ServerName == "" {
+		opts.ServerName, _ = os.Hostname()
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	cfg := httpclient.DefaultConfig("error-tracker")
+	cfg.MaxRetries = 1
+	s := &Sentry{
+		endpoint: endpoint,
+		auth:     "Sentry sentry_version=7, sentry_client=order-service/1.0, sentry_key=" + key,
+		opts:     opts,
+		client:   httpclient.New(cfg),
+		queue:    make(chan *sentryEvent, opts.QueueSize),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s, nil
+}
+
+// Report queues e, or drops it while the queue is full
+func (s *Sentry) Report(ctx context.Context, e Event) {
+	select {
+	case s.queue <- s.event(e):
+	default:
+		reports.WithLabelValues("dropped").Inc()
+		log.Ctx(ctx).Warn().Str("message", e.Message).Msg("Error tracker queue full, event dropped")
+	}
+}
+
+// Close sends the queued events, until ctx is done
+func (s *Sentry) Close(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.queue) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Sentry) run() {
+	defer close(s.done)
+	for event := range s.queue {
+		if err := s.send(event); err != nil {
+			reports.WithLabelValues("failed").Inc()
+			log.Warn().Err(err).Str("event_id", event.EventID).Msg("Failed to send event to error tracker")
+			continue
+		}
+		reports.WithLabelValues("sent").Inc()
+	}
+}
+
+func (s *Sentry) send(event *sentryEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error tracker answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// sentryEvent is an event in Sentry's store format
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Transaction string            `json:"transaction,omitempty"`
+	Exception   *sentryExceptions `json:"exception,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	User        *sentryUser       `json:"user,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Mechanism  sentryMechanism   `json:"mechanism"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryMechanism struct {
+	Type    string `json:"type"`
+	Handled bool   `json:"handled"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type sentryUser struct {
+	ID string `json:"id"`
+}
+
+// event converts e while its request is still being served
+func (s *Sentry) event(e Event) *sentryEvent {
+	event := &sentryEvent{
+		EventID:     strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       "error",
+		Platform:    "go",
+		ServerName:  s.opts.ServerName,
+		Environment: s.opts.Environment,
+		Release:     s.opts.Release,
+		Message:     e.Message,
+		Transaction: e.Route,
+		Tags:        map[string]string{},
+	}
+	for k, v := range e.Tags {
+		event.Tags[k] = v
+	}
+	if e.RequestID != "" {
+		event.Tags["request_id"] = e.RequestID
+	}
+	if e.Status != 0 {
+		event.Tags["status"] = fmt.Sprint(e.Status)
+	}
+	if e.UserID != "" {
+		event.User = &sentryUser{ID: e.UserID}
+	}
+
+	exception := sentryException{Type: "error", Value: e.Message, Mechanism: sentryMechanism{Type: "generic", Handled: true}}
+	if e.Err != nil {
+		exception.Type = fmt.Sprintf("%T", e.Err)
+		exception.Value = e.Err.Error()
+	}
+	if e.Panic {
+		event.Level = "fatal"
+		exception.Type = "panic"
+		exception.Mechanism = sentryMechanism{Type: "panic", Handled: false}
+	}
+	if frames := stackFrames(e.Stack); len(frames) > 0 {
+		exception.Stacktrace = &sentryStacktrace{Frames: frames}
+	}
+	event.Exception = &sentryExceptions{Values: []sentryException{exception}}
+
+	if r := e.Request; r != nil {
+		u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
+		if r.TLS != nil {
+			u.Scheme = "https"
+		}
+		event.Request = &sentryRequest{URL: u.String(), Method: r.Method, Headers: map[string]string{}}
+		for _, name := range reportedHeaders {
+			if v := r.Header.Get(name); v != "" {
+				event.Request.Headers[name] = v
+			}
+		}
+	}
+	return event
+}
+
+// stackFrames returns the frames of stack outermost first, as Sentry
+// expects them, leaving out the runtime's and those recording the stack
+func stackFrames(stack []uintptr) []sentryFrame {
+	if len(stack) == 0 {
+		return nil
+	}
+	var frames []sentryFrame
+	iter := runtime.CallersFrames(stack)
+	for {
+		f, more := iter.Next()
+		if f.Function != "" && !skipFrame(f.Function) {
+			module, function := splitFunction(f.Function)
+			frames = append(frames, sentryFrame{
+				Function: function,
+				Module:   module,
+				Filename: shortFile(f.File),
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(module, "order-service"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+func skipFrame(function string) bool {
+	return strings.HasPrefix(function, "runtime.") ||
+		strings.HasPrefix(function, "github.com/rs/zerolog") ||
+		strings.HasPrefix(function, "order-service/pkg/errreport.")
+}
+
+// splitFunction splits "order-service/internal/api.(*Handler).getOrder"
+// into its package and function
+func splitFunction(name string) (module, function string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
+
+// shortFile returns the last two elements of path
+func shortFile(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return path
+	}
+	if j := strings.LastIndex(path[:i], "/"); j >= 0 {
+		return path[j+1:]
+	}
+	return path
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"context"
+
+	"order-service/pkg/contracts"
+)
+
+// Message buses order events can be published to
+const (
+	BusNone  = "none"
+	BusKafka = "kafka"
+	BusNATS  = "nats"
+)
+
+// MessageBus is a broker order events are published to for other
+// services. Each event goes to a topic or subject named after its type;
+// cancellations also go to order.cancelled, see outgoing.
+type MessageBus interface {
+	Publisher
+	// Ping checks that the broker can be reached
+	Ping(ctx context.Context) error
+	// Close flushes pending writes and releases the broker connection
+	Close() error
+}
+
+// outgoingEvent is an event as a bus delivers it
+type outgoingEvent struct {
+	event   contracts.Event
+	headers map[string]string
+}
+
+// outgoing returns the events a bus delivers for event: the event itself
+// and, for a status change to cancelled, a copy typed
+// contracts.EventOrderCancelled
+func outgoing(event contracts.Event, headers map[string]string) []outgoingEvent {
+	out := []outgoingEvent{{event: event, headers: headers}}
+	if event.Type != contracts.EventOrderStatusChanged || event.Order.Status != contracts.StatusCancelled {
+		return out
+	}
+
+	cancelled := event
+	cancelled.Type = contracts.EventOrderCancelled
+	cancelledHeaders := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		cancelledHeaders[k] = v
+	}
+	cancelledHeaders[HeaderEventType] = contracts.EventOrderCancelled
+	return append(out, outgoingEvent{event: cancelled, headers: cancelledHeaders})
+}
@@ -0,0 +1,80 @@
+// Package events persists order lifecycle events and publishes them to the
+// message bus.
+package events
+
+import (
+	"context"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/requestid"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Header names attached to published events
+const (
+	HeaderEventType = "event-type"
+	HeaderReplay    = "x-replay"
+	// HeaderRequestID is the ID of the request the event was published
+	// for, or that replayed it
+	HeaderRequestID = "x-request-id"
+)
+
+// Headers returns the headers of an event of eventType published for the
+// request ctx serves
+func Headers(ctx context.Context, eventType string) map[string]string {
+	headers := map[string]string{HeaderEventType: eventType}
+	if id := requestid.FromContext(ctx); id != "" {
+		headers[HeaderRequestID] = id
+	}
+	return headers
+}
+
+// Publisher delivers events to the message bus
+type Publisher interface {
+	Publish(ctx context.Context, event contracts.Event, headers map[string]string) error
+}
+
+// LogPublisher logs events instead of delivering them; used when no broker is configured
+type LogPublisher struct{}
+
+// Publish logs the event
+func (LogPublisher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	log.Ctx(ctx).Info().
+		Str("event_id", event.EventID).
+		Str("event_type", event.Type).
+		Str("order_id", event.OrderID).
+		Str("replay", headers[HeaderReplay]).
+		Msg("Event published")
+	return nil
+}
+
+// MultiPublisher publishes every event to each publisher in turn. All of
+// them are tried; the first error is returned.
+type MultiPublisher []Publisher
+
+// Publish delivers the event to every publisher
+func (m MultiPublisher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	var first error
+	for _, p := range m {
+		if err := p.Publish(ctx, event, headers); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// NewEvent builds an event for the given order that occurred at the given time
+func NewEvent(eventType string, order contracts.Order, at time.Time) contracts.Event {
+	return contracts.Event{
+		EventID:       uuid.New().String(),
+		Type:          eventType,
+		SchemaVersion: contracts.SchemaVersion,
+		OrderID:       order.OrderID,
+		UserID:        order.UserID,
+		Order:         order,
+		OccurredAt:    at.UTC(),
+	}
+}
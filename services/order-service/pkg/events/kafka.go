@@ -0,0 +1,106 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"order-service/pkg/contracts"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher writes events to one Kafka topic per event type, named
+// TopicPrefix followed by the type (order.created, order.status_changed...).
+// Messages are keyed by order_id and partitioned by a hash of the key, so
+// every event of an order lands on the same partition in the order it was
+// published, retries included. It is the MessageBus for Kafka.
+type KafkaPublisher struct {
+	writer  *kafka.Writer
+	prefix  string
+	brokers []string
+}
+
+// KafkaConfig configures a KafkaPublisher
+type KafkaConfig struct {
+	Brokers     []string
+	TopicPrefix string
+	// MaxAttempts bounds how often a message is sent before Publish fails;
+	// failed attempts are retried against the partition's current leader
+	MaxAttempts int
+	// BackoffMin and BackoffMax bound the wait between attempts
+	BackoffMin time.Duration
+	BackoffMax time.Duration
+	// WriteTimeout bounds one attempt
+	WriteTimeout time.Duration
+}
+
+// NewKafkaPublisher returns a publisher writing to cfg.Brokers. Writes are
+// acknowledged by every in-sync replica before Publish returns.
+func NewKafkaPublisher(cfg KafkaConfig) *KafkaPublisher {
+	return &KafkaPublisher{
+		prefix:  cfg.TopicPrefix,
+		brokers: cfg.Brokers,
+		writer: &kafka.Writer{
+			Addr:            kafka.TCP(cfg.Brokers...),
+			Balancer:        &kafka.Hash{},
+			RequiredAcks:    kafka.RequireAll,
+			MaxAttempts:     cfg.MaxAttempts,
+			WriteBackoffMin: cfg.BackoffMin,
+			WriteBackoffMax: cfg.BackoffMax,
+			WriteTimeout:    cfg.WriteTimeout,
+			// Events are published one at a time from request handlers, so
+			// waiting to fill a batch would only add latency
+			BatchTimeout: 5 * time.Millisecond,
+		},
+	}
+}
+
+// Publish writes the event, and its order.cancelled counterpart for a
+// cancellation, waiting for the brokers to acknowledge them
+func (p *KafkaPublisher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	var messages []kafka.Message
+	for _, out := range outgoing(event, headers) {
+		msg, err := p.message(out.event, out.headers)
+		if err != nil {
+			return err
+		}
+		messages = append(messages, msg)
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// Ping connects to the brokers in turn until one accepts the connection.
+// The writer finds partition leaders on its own from any of them.
+func (p *KafkaPublisher) Ping(ctx context.Context) error {
+	var err error
+	for _, broker := range p.brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.DialContext(ctx, "tcp", broker); err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
+
+// Close flushes pending writes and closes the broker connections
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+func (p *KafkaPublisher) message(event contracts.Event, headers map[string]string) (kafka.Message, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+	msg := kafka.Message{
+		Topic: p.prefix + event.Type,
+		Key:   []byte(event.OrderID),
+		Value: value,
+		Time:  event.OccurredAt,
+	}
+	for k, v := range headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+	}
+	return msg, nil
+}
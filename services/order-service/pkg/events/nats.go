@@ -0,0 +1,105 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/contracts"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSConfig configures a NATSPublisher
+type NATSConfig struct {
+	URL string
+	// Stream is the JetStream stream events are stored in; it is created
+	// over SubjectPrefix + "order.>" if it does not exist
+	Stream        string
+	SubjectPrefix string
+	// DuplicateWindow is how long JetStream remembers event IDs, dropping
+	// a republished event instead of storing it twice
+	DuplicateWindow time.Duration
+}
+
+// NATSPublisher writes events to NATS JetStream, on subjects named
+// SubjectPrefix followed by the event type. It is the MessageBus for
+// deployments without Kafka. Each event's ID is sent as its Nats-Msg-Id,
+// so retries within the stream's duplicate window are stored once.
+type NATSPublisher struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	prefix string
+}
+
+// NewNATSPublisher connects to cfg.URL and makes sure the stream exists.
+// The connection reconnects on its own after it is established.
+func NewNATSPublisher(cfg NATSConfig) (*NATSPublisher, error) {
+	conn, err := nats.Connect(cfg.URL, nats.Name("order-service"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("open JetStream: %w", err)
+	}
+
+	_, err = js.StreamInfo(cfg.Stream)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:       cfg.Stream,
+			Subjects:   []string{cfg.SubjectPrefix + "order.>"},
+			Storage:    nats.FileStorage,
+			Duplicates: cfg.DuplicateWindow,
+		})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("set up stream %s: %w", cfg.Stream, err)
+	}
+	return &NATSPublisher{conn: conn, js: js, prefix: cfg.SubjectPrefix}, nil
+}
+
+// Publish stores the event, and its order.cancelled counterpart for a
+// cancellation, waiting for JetStream to acknowledge them
+func (p *NATSPublisher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	for _, out := range outgoing(event, headers) {
+		data, err := json.Marshal(out.event)
+		if err != nil {
+			return err
+		}
+		msg := nats.NewMsg(p.prefix + out.event.Type)
+		msg.Data = data
+		for k, v := range out.headers {
+			msg.Header.Set(k, v)
+		}
+		// The counterpart shares the event's ID but must not be dropped as
+		// its duplicate
+		msg.Header.Set(nats.MsgIdHdr, out.event.EventID+"/"+out.event.Type)
+		if _, err := p.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping makes a round trip to the server. While the connection is down and
+// reconnecting it fails without waiting.
+func (p *NATSPublisher) Ping(ctx context.Context) error {
+	if !p.conn.IsConnected() {
+		return errors.New("not connected to NATS")
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+// Close flushes pending writes and closes the connection
+func (p *NATSPublisher) Close() error {
+	err := p.conn.Drain()
+	if errors.Is(err, nats.ErrConnectionClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is an append-only log of every published event, kept so downstream
+// consumers can rebuild their state through Replay
+type Store struct {
+	collection *mongo.Collection
+
+	// Clock decides which events have settled in Since
+	Clock clock.Clock
+}
+
+// NewStore returns a store backed by the given collection
+func NewStore(collection *mongo.Collection) *Store {
+	return &Store{collection: collection, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the indexes used by replay queries
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "event_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "occurred_at", Value: 1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}, {Key: "occurred_at", Value: 1}}},
+	})
+	return err
+}
+
+// Append persists an event
+func (s *Store) Append(ctx context.Context, event contracts.Event) error {
+	_, err := s.collection.InsertOne(ctx, event)
+	return err
+}
+
+// Filter selects events to replay. Zero values are ignored.
+type Filter struct {
+	OrderID string
+	From    time.Time
+	To      time.Time
+	Types   []string
+}
+
+func (f Filter) query() bson.M {
+	query := bson.M{}
+	if f.OrderID != "" {
+		query["order_id"] = f.OrderID
+	}
+	if len(f.Types) > 0 {
+		query["type"] = bson.M{"$in": f.Types}
+	}
+	occurred := bson.M{}
+	if !f.From.IsZero() {
+		occurred["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		occurred["$lt"] = f.To
+	}
+	if len(occurred) > 0 {
+		query["occurred_at"] = occurred
+	}
+	return query
+}
+
+// Each calls fn for every matching event in occurrence order, stopping at the first error
+func (s *Store) Each(ctx context.Context, filter Filter, fn func(contracts.Event) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "occurred_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := s.collection.Find(ctx, filter.query(), opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event contracts.Event
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// Log is the subset of Store used to record and replay events
+type Log interface {
+	Append(ctx context.Context, event contracts.Event) error
+	Each(ctx context.Context, filter Filter, fn func(contracts.Event) error) error
+}
+
+// Replay republishes matching events with the replay header set and returns
+// how many were published
+func Replay(ctx context.Context, store Log, publisher Publisher, filter Filter) (int, error) {
+	replayed := 0
+	err := store.Each(ctx, filter, func(event contracts.Event) error {
+		headers := Headers(ctx, event.Type)
+		headers[HeaderReplay] = "true"
+		if err := publisher.Publish(ctx, event, headers); err != nil {
+			return err
+		}
+		replayed++
+		return nil
+	})
+	return replayed, err
+}
+
+// Record is a stored event together with its position in the log
+type Record struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	contracts.Event `bson:",inline"`
+}
+
+// Since returns up to limit events stored after the given position, oldest
+// first. Events newer than settle are left for the next call so that
+// slightly out-of-order inserts from other replicas are not skipped.
+func (s *Store) Since(ctx context.Context, after primitive.ObjectID, settle time.Duration, limit int64) ([]Record, error) {
+	query := bson.M{"occurred_at": bson.M{"$lte": s.Clock.Now().Add(-settle)}}
+	if !after.IsZero() {
+		query["_id"] = bson.M{"$gt": after}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,113 @@
+// Package featureflags decides which features are on for whom, so changes
+// such as server-side pricing or the placement saga can be rolled out to a
+// share of users or tenants, and rolled back, without a deploy. Flags are
+// decided by rules read from a file, the environment or Redis, or by an
+// OpenFeature flag service.
+package featureflags
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// Flags consulted by the service
+const (
+	// ServerPricing prices the items of new orders from the catalog even
+	// while legacy client prices are accepted
+	ServerPricing = "server_pricing"
+	// PlacementSaga places new orders through the placement saga, when
+	// one is configured
+	PlacementSaga = "placement_saga"
+)
+
+// Defaults are the values of flags no rule or flag service decides: the
+// behaviour from before the flag existed
+var Defaults = map[string]bool{
+	ServerPricing: false,
+	PlacementSaga: true,
+}
+
+var evaluations = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "feature_flag_evaluations_total",
+		Help: "Feature flag evaluations by flag and value",
+	},
+	[]string{"flag", "value"},
+)
+
+func init() {
+	prometheus.MustRegister(evaluations)
+}
+
+// Subject is who a flag is decided for
+type Subject struct {
+	UserID   string
+	TenantID string
+}
+
+type subjectKey struct{}
+
+// WithSubject returns ctx carrying the subject flags are decided for
+func WithSubject(ctx context.Context, s Subject) context.Context {
+	return context.WithValue(ctx, subjectKey{}, s)
+}
+
+// SubjectFrom returns the subject ctx carries
+func SubjectFrom(ctx context.Context) Subject {
+	s, _ := ctx.Value(subjectKey{}).(Subject)
+	return s
+}
+
+// Provider decides flags. ok is false when it has no value for flag, which
+// then takes its default.
+type Provider interface {
+	Evaluate(ctx context.Context, flag string, s Subject) (on, ok bool, err error)
+}
+
+// Flags decides flags through a provider. A nil *Flags decides every flag
+// by Defaults, so components may hold one unconditionally.
+type Flags struct {
+	provider Provider
+}
+
+// New returns flags decided by provider
+func New(provider Provider) *Flags {
+	return &Flags{provider: provider}
+}
+
+// Enabled reports whether flag is on for the subject ctx carries. A
+// provider failure is logged and decided by the default.
+func (f *Flags) Enabled(ctx context.Context, flag string) bool {
+	on := Defaults[flag]
+	if f != nil {
+		value, ok, err := f.provider.Evaluate(ctx, flag, SubjectFrom(ctx))
+		switch {
+		case err != nil:
+			log.Ctx(ctx).Warn().Err(err).Str("flag", flag).Bool("default", on).Msg("Failed to evaluate feature flag, using its default")
+		case ok:
+			on = value
+		}
+	}
+	if on {
+		evaluations.WithLabelValues(flag, "on").Inc()
+	} else {
+		evaluations.WithLabelValues(flag, "off").Inc()
+	}
+	return on
+}
+
+// Run reloads the provider's rules every interval until ctx is done, if
+// it has rules to reload
+func (f *Flags) Run(ctx context.Context, interval time.Duration) {
+	if f == nil {
+		return
+	}
+	if p, ok := f.provider.(interface {
+		Run(ctx context.Context, interval time.Duration)
+	}); ok {
+		p.Run(ctx, interval)
+	}
+}
@@ -0,0 +1,70 @@
+package featureflags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"order-service/pkg/httpclient"
+)
+
+// OFREPProvider decides flags through a flag service implementing the
+// OpenFeature Remote Evaluation Protocol, such as flagd, so flags can be
+// managed with any OpenFeature-compatible tool. The subject's user ID is
+// sent as the targeting key and its tenant as the tenant attribute.
+type OFREPProvider struct {
+	baseURL string
+	client  *httpclient.Client
+}
+
+// NewOFREPProvider returns a provider calling the flag service at baseURL.
+// Evaluations are on the request path, so they are bounded tightly and not
+// retried.
+func NewOFREPProvider(baseURL string) *OFREPProvider {
+	cfg := httpclient.DefaultConfig("feature-flags")
+	cfg.Timeout = 500 * time.Millisecond
+	cfg.MaxRetries = 0
+	return &OFREPProvider{baseURL: strings.TrimSuffix(baseURL, "/"), client: httpclient.New(cfg)}
+}
+
+type ofrepRequest struct {
+	Context map[string]string `json:"context"`
+}
+
+type ofrepResponse struct {
+	Value     interface{} `json:"value"`
+	ErrorCode string      `json:"errorCode"`
+}
+
+// Evaluate asks the flag service; flags it does not know are not decided
+func (p *OFREPProvider) Evaluate(ctx context.Context, flag string, s Subject) (bool, bool, error) {
+	evalCtx := map[string]string{"targetingKey": s.UserID}
+	if s.TenantID != "" {
+		evalCtx["tenant"] = s.TenantID
+	}
+	resp, err := p.client.PostJSON(ctx, p.baseURL+"/ofrep/v1/evaluate/flags/"+url.PathEscape(flag), ofrepRequest{Context: evalCtx})
+	if err != nil {
+		return false, false, err
+	}
+	defer resp.Body.Close()
+
+	var result ofrepResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return false, false, fmt.Errorf("decode flag %s: %w", flag, err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || result.ErrorCode == "FLAG_NOT_FOUND":
+		return false, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return false, false, fmt.Errorf("flag service answered %d for %s: %s", resp.StatusCode, flag, result.ErrorCode)
+	}
+	on, ok := result.Value.(bool)
+	if !ok {
+		return false, false, fmt.Errorf("flag %s is not a boolean", flag)
+	}
+	return on, true, nil
+}
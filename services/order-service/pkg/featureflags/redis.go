@@ -0,0 +1,149 @@
+package featureflags
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisSource loads rules, as YAML or JSON, from a string key in Redis,
+// so every instance follows the same rules as soon as they are written.
+// It speaks just enough of the Redis protocol to read the key; a missing
+// key loads no rules.
+type RedisSource struct {
+	addr     string
+	tls      bool
+	username string
+	password string
+	db       int
+	key      string
+}
+
+// redisTimeout bounds loading the rules
+const redisTimeout = 5 * time.Second
+
+// NewRedisSource returns a source reading key from the Redis server of
+// rawURL, such as redis://:password@redis:6379/0 or rediss:// for TLS
+func NewRedisSource(rawURL, key string) (*RedisSource, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, errors.New("invalid Redis URL: scheme must be redis or rediss")
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("invalid Redis URL: missing host")
+	}
+	s := &RedisSource{addr: u.Host, tls: u.Scheme == "rediss", key: key}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if s.db, err = strconv.Atoi(db); err != nil || s.db < 0 {
+			return nil, fmt.Errorf("invalid Redis URL: database %q is not a number", db)
+		}
+	}
+	return s, nil
+}
+
+// Load reads the key over a new connection
+func (s *RedisSource) Load(ctx context.Context) (Rules, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connect to Redis: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, fmt.Errorf("connect to Redis: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	r := bufio.NewReader(conn)
+	if s.password != "" {
+		args := []string{"AUTH", s.password}
+		if s.username != "" {
+			args = []string{"AUTH", s.username, s.password}
+		}
+		if _, err := redisCall(conn, r, args...); err != nil {
+			return nil, fmt.Errorf("authenticate to Redis: %w", err)
+		}
+	}
+	if s.db != 0 {
+		if _, err := redisCall(conn, r, "SELECT", strconv.Itoa(s.db)); err != nil {
+			return nil, fmt.Errorf("select Redis database: %w", err)
+		}
+	}
+	value, err := redisCall(conn, r, "GET", s.key)
+	if err != nil {
+		return nil, fmt.Errorf("read feature flags from Redis: %w", err)
+	}
+	if value == nil {
+		return Rules{}, nil
+	}
+	return ParseRules(value)
+}
+
+// redisCall sends a command and reads its reply: the bytes of a bulk or
+// simple string, nil for a missing value
+func redisCall(w io.Writer, r *bufio.Reader, args ...string) ([]byte, error) {
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(w, cmd.String()); err != nil {
+		return nil, err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
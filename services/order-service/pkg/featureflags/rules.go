@@ -0,0 +1,186 @@
+package featureflags
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule decides one flag. It is on for everyone when Enabled, and otherwise
+// for the users and tenants listed and the Percentage of the others.
+type Rule struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Percentage, 0 to 100, of users the flag is on for. Each user keeps
+	// their decision as the percentage grows.
+	Percentage int      `json:"percentage" yaml:"percentage"`
+	Users      []string `json:"users,omitempty" yaml:"users"`
+	Tenants    []string `json:"tenants,omitempty" yaml:"tenants"`
+}
+
+// on decides the rule of flag for s
+func (r Rule) on(flag string, s Subject) bool {
+	if r.Enabled {
+		return true
+	}
+	for _, id := range r.Users {
+		if s.UserID != "" && id == s.UserID {
+			return true
+		}
+	}
+	for _, id := range r.Tenants {
+		if s.TenantID != "" && id == s.TenantID {
+			return true
+		}
+	}
+	if r.Percentage <= 0 || s.UserID == "" {
+		return false
+	}
+	return bucket(flag, s.UserID) < r.Percentage
+}
+
+// bucket places id in one of 100 buckets, differently for every flag so
+// the same users are not always the first to get a feature
+func bucket(flag, id string) int {
+	sum := sha256.Sum256([]byte(flag + ":" + id))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// Rules are the rules of each flag, by name
+type Rules map[string]Rule
+
+// validate checks the percentages
+func (r Rules) validate() error {
+	for name, rule := range r {
+		if rule.Percentage < 0 || rule.Percentage > 100 {
+			return fmt.Errorf("flag %s: percentage must be between 0 and 100", name)
+		}
+	}
+	return nil
+}
+
+// ParseRules reads rules from YAML or JSON, mapping flag names to rules
+func ParseRules(data []byte) (Rules, error) {
+	rules := Rules{}
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse feature flags: %w", err)
+	}
+	return rules, rules.validate()
+}
+
+// ParseEnvRules reads rules from a comma-separated list of name=value,
+// where value is on, off or a percentage such as 25%
+func ParseEnvRules(value string) (Rules, error) {
+	rules := Rules{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, v, ok := strings.Cut(pair, "=")
+		name, v = strings.TrimSpace(name), strings.ToLower(strings.TrimSpace(v))
+		if !ok || name == "" {
+			return nil, fmt.Errorf("feature flag %q: expected name=value", pair)
+		}
+		switch {
+		case v == "on" || v == "true":
+			rules[name] = Rule{Enabled: true}
+		case v == "off" || v == "false":
+			rules[name] = Rule{}
+		case strings.HasSuffix(v, "%"):
+			percentage, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+			if err != nil {
+				return nil, fmt.Errorf("feature flag %s: invalid percentage %q", name, v)
+			}
+			rules[name] = Rule{Percentage: percentage}
+		default:
+			return nil, fmt.Errorf("feature flag %s: value must be on, off or a percentage", name)
+		}
+	}
+	return rules, rules.validate()
+}
+
+// Source loads rules
+type Source interface {
+	Load(ctx context.Context) (Rules, error)
+}
+
+// StaticSource always loads the same rules, such as those of the
+// environment
+type StaticSource Rules
+
+// Load returns the rules
+func (s StaticSource) Load(context.Context) (Rules, error) {
+	return Rules(s), nil
+}
+
+// FileSource loads rules from a YAML or JSON file
+type FileSource string
+
+// Load reads the file
+func (s FileSource) Load(context.Context) (Rules, error) {
+	data, err := os.ReadFile(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("read feature flags: %w", err)
+	}
+	return ParseRules(data)
+}
+
+// RuleProvider decides flags by the rules of a source, loaded again by Run
+type RuleProvider struct {
+	source Source
+
+	mu    sync.RWMutex
+	rules Rules
+}
+
+// NewRuleProvider loads the rules of source. It fails if they cannot be
+// loaded, so a broken source is noticed at startup.
+func NewRuleProvider(ctx context.Context, source Source) (*RuleProvider, error) {
+	rules, err := source.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RuleProvider{source: source, rules: rules}, nil
+}
+
+// Evaluate decides flag by its rule; flags without one are not decided
+func (p *RuleProvider) Evaluate(ctx context.Context, flag string, s Subject) (bool, bool, error) {
+	p.mu.RLock()
+	rule, ok := p.rules[flag]
+	p.mu.RUnlock()
+	if !ok {
+		return false, false, nil
+	}
+	return rule.on(flag, s), true, nil
+}
+
+// Run loads the rules again every interval until ctx is done. Rules that
+// fail to load are logged and the previous ones kept.
+func (p *RuleProvider) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rules, err := p.source.Load(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to reload feature flags, keeping the current ones")
+				continue
+			}
+			p.mu.Lock()
+			p.rules = rules
+			p.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,310 @@
+// Package fieldcrypt encrypts single field values, such as the personal
+// data on orders, with AES-256-GCM, so a database dump alone does not
+// expose them. Values are encrypted under data keys kept in MongoDB, each
+// itself encrypted by a key-encryption key held in a KMS, or given in the
+// configuration for development: envelope encryption. A ciphertext names
+// the generation of its data key, so keys rotate while older values stay
+// readable.
+package fieldcrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Prefix starts every encrypted value
+const Prefix = "enc:v1:"
+
+// KeysCollection holds the data keys, encrypted
+const KeysCollection = "encryption_keys"
+
+// refreshBackoff is how long a keyring asked for a generation it does not
+// know waits before reloading the keys again
+const refreshBackoff = 5 * time.Second
+
+// ErrUnknownKey is returned for values encrypted under a data key the
+// keyring cannot find
+var ErrUnknownKey = errors.New("value encrypted under an unknown data key")
+
+// IsEncrypted reports whether value is a ciphertext of Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// KeyWrapper encrypts and decrypts data keys under a key-encryption key; it
+// is implemented by *LocalKEK and *AWSKMS
+type KeyWrapper interface {
+	// Name identifies the key-encryption key, recorded with each data key
+	Name() string
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// dataKey is a data key as stored
+type dataKey struct {
+	Generation int       `bson:"_id"`
+	Wrapped    []byte    `bson:"wrapped"`
+	KEK        string    `bson:"kek"`
+	CreatedAt  time.Time `bson:"created_at"`
+}
+
+// Keyring encrypts values under the newest data key and decrypts them
+// under whichever key they name
+type Keyring struct {
+	keys    *mongo.Collection
+	wrapper KeyWrapper
+
+	// Rotation is how old the newest data key gets before Refresh creates
+	// the next one; 0 never rotates
+	Rotation time.Duration
+	Clock    clock.Clock
+
+	mu        sync.RWMutex
+	aeads     map[int]cipher.AEAD
+	active    int
+	activeAt  time.Time
+	refreshed time.Time
+}
+
+// NewKeyring returns a keyring of the data keys in keys, encrypted by
+// wrapper. Refresh loads them.
+func NewKeyring(keys *mongo.Collection, wrapper KeyWrapper) *Keyring {
+	return &Keyring{keys: keys, wrapper: wrapper, Clock: clock.System{}, aeads: map[int]cipher.AEAD{}}
+}
+
+// Refresh loads the data keys stored since the last refresh, and creates
+// the first one, or the next one once Rotation has passed. Replicas racing
+// to create a generation agree on the one stored first.
+func (k *Keyring) Refresh(ctx context.Context) error {
+	if err := k.load(ctx); err != nil {
+		return err
+	}
+	k.mu.RLock()
+	due := k.active == 0 || (k.Rotation > 0 && k.Clock.Now().Sub(k.activeAt) >= k.Rotation)
+	next := k.active + 1
+	k.mu.RUnlock()
+	if !due {
+		return nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	wrapped, err := k.wrapper.Wrap(ctx, key)
+	if err != nil {
+		return fmt.Errorf("wrap data key: %w", err)
+	}
+	_, err = k.keys.InsertOne(ctx, dataKey{Generation: next, Wrapped: wrapped, KEK: k.wrapper.Name(), CreatedAt: k.Clock.Now()})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("store data key: %w", err)
+	}
+	if err == nil {
+		log.Info().Int("generation", next).Str("kek", k.wrapper.Name()).Msg("Created field encryption data key")
+	}
+	// Either this key or another replica's is now stored
+	return k.load(ctx)
+}
+
+// load adds the stored data keys the keyring lacks
+func (k *Keyring) load(ctx context.Context) error {
+	k.mu.RLock()
+	known := make(bson.A, 0, len(k.aeads))
+	for generation := range k.aeads {
+		known = append(known, generation)
+	}
+	k.mu.RUnlock()
+
+	cursor, err := k.keys.Find(ctx, bson.M{"_id": bson.M{"$nin": known}})
+	if err != nil {
+		return err
+	}
+	var stored []dataKey
+	if err := cursor.All(ctx, &stored); err != nil {
+		return err
+	}
+
+	aeads := map[int]cipher.AEAD{}
+	for _, key := range stored {
+		plain, err := k.wrapper.Unwrap(ctx, key.Wrapped)
+		if err != nil {
+			return fmt.Errorf("unwrap data key %d, wrapped by %s: %w", key.Generation, key.KEK, err)
+		}
+		aead, err := newAEAD(plain)
+		if err != nil {
+			return fmt.Errorf("data key %d: %w", key.Generation, err)
+		}
+		aeads[key.Generation] = aead
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, key := range stored {
+		k.aeads[key.Generation] = aeads[key.Generation]
+		if key.Generation > k.active {
+			k.active, k.activeAt = key.Generation, key.CreatedAt
+		}
+	}
+	k.refreshed = k.Clock.Now()
+	return nil
+}
+
+// Run refreshes the keyring every interval until ctx is done, picking up
+// the keys other replicas rotated to and rotating when due
+func (k *Keyring) Run(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		refreshCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := k.Refresh(refreshCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to refresh field encryption keys")
+		}
+		cancel()
+	}
+}
+
+// Encrypt returns plaintext encrypted under the newest data key. Empty
+// values stay empty.
+func (k *Keyring) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	k.mu.RLock()
+	generation, aead := k.active, k.aeads[k.active]
+	k.mu.RUnlock()
+	if aead == nil {
+		return "", errors.New("field encryption keys are not loaded")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	id := strconv.Itoa(generation)
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return Prefix + id + ":" + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt returns the plaintext of a value of Encrypt, and any other value
+// as it is, so values stored before encryption stay readable
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, Prefix), ":")
+	generation, err := strconv.Atoi(id)
+	if !ok || err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	aead, err := k.aead(generation)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("decrypt value under data key %d: %w", generation, err)
+	}
+	return string(plain), nil
+}
+
+// aead returns the cipher of generation, loading keys stored by other
+// replicas since the last refresh when it is unknown
+func (k *Keyring) aead(generation int) (cipher.AEAD, error) {
+	k.mu.RLock()
+	aead, refreshed := k.aeads[generation], k.refreshed
+	k.mu.RUnlock()
+	if aead != nil {
+		return aead, nil
+	}
+	if k.Clock.Now().Sub(refreshed) < refreshBackoff {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, generation)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := k.load(ctx); err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	aead = k.aeads[generation]
+	k.mu.RUnlock()
+	if aead == nil {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, generation)
+	}
+	return aead, nil
+}
+
+// newAEAD returns AES-GCM under key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LocalKEK wraps data keys with a key-encryption key given in the
+// configuration, for development and deployments without a KMS
+type LocalKEK struct {
+	aead cipher.AEAD
+}
+
+// NewLocalKEK returns the key-encryption key of a 32-byte key
+func NewLocalKEK(key []byte) (*LocalKEK, error) {
+	if len(key) != 32 {
+		return nil, errors.New("the key-encryption key must be 32 bytes")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalKEK{aead: aead}, nil
+}
+
+// Name is "local"
+func (l *LocalKEK) Name() string {
+	return "local"
+}
+
+// Wrap encrypts key
+func (l *LocalKEK) Wrap(_ context.Context, key []byte) ([]byte, error) {
+	nonce := make([]byte, l.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return l.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// Unwrap decrypts a key of Wrap
+func (l *LocalKEK) Unwrap(_ context.Context, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < l.aead.NonceSize() {
+		return nil, errors.New("malformed wrapped key")
+	}
+	return l.aead.Open(nil, wrapped[:l.aead.NonceSize()], wrapped[l.aead.NonceSize():], nil)
+}
@@ -0,0 +1,200 @@
+// Package health tells load balancers whether an instance should receive
+// traffic. Liveness needs nothing beyond a running process; readiness runs
+// a check per dependency requests rely on, and reports unready while the
+// instance drains before shutting down. Operators get the detail behind it:
+// every dependency's latency and last error.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Readiness statuses
+const (
+	StatusReady    = "ready"
+	StatusUnready  = "unready"
+	StatusDraining = "draining"
+)
+
+// Check reports whether a dependency is available; it must return once ctx
+// is done
+type Check func(ctx context.Context) error
+
+// Report is the outcome of the readiness checks
+type Report struct {
+	Status string `json:"status"`
+	// Checks holds "ok" or "failed" per check; failures are logged rather
+	// than reported, as their errors may name internal hosts
+	Checks map[string]string `json:"checks"`
+}
+
+// Details is the state of every dependency, for operators
+type Details struct {
+	// Status is what the readiness endpoint reports
+	Status       string       `json:"status"`
+	Dependencies []Dependency `json:"dependencies"`
+}
+
+// Dependency is the outcome of one dependency's check
+type Dependency struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Required dependencies make the instance unready when they fail
+	Required  bool  `json:"required"`
+	LatencyMS int64 `json:"latency_ms"`
+	// Error is why the check failed just now
+	Error string `json:"error,omitempty"`
+	// LastError is the most recent failure, which may be older than this
+	// check
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Readiness holds the checks an instance must pass to receive traffic.
+// The zero value has no checks and is ready until Drain is called.
+type Readiness struct {
+	// Clock times checks and their failures; nil is the system clock
+	Clock clock.Clock
+
+	mu           sync.Mutex
+	dependencies []*dependency
+	draining     bool
+}
+
+// dependency is a registered check and its last failure
+type dependency struct {
+	name        string
+	check       Check
+	required    bool
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Add registers a check under name that must pass for the instance to be
+// ready
+func (r *Readiness) Add(name string, check Check) {
+	r.add(name, check, true)
+}
+
+// AddOptional registers a check that is only reported in Details: a
+// dependency some requests need, whose outage should not take every
+// instance out of the load balancer at once
+func (r *Readiness) AddOptional(name string, check Check) {
+	r.add(name, check, false)
+}
+
+func (r *Readiness) add(name string, check Check, required bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dependencies = append(r.dependencies, &dependency{name: name, check: check, required: required})
+}
+
+// Drain makes the instance report StatusDraining from now on, so load
+// balancers stop routing to it while in-flight requests finish
+func (r *Readiness) Drain() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.draining = true
+}
+
+// Check runs the required checks concurrently and reports StatusReady only
+// if all of them pass. A draining instance is not checked at all.
+func (r *Readiness) Check(ctx context.Context) Report {
+	r.mu.Lock()
+	draining := r.draining
+	r.mu.Unlock()
+
+	report := Report{Status: StatusReady, Checks: map[string]string{}}
+	if draining {
+		report.Status = StatusDraining
+		return report
+	}
+	details := r.run(ctx, false)
+	for _, dep := range details.Dependencies {
+		report.Checks[dep.Name] = dep.Status
+	}
+	report.Status = details.Status
+	return report
+}
+
+// Details runs every check, optional ones included, even while draining
+func (r *Readiness) Details(ctx context.Context) Details {
+	return r.run(ctx, true)
+}
+
+// run checks the dependencies concurrently, records their failures and
+// derives the status from the required ones
+func (r *Readiness) run(ctx context.Context, optional bool) Details {
+	r.mu.Lock()
+	var deps []*dependency
+	for _, dep := range r.dependencies {
+		if dep.required || optional {
+			deps = append(deps, dep)
+		}
+	}
+	draining := r.draining
+	r.mu.Unlock()
+
+	results := make([]Dependency, len(deps))
+	var wg sync.WaitGroup
+	for i, dep := range deps {
+		wg.Add(1)
+		go func(i int, dep *dependency) {
+			defer wg.Done()
+			results[i] = r.probe(ctx, dep)
+		}(i, dep)
+	}
+	wg.Wait()
+
+	details := Details{Status: StatusReady, Dependencies: results}
+	for _, result := range results {
+		if result.Required && result.Status != "ok" {
+			details.Status = StatusUnready
+		}
+	}
+	if draining {
+		details.Status = StatusDraining
+	}
+	return details
+}
+
+// probe runs one check, timing it and recording a failure as its last
+// error
+func (r *Readiness) probe(ctx context.Context, dep *dependency) Dependency {
+	start := r.now()
+	err := dep.check(ctx)
+	end := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := Dependency{
+		Name:      dep.name,
+		Status:    "ok",
+		Required:  dep.required,
+		LatencyMS: end.Sub(start).Milliseconds(),
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("check", dep.name).Msg("Readiness check failed")
+		result.Status = "failed"
+		result.Error = err.Error()
+		dep.lastError, dep.lastErrorAt = err.Error(), end
+	}
+	if dep.lastError != "" {
+		at := dep.lastErrorAt
+		result.LastError, result.LastErrorAt = dep.lastError, &at
+	}
+	return result
+}
+
+func (r *Readiness) now() time.Time {
+	if r.Clock == nil {
+		return clock.System{}.Now()
+	}
+	return r.Clock.Now()
+}
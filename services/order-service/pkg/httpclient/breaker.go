@@ -0,0 +1,13 @@
+package httpclient
+
+import "order-service/pkg/breaker"
+
+// ErrCircuitOpen is returned without calling the server while the breaker is open
+var ErrCircuitOpen = breaker.ErrOpen
+
+// Breaker states, also exported as the http_client_circuit_state gauge value
+const (
+	StateClosed   = breaker.StateClosed
+	StateHalfOpen = breaker.StateHalfOpen
+	StateOpen     = breaker.StateOpen
+)
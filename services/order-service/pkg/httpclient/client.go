@@ -0,0 +1,276 @@
+// Package httpclient provides the preconfigured HTTP client used for all
+// outbound calls: timeouts, retries with backoff, circuit breaking, trace
+// and request ID propagation, metrics and auth header injection.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"order-service/pkg/breaker"
+	"order-service/pkg/clock"
+	"order-service/pkg/requestid"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AuthFunc returns the Authorization header value for an outbound request
+type AuthFunc func(ctx context.Context) (string, error)
+
+// BearerToken returns an AuthFunc that always sends the given token
+func BearerToken(token string) AuthFunc {
+	return func(context.Context) (string, error) {
+		return "Bearer " + token, nil
+	}
+}
+
+// Config configures a Client. Zero values fall back to DefaultConfig.
+type Config struct {
+	// Name labels metrics and logs, e.g. "product-service"
+	Name string
+	// Timeout bounds a single attempt
+	Timeout time.Duration
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// BaseBackoff and MaxBackoff bound the exponential backoff (with jitter)
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BreakerThreshold consecutive failures open the circuit for BreakerCooldown.
+	// A negative threshold disables the breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// Auth, if set, injects the Authorization header
+	Auth AuthFunc
+	// Transport overrides the underlying round tripper, which is otherwise
+	// shared by every client and configured by UseTLS
+	Transport http.RoundTripper
+	// Clock drives the breaker cooldown; defaults to the system clock
+	Clock clock.Clock
+}
+
+// DefaultConfig returns the settings used for unset Config fields
+func DefaultConfig(name string) Config {
+	return Config{
+		Name:             name,
+		Timeout:          5 * time.Second,
+		MaxRetries:       2,
+		BaseBackoff:      100 * time.Millisecond,
+		MaxBackoff:       2 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// Client is a resilient HTTP client. It is safe for concurrent use.
+type Client struct {
+	cfg     Config
+	http    *http.Client
+	breaker *breaker.Breaker
+}
+
+// New returns a client for cfg
+func New(cfg Config) *Client {
+	defaults := DefaultConfig(cfg.Name)
+	if cfg.Name == "" {
+		cfg.Name = "default"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	}
+	if cfg.BaseBackoff <= 0 {
+		cfg.BaseBackoff = defaults.BaseBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaults.MaxBackoff
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = defaults.BreakerThreshold
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = defaults.BreakerCooldown
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = clock.System{}
+	}
+
+	transport := cfg.Transport
+	if transport == nil {
+		transport = sharedTransport{}
+	}
+
+	clientCircuitState.WithLabelValues(cfg.Name).Set(breaker.StateClosed)
+
+	return &Client{
+		cfg:     cfg,
+		http:    &http.Client{Transport: transport, Timeout: cfg.Timeout},
+		breaker: breaker.New(cfg.Name, cfg.BreakerThreshold, cfg.BreakerCooldown, cfg.Clock),
+	}
+}
+
+// Do sends the request, retrying transient failures of idempotent requests.
+// Requests with an Idempotency-Key header are treated as idempotent.
+// The caller must close the response body.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	start := time.Now()
+	defer func() {
+		clientRequestDuration.WithLabelValues(c.cfg.Name, req.Method).Observe(time.Since(start).Seconds())
+	}()
+
+	if c.cfg.Auth != nil && req.Header.Get("Authorization") == "" {
+		value, err := c.cfg.Auth(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%s: auth: %w", c.cfg.Name, err)
+		}
+		req.Header.Set("Authorization", value)
+	}
+	req.Header.Set("traceparent", childTraceParent(ctx))
+	if id := requestid.FromContext(ctx); id != "" && req.Header.Get(requestid.Header) == "" {
+		req.Header.Set(requestid.Header, id)
+	}
+
+	retryable := isIdempotent(req)
+	var (
+		resp *http.Response
+		err  error
+	)
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		if !c.breaker.Allow() {
+			clientRequestsTotal.WithLabelValues(c.cfg.Name, req.Method, "circuit_open").Inc()
+			return nil, fmt.Errorf("%s: %w", c.cfg.Name, ErrCircuitOpen)
+		}
+
+		resp, err = c.http.Do(req)
+		failed := err != nil || isServerFailure(resp.StatusCode)
+		clientCircuitState.WithLabelValues(c.cfg.Name).Set(float64(c.breaker.Record(!failed)))
+
+		status := "error"
+		if err == nil {
+			status = strconv.Itoa(resp.StatusCode)
+		}
+		clientRequestsTotal.WithLabelValues(c.cfg.Name, req.Method, status).Inc()
+
+		if !shouldRetry(resp, err) || !retryable || attempt >= c.cfg.MaxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		log.Debug().
+			Str("client", c.cfg.Name).
+			Str("url", req.URL.Redacted()).
+			Int("attempt", attempt+1).
+			Dur("backoff", wait).
+			Msg("Retrying outbound request")
+		clientRetriesTotal.WithLabelValues(c.cfg.Name).Inc()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Get issues a GET request
+func (c *Client) Get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+// PostJSON marshals body and POSTs it as JSON
+func (c *Client) PostJSON(ctx context.Context, url string, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.Do(req)
+}
+
+func (c *Client) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait := time.Duration(seconds) * time.Second
+			if wait > c.cfg.MaxBackoff {
+				wait = c.cfg.MaxBackoff
+			}
+			return wait
+		}
+	}
+
+	wait := c.cfg.BaseBackoff << uint(attempt)
+	if wait > c.cfg.MaxBackoff || wait <= 0 {
+		wait = c.cfg.MaxBackoff
+	}
+	// Full jitter avoids synchronized retries across replicas
+	return time.Duration(rand.Int63n(int64(wait)) + 1)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func isServerFailure(status int) bool {
+	return status >= 500
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// rewind returns a copy of req with a fresh body for a retry
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry %s %s: request body is not replayable", req.Method, req.URL.Redacted())
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.Body = body
+	return clone, nil
+}
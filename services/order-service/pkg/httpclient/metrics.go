@@ -0,0 +1,44 @@
+package httpclient
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	clientRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Total number of outbound HTTP requests",
+		},
+		[]string{"client", "method", "status"},
+	)
+
+	clientRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name: "http_client_request_duration_seconds",
+			Help: "Duration of outbound HTTP requests in seconds, including retries",
+		},
+		[]string{"client", "method"},
+	)
+
+	clientRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_client_retries_total",
+			Help: "Total number of outbound HTTP request retries",
+		},
+		[]string{"client"},
+	)
+
+	clientCircuitState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "http_client_circuit_state",
+			Help: "Circuit breaker state per client (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"client"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(clientRequestsTotal)
+	prometheus.MustRegister(clientRequestDuration)
+	prometheus.MustRegister(clientRetriesTotal)
+	prometheus.MustRegister(clientCircuitState)
+}
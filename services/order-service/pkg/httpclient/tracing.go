@@ -0,0 +1,39 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type traceKey struct{}
+
+// ContextWithTraceParent stores an incoming W3C traceparent header so
+// outbound calls made with ctx join the same trace
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceparent)
+}
+
+// childTraceParent returns a traceparent for an outbound call: same trace ID
+// as the caller's, new span ID. A new trace is started if ctx has none.
+func childTraceParent(ctx context.Context) string {
+	traceID := ""
+	if parent, ok := ctx.Value(traceKey{}).(string); ok {
+		parts := strings.Split(parent, "-")
+		if len(parts) == 4 && len(parts[1]) == 32 {
+			traceID = parts[1]
+		}
+	}
+	if traceID == "" {
+		traceID = randomHex(16)
+	}
+	return fmt.Sprintf("00-%s-%s-01", traceID, randomHex(8))
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
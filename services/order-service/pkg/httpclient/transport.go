@@ -0,0 +1,34 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+)
+
+// transport is the round tripper of clients without their own, replaced
+// by UseTLS
+var transport atomic.Pointer[http.Transport]
+
+// UseTLS makes every client without its own transport connect with cfg,
+// e.g. to present a client certificate to the services it calls. Clients
+// created before, such as package-level ones, use it for new connections
+// too.
+func UseTLS(cfg *tls.Config) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	if previous := transport.Swap(t); previous != nil {
+		previous.CloseIdleConnections()
+	}
+}
+
+// sharedTransport sends requests with the transport set by UseTLS, or
+// http.DefaultTransport
+type sharedTransport struct{}
+
+func (sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t := transport.Load(); t != nil {
+		return t.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
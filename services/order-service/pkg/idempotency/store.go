@@ -0,0 +1,136 @@
+// Package idempotency remembers the result of requests carrying an
+// Idempotency-Key header, so a client retrying after a network failure gets
+// the order its first attempt created instead of a duplicate.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxKeyLength is the longest Idempotency-Key accepted
+const MaxKeyLength = 255
+
+var (
+	// ErrKeyReused is returned when a key is sent again with a different
+	// request than the one it was first used for
+	ErrKeyReused = errors.New("idempotency key reused with a different request")
+	// ErrInProgress is returned while the first request with a key is still
+	// being processed
+	ErrInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// Record is the stored state of one key. OrderID is set once the request
+// that reserved the key has created its order.
+type Record struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	UserID      string             `bson:"user_id"`
+	Key         string             `bson:"key"`
+	Fingerprint string             `bson:"fingerprint"`
+	OrderID     primitive.ObjectID `bson:"order_id,omitempty"`
+	ReservedAt  time.Time          `bson:"reserved_at"`
+	ExpiresAt   time.Time          `bson:"expires_at"`
+}
+
+// Store keeps idempotency keys in MongoDB. Keys are scoped to the user who
+// sent them and are deleted TTL after they were first used.
+type Store struct {
+	keys *mongo.Collection
+
+	// TTL is how long a key is remembered
+	TTL time.Duration
+	// Lease is how long a reservation whose request never finished blocks
+	// the key before a retry may take it over
+	Lease time.Duration
+	// Clock defaults to the system clock
+	Clock clock.Clock
+}
+
+// NewStore returns a store backed by keys remembering each key for ttl
+func NewStore(keys *mongo.Collection, ttl time.Duration) *Store {
+	return &Store{keys: keys, TTL: ttl, Lease: time.Minute, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the unique key index and the index expiring records
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.keys.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	return err
+}
+
+// Reserve claims userID's key for a request identified by fingerprint. It
+// returns the ID of the order already created with the key, or the zero ID
+// when the caller now holds the key and must Complete or Release it. A key
+// whose reservation outlived Lease without an order is taken over.
+func (s *Store) Reserve(ctx context.Context, userID, key, fingerprint string) (primitive.ObjectID, error) {
+	now := s.Clock.Now()
+	record := Record{
+		UserID:      userID,
+		Key:         key,
+		Fingerprint: fingerprint,
+		ReservedAt:  now,
+		ExpiresAt:   now.Add(s.TTL),
+	}
+	_, err := s.keys.InsertOne(ctx, record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return primitive.NilObjectID, err
+	}
+
+	var existing Record
+	err = s.keys.FindOne(ctx, bson.M{"user_id": userID, "key": key}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		// Expired and removed since the insert failed; the retry will succeed
+		return primitive.NilObjectID, ErrInProgress
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	switch {
+	case !existing.ExpiresAt.After(now):
+		// Expired but not yet removed by the TTL monitor
+	case existing.Fingerprint != fingerprint:
+		return primitive.NilObjectID, ErrKeyReused
+	case !existing.OrderID.IsZero():
+		return existing.OrderID, nil
+	case now.Sub(existing.ReservedAt) < s.Lease:
+		return primitive.NilObjectID, ErrInProgress
+	}
+
+	// Take the key over, unless a concurrent retry already did
+	record.ID = existing.ID
+	filter := bson.M{"_id": existing.ID, "reserved_at": existing.ReservedAt}
+	result, err := s.keys.ReplaceOne(ctx, filter, record)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if result.ModifiedCount == 0 {
+		return primitive.NilObjectID, ErrInProgress
+	}
+	return primitive.NilObjectID, nil
+}
+
+// Complete records the order created by the request holding userID's key
+func (s *Store) Complete(ctx context.Context, userID, key string, orderID primitive.ObjectID) error {
+	_, err := s.keys.UpdateOne(ctx,
+		bson.M{"user_id": userID, "key": key},
+		bson.M{"$set": bson.M{"order_id": orderID}},
+	)
+	return err
+}
+
+// Release frees userID's key after its request failed, so it can be retried
+func (s *Store) Release(ctx context.Context, userID, key string) error {
+	_, err := s.keys.DeleteOne(ctx, bson.M{"user_id": userID, "key": key, "order_id": bson.M{"$exists": false}})
+	return err
+}
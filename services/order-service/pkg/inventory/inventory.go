@@ -0,0 +1,134 @@
+// Package inventory holds stock in product-service for the line items of
+// orders, so an order is only placed when its items are available and the
+// stock is returned when it is cancelled.
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/httpclient"
+)
+
+// HeaderInternalToken authenticates service-to-service calls to
+// product-service
+const HeaderInternalToken = "X-Internal-Token"
+
+// Shortage is a product an order wants more of than is in stock
+type Shortage struct {
+	ProductID string `json:"product_id"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
+
+// InsufficientStockError is returned when a reservation fails because of
+// shortages; nothing is reserved
+type InsufficientStockError struct {
+	Items []Shortage
+}
+
+func (e *InsufficientStockError) Error() string {
+	ids := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		ids = append(ids, item.ProductID)
+	}
+	return "insufficient stock for " + strings.Join(ids, ", ")
+}
+
+// Client reserves stock through product-service's internal API
+type Client struct {
+	baseURL string
+	token   string
+	client  *httpclient.Client
+}
+
+// NewClient returns a client for product-service at baseURL,
+// authenticating with the shared internal token
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		token:   token,
+		client:  httpclient.New(httpclient.DefaultConfig("product-service-inventory")),
+	}
+}
+
+type reservationItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Reserve holds stock for every item of the order. It states the order's
+// full needs: stock already held for orderID is adjusted, so retries and
+// item edits are safe. It fails with *InsufficientStockError, reserving
+// nothing, when any item is short.
+func (c *Client) Reserve(ctx context.Context, orderID string, items []contracts.OrderItem) error {
+	body := struct {
+		Items []reservationItem `json:"items"`
+	}{Items: make([]reservationItem, 0, len(items))}
+	for _, item := range items {
+		body.Items = append(body.Items, reservationItem{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.endpoint(orderID), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderInternalToken, c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusConflict:
+		var conflict struct {
+			Items []Shortage `json:"items"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
+			return fmt.Errorf("decode stock shortages: %w", err)
+		}
+		return &InsufficientStockError{Items: conflict.Items}
+	default:
+		return fmt.Errorf("product-service returned status %d", resp.StatusCode)
+	}
+}
+
+// Release returns the stock held for orderID; releasing an order with no
+// reservation is a no-op
+func (c *Client) Release(ctx context.Context, orderID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.endpoint(orderID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderInternalToken, c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("product-service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Client) endpoint(orderID string) string {
+	return c.baseURL + "/internal/reservations/" + url.PathEscape(orderID)
+}
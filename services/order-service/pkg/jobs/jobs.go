@@ -0,0 +1,335 @@
+// Package jobs is a persistent queue of background jobs kept in MongoDB,
+// run by a pool of workers on every replica. Jobs are typed by name, each
+// with its own handler and a BSON payload, and survive restarts: a job is
+// leased to one worker while it runs, and taken over by another when its
+// worker dies. Failed attempts are retried with exponential backoff until
+// the job runs out of attempts, and finished jobs are kept for a while for
+// their status to be read back.
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds the jobs unless configured otherwise
+const Collection = "jobs"
+
+// Job statuses. Pending jobs wait for RunAt, running ones are leased to a
+// worker; done and failed jobs are finished and kept for Retention.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// ErrNotFound is returned for jobs the queue does not hold
+var ErrNotFound = errors.New("job not found")
+
+// Job is one unit of background work
+type Job struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	Type    string             `bson:"type"`
+	Payload bson.Raw           `bson:"payload"`
+	Status  string             `bson:"status"`
+	// Attempts counts the attempts started, the current one included
+	Attempts    int       `bson:"attempts"`
+	MaxAttempts int       `bson:"max_attempts"`
+	RunAt       time.Time `bson:"run_at"`
+	// LockedBy is the lease of the worker running the job until
+	// LockedUntil
+	LockedBy    string     `bson:"locked_by,omitempty"`
+	LockedUntil time.Time  `bson:"locked_until,omitempty"`
+	LastError   string     `bson:"last_error,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at"`
+	FinishedAt  *time.Time `bson:"finished_at,omitempty"`
+}
+
+// Decode unmarshals the job's payload into v
+func (j Job) Decode(v interface{}) error {
+	return bson.Unmarshal(j.Payload, v)
+}
+
+// Handler runs one attempt of a job. A returned error fails the attempt,
+// which is retried unless the error is Permanent or the job is out of
+// attempts.
+type Handler func(ctx context.Context, job Job) error
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure retrying cannot fix, such as an invalid
+// payload
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Queue stores jobs and runs them with the handlers registered for their
+// types
+type Queue struct {
+	jobs     *mongo.Collection
+	handlers map[string]Handler
+	wake     chan struct{}
+
+	// MaxAttempts is how many times a job is attempted unless it was
+	// enqueued with its own limit
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry; it doubles with
+	// every attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Timeout bounds one attempt; a job whose worker died is taken over
+	// once it has passed
+	Timeout time.Duration
+	// Retention is how long finished jobs are kept
+	Retention time.Duration
+	Clock     clock.Clock
+}
+
+// NewQueue returns a queue backed by jobs
+func NewQueue(jobs *mongo.Collection) *Queue {
+	return &Queue{
+		jobs:        jobs,
+		handlers:    map[string]Handler{},
+		wake:        make(chan struct{}, 1),
+		MaxAttempts: 5,
+		BaseBackoff: 10 * time.Second,
+		MaxBackoff:  time.Hour,
+		Timeout:     5 * time.Minute,
+		Retention:   7 * 24 * time.Hour,
+		Clock:       clock.System{},
+	}
+}
+
+// EnsureIndexes creates the index workers claim jobs by and the one
+// expiring finished jobs
+func (q *Queue) EnsureIndexes(ctx context.Context) error {
+	_, err := q.jobs.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "run_at", Value: 1}}},
+		{Keys: bson.D{{Key: "finished_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(q.Retention.Seconds()))},
+	})
+	return err
+}
+
+// Handle registers h for jobs of jobType. Handlers are registered before
+// Run; workers only claim jobs of registered types, so replicas running
+// other versions leave unknown types to the ones that know them.
+func (q *Queue) Handle(jobType string, h Handler) {
+	q.handlers[jobType] = h
+}
+
+// Enqueue stores a job of jobType with payload, a struct or map marshalled
+// to BSON, to run as soon as a worker is free
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload interface{}) (Job, error) {
+	return q.EnqueueAt(ctx, jobType, payload, time.Time{})
+}
+
+// EnqueueAt stores a job to run at or after runAt, or at once when zero
+func (q *Queue) EnqueueAt(ctx context.Context, jobType string, payload interface{}, runAt time.Time) (Job, error) {
+	raw, err := bson.Marshal(payload)
+	if err != nil {
+		return Job{}, fmt.Errorf("encode %s job payload: %w", jobType, err)
+	}
+	now := q.Clock.Now()
+	if runAt.IsZero() {
+		runAt = now
+	}
+	job := Job{
+		ID:          primitive.NewObjectID(),
+		Type:        jobType,
+		Payload:     raw,
+		Status:      StatusPending,
+		MaxAttempts: q.MaxAttempts,
+		RunAt:       runAt,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if _, err := q.jobs.InsertOne(ctx, job); err != nil {
+		return Job{}, err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Job returns the job id
+func (q *Queue) Job(ctx context.Context, id primitive.ObjectID) (Job, error) {
+	var job Job
+	err := q.jobs.FindOne(ctx, bson.M{"_id": id}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+// Run runs jobs with workers concurrent workers until ctx is done, each
+// looking for due jobs every poll while idle. Jobs enqueued by this
+// replica wake an idle worker at once.
+func (q *Queue) Run(ctx context.Context, workers int, poll time.Duration) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, poll)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs due jobs one at a time until ctx is done
+func (q *Queue) work(ctx context.Context, poll time.Duration) {
+	for ctx.Err() == nil {
+		job, err := q.claim(ctx)
+		if err == nil {
+			q.process(ctx, job)
+			continue
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to claim job")
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(poll):
+		}
+	}
+}
+
+// claim leases the oldest due job of a registered type: a pending one, or
+// a running one whose worker stopped renewing it
+func (q *Queue) claim(ctx context.Context) (Job, error) {
+	types := make(bson.A, 0, len(q.handlers))
+	for jobType := range q.handlers {
+		types = append(types, jobType)
+	}
+	now := q.Clock.Now()
+	filter := bson.M{
+		"type": bson.M{"$in": types},
+		"$or": bson.A{
+			bson.M{"status": StatusPending, "run_at": bson.M{"$lte": now}},
+			bson.M{"status": StatusRunning, "locked_until": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       StatusRunning,
+			"locked_by":    uuid.New().String(),
+			"locked_until": now.Add(q.Timeout),
+			"updated_at":   now,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "run_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var job Job
+	err := q.jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&job)
+	return job, err
+}
+
+// process runs one attempt of job and records its outcome
+func (q *Queue) process(ctx context.Context, job Job) {
+	logger := log.With().Str("job_id", job.ID.Hex()).Str("job_type", job.Type).Int("attempt", job.Attempts).Logger()
+	if job.Attempts > job.MaxAttempts {
+		// The last attempt's worker died; it is not run again
+		q.finish(job, StatusFailed, "abandoned after the last attempt's worker stopped")
+		attemptsTotal.WithLabelValues(job.Type, "abandoned").Inc()
+		logger.Error().Msg("Job abandoned")
+		return
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, q.Timeout)
+	started := time.Now()
+	err := q.run(attemptCtx, job)
+	cancel()
+	attemptDuration.WithLabelValues(job.Type).Observe(time.Since(started).Seconds())
+
+	var permanent permanentError
+	switch {
+	case err == nil:
+		q.finish(job, StatusDone, "")
+		attemptsTotal.WithLabelValues(job.Type, "done").Inc()
+		logger.Debug().Msg("Job done")
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		q.finish(job, StatusFailed, err.Error())
+		attemptsTotal.WithLabelValues(job.Type, "failed").Inc()
+		logger.Error().Err(err).Msg("Job failed")
+	default:
+		retryAt := q.Clock.Now().Add(q.backoff(job.Attempts))
+		q.update(job, bson.M{"status": StatusPending, "run_at": retryAt, "last_error": err.Error()})
+		attemptsTotal.WithLabelValues(job.Type, "retry").Inc()
+		logger.Warn().Err(err).Time("retry_at", retryAt).Msg("Job attempt failed, will retry")
+	}
+}
+
+// run calls the job's handler, turning a panic into a failed attempt
+func (q *Queue) run(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return q.handlers[job.Type](ctx, job)
+}
+
+// finish records job as finished with status
+func (q *Queue) finish(job Job, status, lastError string) {
+	set := bson.M{"status": status, "finished_at": q.Clock.Now()}
+	if lastError != "" {
+		set["last_error"] = lastError
+	}
+	q.update(job, set)
+}
+
+// update sets fields on job, releasing its lease, unless another worker has
+// taken it over
+func (q *Queue) update(job Job, set bson.M) {
+	set["updated_at"] = q.Clock.Now()
+	update := bson.M{"$set": set, "$unset": bson.M{"locked_by": "", "locked_until": ""}}
+	// The outcome is recorded even when the workers are stopping
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result, err := q.jobs.UpdateOne(ctx, bson.M{"_id": job.ID, "locked_by": job.LockedBy}, update)
+	if err != nil {
+		log.Error().Err(err).Str("job_id", job.ID.Hex()).Str("status", set["status"].(string)).Msg("Failed to record job outcome")
+		return
+	}
+	if result.MatchedCount == 0 {
+		log.Warn().Str("job_id", job.ID.Hex()).Msg("Job was taken over by another worker before its outcome was recorded")
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts
+func (q *Queue) backoff(failures int) time.Duration {
+	d := q.BaseBackoff
+	for i := 1; i < failures && d < q.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.MaxBackoff {
+		d = q.MaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,27 @@
+package jobs
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	attemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "job_attempts_total",
+			Help: "Total number of job attempts by type and outcome",
+		},
+		[]string{"type", "result"},
+	)
+
+	attemptDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "job_attempt_duration_seconds",
+			Help:    "Duration of job attempts by type",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"type"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(attemptsTotal)
+	prometheus.MustRegister(attemptDuration)
+}
@@ -0,0 +1,303 @@
+// Package jwks verifies tokens with the public keys an auth service
+// publishes as a JSON Web Key Set, so it can rotate its signing keys
+// without the services verifying its tokens being redeployed.
+package jwks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/httpclient"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// minRefetch is how soon the set may be fetched again for a token signed
+// with a key it does not have, so forged key IDs cannot flood the endpoint
+const minRefetch = time.Minute
+
+// ErrUnknownKey is returned for tokens signed with a key the set does not
+// have
+var ErrUnknownKey = errors.New("token signed with an unknown key")
+
+// Set caches the keys published at a JWKS URL. Keys are fetched again
+// periodically by Run, and when a token names a key the set does not have
+// yet, as happens right after the auth service rotates its keys.
+type Set struct {
+	url    string
+	clock  clock.Clock
+	client *httpclient.Client
+
+	mu        sync.RWMutex
+	keys      []key
+	fetchedAt time.Time
+	// fetching serializes fetches, so a burst of tokens with a new key
+	// fetches the set once
+	fetching sync.Mutex
+}
+
+// key is a verification key of the set
+type key struct {
+	id     string
+	alg    string
+	public interface{}
+}
+
+// New returns a set for the JWKS at url; nil clk is the system clock. It
+// holds no keys until Refresh is called.
+func New(url string, clk clock.Clock) *Set {
+	if clk == nil {
+		clk = clock.System{}
+	}
+	return &Set{url: url, clock: clk, client: httpclient.New(httpclient.DefaultConfig("jwks"))}
+}
+
+// jwk is a JSON Web Key as published in a set (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Refresh fetches the set, replacing the cached keys. Keys of types that
+// cannot verify signatures are skipped.
+func (s *Set) Refresh(ctx context.Context) error {
+	resp, err := s.client.Get(ctx, s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decode JWKS: %w", err)
+	}
+
+	var keys []key
+	for _, k := range body.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("Skipping unusable JWKS key")
+			continue
+		}
+		keys = append(keys, key{id: k.Kid, alg: k.Alg, public: public})
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS has no usable signing keys")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.fetchedAt = s.clock.Now()
+	return nil
+}
+
+// Run fetches the set every interval until ctx is done. A failed fetch
+// keeps the cached keys.
+func (s *Set) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to refresh JWKS, keeping the cached keys")
+			}
+		}
+	}
+}
+
+// Ready fails while the set holds no keys, when no token can be verified.
+// Until it has keys it tries fetching them again, at most once a minute.
+func (s *Set) Ready(ctx context.Context) error {
+	if s.empty() && !s.refetch() {
+		return fmt.Errorf("no JWKS keys fetched from %s", s.url)
+	}
+	return nil
+}
+
+func (s *Set) empty() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys) == 0
+}
+
+// Keys returns the keys of the set that may have signed token: the key
+// named by its kid header, or without one every key suited to its
+// algorithm. When none matches, as for a key the auth service just
+// rotated in, the set is fetched again, at most once a minute.
+func (s *Set) Keys(token *jwt.Token) ([]interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	keys := s.matching(token.Method.Alg(), kid)
+	if len(keys) == 0 && s.refetch() {
+		keys = s.matching(token.Method.Alg(), kid)
+	}
+	if len(keys) == 0 {
+		return nil, ErrUnknownKey
+	}
+	return keys, nil
+}
+
+// matching returns the cached keys for the algorithm and key ID
+func (s *Set) matching(alg, kid string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var keys []interface{}
+	for _, k := range s.keys {
+		if (kid == "" || k.id == kid) && k.suits(alg) {
+			keys = append(keys, k.public)
+		}
+	}
+	return keys
+}
+
+// refetch fetches the set again unless it was fetched within minRefetch,
+// reporting whether it did
+func (s *Set) refetch() bool {
+	s.fetching.Lock()
+	defer s.fetching.Unlock()
+	s.mu.RLock()
+	recent := !s.fetchedAt.IsZero() && s.clock.Now().Sub(s.fetchedAt) < minRefetch
+	s.mu.RUnlock()
+	if recent {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Refresh(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to fetch JWKS for an unknown key")
+		// Count the attempt, so a failing endpoint is not retried for
+		// every request
+		s.mu.Lock()
+		s.fetchedAt = s.clock.Now()
+		s.mu.Unlock()
+		return false
+	}
+	return true
+}
+
+// suits reports whether the key may verify tokens signed with alg: its
+// declared algorithm, if any, must be alg itself
+func (k key) suits(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	return suits(k.public, alg)
+}
+
+// suits reports whether the key's type matches the algorithm's family,
+// and for ECDSA its curve the algorithm's. A token naming another
+// algorithm than its key's, such as HS256 with an RSA key's ID, is never
+// verified with that key.
+func suits(public interface{}, alg string) bool {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") || strings.HasPrefix(alg, "PS")
+	case *ecdsa.PublicKey:
+		switch alg {
+		case "ES256":
+			return public.Curve == elliptic.P256()
+		case "ES384":
+			return public.Curve == elliptic.P384()
+		case "ES512":
+			return public.Curve == elliptic.P521()
+		}
+	}
+	return false
+}
+
+// publicKey decodes the key's parameters
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("RSA modulus: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		public := &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if err := checkRSA(public); err != nil {
+			return nil, err
+		}
+		return public, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("EC x: %w", err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("EC y: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("EC point is not on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// decodeInt decodes a base64url-encoded big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// checkRSA rejects RSA keys too short to be trusted
+func checkRSA(public *rsa.PublicKey) error {
+	if public.N.BitLen() < 2048 {
+		return fmt.Errorf("RSA key of %d bits is too short", public.N.BitLen())
+	}
+	return nil
+}
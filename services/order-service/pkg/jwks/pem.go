@@ -0,0 +1,78 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ParsePEM decodes the RSA and ECDSA public keys in PEM data, from PUBLIC
+// KEY, RSA PUBLIC KEY and CERTIFICATE blocks
+func ParsePEM(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var (
+			public interface{}
+			err    error
+		)
+		switch block.Type {
+		case "PUBLIC KEY":
+			public, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			public, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				public = cert.PublicKey
+			}
+		default:
+			return nil, fmt.Errorf("unexpected PEM block %q", block.Type)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", block.Type, err)
+		}
+
+		switch public := public.(type) {
+		case *rsa.PublicKey:
+			if err := checkRSA(public); err != nil {
+				return nil, err
+			}
+		case *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported %T public key", public)
+		}
+		keys = append(keys, public)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM-encoded public key found")
+	}
+	return keys, nil
+}
+
+// Static returns the keys among keys that may have signed token, all of
+// those suited to its algorithm
+func Static(keys []crypto.PublicKey) func(token *jwt.Token) ([]interface{}, error) {
+	return func(token *jwt.Token) ([]interface{}, error) {
+		var matching []interface{}
+		for _, public := range keys {
+			if suits(public, token.Method.Alg()) {
+				matching = append(matching, public)
+			}
+		}
+		if len(matching) == 0 {
+			return nil, ErrUnknownKey
+		}
+		return matching, nil
+	}
+}
@@ -0,0 +1,242 @@
+// Package leader elects one replica to run the background jobs that would
+// duplicate work if every replica ran them, such as sweeps over all
+// orders. Replicas compete for a lease document in MongoDB; the holder
+// renews it every third of its duration and runs the jobs, and when it
+// dies or loses touch with the database the lease runs out and another
+// replica takes over. A leader shutting down gives the lease up at once.
+// A leader that has failed to renew for two thirds of the lease cancels its
+// jobs while the lease still holds, so a successor only starts once they
+// were told to stop; renewals and the waits between them are cut short at
+// that point, so a slow database cannot delay it. Leadership is not fenced beyond that: a job slow to
+// stop, or clocks drifting apart, may still overlap a successor briefly.
+package leader
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Collection holds one lease per election
+const Collection = "leader_leases"
+
+var leaderGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "leader_elected",
+		Help: "Whether this replica leads the election (1) or not (0)",
+	},
+	[]string{"election"},
+)
+
+func init() {
+	prometheus.MustRegister(leaderGauge)
+}
+
+type lease struct {
+	Name       string    `bson:"_id"`
+	Holder     string    `bson:"holder"`
+	Host       string    `bson:"host"`
+	Until      time.Time `bson:"lease_until"`
+	AcquiredAt time.Time `bson:"acquired_at"`
+}
+
+// Elector takes part in one election and runs its jobs while leading
+type Elector struct {
+	leases  *mongo.Collection
+	name    string
+	holder  string
+	host    string
+	jobs    []func(context.Context)
+	leading int32
+	// acquireLease is acquire, replaced in tests
+	acquireLease func(ctx context.Context) (bool, error)
+
+	// Lease is how long a leader may go without renewing before another
+	// replica takes over
+	Lease time.Duration
+	Clock clock.Clock
+}
+
+// NewElector returns a candidate in the election name, whose lease is kept
+// in leases
+func NewElector(leases *mongo.Collection, name string) *Elector {
+	host, _ := os.Hostname()
+	e := &Elector{
+		leases: leases,
+		name:   name,
+		holder: uuid.New().String(),
+		host:   host,
+		Lease:  15 * time.Second,
+		Clock:  clock.System{},
+	}
+	e.acquireLease = e.acquire
+	return e
+}
+
+// Add registers job to run while this replica leads, until the context it
+// is given is done. Jobs are added before Run.
+func (e *Elector) Add(job func(ctx context.Context)) {
+	e.jobs = append(e.jobs, job)
+}
+
+// Leading reports whether this replica leads
+func (e *Elector) Leading() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run campaigns until ctx is done, running the jobs while leading, then
+// stops them and gives the lease up
+func (e *Elector) Run(ctx context.Context) {
+	var stop context.CancelFunc
+	var running sync.WaitGroup
+	// renewed is when the last successful renewal started; the lease holds
+	// until at least renewed + Lease
+	var renewed time.Time
+	stepDown := func(reason string) {
+		if stop == nil {
+			return
+		}
+		stop()
+		running.Wait()
+		stop = nil
+		e.setLeading(false)
+		log.Warn().Str("election", e.name).Str("reason", reason).Msg("Stepped down as leader")
+	}
+
+	// left is the time until a leader steps down, unless it renews first
+	left := func() time.Duration {
+		return e.stepDownAt(renewed).Sub(e.Clock.Now())
+	}
+
+	for {
+		// The lease still holds, but the jobs are stopped while it does: by
+		// the next attempt it may have run out
+		if stop != nil && left() <= 0 {
+			stepDown("lease not renewed")
+		}
+		attempt := e.Clock.Now()
+		timeout := e.Lease / 3
+		if stop != nil && left() < timeout {
+			timeout = left()
+		}
+		held, err := e.renew(ctx, timeout)
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				break
+			}
+			log.Error().Err(err).Str("election", e.name).Msg("Failed to renew leader lease")
+			if stop != nil && left() <= 0 {
+				stepDown("lease not renewed")
+			}
+		case held:
+			renewed = attempt
+			if stop == nil {
+				var jobCtx context.Context
+				jobCtx, stop = context.WithCancel(ctx)
+				e.setLeading(true)
+				log.Info().Str("election", e.name).Str("host", e.host).Int("jobs", len(e.jobs)).Msg("Elected leader")
+				for _, job := range e.jobs {
+					running.Add(1)
+					go func(job func(context.Context)) {
+						defer running.Done()
+						job(jobCtx)
+					}(job)
+				}
+			}
+		default:
+			stepDown("lease taken by another replica")
+		}
+
+		wait := e.Lease / 3
+		if stop != nil && left() < wait {
+			wait = left()
+		}
+		select {
+		case <-ctx.Done():
+			if stop != nil {
+				stop()
+				running.Wait()
+				e.setLeading(false)
+				e.release()
+			}
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// stepDownAt returns when a leader that last renewed at renewed stops its
+// jobs: two thirds into the lease, leaving the rest for them to stop
+func (e *Elector) stepDownAt(renewed time.Time) time.Time {
+	return renewed.Add(e.Lease * 2 / 3)
+}
+
+// renew acquires the lease within timeout, so a stalled database cannot
+// hold the loop past the point of stepping down
+func (e *Elector) renew(ctx context.Context, timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return e.acquireLease(ctx)
+}
+
+// acquire takes the lease if it is free or renews it if this replica holds
+// it, reporting whether it does
+func (e *Elector) acquire(ctx context.Context) (bool, error) {
+	now := e.Clock.Now()
+	filter := bson.M{"_id": e.name, "$or": bson.A{
+		bson.M{"holder": e.holder},
+		bson.M{"lease_until": bson.M{"$lt": now}},
+	}}
+	update := bson.A{bson.M{"$set": bson.M{
+		"holder":      e.holder,
+		"host":        e.host,
+		"lease_until": now.Add(e.Lease),
+		// Kept while the lease is renewed
+		"acquired_at": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$holder", e.holder}}, "$acquired_at", now}},
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var held lease
+	err := e.leases.FindOneAndUpdate(ctx, filter, update, opts).Decode(&held)
+	if mongo.IsDuplicateKeyError(err) {
+		// Another replica holds the lease
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return held.Holder == e.holder, nil
+}
+
+// release gives the lease up so another replica takes over at once
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := e.leases.UpdateOne(ctx, bson.M{"_id": e.name, "holder": e.holder}, bson.M{"$set": bson.M{"lease_until": time.Time{}}})
+	if err != nil {
+		log.Warn().Err(err).Str("election", e.name).Msg("Failed to release leader lease")
+		return
+	}
+	log.Info().Str("election", e.name).Msg("Released leader lease")
+}
+
+func (e *Elector) setLeading(leading bool) {
+	value := int32(0)
+	if leading {
+		value = 1
+	}
+	atomic.StoreInt32(&e.leading, value)
+	leaderGauge.WithLabelValues(e.name).Set(float64(value))
+}
@@ -0,0 +1,83 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"order-service/pkg/clock"
+)
+
+func TestSlowRenewStepsDownBeforeExpiry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	e := NewElector(nil, "test")
+	e.Clock = fake
+	e.Lease = 300 * time.Millisecond
+
+	var mu sync.Mutex
+	var timeouts []time.Duration
+	calls := 0
+	e.acquireLease = func(ctx context.Context) (bool, error) {
+		mu.Lock()
+		calls++
+		call := calls
+		if deadline, ok := ctx.Deadline(); ok {
+			timeouts = append(timeouts, time.Until(deadline))
+		}
+		mu.Unlock()
+
+		switch call {
+		case 1:
+			return true, nil
+		case 2:
+			// Half the lease passes before the database answers
+			fake.Advance(e.Lease / 2)
+			return false, errors.New("renewal failed")
+		case 3:
+			// The database stalls until the renewal is given up
+			<-ctx.Done()
+			fake.Advance(e.Lease / 6)
+			return false, ctx.Err()
+		}
+		return false, nil
+	}
+
+	stopped := make(chan time.Time, 1)
+	e.Add(func(ctx context.Context) {
+		<-ctx.Done()
+		stopped <- fake.Now()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case at := <-stopped:
+		if !at.Before(start.Add(e.Lease)) {
+			t.Errorf("jobs stopped at %v, after the lease ran out at %v", at, start.Add(e.Lease))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("jobs were not stopped")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(timeouts) < 3 {
+		t.Fatalf("%d renewals, want 3", len(timeouts))
+	}
+	// Only a sixth of the lease was left before stepping down
+	if timeouts[2] > e.Lease/6 {
+		t.Errorf("stalled renewal given %v, want at most %v", timeouts[2], e.Lease/6)
+	}
+}
@@ -0,0 +1,160 @@
+// Package live pushes order events to clients watching an order. The feed
+// tails the event store rather than listening to local publishes, so a
+// change made by any replica reaches watchers connected to every other.
+package live
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// watcherBuffer is how many events a watcher may fall behind before it is
+// dropped; its client reconnects and starts again from the current state
+const watcherBuffer = 16
+
+// Log is the part of the event store the feed tails; it is implemented by
+// *events.Store
+type Log interface {
+	Since(ctx context.Context, after primitive.ObjectID, settle time.Duration, limit int64) ([]events.Record, error)
+}
+
+type watcher struct {
+	events chan contracts.Event
+}
+
+// Feed hands stored order events to the watchers of each order
+type Feed struct {
+	log Log
+
+	mu       sync.Mutex
+	watchers map[string]map[*watcher]struct{}
+	position primitive.ObjectID
+
+	// Settle is how old events must be before they are read, so slightly
+	// out-of-order inserts from other replicas are not skipped. It adds to
+	// the latency of every update.
+	Settle    time.Duration
+	BatchSize int64
+	Clock     clock.Clock
+}
+
+// NewFeed returns a feed tailing log
+func NewFeed(log Log) *Feed {
+	return &Feed{
+		log:       log,
+		watchers:  map[string]map[*watcher]struct{}{},
+		Settle:    time.Second,
+		BatchSize: 500,
+		Clock:     clock.System{},
+	}
+}
+
+// Watch returns the events of orderID stored from now on. The channel is
+// closed when the watcher falls too far behind; stop must be called once
+// the caller is done.
+func (f *Feed) Watch(orderID string) (<-chan contracts.Event, func()) {
+	w := &watcher{events: make(chan contracts.Event, watcherBuffer)}
+
+	f.mu.Lock()
+	if f.watchers[orderID] == nil {
+		f.watchers[orderID] = map[*watcher]struct{}{}
+	}
+	f.watchers[orderID][w] = struct{}{}
+	f.mu.Unlock()
+
+	stop := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.remove(orderID, w)
+	}
+	return w.events, stop
+}
+
+// remove unregisters w and closes its channel unless that already happened;
+// f.mu must be held
+func (f *Feed) remove(orderID string, w *watcher) {
+	if _, ok := f.watchers[orderID][w]; !ok {
+		return
+	}
+	delete(f.watchers[orderID], w)
+	if len(f.watchers[orderID]) == 0 {
+		delete(f.watchers, orderID)
+	}
+	close(w.events)
+}
+
+// Run tails the event store every interval until ctx is cancelled. The
+// store is only read while someone is watching.
+func (f *Feed) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.poll(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to read order events for live updates")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll hands every settled event since the last poll to its watchers
+func (f *Feed) poll(ctx context.Context) error {
+	f.mu.Lock()
+	watching := len(f.watchers) > 0
+	if !watching || f.position.IsZero() {
+		// Nothing that happened while no one was watching is of interest
+		f.position = primitive.NewObjectIDFromTimestamp(f.Clock.Now().Add(-f.Settle))
+	}
+	position := f.position
+	f.mu.Unlock()
+	if !watching {
+		return nil
+	}
+
+	for {
+		records, err := f.log.Since(ctx, position, f.Settle, f.BatchSize)
+		if err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		f.mu.Lock()
+		for _, record := range records {
+			f.deliver(record.Event)
+		}
+		position = records[len(records)-1].ID
+		f.position = position
+		f.mu.Unlock()
+
+		if int64(len(records)) < f.BatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver hands event to the watchers of its order, dropping those that
+// are too far behind; f.mu must be held
+func (f *Feed) deliver(event contracts.Event) {
+	for w := range f.watchers[event.OrderID] {
+		select {
+		case w.events <- event:
+		default:
+			log.Warn().Str("order_id", event.OrderID).Msg("Dropping slow live update watcher")
+			f.remove(event.OrderID, w)
+		}
+	}
+}
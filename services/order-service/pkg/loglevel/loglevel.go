@@ -0,0 +1,156 @@
+// Package loglevel changes what the service logs while it runs: the level
+// of every entry, overridden for a while by operators, and debug logging
+// for single requests that ask for it. The level is process-wide, like the
+// logger it filters.
+package loglevel
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestLevel is the level of requests that asked for debug logging
+const RequestLevel = zerolog.DebugLevel
+
+var (
+	// level is the zerolog.Level entries are written at or above
+	level int32 = int32(zerolog.InfoLevel)
+	// output is the writer under the filter, for request loggers
+	output io.Writer = os.Stderr
+
+	mu         sync.Mutex
+	configured = zerolog.InfoLevel
+	override   *zerolog.Level
+	until      time.Time
+	revert     *time.Timer
+)
+
+// Status is the level in force and where it comes from
+type Status struct {
+	Level string `json:"level"`
+	// Override is whether an operator set Level rather than LOG_LEVEL
+	Override bool `json:"override"`
+	// Configured is LOG_LEVEL, in force again once an override ends
+	Configured string `json:"configured"`
+	// Until is when an override ends; nil while it lasts until reset
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// filter drops entries below the level in force. zerolog's global level
+// stays at trace so request loggers can write below it.
+type filter struct {
+	w io.Writer
+}
+
+func (f filter) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+func (f filter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	if l < zerolog.Level(atomic.LoadInt32(&level)) {
+		return len(p), nil
+	}
+	return f.w.Write(p)
+}
+
+// Setup makes log.Logger write to w, filtered by the level in force
+func Setup(w io.Writer) {
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	output = w
+	log.Logger = log.Output(filter{w: w})
+}
+
+// SetConfigured sets the configured level, in force unless overridden
+func SetConfigured(l zerolog.Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	configured = l
+	apply()
+}
+
+// Override sets the level in force to l until Reset, or for d when d is
+// positive
+func Override(l zerolog.Level, d time.Duration) Status {
+	mu.Lock()
+	defer mu.Unlock()
+	override = &l
+	until = time.Time{}
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	if d > 0 {
+		until = time.Now().Add(d)
+		var timer *time.Timer
+		timer = time.AfterFunc(d, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			// A later override replaced this one
+			if revert != timer {
+				return
+			}
+			override, until, revert = nil, time.Time{}, nil
+			apply()
+			log.Log().Str("log_level", configured.String()).Msg("Log level override expired")
+		})
+		revert = timer
+	}
+	apply()
+	return current()
+}
+
+// Reset ends an override, putting the configured level back in force
+func Reset() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	override, until = nil, time.Time{}
+	apply()
+	return current()
+}
+
+// Current returns the level in force
+func Current() Status {
+	mu.Lock()
+	defer mu.Unlock()
+	return current()
+}
+
+func current() Status {
+	status := Status{
+		Level:      zerolog.Level(atomic.LoadInt32(&level)).String(),
+		Override:   override != nil,
+		Configured: configured.String(),
+	}
+	if !until.IsZero() {
+		t := until
+		status.Until = &t
+	}
+	return status
+}
+
+func apply() {
+	l := configured
+	if override != nil {
+		l = *override
+	}
+	atomic.StoreInt32(&level, int32(l))
+}
+
+// Debug returns ctx with a logger writing RequestLevel entries and above
+// through log.Ctx whatever the level in force, keeping the fields of the
+// logger ctx carries
+func Debug(ctx context.Context) context.Context {
+	logger := log.Ctx(ctx).Output(output).Level(RequestLevel)
+	return logger.WithContext(ctx)
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Context keys set by Auth; ContextRole holds the "role" claim, see
+// ContextRoles for every role of the token
+const (
+	ContextUserID = "userID"
+	ContextEmail  = "email"
+	ContextRole   = "role"
+	// ContextTenantID holds the tenant_id claim; see Tenant
+	ContextTenantID = "tenantID"
+)
+
+// KeySource returns the keys a token may have been signed with, given the
+// token as parsed but not yet verified. Sources only return keys of the
+// kind the token's algorithm calls for, so a token cannot pick how it is
+// verified.
+type KeySource func(token *jwt.Token) ([]interface{}, error)
+
+// HMACKeys verifies HMAC-signed tokens with any of the secrets returned
+// for the request
+func HMACKeys(secrets func() [][]byte) KeySource {
+	return func(token *jwt.Token) ([]interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		var keys []interface{}
+		for _, secret := range secrets() {
+			keys = append(keys, secret)
+		}
+		return keys, nil
+	}
+}
+
+// BySigningMethod verifies HMAC-signed tokens with hmac and any other token
+// with asymmetric, such as the keys of a JWKS; a nil source rejects its
+// tokens
+func BySigningMethod(hmac, asymmetric KeySource) KeySource {
+	return func(token *jwt.Token) ([]interface{}, error) {
+		source := asymmetric
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			source = hmac
+		}
+		if source == nil {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return source(token)
+	}
+}
+
+// AnyOf returns the keys of every source that has some for the token, such
+// as PEM-configured public keys and a JWKS
+func AnyOf(sources ...KeySource) KeySource {
+	return func(token *jwt.Token) ([]interface{}, error) {
+		var (
+			keys    []interface{}
+			lastErr error
+		)
+		for _, source := range sources {
+			found, err := source(token)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			keys = append(keys, found...)
+		}
+		if len(keys) == 0 && lastErr != nil {
+			return nil, lastErr
+		}
+		return keys, nil
+	}
+}
+
+// Auth validates the HMAC-signed bearer token and stores its claims in the
+// Gin context
+func Auth(secret []byte) gin.HandlerFunc {
+	return Authenticate(HMACKeys(func() [][]byte { return [][]byte{secret} }))
+}
+
+// AuthRotating is Auth with secrets that change at runtime: a token
+// signed with any of the secrets returned for the request is accepted, so
+// tokens issued before a rotation keep working while the previous secret
+// is still returned
+func AuthRotating(secrets func() [][]byte) gin.HandlerFunc {
+	return Authenticate(HMACKeys(secrets))
+}
+
+// Authenticate validates the bearer token with the keys from keys and
+// stores its claims in the Gin context. When algorithms are given, tokens
+// signed with any other, such as one downgraded to HS256 or "none", are
+// rejected before any key is looked up.
+func Authenticate(keys KeySource, algorithms ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.Abort()
+			return
+		}
+
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if tokenString == authHeader {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bearer token required"})
+			c.Abort()
+			return
+		}
+
+		token, err := verify(tokenString, keys, algorithms)
+		if token == nil || err != nil || !token.Valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if userID, ok := claims["userId"].(string); ok {
+				c.Set(ContextUserID, userID)
+			}
+			if email, ok := claims["email"].(string); ok {
+				c.Set(ContextEmail, email)
+			}
+			if id, ok := claims[tenant.Claim].(string); ok {
+				if !tenant.Valid(id) {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+					c.Abort()
+					return
+				}
+				c.Set(ContextTenantID, id)
+			}
+			setAuthorization(c, claims)
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole rejects requests whose token does not carry the given role
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasRole(c, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// verify parses the token, trying each key from keys until one verifies
+// its signature
+func verify(tokenString string, keys KeySource, algorithms []string) (*jwt.Token, error) {
+	var (
+		candidates []interface{}
+		next       int
+	)
+	keyfunc := func(token *jwt.Token) (interface{}, error) {
+		if candidates == nil {
+			var err error
+			if candidates, err = keys(token); err != nil {
+				return nil, err
+			}
+			if len(candidates) == 0 {
+				return nil, fmt.Errorf("no key for signing method %v", token.Header["alg"])
+			}
+		}
+		return candidates[next], nil
+	}
+	for {
+		var options []jwt.ParserOption
+		if len(algorithms) > 0 {
+			options = append(options, jwt.WithValidMethods(algorithms))
+		}
+		token, err := jwt.Parse(tokenString, keyfunc, options...)
+		if err == nil || !errors.Is(err, jwt.ErrTokenSignatureInvalid) || next+1 >= len(candidates) {
+			return token, err
+		}
+		next++
+	}
+}
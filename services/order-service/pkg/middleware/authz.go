@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Roles a token may carry in its "role" or "roles" claim
+const (
+	RoleCustomer    = "customer"
+	RoleFulfillment = "fulfillment"
+	RoleAdmin       = "admin"
+)
+
+// Scopes routes require. A token has the scopes of its roles and those
+// listed in its "scope" (space-separated) or "scp" claim.
+const (
+	// ScopeOrdersRead reads the caller's own orders
+	ScopeOrdersRead = "orders:read"
+	// ScopeOrdersWrite places and changes the caller's own orders
+	ScopeOrdersWrite = "orders:write"
+	// ScopeOrdersFulfill reads every order, changes order statuses and
+	// ships orders
+	ScopeOrdersFulfill = "orders:fulfill"
+	// ScopeOrdersAdmin manages any order and the service itself
+	ScopeOrdersAdmin = "orders:admin"
+	// ScopeWebhooks manages the caller's webhook subscriptions, which make
+	// the service call out to the URLs they name
+	ScopeWebhooks = "webhooks:manage"
+)
+
+// Context keys for every role and scope of the token, set by Auth
+const (
+	ContextRoles  = "roles"
+	ContextScopes = "scopes"
+)
+
+// roleScopes are the scopes each role grants
+var roleScopes = map[string][]string{
+	RoleCustomer:    {ScopeOrdersRead, ScopeOrdersWrite},
+	RoleFulfillment: {ScopeOrdersRead, ScopeOrdersFulfill},
+	RoleAdmin:       {ScopeOrdersRead, ScopeOrdersWrite, ScopeOrdersFulfill, ScopeOrdersAdmin, ScopeWebhooks},
+}
+
+// setAuthorization stores the roles and scopes of the claims. A token
+// with neither is a customer's, as tokens were before roles existed.
+func setAuthorization(c *gin.Context, claims jwt.MapClaims) {
+	roles := claimList(claims["roles"])
+	if role, ok := claims["role"].(string); ok && role != "" {
+		c.Set(ContextRole, role)
+		roles = append(roles, role)
+	}
+	scopes := claimList(claims["scp"])
+	if scope, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(scope)...)
+	}
+	if len(roles) == 0 && len(scopes) == 0 {
+		roles = []string{RoleCustomer}
+	}
+	c.Set(ContextRoles, roles)
+	c.Set(ContextScopes, scopes)
+}
+
+// claimList reads a claim holding a list of strings, or a single string
+// of space-separated values
+func claimList(claim interface{}) []string {
+	switch claim := claim.(type) {
+	case string:
+		return strings.Fields(claim)
+	case []interface{}:
+		var values []string
+		for _, v := range claim {
+			if s, ok := v.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// roles returns the roles of the request's token. Requests authenticated
+// without Auth, such as by the Pact stub, have the single ContextRole or
+// are customers.
+func roles(c *gin.Context) []string {
+	if roles, ok := c.Get(ContextRoles); ok {
+		return roles.([]string)
+	}
+	if role := c.GetString(ContextRole); role != "" {
+		return []string{role}
+	}
+	return []string{RoleCustomer}
+}
+
+// HasRole reports whether the request's token carries role
+func HasRole(c *gin.Context, role string) bool {
+	for _, r := range roles(c) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the request's token grants scope, through one
+// of its roles or its scope claim
+func HasScope(c *gin.Context, scope string) bool {
+	for _, role := range roles(c) {
+		for _, s := range roleScopes[role] {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	for _, s := range c.GetStringSlice(ContextScopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope rejects requests whose token does not grant scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !HasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied", "required_scope": scope})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
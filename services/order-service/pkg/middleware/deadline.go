@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"order-service/pkg/breaker"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusClientClosedRequest is recorded for requests the client abandoned
+// before a response was written (nginx's 499)
+const StatusClientClosedRequest = 499
+
+// Deadline bounds the request context with timeout, so database and
+// downstream calls made from it are cancelled both when the client goes away
+// and when the endpoint's deadline passes. A handler that returns without
+// responding because its context ended gets a 504 or 499 here.
+func Deadline(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if !c.Writer.Written() {
+			RequestEnded(c)
+		}
+	}
+}
+
+// RequestEnded answers a request whose context is done: 504 if its deadline
+// passed, 499 without a body if the client disconnected. It reports whether
+// the context had ended, so handlers can call it before treating a failed
+// call as an internal error.
+func RequestEnded(c *gin.Context) bool {
+	switch c.Request.Context().Err() {
+	case context.DeadlineExceeded:
+		c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "Request timed out"})
+		return true
+	case context.Canceled:
+		c.AbortWithStatus(StatusClientClosedRequest)
+		return true
+	}
+	return false
+}
+
+// RequestFailed is RequestEnded for a handler whose call failed with err,
+// also answering 503 when err is a dependency's open circuit breaker, with
+// a Retry-After of when the breaker probes it again
+func RequestFailed(c *gin.Context, err error) bool {
+	if RequestEnded(c) {
+		return true
+	}
+	if !errors.Is(err, breaker.ErrOpen) {
+		return false
+	}
+	var open *breaker.OpenError
+	if errors.As(err, &open) && open.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int((open.RetryAfter+time.Second-1)/time.Second)))
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Service temporarily unavailable, please retry"})
+	return true
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"order-service/pkg/loglevel"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// HeaderDebugLog asks for a request to be logged at debug level; its value
+// must be the configured debug log token
+const HeaderDebugLog = "X-Debug-Log"
+
+// DebugLogging logs the requests carrying token in X-Debug-Log at debug
+// level through log.Ctx, whatever the level in force, so a failing request
+// can be traced in production without raising the level for everyone. An
+// empty token disables the header. It must run after RequestID, whose
+// logger the request's builds on.
+func DebugLogging(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(HeaderDebugLog)
+		if token == "" || value == "" {
+			c.Next()
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(value), []byte(token)) != 1 {
+			log.Ctx(c.Request.Context()).Warn().Str("path", c.FullPath()).Msg("Debug logging requested with an invalid token")
+			c.Next()
+			return
+		}
+
+		c.Request = c.Request.WithContext(loglevel.Debug(c.Request.Context()))
+		log.Ctx(c.Request.Context()).Debug().
+			Str("method", c.Request.Method).
+			Str("path", c.Request.URL.Path).
+			Msg("Debug logging enabled for request")
+		c.Next()
+	}
+}
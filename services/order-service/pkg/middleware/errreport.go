@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+
+	"order-service/pkg/errreport"
+	"order-service/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReportErrors reports panics and 5xx responses other than 503, which the
+// service answers on purpose while unavailable, to reporter. A response is
+// reported with the last error its handler logged through log.Ctx, and
+// where it was logged. Panics are reported where they were raised and
+// raised again for gin.Recovery, which must run before. A nil reporter
+// reports nothing.
+func ReportErrors(reporter errreport.ErrorReporter) gin.HandlerFunc {
+	if reporter == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		ctx, capture := errreport.WithCapture(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Raised by the server when the client went away
+			if p != http.ErrAbortHandler {
+				err, ok := p.(error)
+				if !ok {
+					err = fmt.Errorf("%v", p)
+				}
+				event := requestEvent(c, http.StatusInternalServerError)
+				event.Message = "panic: " + err.Error()
+				event.Err = err
+				event.Panic = true
+				event.Stack = errreport.Callers(1)
+				reporter.Report(ctx, event)
+			}
+			panic(p)
+		}()
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusInternalServerError || status == http.StatusServiceUnavailable {
+			return
+		}
+		event := requestEvent(c, status)
+		if message, stack, ok := capture.Last(); ok {
+			event.Message = message
+			event.Stack = stack
+		} else {
+			event.Message = fmt.Sprintf("%s %s answered %d", c.Request.Method, event.Route, status)
+		}
+		if last := c.Errors.Last(); last != nil {
+			event.Err = last.Err
+		}
+		reporter.Report(ctx, event)
+	}
+}
+
+// requestEvent returns the event of the request c serves
+func requestEvent(c *gin.Context, status int) errreport.Event {
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	return errreport.Event{
+		Request:   c.Request,
+		Route:     route,
+		Status:    status,
+		UserID:    c.GetString(ContextUserID),
+		RequestID: requestid.FromContext(c.Request.Context()),
+	}
+}
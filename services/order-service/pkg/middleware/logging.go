@@ -0,0 +1,46 @@
+// Package middleware contains the Gin middlewares shared by every Go service:
+// access logging, metrics, CORS, JWT auth, request IDs and rate limiting.
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSkipPaths are not access-logged
+var DefaultSkipPaths = []string{"/health", "/healthz", "/readyz", "/metrics"}
+
+// AccessLog is where Logging writes, stdout unless the service wraps it
+var AccessLog io.Writer = os.Stdout
+
+// Logging writes one access-log line per request to AccessLog, ending with
+// the request ID
+func Logging(skipPaths ...string) gin.HandlerFunc {
+	if len(skipPaths) == 0 {
+		skipPaths = DefaultSkipPaths
+	}
+
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Formatter: func(param gin.LogFormatterParams) string {
+			requestID, _ := param.Keys[ContextRequestID].(string)
+			return fmt.Sprintf("%s - [%s] \"%s %s %s %d %s \"%s\" %s\" %s\n",
+				param.ClientIP,
+				param.TimeStamp.Format(time.RFC1123),
+				param.Method,
+				param.Path,
+				param.Request.Proto,
+				param.StatusCode,
+				param.Latency,
+				param.Request.UserAgent(),
+				param.ErrorMessage,
+				requestID,
+			)
+		},
+		Output:    AccessLog,
+		SkipPaths: skipPaths,
+	})
+}
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Overloaded rejects requests with 503 while exhausted reports the database
+// connection pool exhausted, so callers learn at once to retry instead of
+// queueing for a connection until their deadline. Paths listed in exempt,
+// such as health checks and metrics, are always let through. A nil
+// exhausted lets every request through.
+func Overloaded(exhausted func() bool, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if exhausted == nil {
+			c.Next()
+			return
+		}
+		for _, path := range exempt {
+			if c.FullPath() == path {
+				c.Next()
+				return
+			}
+		}
+		if !exhausted() {
+			c.Next()
+			return
+		}
+
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Database connection pool exhausted, please retry",
+		})
+	}
+}
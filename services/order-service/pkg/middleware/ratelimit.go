@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimit applies a per-client token bucket refilled at rps up to burst.
+// Clients are keyed by authenticated user ID when available, otherwise by IP.
+// A non-positive rps disables limiting.
+func RateLimit(rps float64, burst int) gin.HandlerFunc {
+	if rps <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	var (
+		mu          sync.Mutex
+		buckets     = map[string]*bucket{}
+		lastCleanup = time.Now()
+	)
+
+	return func(c *gin.Context) {
+		key := c.GetString(ContextUserID)
+		if key == "" {
+			key = c.ClientIP()
+		}
+		now := time.Now()
+
+		mu.Lock()
+		// Drop idle buckets so the map does not grow without bound
+		if now.Sub(lastCleanup) > time.Minute {
+			for k, b := range buckets {
+				if now.Sub(b.lastSeen) > 10*time.Minute {
+					delete(buckets, k)
+				}
+			}
+			lastCleanup = now
+		}
+
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{tokens: float64(burst), lastSeen: now}
+			buckets[key] = b
+		}
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.lastSeen).Seconds()*rps)
+		b.lastSeen = now
+
+		allowed := b.tokens >= 1
+		var retryAfter float64
+		if allowed {
+			b.tokens--
+		} else {
+			retryAfter = (1 - b.tokens) / rps
+		}
+		mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadOnlyMode is a switch, flipped at startup or at runtime, under which
+// the service refuses writes while reads keep working. It is used during
+// failovers and database maintenance. The state is held per process.
+type ReadOnlyMode struct {
+	mu      sync.RWMutex
+	enabled bool
+	reason  string
+	since   time.Time
+}
+
+// ReadOnlyStatus describes the current read-only state
+type ReadOnlyStatus struct {
+	Enabled bool       `json:"read_only"`
+	Reason  string     `json:"reason,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// Set enables or disables read-only mode, recording why and when
+func (m *ReadOnlyMode) Set(enabled bool, reason string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+	m.reason = ""
+	m.since = time.Time{}
+	if enabled {
+		m.reason = reason
+		m.since = at
+	}
+}
+
+// Status returns the current state
+func (m *ReadOnlyMode) Status() ReadOnlyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status := ReadOnlyStatus{Enabled: m.enabled, Reason: m.reason}
+	if m.enabled {
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// ReadOnly rejects every request other than GET, HEAD and OPTIONS with 503
+// while mode is enabled. Paths listed in exempt, such as the endpoint that
+// switches the mode off, are always let through.
+func ReadOnly(mode *ReadOnlyMode, exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+		for _, path := range exempt {
+			if c.FullPath() == path {
+				c.Next()
+				return
+			}
+		}
+
+		status := mode.Status()
+		if !status.Enabled {
+			c.Next()
+			return
+		}
+
+		message := "The order service is in read-only mode; changes are temporarily disabled"
+		if status.Reason != "" {
+			message += ": " + status.Reason
+		}
+		c.Header("Retry-After", "60")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":     message,
+			"read_only": true,
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"order-service/pkg/requestid"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderRequestID carries the request ID between services
+const HeaderRequestID = requestid.Header
+
+// ContextRequestID is the Gin context key holding the request ID
+const ContextRequestID = "requestID"
+
+// RequestID accepts an incoming X-Request-ID or generates one, stores it in
+// the Gin and request contexts, so log.Ctx entries, outbound calls and
+// published events carry it, and echoes it in the response
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := requestid.Accept(c.GetHeader(HeaderRequestID))
+
+		c.Set(ContextRequestID, requestID)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), requestID))
+		c.Header(HeaderRequestID, requestID)
+		c.Next()
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"order-service/pkg/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant serves the request for the tenant of the authenticated caller,
+// carried by the request context from then on. With required, callers
+// whose token names no tenant are rejected with 403, so a multi-tenant
+// deployment never serves a request across tenants.
+func Tenant(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetString(ContextTenantID)
+		if id == "" {
+			if required {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Token has no tenant"})
+				return
+			}
+			c.Next()
+			return
+		}
+		c.Request = c.Request.WithContext(tenant.WithTenant(c.Request.Context(), id))
+		c.Next()
+	}
+}
@@ -0,0 +1,146 @@
+// Package migration versions stored documents so their model can change
+// safely. Every document carries a schema_version, and a schema holds the
+// ordered migrations that upgrade a document from one version to the
+// next. Documents are upgraded lazily as they are read, so old and new
+// documents coexist during a rollout, and in batch by Migrate, which
+// persists the upgrades.
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Field is the document field holding its schema version; documents
+// without it are at version 0
+const Field = "schema_version"
+
+// Migration upgrades a document to Version from the version before it.
+// Documents of the previous version may have been partially updated by
+// newer code since they were written, so Up must only rewrite fields still
+// in their old shape.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(doc bson.M) error
+}
+
+// Schema is the ordered migrations of one kind of document
+type Schema struct {
+	name       string
+	migrations []Migration
+}
+
+// NewSchema returns the schema of the documents called name, whose
+// migrations must be numbered from 1 in order; it panics otherwise, as the
+// list is fixed in code
+func NewSchema(name string, migrations ...Migration) *Schema {
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			panic(fmt.Sprintf("migration: %s migration %d has version %d", name, i+1, m.Version))
+		}
+	}
+	return &Schema{name: name, migrations: migrations}
+}
+
+// Version is the version of documents with every migration applied
+func (s *Schema) Version() int {
+	return len(s.migrations)
+}
+
+// VersionOf returns the schema version of a stored document
+func VersionOf(doc bson.Raw) int {
+	v, err := doc.LookupErr(Field)
+	if err != nil {
+		return 0
+	}
+	switch v.Type {
+	case bsontype.Int32:
+		return int(v.Int32())
+	case bsontype.Int64:
+		return int(v.Int64())
+	case bsontype.Double:
+		return int(v.Double())
+	}
+	return 0
+}
+
+// Upgrade applies the migrations doc is missing and returns the upgraded
+// document; upgraded is false, and doc returned unchanged, when it is
+// current. Documents written by a newer version of the schema, as seen
+// while rolling back, are left as they are.
+func (s *Schema) Upgrade(doc bson.Raw) (_ bson.Raw, upgraded bool, err error) {
+	version := VersionOf(doc)
+	if version >= s.Version() {
+		return doc, false, nil
+	}
+	var m bson.M
+	if err := bson.Unmarshal(doc, &m); err != nil {
+		return nil, false, err
+	}
+	for _, migration := range s.migrations[version:] {
+		if err := migration.Up(m); err != nil {
+			return nil, false, fmt.Errorf("migrate %s to version %d (%s): %w", s.name, migration.Version, migration.Description, err)
+		}
+	}
+	m[Field] = s.Version()
+	out, err := bson.Marshal(m)
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// Outdated matches the documents some migration is missing from
+func (s *Schema) Outdated() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{Field: bson.M{"$exists": false}},
+		bson.M{Field: bson.M{"$lt": s.Version()}},
+	}}
+}
+
+// Migrate upgrades and saves every outdated document of collection, and
+// returns how many it saved, or would save with dryRun. Each document is
+// only replaced if unchanged since it was read; one written meanwhile is
+// skipped, and is still upgraded on read until the next run.
+func (s *Schema) Migrate(ctx context.Context, collection *mongo.Collection, dryRun bool) (int, error) {
+	if dryRun {
+		n, err := collection.CountDocuments(ctx, s.Outdated())
+		return int(n), err
+	}
+
+	cursor, err := collection.Find(ctx, s.Outdated(), options.Find().SetBatchSize(100))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		original := cursor.Current
+		upgraded, _, err := s.Upgrade(original)
+		if err != nil {
+			return migrated, fmt.Errorf("%s %s: %w", s.name, original.Lookup("_id"), err)
+		}
+		unchanged := bson.M{
+			"_id":   original.Lookup("_id"),
+			"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": original}}},
+		}
+		result, err := collection.ReplaceOne(ctx, unchanged, upgraded)
+		if err != nil {
+			return migrated, err
+		}
+		if result.MatchedCount == 0 {
+			log.Info().Str("schema", s.name).Str("id", original.Lookup("_id").String()).Msg("Document changed while migrating, skipped")
+			continue
+		}
+		migrated++
+	}
+	return migrated, cursor.Err()
+}
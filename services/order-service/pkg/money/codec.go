@@ -0,0 +1,186 @@
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// jsonMoney is the wire form: the amount as an exact decimal string
+type jsonMoney struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+// MarshalJSON encodes {"amount":"12.99","currency":"USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON accepts {"amount": "12.99" | 12.99, "currency": "EUR"}. A
+// bare number or string is read as an amount in DefaultCurrency so clients
+// that predate the currency field keep working.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	if len(data) > 0 && data[0] != '{' {
+		parsed, err := Parse(jsonAmount(data), DefaultCurrency)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	var wire jsonMoney
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	if wire.Currency == "" {
+		wire.Currency = DefaultCurrency
+	}
+	parsed, err := Parse(jsonAmount(wire.Amount), wire.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// jsonAmount returns the literal text of a JSON number or string
+func jsonAmount(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	return string(raw)
+}
+
+// LegacyJSON rewrites every money object in the JSON document data as a
+// bare decimal number, the form amounts had on the wire before they carried
+// a currency: {"amount":"12.99","currency":"USD"} becomes 12.99. The
+// currency is dropped, so it is only meant for clients that predate it.
+// Everything else, member order included, is kept.
+func LegacyJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeLegacy(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeLegacy writes value to buf with its money objects as numbers
+func writeLegacy(buf *bytes.Buffer, value json.RawMessage) error {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || (value[0] != '{' && value[0] != '[') {
+		buf.Write(value)
+		return nil
+	}
+	if m, ok := wireMoney(value); ok {
+		buf.WriteString(m.Decimal())
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(value))
+	open, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	object := open == json.Delim('{')
+	buf.WriteByte(value[0])
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if object {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name, err := json.Marshal(key)
+			if err != nil {
+				return err
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+		}
+		var member json.RawMessage
+		if err := dec.Decode(&member); err != nil {
+			return err
+		}
+		if err := writeLegacy(buf, member); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return err
+	}
+	if object {
+		buf.WriteByte('}')
+	} else {
+		buf.WriteByte(']')
+	}
+	return nil
+}
+
+// wireMoney reads value if it is a money object exactly as MarshalJSON
+// writes it
+func wireMoney(value json.RawMessage) (Money, bool) {
+	var wire map[string]json.RawMessage
+	if json.Unmarshal(value, &wire) != nil || len(wire) != 2 {
+		return Money{}, false
+	}
+	var amount, currency string
+	if json.Unmarshal(wire["amount"], &amount) != nil || json.Unmarshal(wire["currency"], &currency) != nil {
+		return Money{}, false
+	}
+	m, err := Parse(amount, currency)
+	return m, err == nil && m.Currency == currency
+}
+
+// bsonMoney is the stored form: minor units plus currency
+type bsonMoney struct {
+	Amount   int64  `bson:"amount"`
+	Currency string `bson:"currency"`
+}
+
+// MarshalBSONValue stores the amount as {amount: <minor units>, currency}
+func (m Money) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	data, err := bson.Marshal(bsonMoney{Amount: m.Amount, Currency: m.Currency})
+	return bsontype.EmbeddedDocument, data, err
+}
+
+// UnmarshalBSONValue reads the stored form. Documents written before amounts
+// were money hold a plain double, which is converted in DefaultCurrency.
+func (m *Money) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: t, Value: data}
+
+	switch t {
+	case bsontype.EmbeddedDocument:
+		var stored bsonMoney
+		if err := raw.Unmarshal(&stored); err != nil {
+			return err
+		}
+		*m = Money{Amount: stored.Amount, Currency: stored.Currency}
+	case bsontype.Double:
+		*m = FromFloat(raw.Double(), DefaultCurrency)
+	case bsontype.Int32:
+		*m = FromFloat(float64(raw.Int32()), DefaultCurrency)
+	case bsontype.Int64:
+		*m = FromFloat(float64(raw.Int64()), DefaultCurrency)
+	case bsontype.Null:
+		*m = Money{}
+	default:
+		return fmt.Errorf("cannot decode money from BSON %s", t)
+	}
+	return nil
+}
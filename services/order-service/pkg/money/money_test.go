@@ -0,0 +1,209 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     Money
+		wantErr  error
+	}{
+		{amount: "12.99", currency: "USD", want: New(1299, "USD")},
+		{amount: "12.9", currency: "usd", want: New(1290, "USD")},
+		{amount: "-3", currency: "EUR", want: New(-300, "EUR")},
+		{amount: ".5", currency: "USD", want: New(50, "USD")},
+		{amount: "12.990", currency: "USD", want: New(1299, "USD")},
+		{amount: "1000", currency: "JPY", want: New(1000, "JPY")},
+		{amount: "1.234", currency: "KWD", want: New(1234, "KWD")},
+		{amount: "12.999", currency: "USD", wantErr: ErrInvalidAmount},
+		{amount: "10.5", currency: "JPY", wantErr: ErrInvalidAmount},
+		{amount: "1e3", currency: "USD", wantErr: ErrInvalidAmount},
+		{amount: "", currency: "USD", wantErr: ErrInvalidAmount},
+		{amount: "99999999999999999999", currency: "USD", wantErr: ErrInvalidAmount},
+		{amount: "1", currency: "US", wantErr: ErrInvalidCurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			got, err := Parse(tt.amount, tt.currency)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRounding(t *testing.T) {
+	tests := []struct {
+		name string
+		got  Money
+		want Money
+	}{
+		{name: "float", got: FromFloat(29.48, "USD"), want: New(2948, "USD")},
+		{name: "float half up", got: FromFloat(0.125, "USD"), want: New(13, "USD")},
+		{name: "float half away from zero", got: FromFloat(-0.125, "USD"), want: New(-13, "USD")},
+		{name: "float without minor unit", got: FromFloat(1234.5, "JPY"), want: New(1235, "JPY")},
+		{name: "float with three decimals", got: FromFloat(1.2345, "KWD"), want: New(1235, "KWD")},
+		{name: "ratio half up", got: New(1000, "USD").MulRatio(825, 10000), want: New(83, "USD")},
+		{name: "ratio half away from zero", got: New(-1000, "USD").MulRatio(825, 10000), want: New(-83, "USD")},
+		{name: "ratio down", got: New(1000, "USD").MulRatio(824, 10000), want: New(82, "USD")},
+		{name: "ratio third", got: New(100, "USD").MulRatio(1, 3), want: New(33, "USD")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %+v, want %+v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{money: New(1299, "USD"), want: "12.99"},
+		{money: New(5, "USD"), want: "0.05"},
+		{money: New(-5, "USD"), want: "-0.05"},
+		{money: New(0, "EUR"), want: "0.00"},
+		{money: New(1000, "JPY"), want: "1000"},
+		{money: New(1234, "KWD"), want: "1.234"},
+	}
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.want {
+			t.Errorf("%+v.Decimal() = %q, want %q", tt.money, got, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for _, m := range []Money{New(1299, "USD"), New(-5, "EUR"), New(1000, "JPY"), New(1234, "KWD"), Zero("GBP")} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Money
+		if err := json.Unmarshal(data, &got); err != nil || got != m {
+			t.Errorf("round trip of %+v through %s = %+v, %v", m, data, got, err)
+		}
+	}
+
+	tests := []struct {
+		data    string
+		want    Money
+		wantErr bool
+	}{
+		{data: `{"amount":"12.99","currency":"USD"}`, want: New(1299, "USD")},
+		{data: `{"amount":12.99,"currency":"EUR"}`, want: New(1299, "EUR")},
+		{data: `{"amount":"12.99"}`, want: New(1299, DefaultCurrency)},
+		{data: `12.99`, want: New(1299, DefaultCurrency)},
+		{data: `"12.99"`, want: New(1299, DefaultCurrency)},
+		{data: `null`},
+		{data: `12.999`, wantErr: true},
+		{data: `{"amount":"1","currency":"DOLLARS"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.data, func(t *testing.T) {
+			var got Money
+			err := json.Unmarshal([]byte(tt.data), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Unmarshal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBSON(t *testing.T) {
+	type doc struct {
+		Total Money `bson:"total"`
+	}
+	for _, m := range []Money{New(1299, "USD"), New(-5, "EUR"), New(1000, "JPY")} {
+		data, err := bson.Marshal(doc{Total: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got doc
+		if err := bson.Unmarshal(data, &got); err != nil || got.Total != m {
+			t.Errorf("round trip of %+v = %+v, %v", m, got.Total, err)
+		}
+		if stored := bson.Raw(data).Lookup("total", "amount"); stored.Int64() != m.Amount {
+			t.Errorf("stored amount = %v, want %d minor units", stored, m.Amount)
+		}
+	}
+
+	// Documents written before amounts were money hold plain numbers
+	tests := []struct {
+		name   string
+		stored bson.M
+		want   Money
+	}{
+		{name: "double", stored: bson.M{"total": 29.48}, want: New(2948, DefaultCurrency)},
+		{name: "double rounded", stored: bson.M{"total": 0.125}, want: New(13, DefaultCurrency)},
+		{name: "int32", stored: bson.M{"total": int32(20)}, want: New(2000, DefaultCurrency)},
+		{name: "int64", stored: bson.M{"total": int64(20)}, want: New(2000, DefaultCurrency)},
+		{name: "null", stored: bson.M{"total": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bson.Marshal(tt.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got doc
+			if err := bson.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Total != tt.want {
+				t.Errorf("decoded %+v, want %+v", got.Total, tt.want)
+			}
+		})
+	}
+}
+
+func TestLegacyJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "order",
+			data: `{"total_amount":{"amount":"29.48","currency":"USD"},"items":[{"name":"Beans","price":{"amount":"12.99","currency":"USD"}}],"status":"pending"}`,
+			want: `{"total_amount":29.48,"items":[{"name":"Beans","price":12.99}],"status":"pending"}`,
+		},
+		{name: "no minor unit", data: `{"amount":"1000","currency":"JPY"}`, want: `1000`},
+		{name: "negative", data: `[{"amount":"-0.05","currency":"EUR"}]`, want: `[-0.05]`},
+		{name: "other fields kept", data: `{"amount":"1.00","currency":"USD","note":"x"}`, want: `{"amount":"1.00","currency":"USD","note":"x"}`},
+		{name: "not an amount", data: `{"amount":"all","currency":"USD"}`, want: `{"amount":"all","currency":"USD"}`},
+		{name: "no money", data: `{"a":[1,"two",null,true,{}],"b":[]}`, want: `{"a":[1,"two",null,true,{}],"b":[]}`},
+		{name: "escaped keys", data: `{"\u003ckey\u003e":"\"v\""}`, want: `{"\u003ckey\u003e":"\"v\""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LegacyJSON([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("LegacyJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LegacyJSON([]byte(`{"a":`)); err == nil {
+		t.Error("LegacyJSON accepted truncated JSON")
+	}
+}
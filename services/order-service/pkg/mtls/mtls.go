@@ -0,0 +1,160 @@
+// Package mtls builds the TLS configurations that mutually authenticate
+// intra-cluster traffic without a service mesh: servers requiring client
+// certificates signed by a CA bundle, and clients presenting their own.
+// Certificates are read again when their files change, so ones rotated on
+// disk, as by cert-manager, are used without a restart.
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ServerConfig returns the configuration of a server presenting the
+// certificate in certFile and keyFile. With clientCAFile, clients must
+// present a certificate signed by one of its CAs, or may present none when
+// requireClientCert is false.
+func ServerConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	pair, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return pair.get(), nil
+		},
+	}
+	if clientCAFile != "" {
+		if cfg.ClientCAs, err = LoadCAs(clientCAFile, false); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
+// ClientConfig returns the configuration of a client trusting the CAs in
+// caFile besides the system's, and presenting the certificate in certFile
+// and keyFile to servers asking for one. Each may be empty.
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		var err error
+		if cfg.RootCAs, err = LoadCAs(caFile, true); err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" {
+		pair, err := loadKeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return pair.get(), nil
+		}
+	}
+	return cfg, nil
+}
+
+// LoadCAs reads the PEM certificates in file into a pool, added to the
+// system's when system is set
+func LoadCAs(file string, system bool) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if system {
+		if pool, err = x509.SystemCertPool(); err != nil {
+			pool = x509.NewCertPool()
+		}
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s contains no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// checkInterval is how often the files of a key pair are checked for
+// changes
+const checkInterval = 10 * time.Second
+
+// keyPair is a certificate and its key, loaded again once the files are
+// modified
+type keyPair struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func loadKeyPair(certFile, keyFile string) (*keyPair, error) {
+	p := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// get returns the certificate, loading it again when its files changed.
+// A pair that fails to load, as when only one file was replaced yet, keeps
+// the previous certificate.
+func (p *keyPair) get() *tls.Certificate {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.checkedAt) < checkInterval {
+		return p.cert
+	}
+	p.checkedAt = time.Now()
+	if modTime, err := p.latestModTime(); err == nil && modTime.After(p.modTime) {
+		if err := p.load(); err != nil {
+			log.Warn().Err(err).Str("cert_file", p.certFile).Msg("Failed to reload certificate, keeping the previous one")
+		} else {
+			log.Info().Str("cert_file", p.certFile).Msg("Certificate reloaded")
+		}
+	}
+	return p.cert
+}
+
+// load reads the pair; the caller holds mu, except when loading it first
+func (p *keyPair) load() error {
+	modTime, err := p.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+	p.cert = &cert
+	p.modTime = modTime
+	p.checkedAt = time.Now()
+	return nil
+}
+
+// latestModTime is when the certificate or the key was last modified
+func (p *keyPair) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
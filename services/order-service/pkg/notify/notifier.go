@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/jobs"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Message is one notification addressed to a user over a channel
+type Message struct {
+	UserID    string
+	Channel   string
+	EventType string
+	OrderID   string
+	Text      string
+}
+
+// Sender delivers messages over one channel
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogSender logs messages instead of sending them; used for channels with
+// no provider configured
+type LogSender struct{}
+
+// Send logs the message
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Info().
+		Str("user_id", msg.UserID).
+		Str("channel", msg.Channel).
+		Str("event_type", msg.EventType).
+		Str("order_id", msg.OrderID).
+		Msg("Notification sent")
+	return nil
+}
+
+// Notifier turns order events into user notifications on every channel the
+// user's preferences allow. It implements events.Publisher so it can sit
+// beside the message bus publisher.
+type Notifier struct {
+	prefs   PreferenceSource
+	senders map[string]Sender
+
+	// Clock decides whether quiet hours are active
+	Clock clock.Clock
+	// Timeout bounds the preference lookup and sends for one event
+	Timeout time.Duration
+	// Jobs, when set, queues notifications as JobNotify jobs fanning out
+	// into one JobSend job per channel, each retried on its own
+	Jobs *jobs.Queue
+}
+
+// Notification job types
+const (
+	// JobNotify turns an order event into a JobSend job per channel its
+	// user's preferences allow
+	JobNotify = "notify.order_event"
+	// JobSend sends one message
+	JobSend = "notify.send"
+)
+
+// notifyJob is the payload of JobNotify
+type notifyJob struct {
+	Event contracts.Event `bson:"event"`
+}
+
+// sendJob is the payload of JobSend
+type sendJob struct {
+	Message Message `bson:"message"`
+}
+
+// NewNotifier returns a notifier reading preferences from prefs. senders
+// maps channels to their provider; channels without one are skipped.
+func NewNotifier(prefs PreferenceSource, senders map[string]Sender) *Notifier {
+	return &Notifier{prefs: prefs, senders: senders, Clock: clock.System{}, Timeout: 10 * time.Second}
+}
+
+// Publish notifies the order's owner in the background, queued as a job
+// when Jobs is set, so notification providers never slow down the order
+// write. Replayed events are ignored: users were already notified the
+// first time. Notes are left by the user themselves, so they are not
+// notified of them.
+func (n *Notifier) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	if headers[events.HeaderReplay] == "true" || event.UserID == "" || event.Type == contracts.EventOrderNoteAdded {
+		return nil
+	}
+	if n.Jobs != nil {
+		_, err := n.Jobs.Enqueue(ctx, JobNotify, notifyJob{Event: event})
+		if err == nil {
+			return nil
+		}
+		log.Ctx(ctx).Error().Err(err).Str("event_id", event.EventID).Msg("Failed to queue notification, notifying in the background")
+	}
+	go n.Notify(event)
+	return nil
+}
+
+// Notify sends event to its user on every allowed channel. If preferences
+// cannot be read nothing is sent, since they could not be honored.
+func (n *Notifier) Notify(event contracts.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.Timeout)
+	defer cancel()
+
+	msgs, err := n.messages(ctx, event)
+	if err != nil {
+		log.Warn().Err(err).Str("user_id", event.UserID).Str("event_type", event.Type).Msg("Skipping notification, preferences unavailable")
+		return
+	}
+	for _, msg := range msgs {
+		if err := n.senders[msg.Channel].Send(ctx, msg); err != nil {
+			log.Error().Err(err).Str("user_id", event.UserID).Str("channel", msg.Channel).Msg("Failed to send notification")
+		}
+	}
+}
+
+// RunNotifyJob is the Handler of JobNotify jobs. Unreadable preferences
+// fail the attempt, to be retried.
+func (n *Notifier) RunNotifyJob(ctx context.Context, job jobs.Job) error {
+	var payload notifyJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, n.Timeout)
+	msgs, err := n.messages(lookupCtx, payload.Event)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("read notification preferences: %w", err)
+	}
+	for _, msg := range msgs {
+		if _, err := n.Jobs.Enqueue(ctx, JobSend, sendJob{Message: msg}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunSendJob is the Handler of JobSend jobs
+func (n *Notifier) RunSendJob(ctx context.Context, job jobs.Job) error {
+	var payload sendJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	sender, ok := n.senders[payload.Message.Channel]
+	if !ok {
+		return jobs.Permanent(fmt.Errorf("no sender for channel %q", payload.Message.Channel))
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, n.Timeout)
+	defer cancel()
+	return sender.Send(sendCtx, payload.Message)
+}
+
+// messages returns the messages of event on every channel its user's
+// preferences allow
+func (n *Notifier) messages(ctx context.Context, event contracts.Event) ([]Message, error) {
+	prefs, err := n.prefs.Preferences(ctx, event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := n.Clock.Now()
+	var msgs []Message
+	for _, channel := range []string{ChannelEmail, ChannelSMS, ChannelPush} {
+		if _, ok := n.senders[channel]; !ok || !prefs.Allows(channel, event.Type, now) {
+			continue
+		}
+		msgs = append(msgs, Message{
+			UserID:    event.UserID,
+			Channel:   channel,
+			EventType: event.Type,
+			OrderID:   event.OrderID,
+			Text:      messageText(event),
+		})
+	}
+	return msgs, nil
+}
+
+func messageText(event contracts.Event) string {
+	switch event.Type {
+	case contracts.EventOrderCreated:
+		return fmt.Sprintf("Your order %s has been placed (%s).", event.OrderID, event.Order.TotalAmount)
+	case contracts.EventOrderStatusChanged:
+		return fmt.Sprintf("Your order %s is now %s.", event.OrderID, event.Order.Status)
+	case contracts.EventOrderItemsChanged:
+		return fmt.Sprintf("Your order %s has been updated (%s).", event.OrderID, event.Order.TotalAmount)
+	case contracts.EventOrderShipmentsChanged:
+		return fmt.Sprintf("A shipment of your order %s has been updated.", event.OrderID)
+	case contracts.EventOrderPriorityChanged:
+		return fmt.Sprintf("Your order %s will be handled as %s.", event.OrderID, event.Order.EffectivePriority())
+	case contracts.EventOrderExpired:
+		return fmt.Sprintf("Your order %s was not completed in time and has been cancelled.", event.OrderID)
+	default:
+		return fmt.Sprintf("Update on your order %s.", event.OrderID)
+	}
+}
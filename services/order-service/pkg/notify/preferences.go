@@ -0,0 +1,164 @@
+// Package notify sends order notifications to users over email, SMS and
+// push, honoring the per-event channel choices and quiet hours each user
+// keeps in their user-service profile.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"order-service/pkg/httpclient"
+)
+
+// Channels a notification can be sent over
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+	ChannelPush  = "push"
+)
+
+// ErrUnknownUser is returned when user-service has no such user
+var ErrUnknownUser = errors.New("unknown user")
+
+// QuietHours is a daily window, in the user's timezone, during which SMS
+// and push notifications are suppressed. Start and End are "HH:MM"; a
+// window with End before Start spans midnight.
+type QuietHours struct {
+	Enabled  bool   `json:"enabled"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// Preferences lists, per channel, the event types a user wants to hear about
+type Preferences struct {
+	Email      []string   `json:"email"`
+	SMS        []string   `json:"sms"`
+	Push       []string   `json:"push"`
+	QuietHours QuietHours `json:"quietHours"`
+}
+
+// Allows reports whether eventType may be sent over channel at now
+func (p Preferences) Allows(channel, eventType string, now time.Time) bool {
+	var events []string
+	switch channel {
+	case ChannelEmail:
+		events = p.Email
+	case ChannelSMS:
+		events = p.SMS
+	case ChannelPush:
+		events = p.Push
+	}
+	if !contains(events, eventType) {
+		return false
+	}
+	return channel == ChannelEmail || !p.QuietHours.Active(now)
+}
+
+// Active reports whether now falls inside the quiet hours. Malformed
+// settings are treated as no quiet hours rather than silencing everything.
+func (q QuietHours) Active(now time.Time) bool {
+	if !q.Enabled {
+		return false
+	}
+	start, okStart := minuteOfDay(q.Start)
+	end, okEnd := minuteOfDay(q.End)
+	if !okStart || !okEnd || start == end {
+		return false
+	}
+
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+func minuteOfDay(clock string) (int, bool) {
+	hh, mm, ok := strings.Cut(clock, ":")
+	if !ok {
+		return 0, false
+	}
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if errH != nil || errM != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// PreferenceSource looks up a user's notification preferences
+type PreferenceSource interface {
+	Preferences(ctx context.Context, userID string) (Preferences, error)
+}
+
+// HeaderInternalToken authenticates service-to-service calls to user-service
+const HeaderInternalToken = "X-Internal-Token"
+
+// UserServicePreferences reads preferences from user-service's internal API
+type UserServicePreferences struct {
+	baseURL string
+	token   string
+	client  *httpclient.Client
+}
+
+// NewUserServicePreferences returns a source for user-service at baseURL,
+// authenticating with the shared internal token
+func NewUserServicePreferences(baseURL, token string) *UserServicePreferences {
+	return &UserServicePreferences{
+		baseURL: baseURL,
+		token:   token,
+		client:  httpclient.New(httpclient.DefaultConfig("user-service")),
+	}
+}
+
+// Preferences fetches userID's preferences
+func (s *UserServicePreferences) Preferences(ctx context.Context, userID string) (Preferences, error) {
+	endpoint := s.baseURL + "/internal/users/" + url.PathEscape(userID) + "/notification-preferences"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Preferences{}, err
+	}
+	req.Header.Set(HeaderInternalToken, s.token)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Preferences{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Preferences{}, ErrUnknownUser
+	case resp.StatusCode != http.StatusOK:
+		return Preferences{}, fmt.Errorf("user-service returned status %d", resp.StatusCode)
+	}
+
+	var prefs Preferences
+	if err := json.NewDecoder(resp.Body).Decode(&prefs); err != nil {
+		return Preferences{}, err
+	}
+	return prefs, nil
+}
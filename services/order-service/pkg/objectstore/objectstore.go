@@ -0,0 +1,146 @@
+// Package objectstore keeps objects in an S3 bucket, or in any store
+// speaking the S3 API with Signature Version 4, such as MinIO or the XML
+// API of Google Cloud Storage with HMAC keys.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"order-service/pkg/awssig"
+	"order-service/pkg/clock"
+	"order-service/pkg/httpclient"
+)
+
+// ErrNotFound is returned for objects the bucket does not hold
+var ErrNotFound = errors.New("object not found")
+
+// S3 is one bucket of an S3-compatible store
+type S3 struct {
+	endpoint string
+	bucket   string
+	region   string
+	// pathStyle addresses the bucket in the path rather than the host, as
+	// custom endpoints want
+	pathStyle bool
+	creds     awssig.Credentials
+	clock     clock.Clock
+	client    *httpclient.Client
+}
+
+// NewS3 returns bucket in region, signing requests with creds. endpoint
+// overrides AWS's regional endpoint, e.g. https://storage.googleapis.com
+// for Cloud Storage, whose region is "auto", or a MinIO server; the bucket
+// is then addressed in the path.
+func NewS3(bucket, region string, creds awssig.Credentials, endpoint string) *S3 {
+	s := &S3{
+		endpoint:  strings.TrimRight(endpoint, "/"),
+		bucket:    bucket,
+		region:    region,
+		pathStyle: endpoint != "",
+		creds:     creds,
+		clock:     clock.System{},
+		client:    httpclient.New(httpclient.DefaultConfig("object-store")),
+	}
+	if !s.pathStyle {
+		s.endpoint = "https://" + bucket + ".s3." + region + ".amazonaws.com"
+	}
+	return s
+}
+
+// Ping checks that the bucket exists and the credentials reach it
+func (s *S3) Ping(ctx context.Context) error {
+	resp, err := s.do(ctx, http.MethodHead, "", nil, "")
+	if err != nil {
+		return err
+	}
+	return failure(resp, http.StatusOK)
+}
+
+// Put stores body under key, replacing any object already there
+func (s *S3) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	return failure(resp, http.StatusOK)
+}
+
+// Get reads the object under key, failing with ErrNotFound if there is none
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// Delete removes the object under key; removing a missing one succeeds
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	return failure(resp, http.StatusNoContent, http.StatusOK)
+}
+
+// url returns the URL of key, or of the bucket for an empty key
+func (s *S3) url(key string) string {
+	path := "/" + key
+	if s.pathStyle {
+		path = "/" + s.bucket + path
+	}
+	return s.endpoint + (&url.URL{Path: path}).EscapedPath()
+}
+
+// do sends a signed request for key
+func (s *S3) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key), reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", awssig.PayloadHash(body))
+	awssig.Sign(req, body, s.region, "s3", s.creds, s.clock.Now())
+	return s.client.Do(req)
+}
+
+// failure closes resp and returns nil when its status is one of ok, and
+// otherwise the error the store reported
+func failure(resp *http.Response, ok ...int) error {
+	defer resp.Body.Close()
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode == http.StatusNotFound && body.Code != "NoSuchBucket" {
+		return ErrNotFound
+	}
+	if body.Code == "" {
+		return fmt.Errorf("object store returned status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("object store returned status %d: %s: %s", resp.StatusCode, body.Code, body.Message)
+}
@@ -0,0 +1,175 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	jsonMarshaler  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler  = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	interfaceType  = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Reflector derives schemas from Go types the way encoding/json renders
+// them. Named structs are added to the document's components once and
+// referenced from then on.
+type Reflector struct {
+	doc   *Document
+	names map[reflect.Type]string
+}
+
+// NewReflector returns a reflector adding schemas to doc
+func NewReflector(doc *Document) *Reflector {
+	return &Reflector{doc: doc, names: map[reflect.Type]string{}}
+}
+
+// Define describes v's type as schema, for types with custom JSON
+// encodings, which cannot be reflected
+func (r *Reflector) Define(v interface{}, schema *Schema) {
+	t := reflect.TypeOf(v)
+	name := r.name(t)
+	r.names[t] = name
+	r.doc.Components.Schemas[name] = schema
+}
+
+// Schema returns the schema of v's type, e.g. Schema(contracts.Order{})
+func (r *Reflector) Schema(v interface{}) *Schema {
+	return r.schema(reflect.TypeOf(v))
+}
+
+// ArrayOf returns the schema of a list of v
+func (r *Reflector) ArrayOf(v interface{}) *Schema {
+	return &Schema{Type: "array", Items: r.Schema(v)}
+}
+
+func (r *Reflector) schema(t reflect.Type) *Schema {
+	if name, ok := r.names[t]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		s := r.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType || t == interfaceType:
+		return &Schema{}
+	case t.Implements(jsonMarshaler) || reflect.PtrTo(t).Implements(jsonMarshaler):
+		// Only an override can say what a custom encoding looks like
+		return &Schema{}
+	case t.Implements(textMarshaler) || reflect.PtrTo(t).Implements(textMarshaler):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return r.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + r.component(t)}
+	}
+	return &Schema{}
+}
+
+// component adds the named struct t to the document and returns its name
+func (r *Reflector) component(t reflect.Type) string {
+	name := r.name(t)
+	// Register first so recursive types refer to themselves
+	r.names[t] = name
+	r.doc.Components.Schemas[name] = &Schema{}
+	*r.doc.Components.Schemas[name] = *r.object(t)
+	return name
+}
+
+// name returns the component name of t: its exported name, prefixed with
+// its package when another type already took it
+func (r *Reflector) name(t reflect.Type) string {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if _, taken := r.doc.Components.Schemas[name]; taken {
+		pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	return name
+}
+
+// object describes a struct's JSON fields, embedded structs included.
+// Fields bound with binding:"required" are required.
+func (r *Reflector) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	r.fields(t, s)
+	return s
+}
+
+func (r *Reflector) fields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				r.fields(ft, s)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := r.schema(f.Type)
+		binding := strings.Split(f.Tag.Get("binding"), ",")
+		for _, rule := range binding {
+			switch {
+			case rule == "required":
+				s.Required = append(s.Required, name)
+			case strings.HasPrefix(rule, "max=") && prop.Type == "string":
+				if n, err := strconv.Atoi(strings.TrimPrefix(rule, "max=")); err == nil {
+					prop.MaxLength = &n
+				}
+			}
+		}
+		s.Properties[name] = prop
+	}
+}
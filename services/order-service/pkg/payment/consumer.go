@@ -0,0 +1,199 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"order-service/pkg/events"
+	"order-service/pkg/requestid"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog/log"
+)
+
+// EventConfirmed is the type of the event the payment service publishes
+// once a payment is confirmed
+const EventConfirmed = "payments.confirmed"
+
+// ErrPermanent marks a handler error that retrying cannot fix; the message
+// is dead-lettered instead of redelivered
+var ErrPermanent = errors.New("permanent failure")
+
+// Event is a payment event as published by the payment service
+type Event struct {
+	EventID   string    `json:"event_id"`
+	Type      string    `json:"type"`
+	PaymentID string    `json:"payment_id"`
+	OrderID   string    `json:"order_id"`
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	Status    string    `json:"status"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Confirmer applies a payment confirmation to its order. It must be safe
+// to call again for a confirmation already applied, as RabbitMQ redelivers
+// messages that were not acknowledged. Errors wrapping ErrPermanent
+// dead-letter the message; others have it redelivered.
+type Confirmer interface {
+	ConfirmPayment(ctx context.Context, event Event) error
+}
+
+// ConsumerConfig configures a Consumer
+type ConsumerConfig struct {
+	// URL is the amqp:// or amqps:// address of the broker
+	URL string
+	// Queue is the durable queue confirmations are read from
+	Queue string
+	// Prefetch bounds the messages delivered but not yet acknowledged
+	Prefetch int
+	// RetryDelay is waited before a message whose handling failed is
+	// handed back for redelivery, and between reconnection attempts
+	RetryDelay time.Duration
+}
+
+// Consumer reads payment confirmations from a RabbitMQ queue. Malformed
+// messages and those failing permanently are rejected to the queue's
+// dead-letter exchange, Queue + ".dlx", which routes them to the durable
+// queue Queue + ".dead" for inspection.
+type Consumer struct {
+	cfg     ConsumerConfig
+	handler Confirmer
+}
+
+// NewConsumer returns a consumer handing confirmations to handler
+func NewConsumer(cfg ConsumerConfig, handler Confirmer) *Consumer {
+	return &Consumer{cfg: cfg, handler: handler}
+}
+
+// Run consumes until ctx is cancelled, reconnecting after connection
+// failures
+func (c *Consumer) Run(ctx context.Context) {
+	for {
+		err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error().Err(err).Str("queue", c.cfg.Queue).Msg("Payment event consumer disconnected")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.cfg.RetryDelay):
+		}
+	}
+}
+
+// consume declares the queues and handles deliveries until the connection
+// fails or ctx is cancelled
+func (c *Consumer) consume(ctx context.Context) error {
+	conn, err := amqp.Dial(c.cfg.URL)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close()
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("open channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := c.declare(ch); err != nil {
+		return err
+	}
+	if err := ch.Qos(c.cfg.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("set prefetch: %w", err)
+	}
+	deliveries, err := ch.Consume(c.cfg.Queue, "order-service", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("consume: %w", err)
+	}
+	log.Info().Str("queue", c.cfg.Queue).Msg("Consuming payment events")
+
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-closed:
+			return fmt.Errorf("connection closed: %v", err)
+		case d, ok := <-deliveries:
+			if !ok {
+				return errors.New("delivery channel closed")
+			}
+			c.handle(ctx, d)
+		}
+	}
+}
+
+// declare creates the queue and its dead-letter route if they do not exist
+func (c *Consumer) declare(ch *amqp.Channel) error {
+	dlx, dead := c.cfg.Queue+".dlx", c.cfg.Queue+".dead"
+	if err := ch.ExchangeDeclare(dlx, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter exchange: %w", err)
+	}
+	if _, err := ch.QueueDeclare(dead, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("declare dead-letter queue: %w", err)
+	}
+	if err := ch.QueueBind(dead, "", dlx, false, nil); err != nil {
+		return fmt.Errorf("bind dead-letter queue: %w", err)
+	}
+	args := amqp.Table{"x-dead-letter-exchange": dlx}
+	if _, err := ch.QueueDeclare(c.cfg.Queue, true, false, false, false, args); err != nil {
+		return fmt.Errorf("declare queue: %w", err)
+	}
+	return nil
+}
+
+// handle applies one delivery and settles it: acknowledged once applied,
+// rejected to the dead-letter exchange when malformed or failing
+// permanently, and requeued after RetryDelay otherwise. The request ID the
+// payment service sent in the x-request-id header carries on to the
+// order's events.
+func (c *Consumer) handle(ctx context.Context, d amqp.Delivery) {
+	if id, _ := d.Headers[events.HeaderRequestID].(string); requestid.Valid(id) {
+		ctx = requestid.NewContext(ctx, id)
+	}
+	event, err := decodeConfirmation(d.Body)
+	if err == nil {
+		err = c.handler.ConfirmPayment(ctx, event)
+	}
+
+	logger := log.Ctx(ctx).With().Str("message_id", d.MessageId).Str("order_id", event.OrderID).Str("payment_id", event.PaymentID).Logger()
+	switch {
+	case err == nil:
+		d.Ack(false)
+	case errors.Is(err, ErrPermanent):
+		logger.Warn().Err(err).Msg("Dead-lettering payment event")
+		d.Nack(false, false)
+	default:
+		logger.Error().Err(err).Msg("Failed to handle payment event, requeueing")
+		select {
+		case <-ctx.Done():
+		case <-time.After(c.cfg.RetryDelay):
+		}
+		d.Nack(false, true)
+	}
+}
+
+// decodeConfirmation parses a payments.confirmed message. Anything else is
+// a permanent error.
+func decodeConfirmation(body []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return event, fmt.Errorf("%w: malformed message: %v", ErrPermanent, err)
+	}
+	switch {
+	case event.Type != "" && event.Type != EventConfirmed:
+		return event, fmt.Errorf("%w: unexpected event type %q", ErrPermanent, event.Type)
+	case event.OrderID == "":
+		return event, fmt.Errorf("%w: order_id is missing", ErrPermanent)
+	case event.PaymentID == "":
+		return event, fmt.Errorf("%w: payment_id is missing", ErrPermanent)
+	}
+	return event, nil
+}
@@ -0,0 +1,191 @@
+// Package payment authorizes, voids and refunds order payments through the
+// payment service API. Amounts on the wire are integer minor units of their
+// currency, such as cents, so none are lost to float rounding.
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"order-service/pkg/httpclient"
+	"order-service/pkg/money"
+)
+
+// Payment statuses
+const (
+	StatusAuthorized        = "authorized"
+	StatusDeclined          = "declined"
+	StatusVoided            = "voided"
+	StatusCaptured          = "captured"
+	StatusPartiallyRefunded = "partially_refunded"
+	StatusRefunded          = "refunded"
+)
+
+var (
+	// ErrDeclined is returned when the payment provider refuses an
+	// authorization
+	ErrDeclined = errors.New("payment declined")
+	// ErrRefundDeclined is returned when a refund is refused, by the
+	// provider or because the payment cannot be refunded
+	ErrRefundDeclined = errors.New("refund declined")
+)
+
+// Payment is a payment as returned by the payment service
+type Payment struct {
+	PaymentID string `json:"payment_id"`
+	OrderID   string `json:"order_id"`
+	// Amount is in minor units of Currency
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+	// RefundedAmount is the total refunded so far, in minor units
+	RefundedAmount int64 `json:"refunded_amount,omitempty"`
+}
+
+// Total returns the amount of the payment
+func (p Payment) Total() money.Money {
+	return money.New(p.Amount, p.Currency)
+}
+
+// Refund is the outcome of a refund: the payment after it and the amount
+// returned, which the provider may cap below the amount asked for
+type Refund struct {
+	Payment Payment `json:"payment"`
+	// RefundedAmount is in minor units of the payment's currency
+	RefundedAmount int64 `json:"refunded_amount"`
+}
+
+// Refunded returns the amount returned by the refund
+func (r Refund) Refunded() money.Money {
+	return money.New(r.RefundedAmount, r.Payment.Currency)
+}
+
+// Client calls the payment service
+type Client struct {
+	baseURL string
+	client  *httpclient.Client
+}
+
+// NewClient returns a client for the payment service at baseURL
+func NewClient(baseURL string) *Client {
+	return &Client{baseURL: baseURL, client: httpclient.New(httpclient.DefaultConfig("payment-service"))}
+}
+
+// Authorize reserves amount on the customer's payment method for the
+// order. Retries with the same key return the first attempt's payment
+// instead of authorizing again. A refused authorization returns the
+// declined payment and an error wrapping ErrDeclined.
+func (c *Client) Authorize(ctx context.Context, orderID string, amount money.Money, key string) (Payment, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"order_id": orderID,
+		"amount":   amount.Amount,
+		"currency": amount.Currency,
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/payments/authorize", bytes.NewReader(data))
+	if err != nil {
+		return Payment{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	var payment Payment
+	resp, err := c.do(req, &payment, http.StatusCreated, http.StatusPaymentRequired)
+	if err != nil {
+		return payment, err
+	}
+	if resp.StatusCode == http.StatusPaymentRequired {
+		return payment, fmt.Errorf("%w: %s", ErrDeclined, payment.DeclineReason)
+	}
+	return payment, nil
+}
+
+// Void cancels an authorization that has not been captured. Voiding a
+// voided payment succeeds.
+func (c *Client) Void(ctx context.Context, paymentID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/payments/"+url.PathEscape(paymentID)+"/void", nil)
+	if err != nil {
+		return err
+	}
+	// Voiding is idempotent, so it may be retried like any keyed request
+	req.Header.Set("Idempotency-Key", "void-"+paymentID)
+	_, err = c.do(req, nil, http.StatusOK)
+	return err
+}
+
+// Refund returns amount of a captured payment to the customer. Retries
+// with the same key refund once. A refused refund returns an error
+// wrapping ErrRefundDeclined.
+func (c *Client) Refund(ctx context.Context, paymentID string, amount money.Money, key string) (Refund, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"amount":   amount.Amount,
+		"currency": amount.Currency,
+	})
+	if err != nil {
+		return Refund{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/payments/"+url.PathEscape(paymentID)+"/refund", bytes.NewReader(data))
+	if err != nil {
+		return Refund{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	var refund Refund
+	resp, err := c.do(req, &refund, http.StatusOK, http.StatusConflict, http.StatusUnprocessableEntity)
+	if err != nil {
+		return refund, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return refund, fmt.Errorf("%w: payment service returned status %d", ErrRefundDeclined, resp.StatusCode)
+	}
+	return refund, nil
+}
+
+// ForOrder returns every payment made for the order, oldest first
+func (c *Client) ForOrder(ctx context.Context, orderID string) ([]Payment, error) {
+	resp, err := c.client.Get(ctx, c.baseURL+"/api/payments?order_id="+url.QueryEscape(orderID))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("payment service returned status %d", resp.StatusCode)
+	}
+	var payments []Payment
+	if err := json.NewDecoder(resp.Body).Decode(&payments); err != nil {
+		return nil, fmt.Errorf("decode payments: %w", err)
+	}
+	return payments, nil
+}
+
+// do sends req and decodes the body into v when the response has one of
+// the accepted statuses
+func (c *Client) do(req *http.Request, v interface{}, accepted ...int) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range accepted {
+		if resp.StatusCode != status {
+			continue
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				return resp, fmt.Errorf("decode payment: %w", err)
+			}
+		}
+		return resp, nil
+	}
+	return resp, fmt.Errorf("payment service returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,71 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"order-service/pkg/money"
+)
+
+// recordingServer answers every call with status and response, recording
+// the body it was sent
+func recordingServer(t *testing.T, status int, response interface{}) (*Client, *map[string]interface{}) {
+	t.Helper()
+	sent := map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL), &sent
+}
+
+func TestAuthorizeSendsMinorUnits(t *testing.T) {
+	client, sent := recordingServer(t, http.StatusCreated, Payment{PaymentID: "p-1", Amount: 2948, Currency: "USD", Status: StatusAuthorized})
+
+	paid, err := client.Authorize(context.Background(), "order-1", money.New(2948, "USD"), "key-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*sent)["amount"] != float64(2948) || (*sent)["currency"] != "USD" {
+		t.Errorf("sent %v, want 2948 USD minor units", *sent)
+	}
+	if paid.Total() != money.New(2948, "USD") {
+		t.Errorf("payment total = %+v", paid.Total())
+	}
+}
+
+func TestRefundSendsMinorUnits(t *testing.T) {
+	tests := []struct {
+		amount money.Money
+		want   float64
+	}{
+		{amount: money.New(1299, "EUR"), want: 1299},
+		{amount: money.New(1000, "JPY"), want: 1000},
+		{amount: money.New(1234, "KWD"), want: 1234},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount.Currency, func(t *testing.T) {
+			response := Refund{Payment: Payment{PaymentID: "p-1", Currency: tt.amount.Currency}, RefundedAmount: tt.amount.Amount}
+			client, sent := recordingServer(t, http.StatusOK, response)
+
+			refund, err := client.Refund(context.Background(), "p-1", tt.amount, "refund-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (*sent)["amount"] != tt.want || (*sent)["currency"] != tt.amount.Currency {
+				t.Errorf("sent %v, want %v %s", *sent, tt.want, tt.amount.Currency)
+			}
+			if refund.Refunded() != tt.amount {
+				t.Errorf("refunded %+v, want %+v", refund.Refunded(), tt.amount)
+			}
+		})
+	}
+}
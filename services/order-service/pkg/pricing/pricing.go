@@ -0,0 +1,106 @@
+// Package pricing computes what an order costs on top of its items: tax and
+// shipping. The order service asks a Calculator for them whenever an
+// order's items are set, and the grand total follows from the subtotal,
+// these charges and the discount of any promotion applied to the order.
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+)
+
+// Calculator computes the charges on an order whose items, subtotal and
+// promotion discount are set. Charges left unset are zero; the discount is
+// owned by the order's promotion, so any Discount returned is ignored.
+type Calculator interface {
+	Charges(ctx context.Context, order contracts.Order) (contracts.Charges, error)
+}
+
+// None charges nothing, so the total is the subtotal
+type None struct{}
+
+// Charges returns zero charges
+func (None) Charges(ctx context.Context, order contracts.Order) (contracts.Charges, error) {
+	return contracts.NoCharges(order.Subtotal.Currency), nil
+}
+
+// Standard charges a flat tax rate on the discounted subtotal and a flat
+// shipping fee per currency, waived from a per-currency subtotal on.
+type Standard struct {
+	// TaxRate is in basis points, e.g. 825 for 8.25%
+	TaxRate int64
+	// ShippingFees is the fee per currency; orders in other currencies ship
+	// free
+	ShippingFees map[string]money.Money
+	// FreeShippingFrom is the subtotal per currency from which shipping is
+	// free
+	FreeShippingFrom map[string]money.Money
+}
+
+// Charges returns the tax and shipping on the order
+func (s Standard) Charges(ctx context.Context, order contracts.Order) (contracts.Charges, error) {
+	subtotal := order.Subtotal
+	charges := contracts.NoCharges(subtotal.Currency)
+	taxable := subtotal
+	if order.DiscountAmount.Currency == subtotal.Currency {
+		taxable.Amount -= order.DiscountAmount.Amount
+	}
+	charges.Tax = taxable.MulRatio(s.TaxRate, 10000)
+
+	if fee, ok := s.ShippingFees[subtotal.Currency]; ok {
+		free, ok := s.FreeShippingFrom[subtotal.Currency]
+		if !ok || subtotal.Amount < free.Amount {
+			charges.Shipping = fee
+		}
+	}
+	return charges, nil
+}
+
+// ParseRate reads a percentage with up to two decimals, such as "8.25", as
+// basis points
+func ParseRate(percent string) (int64, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(percent), ".")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("invalid rate %q: at most two decimals", percent)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	bps, err := strconv.ParseUint(whole+frac, 10, 32)
+	if err != nil || whole == "" {
+		return 0, fmt.Errorf("invalid rate %q", percent)
+	}
+	return int64(bps), nil
+}
+
+// ParseAmounts reads a comma-separated list of amounts per currency, such
+// as "USD=4.99,EUR=4.50"
+func ParseAmounts(spec string) (map[string]money.Money, error) {
+	amounts := map[string]money.Money{}
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		code, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid amount %q, expected CODE=amount", pair)
+		}
+		code, err := money.NormalizeCurrency(code)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := money.Parse(strings.TrimSpace(value), code)
+		if err != nil {
+			return nil, err
+		}
+		if amount.IsNegative() {
+			return nil, fmt.Errorf("invalid amount %q: negative", pair)
+		}
+		amounts[code] = amount
+	}
+	return amounts, nil
+}
@@ -0,0 +1,193 @@
+// Package privacy serves the requests of data subjects: erasing the
+// personal data held on a user across all their orders, wherever they are
+// kept, and exporting all of it. Erasure anonymizes rather than deletes, so
+// amounts, items and statuses still add up in aggregates. Requests are
+// served in the tenant of their context, like the order repository's calls.
+package privacy
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"order-service/pkg/archive"
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/retention"
+	"order-service/pkg/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrErasureDisabled is returned by Erase without anonymizers, which need a
+// key to tokenize with
+var ErrErasureDisabled = errors.New("erasure is not configured: ANONYMIZATION_KEY is not set")
+
+// Export is all the order data held on one user
+type Export struct {
+	UserID string `json:"user_id"`
+	// TenantID is the tenant exported; empty for every tenant
+	TenantID    string    `json:"tenant_id,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Orders are the user's orders, live, archived or in cold storage,
+	// deleted ones included, oldest first
+	Orders []contracts.Order `json:"orders"`
+}
+
+// Erasure reports what erasing a user's data changed
+type Erasure struct {
+	UserID string `json:"user_id"`
+	// TenantID is the tenant erased; empty for every tenant
+	TenantID string `json:"tenant_id,omitempty"`
+	// Orders counts the orders anonymized
+	Orders int `json:"orders"`
+	// Thawed counts the orders read back from cold storage to be
+	// anonymized, which the next export takes out again
+	Thawed int `json:"thawed"`
+	// Copies counts documents modified per copy collection, such as stored
+	// events and read models
+	Copies map[string]int64 `json:"copies"`
+	// Runs are the IDs of the anonymization runs stored, one per orders
+	// collection
+	Runs []string `json:"runs"`
+}
+
+// Service erases and exports the data of users
+type Service struct {
+	collections []*mongo.Collection
+	anonymizers []*retention.Anonymizer
+
+	// Cold holds exported orders, which Erase first thaws into ThawInto;
+	// nil without cold storage
+	Cold     *archive.ColdStorage
+	ThawInto *mongo.Collection
+	// Tenants serve the tenants whose orders are kept apart, in a database
+	// or collection of their own, by tenant ID. Other tenants' requests are
+	// served from this service's collections, filtered by tenant; requests
+	// without a tenant cover this service and every one of Tenants.
+	Tenants map[string]*Service
+	Clock   clock.Clock
+}
+
+// NewService returns the service for the orders of collections, such as
+// the live orders and the archive, erasing them with anonymizers, one per
+// collection. Without anonymizers only exports are served.
+func NewService(collections []*mongo.Collection, anonymizers ...*retention.Anonymizer) *Service {
+	return &Service{collections: collections, anonymizers: anonymizers, Clock: clock.System{}}
+}
+
+// services returns the services holding the orders of the tenant of ctx
+func (s *Service) services(ctx context.Context) []*Service {
+	id := tenant.FromContext(ctx)
+	if dedicated, ok := s.Tenants[id]; ok {
+		return []*Service{dedicated}
+	}
+	services := []*Service{s}
+	if id == "" {
+		ids := make([]string, 0, len(s.Tenants))
+		for id := range s.Tenants {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			services = append(services, s.Tenants[id])
+		}
+	}
+	return services
+}
+
+// userFilter matches the orders of userID in the tenant of ctx, or in
+// every tenant without one
+func userFilter(ctx context.Context, userID string) bson.M {
+	filter := bson.M{"user_id": userID}
+	if id := tenant.FromContext(ctx); id != "" {
+		filter["tenant_id"] = id
+	}
+	return filter
+}
+
+// Erase anonymizes the personal data on every order of userID in the
+// tenant of ctx and on its copies. Orders in cold storage are thawed first.
+// A failure leaves the orders done so far anonymized; erasing again carries
+// on with the rest.
+func (s *Service) Erase(ctx context.Context, userID string) (Erasure, error) {
+	erasure := Erasure{UserID: userID, TenantID: tenant.FromContext(ctx), Copies: map[string]int64{}, Runs: []string{}}
+	for _, service := range s.services(ctx) {
+		if err := service.erase(ctx, userID, &erasure); err != nil {
+			return erasure, err
+		}
+	}
+	return erasure, nil
+}
+
+// erase anonymizes the orders of userID in this service's collections
+func (s *Service) erase(ctx context.Context, userID string, erasure *Erasure) error {
+	if len(s.anonymizers) == 0 {
+		return ErrErasureDisabled
+	}
+	if s.Cold != nil {
+		thawed, err := s.Cold.Thaw(ctx, userID, s.ThawInto)
+		erasure.Thawed += thawed
+		if err != nil {
+			return err
+		}
+	}
+	for _, anonymizer := range s.anonymizers {
+		summary, err := anonymizer.EraseUser(ctx, userID)
+		erasure.Orders += summary.Orders
+		for name, n := range summary.Copies {
+			erasure.Copies[name] += n
+		}
+		if !summary.ID.IsZero() {
+			erasure.Runs = append(erasure.Runs, summary.ID.Hex())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export gathers every order of userID in the tenant of ctx, including
+// deleted ones
+func (s *Service) Export(ctx context.Context, userID string) (Export, error) {
+	export := Export{UserID: userID, TenantID: tenant.FromContext(ctx), GeneratedAt: s.Clock.Now(), Orders: []contracts.Order{}}
+	for _, service := range s.services(ctx) {
+		orders, err := service.export(ctx, userID)
+		if err != nil {
+			return Export{}, err
+		}
+		export.Orders = append(export.Orders, orders...)
+	}
+	sort.SliceStable(export.Orders, func(i, j int) bool {
+		return export.Orders[i].CreatedAt.Before(export.Orders[j].CreatedAt)
+	})
+	return export, nil
+}
+
+// export reads the orders of userID from this service's collections
+func (s *Service) export(ctx context.Context, userID string) ([]contracts.Order, error) {
+	var all []contracts.Order
+	for _, collection := range s.collections {
+		cursor, err := collection.Find(ctx, userFilter(ctx, userID), options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		var orders []contracts.Order
+		if err := cursor.All(ctx, &orders); err != nil {
+			return nil, err
+		}
+		all = append(all, orders...)
+	}
+	if s.Cold != nil {
+		orders, err := s.Cold.FindByUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, orders...)
+	}
+	return all, nil
+}
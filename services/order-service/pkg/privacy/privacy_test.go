@@ -0,0 +1,130 @@
+package privacy_test
+
+import (
+	"context"
+	"testing"
+
+	"order-service/pkg/privacy"
+	"order-service/pkg/retention"
+	"order-service/pkg/tenant"
+	fixtures "order-service/pkg/testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+// newService returns the service of the shared orders collection, with
+// globex kept apart in dedicated
+func newService(shared, dedicated, runs *mongo.Collection) *privacy.Service {
+	service := privacy.NewService([]*mongo.Collection{shared}, retention.NewAnonymizer(shared, runs, key, 0))
+	service.Tenants = map[string]*privacy.Service{
+		"globex": privacy.NewService([]*mongo.Collection{dedicated}, retention.NewAnonymizer(dedicated, runs, key, 0)),
+	}
+	return service
+}
+
+func insert(t *testing.T, orders *mongo.Collection, tenantID string) {
+	t.Helper()
+	order := fixtures.NewOrder().WithUser("user-1").Build()
+	order.TenantID = tenantID
+	if _, err := orders.InsertOne(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// remaining counts the orders of user-1 not yet anonymized in orders
+func remaining(t *testing.T, orders *mongo.Collection, tenantID string) int64 {
+	t.Helper()
+	filter := bson.M{"user_id": "user-1", "tenant_id": tenantID}
+	if tenantID == "" {
+		filter["tenant_id"] = bson.M{"$exists": false}
+	}
+	n, err := orders.CountDocuments(context.Background(), filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestEraseInTenant(t *testing.T) {
+	db := fixtures.MongoDatabase(t)
+	// globex has a database of its own
+	shared, dedicated := db.Collection("orders"), fixtures.MongoDatabase(t).Collection("orders")
+	service := newService(shared, dedicated, db.Collection("anonymization_runs"))
+
+	insert(t, shared, "acme")
+	insert(t, shared, "initech")
+	insert(t, shared, "")
+	insert(t, dedicated, "globex")
+
+	erasure, err := service.Erase(tenant.WithTenant(context.Background(), "globex"), "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erasure.Orders != 1 || erasure.TenantID != "globex" {
+		t.Errorf("globex erasure = %+v, want 1 order", erasure)
+	}
+	if n := remaining(t, dedicated, "globex"); n != 0 {
+		t.Errorf("%d globex orders left in its own store", n)
+	}
+
+	erasure, err = service.Erase(tenant.WithTenant(context.Background(), "acme"), "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erasure.Orders != 1 {
+		t.Errorf("acme erasure = %+v, want 1 order", erasure)
+	}
+	for tenantID, want := range map[string]int64{"acme": 0, "initech": 1, "": 1} {
+		if n := remaining(t, shared, tenantID); n != want {
+			t.Errorf("%d orders of %q left in the shared store, want %d", n, tenantID, want)
+		}
+	}
+}
+
+func TestExportInTenant(t *testing.T) {
+	db := fixtures.MongoDatabase(t)
+	// globex has a database of its own
+	shared, dedicated := db.Collection("orders"), fixtures.MongoDatabase(t).Collection("orders")
+	service := newService(shared, dedicated, db.Collection("anonymization_runs"))
+
+	insert(t, shared, "acme")
+	insert(t, shared, "")
+	insert(t, dedicated, "globex")
+
+	tests := []struct {
+		tenant string
+		want   []string
+	}{
+		{tenant: "acme", want: []string{"acme"}},
+		{tenant: "globex", want: []string{"globex"}},
+		{tenant: "initech", want: []string{}},
+		{tenant: "", want: []string{"acme", "", "globex"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tenant, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.tenant != "" {
+				ctx = tenant.WithTenant(ctx, tt.tenant)
+			}
+			export, err := service.Export(ctx, "user-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]bool{}
+			for _, order := range export.Orders {
+				got[order.TenantID] = true
+			}
+			if len(export.Orders) != len(tt.want) {
+				t.Fatalf("exported %d orders, want %d", len(export.Orders), len(tt.want))
+			}
+			for _, id := range tt.want {
+				if !got[id] {
+					t.Errorf("no order of tenant %q exported", id)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package projection
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/httpclient"
+	"order-service/pkg/money"
+)
+
+// ErrProductNotFound is returned when the catalog has no such product
+var ErrProductNotFound = errors.New("product not found")
+
+// ProductDetails is the catalog data denormalized onto order views
+type ProductDetails struct {
+	ID       string  `json:"id" bson:"id"`
+	Name     string  `json:"name" bson:"name"`
+	SKU      string  `json:"sku" bson:"sku"`
+	Category string  `json:"category" bson:"category"`
+	Price    float64 `json:"price" bson:"price"`
+	// Currency of Price; product-service predates it, so empty means USD
+	Currency string `json:"currency,omitempty" bson:"currency,omitempty"`
+	// Active is false for products withdrawn from sale; older
+	// product-service versions omit it, which means active
+	Active *bool `json:"active,omitempty" bson:"-"`
+}
+
+// Purchasable reports whether the product can be put on an order
+func (p ProductDetails) Purchasable() bool {
+	return p.Active == nil || *p.Active
+}
+
+// UnitPrice returns Price as exact money in the product's currency
+func (p ProductDetails) UnitPrice() (money.Money, error) {
+	currency := p.Currency
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	code, err := money.NormalizeCurrency(currency)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.FromFloat(p.Price, code), nil
+}
+
+// Catalog looks up product details
+type Catalog interface {
+	Product(ctx context.Context, productID string) (ProductDetails, error)
+}
+
+type cachedProduct struct {
+	product ProductDetails
+	expires time.Time
+}
+
+// HTTPCatalog reads products from product-service and caches them in memory
+type HTTPCatalog struct {
+	baseURL string
+	client  *httpclient.Client
+	ttl     time.Duration
+
+	// Clock decides when cached products expire
+	Clock clock.Clock
+
+	mu    sync.Mutex
+	cache map[string]cachedProduct
+}
+
+// NewHTTPCatalog returns a catalog client for product-service at baseURL
+func NewHTTPCatalog(baseURL string, ttl time.Duration) *HTTPCatalog {
+	return &HTTPCatalog{
+		baseURL: baseURL,
+		client:  httpclient.New(httpclient.DefaultConfig("product-service")),
+		ttl:     ttl,
+		Clock:   clock.System{},
+		cache:   map[string]cachedProduct{},
+	}
+}
+
+// Ping checks that product-service answers its health check
+func (c *HTTPCatalog) Ping(ctx context.Context) error {
+	resp, err := c.client.Get(ctx, c.baseURL+"/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("product-service health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Product returns the product, serving from cache while fresh
+func (c *HTTPCatalog) Product(ctx context.Context, productID string) (ProductDetails, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[productID]
+	c.mu.Unlock()
+	if ok && c.Clock.Now().Before(cached.expires) {
+		return cached.product, nil
+	}
+
+	resp, err := c.client.Get(ctx, c.baseURL+"/api/products/"+productID)
+	if err != nil {
+		return ProductDetails{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusBadRequest:
+		return ProductDetails{}, ErrProductNotFound
+	case resp.StatusCode != http.StatusOK:
+		return ProductDetails{}, fmt.Errorf("product-service returned status %d", resp.StatusCode)
+	}
+
+	var product ProductDetails
+	if err := json.NewDecoder(resp.Body).Decode(&product); err != nil {
+		return ProductDetails{}, err
+	}
+
+	c.mu.Lock()
+	c.cache[productID] = cachedProduct{product: product, expires: c.Clock.Now().Add(c.ttl)}
+	c.mu.Unlock()
+
+	return product, nil
+}
@@ -0,0 +1,311 @@
+// Package projection maintains denormalized read models built from order
+// events, so read-heavy queries never touch the write path.
+package projection
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/money"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Read model collection names
+const (
+	OrderViewsCollection     = "order_views"
+	UserSummariesCollection  = "user_order_summaries"
+	CheckpointsCollection    = "projection_checkpoints"
+	orderProjectorCheckpoint = "order-projector"
+)
+
+// ItemView is an order line with catalog details attached
+type ItemView struct {
+	contracts.OrderItem `bson:",inline"`
+	Product             *ProductDetails `json:"product,omitempty" bson:"product,omitempty"`
+}
+
+// OrderView is the order-with-product-details read model
+type OrderView struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	OrderID     string             `json:"order_id" bson:"order_id"`
+	UserID      string             `json:"user_id" bson:"user_id"`
+	TenantID    string             `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	Items       []ItemView         `json:"items" bson:"items"`
+	TotalAmount money.Money        `json:"total_amount" bson:"total_amount"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time          `json:"updated_at" bson:"updated_at"`
+	ProjectedAt time.Time          `json:"projected_at" bson:"projected_at"`
+	// DeletedAt marks a soft-deleted order, which user summaries leave out
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+// UserSummary is the per-user read model, one per tenant the user has
+// ordered in; its _id is UserSummaryID
+type UserSummary struct {
+	UserID     string `json:"user_id" bson:"user_id"`
+	TenantID   string `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	OrderCount int    `json:"order_count" bson:"order_count"`
+	// TotalSpent has one entry per currency the user has ordered in
+	TotalSpent []money.Money `json:"total_spent" bson:"total_spent"`
+	// NormalizedTotal is TotalSpent converted to a single reporting
+	// currency. Rates move, so it is computed when read and never stored.
+	NormalizedTotal *money.Money   `json:"normalized_total,omitempty" bson:"-"`
+	StatusCounts    map[string]int `json:"status_counts" bson:"status_counts"`
+	FirstOrderAt    time.Time      `json:"first_order_at" bson:"first_order_at"`
+	LastOrderAt     time.Time      `json:"last_order_at" bson:"last_order_at"`
+	LastUpdatedAt   time.Time      `json:"last_updated_at" bson:"last_updated_at"`
+}
+
+// UserSummaryID is the _id of userID's summary in tenantID. The same user ID
+// may belong to different users in different tenants, so neither is unique
+// on its own.
+func UserSummaryID(tenantID, userID string) bson.D {
+	return bson.D{{Key: "tenant_id", Value: tenantID}, {Key: "user_id", Value: userID}}
+}
+
+type checkpoint struct {
+	Name      string             `bson:"_id"`
+	Position  primitive.ObjectID `bson:"position"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+// Indexer keeps a copy of every order outside MongoDB, such as a search
+// index. Put must ignore snapshots older than the copy it has.
+type Indexer interface {
+	EnsureIndex(ctx context.Context) error
+	Put(ctx context.Context, order contracts.Order) error
+}
+
+// Projector consumes stored order events and keeps the read models current.
+// Both read models are rebuilt from the latest order snapshot on every event,
+// so reprocessing (e.g. after a replay or checkpoint reset) is idempotent.
+type Projector struct {
+	store       *events.Store
+	catalog     Catalog
+	views       *mongo.Collection
+	summaries   *mongo.Collection
+	checkpoints *mongo.Collection
+
+	BatchSize int64
+	Settle    time.Duration
+	Clock     clock.Clock
+	// Search is also given the order of every event; nil keeps none
+	Search Indexer
+}
+
+// NewProjector returns a projector reading from store and writing read
+// models into db
+func NewProjector(store *events.Store, catalog Catalog, db *mongo.Database) *Projector {
+	return &Projector{
+		store:       store,
+		catalog:     catalog,
+		views:       db.Collection(OrderViewsCollection),
+		summaries:   db.Collection(UserSummariesCollection),
+		checkpoints: db.Collection(CheckpointsCollection),
+		BatchSize:   200,
+		Settle:      2 * time.Second,
+		Clock:       clock.System{},
+	}
+}
+
+// EnsureIndexes creates the indexes read queries rely on, and the search
+// index
+func (p *Projector) EnsureIndexes(ctx context.Context) error {
+	_, err := p.views.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "order_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil || p.Search == nil {
+		return err
+	}
+	return p.Search.EnsureIndex(ctx)
+}
+
+// Run processes events until ctx is cancelled, polling every interval when idle
+func (p *Projector) Run(ctx context.Context, interval time.Duration) {
+	for {
+		processed, err := p.ProcessBatch(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Projection batch failed")
+		}
+		if processed > 0 && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ProcessBatch projects the next batch of events and advances the checkpoint
+func (p *Projector) ProcessBatch(ctx context.Context) (int, error) {
+	var cp checkpoint
+	err := p.checkpoints.FindOne(ctx, bson.M{"_id": orderProjectorCheckpoint}).Decode(&cp)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return 0, err
+	}
+
+	records, err := p.store.Since(ctx, cp.Position, p.Settle, p.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, record := range records {
+		if err := p.Handle(ctx, record.Event); err != nil {
+			return i, err
+		}
+		if err := p.saveCheckpoint(ctx, record.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(records), nil
+}
+
+// Reset clears the checkpoint so the next run rebuilds every read model. It
+// also drops the user summaries keyed by user ID alone, written before they
+// were kept per tenant, which the rebuild replaces.
+func (p *Projector) Reset(ctx context.Context) error {
+	if _, err := p.summaries.DeleteMany(ctx, bson.M{"_id": bson.M{"$type": "string"}}); err != nil {
+		return err
+	}
+	_, err := p.checkpoints.DeleteOne(ctx, bson.M{"_id": orderProjectorCheckpoint})
+	return err
+}
+
+// Handle applies a single event to the read models
+func (p *Projector) Handle(ctx context.Context, event contracts.Event) error {
+	if err := p.projectOrder(ctx, event.Order); err != nil {
+		return err
+	}
+	if p.Search != nil {
+		if err := p.Search.Put(ctx, event.Order); err != nil {
+			return fmt.Errorf("index order %s: %w", event.Order.OrderID, err)
+		}
+	}
+	return p.projectUserSummary(ctx, event.Order.TenantID, event.UserID)
+}
+
+func (p *Projector) projectOrder(ctx context.Context, order contracts.Order) error {
+	items := make([]ItemView, 0, len(order.Items))
+	for _, item := range order.Items {
+		view := ItemView{OrderItem: item}
+		product, err := p.catalog.Product(ctx, item.ProductID)
+		if err == nil {
+			view.Product = &product
+		} else if err != ErrProductNotFound {
+			// Keep projecting without details; the next event for this
+			// order will fill them in once product-service is reachable
+			log.Warn().Err(err).Str("product_id", item.ProductID).Msg("Product lookup failed during projection")
+		}
+		items = append(items, view)
+	}
+
+	view := OrderView{
+		ID:          order.ID,
+		OrderID:     order.OrderID,
+		UserID:      order.UserID,
+		TenantID:    order.TenantID,
+		Items:       items,
+		TotalAmount: order.TotalAmount,
+		Status:      order.Status,
+		CreatedAt:   order.CreatedAt,
+		UpdatedAt:   order.UpdatedAt,
+		ProjectedAt: p.Clock.Now(),
+		DeletedAt:   order.DeletedAt,
+	}
+
+	// Ignore snapshots older than what is already projected
+	filter := bson.M{"order_id": order.OrderID, "updated_at": bson.M{"$gt": order.UpdatedAt}}
+	if n, err := p.views.CountDocuments(ctx, filter); err != nil || n > 0 {
+		return err
+	}
+
+	_, err := p.views.ReplaceOne(ctx, bson.M{"_id": view.ID}, view, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (p *Projector) projectUserSummary(ctx context.Context, tenantID, userID string) error {
+	// Views without a tenant match a nil tenant_id
+	var tenant interface{}
+	if tenantID != "" {
+		tenant = tenantID
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "tenant_id": tenant, "deleted_at": bson.M{"$exists": false}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            bson.M{"status": "$status", "currency": "$total_amount.currency"},
+			"count":          bson.M{"$sum": 1},
+			"total":          bson.M{"$sum": "$total_amount.amount"},
+			"first_order_at": bson.M{"$min": "$created_at"},
+			"last_order_at":  bson.M{"$max": "$created_at"},
+		}}},
+	}
+
+	cursor, err := p.views.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Key struct {
+			Status   string `bson:"status"`
+			Currency string `bson:"currency"`
+		} `bson:"_id"`
+		Count        int       `bson:"count"`
+		Total        int64     `bson:"total"`
+		FirstOrderAt time.Time `bson:"first_order_at"`
+		LastOrderAt  time.Time `bson:"last_order_at"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	summary := UserSummary{UserID: userID, TenantID: tenantID, TotalSpent: []money.Money{}, StatusCounts: map[string]int{}, LastUpdatedAt: p.Clock.Now()}
+	spent := map[string]int64{}
+	for _, g := range groups {
+		summary.OrderCount += g.Count
+		summary.StatusCounts[g.Key.Status] += g.Count
+		if g.Key.Status != "cancelled" {
+			spent[g.Key.Currency] += g.Total
+		}
+		if summary.FirstOrderAt.IsZero() || g.FirstOrderAt.Before(summary.FirstOrderAt) {
+			summary.FirstOrderAt = g.FirstOrderAt
+		}
+		if g.LastOrderAt.After(summary.LastOrderAt) {
+			summary.LastOrderAt = g.LastOrderAt
+		}
+	}
+	for currency, total := range spent {
+		summary.TotalSpent = append(summary.TotalSpent, money.New(total, currency))
+	}
+	sort.Slice(summary.TotalSpent, func(i, j int) bool {
+		return summary.TotalSpent[i].Currency < summary.TotalSpent[j].Currency
+	})
+
+	_, err = p.summaries.ReplaceOne(ctx, bson.M{"_id": UserSummaryID(tenantID, userID)}, summary, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (p *Projector) saveCheckpoint(ctx context.Context, position primitive.ObjectID) error {
+	_, err := p.checkpoints.ReplaceOne(ctx,
+		bson.M{"_id": orderProjectorCheckpoint},
+		checkpoint{Name: orderProjectorCheckpoint, Position: position, UpdatedAt: p.Clock.Now()},
+		options.Replace().SetUpsert(true),
+	)
+	return err
+}
@@ -0,0 +1,46 @@
+package projection_test
+
+import (
+	"context"
+	"testing"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/projection"
+	fixtures "order-service/pkg/testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestUserSummaryPerTenant(t *testing.T) {
+	db := fixtures.MongoDatabase(t)
+	p := projection.NewProjector(nil, &fixtures.MockCatalog{}, db)
+	ctx := context.Background()
+
+	place := func(tenantID string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			order := fixtures.NewOrder().WithUser("user-1").Build()
+			order.TenantID = tenantID
+			event := contracts.Event{Type: contracts.EventOrderCreated, OrderID: order.OrderID, UserID: order.UserID, Order: order}
+			if err := p.Handle(ctx, event); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	place("acme", 2)
+	place("globex", 1)
+	place("", 3)
+
+	for tenantID, want := range map[string]int{"acme": 2, "globex": 1, "": 3} {
+		var summary projection.UserSummary
+		err := db.Collection(projection.UserSummariesCollection).
+			FindOne(ctx, bson.M{"_id": projection.UserSummaryID(tenantID, "user-1")}).
+			Decode(&summary)
+		if err != nil {
+			t.Fatalf("summary in %q: %v", tenantID, err)
+		}
+		if summary.OrderCount != want || summary.TenantID != tenantID {
+			t.Errorf("summary in %q = %d orders of tenant %q, want %d", tenantID, summary.OrderCount, summary.TenantID, want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package promotion manages promotion codes customers can apply when they
+// place an order: their terms, validity window and usage limit.
+package promotion
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrNotFound is returned for a code that does not exist
+	ErrNotFound = errors.New("promotion not found")
+	// ErrExists is returned when creating a code that is already taken
+	ErrExists = errors.New("promotion code already exists")
+	// ErrNotStarted and ErrExpired are returned outside the validity window
+	ErrNotStarted = errors.New("promotion has not started")
+	ErrExpired    = errors.New("promotion has expired")
+	// ErrExhausted is returned once a promotion reached its usage limit
+	ErrExhausted = errors.New("promotion has reached its usage limit")
+	// ErrBelowMinimum is returned when the order subtotal is below the
+	// promotion's minimum
+	ErrBelowMinimum = errors.New("order subtotal is below the promotion minimum")
+	// ErrCurrency is returned when a fixed discount or minimum is in another
+	// currency than the order
+	ErrCurrency = errors.New("promotion does not apply to orders in this currency")
+)
+
+// Promotion is a code customers can apply to an order
+type Promotion struct {
+	ID   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Code string             `json:"code" bson:"code"`
+	// Type is contracts.PromotionPercentage, taking BasisPoints of the
+	// subtotal off, or contracts.PromotionFixed, taking AmountOff off
+	Type        string       `json:"type" bson:"type"`
+	BasisPoints int64        `json:"basis_points,omitempty" bson:"basis_points,omitempty"`
+	AmountOff   *money.Money `json:"amount_off,omitempty" bson:"amount_off,omitempty"`
+	// MinSubtotal is the smallest subtotal, in its currency, the code
+	// applies to
+	MinSubtotal *money.Money `json:"min_subtotal,omitempty" bson:"min_subtotal,omitempty"`
+	StartsAt    *time.Time   `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	// MaxUses caps how many orders may use the code; 0 is unlimited
+	MaxUses   int       `json:"max_uses,omitempty" bson:"max_uses,omitempty"`
+	Uses      int       `json:"uses" bson:"uses"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// NormalizeCode returns code as stored: trimmed and upper case, since codes
+// are matched case-insensitively
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks the definition of a new promotion. It returns a
+// *contracts.ValidationError listing every violation, or nil.
+func (p Promotion) Validate() error {
+	verr := &contracts.ValidationError{}
+	invalid := func(field, message string) {
+		verr.Fields = append(verr.Fields, contracts.FieldError{Field: field, Message: message})
+	}
+
+	if code := NormalizeCode(p.Code); code == "" || len(code) > 64 {
+		invalid("code", "must be between 1 and 64 characters")
+	}
+	switch p.Type {
+	case contracts.PromotionPercentage:
+		if p.BasisPoints < 1 || p.BasisPoints > 10000 {
+			invalid("basis_points", "must be between 1 and 10000")
+		}
+	case contracts.PromotionFixed:
+		if p.AmountOff == nil || p.AmountOff.Amount <= 0 {
+			invalid("amount_off", "must be a positive amount")
+		}
+	default:
+		invalid("type", "must be percentage or fixed")
+	}
+	if p.MinSubtotal != nil && p.MinSubtotal.IsNegative() {
+		invalid("min_subtotal", "must not be negative")
+	}
+	if p.StartsAt != nil && p.ExpiresAt != nil && !p.ExpiresAt.After(*p.StartsAt) {
+		invalid("expires_at", "must be after starts_at")
+	}
+	if p.MaxUses < 0 {
+		invalid("max_uses", "must not be negative")
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// Check reports why the promotion cannot be applied at to an order with
+// subtotal, or nil when it can. The usage limit is enforced by Redeem.
+func (p Promotion) Check(subtotal money.Money, at time.Time) error {
+	switch {
+	case p.StartsAt != nil && at.Before(*p.StartsAt):
+		return ErrNotStarted
+	case p.ExpiresAt != nil && !at.Before(*p.ExpiresAt):
+		return ErrExpired
+	case p.MaxUses > 0 && p.Uses >= p.MaxUses:
+		return ErrExhausted
+	case p.Type == contracts.PromotionFixed && p.AmountOff != nil && p.AmountOff.Currency != subtotal.Currency:
+		return ErrCurrency
+	}
+	if p.MinSubtotal != nil {
+		if p.MinSubtotal.Currency != subtotal.Currency {
+			return ErrCurrency
+		}
+		if subtotal.Amount < p.MinSubtotal.Amount {
+			return ErrBelowMinimum
+		}
+	}
+	return nil
+}
+
+// Applied returns the terms to record on an order using the promotion
+func (p Promotion) Applied() contracts.AppliedPromotion {
+	return contracts.AppliedPromotion{
+		Code:        p.Code,
+		Type:        p.Type,
+		BasisPoints: p.BasisPoints,
+		AmountOff:   p.AmountOff,
+	}
+}
+
+// Store keeps promotions in MongoDB and counts their redemptions
+type Store struct {
+	promotions *mongo.Collection
+}
+
+// NewStore returns a store backed by promotions
+func NewStore(promotions *mongo.Collection) *Store {
+	return &Store{promotions: promotions}
+}
+
+// EnsureIndexes creates the unique code index
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.promotions.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Create stores a new promotion with its code normalized and no uses
+func (s *Store) Create(ctx context.Context, p *Promotion) error {
+	p.ID = primitive.NewObjectID()
+	p.Code = NormalizeCode(p.Code)
+	p.Uses = 0
+	if _, err := s.promotions.InsertOne(ctx, p); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrExists
+		}
+		return err
+	}
+	return nil
+}
+
+// List returns every promotion, newest first
+func (s *Store) List(ctx context.Context) ([]Promotion, error) {
+	cursor, err := s.promotions.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, err
+	}
+	promotions := []Promotion{}
+	if err := cursor.All(ctx, &promotions); err != nil {
+		return nil, err
+	}
+	return promotions, nil
+}
+
+// Find returns the promotion with code, matched case-insensitively
+func (s *Store) Find(ctx context.Context, code string) (Promotion, error) {
+	var p Promotion
+	err := s.promotions.FindOne(ctx, bson.M{"code": NormalizeCode(code)}).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// Redeem counts one use of code, failing with ErrExhausted if that would
+// exceed its usage limit. Concurrent redemptions never overshoot the limit.
+func (s *Store) Redeem(ctx context.Context, code string) error {
+	code = NormalizeCode(code)
+	filter := bson.M{"code": code, "$or": bson.A{
+		bson.M{"max_uses": bson.M{"$exists": false}},
+		bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+	}}
+	res, err := s.promotions.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.Find(ctx, code); err != nil {
+			return err
+		}
+		return ErrExhausted
+	}
+	return nil
+}
+
+// Release gives back a use counted by Redeem for an order that was not
+// placed after all
+func (s *Store) Release(ctx context.Context, code string) error {
+	_, err := s.promotions.UpdateOne(ctx,
+		bson.M{"code": NormalizeCode(code), "uses": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"uses": -1}})
+	return err
+}
@@ -0,0 +1,219 @@
+// Package redact masks personal data and credentials in log entries before
+// they are written: values of named JSON fields whole, and anything
+// matching a pattern, such as email addresses and bearer tokens, wherever
+// it appears in a message, error or access log line.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Mask replaces the values of redacted fields
+const Mask = "[REDACTED]"
+
+// Rule replaces the matches of Pattern with Replacement, which may refer to
+// submatches as regexp.Regexp.ReplaceAllString does
+type Rule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// DefaultFields are the JSON fields whose values are always masked: the
+// personal data on orders and the credentials a request may carry
+var DefaultFields = []string{
+	"email", "guest_email", "shipping_address", "line1", "line2",
+	"password", "secret", "token", "access_token", "refresh_token", "api_key",
+	"authorization", "cookie", "claims",
+}
+
+// DefaultRules mask credentials in URLs, bearer tokens, JWTs and email
+// addresses, in that order so a URL's password is not taken for an email
+var DefaultRules = []Rule{
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/@\s"]+@`), "${1}[credentials]@"},
+	{regexp.MustCompile(`(?i)([?&](?:token|access_token|api_key|signature|x-amz-signature)=)[^&\s"]+`), "${1}[token]"},
+	{regexp.MustCompile(`\b(Bearer|Basic|ApiKey)\s+[A-Za-z0-9._~+/=-]{8,}`), "$1 [token]"},
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), "[jwt]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[email]"},
+}
+
+// Redactor masks fields and rule matches in log entries
+type Redactor struct {
+	fields map[string]bool
+	rules  []Rule
+}
+
+// New returns a redactor masking the values of fields, matched by name
+// case-insensitively at any depth, and applying rules to every other string
+func New(fields []string, rules []Rule) *Redactor {
+	r := &Redactor{fields: map[string]bool{}, rules: rules}
+	for _, field := range fields {
+		r.fields[strings.ToLower(field)] = true
+	}
+	return r
+}
+
+// Default returns a redactor of DefaultFields and DefaultRules
+func Default() *Redactor {
+	return New(DefaultFields, DefaultRules)
+}
+
+// Text applies the rules to s
+func (r *Redactor) Text(s string) string {
+	for _, rule := range r.rules {
+		s = rule.Pattern.ReplaceAllString(s, rule.Replacement)
+	}
+	return s
+}
+
+// Entry redacts one log entry: a JSON object, as zerolog writes, field by
+// field keeping their order, or any other line as text
+func (r *Redactor) Entry(p []byte) []byte {
+	trimmed := bytes.TrimRight(p, "\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var out bytes.Buffer
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.UseNumber()
+		if err := r.value(dec, &out, false); err == nil && !dec.More() {
+			out.Write(p[len(trimmed):])
+			return out.Bytes()
+		}
+	}
+	return []byte(r.Text(string(p)))
+}
+
+// value copies the next JSON value of dec to out, masked whole when mask is
+// set
+func (r *Redactor) value(dec *json.Decoder, out *bytes.Buffer, mask bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, composite := tok.(json.Delim)
+	if mask {
+		if composite {
+			if err := skip(dec); err != nil {
+				return err
+			}
+		}
+		return writeString(out, Mask)
+	}
+	if !composite {
+		if s, ok := tok.(string); ok {
+			return writeString(out, r.Text(s))
+		}
+		b, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		out.Write(b)
+		return nil
+	}
+
+	if delim == '[' {
+		out.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			if err := r.value(dec, out, false); err != nil {
+				return err
+			}
+		}
+		out.WriteByte(']')
+	} else {
+		out.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				out.WriteByte(',')
+			}
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			name, _ := key.(string)
+			if err := writeString(out, name); err != nil {
+				return err
+			}
+			out.WriteByte(':')
+			if err := r.value(dec, out, r.fields[strings.ToLower(name)]); err != nil {
+				return err
+			}
+		}
+		out.WriteByte('}')
+	}
+	// The closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// skip reads the rest of the composite value whose opening delimiter was
+// just read
+func skip(dec *json.Decoder) error {
+	for depth := 1; depth > 0; {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// writeString writes s as a JSON string, leaving <, > and & as they are,
+// as zerolog does
+func writeString(out *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	out.Truncate(out.Len() - 1)
+	return nil
+}
+
+// Writer redacts every entry written to it before passing it on. Its
+// redactor can be replaced while it is in use; without one entries pass
+// unchanged.
+type Writer struct {
+	w        io.Writer
+	redactor atomic.Value
+}
+
+// NewWriter returns a writer to w redacting with r
+func NewWriter(w io.Writer, r *Redactor) *Writer {
+	writer := &Writer{w: w}
+	writer.Set(r)
+	return writer
+}
+
+// Set replaces the redactor; nil stops redacting
+func (w *Writer) Set(r *Redactor) {
+	w.redactor.Store(holder{r})
+}
+
+// holder lets a nil redactor be stored
+type holder struct {
+	r *Redactor
+}
+
+// Write writes the redacted p, reporting all of p as written
+func (w *Writer) Write(p []byte) (int, error) {
+	if r := w.redactor.Load().(holder).r; r != nil {
+		if _, err := w.w.Write(r.Entry(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return w.w.Write(p)
+}
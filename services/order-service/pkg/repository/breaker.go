@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order-service/pkg/breaker"
+	"order-service/pkg/contracts"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BreakerRepository guards another repository with a circuit breaker, so
+// requests fail fast with breaker.ErrOpen while the database is down or
+// too slow, instead of each waiting out its deadline. Its methods are
+// those of the wrapped repository.
+type BreakerRepository struct {
+	next  OrderRepository
+	guard breaker.Guard
+}
+
+// NewBreakerRepository wraps next; calls slower than slow count as
+// failures like timeouts and network errors, while errors such as
+// ErrNotFound or ErrConflict do not
+func NewBreakerRepository(next OrderRepository, b *breaker.Breaker, slow time.Duration) *BreakerRepository {
+	return &BreakerRepository{next: next, guard: breaker.Guard{Breaker: b, Failure: databaseFailure, Slow: slow}}
+}
+
+// databaseFailure reports whether err means the database is unreachable or
+// overloaded
+func databaseFailure(err error) bool {
+	return mongo.IsTimeout(err) || mongo.IsNetworkError(err)
+}
+
+// call runs fn through the breaker, unless ctx already ended, as when
+// another dependency used up the deadline, which says nothing about the
+// database
+func (r *BreakerRepository) call(ctx context.Context, fn func() error) error {
+	if ctx.Err() != nil {
+		return fn()
+	}
+	return r.guard.Call(fn)
+}
+
+func (r *BreakerRepository) Create(ctx context.Context, order *contracts.Order) error {
+	return r.call(ctx, func() error { return r.next.Create(ctx, order) })
+}
+
+func (r *BreakerRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	var errs []error
+	err := r.call(ctx, func() error {
+		errs = r.next.CreateMany(ctx, orders)
+		// Orders failing alike failed for the database, not their content
+		if len(errs) > 0 && errs[0] != nil && databaseFailure(errs[0]) {
+			return errs[0]
+		}
+		return nil
+	})
+	if errors.Is(err, breaker.ErrOpen) {
+		errs = make([]error, len(orders))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (r *BreakerRepository) FindByID(ctx context.Context, id primitive.ObjectID) (order contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		order, err = r.next.FindByID(ctx, id)
+		return err
+	})
+	return order, err
+}
+
+func (r *BreakerRepository) FindByUser(ctx context.Context, userID string) (orders []contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		orders, err = r.next.FindByUser(ctx, userID)
+		return err
+	})
+	return orders, err
+}
+
+// EachByUser guards the whole stream, so a cursor stalling half way counts
+// against the database too
+func (r *BreakerRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	return r.call(ctx, func() error { return r.next.EachByUser(ctx, userID, fn) })
+}
+
+func (r *BreakerRepository) FindUserPage(ctx context.Context, userID string, q PageQuery) (page Page, err error) {
+	err = r.call(ctx, func() error {
+		page, err = r.next.FindUserPage(ctx, userID, q)
+		return err
+	})
+	return page, err
+}
+
+func (r *BreakerRepository) FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (page Page, err error) {
+	err = r.call(ctx, func() error {
+		page, err = r.next.FindPage(ctx, filter, q)
+		return err
+	})
+	return page, err
+}
+
+func (r *BreakerRepository) Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (page SearchPage, err error) {
+	err = r.call(ctx, func() error {
+		page, err = r.next.Search(ctx, text, filter, q)
+		return err
+	})
+	return page, err
+}
+
+func (r *BreakerRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (order contracts.Order, previous string, err error) {
+	err = r.call(ctx, func() error {
+		order, previous, err = r.next.UpdateStatus(ctx, id, change)
+		return err
+	})
+	return order, previous, err
+}
+
+func (r *BreakerRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (order contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		order, err = r.next.UpdateItems(ctx, id, change)
+		return err
+	})
+	return order, err
+}
+
+func (r *BreakerRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (order contracts.Order, previous string, err error) {
+	err = r.call(ctx, func() error {
+		order, previous, err = r.next.UpdateShipments(ctx, id, change)
+		return err
+	})
+	return order, previous, err
+}
+
+func (r *BreakerRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (order contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		order, err = r.next.UpdatePriority(ctx, id, change)
+		return err
+	})
+	return order, err
+}
+
+func (r *BreakerRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (order contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		order, err = r.next.AddNote(ctx, id, change)
+		return err
+	})
+	return order, err
+}
+
+func (r *BreakerRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (order contracts.Order, err error) {
+	err = r.call(ctx, func() error {
+		order, err = r.next.Delete(ctx, id, at, base)
+		return err
+	})
+	return order, err
+}
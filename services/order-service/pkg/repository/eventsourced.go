@@ -0,0 +1,760 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Domain event types recorded in the order event stream
+const (
+	DomainOrderCreated     = "OrderCreated"
+	DomainItemAdded        = "ItemAdded"
+	DomainStatusChanged    = "StatusChanged"
+	DomainItemsChanged     = "ItemsChanged"
+	DomainNoteAdded        = "NoteAdded"
+	DomainShipmentsChanged = "ShipmentsChanged"
+	DomainPriorityChanged  = "PriorityChanged"
+	DomainOrderDeleted     = "OrderDeleted"
+)
+
+// DomainEvent is one entry in an order's append-only event stream. Version
+// is the 1-based position in the stream and is unique per aggregate.
+type DomainEvent struct {
+	AggregateID string    `bson:"aggregate_id"`
+	Version     int       `bson:"version"`
+	Type        string    `bson:"type"`
+	Data        bson.Raw  `bson:"data"`
+	OccurredAt  time.Time `bson:"occurred_at"`
+}
+
+// OrderCreatedData is the payload of an OrderCreated event
+type OrderCreatedData struct {
+	ID      primitive.ObjectID `bson:"_id"`
+	OrderID string             `bson:"order_id"`
+	UserID  string             `bson:"user_id"`
+	Status  string             `bson:"status"`
+	// TenantID is set on orders of multi-tenant deployments
+	TenantID string `bson:"tenant_id,omitempty"`
+	// Currency is empty in streams written before orders carried money
+	Currency string `bson:"currency,omitempty"`
+	// Charges are nil in streams written before orders had a breakdown
+	Charges *contracts.Charges `bson:"charges,omitempty"`
+	// Settlement is the order's total normalized to the settlement currency
+	Settlement *money.Money `bson:"settlement_total,omitempty"`
+	// Promotion is the promotion code the order was placed with
+	Promotion *contracts.AppliedPromotion `bson:"promotion,omitempty"`
+	// ShippingAddress is where the order is shipped
+	ShippingAddress *contracts.Address `bson:"shipping_address,omitempty"`
+	// GuestEmail is set on orders placed through guest checkout
+	GuestEmail string `bson:"guest_email,omitempty"`
+	// IsGift and GiftMessage are set on gift orders
+	IsGift      bool   `bson:"is_gift,omitempty"`
+	GiftMessage string `bson:"gift_message,omitempty"`
+	// Priority is empty in streams written before orders had priorities
+	Priority string `bson:"priority,omitempty"`
+}
+
+// ItemAddedData is the payload of an ItemAdded event
+type ItemAddedData struct {
+	Item contracts.OrderItem `bson:"item"`
+}
+
+// StatusChangedData is the payload of a StatusChanged event
+type StatusChangedData struct {
+	From    string `bson:"from"`
+	To      string `bson:"to"`
+	ActorID string `bson:"actor_id,omitempty"`
+	Reason  string `bson:"reason,omitempty"`
+	// Return is the order's return as of the change, for return statuses
+	Return *contracts.Return `bson:"return,omitempty"`
+}
+
+// ItemsChangedData is the payload of an ItemsChanged event, replacing every
+// item of the order
+type ItemsChangedData struct {
+	Items []contracts.OrderItem `bson:"items"`
+	Total money.Money           `bson:"total"`
+	// Subtotal and Charges are nil in events written before orders had a
+	// breakdown, when the total was the subtotal
+	Subtotal *money.Money       `bson:"subtotal,omitempty"`
+	Charges  *contracts.Charges `bson:"charges,omitempty"`
+	// Settlement is the new total normalized to the settlement currency
+	Settlement *money.Money `bson:"settlement_total,omitempty"`
+}
+
+// NoteAddedData is the payload of a NoteAdded event; the note's time is the
+// event's
+type NoteAddedData struct {
+	Text     string `bson:"text"`
+	AuthorID string `bson:"author_id"`
+}
+
+// ShipmentsChangedData is the payload of a ShipmentsChanged event,
+// replacing every shipment of the order. A status derived from the
+// shipments is recorded as a StatusChanged event appended with it.
+type ShipmentsChangedData struct {
+	Shipments []contracts.Shipment `bson:"shipments"`
+}
+
+// PriorityChangedData is the payload of a PriorityChanged event
+type PriorityChangedData struct {
+	Priority string `bson:"priority"`
+	ActorID  string `bson:"actor_id,omitempty"`
+}
+
+// projection is the read-model document kept in the orders collection
+type projection struct {
+	contracts.Order `bson:",inline"`
+	Version         int `bson:"version"`
+}
+
+// MarshalBSON writes the order, through its own hook stamping its schema
+// version, next to the stream version, which the order's hook promoted to
+// projection would otherwise drop
+func (p projection) MarshalBSON() ([]byte, error) {
+	raw, err := bson.Marshal(p.Order)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(append(doc, bson.E{Key: "version", Value: p.Version}))
+}
+
+// UnmarshalBSON reads the order, upgraded to the current schema, and the
+// stream version
+func (p *projection) UnmarshalBSON(data []byte) error {
+	if err := p.Order.UnmarshalBSON(data); err != nil {
+		return err
+	}
+	p.Version = 0
+	if v, ok := bson.Raw(data).Lookup("version").AsInt64OK(); ok {
+		p.Version = int(v)
+	}
+	return nil
+}
+
+// EventSourcedRepository derives order state from an append-only event
+// stream. A current-state projection is written to the orders collection
+// after every append so reads never replay the stream.
+type EventSourcedRepository struct {
+	events      *mongo.Collection
+	projections *mongo.Collection
+
+	snapshots     *mongo.Collection
+	snapshotEvery int
+
+	// Clock timestamps snapshots; event times come from the caller
+	Clock clock.Clock
+	// Shard targets projection queries at the shard holding each order
+	// when the orders collection is sharded; nil when it is not. Streams
+	// and snapshots are keyed by order ID alone.
+	Shard *ShardKey
+	// Transactions writes each append's events and projection atomically;
+	// nil writes them one after the other
+	Transactions *Transactions
+	// Replicas is the projections collection as read by requests marked by
+	// WithReplicaReads; nil reads every query from projections. Streams
+	// are always read from the primary.
+	Replicas *mongo.Collection
+}
+
+// NewEventSourcedRepository returns a repository appending to events and
+// projecting into projections
+func NewEventSourcedRepository(events, projections *mongo.Collection) *EventSourcedRepository {
+	return &EventSourcedRepository{events: events, projections: projections, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the unique stream index that provides optimistic concurrency
+func (r *EventSourcedRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.events.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "aggregate_id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// Create appends OrderCreated, one ItemAdded per line item and one
+// NoteAdded per note
+func (r *EventSourcedRepository) Create(ctx context.Context, order *contracts.Order) error {
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+
+	charges := order.Charges().In(order.TotalAmount.Currency)
+	guestEmail, err := contracts.SealPII(order.GuestEmail)
+	if err != nil {
+		return err
+	}
+	created, err := newDomainEvent(order.OrderID, 1, DomainOrderCreated, order.CreatedAt, OrderCreatedData{
+		ID:              order.ID,
+		OrderID:         order.OrderID,
+		UserID:          order.UserID,
+		TenantID:        order.TenantID,
+		Status:          order.Status,
+		Currency:        order.TotalAmount.Currency,
+		Charges:         &charges,
+		Settlement:      order.SettlementTotal,
+		Promotion:       order.Promotion,
+		ShippingAddress: order.ShippingAddress,
+		GuestEmail:      guestEmail,
+		IsGift:          order.IsGift,
+		GiftMessage:     order.GiftMessage,
+		Priority:        order.Priority,
+	})
+	if err != nil {
+		return err
+	}
+	stream := []DomainEvent{created}
+	for i, item := range order.Items {
+		added, err := newDomainEvent(order.OrderID, i+2, DomainItemAdded, order.CreatedAt, ItemAddedData{Item: item})
+		if err != nil {
+			return err
+		}
+		stream = append(stream, added)
+	}
+	for _, note := range order.Notes {
+		added, err := newDomainEvent(order.OrderID, len(stream)+1, DomainNoteAdded, note.At, NoteAddedData{Text: note.Text, AuthorID: note.AuthorID})
+		if err != nil {
+			return err
+		}
+		stream = append(stream, added)
+	}
+
+	state, err := r.append(ctx, contracts.Order{}, 0, stream)
+	if err != nil {
+		return err
+	}
+	*order = state
+	return nil
+}
+
+// CreateMany appends each order's stream in turn; every order is its own
+// stream, so they cannot share a write
+func (r *EventSourcedRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	errs := make([]error, len(orders))
+	for i, order := range orders {
+		errs[i] = r.Create(ctx, order)
+	}
+	return errs
+}
+
+// FindByID reads the current-state projection
+func (r *EventSourcedRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	var p projection
+	err := reader(ctx, r.projections, r.Replicas).FindOne(ctx, r.Shard.Target("find_by_id", notDeleted(bson.M{"_id": id}), id)).Decode(&p)
+	if err == mongo.ErrNoDocuments {
+		return p.Order, ErrNotFound
+	}
+	r.Shard.Observe(p.Order)
+	return p.Order, err
+}
+
+// FindByUser reads current-state projections for the user
+func (r *EventSourcedRepository) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	filter := notDeleted(bson.M{"user_id": userID})
+	r.Shard.Check("list", filter)
+	cursor, err := reader(ctx, r.projections, r.Replicas).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []contracts.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	r.Shard.Observe(orders...)
+	return orders, nil
+}
+
+// EachByUser streams the user's current-state projections from a cursor
+func (r *EventSourcedRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	filter := notDeleted(bson.M{"user_id": userID})
+	r.Shard.Check("list", filter)
+	return eachOrder(ctx, reader(ctx, r.projections, r.Replicas), filter, fn)
+}
+
+// FindUserPage reads one page of the user's current-state projections
+func (r *EventSourcedRepository) FindUserPage(ctx context.Context, userID string, q PageQuery) (Page, error) {
+	return r.findPage(ctx, notDeleted(bson.M{"user_id": userID}), q)
+}
+
+// FindPage reads one page of the current-state projections matching filter
+func (r *EventSourcedRepository) FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (Page, error) {
+	return r.findPage(ctx, filter.query(), q)
+}
+
+// Search reads one page of the current-state projections matching filter
+// through the text index
+func (r *EventSourcedRepository) Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (SearchPage, error) {
+	query := filter.query()
+	r.Shard.Check("search", query)
+	page, err := searchPage(ctx, reader(ctx, r.projections, r.Replicas), text, query, q)
+	for _, hit := range page.Hits {
+		r.Shard.Observe(hit.Order)
+	}
+	return page, err
+}
+
+// findPage reads a page through findPage, remembering the shard key of its
+// orders
+func (r *EventSourcedRepository) findPage(ctx context.Context, filter bson.M, q PageQuery) (Page, error) {
+	r.Shard.Check("list", filter)
+	page, err := findPage(ctx, reader(ctx, r.projections, r.Replicas), filter, q)
+	r.Shard.Observe(page.Orders...)
+	return page, err
+}
+
+// UpdateStatus rehydrates the aggregate from its stream and appends
+// StatusChanged. The stream version guards the transition check against
+// concurrent changes.
+func (r *EventSourcedRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, "", err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, "", err
+	}
+
+	if !contracts.CanTransition(state.Status, change.To) {
+		return state, "", &contracts.TransitionError{From: state.Status, To: change.To}
+	}
+	if !change.Base.IsZero() && !state.UpdatedAt.Equal(change.Base) {
+		return state, "", ErrConflict
+	}
+
+	changed, err := newDomainEvent(state.OrderID, version+1, DomainStatusChanged, change.At, StatusChangedData{
+		From:    state.Status,
+		To:      change.To,
+		ActorID: change.Actor,
+		Reason:  change.Reason,
+		Return:  change.Return,
+	})
+	if err != nil {
+		return state, "", err
+	}
+
+	previousStatus := state.Status
+	state, err = r.append(ctx, state, version, []DomainEvent{changed})
+	return state, previousStatus, err
+}
+
+// UpdateItems rehydrates the aggregate from its stream and appends
+// ItemsChanged, guarded by the stream version like UpdateStatus
+func (r *EventSourcedRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, err
+	}
+	if state.Status != contracts.StatusPending {
+		return state, contracts.ErrNotEditable
+	}
+	if !state.UpdatedAt.Equal(change.Base) {
+		return state, ErrConflict
+	}
+
+	changed, err := newDomainEvent(state.OrderID, version+1, DomainItemsChanged, change.At, ItemsChangedData{
+		Items:      change.Items,
+		Total:      change.Total,
+		Subtotal:   &change.Subtotal,
+		Charges:    &change.Charges,
+		Settlement: change.Settlement,
+	})
+	if err != nil {
+		return state, err
+	}
+	return r.append(ctx, state, version, []DomainEvent{changed})
+}
+
+// AddNote rehydrates the aggregate from its stream and appends NoteAdded,
+// guarded by the stream version like UpdateStatus
+func (r *EventSourcedRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, err
+	}
+	if !change.Base.IsZero() && !state.UpdatedAt.Equal(change.Base) {
+		return state, ErrConflict
+	}
+	if len(state.Notes) >= contracts.MaxNotes {
+		return state, contracts.ErrTooManyNotes
+	}
+
+	added, err := newDomainEvent(state.OrderID, version+1, DomainNoteAdded, change.Note.At, NoteAddedData{
+		Text:     change.Note.Text,
+		AuthorID: change.Note.AuthorID,
+	})
+	if err != nil {
+		return state, err
+	}
+	return r.append(ctx, state, version, []DomainEvent{added})
+}
+
+// UpdatePriority rehydrates the aggregate from its stream and appends
+// PriorityChanged, guarded by the stream version like UpdateStatus
+func (r *EventSourcedRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, err
+	}
+	if err := change.Apply(&contracts.Order{Status: state.Status}); err != nil {
+		return state, err
+	}
+	if !change.Base.IsZero() && !state.UpdatedAt.Equal(change.Base) {
+		return state, ErrConflict
+	}
+
+	changed, err := newDomainEvent(state.OrderID, version+1, DomainPriorityChanged, change.At, PriorityChangedData{
+		Priority: change.Priority,
+		ActorID:  change.Actor,
+	})
+	if err != nil {
+		return state, err
+	}
+	return r.append(ctx, state, version, []DomainEvent{changed})
+}
+
+// UpdateShipments rehydrates the aggregate from its stream and appends
+// ShipmentsChanged, followed by StatusChanged when the shipments move the
+// order, guarded by the stream version like UpdateStatus
+func (r *EventSourcedRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, "", err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, "", err
+	}
+	// Check the change against the status alone; the events apply it
+	if err := change.Apply(&contracts.Order{Status: state.Status}); err != nil {
+		return state, "", err
+	}
+	if !state.UpdatedAt.Equal(change.Base) {
+		return state, "", ErrConflict
+	}
+
+	changed, err := newDomainEvent(state.OrderID, version+1, DomainShipmentsChanged, change.At, ShipmentsChangedData{
+		Shipments: change.Shipments,
+	})
+	if err != nil {
+		return state, "", err
+	}
+	stream := []DomainEvent{changed}
+	if change.Moves(state.Status) {
+		status := change.StatusChange()
+		moved, err := newDomainEvent(state.OrderID, version+2, DomainStatusChanged, change.At, StatusChangedData{
+			From:    state.Status,
+			To:      status.To,
+			ActorID: status.Actor,
+			Reason:  status.Reason,
+		})
+		if err != nil {
+			return state, "", err
+		}
+		stream = append(stream, moved)
+	}
+
+	previousStatus := state.Status
+	state, err = r.append(ctx, state, version, stream)
+	return state, previousStatus, err
+}
+
+// Delete appends OrderDeleted; the projection keeps the order, marked
+// deleted, so reads skip it
+func (r *EventSourcedRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	state, version, err := r.Load(ctx, current.OrderID)
+	if err != nil {
+		return state, err
+	}
+	if state.DeletedAt != nil {
+		return state, ErrNotFound
+	}
+	if !base.IsZero() && !state.UpdatedAt.Equal(base) {
+		return state, ErrConflict
+	}
+
+	deleted, err := newDomainEvent(state.OrderID, version+1, DomainOrderDeleted, at, struct{}{})
+	if err != nil {
+		return state, err
+	}
+	return r.append(ctx, state, version, []DomainEvent{deleted})
+}
+
+// Load rehydrates an order from its latest snapshot (if any) plus the
+// events after it, and returns it with the stream version
+func (r *EventSourcedRepository) Load(ctx context.Context, orderID string) (contracts.Order, int, error) {
+	var (
+		order   contracts.Order
+		version int
+	)
+
+	snapshot, err := r.loadSnapshot(ctx, orderID)
+	if err != nil {
+		return order, 0, err
+	}
+	if snapshot != nil {
+		order = snapshot.State
+		version = snapshot.Version
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	filter := bson.M{"aggregate_id": orderID, "version": bson.M{"$gt": version}}
+	cursor, err := r.events.Find(ctx, filter, opts)
+	if err != nil {
+		return order, version, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event DomainEvent
+		if err := cursor.Decode(&event); err != nil {
+			return order, version, err
+		}
+		if err := apply(&order, event); err != nil {
+			return order, version, err
+		}
+		version = event.Version
+	}
+	if err := cursor.Err(); err != nil {
+		return order, version, err
+	}
+	if version == 0 {
+		return order, 0, ErrNotFound
+	}
+	return order, version, nil
+}
+
+// History returns the raw event stream for an order
+func (r *EventSourcedRepository) History(ctx context.Context, orderID string) ([]DomainEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}})
+	cursor, err := r.events.Find(ctx, bson.M{"aggregate_id": orderID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stream []DomainEvent
+	if err := cursor.All(ctx, &stream); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// append writes new events after expectedVersion, applies them to state and
+// refreshes the projection. A duplicate version means another writer won.
+func (r *EventSourcedRepository) append(ctx context.Context, state contracts.Order, expectedVersion int, stream []DomainEvent) (contracts.Order, error) {
+	docs := make([]interface{}, 0, len(stream))
+	for _, event := range stream {
+		if err := apply(&state, event); err != nil {
+			return state, err
+		}
+		docs = append(docs, event)
+	}
+
+	// The events and the projection are written in one transaction where
+	// Transactions allows, so the projection never lags its stream. The
+	// upsert of a sharded projection must name its shard, which the state
+	// always knows.
+	version := expectedVersion + len(stream)
+	r.Shard.Observe(state)
+	err := r.Transactions.Run(ctx, func(ctx context.Context) error {
+		if _, err := r.events.InsertMany(ctx, docs); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return ErrConflict
+			}
+			return err
+		}
+		_, err := r.projections.ReplaceOne(ctx,
+			r.Shard.Target("update", bson.M{"_id": state.ID}, state.ID),
+			projection{Order: state, Version: version},
+			options.Replace().SetUpsert(true),
+		)
+		return err
+	})
+	if err != nil {
+		return state, err
+	}
+
+	// Snapshots are an optimization; the stream remains the source of truth
+	if err := r.maybeSnapshot(ctx, state, expectedVersion, version); err != nil {
+		log.Warn().Err(err).Str("order_id", state.OrderID).Msg("Failed to save order snapshot")
+	}
+	return state, nil
+}
+
+func newDomainEvent(aggregateID string, version int, eventType string, at time.Time, data interface{}) (DomainEvent, error) {
+	raw, err := bson.Marshal(data)
+	if err != nil {
+		return DomainEvent{}, err
+	}
+	return DomainEvent{
+		AggregateID: aggregateID,
+		Version:     version,
+		Type:        eventType,
+		Data:        raw,
+		OccurredAt:  at.UTC(),
+	}, nil
+}
+
+// apply folds a single domain event into the order state
+func apply(order *contracts.Order, event DomainEvent) error {
+	switch event.Type {
+	case DomainOrderCreated:
+		var data OrderCreatedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		charges := contracts.NoCharges(data.Currency)
+		if data.Charges != nil {
+			charges = data.Charges.In(data.Currency)
+		}
+		// The items added next count towards both the subtotal and the
+		// total, so the total starts from the charges alone
+		total, err := money.Sum(data.Currency, charges.Tax, charges.Shipping)
+		if err != nil {
+			return err
+		}
+		if total, err = total.Sub(charges.Discount); err != nil {
+			return err
+		}
+		guestEmail, err := contracts.OpenPII(data.GuestEmail)
+		if err != nil {
+			return err
+		}
+		*order = contracts.Order{
+			ID:              data.ID,
+			OrderID:         data.OrderID,
+			UserID:          data.UserID,
+			TenantID:        data.TenantID,
+			Items:           []contracts.OrderItem{},
+			Subtotal:        money.Zero(data.Currency),
+			TaxAmount:       charges.Tax,
+			ShippingAmount:  charges.Shipping,
+			DiscountAmount:  charges.Discount,
+			TotalAmount:     total,
+			Status:          data.Status,
+			SettlementTotal: data.Settlement,
+			Promotion:       data.Promotion,
+			ShippingAddress: data.ShippingAddress,
+			GuestEmail:      guestEmail,
+			IsGift:          data.IsGift,
+			GiftMessage:     data.GiftMessage,
+			Priority:        data.Priority,
+			PriorityRank:    contracts.PriorityRank(data.Priority),
+			CreatedAt:       event.OccurredAt,
+			UpdatedAt:       event.OccurredAt,
+		}
+	case DomainItemAdded:
+		var data ItemAddedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		if order.TotalAmount.Currency == "" {
+			currency := data.Item.Price.Currency
+			order.TotalAmount = money.Zero(currency)
+			order.Subtotal = money.Zero(currency)
+			charges := contracts.NoCharges(currency)
+			order.TaxAmount, order.ShippingAmount, order.DiscountAmount = charges.Tax, charges.Shipping, charges.Discount
+		}
+		line := data.Item.Price.Mul(int64(data.Item.Quantity))
+		total, err := order.TotalAmount.Add(line)
+		if err != nil {
+			return err
+		}
+		subtotal, err := order.Subtotal.Add(line)
+		if err != nil {
+			return err
+		}
+		order.Items = append(order.Items, data.Item)
+		order.Subtotal = subtotal
+		order.TotalAmount = total
+		order.UpdatedAt = event.OccurredAt
+	case DomainStatusChanged:
+		var data StatusChangedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		contracts.StatusChange{To: data.To, At: event.OccurredAt, Reason: data.Reason, Actor: data.ActorID, Return: data.Return}.Set(order)
+	case DomainItemsChanged:
+		var data ItemsChangedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		change := contracts.ItemsChange{
+			Items:      data.Items,
+			Subtotal:   data.Total,
+			Charges:    contracts.NoCharges(data.Total.Currency),
+			Total:      data.Total,
+			Settlement: data.Settlement,
+			At:         event.OccurredAt,
+		}
+		if data.Subtotal != nil && data.Charges != nil {
+			change.Subtotal, change.Charges = *data.Subtotal, *data.Charges
+		}
+		change.Set(order)
+	case DomainNoteAdded:
+		var data NoteAddedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		contracts.NoteChange{Note: contracts.Note{Text: data.Text, AuthorID: data.AuthorID, At: event.OccurredAt}}.Set(order)
+	case DomainShipmentsChanged:
+		var data ShipmentsChangedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		contracts.ShipmentsChange{Shipments: data.Shipments, At: event.OccurredAt}.Set(order)
+	case DomainPriorityChanged:
+		var data PriorityChangedData
+		if err := bson.Unmarshal(event.Data, &data); err != nil {
+			return err
+		}
+		contracts.PriorityChange{Priority: data.Priority, At: event.OccurredAt}.Set(order)
+	case DomainOrderDeleted:
+		at := event.OccurredAt
+		order.DeletedAt = &at
+		order.UpdatedAt = at
+	default:
+		return fmt.Errorf("unknown domain event type %q", event.Type)
+	}
+	return nil
+}
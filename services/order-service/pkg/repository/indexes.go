@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+
+	"order-service/pkg/dbindex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrderIndexes are the indexes of the orders collection. Most are the
+// compound indexes behind order listing and search: each leads with the
+// equality fields of a common query (the user, then status or product) and
+// ends with the field it sorts or ranges on, so filters translate into
+// index scans instead of collection scans. The critical ones keep order IDs
+// unique and serve the listings every client uses.
+var OrderIndexes = []dbindex.Index{
+	// An order by its public order_id, as events from other services
+	// refer to it; unique, so no two orders are ever confused
+	{Keys: bson.D{{Key: "order_id", Value: 1}}, Unique: true, Critical: true},
+	// A user's orders, newest first; also created_after/created_before
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}, Critical: true},
+	// A user's orders in a status
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	// A user's orders by total, within a currency
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "total_amount.currency", Value: 1}, {Key: "total_amount.amount", Value: 1}}},
+	// Orders containing a product (multikey over items)
+	{Keys: bson.D{{Key: "items.product_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	// Admin listing across users by status and date; also the expiry of
+	// pending orders
+	{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}}, Critical: true},
+	// Fulfillment queue: orders in a status, most urgent and oldest first
+	{Keys: bson.D{{Key: "status", Value: 1}, {Key: "priority_rank", Value: -1}, {Key: "created_at", Value: 1}}},
+	{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	// A tenant's orders by date, for admin listings of multi-tenant
+	// deployments
+	{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	// Admin search by order ID, item name and shipping address, but for
+	// its personal data
+	textIndex(),
+}
+
+// EnsureOrderIndexes verifies OrderIndexes on an orders collection, which
+// every repository keeps current orders in, creating the missing ones when
+// create is set. A collection sharded by shardKey can only enforce unique
+// indexes starting with it, so the other unique indexes are expected
+// non-unique there.
+func EnsureOrderIndexes(ctx context.Context, orders *mongo.Collection, create bool, shardKey []string) (dbindex.Report, error) {
+	return dbindex.Ensure(ctx, orders, orderIndexes(shardKey), create)
+}
+
+// ConflictingOrderIndexes returns the names of the indexes of an orders
+// collection declared otherwise in OrderIndexes, such as the text index
+// from before it left out the personal data of the shipping address.
+// EnsureOrderIndexes creates them anew once they are dropped.
+func ConflictingOrderIndexes(ctx context.Context, orders *mongo.Collection, shardKey []string) ([]string, error) {
+	return dbindex.Conflicting(ctx, orders, orderIndexes(shardKey))
+}
+
+// orderIndexes returns OrderIndexes as a collection sharded by shardKey
+// can hold them
+func orderIndexes(shardKey []string) []dbindex.Index {
+	if len(shardKey) == 0 {
+		return OrderIndexes
+	}
+	indexes := make([]dbindex.Index, len(OrderIndexes))
+	for i, index := range OrderIndexes {
+		if index.Unique && !prefixedBy(index.Keys, shardKey) {
+			index.Unique = false
+		}
+		indexes[i] = index
+	}
+	return indexes
+}
+
+// prefixedBy reports whether keys start with fields, in order
+func prefixedBy(keys bson.D, fields []string) bool {
+	if len(keys) < len(fields) {
+		return false
+	}
+	for i, field := range fields {
+		if keys[i].Key != field {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,458 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"order-service/pkg/contracts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoRepository stores each order as a single mutable document
+type MongoRepository struct {
+	collection *mongo.Collection
+
+	// Shard targets queries at the shard holding each order when the
+	// collection is sharded; nil when it is not
+	Shard *ShardKey
+	// Replicas is the collection as read by requests marked by
+	// WithReplicaReads, with their read preference and concern; nil reads
+	// every query from collection
+	Replicas *mongo.Collection
+}
+
+// NewMongoRepository returns a document-per-order repository
+func NewMongoRepository(collection *mongo.Collection) *MongoRepository {
+	return &MongoRepository{collection: collection}
+}
+
+// Create inserts the order document
+func (r *MongoRepository) Create(ctx context.Context, order *contracts.Order) error {
+	result, err := r.collection.InsertOne(ctx, order)
+	if err != nil {
+		return err
+	}
+	order.ID = result.InsertedID.(primitive.ObjectID)
+	r.Shard.Observe(*order)
+	return nil
+}
+
+// CreateMany inserts the order documents in one unordered batch
+func (r *MongoRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	docs := make([]interface{}, len(orders))
+	for i, order := range orders {
+		if order.ID.IsZero() {
+			order.ID = primitive.NewObjectID()
+		}
+		docs[i] = order
+	}
+	errs := insertMany(ctx, r.collection, docs)
+	for i, err := range errs {
+		if err == nil {
+			r.Shard.Observe(*orders[i])
+		}
+	}
+	return errs
+}
+
+// FindByID returns the order document
+func (r *MongoRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	return r.findByID(ctx, reader(ctx, r.collection, r.Replicas), id)
+}
+
+// findByID returns the order document as read from collection
+func (r *MongoRepository) findByID(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) (contracts.Order, error) {
+	var order contracts.Order
+	err := collection.FindOne(ctx, r.byID(id)).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return order, ErrNotFound
+	}
+	r.Shard.Observe(order)
+	return order, err
+}
+
+// byID returns the filter of the live order id, targeted at its shard
+func (r *MongoRepository) byID(id primitive.ObjectID) bson.M {
+	return r.Shard.Target("find_by_id", notDeleted(bson.M{"_id": id}), id)
+}
+
+// target adds the shard key of order id to the filter of an update, as an
+// update of a sharded collection must name its shard, reading the order
+// first if its key is not known
+func (r *MongoRepository) target(ctx context.Context, id primitive.ObjectID, filter bson.M) (bson.M, error) {
+	if !r.Shard.Known(id) {
+		if _, err := r.findByID(ctx, r.collection, id); err != nil {
+			return nil, err
+		}
+	}
+	return r.Shard.Target("update", filter, id), nil
+}
+
+// FindByUser returns all order documents for the user
+func (r *MongoRepository) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	filter := notDeleted(bson.M{"user_id": userID})
+	r.Shard.Check("list", filter)
+	cursor, err := reader(ctx, r.collection, r.Replicas).Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []contracts.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return nil, err
+	}
+	r.Shard.Observe(orders...)
+	return orders, nil
+}
+
+// EachByUser streams the user's order documents from a cursor
+func (r *MongoRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	filter := notDeleted(bson.M{"user_id": userID})
+	r.Shard.Check("list", filter)
+	return eachOrder(ctx, reader(ctx, r.collection, r.Replicas), filter, fn)
+}
+
+// FindUserPage returns one page of the user's order documents
+func (r *MongoRepository) FindUserPage(ctx context.Context, userID string, q PageQuery) (Page, error) {
+	return r.findPage(ctx, notDeleted(bson.M{"user_id": userID}), q)
+}
+
+// FindPage returns one page of the order documents matching filter
+func (r *MongoRepository) FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (Page, error) {
+	return r.findPage(ctx, filter.query(), q)
+}
+
+// Search reads one page of the orders matching filter through the text
+// index, remembering the shard key of its orders
+func (r *MongoRepository) Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (SearchPage, error) {
+	query := filter.query()
+	r.Shard.Check("search", query)
+	page, err := searchPage(ctx, reader(ctx, r.collection, r.Replicas), text, query, q)
+	for _, hit := range page.Hits {
+		r.Shard.Observe(hit.Order)
+	}
+	return page, err
+}
+
+// findPage reads a page through findPage, remembering the shard key of its
+// orders
+func (r *MongoRepository) findPage(ctx context.Context, filter bson.M, q PageQuery) (Page, error) {
+	r.Shard.Check("list", filter)
+	page, err := findPage(ctx, reader(ctx, r.collection, r.Replicas), filter, q)
+	r.Shard.Observe(page.Orders...)
+	return page, err
+}
+
+// UpdateStatus sets the status in place, only matching the order while it
+// is in a status the change is allowed from and unchanged since change.Base.
+// The history entry is built in the same update from the stored status.
+func (r *MongoRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+	// Values are wrapped in $literal so a reason starting with $ is not read
+	// as a field path
+	entry := bson.M{
+		"from": "$status",
+		"to":   bson.M{"$literal": change.To},
+		"at":   change.At,
+	}
+	if change.Actor != "" {
+		entry["actor_id"] = bson.M{"$literal": change.Actor}
+	}
+	if change.Reason != "" {
+		entry["reason"] = bson.M{"$literal": change.Reason}
+	}
+	set := bson.M{
+		"status":         bson.M{"$literal": change.To},
+		"updated_at":     change.At,
+		"status_history": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$status_history", bson.A{}}}, bson.A{entry}}},
+	}
+	if change.To == contracts.StatusCancelled {
+		set["cancelled_at"] = change.At
+		set["cancellation_reason"] = bson.M{"$literal": change.Reason}
+	}
+	if change.Return != nil {
+		set["return"] = bson.M{"$literal": change.Return}
+	}
+	filter, err := r.target(ctx, id, unchangedSince(notDeleted(bson.M{"_id": id, "status": bson.M{"$in": contracts.StatusesBefore(change.To)}}), change.Base))
+	if err != nil {
+		return contracts.Order{}, "", err
+	}
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, bson.A{bson.M{"$set": set}}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// The order does not exist, its status forbids the change or it was
+		// modified since change.Base
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, "", ErrNotFound
+		} else if err != nil {
+			return order, "", err
+		}
+		if contracts.CanTransition(order.Status, change.To) {
+			return order, "", ErrConflict
+		}
+		return order, "", &contracts.TransitionError{From: order.Status, To: change.To}
+	}
+	if err != nil {
+		return order, "", err
+	}
+
+	previousStatus := order.Status
+	change.Set(&order)
+	return order, previousStatus, nil
+}
+
+// UpdateItems replaces the items in place, only matching the order while it
+// is pending and unchanged since change.Base
+func (r *MongoRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error) {
+	filter, err := r.target(ctx, id, notDeleted(bson.M{"_id": id, "status": contracts.StatusPending, "updated_at": change.Base}))
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	update := bson.M{"$set": bson.M{
+		"items":            change.Items,
+		"subtotal":         change.Subtotal,
+		"tax_amount":       change.Charges.Tax,
+		"shipping_amount":  change.Charges.Shipping,
+		"discount_amount":  change.Charges.Discount,
+		"total_amount":     change.Total,
+		"settlement_total": change.Settlement,
+		"updated_at":       change.At,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// The order does not exist, has left pending or was modified
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, ErrNotFound
+		} else if err != nil {
+			return order, err
+		}
+		if order.Status != contracts.StatusPending {
+			return order, contracts.ErrNotEditable
+		}
+		return order, ErrConflict
+	}
+	return order, err
+}
+
+// UpdateShipments sets the shipments in place, only matching the order while
+// it is confirmed or shipped, its status allows the derived status and it is
+// unchanged since change.Base. The history entry of a derived status is
+// built in the same update from the stored status.
+func (r *MongoRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error) {
+	statuses := []string{contracts.StatusConfirmed, contracts.StatusShipped}
+	set := bson.M{
+		"shipments":  bson.M{"$literal": change.Shipments},
+		"updated_at": change.At,
+	}
+	if change.Status != "" {
+		entry := bson.M{
+			"from":   "$status",
+			"to":     bson.M{"$literal": change.Status},
+			"at":     change.At,
+			"reason": bson.M{"$literal": contracts.ShipmentsReason},
+		}
+		if change.Actor != "" {
+			entry["actor_id"] = bson.M{"$literal": change.Actor}
+		}
+		set["status"] = bson.M{"$literal": change.Status}
+		set["status_history"] = bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$status_history", bson.A{}}}, bson.A{entry}}}
+		statuses = []string{}
+		for _, status := range contracts.StatusesBefore(change.Status) {
+			if status == contracts.StatusConfirmed || status == contracts.StatusShipped {
+				statuses = append(statuses, status)
+			}
+		}
+	}
+	filter, err := r.target(ctx, id, notDeleted(bson.M{"_id": id, "status": bson.M{"$in": statuses}, "updated_at": change.Base}))
+	if err != nil {
+		return contracts.Order{}, "", err
+	}
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, bson.A{bson.M{"$set": set}}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// The order does not exist, cannot ship, cannot move to the derived
+		// status or was modified since change.Base
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, "", ErrNotFound
+		} else if err != nil {
+			return order, "", err
+		}
+		if order.Status != contracts.StatusConfirmed && order.Status != contracts.StatusShipped {
+			return order, "", contracts.ErrNotShippable
+		}
+		if !order.UpdatedAt.Equal(change.Base) {
+			return order, "", ErrConflict
+		}
+		return order, "", &contracts.TransitionError{From: order.Status, To: change.Status}
+	}
+	if err != nil {
+		return order, "", err
+	}
+
+	previousStatus := order.Status
+	change.Set(&order)
+	return order, previousStatus, nil
+}
+
+// UpdatePriority sets the priority in place, only matching the order while
+// it is pending or confirmed and unchanged since change.Base
+func (r *MongoRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	statuses := []string{contracts.StatusPending, contracts.StatusConfirmed}
+	filter, err := r.target(ctx, id, unchangedSince(notDeleted(bson.M{"_id": id, "status": bson.M{"$in": statuses}}), change.Base))
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	update := bson.M{"$set": bson.M{
+		"priority":      change.Priority,
+		"priority_rank": contracts.PriorityRank(change.Priority),
+		"updated_at":    change.At,
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// The order does not exist, has shipped or will not ship, or was
+		// modified
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, ErrNotFound
+		} else if err != nil {
+			return order, err
+		}
+		if order.Status != contracts.StatusPending && order.Status != contracts.StatusConfirmed {
+			return order, contracts.ErrPriorityLocked
+		}
+		return order, ErrConflict
+	}
+	return order, err
+}
+
+// AddNote pushes the note in place, only matching the order while it has
+// room for another and is unchanged since change.Base
+func (r *MongoRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	full := fmt.Sprintf("notes.%d", contracts.MaxNotes-1)
+	filter, err := r.target(ctx, id, unchangedSince(notDeleted(bson.M{"_id": id, full: bson.M{"$exists": false}}), change.Base))
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	update := bson.M{
+		"$push": bson.M{"notes": change.Note},
+		"$set":  bson.M{"updated_at": change.Note.At},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		// The order does not exist, is full or was modified
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, ErrNotFound
+		} else if err != nil {
+			return order, err
+		}
+		if len(order.Notes) >= contracts.MaxNotes {
+			return order, contracts.ErrTooManyNotes
+		}
+		return order, ErrConflict
+	}
+	return order, err
+}
+
+// Delete soft-deletes the order document by setting deleted_at
+func (r *MongoRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error) {
+	filter, err := r.target(ctx, id, unchangedSince(notDeleted(bson.M{"_id": id}), base))
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	update := bson.M{"$set": bson.M{"deleted_at": at, "updated_at": at}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order contracts.Order
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		if base.IsZero() {
+			return order, ErrNotFound
+		}
+		// The order does not exist or was modified since base
+		if err := r.collection.FindOne(ctx, r.byID(id)).Decode(&order); err == mongo.ErrNoDocuments {
+			return order, ErrNotFound
+		} else if err != nil {
+			return order, err
+		}
+		return order, ErrConflict
+	}
+	return order, err
+}
+
+// eachOrder calls fn for every document matching filter, oldest first,
+// decoding one at a time from the cursor
+func eachOrder(ctx context.Context, collection *mongo.Collection, filter bson.M, fn func(contracts.Order) error) error {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var order contracts.Order
+		if err := cursor.Decode(&order); err != nil {
+			return err
+		}
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// findPage counts the documents matching filter and reads the requested page
+// of them, sorted in the database. Ties are broken by _id so pages never
+// overlap.
+func findPage(ctx context.Context, collection *mongo.Collection, filter bson.M, q PageQuery) (Page, error) {
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return Page{}, err
+	}
+
+	direction := 1
+	if q.Desc {
+		direction = -1
+	}
+	keys := bson.D{{Key: "created_at", Value: direction}, {Key: "_id", Value: direction}}
+	switch q.SortBy {
+	case SortTotalAmount:
+		keys = bson.D{{Key: "total_amount.amount", Value: direction}, {Key: "_id", Value: direction}}
+	case SortPriority:
+		// Standard orders have no priority_rank, which sorts below 1
+		keys = bson.D{{Key: "priority_rank", Value: direction}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}
+	}
+	opts := options.Find().
+		SetSort(keys).
+		SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return Page{}, err
+	}
+	defer cursor.Close(ctx)
+
+	page := Page{Orders: []contracts.Order{}, Total: total}
+	if err := cursor.All(ctx, &page.Orders); err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
@@ -0,0 +1,632 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ordering is the causal relation between two version vectors
+type Ordering int
+
+const (
+	// Equal vectors have seen the same writes
+	Equal Ordering = iota
+	// Before means the first vector is an ancestor of the second
+	Before
+	// After means the first vector descends from the second
+	After
+	// Concurrent vectors each saw writes the other did not
+	Concurrent
+)
+
+// CompareVersions returns how version vector a relates to b
+func CompareVersions(a, b map[string]int64) Ordering {
+	less, greater := false, false
+	for region, n := range a {
+		switch {
+		case n < b[region]:
+			less = true
+		case n > b[region]:
+			greater = true
+		}
+	}
+	for region, n := range b {
+		if _, ok := a[region]; !ok && n > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	default:
+		return Equal
+	}
+}
+
+// Resolve picks the surviving copy of an order written in several regions.
+// A copy whose version vector descends from the other's wins outright.
+// Between concurrent writes a deletion wins, then the last writer by
+// updated_at, and ties go to the copy that has seen more writes from the
+// order's home region, then to the higher status, so every region resolves
+// the same way. The winner's vector is merged with the loser's so the
+// result descends from both.
+func Resolve(a, b contracts.Order) contracts.Order {
+	winner, loser := a, b
+	switch CompareVersions(a.Versions, b.Versions) {
+	case Before:
+		winner, loser = b, a
+	case Concurrent:
+		home := a.Region
+		switch {
+		case (a.DeletedAt == nil) != (b.DeletedAt == nil):
+			if b.DeletedAt != nil {
+				winner, loser = b, a
+			}
+		case b.UpdatedAt.After(a.UpdatedAt):
+			winner, loser = b, a
+		case b.UpdatedAt.Equal(a.UpdatedAt) && b.Versions[home] > a.Versions[home]:
+			winner, loser = b, a
+		case b.UpdatedAt.Equal(a.UpdatedAt) && b.Versions[home] == a.Versions[home] && b.Status > a.Status:
+			winner, loser = b, a
+		}
+	}
+
+	merged := make(map[string]int64, len(winner.Versions))
+	for region, n := range loser.Versions {
+		merged[region] = n
+	}
+	for region, n := range winner.Versions {
+		if n > merged[region] {
+			merged[region] = n
+		}
+	}
+	winner.Versions = merged
+	return winner
+}
+
+// RegionalRepository runs document storage active-active across regions.
+// Writes always go to the local region's database; reads also query every
+// peer region and resolve divergent copies with Resolve. Each order records
+// its home region and a version vector counting writes per region.
+type RegionalRepository struct {
+	region string
+	local  *mongo.Collection
+	peers  map[string]*mongo.Collection
+
+	// PeerTimeout bounds each peer query; unreachable peers are skipped so
+	// a region outage degrades reads to local data instead of failing them
+	PeerTimeout time.Duration
+	// Clock times reconciliation passes
+	Clock clock.Clock
+}
+
+// NewRegionalRepository returns a repository writing to local as region and
+// reading from local plus the orders collections of peer regions
+func NewRegionalRepository(region string, local *mongo.Collection, peers map[string]*mongo.Collection) *RegionalRepository {
+	return &RegionalRepository{region: region, local: local, peers: peers, PeerTimeout: 2 * time.Second, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the updated_at index reconciliation scans by
+func (r *RegionalRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.local.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "updated_at", Value: 1}},
+	})
+	return err
+}
+
+// Create inserts the order in the local region, which becomes its home
+func (r *RegionalRepository) Create(ctx context.Context, order *contracts.Order) error {
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	if order.Region == "" {
+		order.Region = r.region
+	}
+	order.Versions = map[string]int64{r.region: 1}
+
+	_, err := r.local.InsertOne(ctx, order)
+	return err
+}
+
+// CreateMany inserts the orders in the local region in one unordered batch
+func (r *RegionalRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	docs := make([]interface{}, len(orders))
+	for i, order := range orders {
+		if order.ID.IsZero() {
+			order.ID = primitive.NewObjectID()
+		}
+		if order.Region == "" {
+			order.Region = r.region
+		}
+		order.Versions = map[string]int64{r.region: 1}
+		docs[i] = order
+	}
+	return insertMany(ctx, r.local, docs)
+}
+
+// FindByID returns the resolved copy of the order across all regions.
+// Copies are read whether or not they are deleted, so a deletion in one
+// region hides the order everywhere.
+func (r *RegionalRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	copies, err := r.query(ctx, bson.M{"_id": id})
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	if len(copies) == 0 {
+		return contracts.Order{}, ErrNotFound
+	}
+	order := copies[0]
+	for _, other := range copies[1:] {
+		order = Resolve(order, other)
+	}
+	if order.DeletedAt != nil {
+		return contracts.Order{}, ErrNotFound
+	}
+	return order, nil
+}
+
+// FindByUser returns the user's orders from all regions, one resolved copy
+// per order
+func (r *RegionalRepository) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	copies, err := r.query(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	orders := []contracts.Order{}
+	for _, order := range mergeCopies(copies) {
+		if order.DeletedAt == nil {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	return orders, nil
+}
+
+// EachByUser calls fn for each of the user's orders from all regions. Copies
+// have to be resolved against each other first, so unlike the other
+// repositories the orders are loaded together.
+func (r *RegionalRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	orders, err := r.FindByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindUserPage pages through the user's orders from all regions. Copies
+// have to be resolved before they can be counted, so the page is cut in
+// memory.
+func (r *RegionalRepository) FindUserPage(ctx context.Context, userID string, q PageQuery) (Page, error) {
+	orders, err := r.FindByUser(ctx, userID)
+	if err != nil {
+		return Page{}, err
+	}
+	return Paginate(orders, q), nil
+}
+
+// FindPage pages through the orders matching filter in any region. Copies
+// are resolved first and the filter applied again to the result, so the
+// page is cut in memory.
+func (r *RegionalRepository) FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (Page, error) {
+	// Deleted copies are read too, so a deletion in one region hides the
+	// order everywhere
+	selector := filter.query()
+	delete(selector, "deleted_at")
+	copies, err := r.query(ctx, selector)
+	if err != nil {
+		return Page{}, err
+	}
+	orders := []contracts.Order{}
+	for _, order := range mergeCopies(copies) {
+		if filter.Matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	return Paginate(orders, q), nil
+}
+
+// Search looks for the orders matching filter through the text index of
+// every region, then resolves their copies and scores them in memory, so
+// a copy matching in one region only counts if it is the current one
+func (r *RegionalRepository) Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (SearchPage, error) {
+	searched := words(text)
+	if len(searched) == 0 {
+		return SearchPage{Hits: []SearchHit{}}, nil
+	}
+	selector := filter.query()
+	delete(selector, "deleted_at")
+	selector["$text"] = bson.M{"$search": strings.Join(searched, " ")}
+	copies, err := r.query(ctx, selector)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	orders := []contracts.Order{}
+	for _, order := range mergeCopies(copies) {
+		if filter.Matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	return SearchOrders(orders, text, q), nil
+}
+
+// UpdateStatus applies the new status to the resolved order and writes it to
+// the local region, copying the order in if it was created elsewhere. The
+// write only applies if the local copy is unchanged since it was read;
+// otherwise it returns ErrConflict.
+func (r *RegionalRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, "", err
+	}
+
+	next := current
+	if err := change.Apply(&next); err != nil {
+		return current, "", err
+	}
+	if !change.Base.IsZero() && !current.UpdatedAt.Equal(change.Base) {
+		return current, "", ErrConflict
+	}
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, "", err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, "", err
+	}
+	return next, current.Status, nil
+}
+
+// UpdateItems resolves the current order across regions, applies the change
+// and stores it locally like UpdateStatus
+func (r *RegionalRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	next := current
+	if err := change.Apply(&next); err != nil {
+		return current, err
+	}
+	if !current.UpdatedAt.Equal(change.Base) {
+		return current, ErrConflict
+	}
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, err
+	}
+	return next, nil
+}
+
+// UpdatePriority resolves the current order across regions, sets the
+// priority and stores it locally like UpdateStatus
+func (r *RegionalRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+
+	next := current
+	if err := change.Apply(&next); err != nil {
+		return current, err
+	}
+	if !change.Base.IsZero() && !current.UpdatedAt.Equal(change.Base) {
+		return current, ErrConflict
+	}
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, err
+	}
+	return next, nil
+}
+
+// UpdateShipments resolves the current order across regions, applies the
+// change and stores it locally like UpdateStatus
+func (r *RegionalRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, "", err
+	}
+
+	next := current
+	if err := change.Apply(&next); err != nil {
+		return current, "", err
+	}
+	if !current.UpdatedAt.Equal(change.Base) {
+		return current, "", ErrConflict
+	}
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, "", err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, "", err
+	}
+	return next, current.Status, nil
+}
+
+// AddNote resolves the current order across regions, appends the note and
+// stores it locally like UpdateStatus
+func (r *RegionalRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+	if !change.Base.IsZero() && !current.UpdatedAt.Equal(change.Base) {
+		return current, ErrConflict
+	}
+
+	next := current
+	next.Notes = append([]contracts.Note(nil), current.Notes...)
+	if err := change.Apply(&next); err != nil {
+		return current, err
+	}
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, err
+	}
+	return next, nil
+}
+
+// Delete soft-deletes the resolved order and writes it to the local region
+// like UpdateStatus
+func (r *RegionalRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error) {
+	current, err := r.FindByID(ctx, id)
+	if err != nil {
+		return current, err
+	}
+	if !base.IsZero() && !current.UpdatedAt.Equal(base) {
+		return current, ErrConflict
+	}
+
+	next := current
+	next.DeletedAt = &at
+	next.UpdatedAt = at
+	next.Versions = r.bump(current.Versions)
+
+	local := contracts.Order{ID: id}
+	err = r.local.FindOne(ctx, bson.M{"_id": id}).Decode(&local)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return current, err
+	}
+	if err := r.replaceLocal(ctx, local, next); err != nil {
+		return current, err
+	}
+	return next, nil
+}
+
+// ReconcileResult counts what one reconciliation pass did
+type ReconcileResult struct {
+	Scanned int
+	Applied int
+}
+
+// Reconcile pulls orders that peers changed since the given time and stores
+// the resolved copy locally, so every region converges on the same state
+// even for orders it never read. Peers that cannot be reached are skipped
+// and picked up by the next pass.
+func (r *RegionalRepository) Reconcile(ctx context.Context, since time.Time) (ReconcileResult, error) {
+	var result ReconcileResult
+	for region, peer := range r.peers {
+		cursor, err := peer.Find(ctx, bson.M{"updated_at": bson.M{"$gte": since}})
+		if err != nil {
+			log.Warn().Err(err).Str("region", region).Msg("Failed to read peer region for reconciliation")
+			continue
+		}
+
+		for cursor.Next(ctx) {
+			var remote contracts.Order
+			if err := cursor.Decode(&remote); err != nil {
+				cursor.Close(ctx)
+				return result, err
+			}
+			result.Scanned++
+
+			applied, err := r.apply(ctx, remote)
+			if err != nil {
+				cursor.Close(ctx)
+				return result, err
+			}
+			if applied {
+				result.Applied++
+			}
+		}
+		err = cursor.Err()
+		cursor.Close(ctx)
+		if err != nil {
+			log.Warn().Err(err).Str("region", region).Msg("Peer region reconciliation interrupted")
+		}
+	}
+	return result, nil
+}
+
+// RunReconciler reconciles every interval until ctx is done. Each pass
+// overlaps the previous one by an interval to tolerate clock skew between
+// regions.
+func (r *RegionalRepository) RunReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	since := r.Clock.Now().Add(-time.Hour)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		started := r.Clock.Now()
+		result, err := r.Reconcile(ctx, since)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to reconcile peer regions")
+			continue
+		}
+		if result.Applied > 0 {
+			log.Info().Int("scanned", result.Scanned).Int("applied", result.Applied).Msg("Reconciled orders from peer regions")
+		}
+		since = started.Add(-interval)
+	}
+}
+
+// apply merges a peer's copy into the local one, reporting whether the
+// local copy changed
+func (r *RegionalRepository) apply(ctx context.Context, remote contracts.Order) (bool, error) {
+	var local contracts.Order
+	err := r.local.FindOne(ctx, bson.M{"_id": remote.ID}).Decode(&local)
+	if err == mongo.ErrNoDocuments {
+		_, err = r.local.InsertOne(ctx, remote)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch CompareVersions(local.Versions, remote.Versions) {
+	case Equal, After:
+		return false, nil
+	}
+	err = r.replaceLocal(ctx, local, Resolve(local, remote))
+	if err == ErrConflict {
+		// A local write won the race; the next pass sees both again
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// bump returns a copy of versions counting one more write in this region
+func (r *RegionalRepository) bump(versions map[string]int64) map[string]int64 {
+	next := make(map[string]int64, len(versions)+1)
+	for region, n := range versions {
+		next[region] = n
+	}
+	next[r.region]++
+	return next
+}
+
+// replaceLocal writes next over the local copy if it still carries the
+// version vector of base, inserting it if the region has no copy yet
+func (r *RegionalRepository) replaceLocal(ctx context.Context, base, next contracts.Order) error {
+	filter := bson.M{"_id": base.ID}
+	if len(base.Versions) == 0 {
+		filter["versions"] = bson.M{"$exists": false}
+	}
+	for region, n := range base.Versions {
+		filter["versions."+region] = n
+	}
+
+	_, err := r.local.ReplaceOne(ctx, filter, next, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	return err
+}
+
+// query runs filter against the local region and every peer concurrently
+func (r *RegionalRepository) query(ctx context.Context, filter bson.M) ([]contracts.Order, error) {
+	var (
+		mu     sync.Mutex
+		copies []contracts.Order
+		wg     sync.WaitGroup
+	)
+	for region, peer := range r.peers {
+		wg.Add(1)
+		go func(region string, peer *mongo.Collection) {
+			defer wg.Done()
+			peerCtx, cancel := context.WithTimeout(ctx, r.PeerTimeout)
+			defer cancel()
+
+			found, err := find(peerCtx, peer, filter)
+			if err != nil {
+				log.Warn().Err(err).Str("region", region).Msg("Peer region unavailable, reading local orders only")
+				return
+			}
+			mu.Lock()
+			copies = append(copies, found...)
+			mu.Unlock()
+		}(region, peer)
+	}
+
+	local, err := find(ctx, r.local, filter)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return append(local, copies...), nil
+}
+
+func find(ctx context.Context, collection *mongo.Collection, filter bson.M) ([]contracts.Order, error) {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var orders []contracts.Order
+	err = cursor.All(ctx, &orders)
+	return orders, err
+}
+
+// mergeCopies resolves copies of the same order into one, keeping the order
+// in which orders were first seen
+func mergeCopies(copies []contracts.Order) []contracts.Order {
+	index := map[primitive.ObjectID]int{}
+	var orders []contracts.Order
+	for _, order := range copies {
+		if i, ok := index[order.ID]; ok {
+			orders[i] = Resolve(orders[i], order)
+			continue
+		}
+		index[order.ID] = len(orders)
+		orders = append(orders, order)
+	}
+	return orders
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type replicaReadsKey struct{}
+
+// WithReplicaReads returns ctx marking the reads made with it as allowed to
+// be served by a replica, with the read preference and concern configured
+// for them. Only requests that change nothing are marked: writes, and the
+// reads they are checked against, stay on the primary.
+func WithReplicaReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replicaReadsKey{}, true)
+}
+
+// reader returns replicas for the reads of ctx marked by WithReplicaReads,
+// when configured, and primary otherwise
+func reader(ctx context.Context, primary, replicas *mongo.Collection) *mongo.Collection {
+	if replicas != nil {
+		if marked, _ := ctx.Value(replicaReadsKey{}).(bool); marked {
+			return replicas
+		}
+	}
+	return primary
+}
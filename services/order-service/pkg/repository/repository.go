@@ -0,0 +1,291 @@
+// Package repository provides persistence for orders.
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"order-service/pkg/contracts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrNotFound is returned when an order does not exist
+var ErrNotFound = errors.New("order not found")
+
+// ErrConflict is returned when a concurrent write changed the order first
+var ErrConflict = errors.New("order was modified concurrently")
+
+// OrderRepository stores and retrieves orders
+type OrderRepository interface {
+	// Create persists a new order, assigning its ID if unset
+	Create(ctx context.Context, order *contracts.Order) error
+	// CreateMany persists several new orders at once, assigning their IDs.
+	// The returned slice holds the outcome of each order, nil once stored;
+	// one order failing does not stop the others.
+	CreateMany(ctx context.Context, orders []*contracts.Order) []error
+	// FindByID returns the order with the given document ID
+	FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error)
+	// FindByUser returns all orders placed by a user
+	FindByUser(ctx context.Context, userID string) ([]contracts.Order, error)
+	// EachByUser calls fn for every order placed by a user, oldest first,
+	// stopping at the first error. Orders are streamed rather than loaded
+	// together wherever the storage allows.
+	EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error
+	// FindUserPage returns one sorted page of a user's orders
+	FindUserPage(ctx context.Context, userID string, q PageQuery) (Page, error)
+	// FindPage returns one sorted page of the orders matching filter
+	FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (Page, error)
+	// Search returns one page of the orders matching filter whose order ID,
+	// item names or shipping address contain any of the words of text,
+	// most relevant first, highlighted. Only the limit and offset of q are
+	// used.
+	Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (SearchPage, error)
+	// UpdateStatus applies the status change and returns the updated order
+	// together with its previous status. A change the order's current status
+	// does not allow fails with a *contracts.TransitionError, and one whose
+	// Base no longer matches with ErrConflict, both checked atomically with
+	// the write.
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error)
+	// UpdateItems replaces the items of a pending order and returns the
+	// updated order. It fails with contracts.ErrNotEditable once the order
+	// has left pending, and with ErrConflict if it changed since
+	// change.Base.
+	UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error)
+	// UpdateShipments replaces the shipments of a confirmed or shipped
+	// order, moving it to the status derived from them, and returns the
+	// updated order together with its previous status. It fails with
+	// contracts.ErrNotShippable once the order is in another status, with a
+	// *contracts.TransitionError if the derived status is not allowed from
+	// the current one, and with ErrConflict if it changed since
+	// change.Base.
+	UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error)
+	// UpdatePriority sets the priority of a pending or confirmed order and
+	// returns the updated order. It fails with contracts.ErrPriorityLocked
+	// once the order is in another status, and with ErrConflict if it
+	// changed since a non-zero change.Base.
+	UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error)
+	// AddNote appends a note to the order and returns the updated order. It
+	// fails with contracts.ErrTooManyNotes once the order has
+	// contracts.MaxNotes, and with ErrConflict if it changed since a
+	// non-zero change.Base.
+	AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error)
+	// Delete soft-deletes the order at the given time and returns it. Every
+	// other method treats deleted orders as missing. A non-zero base is the
+	// UpdatedAt the order must still have, or the delete fails with
+	// ErrConflict.
+	Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error)
+}
+
+// insertMany inserts documents unordered and reports the outcome of each.
+// A failure that is not tied to one document fails every one of them.
+func insertMany(ctx context.Context, collection *mongo.Collection, docs []interface{}) []error {
+	errs := make([]error, len(docs))
+	_, err := collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+
+	var bwe mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &bwe) && bwe.WriteConcernError == nil && len(bwe.WriteErrors) > 0:
+		for _, we := range bwe.WriteErrors {
+			errs[we.Index] = we
+		}
+	default:
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+// unchangedSince narrows filter to orders last updated at base, unless base
+// is zero
+func unchangedSince(filter bson.M, base time.Time) bson.M {
+	if !base.IsZero() {
+		filter["updated_at"] = base
+	}
+	return filter
+}
+
+// notDeleted narrows filter to orders that have not been soft-deleted
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// Fields a page of orders can be sorted by
+const (
+	SortCreatedAt   = "created_at"
+	SortTotalAmount = "total_amount"
+	// SortPriority sorts by urgency; orders of the same priority always
+	// come oldest first, so the most urgent page is a work queue
+	SortPriority = "priority"
+)
+
+// PageQuery selects one page of orders
+type PageQuery struct {
+	Limit  int
+	Offset int
+	// SortBy is SortCreatedAt, SortTotalAmount or SortPriority, ascending
+	// unless Desc. Totals sort by minor units regardless of currency.
+	SortBy string
+	Desc   bool
+}
+
+// OrderFilter selects orders to list. Zero fields match every order.
+type OrderFilter struct {
+	// OrderID matches the order with that public order_id
+	OrderID  string
+	Statuses []string
+	UserID   string
+	// TenantID restricts orders to one tenant's
+	TenantID string
+	// ProductID matches orders with an item of that product
+	ProductID string
+	// From and To bound created_at, inclusive and exclusive
+	From time.Time
+	To   time.Time
+	// Currency restricts orders to totals in that currency, which MinTotal
+	// and MaxTotal are then given in, as inclusive minor-unit bounds
+	Currency string
+	MinTotal *int64
+	MaxTotal *int64
+}
+
+// query returns the MongoDB filter selecting the same orders as Matches,
+// deleted ones excluded
+func (f OrderFilter) query() bson.M {
+	filter := bson.M{}
+	if f.OrderID != "" {
+		filter["order_id"] = f.OrderID
+	}
+	if len(f.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": f.Statuses}
+	}
+	if f.UserID != "" {
+		filter["user_id"] = f.UserID
+	}
+	if f.TenantID != "" {
+		filter["tenant_id"] = f.TenantID
+	}
+	if f.ProductID != "" {
+		filter["items.product_id"] = f.ProductID
+	}
+	created := bson.M{}
+	if !f.From.IsZero() {
+		created["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		created["$lt"] = f.To
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+	if f.Currency != "" {
+		filter["total_amount.currency"] = f.Currency
+	}
+	amount := bson.M{}
+	if f.MinTotal != nil {
+		amount["$gte"] = *f.MinTotal
+	}
+	if f.MaxTotal != nil {
+		amount["$lte"] = *f.MaxTotal
+	}
+	if len(amount) > 0 {
+		filter["total_amount.amount"] = amount
+	}
+	return notDeleted(filter)
+}
+
+// Matches reports whether order is selected by the filter, for
+// repositories that filter in memory
+func (f OrderFilter) Matches(order contracts.Order) bool {
+	if order.DeletedAt != nil {
+		return false
+	}
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			found = found || order.Status == status
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.ProductID != "" {
+		found := false
+		for _, item := range order.Items {
+			found = found || item.ProductID == f.ProductID
+		}
+		if !found {
+			return false
+		}
+	}
+	switch {
+	case f.OrderID != "" && order.OrderID != f.OrderID:
+		return false
+	case f.UserID != "" && order.UserID != f.UserID:
+		return false
+	case f.TenantID != "" && order.TenantID != f.TenantID:
+		return false
+	case !f.From.IsZero() && order.CreatedAt.Before(f.From):
+		return false
+	case !f.To.IsZero() && !order.CreatedAt.Before(f.To):
+		return false
+	case f.Currency != "" && order.TotalAmount.Currency != f.Currency:
+		return false
+	case f.MinTotal != nil && order.TotalAmount.Amount < *f.MinTotal:
+		return false
+	case f.MaxTotal != nil && order.TotalAmount.Amount > *f.MaxTotal:
+		return false
+	}
+	return true
+}
+
+// Page is one page of orders and the number of orders across all pages
+type Page struct {
+	Orders []contracts.Order
+	Total  int64
+}
+
+// Paginate sorts orders in memory and cuts the requested page from them,
+// for repositories that cannot sort in the database
+func Paginate(orders []contracts.Order, q PageQuery) Page {
+	less := func(a, b contracts.Order) bool {
+		if q.SortBy == SortTotalAmount && a.TotalAmount.Amount != b.TotalAmount.Amount {
+			return a.TotalAmount.Amount < b.TotalAmount.Amount
+		}
+		if q.SortBy == SortPriority && a.PriorityRank != b.PriorityRank {
+			return a.PriorityRank < b.PriorityRank
+		}
+		if q.SortBy != SortTotalAmount && !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	}
+	sorted := append([]contracts.Order(nil), orders...)
+	sort.Slice(sorted, func(i, j int) bool {
+		// Orders of the same priority stay oldest first either way
+		tied := q.SortBy == SortPriority && sorted[i].PriorityRank == sorted[j].PriorityRank
+		if q.Desc && !tied {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+
+	page := Page{Orders: []contracts.Order{}, Total: int64(len(sorted))}
+	if q.Offset >= len(sorted) {
+		return page
+	}
+	end := len(sorted)
+	if q.Limit > 0 && q.Offset+q.Limit < end {
+		end = q.Offset + q.Limit
+	}
+	page.Orders = sorted[q.Offset:end]
+	return page
+}
@@ -0,0 +1,224 @@
+package repository
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/dbindex"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SearchHit is an order found by a text search
+type SearchHit struct {
+	Order contracts.Order
+	// Score is the relevance of the order; hits are sorted by it
+	Score float64
+	// Highlights holds, by field, the values containing words searched
+	// for, HTML-escaped, with those words wrapped in <em>
+	Highlights map[string][]string
+}
+
+// SearchPage is one page of search hits and the number of hits across all
+// pages
+type SearchPage struct {
+	Hits  []SearchHit
+	Total int64
+}
+
+// searchField is an order field text searches look in
+type searchField struct {
+	path   string
+	weight int
+	values func(contracts.Order) []string
+}
+
+// searchFields are the fields of the orders text index, weighted so an
+// order ID outranks an item name, which outranks an address. The name and
+// street lines of the address are left out: they are personal data, stored
+// encrypted with contracts.EncryptedFields, and a text index would keep
+// their words in plaintext.
+var searchFields = []searchField{
+	{"order_id", 10, func(o contracts.Order) []string { return []string{o.OrderID} }},
+	{"items.name", 5, func(o contracts.Order) []string {
+		names := make([]string, 0, len(o.Items))
+		for _, item := range o.Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}},
+	{"shipping_address.city", 1, address(func(a *contracts.Address) string { return a.City })},
+	{"shipping_address.region", 1, address(func(a *contracts.Address) string { return a.Region })},
+	{"shipping_address.postal_code", 1, address(func(a *contracts.Address) string { return a.PostalCode })},
+	{"shipping_address.country", 1, address(func(a *contracts.Address) string { return a.Country })},
+}
+
+func address(field func(*contracts.Address) string) func(contracts.Order) []string {
+	return func(o contracts.Order) []string {
+		if o.ShippingAddress == nil {
+			return nil
+		}
+		return []string{field(o.ShippingAddress)}
+	}
+}
+
+// textIndex is the orders text index behind Search. Words are matched
+// whole and without stemming, as order IDs, item names and addresses are in
+// no one language.
+func textIndex() dbindex.Index {
+	index := dbindex.Index{Language: "none"}
+	for _, field := range searchFields {
+		index.Keys = append(index.Keys, bson.E{Key: field.path, Value: "text"})
+		index.Weights = append(index.Weights, bson.E{Key: field.path, Value: int32(field.weight)})
+	}
+	return index
+}
+
+// words splits text into the lowercase words a text search matches
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Highlight returns the values of the searched fields of order containing
+// any of the words of text, HTML-escaped, with those words wrapped in
+// <em>, and a score weighing each word found by its field
+func Highlight(order contracts.Order, text string) (map[string][]string, float64) {
+	searched := map[string]bool{}
+	for _, word := range words(text) {
+		searched[word] = true
+	}
+	highlights := map[string][]string{}
+	score := 0.0
+	for _, field := range searchFields {
+		for _, value := range field.values(order) {
+			marked, found := mark(value, searched)
+			if found > 0 {
+				highlights[field.path] = append(highlights[field.path], marked)
+				score += float64(found * field.weight)
+			}
+		}
+	}
+	return highlights, score
+}
+
+// mark wraps the words of value in searched in <em>, escaping the rest,
+// and counts them
+func mark(value string, searched map[string]bool) (string, int) {
+	var b strings.Builder
+	found := 0
+	runes := []rune(value)
+	for i := 0; i < len(runes); {
+		j := i
+		for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+			j++
+		}
+		if j == i {
+			b.WriteString(html.EscapeString(string(runes[i])))
+			i++
+			continue
+		}
+		word := string(runes[i:j])
+		if searched[strings.ToLower(word)] {
+			b.WriteString("<em>" + html.EscapeString(word) + "</em>")
+			found++
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		i = j
+	}
+	return b.String(), found
+}
+
+// SearchOrders scores and highlights orders matched in memory, for
+// repositories that cannot search in the database, and cuts the requested
+// page from them, most relevant first. Orders containing none of the words
+// of text are left out.
+func SearchOrders(orders []contracts.Order, text string, q PageQuery) SearchPage {
+	hits := []SearchHit{}
+	for _, order := range orders {
+		if highlights, score := Highlight(order, text); score > 0 {
+			hits = append(hits, SearchHit{Order: order, Score: score, Highlights: highlights})
+		}
+	}
+	sortHits(hits)
+	page := SearchPage{Total: int64(len(hits)), Hits: []SearchHit{}}
+	if q.Offset < len(hits) {
+		hits = hits[q.Offset:]
+		if q.Limit > 0 && q.Limit < len(hits) {
+			hits = hits[:q.Limit]
+		}
+		page.Hits = hits
+	}
+	return page
+}
+
+// sortHits orders hits most relevant first, then newest first
+func sortHits(hits []SearchHit) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if !a.Order.CreatedAt.Equal(b.Order.CreatedAt) {
+			return a.Order.CreatedAt.After(b.Order.CreatedAt)
+		}
+		return a.Order.ID.Hex() > b.Order.ID.Hex()
+	})
+}
+
+// searchPage reads one page of the orders of collection matching filter
+// and the text index search of text, most relevant first by MongoDB's
+// text score. Only the limit and offset of q are used.
+func searchPage(ctx context.Context, collection *mongo.Collection, text string, filter bson.M, q PageQuery) (SearchPage, error) {
+	searched := words(text)
+	if len(searched) == 0 {
+		return SearchPage{Hits: []SearchHit{}}, nil
+	}
+	// Words are searched for as words only, not as the phrases and
+	// negations of MongoDB's search syntax
+	filter["$text"] = bson.M{"$search": strings.Join(searched, " ")}
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return SearchPage{}, err
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"text_score": score}).
+		SetSort(bson.D{{Key: "text_score", Value: score}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(int64(q.Offset))
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return SearchPage{}, err
+	}
+	defer cursor.Close(ctx)
+
+	page := SearchPage{Hits: []SearchHit{}, Total: total}
+	for cursor.Next(ctx) {
+		var hit SearchHit
+		var scored struct {
+			Score float64 `bson:"text_score"`
+		}
+		if err := cursor.Decode(&hit.Order); err != nil {
+			return SearchPage{}, err
+		}
+		if err := cursor.Decode(&scored); err != nil {
+			return SearchPage{}, err
+		}
+		hit.Score = scored.Score
+		hit.Highlights, _ = Highlight(hit.Order, text)
+		page.Hits = append(page.Hits, hit)
+	}
+	return page, cursor.Err()
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+
+	"order-service/pkg/contracts"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var broadcastQueries = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "orders_broadcast_queries_total",
+		Help: "Queries of the sharded orders collection sent to every shard, by operation",
+	},
+	[]string{"operation"},
+)
+
+func init() {
+	prometheus.MustRegister(broadcastQueries)
+}
+
+// shardKeyFields are the order fields a shard key may use: those never
+// changed once an order is created, as a document cannot move between
+// shards on update
+var shardKeyFields = map[string]func(contracts.Order) interface{}{
+	"_id":        func(o contracts.Order) interface{} { return o.ID },
+	"order_id":   func(o contracts.Order) interface{} { return o.OrderID },
+	"user_id":    func(o contracts.Order) interface{} { return o.UserID },
+	"created_at": func(o contracts.Order) interface{} { return o.CreatedAt },
+	// Orders without a tenant have no tenant_id, which the shard key holds
+	// as null
+	"tenant_id": func(o contracts.Order) interface{} {
+		if o.TenantID == "" {
+			return nil
+		}
+		return o.TenantID
+	},
+}
+
+// shardKeyCacheSize bounds the orders whose shard key is remembered
+const shardKeyCacheSize = 10000
+
+// ShardKey targets the queries of a sharded orders collection at the shard
+// holding each order, rather than sending them to every shard. Listings
+// are targeted by their filter, when it fixes the key's first field, while
+// an order is only known by its ID to most methods: the key of every order
+// read or written is remembered, so reading an order sends the next query
+// on it to its shard, and an order not seen yet is read from every shard
+// once. Methods on a nil *ShardKey leave queries as they are, for an
+// unsharded collection.
+type ShardKey struct {
+	fields []string
+
+	mu    sync.Mutex
+	known map[primitive.ObjectID]bson.M
+	// ring evicts remembered keys oldest first
+	ring []primitive.ObjectID
+	next int
+}
+
+// NewShardKey returns the shard key made of fields, in order, such as
+// user_id and created_at. Only fields orders never change are allowed.
+func NewShardKey(fields ...string) (*ShardKey, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("shard key has no field")
+	}
+	seen := map[string]bool{}
+	for _, field := range fields {
+		if shardKeyFields[field] == nil {
+			return nil, fmt.Errorf("%q cannot be part of the shard key; use _id, order_id, user_id, tenant_id or created_at", field)
+		}
+		if seen[field] {
+			return nil, fmt.Errorf("%q is in the shard key twice", field)
+		}
+		seen[field] = true
+	}
+	return &ShardKey{fields: fields, known: map[primitive.ObjectID]bson.M{}}, nil
+}
+
+// Observe remembers the shard key of orders
+func (k *ShardKey) Observe(orders ...contracts.Order) {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, order := range orders {
+		if order.ID.IsZero() {
+			continue
+		}
+		values := bson.M{}
+		for _, field := range k.fields {
+			values[field] = shardKeyFields[field](order)
+		}
+		if _, ok := k.known[order.ID]; !ok {
+			if len(k.ring) < shardKeyCacheSize {
+				k.ring = append(k.ring, order.ID)
+			} else {
+				delete(k.known, k.ring[k.next])
+				k.ring[k.next] = order.ID
+				k.next = (k.next + 1) % shardKeyCacheSize
+			}
+		}
+		k.known[order.ID] = values
+	}
+}
+
+// Known reports whether the shard key of order id is remembered
+func (k *ShardKey) Known(id primitive.ObjectID) bool {
+	if k == nil {
+		return true
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	_, ok := k.known[id]
+	return ok
+}
+
+// Target adds the shard key of order id to filter, if remembered, and
+// returns it. Fields filter already constrains are left as they are. The
+// query is counted as a broadcast under operation otherwise.
+func (k *ShardKey) Target(operation string, filter bson.M, id primitive.ObjectID) bson.M {
+	if k == nil {
+		return filter
+	}
+	k.mu.Lock()
+	values, ok := k.known[id]
+	k.mu.Unlock()
+	if !ok {
+		broadcastQueries.WithLabelValues(operation).Inc()
+		return filter
+	}
+	for field, value := range values {
+		if _, ok := filter[field]; !ok {
+			filter[field] = value
+		}
+	}
+	return filter
+}
+
+// Check counts a listing by filter as a broadcast under operation unless
+// the filter fixes the first field of the key, which confines it to the
+// shards holding that value
+func (k *ShardKey) Check(operation string, filter bson.M) {
+	if k == nil {
+		return
+	}
+	value, ok := filter[k.fields[0]]
+	if !ok {
+		broadcastQueries.WithLabelValues(operation).Inc()
+		return
+	}
+	// A document of operators is a range or a set, not one value
+	if doc, isDoc := value.(bson.M); isDoc && len(doc) > 0 {
+		broadcastQueries.WithLabelValues(operation).Inc()
+	}
+}
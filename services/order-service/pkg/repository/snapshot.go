@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Snapshot is a materialized aggregate state at a given stream version.
+// Schema fingerprints the Order type so snapshots written by an older model
+// are discarded automatically instead of being decoded into the new one.
+type Snapshot struct {
+	AggregateID string          `bson:"_id"`
+	Version     int             `bson:"version"`
+	Schema      string          `bson:"schema"`
+	State       contracts.Order `bson:"state"`
+	TakenAt     time.Time       `bson:"taken_at"`
+}
+
+// snapshotSchema is computed once from the Order type definition
+var snapshotSchema = schemaFingerprint(reflect.TypeOf(contracts.Order{}))
+
+// EnableSnapshots stores a snapshot every `every` events so long streams
+// (e.g. B2B orders with thousands of events) rehydrate from the latest
+// snapshot plus a short tail instead of from the beginning
+func (r *EventSourcedRepository) EnableSnapshots(collection *mongo.Collection, every int) {
+	r.snapshots = collection
+	r.snapshotEvery = every
+}
+
+// loadSnapshot returns the latest usable snapshot, or nil. Snapshots with a
+// stale schema are deleted.
+func (r *EventSourcedRepository) loadSnapshot(ctx context.Context, orderID string) (*Snapshot, error) {
+	if r.snapshots == nil {
+		return nil, nil
+	}
+
+	var snapshot Snapshot
+	err := r.snapshots.FindOne(ctx, bson.M{"_id": orderID}).Decode(&snapshot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if snapshot.Schema != snapshotSchema {
+		if _, err := r.snapshots.DeleteOne(ctx, bson.M{"_id": orderID, "schema": snapshot.Schema}); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	return &snapshot, nil
+}
+
+// maybeSnapshot saves a snapshot when the append crossed a snapshot boundary
+func (r *EventSourcedRepository) maybeSnapshot(ctx context.Context, state contracts.Order, fromVersion, toVersion int) error {
+	if r.snapshots == nil || r.snapshotEvery <= 0 || fromVersion/r.snapshotEvery == toVersion/r.snapshotEvery {
+		return nil
+	}
+
+	// Never overwrite a newer snapshot written by a concurrent writer
+	filter := bson.M{"_id": state.OrderID, "version": bson.M{"$lt": toVersion}}
+	snapshot := Snapshot{
+		AggregateID: state.OrderID,
+		Version:     toVersion,
+		Schema:      snapshotSchema,
+		State:       state,
+		TakenAt:     r.Clock.Now(),
+	}
+	_, err := r.snapshots.ReplaceOne(ctx, filter, snapshot, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// InvalidateSnapshots removes every stored snapshot, forcing full rehydration
+func (r *EventSourcedRepository) InvalidateSnapshots(ctx context.Context) (int64, error) {
+	if r.snapshots == nil {
+		return 0, nil
+	}
+	result, err := r.snapshots.DeleteMany(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func schemaFingerprint(t reflect.Type) string {
+	var b strings.Builder
+	describeType(&b, t, map[reflect.Type]bool{})
+	h := fnv.New64a()
+	h.Write([]byte(b.String()))
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+func describeType(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Struct:
+		b.WriteString(t.String())
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			fmt.Fprintf(b, "%s %s;", f.Name, f.Tag.Get("bson"))
+			describeType(b, f.Type, seen)
+		}
+		b.WriteString("}")
+	case reflect.Slice, reflect.Array, reflect.Ptr:
+		b.WriteString(t.Kind().String())
+		describeType(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		describeType(b, t.Key(), seen)
+		b.WriteString("]")
+		describeType(b, t.Elem(), seen)
+	default:
+		b.WriteString(t.String())
+	}
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TenantRepository isolates the orders of each tenant, taken from the
+// context of every call. New orders are stamped with the tenant, listings
+// are filtered by it in the database, and an order of another tenant is
+// ErrNotFound to every other method, checked before it is changed.
+// Tenants with a dedicated repository, in their own database or
+// collection, are served from it; every other tenant shares one. Calls
+// without a tenant, such as those of background jobs, go to the shared
+// repository across tenants.
+type TenantRepository struct {
+	shared    OrderRepository
+	dedicated map[string]OrderRepository
+}
+
+// tenantUserBatch is how many orders of a user EachByUser reads at a time
+const tenantUserBatch = 500
+
+// NewTenantRepository returns a repository serving the tenants listed in
+// dedicated from their own repository and every other one from shared
+func NewTenantRepository(shared OrderRepository, dedicated map[string]OrderRepository) *TenantRepository {
+	return &TenantRepository{shared: shared, dedicated: dedicated}
+}
+
+// of returns the repository and tenant of ctx
+func (r *TenantRepository) of(ctx context.Context) (OrderRepository, string) {
+	id := tenant.FromContext(ctx)
+	if repo, ok := r.dedicated[id]; ok {
+		return repo, id
+	}
+	return r.shared, id
+}
+
+// owned returns the repository of ctx if the order belongs to its tenant
+func (r *TenantRepository) owned(ctx context.Context, id primitive.ObjectID) (OrderRepository, error) {
+	repo, tenantID := r.of(ctx)
+	if tenantID == "" {
+		return repo, nil
+	}
+	order, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if order.TenantID != tenantID {
+		return nil, ErrNotFound
+	}
+	return repo, nil
+}
+
+func (r *TenantRepository) Create(ctx context.Context, order *contracts.Order) error {
+	repo, tenantID := r.of(ctx)
+	if tenantID != "" {
+		order.TenantID = tenantID
+	}
+	return repo.Create(ctx, order)
+}
+
+func (r *TenantRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	repo, tenantID := r.of(ctx)
+	if tenantID != "" {
+		for _, order := range orders {
+			order.TenantID = tenantID
+		}
+	}
+	return repo.CreateMany(ctx, orders)
+}
+
+func (r *TenantRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	repo, tenantID := r.of(ctx)
+	order, err := repo.FindByID(ctx, id)
+	if err == nil && tenantID != "" && order.TenantID != tenantID {
+		return contracts.Order{}, ErrNotFound
+	}
+	return order, err
+}
+
+// FindByUser filters by tenant in the database, through FindPage
+func (r *TenantRepository) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	repo, tenantID := r.of(ctx)
+	if tenantID == "" {
+		return repo.FindByUser(ctx, userID)
+	}
+	page, err := repo.FindPage(ctx, OrderFilter{UserID: userID, TenantID: tenantID}, PageQuery{SortBy: SortCreatedAt})
+	return page.Orders, err
+}
+
+// EachByUser filters by tenant in the database, reading pages of
+// tenantUserBatch orders through FindPage, oldest first. Orders placed
+// meanwhile come last, so pages do not shift under them.
+func (r *TenantRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	repo, tenantID := r.of(ctx)
+	if tenantID == "" {
+		return repo.EachByUser(ctx, userID, fn)
+	}
+	filter := OrderFilter{UserID: userID, TenantID: tenantID}
+	for offset := 0; ; offset += tenantUserBatch {
+		page, err := repo.FindPage(ctx, filter, PageQuery{Limit: tenantUserBatch, Offset: offset, SortBy: SortCreatedAt})
+		if err != nil {
+			return err
+		}
+		for _, order := range page.Orders {
+			if err := fn(order); err != nil {
+				return err
+			}
+		}
+		if len(page.Orders) < tenantUserBatch {
+			return nil
+		}
+	}
+}
+
+// FindUserPage filters by tenant in the database, through FindPage
+func (r *TenantRepository) FindUserPage(ctx context.Context, userID string, q PageQuery) (Page, error) {
+	repo, tenantID := r.of(ctx)
+	if tenantID == "" {
+		return repo.FindUserPage(ctx, userID, q)
+	}
+	return repo.FindPage(ctx, OrderFilter{UserID: userID, TenantID: tenantID}, q)
+}
+
+func (r *TenantRepository) FindPage(ctx context.Context, filter OrderFilter, q PageQuery) (Page, error) {
+	repo, tenantID := r.of(ctx)
+	if tenantID != "" {
+		filter.TenantID = tenantID
+	}
+	return repo.FindPage(ctx, filter, q)
+}
+
+func (r *TenantRepository) Search(ctx context.Context, text string, filter OrderFilter, q PageQuery) (SearchPage, error) {
+	repo, tenantID := r.of(ctx)
+	if tenantID != "" {
+		filter.TenantID = tenantID
+	}
+	return repo.Search(ctx, text, filter, q)
+}
+
+func (r *TenantRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, "", err
+	}
+	return repo.UpdateStatus(ctx, id, change)
+}
+
+func (r *TenantRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	return repo.UpdateItems(ctx, id, change)
+}
+
+func (r *TenantRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, "", err
+	}
+	return repo.UpdateShipments(ctx, id, change)
+}
+
+func (r *TenantRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	return repo.UpdatePriority(ctx, id, change)
+}
+
+func (r *TenantRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	return repo.AddNote(ctx, id, change)
+}
+
+func (r *TenantRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error) {
+	repo, err := r.owned(ctx, id)
+	if err != nil {
+		return contracts.Order{}, err
+	}
+	return repo.Delete(ctx, id, at, base)
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Transactions runs groups of writes, such as an order change and the
+// events recording it, in MongoDB transactions so they are applied all
+// together or not at all. Standalone servers have no transactions, which
+// Atomic reports: their groups run write by write, as do those of a nil
+// *Transactions.
+type Transactions struct {
+	client *mongo.Client
+
+	mu     sync.Mutex
+	probed bool
+	atomic bool
+}
+
+// NewTransactions returns transactions on client's deployment
+func NewTransactions(client *mongo.Client) *Transactions {
+	return &Transactions{client: client}
+}
+
+// Atomic reports whether groups run in transactions, which replica sets
+// and sharded clusters support. The server is asked once; a failure to
+// ask reports false and asks again next time.
+func (t *Transactions) Atomic(ctx context.Context) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.probed {
+		var hello struct {
+			SetName string `bson:"setName"`
+			Msg     string `bson:"msg"`
+		}
+		if err := t.client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msg("Failed to ask MongoDB whether it supports transactions")
+			return false
+		}
+		t.probed = true
+		t.atomic = hello.SetName != "" || hello.Msg == "isdbgrid"
+		if !t.atomic {
+			log.Warn().Msg("MongoDB is a standalone server without transactions; multi-document writes are not atomic")
+		}
+	}
+	return t.atomic
+}
+
+// Run calls fn with a context carrying a transaction, committed when fn
+// returns nil and aborted otherwise, and returns fn's error as is. fn is
+// called again when the transaction fails transiently, such as on a write
+// conflict, so it must only write through the context it is given. Within
+// another transaction fn joins it, and without transactions fn is called
+// with ctx.
+func (t *Transactions) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mongo.SessionFromContext(ctx) != nil || !t.Atomic(ctx) {
+		return fn(ctx)
+	}
+	session, err := t.client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
@@ -0,0 +1,72 @@
+// Package requestid carries the ID of the request being served through
+// contexts, so the log entries, outbound calls and published events of one
+// request can be joined across services.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Header carries the request ID on HTTP calls, gRPC metadata and published
+// events
+const Header = "X-Request-ID"
+
+// maxLength bounds the IDs accepted from callers
+const maxLength = 128
+
+type key struct{}
+
+func init() {
+	// log.Ctx on a context without a request logger logs like log.Logger
+	zerolog.DefaultContextLogger = &log.Logger
+}
+
+// Valid reports whether an ID received from a caller may be kept: not
+// empty, at most 128 characters and printable ASCII, so it cannot forge
+// log lines or headers
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Accept returns id if it is Valid, or a new ID
+func Accept(id string) string {
+	if Valid(id) {
+		return id
+	}
+	return uuid.New().String()
+}
+
+// NewContext returns ctx carrying id, with a logger adding it to every
+// entry written through log.Ctx as request_id
+func NewContext(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, key{}, id)
+	logger := log.With().Str("request_id", id).Logger()
+	return logger.WithContext(ctx)
+}
+
+// FromContext returns the request ID ctx carries, or ""
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
+
+// Detach returns a context carrying the request ID of ctx without its
+// deadline and cancellation, for work that outlives the request
+func Detach(ctx context.Context) context.Context {
+	if id := FromContext(ctx); id != "" {
+		return NewContext(context.Background(), id)
+	}
+	return context.Background()
+}
@@ -0,0 +1,342 @@
+// Package retention anonymizes orders once they are older than the retention
+// window. Personal data on an aged order is replaced with irreversible tokens
+// while amounts, items and statuses are kept, so analytics still add up but
+// the order can no longer be tied back to a person.
+package retention
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+	"order-service/pkg/tenant"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TokenPrefix marks values that have already been anonymized
+const TokenPrefix = "anon_"
+
+// DefaultPIIFields are the order fields holding personal data. The city,
+// region, postal code and country of the shipping address are kept for
+// regional analytics.
+var DefaultPIIFields = []string{"user_id", "guest_email", "shipping_address.name", "shipping_address.line1", "shipping_address.line2"}
+
+// DefaultActorFields are the order fields naming who made a change. Where
+// they name the order's own user they are replaced with the user_id token;
+// other actors, such as staff, are kept.
+var DefaultActorFields = []string{"status_history[].actor_id", "notes[].author_id"}
+
+// Token returns the irreversible token replacing value. The same value always
+// maps to the same token under one key, so per-customer aggregates survive
+// anonymization without the customer being recoverable.
+func Token(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return TokenPrefix + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Copy is a collection holding copies of order fields, such as stored events
+// or read models, that must be anonymized along with the order
+type Copy struct {
+	Collection *mongo.Collection
+	// OrderIDField is the field holding the order's order_id
+	OrderIDField string
+	// Match narrows which of the order's documents hold the fields
+	Match bson.M
+	// Fields maps a path in the copy to the order field it duplicates
+	Fields map[string]string
+	// Actors are paths in the copy naming who made a change, tokenized like
+	// the order's actor fields. A [] segment addresses the elements of an
+	// array, as in status_history[].actor_id.
+	Actors []string
+}
+
+// Summary is the audit record of one anonymization run
+type Summary struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	StartedAt  time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt time.Time          `json:"finished_at" bson:"finished_at"`
+	// Collection is the orders collection the run covered
+	Collection string `json:"collection" bson:"collection"`
+	// UserID is the user whose orders an erasure covered, regardless of
+	// their age
+	UserID string `json:"user_id,omitempty" bson:"user_id,omitempty"`
+	// TenantID is the tenant an erasure was limited to; empty for all
+	TenantID string `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	// Cutoff is the creation time before which orders were anonymized
+	Cutoff time.Time `json:"cutoff" bson:"cutoff"`
+	DryRun bool      `json:"dry_run" bson:"dry_run"`
+	Fields []string  `json:"fields" bson:"fields"`
+	// Orders counts orders anonymized, or that would be on a dry run
+	Orders int `json:"orders" bson:"orders"`
+	// Copies counts documents modified per copy collection
+	Copies map[string]int64 `json:"copies" bson:"copies"`
+	Error  string           `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Anonymizer replaces personal data on aged orders and their copies
+type Anonymizer struct {
+	orders *mongo.Collection
+	runs   *mongo.Collection
+	copies []Copy
+	key    []byte
+
+	// Retention is how long orders keep their personal data
+	Retention time.Duration
+	// PIIFields are the order fields replaced with tokens
+	PIIFields []string
+	// ActorFields are the order fields tokenized where they name the
+	// order's user, in the syntax of Copy.Actors
+	ActorFields []string
+	// Reindex, when set, is called with the document ID of every order
+	// anonymized, to refresh copies kept outside MongoDB such as a search
+	// index
+	Reindex func(ctx context.Context, id primitive.ObjectID) error
+	Clock   clock.Clock
+}
+
+// NewAnonymizer returns an anonymizer for orders that records each run in runs
+func NewAnonymizer(orders, runs *mongo.Collection, key []byte, retention time.Duration, copies ...Copy) *Anonymizer {
+	return &Anonymizer{
+		orders:      orders,
+		runs:        runs,
+		copies:      copies,
+		key:         key,
+		Retention:   retention,
+		PIIFields:   DefaultPIIFields,
+		ActorFields: DefaultActorFields,
+		Clock:       clock.System{},
+	}
+}
+
+// Run anonymizes every order created before the retention window that has
+// not been anonymized yet. With dryRun it only counts them. Either way the
+// run's summary is stored and returned, including on failure.
+func (a *Anonymizer) Run(ctx context.Context, dryRun bool) (Summary, error) {
+	now := a.Clock.Now()
+	summary := Summary{
+		StartedAt:  now,
+		Collection: a.orders.Name(),
+		Cutoff:     now.Add(-a.Retention),
+		DryRun:     dryRun,
+		Fields:     a.PIIFields,
+		Copies:     map[string]int64{},
+	}
+	filter := bson.M{
+		"created_at":    bson.M{"$lt": summary.Cutoff},
+		"anonymized_at": bson.M{"$exists": false},
+	}
+	return a.record(ctx, filter, summary)
+}
+
+// EraseUser anonymizes every order of userID however old it is, as when
+// the user asks for their data to be erased. With a tenant in ctx only that
+// tenant's orders are, as the same user ID may name another person in
+// another tenant. Its summary is stored and returned like a run's.
+func (a *Anonymizer) EraseUser(ctx context.Context, userID string) (Summary, error) {
+	if userID == "" {
+		// Would match every guest order
+		return Summary{}, errors.New("erase user: empty user ID")
+	}
+	summary := Summary{
+		StartedAt:  a.Clock.Now(),
+		Collection: a.orders.Name(),
+		UserID:     userID,
+		TenantID:   tenant.FromContext(ctx),
+		Fields:     a.PIIFields,
+		Copies:     map[string]int64{},
+	}
+	filter := bson.M{"user_id": userID}
+	if summary.TenantID != "" {
+		filter["tenant_id"] = summary.TenantID
+	}
+	return a.record(ctx, filter, summary)
+}
+
+// record anonymizes the orders matching filter, then stores and logs
+// summary
+func (a *Anonymizer) record(ctx context.Context, filter bson.M, summary Summary) (Summary, error) {
+	err := a.run(ctx, filter, &summary)
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	summary.FinishedAt = a.Clock.Now()
+
+	if res, insertErr := a.runs.InsertOne(ctx, summary); insertErr != nil {
+		log.Error().Err(insertErr).Msg("Failed to record anonymization run")
+	} else if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		summary.ID = id
+	}
+
+	event := log.Info()
+	if err != nil {
+		event = log.Error().Err(err)
+	}
+	event.Str("collection", summary.Collection).
+		Time("cutoff", summary.Cutoff).
+		Bool("dry_run", summary.DryRun).
+		Bool("erasure", summary.UserID != "").
+		Int("orders", summary.Orders).
+		Interface("copies", summary.Copies).
+		Dur("duration", summary.FinishedAt.Sub(summary.StartedAt)).
+		Msg("Anonymization run finished")
+	return summary, err
+}
+
+func (a *Anonymizer) run(ctx context.Context, filter bson.M, summary *Summary) error {
+	if summary.DryRun {
+		n, err := a.orders.CountDocuments(ctx, filter)
+		summary.Orders = int(n)
+		return err
+	}
+
+	projection := bson.M{"order_id": 1}
+	for _, field := range a.PIIFields {
+		projection[field] = 1
+	}
+	cursor, err := a.orders.Find(ctx, filter, options.Find().SetProjection(projection))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := a.anonymize(ctx, doc, summary); err != nil {
+			return err
+		}
+		summary.Orders++
+	}
+	return cursor.Err()
+}
+
+// anonymize tokenizes one order. Copies are rewritten first and the order is
+// marked last, so an interrupted run picks the order up again next time.
+func (a *Anonymizer) anonymize(ctx context.Context, doc bson.M, summary *Summary) error {
+	orderID, _ := doc["order_id"].(string)
+
+	tokens := bson.M{}
+	for _, field := range a.PIIFields {
+		value, ok := lookup(doc, field).(string)
+		if !ok || value == "" || strings.HasPrefix(value, TokenPrefix) {
+			continue
+		}
+		// Encrypted values are tokenized by their plaintext, so a customer
+		// keeps one token
+		value, err := contracts.OpenPII(value)
+		if err != nil {
+			return fmt.Errorf("anonymize order %s: %w", orderID, err)
+		}
+		tokens[field] = Token(a.key, value)
+	}
+	userID, _ := doc["user_id"].(string)
+	userToken, _ := tokens["user_id"].(string)
+
+	for _, c := range a.copies {
+		if orderID == "" {
+			break
+		}
+		match := bson.M{c.OrderIDField: orderID}
+		for k, v := range c.Match {
+			match[k] = v
+		}
+
+		set := bson.M{}
+		for path, field := range c.Fields {
+			if token, ok := tokens[field]; ok {
+				set[path] = token
+			}
+		}
+		if len(set) > 0 {
+			res, err := c.Collection.UpdateMany(ctx, match, bson.M{"$set": set})
+			if err != nil {
+				return fmt.Errorf("anonymize %s for order %s: %w", c.Collection.Name(), orderID, err)
+			}
+			summary.Copies[c.Collection.Name()] += res.ModifiedCount
+		}
+
+		for _, path := range c.Actors {
+			if userToken == "" {
+				break
+			}
+			n, err := tokenizeActor(ctx, c.Collection, match, path, userID, userToken)
+			if err != nil {
+				return fmt.Errorf("anonymize %s for order %s: %w", c.Collection.Name(), orderID, err)
+			}
+			summary.Copies[c.Collection.Name()] += n
+		}
+	}
+
+	for _, path := range a.ActorFields {
+		if userToken == "" {
+			break
+		}
+		if _, err := tokenizeActor(ctx, a.orders, bson.M{"_id": doc["_id"]}, path, userID, userToken); err != nil {
+			return fmt.Errorf("anonymize order %s: %w", orderID, err)
+		}
+	}
+
+	tokens["anonymized_at"] = a.Clock.Now()
+	if _, err := a.orders.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, bson.M{"$set": tokens}); err != nil {
+		return fmt.Errorf("anonymize order %s: %w", orderID, err)
+	}
+	if id, ok := doc["_id"].(primitive.ObjectID); ok && a.Reindex != nil {
+		if err := a.Reindex(ctx, id); err != nil {
+			return fmt.Errorf("reindex order %s: %w", orderID, err)
+		}
+	}
+	return nil
+}
+
+// tokenizeActor replaces userID with token at path in the documents matching
+// match, leaving other actors alone. A [] segment in path addresses the
+// elements of an array; only elements naming userID are rewritten.
+func tokenizeActor(ctx context.Context, collection *mongo.Collection, match bson.M, path, userID, token string) (int64, error) {
+	filter := bson.M{}
+	for k, v := range match {
+		filter[k] = v
+	}
+	update := bson.M{"$set": bson.M{path: token}}
+	opts := options.Update()
+	if array, field, ok := strings.Cut(path, "[]."); ok {
+		filter[array+"."+field] = userID
+		update = bson.M{"$set": bson.M{array + ".$[actor]." + field: token}}
+		opts.SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"actor." + field: userID}}})
+	} else {
+		filter[path] = userID
+	}
+
+	res, err := collection.UpdateMany(ctx, filter, update, opts)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// lookup returns the value at a dotted path in doc, or nil
+func lookup(doc bson.M, path string) interface{} {
+	var value interface{} = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
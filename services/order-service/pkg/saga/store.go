@@ -0,0 +1,173 @@
+// Package saga persists the progress of order placement sagas. Placing an
+// order spans the order store, product-service and the payment service;
+// recording each completed step lets another replica finish or undo a
+// placement interrupted by a crash instead of leaving stock reserved or a
+// payment authorized for an order that was never confirmed.
+package saga
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/contracts"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Placement steps, run in this order and compensated in reverse
+const (
+	StepRedeemPromotion  = "redeem_promotion"
+	StepCreateOrder      = "create_order"
+	StepReserveStock     = "reserve_stock"
+	StepAuthorizePayment = "authorize_payment"
+	StepConfirmOrder     = "confirm_order"
+)
+
+// Saga statuses. Running and compensating sagas are unfinished; completed
+// and failed ones are kept for Retention.
+const (
+	StatusRunning      = "running"
+	StatusCompensating = "compensating"
+	StatusCompleted    = "completed"
+	StatusFailed       = "failed"
+)
+
+var (
+	// ErrLeaseLost is returned by Save when another replica has taken the
+	// saga over because its lease ran out
+	ErrLeaseLost = errors.New("saga lease lost")
+	// ErrNoneStale is returned by Claim when no unfinished saga has an
+	// expired lease
+	ErrNoneStale = errors.New("no stale saga")
+)
+
+// Saga is the persisted state of one order placement
+type Saga struct {
+	// ID is the order's public order_id
+	ID string `bson:"_id"`
+	// Order is the order being placed, its document ID assigned up front
+	// so a resumed saga can tell whether it was stored
+	Order contracts.Order `bson:"order"`
+	// Steps are the steps this placement runs, fixed when it starts
+	Steps []string `bson:"steps"`
+	// Done are the steps completed, in order; compensation pops them
+	Done      []string `bson:"done"`
+	Status    string   `bson:"status"`
+	PaymentID string   `bson:"payment_id,omitempty"`
+	// Error is why the placement is being compensated
+	Error      string     `bson:"error,omitempty"`
+	CreatedAt  time.Time  `bson:"created_at"`
+	UpdatedAt  time.Time  `bson:"updated_at"`
+	FinishedAt *time.Time `bson:"finished_at,omitempty"`
+	// Lease identifies the replica running the saga until LeaseUntil
+	Lease      string    `bson:"lease"`
+	LeaseUntil time.Time `bson:"lease_until"`
+}
+
+// Finished reports whether the saga has completed or been compensated
+func (s Saga) Finished() bool {
+	return s.Status == StatusCompleted || s.Status == StatusFailed
+}
+
+// Next returns the first step not done yet, or "" when every step is
+func (s Saga) Next() string {
+	if len(s.Done) < len(s.Steps) {
+		return s.Steps[len(s.Done)]
+	}
+	return ""
+}
+
+// Store keeps sagas in MongoDB
+type Store struct {
+	sagas *mongo.Collection
+
+	// Lease is how long a replica may go without saving a saga before
+	// another may take it over
+	Lease time.Duration
+	// Retention is how long finished sagas are kept
+	Retention time.Duration
+	// Clock defaults to the system clock
+	Clock clock.Clock
+}
+
+// NewStore returns a store backed by sagas
+func NewStore(sagas *mongo.Collection) *Store {
+	return &Store{sagas: sagas, Lease: time.Minute, Retention: 7 * 24 * time.Hour, Clock: clock.System{}}
+}
+
+// EnsureIndexes creates the index used to find stale sagas and the one
+// expiring finished sagas
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.sagas.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		{Keys: bson.D{{Key: "finished_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(s.Retention.Seconds()))},
+	})
+	return err
+}
+
+// Start records a new running saga, leased to the caller
+func (s *Store) Start(ctx context.Context, saga *Saga) error {
+	now := s.Clock.Now()
+	saga.Status = StatusRunning
+	saga.CreatedAt = now
+	saga.UpdatedAt = now
+	saga.Lease = uuid.New().String()
+	saga.LeaseUntil = now.Add(s.Lease)
+	_, err := s.sagas.InsertOne(ctx, saga)
+	return err
+}
+
+// Save records the saga's progress and renews its lease. It fails with
+// ErrLeaseLost if another replica has claimed the saga since.
+func (s *Store) Save(ctx context.Context, saga *Saga) error {
+	now := s.Clock.Now()
+	set := bson.M{
+		"done":        saga.Done,
+		"status":      saga.Status,
+		"payment_id":  saga.PaymentID,
+		"error":       saga.Error,
+		"updated_at":  now,
+		"lease_until": now.Add(s.Lease),
+	}
+	if saga.Finished() {
+		set["finished_at"] = now
+		saga.FinishedAt = &now
+	}
+	result, err := s.sagas.UpdateOne(ctx, bson.M{"_id": saga.ID, "lease": saga.Lease}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrLeaseLost
+	}
+	saga.UpdatedAt = now
+	saga.LeaseUntil = now.Add(s.Lease)
+	return nil
+}
+
+// Claim leases the oldest unfinished saga whose lease has run out, which
+// means the replica running it stopped. It returns ErrNoneStale when there
+// is none.
+func (s *Store) Claim(ctx context.Context) (Saga, error) {
+	now := s.Clock.Now()
+	filter := bson.M{
+		"status":      bson.M{"$in": bson.A{StatusRunning, StatusCompensating}},
+		"lease_until": bson.M{"$lt": now},
+	}
+	update := bson.M{"$set": bson.M{"lease": uuid.New().String(), "lease_until": now.Add(s.Lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "lease_until", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var saga Saga
+	err := s.sagas.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saga)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return saga, ErrNoneStale
+	}
+	return saga, err
+}
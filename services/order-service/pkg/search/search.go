@@ -0,0 +1,355 @@
+// Package search keeps a copy of every order in an Elasticsearch index and
+// serves the admin listings from it, so their filters, sorts and free-text
+// queries never load the orders collection. The index is a read model: only
+// the projector writes it, from stored order events, so it trails MongoDB by
+// the projection delay, and it can be rebuilt at any time by replaying them.
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/httpclient"
+	"order-service/pkg/repository"
+)
+
+// DefaultIndex is the index orders are kept in unless configured otherwise
+const DefaultIndex = "orders"
+
+// MaxResults is how deep into a listing Elasticsearch pages, its default
+// index.max_result_window
+const MaxResults = 10000
+
+// ErrTooDeep is returned for pages ending past MaxResults
+var ErrTooDeep = fmt.Errorf("search listings end after %d orders; narrow the filters", MaxResults)
+
+// mapping indexes the fields listings filter and sort on, and the text
+// free-text queries match. The order itself is stored unindexed, for hits to
+// be read back from, with its personal data sealed as in MongoDB.
+const mapping = `{
+	"mappings": {
+		"dynamic": false,
+		"properties": {
+			"id": {"type": "keyword"},
+			"order_id": {"type": "keyword"},
+			"user_id": {"type": "keyword"},
+			"tenant_id": {"type": "keyword"},
+			"status": {"type": "keyword"},
+			"product_ids": {"type": "keyword"},
+			"created_at": {"type": "date"},
+			"total_currency": {"type": "keyword"},
+			"total_amount": {"type": "long"},
+			"priority_rank": {"type": "integer"},
+			"deleted": {"type": "boolean"},
+			"text": {"type": "text"},
+			"order": {"type": "object", "enabled": false}
+		}
+	}
+}`
+
+// document is an order as indexed
+type document struct {
+	ID            string          `json:"id"`
+	OrderID       string          `json:"order_id"`
+	UserID        string          `json:"user_id"`
+	TenantID      string          `json:"tenant_id,omitempty"`
+	Status        string          `json:"status"`
+	ProductIDs    []string        `json:"product_ids"`
+	CreatedAt     time.Time       `json:"created_at"`
+	TotalCurrency string          `json:"total_currency"`
+	TotalAmount   int64           `json:"total_amount"`
+	PriorityRank  int             `json:"priority_rank"`
+	Deleted       bool            `json:"deleted"`
+	Text          []string        `json:"text"`
+	Order         contracts.Order `json:"order"`
+}
+
+// newDocument returns order as indexed. Its text leaves out the personal
+// data of contracts.EncryptedFields, which would be searchable in
+// plaintext otherwise.
+func newDocument(order contracts.Order) (document, error) {
+	sealed, err := order.Sealed()
+	if err != nil {
+		return document{}, err
+	}
+	doc := document{
+		ID:            order.ID.Hex(),
+		OrderID:       order.OrderID,
+		UserID:        order.UserID,
+		TenantID:      order.TenantID,
+		Status:        order.Status,
+		ProductIDs:    []string{},
+		CreatedAt:     order.CreatedAt,
+		TotalCurrency: order.TotalAmount.Currency,
+		TotalAmount:   order.TotalAmount.Amount,
+		PriorityRank:  order.PriorityRank,
+		Deleted:       order.DeletedAt != nil,
+		Text:          []string{order.OrderID},
+		Order:         sealed,
+	}
+	for _, item := range order.Items {
+		doc.ProductIDs = append(doc.ProductIDs, item.ProductID)
+		doc.Text = append(doc.Text, item.Name, item.SKU)
+	}
+	if a := order.ShippingAddress; a != nil {
+		doc.Text = append(doc.Text, a.City, a.Region, a.PostalCode, a.Country)
+	}
+	for _, note := range order.Notes {
+		doc.Text = append(doc.Text, note.Text)
+	}
+	return doc, nil
+}
+
+// Index is an Elasticsearch index of orders
+type Index struct {
+	baseURL string
+	name    string
+	client  *httpclient.Client
+}
+
+// NewIndex returns the index name on the cluster at baseURL, which may
+// carry basic auth credentials. A non-empty apiKey is sent instead.
+func NewIndex(baseURL, name, apiKey string) *Index {
+	cfg := httpclient.DefaultConfig("elasticsearch")
+	if apiKey != "" {
+		cfg.Auth = func(context.Context) (string, error) { return "ApiKey " + apiKey, nil }
+	}
+	return &Index{baseURL: strings.TrimSuffix(baseURL, "/"), name: name, client: httpclient.New(cfg)}
+}
+
+// Ping checks that the cluster answers
+func (x *Index) Ping(ctx context.Context) error {
+	resp, err := x.do(ctx, http.MethodGet, x.baseURL+"/_cluster/health", nil)
+	if err != nil {
+		return err
+	}
+	return failure(resp, http.StatusOK)
+}
+
+// EnsureIndex creates the index with its mapping unless it exists
+func (x *Index) EnsureIndex(ctx context.Context) error {
+	resp, err := x.do(ctx, http.MethodHead, x.url(""), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = x.do(ctx, http.MethodPut, x.url(""), []byte(mapping))
+	if err != nil {
+		return err
+	}
+	err = failure(resp, http.StatusOK)
+	if err != nil && strings.Contains(err.Error(), "resource_already_exists_exception") {
+		// Another projector created it meanwhile
+		return nil
+	}
+	return err
+}
+
+// Put indexes order, unless a later version of it already is. Orders are
+// versioned by updated_at, so events projected twice or out of order
+// never roll an order back.
+func (x *Index) Put(ctx context.Context, order contracts.Order) error {
+	doc, err := newDocument(order)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	version := url.Values{
+		"version":      {fmt.Sprint(order.UpdatedAt.UnixNano())},
+		"version_type": {"external_gte"},
+	}
+	resp, err := x.do(ctx, http.MethodPut, x.url("/_doc/"+order.ID.Hex()+"?"+version.Encode()), body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		return nil
+	}
+	return failure(resp, http.StatusOK, http.StatusCreated)
+}
+
+// Find returns one page of the orders matching filter and, when text is
+// not empty, containing every word of it in their order ID, item names or
+// SKUs, shipping city, region, postal code or country, or notes. Deleted orders are left
+// out, as from repository listings, and pages are sorted the same way.
+func (x *Index) Find(ctx context.Context, filter repository.OrderFilter, text string, q repository.PageQuery) (repository.Page, error) {
+	if q.Offset+q.Limit > MaxResults {
+		return repository.Page{}, ErrTooDeep
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"query":            query(filter, text),
+		"sort":             sort(q),
+		"from":             q.Offset,
+		"size":             q.Limit,
+		"track_total_hits": true,
+	})
+	if err != nil {
+		return repository.Page{}, err
+	}
+
+	resp, err := x.do(ctx, http.MethodPost, x.url("/_search"), body)
+	if err != nil {
+		return repository.Page{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return repository.Page{}, failure(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source document `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return repository.Page{}, fmt.Errorf("decode search result: %w", err)
+	}
+
+	page := repository.Page{Orders: make([]contracts.Order, 0, len(result.Hits.Hits)), Total: result.Hits.Total.Value}
+	for _, hit := range result.Hits.Hits {
+		order, err := hit.Source.Order.Opened()
+		if err != nil {
+			return repository.Page{}, err
+		}
+		// PriorityRank is left out of the order's JSON
+		order.PriorityRank = hit.Source.PriorityRank
+		page.Orders = append(page.Orders, order)
+	}
+	return page, nil
+}
+
+// query returns the Elasticsearch query selecting the orders of filter
+// containing text
+func query(f repository.OrderFilter, text string) map[string]interface{} {
+	term := func(field string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{"term": map[string]interface{}{field: value}}
+	}
+	filters := []interface{}{term("deleted", false)}
+	if f.OrderID != "" {
+		filters = append(filters, term("order_id", f.OrderID))
+	}
+	if len(f.Statuses) > 0 {
+		filters = append(filters, map[string]interface{}{"terms": map[string]interface{}{"status": f.Statuses}})
+	}
+	if f.UserID != "" {
+		filters = append(filters, term("user_id", f.UserID))
+	}
+	if f.TenantID != "" {
+		filters = append(filters, term("tenant_id", f.TenantID))
+	}
+	if f.ProductID != "" {
+		filters = append(filters, term("product_ids", f.ProductID))
+	}
+	created := map[string]interface{}{}
+	if !f.From.IsZero() {
+		created["gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		created["lt"] = f.To
+	}
+	if len(created) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"created_at": created}})
+	}
+	if f.Currency != "" {
+		filters = append(filters, term("total_currency", f.Currency))
+	}
+	amount := map[string]interface{}{}
+	if f.MinTotal != nil {
+		amount["gte"] = *f.MinTotal
+	}
+	if f.MaxTotal != nil {
+		amount["lte"] = *f.MaxTotal
+	}
+	if len(amount) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"total_amount": amount}})
+	}
+
+	boolQuery := map[string]interface{}{"filter": filters}
+	if text != "" {
+		boolQuery["must"] = map[string]interface{}{
+			"match": map[string]interface{}{"text": map[string]interface{}{"query": text, "operator": "and"}},
+		}
+	}
+	return map[string]interface{}{"bool": boolQuery}
+}
+
+// sort returns the sort of q, with the tie-breaks of repository listings
+func sort(q repository.PageQuery) []interface{} {
+	direction := "asc"
+	if q.Desc {
+		direction = "desc"
+	}
+	by := func(field, order string) map[string]interface{} {
+		return map[string]interface{}{field: map[string]interface{}{"order": order}}
+	}
+	switch q.SortBy {
+	case repository.SortTotalAmount:
+		return []interface{}{by("total_amount", direction), by("id", direction)}
+	case repository.SortPriority:
+		// Orders of the same priority stay oldest first either way
+		return []interface{}{by("priority_rank", direction), by("created_at", "asc"), by("id", "asc")}
+	}
+	return []interface{}{by("created_at", direction), by("id", direction)}
+}
+
+func (x *Index) url(path string) string {
+	return x.baseURL + "/" + url.PathEscape(x.name) + path
+}
+
+// do sends body, when not nil, as JSON
+func (x *Index) do(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return x.client.Do(req)
+}
+
+// failure closes resp and returns nil when its status is one of ok, and
+// otherwise the error Elasticsearch reported
+func failure(resp *http.Response, ok ...int) error {
+	defer resp.Body.Close()
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	var body struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Type == "" {
+		return fmt.Errorf("elasticsearch returned status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("elasticsearch returned status %d: %s: %s", resp.StatusCode, body.Error.Type, body.Error.Reason)
+}
@@ -0,0 +1,68 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/fieldcrypt"
+)
+
+// prefixCipher "encrypts" by prefixing values, enough to tell sealed ones
+type prefixCipher struct{}
+
+func (prefixCipher) Encrypt(plaintext string) (string, error) {
+	return fieldcrypt.Prefix + "test:" + plaintext, nil
+}
+
+func (prefixCipher) Decrypt(value string) (string, error) {
+	return strings.TrimPrefix(value, fieldcrypt.Prefix+"test:"), nil
+}
+
+func TestDocumentLeavesOutPersonalData(t *testing.T) {
+	contracts.SetFieldCipher(prefixCipher{})
+	defer contracts.SetFieldCipher(nil)
+
+	order := contracts.Order{
+		OrderID:    "ord-1",
+		GuestEmail: "jane@example.com",
+		ShippingAddress: &contracts.Address{
+			Name: "Jane Doe", Line1: "1 Main St", Line2: "Flat 2",
+			City: "Berlin", PostalCode: "10115", Country: "DE",
+		},
+	}
+	doc, err := newDocument(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := strings.Join(doc.Text, " ")
+	for _, personal := range []string{"jane", "Jane Doe", "Main St", "Flat 2"} {
+		if strings.Contains(text, personal) {
+			t.Errorf("text %q indexes %q", text, personal)
+		}
+	}
+	for _, searched := range []string{"ord-1", "Berlin", "10115", "DE"} {
+		if !strings.Contains(text, searched) {
+			t.Errorf("text %q misses %q", text, searched)
+		}
+	}
+
+	stored := doc.Order
+	for _, value := range []string{stored.GuestEmail, stored.ShippingAddress.Name, stored.ShippingAddress.Line1, stored.ShippingAddress.Line2} {
+		if !fieldcrypt.IsEncrypted(value) {
+			t.Errorf("stored %q in plaintext", value)
+		}
+	}
+	if order.ShippingAddress.Name != "Jane Doe" {
+		t.Errorf("sealing changed the order indexed")
+	}
+
+	opened, err := stored.Opened()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.GuestEmail != order.GuestEmail || *opened.ShippingAddress != *order.ShippingAddress {
+		t.Errorf("read back %+v, want %+v", opened, order)
+	}
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/httpclient"
+)
+
+// gcpMetadataTokenURL issues access tokens for the instance's service
+// account on GCE, GKE and Cloud Run
+const gcpMetadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// GCPSecretManager reads secrets from Google Cloud Secret Manager. A
+// reference is the secret's ID in the project, optionally followed by the
+// field wanted of a JSON secret, e.g. "order-service-jwt".
+type GCPSecretManager struct {
+	project string
+	clock   clock.Clock
+	client  *httpclient.Client
+
+	// token is the access token from the metadata server, reused until
+	// shortly before it expires
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+// NewGCPSecretManager returns a provider for the secrets of project,
+// authenticating as the instance's service account
+func NewGCPSecretManager(project string) *GCPSecretManager {
+	m := &GCPSecretManager{project: project, clock: clock.System{}}
+	cfg := httpclient.DefaultConfig("gcp-secret-manager")
+	cfg.Auth = m.accessToken
+	m.client = httpclient.New(cfg)
+	return m
+}
+
+// Get reads the latest version of the secret
+func (m *GCPSecretManager) Get(ctx context.Context, ref string) (string, error) {
+	name, field := splitRef(ref)
+	url := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/latest:access", m.project, name)
+	resp, err := m.client.Get(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	default:
+		return "", fmt.Errorf("secret manager returned status %d", resp.StatusCode)
+	}
+
+	var version struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("decode secret version: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(version.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("decode secret payload: %w", err)
+	}
+	return jsonField(string(value), field)
+}
+
+// accessToken returns the Authorization header for Secret Manager calls
+func (m *GCPSecretManager) accessToken(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && m.clock.Now().Before(m.tokenExpires) {
+		return "Bearer " + m.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpMetadataTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decode access token: %w", err)
+	}
+	// Renew a minute early so no call goes out with an expired token
+	m.token = token.AccessToken
+	m.tokenExpires = m.clock.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return "Bearer " + m.token, nil
+}
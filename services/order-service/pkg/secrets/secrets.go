@@ -0,0 +1,151 @@
+// Package secrets fetches secrets such as the JWT signing secret and the
+// MongoDB URI from a secret manager at runtime, instead of having them baked
+// into environment variables, and keeps them current as they are rotated.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"order-service/pkg/clock"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrNotFound is returned for secrets the provider does not have
+var ErrNotFound = errors.New("secret not found")
+
+// Provider reads secrets by reference. A reference names the secret in the
+// provider, followed by "#field" to pick one field of a JSON secret or of a
+// Vault entry, e.g. "order-service/jwt#secret".
+type Provider interface {
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// splitRef separates the secret's name from the field wanted
+func splitRef(ref string) (name, field string) {
+	name, field, _ = strings.Cut(ref, "#")
+	return name, field
+}
+
+// jsonField picks field out of a secret holding a JSON object, or returns
+// the secret as is when no field is wanted
+func jsonField(value, field string) (string, error) {
+	if field == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object with field %q", field)
+	}
+	return stringField(fields, field)
+}
+
+// stringField returns a field of a secret, which must be a string
+func stringField(fields map[string]interface{}, field string) (string, error) {
+	v, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("%w: no field %q", ErrNotFound, field)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("secret field %q is not a string", field)
+	}
+	return s, nil
+}
+
+// Rotating keeps a secret current by fetching it again periodically. After
+// a rotation the previous value stays valid for Grace, so tokens signed
+// just before the rotation still verify.
+type Rotating struct {
+	provider Provider
+	ref      string
+	clock    clock.Clock
+	// Grace is how long the previous value is kept after a rotation
+	Grace time.Duration
+	// OnChange, when set, is called with every new value
+	OnChange func(value string)
+
+	mu              sync.RWMutex
+	current         string
+	previous        string
+	previousExpires time.Time
+}
+
+// NewRotating fetches the secret ref from provider; nil clk is the system
+// clock
+func NewRotating(ctx context.Context, provider Provider, ref string, clk clock.Clock) (*Rotating, error) {
+	if clk == nil {
+		clk = clock.System{}
+	}
+	value, err := provider.Get(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("fetch secret %s: %w", ref, err)
+	}
+	return &Rotating{provider: provider, ref: ref, clock: clk, current: value}, nil
+}
+
+// Current returns the latest value
+func (r *Rotating) Current() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// Values returns the latest value followed by the previous one while it is
+// within its grace period
+func (r *Rotating) Values() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	values := []string{r.current}
+	if r.previous != "" && r.clock.Now().Before(r.previousExpires) {
+		values = append(values, r.previous)
+	}
+	return values
+}
+
+// Refresh fetches the secret again, keeping the current value when that
+// fails
+func (r *Rotating) Refresh(ctx context.Context) error {
+	value, err := r.provider.Get(ctx, r.ref)
+	if err != nil {
+		return fmt.Errorf("fetch secret %s: %w", r.ref, err)
+	}
+
+	r.mu.Lock()
+	changed := value != r.current
+	if changed {
+		r.previous, r.previousExpires = r.current, r.clock.Now().Add(r.Grace)
+		r.current = value
+	}
+	r.mu.Unlock()
+
+	if changed {
+		log.Info().Str("secret", r.ref).Msg("Secret rotated")
+		if r.OnChange != nil {
+			r.OnChange(value)
+		}
+	}
+	return nil
+}
+
+// Run refreshes the secret every interval until ctx is done
+func (r *Rotating) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Refresh(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to refresh secret, keeping the current value")
+			}
+		}
+	}
+}
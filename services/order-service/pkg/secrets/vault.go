@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"order-service/pkg/httpclient"
+)
+
+// Vault reads secrets from a HashiCorp Vault KV version 2 engine. A
+// reference is the entry's path in the engine and the field wanted, e.g.
+// "order-service/jwt#secret".
+type Vault struct {
+	addr   string
+	token  string
+	mount  string
+	client *httpclient.Client
+}
+
+// NewVault returns a provider for the Vault server at addr, authenticating
+// with token, reading the KV engine mounted at mount ("secret" if empty)
+func NewVault(addr, token, mount string) *Vault {
+	if mount == "" {
+		mount = "secret"
+	}
+	return &Vault{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		mount:  strings.Trim(mount, "/"),
+		client: httpclient.New(httpclient.DefaultConfig("vault")),
+	}
+}
+
+type vaultEntry struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+}
+
+// Get reads the field of the latest version of the entry
+func (v *Vault) Get(ctx context.Context, ref string) (string, error) {
+	path, field := splitRef(ref)
+	if path == "" || field == "" {
+		return "", fmt.Errorf("vault secret reference %q is not of the form path#field", ref)
+	}
+
+	endpoint := v.addr + "/v1/" + v.mount + "/data/" + (&url.URL{Path: strings.Trim(path, "/")}).EscapedPath()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", ErrNotFound
+	default:
+		return "", fmt.Errorf("vault returned status %d", resp.StatusCode)
+	}
+
+	var entry vaultEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return "", fmt.Errorf("decode vault entry: %w", err)
+	}
+	return stringField(entry.Data.Data, field)
+}
@@ -0,0 +1,53 @@
+// Package tenant carries the tenant a request is served for through
+// contexts, so SaaS deployments keep each customer's orders apart. The
+// tenant comes from the tenant_id claim of the caller's token, or from the
+// x-tenant-id metadata of internal gRPC calls; contexts without one, such
+// as those of background jobs, act across tenants.
+package tenant
+
+import (
+	"context"
+	"errors"
+)
+
+// Claim is the token claim naming the caller's tenant
+const Claim = "tenant_id"
+
+// Header carries the tenant on internal gRPC calls
+const Header = "x-tenant-id"
+
+// ErrRequired is returned for callers without a tenant where every caller
+// must have one
+var ErrRequired = errors.New("tenant required")
+
+// maxLength bounds the tenant IDs accepted
+const maxLength = 64
+
+type key struct{}
+
+// Valid reports whether id may name a tenant: 1 to 64 letters, digits,
+// dashes and underscores, as it may end up in database names
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// WithTenant returns ctx carrying the tenant id
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// FromContext returns the tenant ctx carries, empty for none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
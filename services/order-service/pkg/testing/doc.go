@@ -0,0 +1,11 @@
+// Package testing provides shared fixtures for service tests: builders for
+// orders, signed JWTs and HTTP requests, golden-file assertions, and
+// in-memory mocks of the repository, event log, publisher and catalog. Pair the mocks
+// with clock.Fake to unit test internal/service and internal/api without
+// MongoDB; tests of the MongoDB stores themselves get a throwaway database
+// from MongoDatabase.
+//
+// Import it under an alias to avoid clashing with the standard library:
+//
+//	import fixtures "order-service/pkg/testing"
+package testing
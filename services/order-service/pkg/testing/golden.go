@@ -0,0 +1,52 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// UpdateGoldenEnv rewrites golden files instead of comparing when set to "1"
+const UpdateGoldenEnv = "UPDATE_GOLDEN"
+
+// AssertGolden compares got with testdata/<name>.golden
+func AssertGolden(t testing.TB, name string, got []byte) {
+	t.Helper()
+
+	path := filepath.Join("testdata", name+".golden")
+	if os.Getenv(UpdateGoldenEnv) == "1" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create golden dir: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("write golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file (run with %s=1 to create it): %v", UpdateGoldenEnv, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run with %s=1 to update)\n--- got\n%s\n--- want\n%s", path, UpdateGoldenEnv, got, want)
+	}
+}
+
+// AssertGoldenJSON normalizes got to indented JSON before comparing, so
+// key order and whitespace differences don't cause spurious failures
+func AssertGoldenJSON(t testing.TB, name string, got []byte) {
+	t.Helper()
+
+	var v interface{}
+	if err := json.Unmarshal(got, &v); err != nil {
+		t.Fatalf("golden JSON %s: %v", name, err)
+	}
+	normalized, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("golden JSON %s: %v", name, err)
+	}
+	AssertGolden(t, name, append(normalized, '\n'))
+}
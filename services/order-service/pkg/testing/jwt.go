@@ -0,0 +1,73 @@
+package testing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// TestSecret is the HMAC key tests should configure as JWT_SECRET
+var TestSecret = []byte("test-secret-key-do-not-use-in-production")
+
+// TokenBuilder builds signed JWTs matching the claims issued by user-service
+type TokenBuilder struct {
+	claims jwt.MapClaims
+	secret []byte
+	method jwt.SigningMethod
+}
+
+// NewToken returns a builder for a token valid for one hour
+func NewToken() *TokenBuilder {
+	return &TokenBuilder{
+		claims: jwt.MapClaims{
+			"userId": "user-1",
+			"email":  "user-1@example.com",
+			"iat":    time.Now().Unix(),
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		},
+		secret: TestSecret,
+		method: jwt.SigningMethodHS256,
+	}
+}
+
+// ForUser sets the userId and a matching email claim
+func (b *TokenBuilder) ForUser(userID string) *TokenBuilder {
+	b.claims["userId"] = userID
+	b.claims["email"] = userID + "@example.com"
+	return b
+}
+
+// WithClaim sets an arbitrary claim
+func (b *TokenBuilder) WithClaim(key string, value interface{}) *TokenBuilder {
+	b.claims[key] = value
+	return b
+}
+
+// Expired makes the token expire in the past
+func (b *TokenBuilder) Expired() *TokenBuilder {
+	b.claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	return b
+}
+
+// SignedWith overrides the HMAC key, e.g. to produce an invalid signature
+func (b *TokenBuilder) SignedWith(secret []byte) *TokenBuilder {
+	b.secret = secret
+	return b
+}
+
+// Sign returns the signed token string
+func (b *TokenBuilder) Sign(t testing.TB) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(b.method, b.claims).SignedString(b.secret)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+// Bearer returns the signed token as an Authorization header value
+func (b *TokenBuilder) Bearer(t testing.TB) string {
+	t.Helper()
+	return "Bearer " + b.Sign(t)
+}
@@ -0,0 +1,787 @@
+package testing
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/inventory"
+	"order-service/pkg/money"
+	"order-service/pkg/payment"
+	"order-service/pkg/projection"
+	"order-service/pkg/promotion"
+	"order-service/pkg/repository"
+	"order-service/pkg/saga"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Compile-time checks that the mocks satisfy the production interfaces
+var (
+	_ repository.OrderRepository = (*MockOrderRepository)(nil)
+	_ events.Publisher           = (*MockPublisher)(nil)
+	_ events.Log                 = (*MockEventLog)(nil)
+	_ projection.Catalog         = (*MockCatalog)(nil)
+)
+
+// MockOrderRepository is an in-memory repository.OrderRepository. Set the
+// *Func fields to inject failures; unset fields fall back to the in-memory
+// behaviour, which mirrors MongoRepository (ErrNotFound for unknown IDs).
+type MockOrderRepository struct {
+	CreateFunc          func(ctx context.Context, order *contracts.Order) error
+	CreateManyFunc      func(ctx context.Context, orders []*contracts.Order) []error
+	FindByIDFunc        func(ctx context.Context, id primitive.ObjectID) (contracts.Order, error)
+	FindByUserFunc      func(ctx context.Context, userID string) ([]contracts.Order, error)
+	EachByUserFunc      func(ctx context.Context, userID string, fn func(contracts.Order) error) error
+	FindUserPageFunc    func(ctx context.Context, userID string, q repository.PageQuery) (repository.Page, error)
+	FindPageFunc        func(ctx context.Context, filter repository.OrderFilter, q repository.PageQuery) (repository.Page, error)
+	SearchFunc          func(ctx context.Context, text string, filter repository.OrderFilter, q repository.PageQuery) (repository.SearchPage, error)
+	UpdateStatusFunc    func(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error)
+	UpdateItemsFunc     func(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error)
+	UpdateShipmentsFunc func(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error)
+	UpdatePriorityFunc  func(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error)
+	AddNoteFunc         func(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error)
+	DeleteFunc          func(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error)
+
+	mu     sync.Mutex
+	orders map[primitive.ObjectID]contracts.Order
+	calls  []string
+}
+
+// NewMockOrderRepository returns a repository preloaded with orders
+func NewMockOrderRepository(orders ...contracts.Order) *MockOrderRepository {
+	m := &MockOrderRepository{orders: map[primitive.ObjectID]contracts.Order{}}
+	for _, order := range orders {
+		m.orders[order.ID] = order
+	}
+	return m
+}
+
+// Calls returns the names of the methods invoked so far, in order
+func (m *MockOrderRepository) Calls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+func (m *MockOrderRepository) record(method string) {
+	m.mu.Lock()
+	m.calls = append(m.calls, method)
+	if m.orders == nil {
+		m.orders = map[primitive.ObjectID]contracts.Order{}
+	}
+	m.mu.Unlock()
+}
+
+// Create stores the order, assigning an ID if unset
+func (m *MockOrderRepository) Create(ctx context.Context, order *contracts.Order) error {
+	m.record("Create")
+	if m.CreateFunc != nil {
+		return m.CreateFunc(ctx, order)
+	}
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
+	m.mu.Lock()
+	m.orders[order.ID] = *order
+	m.mu.Unlock()
+	return nil
+}
+
+// CreateMany stores each order like Create
+func (m *MockOrderRepository) CreateMany(ctx context.Context, orders []*contracts.Order) []error {
+	m.record("CreateMany")
+	if m.CreateManyFunc != nil {
+		return m.CreateManyFunc(ctx, orders)
+	}
+	errs := make([]error, len(orders))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, order := range orders {
+		if order.ID.IsZero() {
+			order.ID = primitive.NewObjectID()
+		}
+		m.orders[order.ID] = *order
+	}
+	return errs
+}
+
+// FindByID returns the stored order or ErrNotFound
+func (m *MockOrderRepository) FindByID(ctx context.Context, id primitive.ObjectID) (contracts.Order, error) {
+	m.record("FindByID")
+	if m.FindByIDFunc != nil {
+		return m.FindByIDFunc(ctx, id)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	return order, nil
+}
+
+// FindByUser returns the stored orders owned by userID
+func (m *MockOrderRepository) FindByUser(ctx context.Context, userID string) ([]contracts.Order, error) {
+	m.record("FindByUser")
+	if m.FindByUserFunc != nil {
+		return m.FindByUserFunc(ctx, userID)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orders []contracts.Order
+	for _, order := range m.orders {
+		if order.UserID == userID && order.DeletedAt == nil {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// EachByUser calls fn for each stored order owned by userID, oldest first
+func (m *MockOrderRepository) EachByUser(ctx context.Context, userID string, fn func(contracts.Order) error) error {
+	m.record("EachByUser")
+	if m.EachByUserFunc != nil {
+		return m.EachByUserFunc(ctx, userID, fn)
+	}
+	m.mu.Lock()
+	var orders []contracts.Order
+	for _, order := range m.orders {
+		if order.UserID == userID && order.DeletedAt == nil {
+			orders = append(orders, order)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(orders, func(i, j int) bool { return orders[i].CreatedAt.Before(orders[j].CreatedAt) })
+	for _, order := range orders {
+		if err := fn(order); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindUserPage pages through the stored orders owned by userID
+func (m *MockOrderRepository) FindUserPage(ctx context.Context, userID string, q repository.PageQuery) (repository.Page, error) {
+	m.record("FindUserPage")
+	if m.FindUserPageFunc != nil {
+		return m.FindUserPageFunc(ctx, userID, q)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orders []contracts.Order
+	for _, order := range m.orders {
+		if order.UserID == userID && order.DeletedAt == nil {
+			orders = append(orders, order)
+		}
+	}
+	return repository.Paginate(orders, q), nil
+}
+
+// FindPage pages through the stored orders matching filter
+func (m *MockOrderRepository) FindPage(ctx context.Context, filter repository.OrderFilter, q repository.PageQuery) (repository.Page, error) {
+	m.record("FindPage")
+	if m.FindPageFunc != nil {
+		return m.FindPageFunc(ctx, filter, q)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orders []contracts.Order
+	for _, order := range m.orders {
+		if filter.Matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	return repository.Paginate(orders, q), nil
+}
+
+// Search scores the stored orders matching filter in memory
+func (m *MockOrderRepository) Search(ctx context.Context, text string, filter repository.OrderFilter, q repository.PageQuery) (repository.SearchPage, error) {
+	m.record("Search")
+	if m.SearchFunc != nil {
+		return m.SearchFunc(ctx, text, filter, q)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orders []contracts.Order
+	for _, order := range m.orders {
+		if filter.Matches(order) {
+			orders = append(orders, order)
+		}
+	}
+	return repository.SearchOrders(orders, text, q), nil
+}
+
+// UpdateStatus applies the change to the stored order, enforcing the
+// state machine like the real repositories
+func (m *MockOrderRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, change contracts.StatusChange) (contracts.Order, string, error) {
+	m.record("UpdateStatus")
+	if m.UpdateStatusFunc != nil {
+		return m.UpdateStatusFunc(ctx, id, change)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, "", repository.ErrNotFound
+	}
+	previous := order.Status
+	if contracts.CanTransition(order.Status, change.To) && !change.Base.IsZero() && !order.UpdatedAt.Equal(change.Base) {
+		return order, "", repository.ErrConflict
+	}
+	if err := change.Apply(&order); err != nil {
+		return order, "", err
+	}
+	m.orders[id] = order
+	return order, previous, nil
+}
+
+// UpdateItems applies the change to the stored order, rejecting it like the
+// real repositories once the order left pending or changed since change.Base
+func (m *MockOrderRepository) UpdateItems(ctx context.Context, id primitive.ObjectID, change contracts.ItemsChange) (contracts.Order, error) {
+	m.record("UpdateItems")
+	if m.UpdateItemsFunc != nil {
+		return m.UpdateItemsFunc(ctx, id, change)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	if order.Status == contracts.StatusPending && !order.UpdatedAt.Equal(change.Base) {
+		return order, repository.ErrConflict
+	}
+	if err := change.Apply(&order); err != nil {
+		return order, err
+	}
+	m.orders[id] = order
+	return order, nil
+}
+
+// UpdateShipments applies the change to the stored order, rejecting it like
+// the real repositories once the order cannot ship or changed since
+// change.Base
+func (m *MockOrderRepository) UpdateShipments(ctx context.Context, id primitive.ObjectID, change contracts.ShipmentsChange) (contracts.Order, string, error) {
+	m.record("UpdateShipments")
+	if m.UpdateShipmentsFunc != nil {
+		return m.UpdateShipmentsFunc(ctx, id, change)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, "", repository.ErrNotFound
+	}
+	previous := order.Status
+	if (previous == contracts.StatusConfirmed || previous == contracts.StatusShipped) && !order.UpdatedAt.Equal(change.Base) {
+		return order, "", repository.ErrConflict
+	}
+	if err := change.Apply(&order); err != nil {
+		return order, "", err
+	}
+	m.orders[id] = order
+	return order, previous, nil
+}
+
+// UpdatePriority sets the priority of the stored order, rejecting it like
+// the real repositories once the order has shipped or changed since a
+// non-zero change.Base
+func (m *MockOrderRepository) UpdatePriority(ctx context.Context, id primitive.ObjectID, change contracts.PriorityChange) (contracts.Order, error) {
+	m.record("UpdatePriority")
+	if m.UpdatePriorityFunc != nil {
+		return m.UpdatePriorityFunc(ctx, id, change)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	if err := change.Apply(&order); err != nil {
+		return m.orders[id], err
+	}
+	if !change.Base.IsZero() && !m.orders[id].UpdatedAt.Equal(change.Base) {
+		return m.orders[id], repository.ErrConflict
+	}
+	m.orders[id] = order
+	return order, nil
+}
+
+// AddNote appends the note to the stored order, rejecting it like the real
+// repositories once the order is full or changed since change.Base
+func (m *MockOrderRepository) AddNote(ctx context.Context, id primitive.ObjectID, change contracts.NoteChange) (contracts.Order, error) {
+	m.record("AddNote")
+	if m.AddNoteFunc != nil {
+		return m.AddNoteFunc(ctx, id, change)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	if !change.Base.IsZero() && !order.UpdatedAt.Equal(change.Base) {
+		return order, repository.ErrConflict
+	}
+	order.Notes = append([]contracts.Note(nil), order.Notes...)
+	if err := change.Apply(&order); err != nil {
+		return order, err
+	}
+	m.orders[id] = order
+	return order, nil
+}
+
+// Delete marks the stored order deleted
+func (m *MockOrderRepository) Delete(ctx context.Context, id primitive.ObjectID, at, base time.Time) (contracts.Order, error) {
+	m.record("Delete")
+	if m.DeleteFunc != nil {
+		return m.DeleteFunc(ctx, id, at, base)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	order, ok := m.orders[id]
+	if !ok || order.DeletedAt != nil {
+		return contracts.Order{}, repository.ErrNotFound
+	}
+	if !base.IsZero() && !order.UpdatedAt.Equal(base) {
+		return order, repository.ErrConflict
+	}
+	order.DeletedAt = &at
+	order.UpdatedAt = at
+	m.orders[id] = order
+	return order, nil
+}
+
+// PublishedEvent is an event captured by MockPublisher
+type PublishedEvent struct {
+	Event   contracts.Event
+	Headers map[string]string
+}
+
+// MockPublisher records published events. Set Err to make Publish fail.
+type MockPublisher struct {
+	Err error
+
+	mu     sync.Mutex
+	events []PublishedEvent
+}
+
+// Publish records the event and returns Err
+func (m *MockPublisher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, PublishedEvent{Event: event, Headers: headers})
+	return m.Err
+}
+
+// Events returns everything published so far
+func (m *MockPublisher) Events() []PublishedEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]PublishedEvent(nil), m.events...)
+}
+
+// MockEventLog is an in-memory events.Log. Set AppendErr to make Append fail.
+type MockEventLog struct {
+	AppendErr error
+
+	mu     sync.Mutex
+	events []contracts.Event
+}
+
+// Append stores the event and returns AppendErr
+func (m *MockEventLog) Append(ctx context.Context, event contracts.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.AppendErr != nil {
+		return m.AppendErr
+	}
+	m.events = append(m.events, event)
+	return nil
+}
+
+// Each calls fn for every stored event matching filter, in append order
+func (m *MockEventLog) Each(ctx context.Context, filter events.Filter, fn func(contracts.Event) error) error {
+	m.mu.Lock()
+	stored := append([]contracts.Event(nil), m.events...)
+	m.mu.Unlock()
+
+	for _, event := range stored {
+		if filter.OrderID != "" && event.OrderID != filter.OrderID {
+			continue
+		}
+		if !filter.From.IsZero() && event.OccurredAt.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !event.OccurredAt.Before(filter.To) {
+			continue
+		}
+		if len(filter.Types) > 0 && !containsString(filter.Types, event.Type) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Events returns everything appended so far
+func (m *MockEventLog) Events() []contracts.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]contracts.Event(nil), m.events...)
+}
+
+// MockTransactions is a service.Transactor for in-memory mocks, which
+// cannot roll back: Run calls fn once and counts it, so tests can check
+// which changes run in a transaction and that failures within fail them.
+// Set NonAtomic to report no transactions, as a standalone server does.
+type MockTransactions struct {
+	NonAtomic bool
+
+	mu   sync.Mutex
+	runs int
+}
+
+// Atomic reports whether Run stands for a transaction
+func (m *MockTransactions) Atomic(ctx context.Context) bool {
+	return !m.NonAtomic
+}
+
+// Run calls fn with ctx
+func (m *MockTransactions) Run(ctx context.Context, fn func(ctx context.Context) error) error {
+	m.mu.Lock()
+	m.runs++
+	m.mu.Unlock()
+	return fn(ctx)
+}
+
+// Runs returns how many times Run was called
+func (m *MockTransactions) Runs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.runs
+}
+
+// MockCatalog is an in-memory projection.Catalog keyed by product ID. Unknown
+// products return ErrProductNotFound; set Err to simulate an outage.
+type MockCatalog struct {
+	Products map[string]projection.ProductDetails
+	Err      error
+}
+
+// Product returns the stored product or Err
+func (m *MockCatalog) Product(ctx context.Context, productID string) (projection.ProductDetails, error) {
+	if m.Err != nil {
+		return projection.ProductDetails{}, m.Err
+	}
+	product, ok := m.Products[productID]
+	if !ok {
+		return projection.ProductDetails{}, projection.ErrProductNotFound
+	}
+	return product, nil
+}
+
+// MockPromotionStore is an in-memory service.PromotionStore keyed by
+// normalized code, enforcing usage limits like the real store
+type MockPromotionStore struct {
+	mu         sync.Mutex
+	Promotions map[string]promotion.Promotion
+}
+
+// Find returns the stored promotion or promotion.ErrNotFound
+func (m *MockPromotionStore) Find(ctx context.Context, code string) (promotion.Promotion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.Promotions[promotion.NormalizeCode(code)]
+	if !ok {
+		return p, promotion.ErrNotFound
+	}
+	return p, nil
+}
+
+// Redeem counts a use unless the limit is reached
+func (m *MockPromotionStore) Redeem(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code = promotion.NormalizeCode(code)
+	p, ok := m.Promotions[code]
+	switch {
+	case !ok:
+		return promotion.ErrNotFound
+	case p.MaxUses > 0 && p.Uses >= p.MaxUses:
+		return promotion.ErrExhausted
+	}
+	p.Uses++
+	m.Promotions[code] = p
+	return nil
+}
+
+// Release gives back a use
+func (m *MockPromotionStore) Release(ctx context.Context, code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code = promotion.NormalizeCode(code)
+	if p, ok := m.Promotions[code]; ok && p.Uses > 0 {
+		p.Uses--
+		m.Promotions[code] = p
+	}
+	return nil
+}
+
+// MockInventory is an in-memory service.Inventory holding Stock per product
+// ID. Reservations replace what an order held before and fail with
+// *inventory.InsufficientStockError, reserving nothing, like product-service.
+type MockInventory struct {
+	mu       sync.Mutex
+	Stock    map[string]int
+	reserved map[string]map[string]int
+}
+
+// Reserve holds stock for the order's items
+func (m *MockInventory) Reserve(ctx context.Context, orderID string, items []contracts.OrderItem) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Stock == nil {
+		m.Stock = map[string]int{}
+	}
+	if m.reserved == nil {
+		m.reserved = map[string]map[string]int{}
+	}
+
+	wanted := map[string]int{}
+	for _, item := range items {
+		wanted[item.ProductID] += item.Quantity
+	}
+	held := m.reserved[orderID]
+	var shortages []inventory.Shortage
+	for productID, quantity := range wanted {
+		if available := m.Stock[productID] + held[productID]; quantity > available {
+			shortages = append(shortages, inventory.Shortage{ProductID: productID, Requested: quantity, Available: available})
+		}
+	}
+	if len(shortages) > 0 {
+		sort.Slice(shortages, func(i, j int) bool { return shortages[i].ProductID < shortages[j].ProductID })
+		return &inventory.InsufficientStockError{Items: shortages}
+	}
+
+	for productID, quantity := range held {
+		m.Stock[productID] += quantity
+	}
+	for productID, quantity := range wanted {
+		m.Stock[productID] -= quantity
+	}
+	m.reserved[orderID] = wanted
+	return nil
+}
+
+// Release returns the stock held for the order
+func (m *MockInventory) Release(ctx context.Context, orderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for productID, quantity := range m.reserved[orderID] {
+		m.Stock[productID] += quantity
+	}
+	delete(m.reserved, orderID)
+	return nil
+}
+
+// Reserved returns the quantity of each product held for the order
+func (m *MockInventory) Reserved(orderID string) map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	held := map[string]int{}
+	for productID, quantity := range m.reserved[orderID] {
+		held[productID] = quantity
+	}
+	return held
+}
+
+// MockSagaStore is an in-memory service.SagaStore. Sagas are never leased
+// out: Claim returns every unfinished saga in turn, as if its replica had
+// stopped, so tests can resume placements by calling ResumeSagas.
+type MockSagaStore struct {
+	mu      sync.Mutex
+	sagas   map[string]saga.Saga
+	claimed map[string]bool
+	// SaveErr, when set, fails every Save to simulate a crash
+	SaveErr error
+}
+
+// Start records the saga
+func (m *MockSagaStore) Start(ctx context.Context, s *saga.Saga) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sagas == nil {
+		m.sagas = map[string]saga.Saga{}
+	}
+	s.Status = saga.StatusRunning
+	m.sagas[s.ID] = cloneSaga(*s)
+	return nil
+}
+
+// Save records the saga's progress or returns SaveErr
+func (m *MockSagaStore) Save(ctx context.Context, s *saga.Saga) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	m.sagas[s.ID] = cloneSaga(*s)
+	return nil
+}
+
+// Claim returns each unfinished saga once
+func (m *MockSagaStore) Claim(ctx context.Context) (saga.Saga, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.claimed == nil {
+		m.claimed = map[string]bool{}
+	}
+	ids := make([]string, 0, len(m.sagas))
+	for id := range m.sagas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if s := m.sagas[id]; !s.Finished() && !m.claimed[id] {
+			m.claimed[id] = true
+			return cloneSaga(s), nil
+		}
+	}
+	return saga.Saga{}, saga.ErrNoneStale
+}
+
+// Saga returns the stored saga of an order
+func (m *MockSagaStore) Saga(orderID string) (saga.Saga, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sagas[orderID]
+	return cloneSaga(s), ok
+}
+
+func cloneSaga(s saga.Saga) saga.Saga {
+	s.Steps = append([]string(nil), s.Steps...)
+	s.Done = append([]string(nil), s.Done...)
+	return s
+}
+
+// MockPaymentGateway is an in-memory service.PaymentGateway. Authorizations
+// succeed unless Decline or Err is set; retries with the same key return the
+// first payment, like the payment service.
+type MockPaymentGateway struct {
+	mu       sync.Mutex
+	payments []payment.Payment
+	keys     map[string]int
+	// Decline refuses every authorization
+	Decline bool
+	// Err fails every call
+	Err error
+}
+
+// Authorize records an authorized or declined payment
+func (m *MockPaymentGateway) Authorize(ctx context.Context, orderID string, amount money.Money, key string) (payment.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return payment.Payment{}, m.Err
+	}
+	if m.keys == nil {
+		m.keys = map[string]int{}
+	}
+
+	at, ok := m.keys[key]
+	if !ok {
+		p := payment.Payment{
+			PaymentID: primitive.NewObjectID().Hex(),
+			OrderID:   orderID,
+			Amount:    amount.Amount,
+			Currency:  amount.Currency,
+			Status:    payment.StatusAuthorized,
+		}
+		if m.Decline {
+			p.Status, p.DeclineReason = payment.StatusDeclined, "card_declined"
+		}
+		m.payments = append(m.payments, p)
+		at = len(m.payments) - 1
+		m.keys[key] = at
+	}
+	if p := m.payments[at]; p.Status == payment.StatusDeclined {
+		return p, payment.ErrDeclined
+	}
+	return m.payments[at], nil
+}
+
+// Void voids an authorized payment
+func (m *MockPaymentGateway) Void(ctx context.Context, paymentID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	for i, p := range m.payments {
+		if p.PaymentID == paymentID && p.Status == payment.StatusAuthorized {
+			m.payments[i].Status = payment.StatusVoided
+		}
+	}
+	return nil
+}
+
+// Refund refunds part or all of a payment that was not voided or declined,
+// once per key
+func (m *MockPaymentGateway) Refund(ctx context.Context, paymentID string, amount money.Money, key string) (payment.Refund, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return payment.Refund{}, m.Err
+	}
+	if m.keys == nil {
+		m.keys = map[string]int{}
+	}
+	for i, p := range m.payments {
+		if p.PaymentID != paymentID || p.Status == payment.StatusDeclined || p.Status == payment.StatusVoided {
+			continue
+		}
+		if _, refunded := m.keys[key]; !refunded {
+			m.keys[key] = i
+			m.payments[i].RefundedAmount += amount.Amount
+			m.payments[i].Status = payment.StatusPartiallyRefunded
+			if m.payments[i].RefundedAmount >= p.Amount {
+				m.payments[i].Status = payment.StatusRefunded
+			}
+		}
+		return payment.Refund{Payment: m.payments[i], RefundedAmount: amount.Amount}, nil
+	}
+	return payment.Refund{}, payment.ErrRefundDeclined
+}
+
+// ForOrder returns the payments made for the order
+func (m *MockPaymentGateway) ForOrder(ctx context.Context, orderID string) ([]payment.Payment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	var payments []payment.Payment
+	for _, p := range m.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+	return payments, nil
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+package testing
+
+import (
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/money"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FixedTime is the timestamp used by builders so golden output is stable
+var FixedTime = time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// OrderBuilder builds contracts.Order values with sensible defaults
+type OrderBuilder struct {
+	order contracts.Order
+}
+
+// NewOrder returns a builder for a pending order with a single item
+func NewOrder() *OrderBuilder {
+	return &OrderBuilder{order: contracts.Order{
+		ID:        primitive.NewObjectID(),
+		OrderID:   uuid.New().String(),
+		UserID:    "user-1",
+		Items:     []contracts.OrderItem{NewItem().Build()},
+		Status:    "pending",
+		CreatedAt: FixedTime,
+		UpdatedAt: FixedTime,
+	}}
+}
+
+// WithID sets the Mongo document ID from a hex string
+func (b *OrderBuilder) WithID(hex string) *OrderBuilder {
+	b.order.ID, _ = primitive.ObjectIDFromHex(hex)
+	return b
+}
+
+// WithOrderID sets the public order identifier
+func (b *OrderBuilder) WithOrderID(orderID string) *OrderBuilder {
+	b.order.OrderID = orderID
+	return b
+}
+
+// WithUser sets the owning user
+func (b *OrderBuilder) WithUser(userID string) *OrderBuilder {
+	b.order.UserID = userID
+	return b
+}
+
+// WithStatus sets the order status
+func (b *OrderBuilder) WithStatus(status string) *OrderBuilder {
+	b.order.Status = status
+	return b
+}
+
+// WithItems replaces the line items
+func (b *OrderBuilder) WithItems(items ...contracts.OrderItem) *OrderBuilder {
+	b.order.Items = items
+	return b
+}
+
+// AddItem appends a line item
+func (b *OrderBuilder) AddItem(item contracts.OrderItem) *OrderBuilder {
+	b.order.Items = append(b.order.Items, item)
+	return b
+}
+
+// CreatedAt sets both creation and update timestamps
+func (b *OrderBuilder) CreatedAt(t time.Time) *OrderBuilder {
+	b.order.CreatedAt = t.UTC()
+	b.order.UpdatedAt = t.UTC()
+	return b
+}
+
+// Build returns the order with its total computed from the items, with no
+// tax, shipping or discount
+func (b *OrderBuilder) Build() contracts.Order {
+	order := b.order
+	order.Items = append([]contracts.OrderItem(nil), b.order.Items...)
+	order.TotalAmount = money.Zero(money.DefaultCurrency)
+	if len(order.Items) > 0 {
+		order.TotalAmount = money.Zero(order.Items[0].Price.Currency)
+	}
+	for _, item := range order.Items {
+		// Builders may deliberately mix currencies; keep the first currency
+		order.TotalAmount.Amount += item.Price.Mul(int64(item.Quantity)).Amount
+	}
+	order.BackfillTotals()
+	return order
+}
+
+// CreateRequest returns the matching request payload for POST /api/orders
+func (b *OrderBuilder) CreateRequest() contracts.CreateOrderRequest {
+	return contracts.CreateOrderRequest{Items: b.Build().Items}
+}
+
+// ItemBuilder builds contracts.OrderItem values
+type ItemBuilder struct {
+	item contracts.OrderItem
+}
+
+// NewItem returns a builder for a single valid line item
+func NewItem() *ItemBuilder {
+	return &ItemBuilder{item: contracts.OrderItem{
+		ProductID: "product-1",
+		Name:      "Test Product",
+		Price:     money.New(1000, money.DefaultCurrency),
+		Quantity:  1,
+	}}
+}
+
+// WithProduct sets the product ID and name
+func (b *ItemBuilder) WithProduct(productID, name string) *ItemBuilder {
+	b.item.ProductID = productID
+	b.item.Name = name
+	return b
+}
+
+// WithPrice sets the unit price from a decimal string such as "12.99" in
+// the default currency; it panics on malformed input
+func (b *ItemBuilder) WithPrice(price string) *ItemBuilder {
+	return b.WithMoney(mustParse(price, money.DefaultCurrency))
+}
+
+// WithMoney sets the unit price
+func (b *ItemBuilder) WithMoney(price money.Money) *ItemBuilder {
+	b.item.Price = price
+	return b
+}
+
+func mustParse(amount, currency string) money.Money {
+	m, err := money.Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// WithQuantity sets the quantity
+func (b *ItemBuilder) WithQuantity(quantity int) *ItemBuilder {
+	b.item.Quantity = quantity
+	return b
+}
+
+// Build returns the line item
+func (b *ItemBuilder) Build() contracts.OrderItem {
+	return b.item
+}
@@ -0,0 +1,102 @@
+package testing
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// RequestBuilder builds HTTP requests for exercising handlers
+type RequestBuilder struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+	body   interface{}
+}
+
+// NewRequest returns a builder for the given method and path
+func NewRequest(method, path string) *RequestBuilder {
+	return &RequestBuilder{
+		method: method,
+		path:   path,
+		query:  url.Values{},
+		header: http.Header{},
+	}
+}
+
+// WithJSON sets a body that is marshaled to JSON; strings and byte slices are sent as-is
+func (b *RequestBuilder) WithJSON(body interface{}) *RequestBuilder {
+	b.body = body
+	b.header.Set("Content-Type", "application/json")
+	return b
+}
+
+// WithHeader sets a request header
+func (b *RequestBuilder) WithHeader(key, value string) *RequestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// WithQuery adds a query parameter
+func (b *RequestBuilder) WithQuery(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+// WithToken sets the Authorization header from a token builder
+func (b *RequestBuilder) WithToken(t testing.TB, token *TokenBuilder) *RequestBuilder {
+	t.Helper()
+	return b.WithHeader("Authorization", token.Bearer(t))
+}
+
+// Build returns the request
+func (b *RequestBuilder) Build(t testing.TB) *http.Request {
+	t.Helper()
+
+	var body io.Reader
+	switch v := b.body.(type) {
+	case nil:
+	case string:
+		body = bytes.NewBufferString(v)
+	case []byte:
+		body = bytes.NewBuffer(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+		body = bytes.NewBuffer(data)
+	}
+
+	target := b.path
+	if len(b.query) > 0 {
+		target += "?" + b.query.Encode()
+	}
+
+	req := httptest.NewRequest(b.method, target, body)
+	for key, values := range b.header {
+		req.Header[key] = values
+	}
+	return req
+}
+
+// Do serves the request against handler and returns the recorded response
+func (b *RequestBuilder) Do(t testing.TB, handler http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, b.Build(t))
+	return w
+}
+
+// DecodeJSON unmarshals a recorded response body into v
+func DecodeJSON(t testing.TB, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", w.Body.String(), err)
+	}
+}
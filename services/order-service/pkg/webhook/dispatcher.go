@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"order-service/pkg/clock"
+	"order-service/pkg/jobs"
+	"order-service/pkg/tenant"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInvalidURL is returned when subscribing a URL that is not http(s)
+var ErrInvalidURL = errors.New("webhook URL must be an absolute http:// or https:// URL")
+
+// ErrUnknownEventType is returned when subscribing to an event type that is
+// not in OrderEventTypes
+var ErrUnknownEventType = errors.New("unknown webhook event type")
+
+// RetryPolicy decides when failed deliveries are retried
+type RetryPolicy struct {
+	// BaseBackoff is the delay before the first retry; it doubles with every
+	// attempt up to MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// MaxAge is how long after the event a delivery is still attempted
+	MaxAge time.Duration
+}
+
+// DefaultRetryPolicy retries for a day, backing off from 10s to 1h
+var DefaultRetryPolicy = RetryPolicy{BaseBackoff: 10 * time.Second, MaxBackoff: time.Hour, MaxAge: 24 * time.Hour}
+
+// backoff returns the delay after the given number of failed attempts
+func (p RetryPolicy) backoff(failures int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < failures && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return d
+}
+
+// Dispatcher manages subscriptions and delivers events to them
+type Dispatcher struct {
+	store  *Store
+	client *http.Client
+
+	// Policy controls retries; defaults to DefaultRetryPolicy
+	Policy RetryPolicy
+	// Clock stamps deliveries and decides when retries are due
+	Clock clock.Clock
+	// Jobs, when set, queues the delivery of published events as JobDispatch
+	// jobs, so events published just before a crash are still delivered
+	Jobs *jobs.Queue
+	// Egress limits the addresses subscriptions may point at, checked when
+	// subscribing and again on every connection
+	Egress Egress
+}
+
+// NewDispatcher returns a dispatcher persisting to store. Requests time out
+// after timeout. Subscriber endpoints are independent, so the shared
+// httpclient breaker and in-request retries are deliberately not used.
+// Requests go straight to the subscriber, without a proxy, and redirects
+// are not followed.
+func NewDispatcher(store *Store, timeout time.Duration) *Dispatcher {
+	d := &Dispatcher{
+		store:  store,
+		Policy: DefaultRetryPolicy,
+		Clock:  clock.System{},
+	}
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			return d.Egress.control(network, address, conn)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	d.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return d
+}
+
+// Subscribe registers url for eventTypes (all events when empty) on behalf
+// of owner, in the tenant of ctx, and returns the subscription with its
+// signing secret. With allOrders the subscription receives the events of
+// every order of the tenant, not only the owner's. Every method taking an
+// owner acts in the tenant of ctx.
+func (d *Dispatcher) Subscribe(ctx context.Context, owner, rawURL string, eventTypes []string, allOrders bool) (Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, ErrInvalidURL
+	}
+	if err := d.Egress.Check(ctx, u.Hostname()); err != nil {
+		return Subscription{}, err
+	}
+	if err := checkEventTypes(eventTypes); err != nil {
+		return Subscription{}, err
+	}
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub := Subscription{
+		Owner:      owner,
+		TenantID:   tenant.FromContext(ctx),
+		URL:        u.String(),
+		Secret:     secret,
+		EventTypes: eventTypes,
+		AllOrders:  allOrders,
+		CreatedAt:  d.Clock.Now(),
+	}
+	if err := d.store.CreateSubscription(ctx, &sub); err != nil {
+		return Subscription{}, err
+	}
+	return sub, nil
+}
+
+// Subscriptions lists owner's subscriptions without their secrets
+func (d *Dispatcher) Subscriptions(ctx context.Context, owner string) ([]Subscription, error) {
+	subs, err := d.store.Subscriptions(ctx, owner, tenant.FromContext(ctx))
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, err
+}
+
+// Unsubscribe deletes owner's subscription id
+func (d *Dispatcher) Unsubscribe(ctx context.Context, owner string, id primitive.ObjectID) error {
+	return d.store.DeleteSubscription(ctx, owner, tenant.FromContext(ctx), id)
+}
+
+// Deliveries returns up to limit recent deliveries of owner's subscription
+// id, newest first, with every attempt's response code
+func (d *Dispatcher) Deliveries(ctx context.Context, owner string, id primitive.ObjectID, limit int) ([]Delivery, error) {
+	if _, err := d.store.Subscription(ctx, owner, tenant.FromContext(ctx), id); err != nil {
+		return nil, err
+	}
+	return d.store.Deliveries(ctx, id, limit)
+}
+
+// Ping sends a test event to owner's subscription id and returns the
+// delivery after its first attempt
+func (d *Dispatcher) Ping(ctx context.Context, owner string, id primitive.ObjectID) (Delivery, error) {
+	sub, err := d.store.Subscription(ctx, owner, tenant.FromContext(ctx), id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	event := Event{
+		ID:        uuid.New().String(),
+		Type:      EventPing,
+		CreatedAt: d.Clock.Now(),
+		Data:      map[string]string{"subscription_id": sub.ID.Hex()},
+	}
+	return d.Send(ctx, sub, event)
+}
+
+// Send records a delivery of event to sub and makes the first attempt.
+// Failures are left pending for Run to retry; only storage errors are
+// returned.
+func (d *Dispatcher) Send(ctx context.Context, sub Subscription, event Event) (Delivery, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return Delivery{}, fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	now := d.Clock.Now()
+	delivery := Delivery{
+		SubscriptionID: sub.ID,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		Payload:        string(payload),
+		Status:         StatusPending,
+		Attempts:       []Attempt{},
+		NextAttemptAt:  now,
+		CreatedAt:      now,
+	}
+	if err := d.store.InsertDelivery(ctx, &delivery); err != nil {
+		return Delivery{}, err
+	}
+
+	return d.attempt(ctx, sub, delivery)
+}
+
+// Run retries due deliveries every interval until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		d.retryDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryDue attempts every delivery that is due, one at a time
+func (d *Dispatcher) retryDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// Lease long enough to cover the request so another instance does
+		// not pick the delivery up mid-attempt
+		delivery, err := d.store.ClaimDue(ctx, d.Clock.Now(), d.client.Timeout+time.Minute)
+		if err == mongo.ErrNoDocuments {
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim webhook delivery")
+			return
+		}
+
+		sub, err := d.store.subscriptionByID(ctx, delivery.SubscriptionID)
+		if err == ErrNotFound {
+			d.complete(ctx, delivery, Attempt{At: d.Clock.Now(), Error: "subscription deleted"}, StatusFailed)
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("delivery_id", delivery.ID.Hex()).Msg("Failed to load webhook subscription")
+			return
+		}
+
+		if _, err := d.attempt(ctx, sub, delivery); err != nil {
+			log.Error().Err(err).Str("delivery_id", delivery.ID.Hex()).Msg("Failed to record webhook attempt")
+		}
+	}
+}
+
+// attempt POSTs the delivery once and records the outcome
+func (d *Dispatcher) attempt(ctx context.Context, sub Subscription, delivery Delivery) (Delivery, error) {
+	start := d.Clock.Now()
+	code, err := d.post(ctx, sub, delivery, start)
+	attempt := Attempt{At: start, StatusCode: code}
+	attempt.DurationMS = d.Clock.Now().Sub(start).Milliseconds()
+	if err != nil {
+		// The history is shown to the subscriber; the cause is only logged
+		attempt.Error = attemptError(err)
+	}
+
+	status := StatusPending
+	switch {
+	case err == nil && attempt.StatusCode >= 200 && attempt.StatusCode < 300:
+		status = StatusSucceeded
+		attemptsTotal.WithLabelValues(delivery.EventType, "success").Inc()
+	default:
+		attemptsTotal.WithLabelValues(delivery.EventType, "failure").Inc()
+		log.Warn().
+			Str("delivery_id", delivery.ID.Hex()).
+			Str("subscription_id", delivery.SubscriptionID.Hex()).
+			Str("event_type", delivery.EventType).
+			Int("status_code", attempt.StatusCode).
+			Str("error", attempt.Error).
+			AnErr("cause", err).
+			Int("attempt", len(delivery.Attempts)+1).
+			Msg("Webhook delivery attempt failed")
+		next := start.Add(d.Policy.backoff(len(delivery.Attempts) + 1))
+		if next.Sub(delivery.CreatedAt) > d.Policy.MaxAge {
+			status = StatusFailed
+		}
+		delivery.NextAttemptAt = next
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	return d.complete(ctx, delivery, attempt, status)
+}
+
+// complete records the attempt and the delivery's new status
+func (d *Dispatcher) complete(ctx context.Context, delivery Delivery, attempt Attempt, status string) (Delivery, error) {
+	delivery.Status = status
+	if status != StatusPending {
+		delivery.CompletedAt = &attempt.At
+		deliveriesCompleted.WithLabelValues(delivery.EventType, status).Inc()
+		if status == StatusFailed {
+			log.Warn().
+				Str("delivery_id", delivery.ID.Hex()).
+				Str("subscription_id", delivery.SubscriptionID.Hex()).
+				Str("event_type", delivery.EventType).
+				Int("attempts", len(delivery.Attempts)).
+				Msg("Webhook delivery abandoned")
+		}
+	}
+	return delivery, d.store.RecordAttempt(ctx, delivery.ID, attempt, status, delivery.NextAttemptAt)
+}
+
+// post sends the signed payload and returns the response status
+func (d *Dispatcher) post(ctx context.Context, sub Subscription, delivery Delivery, at time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "order-service-webhooks/1")
+	req.Header.Set(HeaderSignature, Sign(sub.Secret, at, body))
+	req.Header.Set(HeaderDeliveryID, delivery.ID.Hex())
+	req.Header.Set(HeaderEventType, delivery.EventType)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
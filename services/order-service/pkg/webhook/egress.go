@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned when subscribing a URL whose host does not
+// resolve, or resolves to an address webhooks may not be sent to
+var ErrForbiddenAddress = errors.New("webhook URL must resolve to a public address")
+
+// reservedNetworks are not reachable on the internet but are not covered by
+// the net.IP predicates checked alongside them
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT, often used by cluster networks
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which maps onto IPv4 addresses
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Egress decides which addresses webhooks may be sent to: public ones, and
+// the networks in Allow. Without it, any subscriber could make the service
+// call loopback, cloud metadata or cluster-internal endpoints and read the
+// outcome back from the delivery history.
+type Egress struct {
+	// Allow lists networks permitted even though they are not public, such
+	// as subscribers inside the cluster; empty permits public addresses only
+	Allow []*net.IPNet
+	// Resolver looks hosts up; nil uses net.DefaultResolver
+	Resolver *net.Resolver
+}
+
+// Permitted reports whether webhooks may be sent to ip
+func (e Egress) Permitted(ip net.IP) bool {
+	for _, network := range e.Allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check resolves the host of a subscription URL and returns
+// ErrForbiddenAddress unless every address it resolves to is permitted
+func (e Egress) Check(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !e.Permitted(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+	resolver := e.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return ErrForbiddenAddress
+	}
+	for _, addr := range addrs {
+		if !e.Permitted(addr.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// control is the dialer's Control hook. It checks the address actually
+// dialled, after resolution, so a host whose DNS changes after subscribing
+// (DNS rebinding) or a redirect still cannot reach a forbidden address.
+func (e Egress) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !e.Permitted(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// attemptError describes a failed request to the subscriber without the
+// transport's own message, which would tell a subscriber about the network
+// the service runs in
+func attemptError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrForbiddenAddress):
+		return "address not permitted"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timed out"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.As(err, new(*net.DNSError)):
+		return "host not found"
+	}
+	return "request failed"
+}
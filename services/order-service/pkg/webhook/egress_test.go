@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEgressPermitted(t *testing.T) {
+	_, cluster, _ := net.ParseCIDR("10.96.0.0/12")
+
+	tests := []struct {
+		ip    string
+		allow []*net.IPNet
+		want  bool
+	}{
+		{ip: "93.184.216.34", want: true},
+		{ip: "2606:4700:4700::1111", want: true},
+		{ip: "127.0.0.1"},
+		{ip: "127.8.8.8"},
+		{ip: "::1"},
+		{ip: "0.0.0.0"},
+		{ip: "::"},
+		{ip: "10.1.2.3"},
+		{ip: "172.16.0.1"},
+		{ip: "192.168.1.1"},
+		{ip: "fd00::1"},
+		{ip: "169.254.169.254"},
+		{ip: "fe80::1"},
+		{ip: "100.64.0.1"},
+		{ip: "224.0.0.1"},
+		{ip: "255.255.255.255"},
+		{ip: "::ffff:127.0.0.1"},
+		{ip: "::ffff:169.254.169.254"},
+		{ip: "64:ff9b::a9fe:a9fe"},
+		{ip: "10.100.0.5", allow: []*net.IPNet{cluster}, want: true},
+		{ip: "10.200.0.5", allow: []*net.IPNet{cluster}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			e := Egress{Allow: tt.allow}
+			if got := e.Permitted(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Permitted(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEgressCheck(t *testing.T) {
+	tests := []struct {
+		host string
+		want error
+	}{
+		{host: "93.184.216.34"},
+		{host: "127.0.0.1", want: ErrForbiddenAddress},
+		{host: "::1", want: ErrForbiddenAddress},
+		{host: "169.254.169.254", want: ErrForbiddenAddress},
+		{host: "localhost", want: ErrForbiddenAddress},
+		{host: "does-not-exist.invalid", want: ErrForbiddenAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if err := (Egress{}).Check(context.Background(), tt.host); err != tt.want {
+				t.Errorf("Check(%s) = %v, want %v", tt.host, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscribeRejectsInternalURLs(t *testing.T) {
+	d := NewDispatcher(nil, time.Second)
+
+	for _, url := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://[::1]/hook",
+		"https://10.0.0.5/hook",
+	} {
+		if _, err := d.Subscribe(context.Background(), "user-1", url, nil, false); err != ErrForbiddenAddress {
+			t.Errorf("Subscribe(%s) = %v, want %v", url, err, ErrForbiddenAddress)
+		}
+	}
+}
+
+// TestDeliveryRechecksAddress covers a subscription whose host resolves to a
+// forbidden address only after subscribing: the connection itself is refused
+func TestDeliveryRechecksAddress(t *testing.T) {
+	var hits int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	sub := Subscription{URL: server.URL, Secret: "secret"}
+	delivery := Delivery{EventType: EventPing, Payload: "{}"}
+
+	d := NewDispatcher(nil, time.Second)
+	code, err := d.post(context.Background(), sub, delivery, time.Now())
+	if !errors.Is(err, ErrForbiddenAddress) || code != 0 || hits != 0 {
+		t.Fatalf("post to loopback = %d, %v after %d requests, want %v before any request", code, err, hits, ErrForbiddenAddress)
+	}
+	if got := attemptError(err); got != "address not permitted" {
+		t.Errorf("attempt error = %q", got)
+	}
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	d.Egress.Allow = []*net.IPNet{loopback}
+	if code, err := d.post(context.Background(), sub, delivery, time.Now()); err != nil || code != http.StatusOK {
+		t.Fatalf("post to allowed network = %d, %v", code, err)
+	}
+}
+
+func TestDeliveryDoesNotFollowRedirects(t *testing.T) {
+	var redirected bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected = true
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	d := NewDispatcher(nil, time.Second)
+	d.Egress.Allow = []*net.IPNet{loopback}
+
+	code, err := d.post(context.Background(), Subscription{URL: server.URL}, Delivery{Payload: "{}"}, time.Now())
+	if err != nil || code != http.StatusTemporaryRedirect || redirected {
+		t.Errorf("post = %d, %v, redirect followed %v; want %d without following", code, err, redirected, http.StatusTemporaryRedirect)
+	}
+}
+
+func TestAttemptError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "deadline", err: context.DeadlineExceeded, want: "timed out"},
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "internal.example", IsNotFound: true}, want: "host not found"},
+		{name: "other", err: errors.New("dial tcp 10.0.0.5:6379: connect: no route to host"), want: "request failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attemptError(tt.err); got != tt.want {
+				t.Errorf("attemptError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
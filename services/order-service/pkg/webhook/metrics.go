@@ -0,0 +1,26 @@
+package webhook
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	attemptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_delivery_attempts_total",
+			Help: "Total number of webhook delivery attempts by outcome",
+		},
+		[]string{"event_type", "result"},
+	)
+
+	deliveriesCompleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_deliveries_completed_total",
+			Help: "Total number of webhook deliveries that succeeded or were given up",
+		},
+		[]string{"event_type", "status"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(attemptsTotal)
+	prometheus.MustRegister(deliveriesCompleted)
+}
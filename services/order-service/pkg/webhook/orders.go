@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/events"
+	"order-service/pkg/jobs"
+
+	"github.com/rs/zerolog/log"
+)
+
+// OrderEventTypes are the order events subscriptions can ask for. Every
+// status change is sent as order.status_changed; cancellations are also
+// sent as order.cancelled for subscribers that only follow those, and
+// expired orders as order.expired after their cancellation.
+var OrderEventTypes = []string{
+	contracts.EventOrderCreated,
+	contracts.EventOrderStatusChanged,
+	contracts.EventOrderCancelled,
+	contracts.EventOrderItemsChanged,
+	contracts.EventOrderNoteAdded,
+	contracts.EventOrderShipmentsChanged,
+	contracts.EventOrderPriorityChanged,
+	contracts.EventOrderDeleted,
+	contracts.EventOrderExpired,
+}
+
+// OrderEventData is the data of every order event payload:
+//
+//	{
+//	  "id": "<event id>",
+//	  "type": "order.status_changed",
+//	  "created_at": "2024-05-01T12:00:00Z",
+//	  "data": {
+//	    "order_id": "...",
+//	    "user_id": "...",
+//	    "status": "shipped",
+//	    "previous_status": "confirmed",
+//	    "order": { ...the order as returned by GET /api/orders/{id}... }
+//	  }
+//	}
+//
+// previous_status is only set for status changes. The order is its state
+// after the event.
+type OrderEventData struct {
+	OrderID        string          `json:"order_id"`
+	UserID         string          `json:"user_id"`
+	Status         string          `json:"status"`
+	PreviousStatus string          `json:"previous_status,omitempty"`
+	Order          contracts.Order `json:"order"`
+}
+
+// validEventType reports whether subscriptions can ask for eventType
+func validEventType(eventType string) bool {
+	for _, t := range OrderEventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// JobDispatch is the job type delivering a published order event to its
+// subscriptions
+const JobDispatch = "webhook.dispatch"
+
+// dispatchJob is the payload of JobDispatch
+type dispatchJob struct {
+	Event contracts.Event `bson:"event"`
+}
+
+// Publish sends an order event to every subscription that wants it: those
+// of the order's owner and those covering all orders of its tenant. Delivery happens in
+// the background, queued as a job when Jobs is set, so the order request is
+// not held up by subscribers; replays are not sent again.
+func (d *Dispatcher) Publish(ctx context.Context, event contracts.Event, headers map[string]string) error {
+	if headers[events.HeaderReplay] == "true" || event.UserID == "" {
+		return nil
+	}
+	if d.Jobs != nil {
+		_, err := d.Jobs.Enqueue(ctx, JobDispatch, dispatchJob{Event: event})
+		if err == nil {
+			return nil
+		}
+		log.Ctx(ctx).Error().Err(err).Str("event_id", event.EventID).Msg("Failed to queue webhook dispatch, dispatching in the background")
+	}
+	go func() {
+		if err := d.dispatch(context.Background(), event, false); err != nil {
+			log.Error().Err(err).Str("event_id", event.EventID).Msg("Failed to dispatch webhook event")
+		}
+	}()
+	return nil
+}
+
+// RunDispatchJob is the Handler of JobDispatch jobs. A retried job skips
+// the subscriptions its earlier attempts recorded a delivery for.
+func (d *Dispatcher) RunDispatchJob(ctx context.Context, job jobs.Job) error {
+	var payload dispatchJob
+	if err := job.Decode(&payload); err != nil {
+		return jobs.Permanent(err)
+	}
+	return d.dispatch(ctx, payload.Event, job.Attempts > 1)
+}
+
+// dispatch records and attempts a delivery of event to each subscription
+// that wants it, skipping those already recorded when retried. Failed
+// attempts are left to Run; only storage errors are returned.
+func (d *Dispatcher) dispatch(ctx context.Context, event contracts.Event, retried bool) error {
+	types := []string{event.Type}
+	if event.Type == contracts.EventOrderStatusChanged && event.Order.Status == contracts.StatusCancelled {
+		types = append(types, contracts.EventOrderCancelled)
+	}
+
+	for _, eventType := range types {
+		lookupCtx, cancel := context.WithTimeout(ctx, d.client.Timeout+time.Minute)
+		subs, err := d.store.SubscriptionsFor(lookupCtx, event.UserID, event.Order.TenantID, eventType)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("find %s webhook subscriptions: %w", eventType, err)
+		}
+
+		payload := Event{
+			ID:        event.EventID,
+			Type:      eventType,
+			CreatedAt: event.OccurredAt,
+			Data: OrderEventData{
+				OrderID:        event.OrderID,
+				UserID:         event.UserID,
+				Status:         event.Order.Status,
+				PreviousStatus: event.PreviousStatus,
+				Order:          event.Order,
+			},
+		}
+		for _, sub := range subs {
+			if retried {
+				exists, err := d.store.DeliveryExists(ctx, sub.ID, event.EventID, eventType)
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+			}
+			sendCtx, cancel := context.WithTimeout(ctx, d.client.Timeout+time.Minute)
+			_, err := d.Send(sendCtx, sub, payload)
+			cancel()
+			if err != nil {
+				return fmt.Errorf("record %s webhook delivery to subscription %s: %w", eventType, sub.ID.Hex(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkEventTypes returns an error naming the first type subscriptions
+// cannot ask for
+func checkEventTypes(eventTypes []string) error {
+	for _, t := range eventTypes {
+		if !validEventType(t) {
+			return fmt.Errorf("%w: %q", ErrUnknownEventType, t)
+		}
+	}
+	return nil
+}
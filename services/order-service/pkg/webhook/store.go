@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// historyRetention is how long delivery records are kept for debugging
+const historyRetention = 30 * 24 * time.Hour
+
+// Store persists subscriptions and deliveries in MongoDB
+type Store struct {
+	subscriptions *mongo.Collection
+	deliveries    *mongo.Collection
+}
+
+// NewStore returns a store backed by the given collections
+func NewStore(subscriptions, deliveries *mongo.Collection) *Store {
+	return &Store{subscriptions: subscriptions, deliveries: deliveries}
+}
+
+// EnsureIndexes creates the indexes used for lookups, retries and history.
+// Deliveries expire after historyRetention.
+func (s *Store) EnsureIndexes(ctx context.Context) error {
+	_, err := s.subscriptions.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "owner", Value: 1}, {Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "event_types", Value: 1}}},
+		{Keys: bson.D{{Key: "tenant_id", Value: 1}, {Key: "all_orders", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.deliveries.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "subscription_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "created_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(int32(historyRetention.Seconds()))},
+	})
+	return err
+}
+
+// CreateSubscription stores sub and sets its ID
+func (s *Store) CreateSubscription(ctx context.Context, sub *Subscription) error {
+	result, err := s.subscriptions.InsertOne(ctx, sub)
+	if err != nil {
+		return err
+	}
+	sub.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// tenantFilter matches the documents of tenantID; without a tenant, those
+// stored without one
+func tenantFilter(tenantID string) interface{} {
+	if tenantID == "" {
+		return nil
+	}
+	return tenantID
+}
+
+// Subscription returns owner's subscription id in tenantID
+func (s *Store) Subscription(ctx context.Context, owner, tenantID string, id primitive.ObjectID) (Subscription, error) {
+	var sub Subscription
+	err := s.subscriptions.FindOne(ctx, bson.M{"_id": id, "owner": owner, "tenant_id": tenantFilter(tenantID)}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		return sub, ErrNotFound
+	}
+	return sub, err
+}
+
+// Subscriptions returns every subscription of owner in tenantID, oldest
+// first
+func (s *Store) Subscriptions(ctx context.Context, owner, tenantID string) ([]Subscription, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := s.subscriptions.Find(ctx, bson.M{"owner": owner, "tenant_id": tenantFilter(tenantID)}, opts)
+	if err != nil {
+		return nil, err
+	}
+	subs := []Subscription{}
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// SubscriptionsFor returns every subscription receiving eventType for an
+// order of userID in tenantID: the user's own and those covering all orders
+// of the tenant
+func (s *Store) SubscriptionsFor(ctx context.Context, userID, tenantID, eventType string) ([]Subscription, error) {
+	filter := bson.M{"$and": bson.A{
+		bson.M{"tenant_id": tenantFilter(tenantID)},
+		bson.M{"$or": bson.A{
+			bson.M{"owner": userID},
+			bson.M{"all_orders": true},
+		}},
+		bson.M{"$or": bson.A{
+			bson.M{"event_types": eventType},
+			bson.M{"event_types": bson.M{"$size": 0}},
+		}},
+	}}
+	cursor, err := s.subscriptions.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	var subs []Subscription
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// subscriptionByID returns a subscription regardless of owner
+func (s *Store) subscriptionByID(ctx context.Context, id primitive.ObjectID) (Subscription, error) {
+	var sub Subscription
+	err := s.subscriptions.FindOne(ctx, bson.M{"_id": id}).Decode(&sub)
+	if err == mongo.ErrNoDocuments {
+		return sub, ErrNotFound
+	}
+	return sub, err
+}
+
+// DeleteSubscription removes owner's subscription id in tenantID. Pending
+// deliveries are abandoned the next time they are claimed.
+func (s *Store) DeleteSubscription(ctx context.Context, owner, tenantID string, id primitive.ObjectID) error {
+	result, err := s.subscriptions.DeleteOne(ctx, bson.M{"_id": id, "owner": owner, "tenant_id": tenantFilter(tenantID)})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// InsertDelivery stores d and sets its ID
+func (s *Store) InsertDelivery(ctx context.Context, d *Delivery) error {
+	result, err := s.deliveries.InsertOne(ctx, d)
+	if err != nil {
+		return err
+	}
+	d.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// DeliveryExists reports whether a delivery of event eventID as eventType
+// to subscription subID is recorded
+func (s *Store) DeliveryExists(ctx context.Context, subID primitive.ObjectID, eventID, eventType string) (bool, error) {
+	n, err := s.deliveries.CountDocuments(ctx, bson.M{"subscription_id": subID, "event_id": eventID, "event_type": eventType}, options.Count().SetLimit(1))
+	return n > 0, err
+}
+
+// ClaimDue returns a pending delivery whose next attempt is due and leases
+// it until now+lease, so concurrent dispatchers never attempt it twice. It
+// returns mongo.ErrNoDocuments when nothing is due.
+func (s *Store) ClaimDue(ctx context.Context, now time.Time, lease time.Duration) (Delivery, error) {
+	filter := bson.M{"status": StatusPending, "next_attempt_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"next_attempt_at": now.Add(lease)}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var d Delivery
+	err := s.deliveries.FindOneAndUpdate(ctx, filter, update, opts).Decode(&d)
+	return d, err
+}
+
+// RecordAttempt appends attempt to the delivery and moves it to status,
+// scheduling the next attempt for pending deliveries
+func (s *Store) RecordAttempt(ctx context.Context, id primitive.ObjectID, attempt Attempt, status string, next time.Time) error {
+	set := bson.M{"status": status, "next_attempt_at": next}
+	if status != StatusPending {
+		set["completed_at"] = attempt.At
+	}
+	_, err := s.deliveries.UpdateByID(ctx, id, bson.M{
+		"$set":  set,
+		"$push": bson.M{"attempts": attempt},
+	})
+	return err
+}
+
+// Deliveries returns the latest deliveries of a subscription, newest first
+func (s *Store) Deliveries(ctx context.Context, subscriptionID primitive.ObjectID, limit int) ([]Delivery, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := s.deliveries.Find(ctx, bson.M{"subscription_id": subscriptionID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	deliveries := []Delivery{}
+	if err := cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"order-service/pkg/contracts"
+	"order-service/pkg/tenant"
+	fixtures "order-service/pkg/testing"
+)
+
+func TestSubscriptionsStayInTenant(t *testing.T) {
+	db := fixtures.MongoDatabase(t)
+	store := NewStore(db.Collection("webhook_subscriptions"), db.Collection("webhook_deliveries"))
+	ctx := context.Background()
+	if err := store.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDispatcher(store, time.Second)
+
+	acme := tenant.WithTenant(ctx, "acme")
+	globex := tenant.WithTenant(ctx, "globex")
+	subscribe := func(ctx context.Context, owner string, allOrders bool) Subscription {
+		t.Helper()
+		sub, err := d.Subscribe(ctx, owner, "https://93.184.216.34/hooks", nil, allOrders)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+	acmeAdmin := subscribe(acme, "admin-acme", true)
+	globexAdmin := subscribe(globex, "admin-globex", true)
+	acmeUser := subscribe(acme, "user-1", false)
+	globexUser := subscribe(globex, "user-1", false)
+	shared := subscribe(ctx, "admin-shared", true)
+
+	tests := []struct {
+		name   string
+		userID string
+		tenant string
+		want   []Subscription
+	}{
+		{name: "acme order", userID: "user-1", tenant: "acme", want: []Subscription{acmeAdmin, acmeUser}},
+		{name: "globex order", userID: "user-1", tenant: "globex", want: []Subscription{globexAdmin, globexUser}},
+		{name: "other acme user", userID: "user-2", tenant: "acme", want: []Subscription{acmeAdmin}},
+		{name: "order without tenant", userID: "user-1", want: []Subscription{shared}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs, err := store.SubscriptionsFor(ctx, tt.userID, tt.tenant, contracts.EventOrderCreated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]bool{}
+			for _, sub := range subs {
+				got[sub.ID.Hex()] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d subscriptions, want %d: %+v", len(got), len(tt.want), subs)
+			}
+			for _, sub := range tt.want {
+				if !got[sub.ID.Hex()] {
+					t.Errorf("missing subscription of %s in %q", sub.Owner, sub.TenantID)
+				}
+			}
+		})
+	}
+
+	if subs, err := d.Subscriptions(globex, "user-1"); err != nil || len(subs) != 1 || subs[0].ID != globexUser.ID {
+		t.Errorf("globex Subscriptions(user-1) = %+v, %v", subs, err)
+	}
+	if err := d.Unsubscribe(globex, "user-1", acmeUser.ID); err != ErrNotFound {
+		t.Errorf("unsubscribing another tenant's subscription = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,163 @@
+// Package webhook delivers signed event notifications to subscriber URLs.
+// Every delivery is persisted before it is attempted, retried with
+// exponential backoff until it succeeds or exceeds a maximum age, and kept
+// with each attempt's response code so integrators can debug their
+// endpoints.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// HeaderSignature carries "t=<unix seconds>,v1=<hex HMAC-SHA256>"
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderDeliveryID identifies the delivery; it is stable across retries
+	HeaderDeliveryID = "X-Webhook-Delivery"
+	// HeaderEventType is the event type of the payload
+	HeaderEventType = "X-Webhook-Event"
+
+	// EventPing is sent on demand to test a subscription
+	EventPing = "webhook.ping"
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrNotFound is returned for unknown subscriptions, including ones
+	// owned by someone else
+	ErrNotFound = errors.New("webhook subscription not found")
+	// ErrInvalidSignature is returned by Verify
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Subscription is an endpoint that receives events of the listed types
+type Subscription struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Owner string             `json:"-" bson:"owner"`
+	// TenantID is the tenant of the owner; the subscription only receives
+	// the events of that tenant's orders
+	TenantID string `json:"tenant_id,omitempty" bson:"tenant_id,omitempty"`
+	URL      string `json:"url" bson:"url"`
+	// Secret signs every payload. It is only returned when the subscription
+	// is created.
+	Secret     string   `json:"secret,omitempty" bson:"secret"`
+	EventTypes []string `json:"event_types" bson:"event_types"`
+	// AllOrders subscriptions receive the events of every order of their
+	// tenant; others only those of the owner's orders
+	AllOrders bool      `json:"all_orders" bson:"all_orders"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+// Wants reports whether the subscription receives eventType. An empty list
+// subscribes to everything.
+func (s Subscription) Wants(eventType string) bool {
+	if len(s.EventTypes) == 0 || eventType == EventPing {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is the JSON envelope POSTed to subscribers
+type Event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Attempt records one HTTP call of a delivery
+type Attempt struct {
+	At         time.Time `json:"at" bson:"at"`
+	StatusCode int       `json:"status_code,omitempty" bson:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms" bson:"duration_ms"`
+}
+
+// Delivery is one event sent to one subscription
+type Delivery struct {
+	ID             primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	SubscriptionID primitive.ObjectID `json:"subscription_id" bson:"subscription_id"`
+	EventID        string             `json:"event_id" bson:"event_id"`
+	EventType      string             `json:"event_type" bson:"event_type"`
+	// Payload is the exact body sent, so retries are byte-identical
+	Payload       string     `json:"payload" bson:"payload"`
+	Status        string     `json:"status" bson:"status"`
+	Attempts      []Attempt  `json:"attempts" bson:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at,omitempty" bson:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at" bson:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty" bson:"completed_at,omitempty"`
+}
+
+// NewSecret returns a random signing secret
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+// Sign returns the HeaderSignature value for body sent at t. The timestamp
+// is part of the signed message so captured requests cannot be replayed
+// later.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(secret, ts, body)
+}
+
+// Verify checks a HeaderSignature value against body, rejecting signatures
+// older than tolerance. Subscribers in Go can use it directly; it documents
+// the scheme for everyone else.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func signature(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,34 @@
+#!/bin/sh
+# Verify consumer contracts (storefront, gateway) against a running order
+# service started with PACT_VERIFICATION=true.
+#
+#   PACT_BROKER_BASE_URL=https://broker.example.com ./scripts/pact-verify.sh
+#
+# Pact files can be verified locally instead of from the broker by passing
+# their paths as arguments.
+set -e
+
+PROVIDER_BASE_URL=${PROVIDER_BASE_URL:-http://host.docker.internal:3003}
+PROVIDER_VERSION=${PROVIDER_VERSION:-$(git rev-parse --short HEAD 2>/dev/null || echo dev)}
+PACT_CLI_IMAGE=${PACT_CLI_IMAGE:-pactfoundation/pact-cli:latest}
+
+set -- "$@" \
+  --provider order-service \
+  --provider-base-url "$PROVIDER_BASE_URL" \
+  --provider-states-setup-url "$PROVIDER_BASE_URL/_pact/provider-states" \
+  --provider-app-version "$PROVIDER_VERSION" \
+  --custom-provider-header "Authorization: Bearer pact-stub-token"
+
+if [ -n "$PACT_BROKER_BASE_URL" ]; then
+  set -- "$@" \
+    --pact-broker-base-url "$PACT_BROKER_BASE_URL" \
+    --consumer-version-selector '{"consumer": "storefront", "latest": true}' \
+    --consumer-version-selector '{"consumer": "gateway", "latest": true}' \
+    --publish-verification-results
+  [ -n "$PACT_BROKER_TOKEN" ] && set -- "$@" --broker-token "$PACT_BROKER_TOKEN"
+fi
+
+exec docker run --rm \
+  --add-host host.docker.internal:host-gateway \
+  -v "$(pwd)":/pacts -w /pacts \
+  "$PACT_CLI_IMAGE" verify "$@"
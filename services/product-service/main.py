@@ -1,4 +1,4 @@
-from fastapi import FastAPI, HTTPException, Depends, status
+from fastapi import FastAPI, HTTPException, Depends, Header, status
 from fastapi.middleware.cors import CORSMiddleware
 from fastapi.security import HTTPBearer, HTTPAuthorizationCredentials
 from pydantic import BaseModel, Field
@@ -9,7 +9,8 @@ import os
 import uvicorn
 import structlog
 from prometheus_client import Counter, Histogram, generate_latest, CONTENT_TYPE_LATEST
-from fastapi.responses import Response
+from fastapi.responses import JSONResponse, Response
+import hmac
 import time
 from datetime import datetime
 from dotenv import load_dotenv
@@ -59,6 +60,8 @@ security = HTTPBearer()
 client = AsyncIOMotorClient(os.getenv("MONGODB_URI", "mongodb://localhost:27017"))
 database = client.products
 collection = database.products
+# Stock held for orders, one document per order keyed by its order_id
+reservations = database.inventory_reservations
 
 # Pydantic models
 class Product(BaseModel):
@@ -68,6 +71,8 @@ class Product(BaseModel):
     category: str = Field(..., min_length=1, max_length=50)
     inventory: int = Field(..., ge=0)
     sku: str = Field(..., min_length=1, max_length=50)
+    # Inactive products stay in the catalog but cannot be ordered
+    active: bool = True
 
 class ProductResponse(BaseModel):
     id: str
@@ -77,6 +82,7 @@ class ProductResponse(BaseModel):
     category: str
     inventory: int
     sku: str
+    active: bool = True
     created_at: datetime
     updated_at: datetime
 
@@ -86,6 +92,14 @@ class ProductUpdate(BaseModel):
     price: Optional[float] = Field(None, gt=0)
     category: Optional[str] = Field(None, min_length=1, max_length=50)
     inventory: Optional[int] = Field(None, ge=0)
+    active: Optional[bool] = None
+
+class ReservationItem(BaseModel):
+    product_id: str
+    quantity: int = Field(..., gt=0)
+
+class ReservationRequest(BaseModel):
+    items: List[ReservationItem]
 
 # Middleware for metrics
 @app.middleware("http")
@@ -117,6 +131,8 @@ def product_helper(product) -> dict:
         "category": product["category"],
         "inventory": product["inventory"],
         "sku": product["sku"],
+        # Products created before the flag existed are active
+        "active": product.get("active", True),
         "created_at": product["created_at"],
         "updated_at": product["updated_at"]
     }
@@ -298,6 +314,86 @@ async def search_products(q: str, skip: int = 0, limit: int = 50):
         logger.error("Error searching products", query=q, error=str(e))
         raise HTTPException(status_code=500, detail="Internal server error")
 
+def verify_internal_token(x_internal_token: Optional[str] = Header(None)):
+    # Service-to-service endpoints require X-Internal-Token to match
+    # INTERNAL_API_TOKEN; they are disabled without it
+    token = os.getenv("INTERNAL_API_TOKEN")
+    if not token or not x_internal_token or not hmac.compare_digest(x_internal_token.encode(), token.encode()):
+        raise HTTPException(status_code=403, detail="Forbidden")
+
+def reserved_quantities(items) -> dict:
+    quantities = {}
+    for item in items:
+        quantities[item.product_id] = quantities.get(item.product_id, 0) + item.quantity
+    return quantities
+
+# Internal: reserve stock for an order. The request states every item the
+# order needs; stock already held for the order is only adjusted by the
+# difference, so retries and item edits are safe. Either every item is
+# reserved or none is, and shortages are reported per product with 409.
+@app.put("/internal/reservations/{order_id}")
+async def reserve_stock(order_id: str, request: ReservationRequest, _=Depends(verify_internal_token)):
+    try:
+        wanted = reserved_quantities(request.items)
+        existing = await reservations.find_one({"_id": order_id})
+        held = existing["items"] if existing else {}
+
+        applied = []
+        shortages = []
+        for product_id in sorted(set(wanted) | set(held)):
+            delta = wanted.get(product_id, 0) - held.get(product_id, 0)
+            if delta == 0:
+                continue
+            if not ObjectId.is_valid(product_id):
+                shortages.append({"product_id": product_id, "requested": wanted.get(product_id, 0), "available": 0})
+                continue
+            query = {"_id": ObjectId(product_id)}
+            if delta > 0:
+                query["inventory"] = {"$gte": delta}
+            result = await collection.update_one(query, {"$inc": {"inventory": -delta}})
+            if result.modified_count == 0:
+                product = await collection.find_one({"_id": ObjectId(product_id)}, {"inventory": 1})
+                available = held.get(product_id, 0) + (product["inventory"] if product else 0)
+                shortages.append({"product_id": product_id, "requested": wanted.get(product_id, 0), "available": available})
+                continue
+            applied.append((product_id, delta))
+
+        if shortages:
+            for product_id, delta in applied:
+                await collection.update_one({"_id": ObjectId(product_id)}, {"$inc": {"inventory": delta}})
+            logger.info("Stock reservation rejected", order_id=order_id, shortages=len(shortages))
+            return JSONResponse(status_code=409, content={"detail": "Insufficient stock", "items": shortages})
+
+        if wanted:
+            await reservations.replace_one(
+                {"_id": order_id},
+                {"items": wanted, "updated_at": datetime.utcnow()},
+                upsert=True
+            )
+        else:
+            await reservations.delete_one({"_id": order_id})
+
+        logger.info("Stock reserved", order_id=order_id, products=len(wanted))
+        return {"order_id": order_id, "items": wanted}
+    except Exception as e:
+        logger.error("Error reserving stock", order_id=order_id, error=str(e))
+        raise HTTPException(status_code=500, detail="Internal server error")
+
+# Internal: return the stock held for an order, e.g. when it is cancelled.
+# Releasing an order with no reservation is a no-op.
+@app.delete("/internal/reservations/{order_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def release_stock(order_id: str, _=Depends(verify_internal_token)):
+    try:
+        reservation = await reservations.find_one_and_delete({"_id": order_id})
+        if reservation:
+            for product_id, quantity in reservation["items"].items():
+                await collection.update_one({"_id": ObjectId(product_id)}, {"$inc": {"inventory": quantity}})
+            logger.info("Stock released", order_id=order_id, products=len(reservation["items"]))
+        return Response(status_code=status.HTTP_204_NO_CONTENT)
+    except Exception as e:
+        logger.error("Error releasing stock", order_id=order_id, error=str(e))
+        raise HTTPException(status_code=500, detail="Internal server error")
+
 if __name__ == "__main__":
     uvicorn.run(
         "main:app",
@@ -8,6 +8,7 @@ const bcrypt = require('bcryptjs');
 const Joi = require('joi');
 const winston = require('winston');
 const client = require('prom-client');
+const crypto = require('crypto');
 require('dotenv').config();
 
 // Prometheus metrics
@@ -80,6 +81,29 @@ mongoose.connect(process.env.MONGODB_URI || 'mongodb://localhost:27017/users', {
   useUnifiedTopology: true,
 });
 
+// Order events users can be notified about, and the channels per event
+// enabled for new accounts. Quiet hours silence SMS and push, not email.
+const NOTIFICATION_EVENTS = ['order.created', 'order.status_changed'];
+const NOTIFICATION_CHANNELS = ['email', 'sms', 'push'];
+const DEFAULT_NOTIFICATION_PREFERENCES = {
+  email: ['order.created', 'order.status_changed'],
+  sms: [],
+  push: ['order.status_changed'],
+  quietHours: { enabled: false, start: '22:00', end: '07:00', timezone: 'UTC' }
+};
+
+const notificationPreferencesSchema = new mongoose.Schema({
+  email: { type: [String], default: () => [...DEFAULT_NOTIFICATION_PREFERENCES.email] },
+  sms: { type: [String], default: () => [...DEFAULT_NOTIFICATION_PREFERENCES.sms] },
+  push: { type: [String], default: () => [...DEFAULT_NOTIFICATION_PREFERENCES.push] },
+  quietHours: {
+    enabled: { type: Boolean, default: DEFAULT_NOTIFICATION_PREFERENCES.quietHours.enabled },
+    start: { type: String, default: DEFAULT_NOTIFICATION_PREFERENCES.quietHours.start },
+    end: { type: String, default: DEFAULT_NOTIFICATION_PREFERENCES.quietHours.end },
+    timezone: { type: String, default: DEFAULT_NOTIFICATION_PREFERENCES.quietHours.timezone }
+  }
+}, { _id: false });
+
 // User schema
 const userSchema = new mongoose.Schema({
   username: { type: String, required: true, unique: true },
@@ -87,6 +111,7 @@ const userSchema = new mongoose.Schema({
   password: { type: String, required: true },
   firstName: { type: String, required: true },
   lastName: { type: String, required: true },
+  notificationPreferences: { type: notificationPreferencesSchema, default: () => ({}) },
   createdAt: { type: Date, default: Date.now },
   updatedAt: { type: Date, default: Date.now }
 });
@@ -107,6 +132,30 @@ const loginSchema = Joi.object({
   password: Joi.string().required()
 });
 
+const isTimezone = (value, helpers) => {
+  try {
+    new Intl.DateTimeFormat('en-US', { timeZone: value });
+    return value;
+  } catch (e) {
+    return helpers.error('any.invalid');
+  }
+};
+
+const clockTime = Joi.string().pattern(/^([01]\d|2[0-3]):[0-5]\d$/).message('must be HH:MM');
+const channelEvents = Joi.array().items(Joi.string().valid(...NOTIFICATION_EVENTS)).unique();
+
+const updateNotificationsSchema = Joi.object({
+  email: channelEvents,
+  sms: channelEvents,
+  push: channelEvents,
+  quietHours: Joi.object({
+    enabled: Joi.boolean(),
+    start: clockTime,
+    end: clockTime,
+    timezone: Joi.string().custom(isTimezone, 'IANA timezone')
+  })
+}).min(1);
+
 // JWT middleware
 const authenticateToken = (req, res, next) => {
   const authHeader = req.headers['authorization'];
@@ -282,6 +331,85 @@ app.put('/api/users/profile', authenticateToken, async (req, res) => {
   }
 });
 
+// Get notification preferences
+app.get('/api/users/profile/notifications', authenticateToken, async (req, res) => {
+  try {
+    const user = await User.findById(req.user.userId).select('notificationPreferences');
+    if (!user) {
+      return res.status(404).json({ error: 'User not found' });
+    }
+
+    res.json(user.notificationPreferences);
+  } catch (error) {
+    logger.error('Notification preferences fetch error', { error: error.message });
+    res.status(500).json({ error: 'Internal server error' });
+  }
+});
+
+// Update notification preferences; omitted fields are left unchanged
+app.put('/api/users/profile/notifications', authenticateToken, async (req, res) => {
+  try {
+    const { error, value } = updateNotificationsSchema.validate(req.body);
+    if (error) {
+      return res.status(400).json({ error: error.details[0].message });
+    }
+
+    const updates = { updatedAt: new Date() };
+    for (const channel of NOTIFICATION_CHANNELS) {
+      if (value[channel] !== undefined) {
+        updates[`notificationPreferences.${channel}`] = value[channel];
+      }
+    }
+    for (const [key, setting] of Object.entries(value.quietHours || {})) {
+      updates[`notificationPreferences.quietHours.${key}`] = setting;
+    }
+
+    const user = await User.findByIdAndUpdate(
+      req.user.userId,
+      { $set: updates },
+      { new: true, runValidators: true }
+    ).select('notificationPreferences');
+
+    if (!user) {
+      return res.status(404).json({ error: 'User not found' });
+    }
+
+    logger.info('Notification preferences updated', { userId: user._id });
+
+    res.json(user.notificationPreferences);
+  } catch (error) {
+    logger.error('Notification preferences update error', { error: error.message });
+    res.status(500).json({ error: 'Internal server error' });
+  }
+});
+
+// Internal: notification preferences for the notification dispatcher.
+// Requires X-Internal-Token to match INTERNAL_API_TOKEN; disabled without it.
+app.get('/internal/users/:id/notification-preferences', async (req, res) => {
+  const token = process.env.INTERNAL_API_TOKEN;
+  const presented = Buffer.from(req.headers['x-internal-token'] || '');
+  if (!token || presented.length !== Buffer.byteLength(token) ||
+      !crypto.timingSafeEqual(presented, Buffer.from(token))) {
+    return res.status(403).json({ error: 'Forbidden' });
+  }
+
+  try {
+    if (!mongoose.Types.ObjectId.isValid(req.params.id)) {
+      return res.status(404).json({ error: 'User not found' });
+    }
+
+    const user = await User.findById(req.params.id).select('notificationPreferences');
+    if (!user) {
+      return res.status(404).json({ error: 'User not found' });
+    }
+
+    res.json(user.notificationPreferences);
+  } catch (error) {
+    logger.error('Internal notification preferences fetch error', { error: error.message });
+    res.status(500).json({ error: 'Internal server error' });
+  }
+});
+
 // Error handling middleware
 app.use((err, req, res, next) => {
   logger.error('Unhandled error', { error: err.message, stack: err.stack });